@@ -0,0 +1,109 @@
+package ipfscluster
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ipfs/ipfs-cluster/api"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// AlertBufferSize is the number of alerts kept for each subscriber of
+// a Cluster peer. Further alerts are dropped for subscribers which fall
+// this far behind, so that slow subscribers cannot block the peer.
+var AlertBufferSize = 100
+
+// alertBroker sends the alerts published by the components of a peer
+// to every subscriber.
+type alertBroker struct {
+	mu   sync.Mutex
+	subs map[chan api.Alert]struct{}
+}
+
+func newAlertBroker() *alertBroker {
+	return &alertBroker{
+		subs: make(map[chan api.Alert]struct{}),
+	}
+}
+
+// subscribe returns a channel receiving the published alerts and a
+// function which cancels the subscription. The channel is closed when
+// the subscription is cancelled or the broker is closed.
+func (ab *alertBroker) subscribe() (<-chan api.Alert, func()) {
+	ch := make(chan api.Alert, AlertBufferSize)
+
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+	if ab.subs == nil {
+		// closed already
+		close(ch)
+		return ch, func() {}
+	}
+	ab.subs[ch] = struct{}{}
+
+	cancel := func() {
+		ab.mu.Lock()
+		defer ab.mu.Unlock()
+		if _, ok := ab.subs[ch]; ok {
+			delete(ab.subs, ch)
+			close(ch)
+		}
+	}
+	return ch, cancel
+}
+
+// publish sends an alert to the subscribers which have room for it.
+func (ab *alertBroker) publish(a api.Alert) {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+	for ch := range ab.subs {
+		select {
+		case ch <- a:
+		default:
+			logger.Warningf("dropping %s alert for a slow subscriber", a.Code)
+		}
+	}
+}
+
+// close closes the channels of all the subscribers.
+func (ab *alertBroker) close() {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+	for ch := range ab.subs {
+		close(ch)
+	}
+	ab.subs = nil
+}
+
+// SubscribeAlerts returns a channel on which the alerts raised by this
+// peer are sent, i.e. changes of consensus leader, peers joining and
+// leaving the Cluster or Cids stuck in error, and a function which
+// cancels the subscription and must be called when done. Alerts are
+// dropped when the channel is not read fast enough. The channel is
+// closed when the subscription is cancelled or the peer shuts down.
+func (c *Cluster) SubscribeAlerts() (<-chan api.Alert, func()) {
+	return c.alerts.subscribe()
+}
+
+// Alerts returns a channel on which the alerts raised by this peer are
+// sent until it shuts down, when the channel is closed. Like with
+// SubscribeAlerts, alerts are dropped when the channel is not read fast
+// enough. Use SubscribeAlerts instead to stop receiving them earlier.
+func (c *Cluster) Alerts() <-chan api.Alert {
+	ch, _ := c.alerts.subscribe()
+	return ch
+}
+
+// alert sends an alert about the given peer (which may be empty) to
+// the subscribers.
+func (c *Cluster) alert(p peer.ID, severity, code, format string, args ...interface{}) {
+	c.alerts.publish(api.Alert{
+		Peer:     p,
+		Severity: severity,
+		Code:     code,
+		Message:  fmt.Sprintf(format, args...),
+		Time:     time.Now(),
+	})
+}
@@ -0,0 +1,56 @@
+package ipfscluster
+
+import (
+	"testing"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/test"
+)
+
+func TestAlertBroker(t *testing.T) {
+	c := &Cluster{alerts: newAlertBroker()}
+
+	ch1, cancel1 := c.SubscribeAlerts()
+	ch2, cancel2 := c.SubscribeAlerts()
+	defer cancel2()
+	all := c.Alerts()
+
+	pid := test.TestPeerID1
+	c.alert(pid, api.AlertInfo, api.AlertPeerAdded, "new Cluster peer %s", pid.Pretty())
+	for _, ch := range []<-chan api.Alert{ch1, ch2, all} {
+		a := <-ch
+		if a.Peer != pid || a.Code != api.AlertPeerAdded ||
+			a.Severity != api.AlertInfo || a.Time.IsZero() ||
+			a.Message != "new Cluster peer "+pid.Pretty() {
+			t.Errorf("unexpected alert: %+v", a)
+		}
+	}
+
+	cancel1()
+	if _, ok := <-ch1; ok {
+		t.Error("the channel should be closed when cancelled")
+	}
+	cancel1() // twice is fine
+
+	// Slow subscribers lose alerts rather than blocking
+	for i := 0; i < AlertBufferSize+1; i++ {
+		c.alert("", api.AlertError, api.AlertLeaderLost, "no leader")
+	}
+	if len(ch2) != AlertBufferSize {
+		t.Error("expected a full buffer:", len(ch2))
+	}
+
+	c.alerts.close()
+	n := 0
+	for range ch2 {
+		n++
+	}
+	if n != AlertBufferSize {
+		t.Error("buffered alerts should be received before the channel is closed")
+	}
+	for range all {
+	}
+	if _, ok := <-c.Alerts(); ok {
+		t.Error("subscribing after closing should return a closed channel")
+	}
+}
@@ -0,0 +1,97 @@
+// Package freespacealloc implements an ipfscluster.Allocator based on the
+// "freespace" Informer. It allocates pins to the peers with the most space
+// left in their IPFS repositories.
+package freespacealloc
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/informer/freespace"
+
+	rpc "github.com/hsanjuan/go-libp2p-gorpc"
+	cid "github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+var logger = logging.Logger("freespacealloc")
+
+// Allocator implements ipfscluster.Allocate.
+type Allocator struct{}
+
+// NewAllocator returns an initialized Allocator
+func NewAllocator() *Allocator {
+	return &Allocator{}
+}
+
+// SetClient does nothing in this allocator
+func (alloc *Allocator) SetClient(c *rpc.Client) {}
+
+// Shutdown does nothing in this allocator
+func (alloc *Allocator) Shutdown() error { return nil }
+
+// Allocate returns where to allocate a pin request based on "freespace"
+// Informer metrics. The metrics of the current allocations are ignored
+// and the candidates are sorted so that the peers with the most free
+// space come first.
+func (alloc *Allocator) Allocate(c *cid.Cid, current, candidates map[peer.ID]api.Metric) ([]peer.ID, error) {
+	// sort our metrics
+	freespace := newMetricsSorter(candidates)
+	sort.Sort(freespace)
+	return freespace.peers, nil
+}
+
+// metricsSorter attaches sort.Interface methods to our metrics and sorts
+// a slice of peers in the way that interest us
+type metricsSorter struct {
+	peers []peer.ID
+	m     map[peer.ID]uint64
+}
+
+func newMetricsSorter(m map[peer.ID]api.Metric) *metricsSorter {
+	vMap := make(map[peer.ID]uint64)
+	peers := make([]peer.ID, 0, len(m))
+	for k, v := range m {
+		if v.Name != freespace.MetricName || v.Discard() {
+			continue
+		}
+		val, err := strconv.ParseUint(v.Value, 10, 64)
+		if err != nil {
+			continue
+		}
+		peers = append(peers, k)
+		vMap[k] = val
+	}
+
+	sorter := &metricsSorter{
+		m:     vMap,
+		peers: peers,
+	}
+	return sorter
+}
+
+// Len returns the number of metrics
+func (s metricsSorter) Len() int {
+	return len(s.peers)
+}
+
+// Less reports if the element in position i has more free space than
+// the element in j, so that the peers with most free space come first
+func (s metricsSorter) Less(i, j int) bool {
+	peeri := s.peers[i]
+	peerj := s.peers[j]
+
+	x := s.m[peeri]
+	y := s.m[peerj]
+
+	return x > y
+}
+
+// Swap swaps the elements in positions i and j
+func (s metricsSorter) Swap(i, j int) {
+	temp := s.peers[i]
+	s.peers[i] = s.peers[j]
+	s.peers[j] = temp
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// Error is an error carrying a code, following the HTTP status codes,
+// which describes the kind of failure (i.e. 404 for items which are
+// not found, or 503 when there is no consensus leader).
+//
+// RPC only preserves the messages of errors, so the code is included
+// in the message. ErrorCode recovers it on the other side, even when
+// the message has been wrapped in other errors.
+type Error struct {
+	Code    int
+	Message string
+}
+
+// NewError returns an Error with the given code and a message built
+// like fmt.Sprintf does.
+func NewError(code int, format string, a ...interface{}) Error {
+	return Error{
+		Code:    code,
+		Message: fmt.Sprintf(format, a...),
+	}
+}
+
+// Error returns the message, followed by the code.
+func (e Error) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+var errorCodeRegexp = regexp.MustCompile(` \(code ([0-9]{3})\)$`)
+
+// ErrorCode returns the code carried by an error, or 0 when there is
+// none, along with the error message without the code.
+func ErrorCode(err error) (int, string) {
+	if e, ok := err.(Error); ok {
+		return e.Code, e.Message
+	}
+	msg := err.Error()
+	m := errorCodeRegexp.FindStringSubmatchIndex(msg)
+	if m == nil {
+		return 0, msg
+	}
+	code, _ := strconv.Atoi(msg[m[2]:m[3]])
+	return code, msg[:m[0]]
+}
@@ -0,0 +1,33 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestErrorCode(t *testing.T) {
+	err := NewError(404, "%s is not pinned", "abc")
+	if err.Error() != "abc is not pinned (code 404)" {
+		t.Error("unexpected message:", err)
+	}
+
+	testcases := []struct {
+		err  error
+		code int
+		msg  string
+	}{
+		{err, 404, "abc is not pinned"},
+		// errors which crossed the RPC boundary
+		{errors.New(err.Error()), 404, "abc is not pinned"},
+		{fmt.Errorf("waiting: %s", err), 404, "waiting: abc is not pinned"},
+		{errors.New("plain error"), 0, "plain error"},
+		{errors.New("a (code 12345)"), 0, "a (code 12345)"},
+	}
+	for _, tc := range testcases {
+		code, msg := ErrorCode(tc.err)
+		if code != tc.code || msg != tc.msg {
+			t.Errorf("%q: got %d and %q", tc.err, code, msg)
+		}
+	}
+}
@@ -6,9 +6,14 @@
 //
 // Note that all conversion methods ignore any parsing errors. All values must
 // be validated first before initializing any of the types defined here.
+//
+// Serializable types use snake_case JSON keys. Lists are always present
+// (empty rather than null), while error messages, zero timestamps and
+// optional flags are omitted when empty.
 package api
 
 import (
+	"fmt"
 	"time"
 
 	cid "github.com/ipfs/go-cid"
@@ -105,23 +110,125 @@ func (ips IPFSPinStatus) IsPinned() bool {
 	return ips == IPFSPinStatusDirect || ips == IPFSPinStatusRecursive
 }
 
+// IsPinnedAs returns true if an item with this status does not need to
+// be pinned again with the given PinType. Recursive pins include the
+// block itself, so they satisfy direct pins too.
+func (ips IPFSPinStatus) IsPinnedAs(pt PinType) bool {
+	if pt == PinTypeDirect {
+		return ips.IsPinned()
+	}
+	return ips == IPFSPinStatusRecursive
+}
+
+// PinType values
+const (
+	// PinTypeRecursive pins a Cid along with all its children. It is
+	// the default.
+	PinTypeRecursive PinType = iota
+	// PinTypeDirect pins only the block of the Cid.
+	PinTypeDirect
+)
+
+// PinType sets how the IPFS daemons pin a Cid.
+type PinType int
+
+// String returns the name used by IPFS for the PinType.
+func (pt PinType) String() string {
+	switch pt {
+	case PinTypeDirect:
+		return "direct"
+	default:
+		return "recursive"
+	}
+}
+
+// PinTypeFromString parses a string and returns the matching PinType.
+// An empty string is the default PinTypeRecursive.
+func PinTypeFromString(t string) (PinType, error) {
+	switch t {
+	case "", "recursive":
+		return PinTypeRecursive, nil
+	case "direct":
+		return PinTypeDirect, nil
+	default:
+		return PinTypeRecursive, fmt.Errorf("unknown pin type %q: expected recursive or direct", t)
+	}
+}
+
+// IPFSRepoStat wraps information about the IPFS repository, as
+// reported by the "repo/stat" endpoint.
+type IPFSRepoStat struct {
+	RepoSize   uint64 `json:"repo_size"`
+	StorageMax uint64 `json:"storage_max"`
+}
+
+// FreeSpace returns the number of bytes which can still be used in
+// the repository, or 0 when it is full.
+func (rs IPFSRepoStat) FreeSpace() uint64 {
+	if rs.RepoSize >= rs.StorageMax {
+		return 0
+	}
+	return rs.StorageMax - rs.RepoSize
+}
+
+// IPFSBandwidth wraps the bandwidth usage of the IPFS daemon, as
+// reported by the "stats/bw" endpoint. Totals are in bytes and rates
+// in bytes per second.
+type IPFSBandwidth struct {
+	TotalIn  uint64  `json:"total_in"`
+	TotalOut uint64  `json:"total_out"`
+	RateIn   float64 `json:"rate_in"`
+	RateOut  float64 `json:"rate_out"`
+	Error    string  `json:"error,omitempty"`
+}
+
 // GlobalPinInfo contains cluster-wide status information about a tracked Cid,
 // indexed by cluster peer.
 type GlobalPinInfo struct {
 	Cid     *cid.Cid
 	PeerMap map[peer.ID]PinInfo
+	// UnderReplicated is set when the Cid is allocated to fewer
+	// peers than the minimum replication factor requires.
+	UnderReplicated bool
+	// ReplicationFactorMin and ReplicationFactorMax are the range
+	// of replicas wanted for the Cid (-1 when pinned everywhere) and
+	// Replicas is the number of peers which have it pinned, which
+	// tells where in that range the Cid currently is.
+	ReplicationFactorMin int
+	ReplicationFactorMax int
+	Replicas             int
+	// Name, Metadata and Namespace of the pin in the shared state
+	Name      string
+	Metadata  map[string]string
+	Namespace string
 }
 
 // GlobalPinInfoSerial is the serializable version of GlobalPinInfo.
 type GlobalPinInfoSerial struct {
-	Cid     string                   `json:"cid"`
-	PeerMap map[string]PinInfoSerial `json:"peer_map"`
+	Cid             string                   `json:"cid"`
+	PeerMap         map[string]PinInfoSerial `json:"peer_map"`
+	UnderReplicated bool                     `json:"under_replicated,omitempty"`
+
+	ReplicationFactorMin int `json:"replication_factor_min,omitempty"`
+	ReplicationFactorMax int `json:"replication_factor_max,omitempty"`
+	Replicas             int `json:"replicas"`
+
+	Name      string            `json:"name,omitempty"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	Namespace string            `json:"namespace,omitempty"`
 }
 
 // ToSerial converts a GlobalPinInfo to its serializable version.
 func (gpi GlobalPinInfo) ToSerial() GlobalPinInfoSerial {
 	s := GlobalPinInfoSerial{}
 	s.Cid = gpi.Cid.String()
+	s.UnderReplicated = gpi.UnderReplicated
+	s.ReplicationFactorMin = gpi.ReplicationFactorMin
+	s.ReplicationFactorMax = gpi.ReplicationFactorMax
+	s.Replicas = gpi.Replicas
+	s.Name = gpi.Name
+	s.Metadata = gpi.Metadata
+	s.Namespace = gpi.Namespace
 	s.PeerMap = make(map[string]PinInfoSerial)
 	for k, v := range gpi.PeerMap {
 		s.PeerMap[peer.IDB58Encode(k)] = v.ToSerial()
@@ -133,8 +240,17 @@ func (gpi GlobalPinInfo) ToSerial() GlobalPinInfoSerial {
 func (gpis GlobalPinInfoSerial) ToGlobalPinInfo() GlobalPinInfo {
 	c, _ := cid.Decode(gpis.Cid)
 	gpi := GlobalPinInfo{
-		Cid:     c,
-		PeerMap: make(map[peer.ID]PinInfo),
+		Cid:             c,
+		PeerMap:         make(map[peer.ID]PinInfo),
+		UnderReplicated: gpis.UnderReplicated,
+
+		ReplicationFactorMin: gpis.ReplicationFactorMin,
+		ReplicationFactorMax: gpis.ReplicationFactorMax,
+		Replicas:             gpis.Replicas,
+
+		Name:      gpis.Name,
+		Metadata:  gpis.Metadata,
+		Namespace: gpis.Namespace,
 	}
 	for k, v := range gpis.PeerMap {
 		p, _ := peer.IDB58Decode(k)
@@ -152,6 +268,12 @@ type PinInfo struct {
 	Status TrackerStatus
 	TS     time.Time
 	Error  string
+	// Attempts counts the failed attempts of the current operation
+	// when the PinTracker retries them.
+	Attempts int
+	// Progress is the number of blocks fetched so far, while the
+	// status is pinning, when IPFS reports it.
+	Progress uint64
 }
 
 // PinInfoSerial is a serializable version of PinInfo.
@@ -160,18 +282,29 @@ type PinInfoSerial struct {
 	Cid    string `json:"cid"`
 	Peer   string `json:"peer"`
 	Status string `json:"status"`
-	TS     string `json:"timestamp"`
-	Error  string `json:"error"`
+	TS     string `json:"timestamp,omitempty"`
+	Error  string `json:"error,omitempty"`
+
+	Attempts int    `json:"attempts,omitempty"`
+	Progress uint64 `json:"progress,omitempty"`
 }
 
 // ToSerial converts a PinInfo to its serializable version.
 func (pi PinInfo) ToSerial() PinInfoSerial {
+	var ts string
+	if !pi.TS.IsZero() {
+		ts = pi.TS.UTC().Format(time.RFC1123)
+	}
+
 	return PinInfoSerial{
 		Cid:    pi.Cid.String(),
 		Peer:   peer.IDB58Encode(pi.Peer),
 		Status: pi.Status.String(),
-		TS:     pi.TS.UTC().Format(time.RFC1123),
+		TS:     ts,
 		Error:  pi.Error,
+
+		Attempts: pi.Attempts,
+		Progress: pi.Progress,
 	}
 }
 
@@ -186,12 +319,626 @@ func (pis PinInfoSerial) ToPinInfo() PinInfo {
 		Status: TrackerStatusFromString(pis.Status),
 		TS:     ts,
 		Error:  pis.Error,
+
+		Attempts: pis.Attempts,
+		Progress: pis.Progress,
+	}
+}
+
+// TrackerLoad describes how busy a PinTracker is. It allows to estimate
+// how long a new pin will wait before being processed.
+type TrackerLoad struct {
+	QueueLength      int           `json:"queue_length"`
+	QueueCapacity    int           `json:"queue_capacity"`
+	UnpinQueueLength int           `json:"unpin_queue_length"`
+	AvgPinDuration   time.Duration `json:"avg_pin_duration"`
+}
+
+// FillRatio returns the fraction of the pin queue which is in use.
+func (l TrackerLoad) FillRatio() float64 {
+	if l.QueueCapacity <= 0 {
+		return 0
+	}
+	return float64(l.QueueLength) / float64(l.QueueCapacity)
+}
+
+// EstimatedWait returns how long it would take to process the items
+// currently in the pin queue, based on the average pin duration.
+func (l TrackerLoad) EstimatedWait() time.Duration {
+	return time.Duration(l.QueueLength) * l.AvgPinDuration
+}
+
+// QueueInfo describes the occupancy of the pin and unpin queues of a
+// PinTracker.
+type QueueInfo struct {
+	PinQueueLength     int `json:"pin_queue_length"`
+	PinQueueCapacity   int `json:"pin_queue_capacity"`
+	UnpinQueueLength   int `json:"unpin_queue_length"`
+	UnpinQueueCapacity int `json:"unpin_queue_capacity"`
+}
+
+// Summary is an overview of the cluster as seen by a peer. It is cheap
+// to obtain, so that it can be polled.
+type Summary struct {
+	// Pins in the shared state
+	Pins int `json:"pins"`
+	// Number of items tracked by the peer, by status
+	Status map[string]int `json:"status"`
+	Peers  int            `json:"peers"`
+	// Empty when there is no leader
+	Leader  string `json:"leader"`
+	Version string `json:"version"`
+}
+
+// Health reports conditions which affect the ability of a peer to
+// work normally, such as running out of disk space.
+type Health struct {
+	// Free space, in bytes, in the consensus data folder
+	DiskFree uint64 `json:"disk_free"`
+	// Free space below which LowDiskSpace is set (0 when disabled)
+	DiskThreshold uint64 `json:"disk_threshold"`
+	LowDiskSpace  bool   `json:"low_disk_space"`
+	// ReadOnly is set when the peer is rejecting new pins
+	ReadOnly bool `json:"read_only"`
+	// Occupancy of the pin queue. New pins allocated to the peer
+	// are rejected while it is full.
+	PinQueueLength   int    `json:"pin_queue_length"`
+	PinQueueCapacity int    `json:"pin_queue_capacity"`
+	Error            string `json:"error,omitempty"`
+}
+
+// PageRequest asks for the items sorted after a given one, up to Limit
+// of them. After is empty for the first page.
+type PageRequest struct {
+	After string `json:"after"`
+	Limit int    `json:"limit"`
+}
+
+// StatusPage is a page of the global status of the pins, sorted by
+// Cid. Next is the Cid to request the following page after, and it is
+// empty when there are no more pages.
+type StatusPage struct {
+	Items []GlobalPinInfo
+	Next  string
+}
+
+// StatusPageSerial is the serializable version of StatusPage.
+type StatusPageSerial struct {
+	Items []GlobalPinInfoSerial `json:"items"`
+	Next  string                `json:"next,omitempty"`
+}
+
+// ToSerial converts a StatusPage to its serializable version.
+func (sp StatusPage) ToSerial() StatusPageSerial {
+	s := StatusPageSerial{
+		Items: make([]GlobalPinInfoSerial, len(sp.Items), len(sp.Items)),
+		Next:  sp.Next,
+	}
+	for i, gpi := range sp.Items {
+		s.Items[i] = gpi.ToSerial()
+	}
+	return s
+}
+
+// ToStatusPage converts a StatusPageSerial to its native version.
+func (sps StatusPageSerial) ToStatusPage() StatusPage {
+	sp := StatusPage{
+		Items: make([]GlobalPinInfo, len(sps.Items), len(sps.Items)),
+		Next:  sps.Next,
+	}
+	for i, gpis := range sps.Items {
+		sp.Items[i] = gpis.ToGlobalPinInfo()
+	}
+	return sp
+}
+
+// StatusChangesRequest asks for the changes in the global status of
+// the pins since Token was obtained. When Scoped, only the changes of
+// the pins under Namespace are returned, including removals.
+type StatusChangesRequest struct {
+	Token     string
+	Namespace string
+	Scoped    bool
+}
+
+// StatusChanges holds the changes in the global status of the pins
+// since a previous StatusChanges was obtained. Token identifies this
+// set of changes and is used to obtain the next ones. Tokens are
+// opaque and only valid on the peer which issued them.
+type StatusChanges struct {
+	Token   string
+	Changed []GlobalPinInfo
+	Removed []*cid.Cid
+}
+
+// StatusChangesSerial is the serializable version of StatusChanges.
+type StatusChangesSerial struct {
+	Token   string                `json:"token"`
+	Changed []GlobalPinInfoSerial `json:"changed"`
+	Removed []string              `json:"removed"`
+}
+
+// ToSerial converts a StatusChanges to its serializable version.
+func (sc StatusChanges) ToSerial() StatusChangesSerial {
+	s := StatusChangesSerial{
+		Token:   sc.Token,
+		Changed: make([]GlobalPinInfoSerial, len(sc.Changed), len(sc.Changed)),
+		Removed: make([]string, len(sc.Removed), len(sc.Removed)),
+	}
+	for i, gpi := range sc.Changed {
+		s.Changed[i] = gpi.ToSerial()
+	}
+	for i, c := range sc.Removed {
+		s.Removed[i] = c.String()
+	}
+	return s
+}
+
+// ToStatusChanges converts a StatusChangesSerial to its native version.
+func (scs StatusChangesSerial) ToStatusChanges() StatusChanges {
+	sc := StatusChanges{
+		Token:   scs.Token,
+		Changed: make([]GlobalPinInfo, len(scs.Changed), len(scs.Changed)),
+		Removed: make([]*cid.Cid, len(scs.Removed), len(scs.Removed)),
+	}
+	for i, gpis := range scs.Changed {
+		sc.Changed[i] = gpis.ToGlobalPinInfo()
+	}
+	for i, cs := range scs.Removed {
+		sc.Removed[i], _ = cid.Decode(cs)
 	}
+	return sc
 }
 
-// Version holds version information
+// PinAck tells whether a cluster peer has accepted to track a pin,
+// that is, whether pinning will be attempted on that peer. A pin may
+// be rejected, for example, when the pin queue is full.
+type PinAck struct {
+	Peer     peer.ID
+	Accepted bool
+	Error    string
+}
+
+// PinAckSerial is the serializable version of PinAck.
+type PinAckSerial struct {
+	Peer     string `json:"peer"`
+	Accepted bool   `json:"accepted"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ToSerial converts a PinAck to its serializable version.
+func (ack PinAck) ToSerial() PinAckSerial {
+	return PinAckSerial{
+		Peer:     peer.IDB58Encode(ack.Peer),
+		Accepted: ack.Accepted,
+		Error:    ack.Error,
+	}
+}
+
+// ToPinAck converts a PinAckSerial to its native version.
+func (acks PinAckSerial) ToPinAck() PinAck {
+	p, _ := peer.IDB58Decode(acks.Peer)
+	return PinAck{
+		Peer:     p,
+		Accepted: acks.Accepted,
+		Error:    acks.Error,
+	}
+}
+
+// PinResult is the outcome of pinning one of the Cids in a batch.
+// Index is the log index of the committed pin when there is no Error.
+type PinResult struct {
+	Cid   *cid.Cid
+	Index uint64
+	Error string
+}
+
+// PinResultSerial is the serializable version of PinResult.
+type PinResultSerial struct {
+	Cid   string `json:"cid"`
+	Index uint64 `json:"index,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// ToSerial converts a PinResult to its serializable version.
+func (pr PinResult) ToSerial() PinResultSerial {
+	var c string
+	if pr.Cid != nil {
+		c = pr.Cid.String()
+	}
+	return PinResultSerial{
+		Cid:   c,
+		Index: pr.Index,
+		Error: pr.Error,
+	}
+}
+
+// PinPathSerial is a request to pin the Cid an IPFS path resolves to,
+// with the options in CidArg, whose Cid is ignored.
+type PinPathSerial struct {
+	Path   string       `json:"path"`
+	CidArg CidArgSerial `json:"cid_arg"`
+}
+
+// ToPinResult converts a PinResultSerial to its native version.
+func (prs PinResultSerial) ToPinResult() PinResult {
+	c, _ := cid.Decode(prs.Cid)
+	return PinResult{
+		Cid:   c,
+		Index: prs.Index,
+		Error: prs.Error,
+	}
+}
+
+// VerifyResult tells whether a cluster peer holds all the blocks of
+// a pinned Cid, that is, whether its whole DAG could be read.
+type VerifyResult struct {
+	Peer  peer.ID
+	Valid bool
+	Error string
+}
+
+// VerifyResultSerial is the serializable version of VerifyResult.
+type VerifyResultSerial struct {
+	Peer  string `json:"peer"`
+	Valid bool   `json:"valid"`
+	Error string `json:"error,omitempty"`
+}
+
+// ToSerial converts a VerifyResult to its serializable version.
+func (vr VerifyResult) ToSerial() VerifyResultSerial {
+	return VerifyResultSerial{
+		Peer:  peer.IDB58Encode(vr.Peer),
+		Valid: vr.Valid,
+		Error: vr.Error,
+	}
+}
+
+// ToVerifyResult converts a VerifyResultSerial to its native version.
+func (vrs VerifyResultSerial) ToVerifyResult() VerifyResult {
+	p, _ := peer.IDB58Decode(vrs.Peer)
+	return VerifyResult{
+		Peer:  p,
+		Valid: vrs.Valid,
+		Error: vrs.Error,
+	}
+}
+
+// ConsensusView is what a cluster peer sees of the consensus: the
+// cluster peers, the leader and a summary of the shared state.
+type ConsensusView struct {
+	Peer          peer.ID
+	ClusterPeers  []peer.ID
+	Leader        peer.ID
+	PinCount      int
+	StateChecksum string
+	Error         string
+}
+
+// ConsensusViewSerial is the serializable version of ConsensusView.
+type ConsensusViewSerial struct {
+	Peer          string   `json:"peer"`
+	ClusterPeers  []string `json:"cluster_peers"`
+	Leader        string   `json:"leader,omitempty"`
+	PinCount      int      `json:"pin_count"`
+	StateChecksum string   `json:"state_checksum,omitempty"`
+	Error         string   `json:"error,omitempty"`
+}
+
+// ToSerial converts a ConsensusView to its serializable version.
+func (cv ConsensusView) ToSerial() ConsensusViewSerial {
+	peers := make([]string, len(cv.ClusterPeers), len(cv.ClusterPeers))
+	for i, p := range cv.ClusterPeers {
+		peers[i] = peer.IDB58Encode(p)
+	}
+	var leader string
+	if cv.Leader != "" {
+		leader = peer.IDB58Encode(cv.Leader)
+	}
+	return ConsensusViewSerial{
+		Peer:          peer.IDB58Encode(cv.Peer),
+		ClusterPeers:  peers,
+		Leader:        leader,
+		PinCount:      cv.PinCount,
+		StateChecksum: cv.StateChecksum,
+		Error:         cv.Error,
+	}
+}
+
+// ToConsensusView converts a ConsensusViewSerial to its native version.
+func (cvs ConsensusViewSerial) ToConsensusView() ConsensusView {
+	p, _ := peer.IDB58Decode(cvs.Peer)
+	peers := make([]peer.ID, len(cvs.ClusterPeers), len(cvs.ClusterPeers))
+	for i, ps := range cvs.ClusterPeers {
+		peers[i], _ = peer.IDB58Decode(ps)
+	}
+	var leader peer.ID
+	if cvs.Leader != "" {
+		leader, _ = peer.IDB58Decode(cvs.Leader)
+	}
+	return ConsensusView{
+		Peer:          p,
+		ClusterPeers:  peers,
+		Leader:        leader,
+		PinCount:      cvs.PinCount,
+		StateChecksum: cvs.StateChecksum,
+		Error:         cvs.Error,
+	}
+}
+
+// ConsistencyReport collects the ConsensusView of every cluster peer
+// and lists the disagreements between them. Consistent is false
+// when any peer disagrees or could not be contacted.
+type ConsistencyReport struct {
+	Consistent    bool
+	Views         []ConsensusView
+	Disagreements []string
+}
+
+// ConsistencyReportSerial is the serializable version of
+// ConsistencyReport.
+type ConsistencyReportSerial struct {
+	Consistent    bool                  `json:"consistent"`
+	Views         []ConsensusViewSerial `json:"views"`
+	Disagreements []string              `json:"disagreements,omitempty"`
+}
+
+// ToSerial converts a ConsistencyReport to its serializable version.
+func (cr ConsistencyReport) ToSerial() ConsistencyReportSerial {
+	views := make([]ConsensusViewSerial, len(cr.Views), len(cr.Views))
+	for i, v := range cr.Views {
+		views[i] = v.ToSerial()
+	}
+	return ConsistencyReportSerial{
+		Consistent:    cr.Consistent,
+		Views:         views,
+		Disagreements: cr.Disagreements,
+	}
+}
+
+// ToConsistencyReport converts a ConsistencyReportSerial to its
+// native version.
+func (crs ConsistencyReportSerial) ToConsistencyReport() ConsistencyReport {
+	views := make([]ConsensusView, len(crs.Views), len(crs.Views))
+	for i, v := range crs.Views {
+		views[i] = v.ToConsensusView()
+	}
+	return ConsistencyReport{
+		Consistent:    crs.Consistent,
+		Views:         views,
+		Disagreements: crs.Disagreements,
+	}
+}
+
+// PeerReplacement phases
+const (
+	PeerReplacementAdding   = "adding"
+	PeerReplacementPinning  = "pinning"
+	PeerReplacementRemoving = "removing"
+	PeerReplacementDone     = "done"
+	PeerReplacementFailed   = "failed"
+)
+
+// PeerReplacement describes the progress of replacing a cluster peer
+// with a new one which takes over its pins. Total is the number of
+// pins to be migrated, Pinned how many of them the new peer has
+// pinned already (or were unpinned meanwhile) and Failed how many it
+// could not pin, which are re-allocated when the old peer is removed.
+type PeerReplacement struct {
+	OldPeer  peer.ID
+	NewPeer  peer.ID
+	Phase    string
+	Total    int
+	Pinned   int
+	Failed   int
+	Started  time.Time
+	Finished time.Time
+	Error    string
+}
+
+// PeerReplacementSerial is the serializable version of PeerReplacement.
+type PeerReplacementSerial struct {
+	OldPeer  string `json:"old_peer"`
+	NewPeer  string `json:"new_peer"`
+	Phase    string `json:"phase"`
+	Total    int    `json:"total"`
+	Pinned   int    `json:"pinned"`
+	Failed   int    `json:"failed,omitempty"`
+	Started  string `json:"started,omitempty"`
+	Finished string `json:"finished,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ToSerial converts a PeerReplacement to its serializable version.
+func (pr PeerReplacement) ToSerial() PeerReplacementSerial {
+	var oldPeer, newPeer, started, finished string
+	if pr.OldPeer != "" {
+		oldPeer = peer.IDB58Encode(pr.OldPeer)
+	}
+	if pr.NewPeer != "" {
+		newPeer = peer.IDB58Encode(pr.NewPeer)
+	}
+	if !pr.Started.IsZero() {
+		started = pr.Started.UTC().Format(time.RFC1123)
+	}
+	if !pr.Finished.IsZero() {
+		finished = pr.Finished.UTC().Format(time.RFC1123)
+	}
+	return PeerReplacementSerial{
+		OldPeer:  oldPeer,
+		NewPeer:  newPeer,
+		Phase:    pr.Phase,
+		Total:    pr.Total,
+		Pinned:   pr.Pinned,
+		Failed:   pr.Failed,
+		Started:  started,
+		Finished: finished,
+		Error:    pr.Error,
+	}
+}
+
+// ToPeerReplacement converts a PeerReplacementSerial to its native
+// version.
+func (prs PeerReplacementSerial) ToPeerReplacement() PeerReplacement {
+	oldPeer, _ := peer.IDB58Decode(prs.OldPeer)
+	newPeer, _ := peer.IDB58Decode(prs.NewPeer)
+	started, _ := time.Parse(time.RFC1123, prs.Started)
+	finished, _ := time.Parse(time.RFC1123, prs.Finished)
+	return PeerReplacement{
+		OldPeer:  oldPeer,
+		NewPeer:  newPeer,
+		Phase:    prs.Phase,
+		Total:    prs.Total,
+		Pinned:   prs.Pinned,
+		Failed:   prs.Failed,
+		Started:  started,
+		Finished: finished,
+		Error:    prs.Error,
+	}
+}
+
+// Reconciliation actions
+const (
+	// ReconcilePin pins an item allocated to a peer which does not
+	// have it pinned.
+	ReconcilePin = "pin"
+	// ReconcileUnpin removes a pin from the IPFS daemon of a peer
+	// which should not hold it.
+	ReconcileUnpin = "unpin"
+	// ReconcileUntrack stops tracking an item which is no longer
+	// part of the shared state.
+	ReconcileUntrack = "untrack"
+	// ReconcileReallocate allocates an item to new peers.
+	ReconcileReallocate = "reallocate"
+)
+
+// ReconcileAction is an action that reconciliation would take to make
+// the actual status of a peer match the shared state. Reallocations
+// do not refer to any peer.
+type ReconcileAction struct {
+	Action string
+	Cid    *cid.Cid
+	Peer   peer.ID
+	Reason string
+}
+
+// ReconcileActionSerial is the serializable version of ReconcileAction.
+type ReconcileActionSerial struct {
+	Action string `json:"action"`
+	Cid    string `json:"cid"`
+	Peer   string `json:"peer,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// ToSerial converts a ReconcileAction to its serializable version.
+func (ra ReconcileAction) ToSerial() ReconcileActionSerial {
+	var p string
+	if ra.Peer != "" {
+		p = peer.IDB58Encode(ra.Peer)
+	}
+	return ReconcileActionSerial{
+		Action: ra.Action,
+		Cid:    ra.Cid.String(),
+		Peer:   p,
+		Reason: ra.Reason,
+	}
+}
+
+// ToReconcileAction converts a ReconcileActionSerial to its native
+// version.
+func (ras ReconcileActionSerial) ToReconcileAction() ReconcileAction {
+	c, _ := cid.Decode(ras.Cid)
+	p, _ := peer.IDB58Decode(ras.Peer)
+	return ReconcileAction{
+		Action: ras.Action,
+		Cid:    c,
+		Peer:   p,
+		Reason: ras.Reason,
+	}
+}
+
+// ReconcilePlan lists the actions that reconciliation would take.
+// Errors holds the problems contacting peers, whose actions may be
+// missing from the plan.
+type ReconcilePlan struct {
+	Actions []ReconcileAction
+	Errors  []string
+}
+
+// ReconcilePlanSerial is the serializable version of ReconcilePlan.
+type ReconcilePlanSerial struct {
+	Actions []ReconcileActionSerial `json:"actions"`
+	Errors  []string                `json:"errors,omitempty"`
+}
+
+// ToSerial converts a ReconcilePlan to its serializable version.
+func (rp ReconcilePlan) ToSerial() ReconcilePlanSerial {
+	actions := make([]ReconcileActionSerial, len(rp.Actions), len(rp.Actions))
+	for i, a := range rp.Actions {
+		actions[i] = a.ToSerial()
+	}
+	return ReconcilePlanSerial{
+		Actions: actions,
+		Errors:  rp.Errors,
+	}
+}
+
+// ToReconcilePlan converts a ReconcilePlanSerial to its native version.
+func (rps ReconcilePlanSerial) ToReconcilePlan() ReconcilePlan {
+	actions := make([]ReconcileAction, len(rps.Actions), len(rps.Actions))
+	for i, a := range rps.Actions {
+		actions[i] = a.ToReconcileAction()
+	}
+	return ReconcilePlan{
+		Actions: actions,
+		Errors:  rps.Errors,
+	}
+}
+
+// RaftVoter is the suffrage of Raft servers which vote in elections
+// and count towards the quorum.
+const RaftVoter = "voter"
+
+// RaftServer is a member of the Raft configuration.
+type RaftServer struct {
+	ID       peer.ID
+	Suffrage string
+	Leader   bool
+}
+
+// RaftServerSerial is the serializable version of RaftServer.
+type RaftServerSerial struct {
+	ID       string `json:"id"`
+	Suffrage string `json:"suffrage"`
+	Leader   bool   `json:"leader,omitempty"`
+}
+
+// ToSerial converts a RaftServer to its serializable version.
+func (rs RaftServer) ToSerial() RaftServerSerial {
+	return RaftServerSerial{
+		ID:       peer.IDB58Encode(rs.ID),
+		Suffrage: rs.Suffrage,
+		Leader:   rs.Leader,
+	}
+}
+
+// ToRaftServer converts a RaftServerSerial to its native version.
+func (rss RaftServerSerial) ToRaftServer() RaftServer {
+	id, _ := peer.IDB58Decode(rss.ID)
+	return RaftServer{
+		ID:       id,
+		Suffrage: rss.Suffrage,
+		Leader:   rss.Leader,
+	}
+}
+
+// Version holds version information. Peers can only talk to each
+// other when they use the same RPC protocol version.
 type Version struct {
-	Version string `json:"Version"`
+	Version            string `json:"version"`
+	Commit             string `json:"commit,omitempty"`
+	RPCProtocolVersion string `json:"rpc_protocol_version"`
+	GoVersion          string `json:"go_version"`
 }
 
 // IPFSID is used to store information about the underlying IPFS daemon
@@ -205,7 +952,7 @@ type IPFSID struct {
 type IPFSIDSerial struct {
 	ID        string           `json:"id"`
 	Addresses MultiaddrsSerial `json:"addresses"`
-	Error     string           `json:"error"`
+	Error     string           `json:"error,omitempty"`
 }
 
 // ToSerial converts IPFSID to a go serializable object
@@ -238,6 +985,11 @@ type ID struct {
 	RPCProtocolVersion protocol.ID
 	Error              string
 	IPFS               IPFSID
+	// Time is the peer's clock when the ID was generated.
+	Time time.Time
+	// ClockSkew is the estimated difference between the peer's clock
+	// and the clock of the peer which requested the ID.
+	ClockSkew time.Duration
 	//PublicKey          crypto.PubKey
 }
 
@@ -249,8 +1001,10 @@ type IDSerial struct {
 	Version            string           `json:"version"`
 	Commit             string           `json:"commit"`
 	RPCProtocolVersion string           `json:"rpc_protocol_version"`
-	Error              string           `json:"error"`
+	Error              string           `json:"error,omitempty"`
 	IPFS               IPFSIDSerial     `json:"ipfs"`
+	Time               string           `json:"time,omitempty"`
+	ClockSkew          string           `json:"clock_skew,omitempty"`
 	//PublicKey          []byte
 }
 
@@ -261,6 +1015,16 @@ func (id ID) ToSerial() IDSerial {
 	//	pkey, _ = id.PublicKey.Bytes()
 	//}
 
+	var skew string
+	if id.ClockSkew != 0 {
+		skew = id.ClockSkew.String()
+	}
+
+	var t string
+	if !id.Time.IsZero() {
+		t = id.Time.UTC().Format(time.RFC3339Nano)
+	}
+
 	return IDSerial{
 		ID: peer.IDB58Encode(id.ID),
 		//PublicKey:          pkey,
@@ -271,6 +1035,8 @@ func (id ID) ToSerial() IDSerial {
 		RPCProtocolVersion: string(id.RPCProtocolVersion),
 		Error:              id.Error,
 		IPFS:               id.IPFS.ToSerial(),
+		Time:               t,
+		ClockSkew:          skew,
 	}
 }
 
@@ -292,6 +1058,8 @@ func (ids IDSerial) ToID() ID {
 	id.RPCProtocolVersion = protocol.ID(ids.RPCProtocolVersion)
 	id.Error = ids.Error
 	id.IPFS = ids.IPFS.ToIPFSID()
+	id.Time, _ = time.Parse(time.RFC3339Nano, ids.Time)
+	id.ClockSkew, _ = time.ParseDuration(ids.ClockSkew)
 	return id
 }
 
@@ -332,12 +1100,67 @@ func (addrsS MultiaddrsSerial) ToMultiaddrs() []ma.Multiaddr {
 	return addrs
 }
 
+// DefaultNamespace is the namespace of pins which do not specify one.
+const DefaultNamespace = ""
+
 // CidArg is an arguments that carry a Cid. It may carry more things in the
 // future.
 type CidArg struct {
 	Cid         *cid.Cid
 	Allocations []peer.ID
 	Everywhere  bool
+	// NoFetch indicates that the content is expected to be already
+	// present in the IPFS daemons. They pin it without fetching any
+	// block, and the pin fails if a block is missing.
+	NoFetch bool
+	// Namespace labels pins, i.e. per tenant or application, and
+	// scopes the operations on them. A Cid belongs to a single
+	// namespace at a time. Namespaces are not access control.
+	Namespace string
+	// UnderReplicated is set by the Cluster when there were not
+	// enough peers to satisfy the minimum replication factor.
+	UnderReplicated bool
+	// UserAllocations is set by the Cluster when the Allocations
+	// were given when pinning instead of being chosen by the
+	// allocator. They are not changed by automatic re-allocations.
+	UserAllocations bool
+	// ReplicationFactorMin and ReplicationFactorMax set the range of
+	// peers that the Cid should be allocated to. The Cluster
+	// allocates as many peers as possible within the range. When
+	// both are 0, the configured ReplicationFactor is used for both.
+	// -1 pins the Cid everywhere.
+	ReplicationFactorMin int
+	ReplicationFactorMax int
+	// Protected pins cannot be unpinned or evicted until they
+	// are explicitly unprotected.
+	Protected bool
+	// PinTimeout is how long the Cid may stay in Pinning state
+	// before the operation is considered failed. When 0, the
+	// global PinningTimeout is used.
+	PinTimeout time.Duration
+	// Name is a human-readable name for the pin and Metadata holds
+	// arbitrary tags. Both are stored in the shared state so that
+	// pins can be found by them later.
+	Name     string
+	Metadata map[string]string
+	// Type sets whether the IPFS daemons pin the Cid recursively,
+	// which is the default, or only its block.
+	Type PinType
+}
+
+// AllocatedTo returns true if the given peer is expected to pin the
+// Cid, either because it is part of the Allocations or because the
+// Cid is pinned everywhere.
+func (carg CidArg) AllocatedTo(p peer.ID) bool {
+	if carg.Everywhere {
+		return true
+	}
+	for _, a := range carg.Allocations {
+		if a == p {
+			return true
+		}
+	}
+	return false
 }
 
 // CidArgCid is a shorcut to create a CidArg only with a Cid.
@@ -352,6 +1175,23 @@ type CidArgSerial struct {
 	Cid         string   `json:"cid"`
 	Allocations []string `json:"allocations"`
 	Everywhere  bool     `json:"everywhere"`
+	NoFetch     bool     `json:"no_fetch,omitempty"`
+	Namespace   string   `json:"namespace,omitempty"`
+
+	UnderReplicated bool `json:"under_replicated,omitempty"`
+	UserAllocations bool `json:"user_allocations,omitempty"`
+
+	ReplicationFactorMin int `json:"replication_factor_min,omitempty"`
+	ReplicationFactorMax int `json:"replication_factor_max,omitempty"`
+
+	Protected bool `json:"protected,omitempty"`
+
+	PinTimeout string `json:"pin_timeout,omitempty"`
+
+	Name     string            `json:"name,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	Type string `json:"type,omitempty"`
 }
 
 // ToSerial converts a CidArg to CidArgSerial.
@@ -361,11 +1201,38 @@ func (carg CidArg) ToSerial() CidArgSerial {
 	for i, p := range carg.Allocations {
 		allocs[i] = peer.IDB58Encode(p)
 	}
+	var timeout string
+	if carg.PinTimeout > 0 {
+		timeout = carg.PinTimeout.String()
+	}
+	// The default type is left out so that it does not change the
+	// serialized pins and snapshots.
+	var pinType string
+	if carg.Type != PinTypeRecursive {
+		pinType = carg.Type.String()
+	}
 
 	return CidArgSerial{
 		Cid:         carg.Cid.String(),
 		Allocations: allocs,
 		Everywhere:  carg.Everywhere,
+		NoFetch:     carg.NoFetch,
+		Namespace:   carg.Namespace,
+
+		UnderReplicated: carg.UnderReplicated,
+		UserAllocations: carg.UserAllocations,
+
+		ReplicationFactorMin: carg.ReplicationFactorMin,
+		ReplicationFactorMax: carg.ReplicationFactorMax,
+
+		Protected: carg.Protected,
+
+		PinTimeout: timeout,
+
+		Name:     carg.Name,
+		Metadata: carg.Metadata,
+
+		Type: pinType,
 	}
 }
 
@@ -377,13 +1244,39 @@ func (cargs CidArgSerial) ToCidArg() CidArg {
 	for i, p := range cargs.Allocations {
 		allocs[i], _ = peer.IDB58Decode(p)
 	}
+	timeout, _ := time.ParseDuration(cargs.PinTimeout)
+	pinType, _ := PinTypeFromString(cargs.Type)
 	return CidArg{
 		Cid:         c,
 		Allocations: allocs,
 		Everywhere:  cargs.Everywhere,
+		NoFetch:     cargs.NoFetch,
+		Namespace:   cargs.Namespace,
+
+		UnderReplicated: cargs.UnderReplicated,
+		UserAllocations: cargs.UserAllocations,
+
+		ReplicationFactorMin: cargs.ReplicationFactorMin,
+		ReplicationFactorMax: cargs.ReplicationFactorMax,
+
+		Protected: cargs.Protected,
+
+		PinTimeout: timeout,
+
+		Name:     cargs.Name,
+		Metadata: cargs.Metadata,
+
+		Type: pinType,
 	}
 }
 
+// MetadataFilter selects the pins with the given Value for a
+// metadata Key.
+type MetadataFilter struct {
+	Key   string
+	Value string
+}
+
 // Metric transports information about a peer.ID. It is used to decide
 // pin allocations by a PinAllocator. IPFS cluster is agnostic to
 // the Value, which should be interpreted by the PinAllocator.
@@ -418,8 +1311,192 @@ func (m *Metric) Discard() bool {
 	return !m.Valid || m.Expired()
 }
 
-// Alert carries alerting information about a peer. WIP.
+// MetricSerial is the serializable version of Metric.
+type MetricSerial struct {
+	Name   string `json:"name"`
+	Peer   string `json:"peer"`
+	Value  string `json:"value"`
+	Expire string `json:"expire"`
+	Valid  bool   `json:"valid"`
+}
+
+// ToSerial converts a Metric to its serializable version.
+func (m Metric) ToSerial() MetricSerial {
+	return MetricSerial{
+		Name:   m.Name,
+		Peer:   peer.IDB58Encode(m.Peer),
+		Value:  m.Value,
+		Expire: m.Expire,
+		Valid:  m.Valid,
+	}
+}
+
+// ToMetric converts a MetricSerial to its native form.
+func (ms MetricSerial) ToMetric() Metric {
+	p, _ := peer.IDB58Decode(ms.Peer)
+	return Metric{
+		Name:   ms.Name,
+		Peer:   p,
+		Value:  ms.Value,
+		Expire: ms.Expire,
+		Valid:  ms.Valid,
+	}
+}
+
+// AllocationPreview shows how a Cid would be allocated with the
+// metrics currently known to the Cluster, without pinning it.
+type AllocationPreview struct {
+	Cid                  *cid.Cid
+	ReplicationFactorMin int
+	ReplicationFactorMax int
+	// MetricName is the metric used by the PinAllocator
+	MetricName string
+	// Current holds the metrics of the peers which the Cid is
+	// already allocated to. Those allocations are kept.
+	Current []Metric
+	// Candidates holds the metrics of the other peers, in the order
+	// of preference given by the PinAllocator.
+	Candidates []Metric
+	// Allocations are the candidates which would be allocated.
+	Allocations []peer.ID
+	// Error is set when the metrics do not allow to allocate the
+	// Cid, i.e. because there are not enough healthy peers.
+	Error string
+}
+
+// AllocationPreviewSerial is the serializable version of
+// AllocationPreview.
+type AllocationPreviewSerial struct {
+	Cid                  string         `json:"cid"`
+	ReplicationFactorMin int            `json:"replication_factor_min"`
+	ReplicationFactorMax int            `json:"replication_factor_max"`
+	MetricName           string         `json:"metric_name"`
+	Current              []MetricSerial `json:"current"`
+	Candidates           []MetricSerial `json:"candidates"`
+	Allocations          []string       `json:"allocations"`
+	Error                string         `json:"error,omitempty"`
+}
+
+// ToSerial converts an AllocationPreview to its serializable version.
+func (ap AllocationPreview) ToSerial() AllocationPreviewSerial {
+	var c string
+	if ap.Cid != nil {
+		c = ap.Cid.String()
+	}
+	s := AllocationPreviewSerial{
+		Cid:                  c,
+		ReplicationFactorMin: ap.ReplicationFactorMin,
+		ReplicationFactorMax: ap.ReplicationFactorMax,
+		MetricName:           ap.MetricName,
+		Current:              make([]MetricSerial, len(ap.Current)),
+		Candidates:           make([]MetricSerial, len(ap.Candidates)),
+		Allocations:          make([]string, len(ap.Allocations)),
+		Error:                ap.Error,
+	}
+	for i, m := range ap.Current {
+		s.Current[i] = m.ToSerial()
+	}
+	for i, m := range ap.Candidates {
+		s.Candidates[i] = m.ToSerial()
+	}
+	for i, p := range ap.Allocations {
+		s.Allocations[i] = peer.IDB58Encode(p)
+	}
+	return s
+}
+
+// ToAllocationPreview converts an AllocationPreviewSerial to its
+// native form.
+func (aps AllocationPreviewSerial) ToAllocationPreview() AllocationPreview {
+	c, _ := cid.Decode(aps.Cid)
+	ap := AllocationPreview{
+		Cid:                  c,
+		ReplicationFactorMin: aps.ReplicationFactorMin,
+		ReplicationFactorMax: aps.ReplicationFactorMax,
+		MetricName:           aps.MetricName,
+		Current:              make([]Metric, len(aps.Current)),
+		Candidates:           make([]Metric, len(aps.Candidates)),
+		Allocations:          make([]peer.ID, len(aps.Allocations)),
+		Error:                aps.Error,
+	}
+	for i, m := range aps.Current {
+		ap.Current[i] = m.ToMetric()
+	}
+	for i, m := range aps.Candidates {
+		ap.Candidates[i] = m.ToMetric()
+	}
+	for i, p := range aps.Allocations {
+		ap.Allocations[i], _ = peer.IDB58Decode(p)
+	}
+	return ap
+}
+
+// Alert severities
+const (
+	AlertInfo    = "info"
+	AlertWarning = "warning"
+	AlertError   = "error"
+)
+
+// Alert codes
+const (
+	AlertPeerAdded     = "peer_added"
+	AlertPeerRemoved   = "peer_removed"
+	AlertLeaderChanged = "leader_changed"
+	AlertLeaderLost    = "leader_lost"
+	AlertPinStuck      = "pin_stuck"
+)
+
+// Alert carries information about something operators may need to
+// know, like changes in the membership of the Cluster. Peer is the peer
+// the alert is about, if any. The PeerMonitor also fills MetricName for
+// alerts about missing metrics.
 type Alert struct {
 	Peer       peer.ID
 	MetricName string
+	Severity   string
+	Code       string
+	Message    string
+	Time       time.Time
+}
+
+// AlertSerial is a serializable version of Alert.
+type AlertSerial struct {
+	Peer       string `json:"peer,omitempty"`
+	MetricName string `json:"metric_name,omitempty"`
+	Severity   string `json:"severity"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	Time       string `json:"time"`
+}
+
+// ToSerial converts an Alert to its serializable version.
+func (a Alert) ToSerial() AlertSerial {
+	var p string
+	if a.Peer != "" {
+		p = peer.IDB58Encode(a.Peer)
+	}
+	return AlertSerial{
+		Peer:       p,
+		MetricName: a.MetricName,
+		Severity:   a.Severity,
+		Code:       a.Code,
+		Message:    a.Message,
+		Time:       a.Time.UTC().Format(time.RFC3339Nano),
+	}
+}
+
+// ToAlert converts an AlertSerial to an Alert.
+// It will ignore any errors when parsing the fields.
+func (as AlertSerial) ToAlert() Alert {
+	p, _ := peer.IDB58Decode(as.Peer)
+	t, _ := time.Parse(time.RFC3339Nano, as.Time)
+	return Alert{
+		Peer:       p,
+		MetricName: as.MetricName,
+		Severity:   as.Severity,
+		Code:       as.Code,
+		Message:    as.Message,
+		Time:       t,
+	}
 }
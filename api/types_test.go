@@ -1,6 +1,7 @@
 package api
 
 import (
+	"encoding/json"
 	"testing"
 	"time"
 
@@ -85,6 +86,8 @@ func TestIDConv(t *testing.T) {
 			Addresses: []ma.Multiaddr{testMAddr},
 			Error:     "abc",
 		},
+		Time:      time.Now(),
+		ClockSkew: 3 * time.Second,
 	}
 
 	newid := id.ToSerial().ToID()
@@ -104,7 +107,9 @@ func TestIDConv(t *testing.T) {
 	if id.Version != newid.Version ||
 		id.Commit != newid.Commit ||
 		id.RPCProtocolVersion != newid.RPCProtocolVersion ||
-		id.Error != newid.Error {
+		id.Error != newid.Error ||
+		!id.Time.Equal(newid.Time) ||
+		id.ClockSkew != newid.ClockSkew {
 		t.Error("some field didn't survive")
 	}
 
@@ -144,16 +149,71 @@ func TestCidArgConv(t *testing.T) {
 		Cid:         testCid1,
 		Allocations: []peer.ID{testPeerID1},
 		Everywhere:  true,
+		NoFetch:     true,
+		Namespace:   "ns",
+
+		UserAllocations: true,
+
+		ReplicationFactorMin: 2,
+		ReplicationFactorMax: 3,
+		Protected:            true,
+		PinTimeout:           90 * time.Minute,
+		Name:                 "backup",
+		Metadata:             map[string]string{"owner": "alice"},
+		Type:                 PinTypeDirect,
 	}
 
 	newc := c.ToSerial().ToCidArg()
 	if c.Cid.String() != newc.Cid.String() ||
 		c.Allocations[0] != newc.Allocations[0] ||
-		c.Everywhere != newc.Everywhere {
+		c.Everywhere != newc.Everywhere ||
+		c.NoFetch != newc.NoFetch ||
+		c.Namespace != newc.Namespace ||
+		c.UserAllocations != newc.UserAllocations ||
+		c.ReplicationFactorMin != newc.ReplicationFactorMin ||
+		c.ReplicationFactorMax != newc.ReplicationFactorMax ||
+		c.Protected != newc.Protected ||
+		c.PinTimeout != newc.PinTimeout ||
+		c.Name != newc.Name ||
+		newc.Metadata["owner"] != "alice" ||
+		c.Type != newc.Type {
 		t.Error("mismatch")
 	}
 }
 
+func TestPinType(t *testing.T) {
+	if pt, err := PinTypeFromString(""); err != nil || pt != PinTypeRecursive {
+		t.Error("the default pin type should be recursive")
+	}
+	if pt, err := PinTypeFromString(PinTypeDirect.String()); err != nil || pt != PinTypeDirect {
+		t.Error("expected a direct pin type")
+	}
+	if _, err := PinTypeFromString("indirect"); err == nil {
+		t.Error("expected an error for an unknown pin type")
+	}
+
+	if !IPFSPinStatus(IPFSPinStatusRecursive).IsPinnedAs(PinTypeDirect) {
+		t.Error("recursive pins should satisfy direct ones")
+	}
+	if IPFSPinStatus(IPFSPinStatusDirect).IsPinnedAs(PinTypeRecursive) {
+		t.Error("direct pins should not satisfy recursive ones")
+	}
+}
+
+func TestCidArgAllocatedTo(t *testing.T) {
+	c := CidArg{
+		Cid:         testCid1,
+		Allocations: []peer.ID{testPeerID1},
+	}
+	if !c.AllocatedTo(testPeerID1) || c.AllocatedTo(testPeerID2) {
+		t.Error("unexpected allocation check result")
+	}
+	c.Everywhere = true
+	if !c.AllocatedTo(testPeerID2) {
+		t.Error("everywhere pins are allocated to all peers")
+	}
+}
+
 func TestMetric(t *testing.T) {
 	m := Metric{
 		Name:  "hello",
@@ -193,3 +253,148 @@ func TestMetric(t *testing.T) {
 		t.Error("looks like a bad ttl")
 	}
 }
+
+func TestTrackerLoad(t *testing.T) {
+	l := TrackerLoad{}
+	if l.FillRatio() != 0 {
+		t.Error("empty load should have a 0 fill ratio")
+	}
+
+	l = TrackerLoad{
+		QueueLength:    3,
+		QueueCapacity:  4,
+		AvgPinDuration: 2 * time.Second,
+	}
+	if l.FillRatio() != 0.75 {
+		t.Error("bad fill ratio")
+	}
+	if l.EstimatedWait() != 6*time.Second {
+		t.Error("bad estimated wait")
+	}
+}
+
+func TestPinAckConv(t *testing.T) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatal("paniced")
+		}
+	}()
+
+	ack := PinAck{
+		Peer:  testPeerID1,
+		Error: "pin queue is full",
+	}
+	newack := ack.ToSerial().ToPinAck()
+	if newack.Peer != ack.Peer ||
+		newack.Accepted ||
+		newack.Error != ack.Error {
+		t.Error("mismatch")
+	}
+}
+
+func TestPinResultConv(t *testing.T) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatal("paniced")
+		}
+	}()
+
+	pr := PinResult{
+		Cid:   testCid1,
+		Index: 3,
+	}
+	newpr := pr.ToSerial().ToPinResult()
+	if newpr.Cid.String() != pr.Cid.String() ||
+		newpr.Index != pr.Index ||
+		newpr.Error != "" {
+		t.Error("mismatch")
+	}
+
+	if (PinResult{Error: "bad cid"}).ToSerial().Cid != "" {
+		t.Error("a missing Cid should serialize as empty")
+	}
+}
+
+func TestAlertConv(t *testing.T) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatal("paniced")
+		}
+	}()
+
+	a := Alert{
+		Peer:     testPeerID1,
+		Severity: AlertWarning,
+		Code:     AlertPeerRemoved,
+		Message:  "peer removed",
+		Time:     testTime,
+	}
+	newa := a.ToSerial().ToAlert()
+	if newa.Peer != a.Peer ||
+		newa.Severity != a.Severity ||
+		newa.Code != a.Code ||
+		newa.Message != a.Message ||
+		!newa.Time.Equal(a.Time) {
+		t.Error("mismatch")
+	}
+
+	if (Alert{Code: AlertLeaderLost}).ToSerial().Peer != "" {
+		t.Error("a missing Peer should serialize as empty")
+	}
+}
+
+func TestSerialWireFormat(t *testing.T) {
+	c := testCid1
+
+	testcases := []struct {
+		name     string
+		obj      interface{}
+		expected string
+	}{
+		{
+			"pin info without error nor timestamp",
+			PinInfo{Cid: c, Peer: testPeerID1, Status: TrackerStatusPinning}.ToSerial(),
+			`{"cid":"QmP63DkAFEnDYNjDYBpyNDfttu1fvUw99x1brscPzpqmmq","peer":"QmXZrtE5jQwXNqCJMfHUTQkvhQ4ZAnqMnmzFMJfLewuabc","status":"pinning"}`,
+		},
+		{
+			"pin info with error",
+			PinInfo{Cid: c, Peer: testPeerID1, Status: TrackerStatusPinError, Error: "oops"}.ToSerial(),
+			`{"cid":"QmP63DkAFEnDYNjDYBpyNDfttu1fvUw99x1brscPzpqmmq","peer":"QmXZrtE5jQwXNqCJMfHUTQkvhQ4ZAnqMnmzFMJfLewuabc","status":"pin_error","error":"oops"}`,
+		},
+		{
+			"cid arg pinned everywhere",
+			CidArg{Cid: c, Everywhere: true}.ToSerial(),
+			`{"cid":"QmP63DkAFEnDYNjDYBpyNDfttu1fvUw99x1brscPzpqmmq","allocations":[],"everywhere":true}`,
+		},
+		{
+			"cid arg with options",
+			CidArg{Cid: c, Allocations: []peer.ID{testPeerID1}, NoFetch: true, Namespace: "ns"}.ToSerial(),
+			`{"cid":"QmP63DkAFEnDYNjDYBpyNDfttu1fvUw99x1brscPzpqmmq","allocations":["QmXZrtE5jQwXNqCJMfHUTQkvhQ4ZAnqMnmzFMJfLewuabc"],"everywhere":false,"no_fetch":true,"namespace":"ns"}`,
+		},
+		{
+			"ipfs id without error",
+			(&IPFSID{ID: testPeerID1}).ToSerial(),
+			`{"id":"QmXZrtE5jQwXNqCJMfHUTQkvhQ4ZAnqMnmzFMJfLewuabc","addresses":[]}`,
+		},
+		{
+			"pin ack",
+			PinAck{Peer: testPeerID1, Accepted: true}.ToSerial(),
+			`{"peer":"QmXZrtE5jQwXNqCJMfHUTQkvhQ4ZAnqMnmzFMJfLewuabc","accepted":true}`,
+		},
+		{
+			"version",
+			Version{Version: "0.0.1", RPCProtocolVersion: "/ipfscluster/0.0.1/rpc", GoVersion: "go1.8"},
+			`{"version":"0.0.1","rpc_protocol_version":"/ipfscluster/0.0.1/rpc","go_version":"go1.8"}`,
+		},
+	}
+
+	for _, tc := range testcases {
+		j, err := json.Marshal(tc.obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(j) != tc.expected {
+			t.Errorf("%s: unexpected wire format:\n%s\nexpected:\n%s", tc.name, j, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package ipfscluster
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ipfs/ipfs-cluster/api"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// accessTimesFile is the name of the file, in the consensus data
+// folder, where every peer keeps its access times across restarts.
+const accessTimesFile = "access_times.json"
+
+// accessLog keeps the last time that content was accessed through this
+// peer. It is used to decide which pins to evict when the Cluster runs
+// with the LRU eviction policy. Access times are local to every peer
+// and gathered by the leader when needed, so recording an access does
+// not need to go through consensus. They are saved to a file from time
+// to time (see save), rather than on every access.
+type accessLog struct {
+	mux   sync.RWMutex
+	times map[string]time.Time
+	path  string
+	dirty bool
+}
+
+// newAccessLog returns an accessLog saved to the given path, loading
+// the access times saved there before. An empty path keeps them only
+// in memory.
+func newAccessLog(path string) *accessLog {
+	al := &accessLog{
+		times: make(map[string]time.Time),
+		path:  path,
+	}
+	if err := al.load(); err != nil {
+		logger.Errorf("error loading access times: %s", err)
+	}
+	return al
+}
+
+func accessTimesPath(cfg *Config) string {
+	if cfg.ConsensusDataFolder == "" {
+		return ""
+	}
+	return filepath.Join(cfg.ConsensusDataFolder, accessTimesFile)
+}
+
+func (al *accessLog) touch(c *cid.Cid) {
+	al.mux.Lock()
+	defer al.mux.Unlock()
+	al.times[c.String()] = time.Now()
+	al.dirty = true
+}
+
+func (al *accessLog) forget(c *cid.Cid) {
+	al.mux.Lock()
+	defer al.mux.Unlock()
+	delete(al.times, c.String())
+	al.dirty = true
+}
+
+// load reads the access times written by save. A missing file is not
+// an error.
+func (al *accessLog) load() error {
+	if al.path == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(al.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	al.mux.Lock()
+	defer al.mux.Unlock()
+	return json.Unmarshal(b, &al.times)
+}
+
+// save writes the access times to the file, replacing it atomically,
+// when they have changed since the last time.
+func (al *accessLog) save() {
+	al.mux.Lock()
+	defer al.mux.Unlock()
+	if al.path == "" || !al.dirty {
+		return
+	}
+
+	b, err := json.Marshal(al.times)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(al.path), 0700)
+	}
+	if err == nil {
+		tmp := al.path + ".tmp"
+		err = ioutil.WriteFile(tmp, b, 0600)
+		if err == nil {
+			err = os.Rename(tmp, al.path)
+		}
+	}
+	if err != nil {
+		logger.Errorf("error saving access times: %s", err)
+		return
+	}
+	al.dirty = false
+}
+
+func (al *accessLog) list() map[string]time.Time {
+	al.mux.RLock()
+	defer al.mux.RUnlock()
+	times := make(map[string]time.Time, len(al.times))
+	for k, v := range al.times {
+		times[k] = v
+	}
+	return times
+}
+
+// Touch records that the given Cid has been accessed through this peer.
+// Recently accessed pins are the last ones to be evicted when the
+// Cluster runs with the LRU eviction policy.
+func (c *Cluster) Touch(h *cid.Cid) {
+	c.accessLog.touch(h)
+}
+
+// AccessTimes returns the last time that each Cid was accessed through
+// this peer. Cids which have not been accessed are not included.
+func (c *Cluster) AccessTimes() map[string]time.Time {
+	return c.accessLog.list()
+}
+
+// errAccessTimesUnknown is returned by globalAccessTimes when some peer
+// cannot tell its access times.
+var errAccessTimesUnknown = errors.New("the access times of some peers are unknown")
+
+// globalAccessTimes gathers the access times from all peers, keeping
+// the most recent access for every Cid. It fails if any peer cannot be
+// contacted, as the pins accessed through it would look unused.
+func (c *Cluster) globalAccessTimes() (map[string]time.Time, error) {
+	members := c.peerManager.peers()
+	replies := make([]map[string]time.Time, len(members), len(members))
+	ifaces := make([]interface{}, len(members), len(members))
+	for i := range replies {
+		ifaces[i] = &replies[i]
+	}
+
+	errs := c.multiRPC(c.ctx, members, "Cluster", "AccessTimes", struct{}{}, ifaces)
+
+	times := make(map[string]time.Time)
+	var err error
+	for i, r := range replies {
+		if errs[i] != nil {
+			logger.Warningf("error getting access times from %s: %s",
+				members[i].Pretty(), errs[i])
+			err = errAccessTimesUnknown
+			continue
+		}
+		for k, t := range r {
+			if t.After(times[k]) {
+				times[k] = t
+			}
+		}
+	}
+	return times, err
+}
+
+// evict unpins items when the number of pins is over the CacheCapacity
+// and an eviction policy other than EvictionPolicyNone is used. Only
+// the leader performs evictions, so that all peers do not commit the
+// same unpins. Nothing is evicted while the access times of any peer
+// are unknown, i.e. when it is down, so that content which is in use
+// is not taken for unused.
+func (c *Cluster) evict() {
+	capacity := c.config.CacheCapacity
+	if c.config.EvictionPolicy != EvictionPolicyLRU || capacity <= 0 {
+		return
+	}
+
+	leader, err := c.consensus.Leader()
+	if err != nil || leader != c.id {
+		return
+	}
+
+	cState, err := c.consensus.State()
+	if err != nil {
+		return
+	}
+
+	pins := cState.List()
+	if len(pins) <= capacity {
+		return
+	}
+
+	times, err := c.globalAccessTimes()
+	if err != nil {
+		logger.Warningf("cache capacity (%d) exceeded but not evicting: %s",
+			capacity, err)
+		return
+	}
+	victims := lruVictims(pins, times, capacity)
+	logger.Infof("cache capacity (%d) exceeded: evicting %d pins",
+		capacity, len(victims))
+	for _, carg := range victims {
+		_, err := c.consensus.LogUnpin(api.CidArg{
+			Cid:       carg.Cid,
+			Namespace: carg.Namespace,
+		})
+		if err != nil {
+			logger.Errorf("error evicting %s: %s", carg.Cid, err)
+			continue
+		}
+		logger.Infof("evicted %s", carg.Cid)
+	}
+}
+
+// lruVictims returns the pins which need to be removed so that only
+// capacity pins remain, starting by the least recently accessed ones.
+// Pins without access time are considered the oldest. Protected pins
+// are never evicted, so fewer pins may be returned when they fill the
+// capacity.
+func lruVictims(pins []api.CidArg, times map[string]time.Time, capacity int) []api.CidArg {
+	if len(pins) <= capacity {
+		return nil
+	}
+	candidates := make([]api.CidArg, 0, len(pins))
+	for _, carg := range pins {
+		if !carg.Protected {
+			candidates = append(candidates, carg)
+		}
+	}
+	sort.Sort(byAccessTime{candidates, times})
+	n := len(pins) - capacity
+	if n > len(candidates) {
+		logger.Warningf("cache capacity (%d) exceeded by protected pins", capacity)
+		n = len(candidates)
+	}
+	return candidates[:n]
+}
+
+// byAccessTime sorts CidArgs from least to most recently accessed.
+// Ties are sorted by Cid so that all peers agree on the order.
+type byAccessTime struct {
+	pins  []api.CidArg
+	times map[string]time.Time
+}
+
+func (s byAccessTime) Len() int      { return len(s.pins) }
+func (s byAccessTime) Swap(i, j int) { s.pins[i], s.pins[j] = s.pins[j], s.pins[i] }
+func (s byAccessTime) Less(i, j int) bool {
+	ci := s.pins[i].Cid.String()
+	cj := s.pins[j].Cid.String()
+	ti := s.times[ci]
+	tj := s.times[cj]
+	if ti.Equal(tj) {
+		return ci < cj
+	}
+	return ti.Before(tj)
+}
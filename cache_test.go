@@ -0,0 +1,126 @@
+package ipfscluster
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/test"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+func TestAccessLog(t *testing.T) {
+	al := newAccessLog("")
+	c1, _ := cid.Decode(test.TestCid1)
+	c2, _ := cid.Decode(test.TestCid2)
+
+	al.touch(c1)
+	al.touch(c2)
+	al.forget(c2)
+
+	times := al.list()
+	if len(times) != 1 {
+		t.Fatal("expected 1 access time")
+	}
+	if times[test.TestCid1].IsZero() {
+		t.Error("expected an access time for the touched cid")
+	}
+}
+
+func TestAccessLogPersist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "accesslog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, accessTimesFile)
+
+	al := newAccessLog(path)
+	c1, _ := cid.Decode(test.TestCid1)
+	al.touch(c1)
+	al.save()
+	touched := al.list()[test.TestCid1]
+
+	al2 := newAccessLog(path)
+	if tm := al2.list()[test.TestCid1]; !tm.Equal(touched) {
+		t.Errorf("expected the saved access time %s, got %s", touched, tm)
+	}
+
+	// nothing changed, so nothing is written
+	os.Remove(path)
+	al2.save()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("the access times should only be saved when changed")
+	}
+}
+
+func TestLRUVictims(t *testing.T) {
+	c1, _ := cid.Decode(test.TestCid1)
+	c2, _ := cid.Decode(test.TestCid2)
+	c3, _ := cid.Decode(test.TestCid3)
+	pins := []api.CidArg{
+		api.CidArgCid(c1),
+		api.CidArgCid(c2),
+		api.CidArgCid(c3),
+	}
+
+	now := time.Now()
+	// c2 has never been accessed
+	times := map[string]time.Time{
+		test.TestCid1: now,
+		test.TestCid3: now.Add(-time.Minute),
+	}
+
+	testcases := []struct {
+		capacity int
+		expected []string
+	}{
+		{3, nil},
+		{5, nil},
+		{2, []string{test.TestCid2}},
+		{1, []string{test.TestCid2, test.TestCid3}},
+		{0, []string{test.TestCid2, test.TestCid3, test.TestCid1}},
+	}
+
+	for _, tc := range testcases {
+		victims := lruVictims(pins, times, tc.capacity)
+		if len(victims) != len(tc.expected) {
+			t.Errorf("capacity %d: expected %d victims, got %d",
+				tc.capacity, len(tc.expected), len(victims))
+			continue
+		}
+		for i, v := range victims {
+			if v.Cid.String() != tc.expected[i] {
+				t.Errorf("capacity %d: expected %s in position %d, got %s",
+					tc.capacity, tc.expected[i], i, v.Cid)
+			}
+		}
+	}
+}
+
+func TestLRUVictimsProtected(t *testing.T) {
+	c1, _ := cid.Decode(test.TestCid1)
+	c2, _ := cid.Decode(test.TestCid2)
+	pins := []api.CidArg{
+		api.CidArgCid(c1),
+		{Cid: c2, Protected: true},
+	}
+
+	// c2 is the oldest, but it is protected
+	times := map[string]time.Time{
+		test.TestCid1: time.Now(),
+	}
+	victims := lruVictims(pins, times, 1)
+	if len(victims) != 1 || victims[0].Cid.String() != test.TestCid1 {
+		t.Error("protected pins should not be evicted:", victims)
+	}
+
+	victims = lruVictims(pins, times, 0)
+	if len(victims) != 1 {
+		t.Error("only unprotected pins should be evicted:", victims)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -19,6 +21,33 @@ import (
 	ma "github.com/multiformats/go-multiaddr"
 )
 
+// ReallocateTimeout specifies how long Reallocate waits for the peers
+// to apply a new allocation before returning the current status.
+var ReallocateTimeout = 5 * time.Second
+
+// PinAckTimeout is how long a peer waits for a new pin to reach its
+// PinTracker before reporting that it has not accepted it.
+var PinAckTimeout = 2 * time.Second
+
+// PeerAddDialTimeout is how long PeerAdd waits to connect to the new
+// peer before failing, so that unreachable peers are not committed to
+// the consensus.
+var PeerAddDialTimeout = 5 * time.Second
+
+// LeaveTimeout is how long Leave waits for the rest of the Cluster to
+// apply the removal of the peer and have a leader among themselves.
+var LeaveTimeout = 30 * time.Second
+
+// ClockSkewThreshold is the estimated clock difference with another
+// peer above which a warning is logged. Timeouts and metric expiration
+// rely on peers having reasonably synchronized clocks.
+var ClockSkewThreshold = 5 * time.Second
+
+// ExpiredPeersCacheTTL is how long the peers whose metrics have
+// expired, as obtained from the leader, are reused when asking every
+// peer for the status of the pins.
+var ExpiredPeersCacheTTL = 5 * time.Second
+
 // Cluster is the main IPFS cluster component. It provides
 // the go-API for it and orchestrates the components that make up the system.
 type Cluster struct {
@@ -40,19 +69,47 @@ type Cluster struct {
 	monitor   PeerMonitor
 	allocator PinAllocator
 	informer  Informer
+	accessLog *accessLog
+	diskSpace *diskSpace
+	// serves /metrics when enabled (may be nil)
+	metricsServer *metricsServer
+	alerts        *alertBroker
+	// long operations started over RPC, which may be cancelled
+	ops *opContexts
 
 	shutdownLock sync.Mutex
 	shutdown     bool
+	left         bool
 	doneCh       chan struct{}
 	readyCh      chan struct{}
 	wg           sync.WaitGroup
 
 	paMux sync.Mutex
+
+	// Cids removed with ForceUnpin and the peers which
+	// have not confirmed their removal yet
+	pendingRemovals map[string][]peer.ID
+	removalsMux     sync.Mutex
+
+	statusVersions *statusVersions
+
+	// limits concurrent VerifyLocal operations
+	verifySem chan struct{}
+
+	replacement peerReplacement
+
+	// cached result of expiredPeers
+	expired    map[peer.ID]bool
+	expiredAt  time.Time
+	expiredMux sync.Mutex
 }
 
 // NewCluster builds a new IPFS Cluster peer. It initializes a LibP2P host,
 // creates and RPC Server and client and sets up all components.
 //
+// The api component can be nil when the peer is embedded and driven
+// directly through its methods (see ClusterAPI).
+//
 // The new cluster peer may still be performing initialization tasks when
 // this call returns (consensus may still be bootstrapping). Use Cluster.Ready()
 // if you need to wait until the peer is fully up.
@@ -91,11 +148,32 @@ func NewCluster(
 		monitor:   monitor,
 		allocator: allocator,
 		informer:  informer,
+		accessLog: newAccessLog(accessTimesPath(cfg)),
+		alerts:    newAlertBroker(),
+		ops:       newOpContexts(),
+		diskSpace: newDiskSpace(cfg.ConsensusDataFolder, cfg.DiskSpaceThresholdMB),
 		doneCh:    make(chan struct{}),
 		readyCh:   make(chan struct{}),
+
+		pendingRemovals: make(map[string][]peer.ID),
+		statusVersions:  newStatusVersions(),
+		verifySem:       make(chan struct{}, VerifyConcurrency),
+	}
+
+	if err := c.loadRemovals(); err != nil {
+		logger.Errorf("error loading pending removals: %s", err)
 	}
 
 	c.setupPeerManager()
+	if as, ok := api.(alertStreamer); ok {
+		as.SetAlertSource(c)
+	}
+	if ss, ok := api.(statusStreamer); ok {
+		ss.SetStatusSource(c)
+	}
+	if cr, ok := api.(contentReceiver); ok {
+		cr.SetContentAdder(ipfs)
+	}
 	err = c.setupRPC()
 	if err != nil {
 		c.Shutdown()
@@ -108,6 +186,16 @@ func NewCluster(
 		return nil, err
 	}
 	c.setupRPCClients()
+
+	if cfg.EnableMetrics {
+		c.metricsServer, err = newMetricsServer(c, cfg.MetricsAddr)
+		if err != nil {
+			logger.Errorf("error starting the metrics server: %s", err)
+			c.Shutdown()
+			return nil, err
+		}
+	}
+
 	c.bootstrap()
 	ok := c.bootstrap()
 	if !ok {
@@ -156,7 +244,7 @@ func (c *Cluster) setupConsensus() error {
 	consensus, err := NewConsensus(
 		append(startPeers, c.id),
 		c.host,
-		c.config.ConsensusDataFolder,
+		c.config,
 		c.state)
 	if err != nil {
 		logger.Errorf("error creating consensus: %s", err)
@@ -169,7 +257,9 @@ func (c *Cluster) setupConsensus() error {
 func (c *Cluster) setupRPCClients() {
 	c.tracker.SetClient(c.rpcClient)
 	c.ipfs.SetClient(c.rpcClient)
-	c.api.SetClient(c.rpcClient)
+	if c.api != nil {
+		c.api.SetClient(c.rpcClient)
+	}
 	c.consensus.SetClient(c.rpcClient)
 	c.monitor.SetClient(c.rpcClient)
 	c.allocator.SetClient(c.rpcClient)
@@ -184,6 +274,10 @@ func (c *Cluster) stateSyncWatcher() {
 		select {
 		case <-stateSyncTicker.C:
 			c.StateSync()
+			c.checkAllocations()
+			c.accessLog.save()
+			c.evict()
+			c.retryRemovals()
 		case <-c.ctx.Done():
 			stateSyncTicker.Stop()
 			return
@@ -233,6 +327,7 @@ func (c *Cluster) pushInformerMetrics() {
 // before signaling readyCh
 func (c *Cluster) run() {
 	go c.stateSyncWatcher()
+	go c.diskSpaceWatcher()
 	go c.pushInformerMetrics()
 }
 
@@ -299,7 +394,7 @@ func (c *Cluster) Shutdown() error {
 
 	logger.Info("shutting down IPFS Cluster")
 
-	if c.config.LeaveOnShutdown {
+	if c.config.LeaveOnShutdown && !c.left {
 		// best effort
 		logger.Warning("Attempting to leave Cluster. This may take some seconds")
 		err := c.consensus.LogRmPeer(c.id)
@@ -314,6 +409,10 @@ func (c *Cluster) Shutdown() error {
 	// Cancel contexts
 	c.cancel()
 
+	if c.metricsServer != nil {
+		c.metricsServer.shutdown()
+	}
+
 	if con := c.consensus; con != nil {
 		if err := con.Shutdown(); err != nil {
 			logger.Errorf("error stopping consensus: %s", err)
@@ -323,9 +422,11 @@ func (c *Cluster) Shutdown() error {
 
 	c.peerManager.savePeers()
 
-	if err := c.api.Shutdown(); err != nil {
-		logger.Errorf("error stopping API: %s", err)
-		return err
+	if c.api != nil {
+		if err := c.api.Shutdown(); err != nil {
+			logger.Errorf("error stopping API: %s", err)
+			return err
+		}
 	}
 	if err := c.ipfs.Shutdown(); err != nil {
 		logger.Errorf("error stopping IPFS Connector: %s", err)
@@ -337,6 +438,8 @@ func (c *Cluster) Shutdown() error {
 		return err
 	}
 	c.wg.Wait()
+	c.accessLog.save()
+	c.alerts.close()
 	c.host.Close() // Shutdown all network services
 	c.shutdown = true
 	close(c.doneCh)
@@ -373,16 +476,31 @@ func (c *Cluster) ID() api.ID {
 		Commit:             Commit,
 		RPCProtocolVersion: RPCProtocol,
 		IPFS:               ipfsID,
+		Time:               time.Now(),
 	}
 }
 
 // PeerAdd adds a new peer to this Cluster.
 //
-// The new peer must be reachable. It will be added to the
-// consensus and will receive the shared state (including the
-// list of peers). The new peer should be a single-peer cluster,
-// preferable without any relevant state.
+// The new peer must be reachable: it is dialed on the given address
+// before anything else, and an error is returned if that fails within
+// PeerAddDialTimeout. It will be added to the consensus and will
+// receive the shared state (including the list of peers). The new peer
+// should be a single-peer cluster, preferable without any relevant
+// state.
 func (c *Cluster) PeerAdd(addr ma.Multiaddr) (api.ID, error) {
+	return c.peerAdd(addr, false)
+}
+
+// PeerAddForce works like PeerAdd but does not check that the new peer
+// is reachable, and commits it to the consensus anyway. If the peer
+// cannot be contacted, it does not receive the list of peers and must
+// join the Cluster on its own.
+func (c *Cluster) PeerAddForce(addr ma.Multiaddr) (api.ID, error) {
+	return c.peerAdd(addr, true)
+}
+
+func (c *Cluster) peerAdd(addr ma.Multiaddr, force bool) (api.ID, error) {
 	// starting 10 nodes on the same box for testing
 	// causes deadlock and a global lock here
 	// seems to help.
@@ -397,6 +515,15 @@ func (c *Cluster) PeerAdd(addr ma.Multiaddr) (api.ID, error) {
 		return id, err
 	}
 
+	if !force {
+		err = c.dialPeer(pid, decapAddr)
+		if err != nil {
+			logger.Error(err)
+			id := api.ID{ID: pid, Error: err.Error()}
+			return id, err
+		}
+	}
+
 	// Figure out its real address if we have one
 	remoteAddr := getRemoteMultiaddr(c.host, pid, decapAddr)
 
@@ -412,12 +539,16 @@ func (c *Cluster) PeerAdd(addr ma.Multiaddr) (api.ID, error) {
 	var addrSerial api.MultiaddrSerial
 	err = c.rpcClient.Call(pid, "Cluster",
 		"RemoteMultiaddrForPeer", c.id, &addrSerial)
-	if err != nil {
+	reachable := err == nil
+	if err != nil && !force {
 		logger.Error(err)
 		id := api.ID{ID: pid, Error: err.Error()}
 		c.peerManager.rmPeer(pid, false)
 		return id, err
 	}
+	if err != nil {
+		logger.Warningf("adding %s, which cannot be contacted: %s", pid.Pretty(), err)
+	}
 
 	// Log the new peer in the log so everyone gets it.
 	err = c.consensus.LogAddPeer(remoteAddr)
@@ -429,31 +560,56 @@ func (c *Cluster) PeerAdd(addr ma.Multiaddr) (api.ID, error) {
 	}
 
 	// Send cluster peers to the new peer.
-	clusterPeers := append(c.peerManager.peersAddrs(),
-		addrSerial.ToMultiaddr())
-	err = c.rpcClient.Call(pid,
-		"Cluster",
-		"PeerManagerAddFromMultiaddrs",
-		api.MultiaddrsToSerial(clusterPeers),
-		&struct{}{})
-	if err != nil {
-		logger.Error(err)
+	if reachable {
+		clusterPeers := append(c.peerManager.peersAddrs(),
+			addrSerial.ToMultiaddr())
+		err = c.rpcClient.Call(pid,
+			"Cluster",
+			"PeerManagerAddFromMultiaddrs",
+			api.MultiaddrsToSerial(clusterPeers),
+			&struct{}{})
+		if err != nil {
+			logger.Error(err)
+		}
 	}
 
 	id, err := c.getIDForPeer(pid)
 	return id, nil
 }
 
+// dialPeer connects to a peer on the given address, failing after
+// PeerAddDialTimeout.
+func (c *Cluster) dialPeer(pid peer.ID, addr ma.Multiaddr) error {
+	ctx, cancel := context.WithTimeout(c.ctx, PeerAddDialTimeout)
+	defer cancel()
+	err := c.host.Connect(ctx, peerstore.PeerInfo{
+		ID:    pid,
+		Addrs: []ma.Multiaddr{addr},
+	})
+	if err != nil {
+		return api.NewError(400, "cannot connect to %s on %s: %s",
+			pid.Pretty(), addr, err)
+	}
+	return nil
+}
+
 // PeerRemove removes a peer from this Cluster.
 //
-// The peer will be removed from the consensus peer set,
+// The pins allocated to the peer are re-allocated to other peers
+// first. Then the peer will be removed from the consensus peer set,
 // it will be shut down after this happens.
 func (c *Cluster) PeerRemove(pid peer.ID) error {
 	if !c.peerManager.isPeer(pid) {
-		return fmt.Errorf("%s is not a peer", pid.Pretty())
+		return api.NewError(404, "%s is not a peer", pid.Pretty())
 	}
 
-	err := c.consensus.LogRmPeer(pid)
+	err := c.reallocateFromPeer(pid)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	err = c.consensus.LogRmPeer(pid)
 	if err != nil {
 		logger.Error(err)
 		return err
@@ -473,6 +629,148 @@ func (c *Cluster) PeerRemove(pid peer.ID) error {
 	return nil
 }
 
+// Leave removes this peer from the Cluster and shuts it down. Unlike
+// PeerRemove, every step is completed before shutting down: the pins
+// allocated to this peer are re-allocated, its removal is committed,
+// and Leave waits until the rest of the Cluster has applied it and has
+// a leader among themselves. A leader leaving steps down once its
+// removal is committed. A snapshot of the state is then taken so that
+// the peer can be restarted from it. The shutdown happens in the
+// background once Leave returns.
+func (c *Cluster) Leave() error {
+	var others []peer.ID
+	for _, p := range c.peerManager.peers() {
+		if p != c.id {
+			others = append(others, p)
+		}
+	}
+	if len(others) == 0 {
+		return api.NewError(400, "cannot leave: this is the only Cluster peer")
+	}
+
+	logger.Warning("leaving the Cluster. This may take some seconds")
+	err := c.reallocateFromPeer(c.id)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	err = c.consensus.LogRmPeer(c.id)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(c.ctx, LeaveTimeout)
+	defer cancel()
+	err = c.waitForLeave(ctx, others)
+	if err != nil {
+		return api.NewError(504, "this peer was removed, but the removal was not applied by the Cluster in time: %s", err)
+	}
+
+	// Best effort. The state is also in the log.
+	err = c.consensus.SnapshotLocal()
+	if err != nil {
+		logger.Error(err)
+	}
+
+	c.shutdownLock.Lock()
+	c.left = true
+	c.shutdownLock.Unlock()
+	c.peerManager.resetPeers()
+
+	logger.Info("this peer has left the Cluster and will shut down")
+	go c.Shutdown()
+	return nil
+}
+
+// waitForLeave holds until any of the given peers reports a Raft
+// configuration without this peer and with a leader.
+func (c *Cluster) waitForLeave(ctx context.Context, others []peer.ID) error {
+	for {
+		for _, p := range others {
+			var servers []api.RaftServerSerial
+			err := c.rpcClient.Call(p,
+				"Cluster",
+				"RaftConfiguration",
+				struct{}{},
+				&servers)
+			if err != nil {
+				logger.Debugf("waiting for %s: %s", p.Pretty(), err)
+				continue
+			}
+			if raftConfigurationLeft(servers, c.id) {
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+// raftConfigurationLeft returns true when the given Raft configuration
+// does not include pid and has a leader.
+func raftConfigurationLeft(servers []api.RaftServerSerial, pid peer.ID) bool {
+	leader := false
+	for _, s := range servers {
+		if s.ID == peer.IDB58Encode(pid) {
+			return false
+		}
+		leader = leader || s.Leader
+	}
+	return leader
+}
+
+// reallocateFromPeer moves the pins allocated to a peer which is about
+// to leave the Cluster to other peers, so that they do not silently
+// become under-replicated. The remaining allocations are kept and new
+// ones are chosen by the allocator. Pins for which not enough peers are
+// found are flagged as under-replicated and logged. It fails only when
+// the updated pins cannot be committed.
+func (c *Cluster) reallocateFromPeer(pid peer.ID) error {
+	cState, err := c.consensus.State()
+	if err != nil {
+		return err
+	}
+
+	var underReplicated []*cid.Cid
+	for _, carg := range cState.ListByPeer(pid) {
+		if carg.Everywhere {
+			continue
+		}
+		if carg.UserAllocations {
+			logger.Warningf("not re-allocating %s: its allocations were given explicitly", carg.Cid)
+			continue
+		}
+		carg.Allocations = withoutPeer(carg.Allocations, pid)
+		rplMin, rplMax := c.replicationFactors(carg)
+		allocs, err := c.allocate(carg.Cid, rplMin, rplMax, pid)
+		if err != nil {
+			logger.Warningf("error re-allocating %s: %s", carg.Cid, err)
+		}
+		carg.Allocations = append(carg.Allocations, allocs...)
+		carg.UnderReplicated = len(carg.Allocations) < rplMin
+		if carg.UnderReplicated {
+			underReplicated = append(underReplicated, carg.Cid)
+		}
+		_, err = c.consensus.LogPin(carg)
+		if err != nil {
+			return fmt.Errorf("re-allocating %s: %s", carg.Cid, err)
+		}
+		logger.Infof("re-allocated %s to %s", carg.Cid, carg.Allocations)
+	}
+
+	if len(underReplicated) > 0 {
+		logger.Warningf("the removal of %s leaves these CIDs under-replicated: %s",
+			pid.Pretty(), underReplicated)
+	}
+	return nil
+}
+
 // Join adds this peer to an existing cluster. The calling peer should
 // be a single-peer cluster node. This is almost equivalent to calling
 // PeerAdd on the destination cluster.
@@ -547,7 +845,7 @@ func (c *Cluster) StateSync() ([]api.PinInfo, error) {
 	}
 
 	// Untrack items which should not be tracked
-	for _, p := range c.tracker.StatusAll() {
+	for _, p := range c.tracker.StatusAll(c.ctx) {
 		if !cState.Has(p.Cid) {
 			changed = append(changed, p.Cid)
 			go c.tracker.Untrack(p.Cid)
@@ -561,10 +859,160 @@ func (c *Cluster) StateSync() ([]api.PinInfo, error) {
 	return infos, nil
 }
 
+// checkAllocations looks for pins allocated to peers which are no longer
+// part of the Cluster, and for pins allocated to fewer peers than their
+// minimum replication factor. They are re-allocated when the
+// ReallocateUnknownAllocations option is set, or just logged otherwise.
+// Pins below their minimum are handled first. Only the leader performs
+// this check, to avoid every peer committing the same re-allocations.
+func (c *Cluster) checkAllocations() {
+	leader, err := c.consensus.Leader()
+	if err != nil || leader != c.id {
+		return
+	}
+
+	cState, err := c.consensus.State()
+	if err != nil {
+		return
+	}
+
+	var urgent, others []api.CidArg
+	for _, carg := range cState.List() {
+		if carg.Everywhere {
+			continue
+		}
+
+		known, unknown := c.splitAllocations(carg.Allocations)
+		rplMin, _ := c.replicationFactors(carg)
+		switch {
+		case len(known) < rplMin:
+			logger.Errorf("%s is allocated to %d peers, below its minimum replication factor (%d)",
+				carg.Cid, len(known), rplMin)
+			urgent = append(urgent, carg)
+		case len(unknown) > 0:
+			logger.Warningf("%s is allocated to peers which are not part of the cluster: %s",
+				carg.Cid, unknown)
+			others = append(others, carg)
+		}
+	}
+
+	if !c.config.ReallocateUnknownAllocations {
+		return
+	}
+
+	for _, carg := range append(urgent, others...) {
+		if carg.UserAllocations {
+			logger.Warningf("not re-allocating %s: its allocations were given explicitly", carg.Cid)
+			continue
+		}
+		known, _ := c.splitAllocations(carg.Allocations)
+		rplMin, rplMax := c.replicationFactors(carg)
+		allocs, err := c.allocate(carg.Cid, rplMin, rplMax)
+		if err != nil {
+			logger.Errorf("error re-allocating %s: %s", carg.Cid, err)
+			continue
+		}
+		carg.Allocations = append(known, allocs...)
+		carg.UnderReplicated = len(carg.Allocations) < rplMin
+		_, err = c.consensus.LogPin(carg)
+		if err != nil {
+			logger.Errorf("error re-allocating %s: %s", carg.Cid, err)
+			continue
+		}
+		logger.Infof("re-allocated %s to %s", carg.Cid, carg.Allocations)
+	}
+}
+
+// splitAllocations separates the given allocations into those which
+// are cluster peers and those which are not.
+func (c *Cluster) splitAllocations(allocs []peer.ID) (known, unknown []peer.ID) {
+	for _, p := range allocs {
+		if c.peerManager.isPeer(p) {
+			known = append(known, p)
+		} else {
+			unknown = append(unknown, p)
+		}
+	}
+	return
+}
+
+// replicationFactors returns the minimum and maximum replication
+// factors for a pin. Pins which do not set them use the
+// ReplicationFactor from the configuration for both. When only one
+// of them is set, the other one is the ReplicationFactor from the
+// configuration, unless it falls on the wrong side of the one set,
+// which is then used for both.
+func (c *Cluster) replicationFactors(carg api.CidArg) (int, int) {
+	rplMin, rplMax := carg.ReplicationFactorMin, carg.ReplicationFactorMax
+	rf := c.config.ReplicationFactor
+	switch {
+	case rplMin == 0 && rplMax == 0:
+		return rf, rf
+	case rplMin == 0:
+		rplMin = rf
+		if rplMin <= 0 || (rplMax > 0 && rplMin > rplMax) {
+			rplMin = rplMax
+		}
+	case rplMax == 0:
+		rplMax = rf
+		if rplMin < 0 || (rplMax > 0 && rplMax < rplMin) {
+			rplMax = rplMin
+		}
+	}
+	return rplMin, rplMax
+}
+
+// setPinDetails fills in the replication information, the name, the
+// metadata and the namespace of a GlobalPinInfo from the given pin in
+// the shared state.
+func (c *Cluster) setPinDetails(gpi *api.GlobalPinInfo, carg api.CidArg) {
+	gpi.Name = carg.Name
+	gpi.Metadata = carg.Metadata
+	gpi.Namespace = carg.Namespace
+	gpi.UnderReplicated = carg.UnderReplicated
+	gpi.ReplicationFactorMin, gpi.ReplicationFactorMax = c.replicationFactors(carg)
+	if carg.Everywhere {
+		gpi.ReplicationFactorMin, gpi.ReplicationFactorMax = -1, -1
+	}
+	gpi.Replicas = 0
+	for _, pinfo := range gpi.PeerMap {
+		if pinfo.Status == api.TrackerStatusPinned {
+			gpi.Replicas++
+		}
+	}
+}
+
 // StatusAll returns the GlobalPinInfo for all tracked Cids. If an error
 // happens, the slice will contain as much information as could be fetched.
-func (c *Cluster) StatusAll() ([]api.GlobalPinInfo, error) {
-	return c.globalPinInfoSlice("TrackerStatusAll")
+// Peers which have not answered when ctx is cancelled are reported in
+// error.
+func (c *Cluster) StatusAll(ctx context.Context) ([]api.GlobalPinInfo, error) {
+	return c.globalPinInfoSlice(ctx, "TrackerStatusAll", struct{}{})
+}
+
+// StatusAllPage works like StatusAll but returns, sorted by Cid, only
+// up to limit items which sort after the given Cid. Next is set in the
+// page only when there are more items.
+func (c *Cluster) StatusAllPage(ctx context.Context, after string, limit int) (api.StatusPage, error) {
+	if limit <= 0 {
+		return api.StatusPage{}, api.NewError(400, "the page limit must be positive")
+	}
+	// Each peer sends its own first items after the cursor. Any of
+	// the first items overall is among them for every peer tracking it.
+	// One more item than needed tells whether there is a next page.
+	infos, err := c.globalPinInfoSlice(ctx, "TrackerStatusPage",
+		api.PageRequest{After: after, Limit: limit + 1})
+	if err != nil {
+		return api.StatusPage{}, err
+	}
+	sort.Sort(globalPinInfosByCid(infos))
+
+	page := api.StatusPage{Items: infos}
+	if len(infos) > limit {
+		page.Items = infos[:limit]
+		page.Next = page.Items[limit-1].Cid.String()
+	}
+	return page, nil
 }
 
 // Status returns the GlobalPinInfo for a given Cid. If an error happens,
@@ -573,13 +1021,45 @@ func (c *Cluster) Status(h *cid.Cid) (api.GlobalPinInfo, error) {
 	return c.globalPinInfoCid("TrackerStatus", h)
 }
 
+// StatusCids returns the GlobalPinInfo for each of the given Cids, in
+// the same order. Every peer is asked once for the status of all of
+// them. Cids which are not tracked are reported as unpinned.
+func (c *Cluster) StatusCids(ctx context.Context, cids []*cid.Cid) ([]api.GlobalPinInfo, error) {
+	hashes := make([]string, len(cids), len(cids))
+	for i, h := range cids {
+		hashes[i] = h.String()
+	}
+	infos, err := c.globalPinInfoSlice(ctx, "TrackerStatusCids", hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	byCid := make(map[string]api.GlobalPinInfo, len(infos))
+	for _, info := range infos {
+		byCid[info.Cid.String()] = info
+	}
+	result := make([]api.GlobalPinInfo, len(cids), len(cids))
+	for i, h := range cids {
+		info, ok := byCid[hashes[i]]
+		if !ok { // no peer answered
+			info = api.GlobalPinInfo{
+				Cid:     h,
+				PeerMap: make(map[peer.ID]api.PinInfo),
+			}
+		}
+		result[i] = info
+	}
+	return result, nil
+}
+
 // SyncAllLocal makes sure that the current state for all tracked items
 // matches the state reported by the IPFS daemon.
 //
 // SyncAllLocal returns the list of PinInfo that where updated because of
-// the operation, along with those in error states.
-func (c *Cluster) SyncAllLocal() ([]api.PinInfo, error) {
-	syncedItems, err := c.tracker.SyncAll()
+// the operation, along with those in error states. It stops when ctx is
+// cancelled.
+func (c *Cluster) SyncAllLocal(ctx context.Context) ([]api.PinInfo, error) {
+	syncedItems, err := c.tracker.SyncAll(ctx)
 	// Despite errors, tracker provides synced items that we can provide.
 	// They encapsulate the error.
 	if err != nil {
@@ -604,9 +1084,10 @@ func (c *Cluster) SyncLocal(h *cid.Cid) (api.PinInfo, error) {
 	return pInfo, err
 }
 
-// SyncAll triggers LocalSync() operations in all cluster peers.
-func (c *Cluster) SyncAll() ([]api.GlobalPinInfo, error) {
-	return c.globalPinInfoSlice("SyncAllLocal")
+// SyncAll triggers LocalSync() operations in all cluster peers. They
+// are cancelled when ctx is.
+func (c *Cluster) SyncAll(ctx context.Context) ([]api.GlobalPinInfo, error) {
+	return c.globalPinInfoOp(ctx, "SyncAllLocal")
 }
 
 // Sync triggers a LocalSyncCid() operation for a given Cid
@@ -626,17 +1107,133 @@ func (c *Cluster) Recover(h *cid.Cid) (api.GlobalPinInfo, error) {
 	return c.globalPinInfoCid("TrackerRecover", h)
 }
 
+// RecoverAllLocal triggers a recover operation for all the Cids in
+// PinError or UnpinError state in this peer. Up to
+// Config.RecoverAllConcurrency of them are recovered at the same time.
+// It returns the resulting status of those Cids. Failures are reflected
+// in the status of each Cid. When ctx is cancelled, no more Cids are
+// recovered and the context's error is returned along with the status
+// of those which were.
+func (c *Cluster) RecoverAllLocal(ctx context.Context) ([]api.PinInfo, error) {
+	var errored []*cid.Cid
+	for _, pinfo := range c.tracker.StatusAll(ctx) {
+		switch pinfo.Status {
+		case api.TrackerStatusPinError, api.TrackerStatusUnpinError:
+			errored = append(errored, pinfo.Cid)
+		}
+	}
+	if len(errored) == 0 {
+		return []api.PinInfo{}, nil
+	}
+	logger.Infof("recovering %d items in error state", len(errored))
+
+	concurrency := c.config.RecoverAllConcurrency
+	if concurrency <= 0 {
+		concurrency = DefaultRecoverAllConcurrency
+	}
+	sem := make(chan struct{}, concurrency)
+	pinfos := make([]api.PinInfo, len(errored), len(errored))
+	var wg sync.WaitGroup
+	started := 0
+	for _, h := range errored {
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(i int, h *cid.Cid) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			pinfos[i], _ = c.tracker.Recover(h)
+		}(started, h)
+		started++
+	}
+	wg.Wait()
+	return pinfos[:started], ctx.Err()
+}
+
+// RecoverAll triggers a RecoverAllLocal() operation in all cluster
+// peers, and returns the status of the Cids which were recovered in
+// any of them. They are cancelled when ctx is.
+func (c *Cluster) RecoverAll(ctx context.Context) ([]api.GlobalPinInfo, error) {
+	return c.globalPinInfoOp(ctx, "RecoverAllLocal")
+}
+
 // Pins returns the list of Cids managed by Cluster and which are part
 // of the current global state. This is the source of truth as to which
 // pins are managed, but does not indicate if the item is successfully pinned.
 func (c *Cluster) Pins() []api.CidArg {
+	return c.readState().List()
+}
+
+// LocalAllocations returns the list of Cids which this peer is expected
+// to pin according to the shared state: those allocated to it and those
+// pinned everywhere. Unlike the status in the PinTracker, it reflects
+// the intended assignment rather than what IPFS actually holds.
+func (c *Cluster) LocalAllocations() []api.CidArg {
+	return c.PinsByPeer(c.id)
+}
+
+// PinsByPeer returns the list of Cids allocated to the given peer,
+// including those pinned everywhere.
+func (c *Cluster) PinsByPeer(p peer.ID) []api.CidArg {
+	return c.readState().ListByPeer(p)
+}
+
+// PinsByNamespace returns the list of Cids pinned in the given
+// namespace.
+func (c *Cluster) PinsByNamespace(ns string) []api.CidArg {
+	return c.readState().ListByNamespace(ns)
+}
+
+// PinsByName returns the list of Cids pinned with the given name.
+func (c *Cluster) PinsByName(name string) []api.CidArg {
+	return c.readState().ListByName(name)
+}
+
+// PinsByMetadata returns the list of Cids pinned with the given value
+// for the given metadata key.
+func (c *Cluster) PinsByMetadata(key, value string) []api.CidArg {
+	return c.readState().ListByMetadata(key, value)
+}
+
+// readState returns the shared state for read-only operations. When
+// it cannot be obtained from the consensus, i.e. during a leader
+// election on a peer which has not received the state yet, the local
+// copy is used instead. See StateStale().
+func (c *Cluster) readState() State {
 	cState, err := c.consensus.State()
-	if err != nil {
-		logger.Error(err)
-		return []api.CidArg{}
+	if err == nil {
+		return cState
 	}
+	logger.Warningf("using the local copy of the shared state: %s", err)
+	cState, _ = c.consensus.LocalState()
+	return cState
+}
+
+// StateStale returns true when the shared state as known by this peer
+// may be out of date, because there is no consensus leader or no state
+// has been agreed upon. Listings keep being served from it meanwhile.
+func (c *Cluster) StateStale() bool {
+	_, stale := c.consensus.LocalState()
+	return stale
+}
 
-	return cState.List()
+// Allocations returns the pin for the given Cid as found in the
+// shared state, which tells which peers it is allocated to, or
+// whether it is pinned everywhere.
+func (c *Cluster) Allocations(h *cid.Cid) (api.CidArg, error) {
+	cState := c.readState()
+	if !cState.Has(h) {
+		return api.CidArg{}, errNotPinned(h)
+	}
+	return cState.Get(h), nil
 }
 
 // Pin makes the cluster Pin a Cid. This implies adding the Cid
@@ -647,32 +1244,178 @@ func (c *Cluster) Pins() []api.CidArg {
 // Pin returns an error if the operation could not be persisted
 // to the global state. Pin does not reflect the success or failure
 // of underlying IPFS daemon pinning operations.
-func (c *Cluster) Pin(h *cid.Cid) error {
-	logger.Info("pinning:", h)
+//
+// On success, Pin returns the log index at which the operation was
+// committed. It can be passed to WaitForIndex to read-your-writes.
+//
+// The allocations for the given CidArg are decided by the Cluster,
+// within the ReplicationFactorMin and ReplicationFactorMax of the
+// CidArg. Pinning a Cid again keeps the peers already holding it and
+// only adds peers when needed, so it can be used to change the other
+// options of a pin. Other options, like NoFetch, are preserved. A Cid already
+// pinned under a different Namespace is left as it is, and Pin returns
+// errNotInNamespace.
+func (c *Cluster) Pin(cidArg api.CidArg) (uint64, error) {
+	logger.Info("pinning:", cidArg.Cid)
+	cidArg, err := c.preparePin(cidArg, 0)
+	if err != nil {
+		return 0, err
+	}
 
-	cidArg := api.CidArg{
-		Cid: h,
+	index, err := c.consensus.LogPin(cidArg)
+	if err != nil {
+		return 0, err
 	}
+	c.pinCommitted(cidArg.Cid)
+	return index, nil
+}
 
-	rpl := c.config.ReplicationFactor
+// preparePin decides the allocations of a CidArg to be pinned. queued
+// is the number of pins to be tracked by this peer which will be
+// committed along with this one.
+func (c *Cluster) preparePin(cidArg api.CidArg, queued int) (api.CidArg, error) {
+	h := cidArg.Cid
+	if c.readOnly() {
+		return cidArg, errLowDiskSpace
+	}
+
+	if c.pinnedElsewhere(cidArg) {
+		return cidArg, errNotInNamespace
+	}
+
+	userAllocs := cidArg.Allocations
+	cidArg.Allocations = nil
+	cidArg.Everywhere = false
+	cidArg.UnderReplicated = false
+	cidArg.UserAllocations = false
+	// Re-pinning does not remove the protection. See Protect().
+	cidArg.Protected = cidArg.Protected || c.isProtected(h)
+
+	rplMin, rplMax := c.replicationFactors(cidArg)
 	switch {
-	case rpl == 0:
-		return errors.New("replication factor is 0")
-	case rpl < 0:
+	case len(userAllocs) > 0:
+		// The allocator is bypassed when the allocations are given.
+		allocs, err := c.peerAllocations(userAllocs)
+		if err != nil {
+			return cidArg, err
+		}
+		cidArg.Allocations = allocs
+		cidArg.UserAllocations = true
+	case rplMin < 0 || rplMax < 0:
 		cidArg.Everywhere = true
-	case rpl > 0:
-		allocs, err := c.allocate(h)
+	case rplMin == 0 || rplMax == 0:
+		return cidArg, api.NewError(400, "replication factor is 0")
+	case rplMin > rplMax:
+		return cidArg, api.NewError(400, "the minimum replication factor (%d) is larger than the maximum (%d)",
+			rplMin, rplMax)
+	default:
+		allocs, err := c.allocateWithCurrent(h, rplMin, rplMax)
 		if err != nil {
-			return err
+			return cidArg, err
 		}
 		cidArg.Allocations = allocs
+		if len(allocs) < rplMin {
+			logger.Warningf("%s is under-replicated: minimum replication factor is %d but only %d peers are available",
+				h, rplMin, len(allocs))
+			cidArg.UnderReplicated = true
+		}
+	}
+
+	// The pin reaches the PinTracker only after it is committed, so
+	// a full queue is checked beforehand rather than accepting a pin
+	// which would fail right away on this peer.
+	if c.tracksLocally(cidArg) {
+		load := c.tracker.Load()
+		load.QueueLength += queued
+		if pinQueueFull(load) {
+			return cidArg, ErrPinQueueFull
+		}
 	}
+	return cidArg, nil
+}
+
+// pinCommitted is called for every pin once committed.
+func (c *Cluster) pinCommitted(h *cid.Cid) {
+	// Pinning counts as an access so that new pins are not
+	// the first ones evicted.
+	c.accessLog.touch(h)
+	// A pending ForceUnpin must not remove the content anymore
+	c.forgetRemoval(h.String())
+}
 
-	err := c.consensus.LogPin(cidArg)
+// tracksLocally returns true when this peer should pin the given
+// item.
+func (c *Cluster) tracksLocally(cidArg api.CidArg) bool {
+	if cidArg.Everywhere {
+		return true
+	}
+	for _, p := range cidArg.Allocations {
+		if p == c.id {
+			return true
+		}
+	}
+	return false
+}
+
+func pinQueueFull(load api.TrackerLoad) bool {
+	return load.QueueCapacity > 0 && load.QueueLength >= load.QueueCapacity
+}
+
+// PinMany pins several Cids like Pin, returning the outcome for each
+// of them in the same order. An error allocating one of them does not
+// prevent pinning the rest. The allocated ones are committed to the
+// shared state in a single operation, so they are pinned at the same
+// log index or not at all.
+func (c *Cluster) PinMany(cidArgs []api.CidArg) []api.PinResult {
+	results := make([]api.PinResult, len(cidArgs), len(cidArgs))
+	var batch []api.CidArg
+	var positions []int
+	queued := 0
+	for i, carg := range cidArgs {
+		results[i].Cid = carg.Cid
+		carg, err := c.preparePin(carg, queued)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		if c.tracksLocally(carg) {
+			queued++
+		}
+		batch = append(batch, carg)
+		positions = append(positions, i)
+	}
+	if len(batch) == 0 {
+		return results
+	}
+
+	index, err := c.consensus.LogPinMany(batch)
+	for j, i := range positions {
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i].Index = index
+		c.pinCommitted(batch[j].Cid)
+	}
+	return results
+}
+
+// PinPath resolves an IPFS path, like /ipns/example.com or
+// /ipfs/<cid>/dir/file, using the IPFS daemon, and pins the Cid it
+// points to like Pin does with the options in the given CidArg. It
+// returns that Cid along with the log index of the operation.
+//
+// The pin is fixed to what the path resolved to at this time: it is
+// not updated when an IPNS name or a DNSLink changes.
+func (c *Cluster) PinPath(path string, carg api.CidArg) (*cid.Cid, uint64, error) {
+	h, err := c.ipfs.Resolve(path)
 	if err != nil {
-		return err
+		return nil, 0, fmt.Errorf("error resolving %s: %s", path, err)
 	}
-	return nil
+	logger.Infof("%s resolved to %s", path, h)
+	carg.Cid = h
+	index, err := c.Pin(carg)
+	return h, index, err
 }
 
 // Unpin makes the cluster Unpin a Cid. This implies adding the Cid
@@ -681,33 +1424,467 @@ func (c *Cluster) Pin(h *cid.Cid) error {
 // Unpin returns an error if the operation could not be persisted
 // to the global state. Unpin does not reflect the success or failure
 // of underlying IPFS daemon unpinning operations.
-func (c *Cluster) Unpin(h *cid.Cid) error {
-	logger.Info("unpinning:", h)
+//
+// Unpin is scoped to the Namespace of the given CidArg: Cids pinned
+// under a different namespace are left untouched and errNotInNamespace
+// is returned, as it is for Cids which are not pinned at all when a
+// Namespace is given. Protected pins cannot be unpinned.
+func (c *Cluster) Unpin(carg api.CidArg) error {
+	logger.Info("unpinning:", carg.Cid)
 
-	carg := api.CidArg{
-		Cid: h,
+	if !c.inNamespace(carg) {
+		return errNotInNamespace
+	}
+	if c.isProtected(carg.Cid) {
+		return errPinProtected
+	}
+
+	carg = api.CidArg{
+		Cid:       carg.Cid,
+		Namespace: carg.Namespace,
+	}
+
+	_, err := c.consensus.LogUnpin(carg)
+	if err != nil {
+		return err
+	}
+	c.accessLog.forget(carg.Cid)
+	return nil
+}
+
+// UnpinIfHealthy works like Unpin, but only removes the Cid when it is
+// pinned on all the peers allocated to it (all of them for pins with
+// Everywhere set). Otherwise, i.e. while it is being pinned or
+// recovered somewhere, it returns a 409 error listing the peers where
+// it is not pinned and leaves the shared state untouched.
+func (c *Cluster) UnpinIfHealthy(carg api.CidArg) error {
+	cState, err := c.consensus.State()
+	if err != nil {
+		return err
+	}
+	if !c.inNamespace(carg) {
+		return errNotInNamespace
+	}
+	if !cState.Has(carg.Cid) {
+		return errNotPinned(carg.Cid)
 	}
 
-	err := c.consensus.LogUnpin(carg)
+	gpi, err := c.globalPinInfoCid("TrackerStatus", carg.Cid)
+	if err != nil {
+		return err
+	}
+	stored := cState.Get(carg.Cid)
+	expected := stored.Allocations
+	if stored.Everywhere {
+		expected = c.peerManager.peers()
+	}
+	var unhealthy []string
+	for _, p := range expected {
+		pinfo, ok := gpi.PeerMap[p]
+		switch {
+		case !ok:
+			unhealthy = append(unhealthy, p.Pretty()+" (unknown)")
+		case pinfo.Status != api.TrackerStatusPinned:
+			unhealthy = append(unhealthy, fmt.Sprintf("%s (%s)", p.Pretty(), pinfo.Status))
+		}
+	}
+	if len(unhealthy) > 0 {
+		return api.NewError(409, "%s is not pinned on all its allocations: %s",
+			carg.Cid, strings.Join(unhealthy, ", "))
+	}
+	return c.Unpin(carg)
+}
+
+// PinAcks asks the peers allocated to a pinned Cid (all of them for
+// pins with Everywhere set) whether they have accepted to track it.
+// This provides early feedback on whether the pin will be attempted
+// on every peer, before pinning completes. Peers which cannot be
+// contacted are reported as not having accepted the pin.
+func (c *Cluster) PinAcks(h *cid.Cid) ([]api.PinAck, error) {
+	cState, err := c.consensus.State()
+	if err != nil {
+		return nil, err
+	}
+	if !cState.Has(h) {
+		return nil, errNotPinned(h)
+	}
+
+	carg := cState.Get(h)
+	dests := carg.Allocations
+	if carg.Everywhere {
+		dests = c.peerManager.peers()
+	}
+
+	replies := make([]api.PinAckSerial, len(dests), len(dests))
+	ifaces := make([]interface{}, len(dests), len(dests))
+	for i := range replies {
+		ifaces[i] = &replies[i]
+	}
+	errs := c.multiRPC(c.ctx, dests, "Cluster", "TrackerAck",
+		api.CidArgCid(h).ToSerial(), ifaces)
+
+	acks := make([]api.PinAck, len(dests), len(dests))
+	for i, r := range replies {
+		if errs[i] != nil {
+			acks[i] = api.PinAck{
+				Peer:  dests[i],
+				Error: errs[i].Error(),
+			}
+			continue
+		}
+		acks[i] = r.ToPinAck()
+	}
+	return acks, nil
+}
+
+// trackerAck reports whether the local PinTracker has accepted to
+// track the given Cid. As tracking starts when the pin is applied to
+// the local state, it waits up to PinAckTimeout for it to happen.
+func (c *Cluster) trackerAck(h *cid.Cid) api.PinAck {
+	ack := api.PinAck{
+		Peer: c.id,
+	}
+	deadline := time.Now().Add(PinAckTimeout)
+	for {
+		pinfo := c.tracker.Status(h)
+		switch pinfo.Status {
+		case api.TrackerStatusPinning, api.TrackerStatusPinned:
+			ack.Accepted = true
+			return ack
+		case api.TrackerStatusPinError:
+			ack.Error = pinfo.Error
+			return ack
+		}
+
+		if time.Now().After(deadline) {
+			ack.Error = "the pin has not reached the tracker (status: " +
+				pinfo.Status.String() + ")"
+			return ack
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// ForceUnpin removes a Cid from the shared state, regardless of its
+// namespace, and asks the IPFS daemons of all cluster peers to unpin it.
+// Peers which cannot do it right away are retried regularly until they
+// all confirm the removal, so the content is eventually removed
+// everywhere even if some peers are down. Pending removals are kept
+// by the peer which performed the ForceUnpin only, in its consensus
+// data folder, and dropped if the Cid is pinned again. Protected pins
+// are not removed.
+func (c *Cluster) ForceUnpin(h *cid.Cid) error {
+	logger.Info("force-unpinning:", h)
+
+	cState, err := c.consensus.State()
 	if err != nil {
 		return err
 	}
+
+	// The Cid may be unpinned already, but still present in
+	// some IPFS daemons.
+	var index uint64
+	if cState.Has(h) {
+		carg := cState.Get(h)
+		if carg.Protected {
+			return errPinProtected
+		}
+		index, err = c.consensus.LogUnpin(api.CidArg{
+			Cid:       h,
+			Namespace: carg.Namespace,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	c.accessLog.forget(h)
+
+	c.removalsMux.Lock()
+	c.pendingRemovals[h.String()] = c.peerManager.peers()
+	c.saveRemovals()
+	c.removalsMux.Unlock()
+
+	// retryRemovals skips Cids which are in the state, so the
+	// removal must have been applied here first. Otherwise, it is
+	// retried later.
+	if index > 0 {
+		if err := c.WaitForIndex(index); err != nil {
+			logger.Warningf("removal of %s will be retried later: %s", h, err)
+			return nil
+		}
+	}
+	c.retryRemovals()
 	return nil
 }
 
+// retryRemovals asks the peers which have not confirmed the removal
+// of a Cid with ForceUnpin to unpin it again, and forgets the removals
+// confirmed by all of them. Cids which have been pinned again since
+// are not removed. The peers are called without holding removalsMux.
+func (c *Cluster) retryRemovals() {
+	c.removalsMux.Lock()
+	pending := make(map[string][]peer.ID, len(c.pendingRemovals))
+	for k, peers := range c.pendingRemovals {
+		pending[k] = peers
+	}
+	c.removalsMux.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	cState, err := c.consensus.State()
+	if err != nil {
+		logger.Debugf("not retrying removals: %s", err)
+		return
+	}
+
+	for k, peers := range pending {
+		h, _ := cid.Decode(k)
+		if cState.Has(h) {
+			logger.Infof("%s has been pinned again and will not be removed", k)
+			c.forgetRemoval(k)
+			continue
+		}
+
+		var members []peer.ID
+		for _, p := range peers {
+			if c.peerManager.isPeer(p) {
+				members = append(members, p)
+			}
+		}
+
+		errs := c.multiRPC(c.ctx, members, "Cluster", "IPFSUnpin",
+			api.CidArgCid(h).ToSerial(),
+			copyEmptyStructToIfaces(make([]struct{}, len(members), len(members))))
+
+		var remaining []peer.ID
+		for i, err := range errs {
+			if err != nil {
+				logger.Warningf("%s could not remove %s. Will retry: %s",
+					members[i].Pretty(), k, err)
+				remaining = append(remaining, members[i])
+			}
+		}
+
+		c.removalsMux.Lock()
+		// Pin may have dropped it meanwhile
+		if _, ok := c.pendingRemovals[k]; ok {
+			if len(remaining) == 0 {
+				logger.Infof("%s has been removed from all peers", k)
+				delete(c.pendingRemovals, k)
+			} else {
+				c.pendingRemovals[k] = remaining
+			}
+			c.saveRemovals()
+		}
+		c.removalsMux.Unlock()
+	}
+}
+
+// forgetRemoval drops the pending removal of a Cid, if any.
+func (c *Cluster) forgetRemoval(k string) {
+	c.removalsMux.Lock()
+	defer c.removalsMux.Unlock()
+	if _, ok := c.pendingRemovals[k]; ok {
+		delete(c.pendingRemovals, k)
+		c.saveRemovals()
+	}
+}
+
+// Reallocate replaces the allocations of a pinned Cid with the given
+// peers. The new allocation is committed to the shared state in a single
+// operation, which makes the new peers pin the content and the peers
+// which are no longer allocated unpin it. Like allocations given when
+// pinning, they are not changed by automatic re-allocations.
+//
+// Reallocate then waits until all affected peers have applied the
+// change, or for ReallocateTimeout, and returns the resulting status.
+// An error is returned if any of the affected peers failed to do so.
+func (c *Cluster) Reallocate(h *cid.Cid, newPeers []peer.ID) (api.GlobalPinInfo, error) {
+	if len(newPeers) == 0 {
+		return api.GlobalPinInfo{}, api.NewError(400, "no peers to allocate to")
+	}
+
+	st, err := c.consensus.State()
+	if err != nil {
+		return api.GlobalPinInfo{}, err
+	}
+	if !st.Has(h) {
+		return api.GlobalPinInfo{}, errNotPinned(h)
+	}
+
+	allocs, err := c.peerAllocations(newPeers)
+	if err != nil {
+		return api.GlobalPinInfo{}, err
+	}
+
+	carg := st.Get(h)
+	oldAllocs := carg.Allocations
+	carg.Allocations = allocs
+	carg.Everywhere = false
+	carg.UserAllocations = true
+
+	logger.Infof("reallocating %s from %s to %s", h, oldAllocs, allocs)
+	_, err = c.consensus.LogPin(carg)
+	if err != nil {
+		return api.GlobalPinInfo{}, err
+	}
+
+	allocated := make(map[peer.ID]bool)
+	for _, p := range allocs {
+		allocated[p] = true
+	}
+
+	ctx, cancel := context.WithTimeout(c.ctx, ReallocateTimeout)
+	defer cancel()
+	for {
+		gpi, err := c.globalPinInfoCid("TrackerStatus", h)
+		if err != nil {
+			return gpi, err
+		}
+		done, err := reallocationDone(gpi, allocated)
+		if done || err != nil {
+			return gpi, err
+		}
+
+		select {
+		case <-ctx.Done():
+			logger.Warningf("reallocation of %s still in progress", h)
+			return gpi, nil
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+// peerAllocations checks that the given peers are part of the Cluster
+// and returns them without duplicates, in the same order. It returns
+// a 400 error otherwise.
+func (c *Cluster) peerAllocations(peers []peer.ID) ([]peer.ID, error) {
+	allocs := make([]peer.ID, 0, len(peers))
+	seen := make(map[peer.ID]bool)
+	for _, p := range peers {
+		if !c.peerManager.isPeer(p) {
+			return nil, api.NewError(400, "%s is not a cluster peer", p.Pretty())
+		}
+		if !seen[p] {
+			allocs = append(allocs, p)
+			seen[p] = true
+		}
+	}
+	return allocs, nil
+}
+
+// reallocationDone checks whether the given status reflects that the
+// allocated peers have pinned the content and the rest have released it.
+func reallocationDone(gpi api.GlobalPinInfo, allocated map[peer.ID]bool) (bool, error) {
+	done := true
+	for p, pinfo := range gpi.PeerMap {
+		switch pinfo.Status {
+		case api.TrackerStatusPinError, api.TrackerStatusUnpinError, api.TrackerStatusClusterError:
+			return true, fmt.Errorf("reallocation failed on %s: %s", p.Pretty(), pinfo.Error)
+		}
+
+		if allocated[p] {
+			done = done && pinfo.Status == api.TrackerStatusPinned
+		} else {
+			done = done && (pinfo.Status == api.TrackerStatusRemote ||
+				pinfo.Status == api.TrackerStatusUnpinned)
+		}
+	}
+	return done, nil
+}
+
+// errNotInNamespace is returned for operations on a Cid which is not
+// pinned under the namespace of the request, whether it is pinned under
+// another one or not at all, so that the pins of other namespaces are
+// not revealed. The REST API answers the same.
+var errNotInNamespace = api.NewError(404, "Cid not pinned in this namespace")
+
+// pinnedElsewhere returns true if the Cid is part of the shared state
+// under a namespace different from the one in the given CidArg. Such
+// Cids cannot be pinned or unpinned from other namespaces.
+func (c *Cluster) pinnedElsewhere(carg api.CidArg) bool {
+	st, err := c.consensus.State()
+	if err != nil {
+		// no state means nothing is pinned. If there was another
+		// problem, we would fail to commit anyway.
+		return false
+	}
+	if !st.Has(carg.Cid) {
+		return false
+	}
+	return st.Get(carg.Cid).Namespace != carg.Namespace
+}
+
+// inNamespace returns false when an operation on an existing pin, like
+// Unpin, cannot go ahead because the Cid is pinned under another
+// namespace or, for CidArgs with a Namespace, is not pinned at all.
+// Both cases must look the same to the caller.
+func (c *Cluster) inNamespace(carg api.CidArg) bool {
+	if c.pinnedElsewhere(carg) {
+		return false
+	}
+	if carg.Namespace == "" {
+		return true
+	}
+	st, err := c.consensus.State()
+	return err == nil && st.Has(carg.Cid)
+}
+
+// WaitForIndex blocks until this peer's shared state has applied the
+// log entry with the given index, as returned by Pin. It returns an
+// error if that does not happen within WaitForIndexTimeout.
+func (c *Cluster) WaitForIndex(index uint64) error {
+	ctx, cancel := context.WithTimeout(c.ctx, WaitForIndexTimeout)
+	defer cancel()
+	return c.consensus.WaitForIndex(ctx, index)
+}
+
 // Version returns the current IPFS Cluster version
 func (c *Cluster) Version() string {
 	return Version
 }
 
+// QueueInfo returns the occupancy of the pin and unpin queues of
+// this peer's PinTracker.
+func (c *Cluster) QueueInfo() api.QueueInfo {
+	return c.tracker.QueueInfo()
+}
+
+// Summary returns an overview of the cluster: the number of pins in
+// the shared state, the number of items tracked by this peer in each
+// status, the number of peers, the leader and the version.
+func (c *Cluster) Summary() api.Summary {
+	s := api.Summary{
+		Status:  make(map[string]int),
+		Peers:   len(c.peerManager.peers()),
+		Version: Version,
+	}
+	if st, err := c.consensus.State(); err == nil {
+		s.Pins = st.Len()
+	}
+	if l, err := c.consensus.Leader(); err == nil {
+		s.Leader = peer.IDB58Encode(l)
+	}
+	for st := api.TrackerStatusClusterError; st <= api.TrackerStatusRemote; st++ {
+		s.Status[api.TrackerStatus(st).String()] = 0
+	}
+	for _, pinfo := range c.tracker.StatusAll(c.ctx) {
+		s.Status[pinfo.Status.String()]++
+	}
+	return s
+}
+
 // Peers returns the IDs of the members of this Cluster
 func (c *Cluster) Peers() []api.ID {
 	members := c.peerManager.peers()
 	peersSerial := make([]api.IDSerial, len(members), len(members))
 	peers := make([]api.ID, len(members), len(members))
 
-	errs := c.multiRPC(members, "Cluster", "ID", struct{}{},
+	start := time.Now()
+	errs := c.multiRPC(c.ctx, members, "Cluster", "ID", struct{}{},
 		copyIDSerialsToIfaces(peersSerial))
+	elapsed := time.Since(start)
 
 	for i, err := range errs {
 		if err != nil {
@@ -718,10 +1895,98 @@ func (c *Cluster) Peers() []api.ID {
 
 	for i, ps := range peersSerial {
 		peers[i] = ps.ToID()
+		if errs[i] == nil && members[i] != c.id {
+			checkClockSkew(&peers[i], start, elapsed)
+		}
 	}
 	return peers
 }
 
+// PeerInfo returns the ID of a single member of the Cluster, like
+// Peers() does for all of them. An error is returned when the given
+// peer is not part of the Cluster. When the peer cannot be contacted,
+// the error is included in the returned object instead.
+func (c *Cluster) PeerInfo(pid peer.ID) (api.ID, error) {
+	if !c.peerManager.isPeer(pid) {
+		return api.ID{}, api.NewError(404, "%s is not a peer", pid.Pretty())
+	}
+	if pid == c.id {
+		return c.ID(), nil
+	}
+
+	start := time.Now()
+	id, err := c.getIDForPeer(pid)
+	if err == nil {
+		checkClockSkew(&id, start, time.Since(start))
+	}
+	return id, nil
+}
+
+// RaftConfiguration returns the servers taking part in the consensus,
+// as seen by this peer. See Consensus.RaftConfiguration().
+func (c *Cluster) RaftConfiguration() ([]api.RaftServer, error) {
+	return c.consensus.RaftConfiguration()
+}
+
+// Snapshot makes the consensus leader take a snapshot of the shared
+// state. See Consensus.Snapshot().
+func (c *Cluster) Snapshot() error {
+	return c.consensus.Snapshot()
+}
+
+// ExportState returns the shared state serialized as JSON. See
+// Consensus.ExportState().
+func (c *Cluster) ExportState() ([]byte, error) {
+	return c.consensus.ExportState()
+}
+
+// ImportState replaces the shared state with one obtained with
+// ExportState(). See Consensus.ImportState(). Since the pins are not
+// replaced through pin and unpin operations, every peer is then asked
+// to sync its tracker to the new state. Peers which have not received
+// it yet catch up on their next periodic sync.
+func (c *Cluster) ImportState(data []byte) error {
+	err := c.consensus.ImportState(data)
+	if err != nil {
+		return err
+	}
+
+	members := c.peerManager.peers()
+	replies := make([]interface{}, len(members), len(members))
+	for i := range replies {
+		replies[i] = &[]api.PinInfoSerial{}
+	}
+	errs := c.multiRPC(c.ctx, members, "Cluster", "StateSync", struct{}{}, replies)
+	for i, err := range errs {
+		if err != nil {
+			logger.Warningf("error syncing %s to the imported state: %s", members[i], err)
+		}
+	}
+	return nil
+}
+
+// checkClockSkew estimates the clock difference with the peer which
+// generated the given ID during a request which started at start and
+// took elapsed. It sets the ID's ClockSkew and logs a warning when it
+// is over ClockSkewThreshold. As we do not know when exactly the ID was
+// generated, the estimation is only accurate to elapsed/2.
+func checkClockSkew(id *api.ID, start time.Time, elapsed time.Duration) {
+	if id.Time.IsZero() {
+		return
+	}
+	skew := id.Time.Sub(start.Add(elapsed / 2))
+	id.ClockSkew = skew
+
+	abs := skew
+	if abs < 0 {
+		abs = -abs
+	}
+	if abs > ClockSkewThreshold+elapsed/2 {
+		logger.Warningf("the clock of peer %s seems to be %s off from ours. This may cause premature timeouts and metric expiration",
+			id.ID.Pretty(), skew)
+	}
+}
+
 // makeHost makes a libp2p-host
 func makeHost(ctx context.Context, cfg *Config) (host.Host, error) {
 	ps := peerstore.NewPeerstore()
@@ -752,8 +2017,9 @@ func makeHost(ctx context.Context, cfg *Config) (host.Host, error) {
 	return bhost, nil
 }
 
-// Perform an RPC request to multiple destinations
-func (c *Cluster) multiRPC(dests []peer.ID, svcName, svcMethod string, args interface{}, reply []interface{}) []error {
+// Perform an RPC request to multiple destinations. The requests which
+// are still running are abandoned when the context is cancelled.
+func (c *Cluster) multiRPC(ctx context.Context, dests []peer.ID, svcName, svcMethod string, args interface{}, reply []interface{}) []error {
 	if len(dests) != len(reply) {
 		panic("must have matching dests and replies")
 	}
@@ -764,7 +2030,8 @@ func (c *Cluster) multiRPC(dests []peer.ID, svcName, svcMethod string, args inte
 		wg.Add(1)
 		go func(i int) {
 			defer wg.Done()
-			err := c.rpcClient.Call(
+			err := c.rpcClient.CallContext(
+				ctx,
 				dests[i],
 				svcName,
 				svcMethod,
@@ -778,6 +2045,55 @@ func (c *Cluster) multiRPC(dests []peer.ID, svcName, svcMethod string, args inte
 
 }
 
+// errPeerOffline labels the errors of peers which could not be asked
+// for the status and have stopped sending metrics.
+var errPeerOffline = errors.New("peer offline: its metrics have expired")
+
+// expiredPeers returns the cluster peers whose metrics have expired in
+// the leader's PeerMonitor, which are likely offline. This peer is
+// never among them. The result is reused for ExpiredPeersCacheTTL, so
+// that requests do not cost an extra RPC each. When the expired metrics
+// cannot be obtained, no peer is returned.
+func (c *Cluster) expiredPeers() map[peer.ID]bool {
+	c.expiredMux.Lock()
+	defer c.expiredMux.Unlock()
+	if c.expired != nil && time.Since(c.expiredAt) < ExpiredPeersCacheTTL {
+		return c.expired
+	}
+
+	l, err := c.consensus.Leader()
+	if err != nil {
+		return map[peer.ID]bool{}
+	}
+	var expired []peer.ID
+	err = c.rpcClient.Call(l,
+		"Cluster", "PeerMonitorExpiredPeers",
+		c.informer.Name(),
+		&expired)
+	if err != nil {
+		logger.Warningf("could not obtain expired metrics: %s", err)
+		return map[peer.ID]bool{}
+	}
+	c.expired = make(map[peer.ID]bool, len(expired))
+	for _, p := range expired {
+		if p != c.id {
+			c.expired[p] = true
+		}
+	}
+	c.expiredAt = time.Now()
+	return c.expired
+}
+
+// peerStatusError returns the message for the error obtained asking
+// a peer for the status, labelled as offline when its metrics have
+// expired.
+func peerStatusError(expired map[peer.ID]bool, p peer.ID, err error) string {
+	if expired[p] {
+		return fmt.Sprintf("%s: %s", errPeerOffline, err)
+	}
+	return err.Error()
+}
+
 func (c *Cluster) globalPinInfoCid(method string, h *cid.Cid) (api.GlobalPinInfo, error) {
 	pin := api.GlobalPinInfo{
 		Cid:     h,
@@ -785,11 +2101,12 @@ func (c *Cluster) globalPinInfoCid(method string, h *cid.Cid) (api.GlobalPinInfo
 	}
 
 	members := c.peerManager.peers()
+	expired := c.expiredPeers()
 	replies := make([]api.PinInfoSerial, len(members), len(members))
 	arg := api.CidArg{
 		Cid: h,
 	}
-	errs := c.multiRPC(members,
+	errs := c.multiRPC(c.ctx, members,
 		"Cluster",
 		method, arg.ToSerial(),
 		copyPinInfoSerialToIfaces(replies))
@@ -805,7 +2122,7 @@ func (c *Cluster) globalPinInfoCid(method string, h *cid.Cid) (api.GlobalPinInfo
 					Peer:   members[i],
 					Status: api.TrackerStatusClusterError,
 					TS:     time.Now(),
-					Error:  e.Error(),
+					Error:  peerStatusError(expired, members[i], e),
 				}
 			} else {
 				r.Error = e.Error()
@@ -814,18 +2131,38 @@ func (c *Cluster) globalPinInfoCid(method string, h *cid.Cid) (api.GlobalPinInfo
 		pin.PeerMap[members[i]] = r
 	}
 
+	if st, err := c.consensus.State(); err == nil && st.Has(h) {
+		c.setPinDetails(&pin, st.Get(h))
+	}
 	return pin, nil
 }
 
-func (c *Cluster) globalPinInfoSlice(method string) ([]api.GlobalPinInfo, error) {
+// globalPinInfoOp runs a cancellable operation (see opContexts) in all
+// the cluster peers. When ctx is cancelled, the peers are told to stop.
+func (c *Cluster) globalPinInfoOp(ctx context.Context, method string) ([]api.GlobalPinInfo, error) {
+	id := newOpID()
+	infos, err := c.globalPinInfoSlice(ctx, method, id)
+	if ctx.Err() != nil {
+		go func() {
+			members := c.peerManager.peers()
+			replies := make([]struct{}, len(members), len(members))
+			c.multiRPC(c.ctx, members, "Cluster", "CancelOp", id,
+				copyEmptyStructToIfaces(replies))
+		}()
+	}
+	return infos, err
+}
+
+func (c *Cluster) globalPinInfoSlice(ctx context.Context, method string, arg interface{}) ([]api.GlobalPinInfo, error) {
 	var infos []api.GlobalPinInfo
 	fullMap := make(map[string]api.GlobalPinInfo)
 
 	members := c.peerManager.peers()
+	expired := c.expiredPeers()
 	replies := make([][]api.PinInfoSerial, len(members), len(members))
-	errs := c.multiRPC(members,
+	errs := c.multiRPC(ctx, members,
 		"Cluster",
-		method, struct{}{},
+		method, arg,
 		copyPinInfoSerialSliceToIfaces(replies))
 
 	mergePins := func(pins []api.PinInfoSerial) {
@@ -849,7 +2186,7 @@ func (c *Cluster) globalPinInfoSlice(method string) ([]api.GlobalPinInfo, error)
 	for i, r := range replies {
 		if e := errs[i]; e != nil { // This error must come from not being able to contact that cluster member
 			logger.Errorf("%s: error in broadcast response from %s: %s ", c.id, members[i], e)
-			erroredPeers[members[i]] = e.Error()
+			erroredPeers[members[i]] = peerStatusError(expired, members[i], e)
 		} else {
 			mergePins(r)
 		}
@@ -869,7 +2206,11 @@ func (c *Cluster) globalPinInfoSlice(method string) ([]api.GlobalPinInfo, error)
 		}
 	}
 
+	st, stErr := c.consensus.State()
 	for _, v := range fullMap {
+		if stErr == nil && st.Has(v.Cid) {
+			c.setPinDetails(&v, st.Get(v.Cid))
+		}
 		infos = append(infos, v)
 	}
 
@@ -889,10 +2230,51 @@ func (c *Cluster) getIDForPeer(pid peer.ID) (api.ID, error) {
 }
 
 // allocate finds peers to allocate a hash using the informer and the monitor
-// it should only be used with a positive replication factor
-func (c *Cluster) allocate(hash *cid.Cid) ([]peer.ID, error) {
-	if c.config.ReplicationFactor <= 0 {
-		return nil, errors.New("cannot decide allocation for replication factor <= 0")
+// it should only be used with positive replication factors. It returns
+// as many new peers as available so that the hash is allocated to at
+// most rplMax peers, and fails when rplMin cannot be reached. The
+// excluded peers are neither chosen nor counted as current allocations.
+func (c *Cluster) allocate(hash *cid.Cid, rplMin, rplMax int, exclude ...peer.ID) ([]peer.ID, error) {
+	preview, err := c.previewAllocation(hash, rplMin, rplMax, exclude...)
+	if err != nil {
+		return nil, err
+	}
+	return preview.Allocations, nil
+}
+
+// allocateWithCurrent returns the full allocations of a hash which is
+// being pinned, possibly again: the peers already holding it with
+// valid metrics, followed by the new peers picked by allocate. A hash
+// which is already correctly allocated keeps its current allocations,
+// so that re-pinning it to change other options does not fail.
+func (c *Cluster) allocateWithCurrent(hash *cid.Cid, rplMin, rplMax int) ([]peer.ID, error) {
+	preview, err := c.previewAllocation(hash, rplMin, rplMax)
+	if err != nil && err != errAlreadyAllocated {
+		return nil, err
+	}
+	allocs := make([]peer.ID, 0, len(preview.Current)+len(preview.Allocations))
+	for _, m := range preview.Current {
+		allocs = append(allocs, m.Peer)
+	}
+	return append(allocs, preview.Allocations...), nil
+}
+
+// errAlreadyAllocated is returned by previewAllocation when the current
+// allocations of a hash already reach its maximum replication factor.
+var errAlreadyAllocated = errors.New("CID is already correctly allocated")
+
+// previewAllocation decides where to allocate a hash like allocate
+// does, and returns the metrics which drove the decision. When the
+// metrics do not allow to allocate the hash, the error is returned and
+// also set in the preview.
+func (c *Cluster) previewAllocation(hash *cid.Cid, rplMin, rplMax int, exclude ...peer.ID) (api.AllocationPreview, error) {
+	preview := api.AllocationPreview{
+		Cid:                  hash,
+		ReplicationFactorMin: rplMin,
+		ReplicationFactorMax: rplMax,
+	}
+	if rplMin <= 0 || rplMax <= 0 {
+		return preview, errors.New("cannot decide allocation for replication factor <= 0")
 	}
 
 	// Figure out who is currently holding this
@@ -912,14 +2294,18 @@ func (c *Cluster) allocate(hash *cid.Cid) ([]peer.ID, error) {
 	clusterPeers := c.peerManager.peers()
 	metricsMap := make(map[peer.ID]api.Metric)
 	for _, cp := range clusterPeers {
+		if peerIn(exclude, cp) {
+			continue
+		}
 		metricsMap[cp] = api.Metric{Valid: false}
 	}
 
 	// Request latest metrics logged by informers from the leader
 	metricName := c.informer.Name()
+	preview.MetricName = metricName
 	l, err := c.consensus.Leader()
 	if err != nil {
-		return nil, errors.New("cannot determine leading Monitor")
+		return preview, errors.New("cannot determine leading Monitor")
 	}
 	var metrics []api.Metric
 	err = c.rpcClient.Call(l,
@@ -927,7 +2313,7 @@ func (c *Cluster) allocate(hash *cid.Cid) ([]peer.ID, error) {
 		metricName,
 		&metrics)
 	if err != nil {
-		return nil, err
+		return preview, err
 	}
 
 	// put metrics in the metricsMap if they belong to a current clusterPeer
@@ -955,34 +2341,88 @@ func (c *Cluster) allocate(hash *cid.Cid) ([]peer.ID, error) {
 			continue
 		}
 		currentlyAllocatedPeersMetrics[p] = m
+		preview.Current = append(preview.Current, m)
 		delete(metricsMap, p)
 
 	}
 
+	// From here on, the decision only depends on the metrics
+	decisionErr := func(err error) (api.AllocationPreview, error) {
+		preview.Error = err.Error()
+		return preview, err
+	}
+
 	// how many allocations do we need (note we will re-allocate if we did
 	// not receive good metrics for currently allocated peeers)
-	needed := c.config.ReplicationFactor - len(currentlyAllocatedPeersMetrics)
+	neededMin := rplMin - len(currentlyAllocatedPeersMetrics)
+	neededMax := rplMax - len(currentlyAllocatedPeersMetrics)
 
 	// if we are already good (note invalid metrics would trigger
 	// re-allocations as they are not included in currentAllocMetrics)
-	if needed <= 0 {
-		return nil, fmt.Errorf("CID is already correctly allocated to %s", currentlyAllocatedPeers)
+	if neededMax <= 0 {
+		return decisionErr(errAlreadyAllocated)
 	}
 
 	// Allocate is called with currentAllocMetrics which contains
 	// only currentlyAllocatedPeers when they have provided valid metrics.
 	candidateAllocs, err := c.allocator.Allocate(hash, currentlyAllocatedPeersMetrics, metricsMap)
 	if err != nil {
-		return nil, logError(err.Error())
+		return decisionErr(logError(err.Error()))
+	}
+	for _, p := range candidateAllocs {
+		preview.Candidates = append(preview.Candidates, metricsMap[p])
 	}
 
 	// we don't have enough peers to pin
-	if len(candidateAllocs) < needed {
-		err = logError("cannot find enough allocations for this CID: needed: %d. Got: %s",
-			needed, candidateAllocs)
-		return nil, err
+	if len(candidateAllocs) < neededMin {
+		if len(candidateAllocs) == 0 ||
+			c.config.AllocationOnInsufficientPeers != InsufficientPeersWarn {
+			err = api.NewError(503, "the minimum replication factor is %d but only %d healthy peers are available to pin this CID",
+				rplMin,
+				len(candidateAllocs)+len(currentlyAllocatedPeersMetrics))
+			logger.Error(err)
+			return decisionErr(err)
+		}
+		// with InsufficientPeersWarn we use what we have.
+		preview.Allocations = candidateAllocs
+		return preview, nil
+	}
+
+	// return as many as possible within the range
+	if len(candidateAllocs) > neededMax {
+		candidateAllocs = candidateAllocs[0:neededMax]
 	}
+	preview.Allocations = candidateAllocs
+	return preview, nil
+}
+
+// AllocationPreview shows where the given Cid would be allocated with
+// the given replication factor, using the metrics currently known to
+// the Cluster, along with those metrics. Nothing is pinned. When the
+// replication factor is 0, the one from the configuration is used.
+//
+// Reasons preventing the allocation, like the lack of healthy peers,
+// are reported in the Error field of the preview.
+func (c *Cluster) AllocationPreview(h *cid.Cid, replication int) (api.AllocationPreview, error) {
+	if replication == 0 {
+		replication = c.config.ReplicationFactor
+	}
+	if replication < 0 {
+		return api.AllocationPreview{}, api.NewError(400,
+			"nothing to allocate: pins with a replication factor of -1 are pinned everywhere")
+	}
+	preview, err := c.previewAllocation(h, replication, replication)
+	if err != nil && preview.Error == "" {
+		return api.AllocationPreview{}, err
+	}
+	return preview, nil
+}
+
+// globalPinInfosByCid sorts GlobalPinInfos by their Cid string.
+type globalPinInfosByCid []api.GlobalPinInfo
 
-	// return as many as needed
-	return candidateAllocs[0:needed], nil
+func (g globalPinInfosByCid) Len() int      { return len(g) }
+func (g globalPinInfosByCid) Swap(i, j int) { g[i], g[j] = g[j], g[i] }
+func (g globalPinInfosByCid) Less(i, j int) bool {
+	return g[i].Cid.String() < g[j].Cid.String()
 }
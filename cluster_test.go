@@ -2,7 +2,14 @@ package ipfscluster
 
 import (
 	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/ipfs/ipfs-cluster/allocator/numpinalloc"
 	"github.com/ipfs/ipfs-cluster/api"
@@ -12,6 +19,8 @@ import (
 
 	rpc "github.com/hsanjuan/go-libp2p-gorpc"
 	cid "github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-peer"
+	ma "github.com/multiformats/go-multiaddr"
 )
 
 type mockComponent struct {
@@ -45,7 +54,14 @@ func (ipfs *mockConnector) ID() (api.IPFSID, error) {
 	}, nil
 }
 
-func (ipfs *mockConnector) Pin(c *cid.Cid) error {
+func (ipfs *mockConnector) Pin(c *cid.Cid, pinType api.PinType) error {
+	if ipfs.returnError {
+		return errors.New("")
+	}
+	return nil
+}
+
+func (ipfs *mockConnector) PinPresent(c *cid.Cid, pinType api.PinType) error {
 	if ipfs.returnError {
 		return errors.New("")
 	}
@@ -74,14 +90,51 @@ func (ipfs *mockConnector) PinLs(filter string) (map[string]api.IPFSPinStatus, e
 	return m, nil
 }
 
+func (ipfs *mockConnector) Verify(c *cid.Cid) error {
+	if ipfs.returnError {
+		return errors.New("")
+	}
+	return nil
+}
+
+func (ipfs *mockConnector) Resolve(path string) (*cid.Cid, error) {
+	if ipfs.returnError {
+		return nil, errors.New("")
+	}
+	return cid.Decode(test.TestCid1)
+}
+
+func (ipfs *mockConnector) Add(data io.Reader) (*cid.Cid, error) {
+	if ipfs.returnError {
+		return nil, errors.New("")
+	}
+	return cid.Decode(test.TestCid1)
+}
+
+func (ipfs *mockConnector) RepoStat() (api.IPFSRepoStat, error) {
+	if ipfs.returnError {
+		return api.IPFSRepoStat{}, errors.New("")
+	}
+	return api.IPFSRepoStat{RepoSize: 1000, StorageMax: 5000}, nil
+}
+
+func (ipfs *mockConnector) BandwidthStats() (api.IPFSBandwidth, error) {
+	if ipfs.returnError {
+		return api.IPFSBandwidth{}, errors.New("")
+	}
+	return api.IPFSBandwidth{TotalIn: 100, TotalOut: 200}, nil
+}
+
 func testingCluster(t *testing.T) (*Cluster, *mockAPI, *mockConnector, *mapstate.MapState, *MapPinTracker) {
+	return testingClusterWithAllocator(t, testingConfig(), numpinalloc.NewAllocator())
+}
+
+func testingClusterWithAllocator(t *testing.T, cfg *Config, alloc PinAllocator) (*Cluster, *mockAPI, *mockConnector, *mapstate.MapState, *MapPinTracker) {
 	api := &mockAPI{}
 	ipfs := &mockConnector{}
-	cfg := testingConfig()
 	st := mapstate.NewMapState()
 	tracker := NewMapPinTracker(cfg)
 	mon := NewStdPeerMonitor(5)
-	alloc := numpinalloc.NewAllocator()
 	inf := numpin.NewInformer()
 
 	cl, err := NewCluster(
@@ -124,7 +177,7 @@ func TestClusterStateSync(t *testing.T) {
 	}
 
 	c, _ := cid.Decode(test.TestCid1)
-	err = cl.Pin(c)
+	_, err = cl.Pin(api.CidArgCid(c))
 	if err != nil {
 		t.Fatal("pin should have worked:", err)
 	}
@@ -143,6 +196,52 @@ func TestClusterStateSync(t *testing.T) {
 	}
 }
 
+func TestClusterSummary(t *testing.T) {
+	cl, _, _, _, _ := testingCluster(t)
+	defer cleanRaft()
+	defer cl.Shutdown()
+
+	c, _ := cid.Decode(test.TestCid1)
+	_, err := cl.Pin(api.CidArgCid(c))
+	if err != nil {
+		t.Fatal("pin should have worked:", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	s := cl.Summary()
+	if s.Pins != 1 || s.Peers != 1 || s.Version != Version {
+		t.Errorf("unexpected summary: %+v", s)
+	}
+	if s.Leader != peer.IDB58Encode(cl.id) {
+		t.Error("this peer should be the leader")
+	}
+	if s.Status["pinned"] != 1 || s.Status["pin_error"] != 0 {
+		t.Errorf("unexpected status counts: %v", s.Status)
+	}
+}
+
+func TestClusterLocalAllocations(t *testing.T) {
+	cl, _, _, _, _ := testingCluster(t)
+	defer cleanRaft()
+	defer cl.Shutdown()
+
+	if len(cl.LocalAllocations()) != 0 {
+		t.Fatal("expected no local allocations")
+	}
+
+	c, _ := cid.Decode(test.TestCid1)
+	_, err := cl.Pin(api.CidArgCid(c))
+	if err != nil {
+		t.Fatal("pin should have worked:", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	allocs := cl.LocalAllocations()
+	if len(allocs) != 1 || !allocs[0].Cid.Equals(c) {
+		t.Errorf("unexpected local allocations: %+v", allocs)
+	}
+}
+
 func TestClusterID(t *testing.T) {
 	cl, _, _, _, _ := testingCluster(t)
 	defer cleanRaft()
@@ -168,38 +267,739 @@ func TestClusterPin(t *testing.T) {
 	defer cl.Shutdown()
 
 	c, _ := cid.Decode(test.TestCid1)
-	err := cl.Pin(c)
+	_, err := cl.Pin(api.CidArgCid(c))
 	if err != nil {
 		t.Fatal("pin should have worked:", err)
 	}
 
 	// test an error case
 	cl.consensus.Shutdown()
-	err = cl.Pin(c)
+	_, err = cl.Pin(api.CidArgCid(c))
 	if err == nil {
 		t.Error("expected an error but things worked")
 	}
 }
 
+func TestClusterPinAllocations(t *testing.T) {
+	self := testingConfig().ID
+	p2 := test.TestPeerID2
+	p3 := test.TestPeerID3
+
+	testCases := []struct {
+		name      string
+		rf        int
+		rmin      int       // pin replication factor min
+		rmax      int       // pin replication factor max
+		invalid   []peer.ID // peers with invalid metrics
+		missing   []peer.ID // peers without metrics
+		warn      bool      // allocate on insufficient peers
+		expected  []peer.ID
+		expectErr bool
+	}{
+		{
+			name:     "replication factor 1",
+			rf:       1,
+			expected: []peer.ID{p3},
+		},
+		{
+			name:     "replication factor 3",
+			rf:       3,
+			expected: []peer.ID{p3, p2, self},
+		},
+		{
+			name:     "pin everywhere",
+			rf:       -1,
+			expected: nil,
+		},
+		{
+			name:      "not enough peers",
+			rf:        4,
+			expectErr: true,
+		},
+		{
+			name:     "not enough peers with warn policy",
+			rf:       4,
+			warn:     true,
+			expected: []peer.ID{p3, p2, self},
+		},
+		{
+			name:      "not enough peers with metrics",
+			rf:        3,
+			missing:   []peer.ID{p2},
+			expectErr: true,
+		},
+		{
+			name:     "peers with invalid metrics are excluded",
+			rf:       2,
+			invalid:  []peer.ID{p3},
+			expected: []peer.ID{p2, self},
+		},
+		{
+			name:     "replication range allocates up to the maximum",
+			rf:       1,
+			rmin:     1,
+			rmax:     2,
+			expected: []peer.ID{p3, p2},
+		},
+		{
+			name:     "replication range allocates all available peers",
+			rf:       1,
+			rmin:     2,
+			rmax:     5,
+			expected: []peer.ID{p3, p2, self},
+		},
+		{
+			name:      "replication range minimum not reached",
+			rf:        1,
+			rmin:      4,
+			rmax:      5,
+			expectErr: true,
+		},
+		{
+			name:      "replication range minimum larger than maximum",
+			rf:        1,
+			rmin:      3,
+			rmax:      2,
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := testingConfig()
+			cfg.ReplicationFactor = tc.rf
+			if tc.warn {
+				cfg.AllocationOnInsufficientPeers = InsufficientPeersWarn
+			}
+			alloc := test.NewMockAllocator(p3, p2, self)
+			cl, _, _, _, _ := testingClusterWithAllocator(t, cfg, alloc)
+			defer cleanRaft()
+			defer cl.Shutdown()
+
+			for _, p := range []peer.ID{p2, p3} {
+				addr, _ := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/10000/ipfs/" + p.Pretty())
+				cl.peerManager.addPeer(addr)
+			}
+
+			for _, p := range []peer.ID{self, p2, p3} {
+				if containsPeer(tc.missing, p) {
+					continue
+				}
+				m := api.Metric{
+					Name:  numpin.MetricName,
+					Peer:  p,
+					Value: "0",
+					Valid: !containsPeer(tc.invalid, p),
+				}
+				m.SetTTL(60)
+				cl.monitor.LogMetric(m)
+			}
+
+			c, _ := cid.Decode(test.TestCid1)
+			carg := api.CidArgCid(c)
+			carg.ReplicationFactorMin = tc.rmin
+			carg.ReplicationFactorMax = tc.rmax
+			_, err := cl.Pin(carg)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("pin should have worked:", err)
+			}
+
+			st, err := cl.consensus.State()
+			if err != nil {
+				t.Fatal(err)
+			}
+			carg = st.Get(c)
+			if len(carg.Allocations) != len(tc.expected) {
+				t.Fatalf("expected allocations %s but got %s", tc.expected, carg.Allocations)
+			}
+			for i, p := range tc.expected {
+				if carg.Allocations[i] != p {
+					t.Errorf("expected allocations %s but got %s", tc.expected, carg.Allocations)
+				}
+			}
+			rmin := tc.rf
+			if tc.rmin != 0 {
+				rmin = tc.rmin
+			}
+			if carg.UnderReplicated != (rmin > len(tc.expected)) {
+				t.Error("unexpected under-replicated flag:", carg.UnderReplicated)
+			}
+			if tc.rf < 0 && !carg.Everywhere {
+				t.Error("expected the pin to be allocated everywhere")
+			}
+		})
+	}
+}
+
+func TestClusterAllocationPreview(t *testing.T) {
+	self := testingConfig().ID
+	p2 := test.TestPeerID2
+	p3 := test.TestPeerID3
+
+	cfg := testingConfig()
+	cfg.ReplicationFactor = 2
+	alloc := test.NewMockAllocator(p3, p2, self)
+	cl, _, _, _, _ := testingClusterWithAllocator(t, cfg, alloc)
+	defer cleanRaft()
+	defer cl.Shutdown()
+
+	for _, p := range []peer.ID{p2, p3} {
+		addr, _ := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/10000/ipfs/" + p.Pretty())
+		cl.peerManager.addPeer(addr)
+	}
+	for i, p := range []peer.ID{self, p2, p3} {
+		m := api.Metric{
+			Name:  numpin.MetricName,
+			Peer:  p,
+			Value: strconv.Itoa(i),
+			Valid: true,
+		}
+		m.SetTTL(60)
+		cl.monitor.LogMetric(m)
+	}
+
+	c, _ := cid.Decode(test.TestCid1)
+	preview, err := cl.AllocationPreview(c, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preview.MetricName != numpin.MetricName || preview.ReplicationFactorMax != 2 {
+		t.Errorf("unexpected preview: %+v", preview)
+	}
+	if len(preview.Candidates) != 3 ||
+		preview.Candidates[0].Peer != p3 || preview.Candidates[0].Value != "2" {
+		t.Errorf("unexpected candidates: %+v", preview.Candidates)
+	}
+	if len(preview.Allocations) != 2 ||
+		preview.Allocations[0] != p3 || preview.Allocations[1] != p2 {
+		t.Errorf("unexpected allocations: %s", preview.Allocations)
+	}
+	if len(cl.Pins()) != 0 {
+		t.Error("a preview should not pin anything")
+	}
+
+	preview, err = cl.AllocationPreview(c, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preview.Error == "" || len(preview.Allocations) != 0 {
+		t.Error("expected a preview error with not enough peers")
+	}
+
+	_, err = cl.AllocationPreview(c, -1)
+	if code, _ := api.ErrorCode(err); code != 400 {
+		t.Error("expected a 400 error for a negative replication factor:", err)
+	}
+}
+
+func TestClusterReallocateFromPeer(t *testing.T) {
+	self := testingConfig().ID
+	p2 := test.TestPeerID2
+	p3 := test.TestPeerID3
+
+	cfg := testingConfig()
+	cfg.ReplicationFactor = 2
+	alloc := test.NewMockAllocator(p3, p2, self)
+	cl, _, _, _, _ := testingClusterWithAllocator(t, cfg, alloc)
+	defer cleanRaft()
+	defer cl.Shutdown()
+
+	for _, p := range []peer.ID{p2, p3} {
+		addr, _ := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/10000/ipfs/" + p.Pretty())
+		cl.peerManager.addPeer(addr)
+	}
+	for _, p := range []peer.ID{self, p2, p3} {
+		m := api.Metric{
+			Name:  numpin.MetricName,
+			Peer:  p,
+			Value: "0",
+			Valid: true,
+		}
+		m.SetTTL(60)
+		cl.monitor.LogMetric(m)
+	}
+
+	c1, _ := cid.Decode(test.TestCid1)
+	c2, _ := cid.Decode(test.TestCid2)
+	_, err := cl.Pin(api.CidArgCid(c1)) // allocated to p3, p2
+	if err != nil {
+		t.Fatal(err)
+	}
+	carg := api.CidArgCid(c2)
+	carg.ReplicationFactorMin = 3
+	carg.ReplicationFactorMax = 3
+	_, err = cl.Pin(carg) // allocated to p3, p2, self
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = cl.reallocateFromPeer(p3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := cl.consensus.State()
+	if err != nil {
+		t.Fatal(err)
+	}
+	carg = st.Get(c1)
+	if len(carg.Allocations) != 2 || peerIn(carg.Allocations, p3) ||
+		!peerIn(carg.Allocations, p2) || !peerIn(carg.Allocations, self) {
+		t.Errorf("unexpected allocations for %s: %s", c1, carg.Allocations)
+	}
+	if carg.UnderReplicated {
+		t.Error("expected a replacement peer to be found")
+	}
+
+	carg = st.Get(c2)
+	if len(carg.Allocations) != 2 || peerIn(carg.Allocations, p3) {
+		t.Errorf("unexpected allocations for %s: %s", c2, carg.Allocations)
+	}
+	if !carg.UnderReplicated {
+		t.Error("expected the pin to be under-replicated")
+	}
+}
+
+// recordingAllocator is a MockAllocator which records the Cids it is
+// asked to allocate, in order.
+type recordingAllocator struct {
+	*test.MockAllocator
+	mu   sync.Mutex
+	cids []string
+}
+
+func (alloc *recordingAllocator) Allocate(c *cid.Cid, current, candidates map[peer.ID]api.Metric) ([]peer.ID, error) {
+	alloc.mu.Lock()
+	alloc.cids = append(alloc.cids, c.String())
+	alloc.mu.Unlock()
+	return alloc.MockAllocator.Allocate(c, current, candidates)
+}
+
+func (alloc *recordingAllocator) reset() []string {
+	alloc.mu.Lock()
+	defer alloc.mu.Unlock()
+	cids := alloc.cids
+	alloc.cids = nil
+	return cids
+}
+
+func TestClusterCheckAllocations(t *testing.T) {
+	self := testingConfig().ID
+	p2 := test.TestPeerID2
+	p3 := test.TestPeerID3
+
+	cfg := testingConfig()
+	cfg.ReplicationFactor = 2
+	cfg.ReallocateUnknownAllocations = false
+	alloc := &recordingAllocator{MockAllocator: test.NewMockAllocator(p3, p2, self)}
+	cl, _, _, _, _ := testingClusterWithAllocator(t, cfg, alloc)
+	defer cleanRaft()
+	defer cl.Shutdown()
+
+	for _, p := range []peer.ID{p2, p3} {
+		addr, _ := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/10000/ipfs/" + p.Pretty())
+		cl.peerManager.addPeer(addr)
+	}
+	for _, p := range []peer.ID{self, p2, p3} {
+		m := api.Metric{
+			Name:  numpin.MetricName,
+			Peer:  p,
+			Value: "0",
+			Valid: true,
+		}
+		m.SetTTL(60)
+		cl.monitor.LogMetric(m)
+	}
+
+	// Both allocated to p3, p2. Without p3, c1 falls below its
+	// minimum replication factor while c2 does not.
+	c1, _ := cid.Decode(test.TestCid1)
+	c2, _ := cid.Decode(test.TestCid2)
+	carg := api.CidArgCid(c2)
+	carg.ReplicationFactorMin = 1
+	carg.ReplicationFactorMax = 2
+	_, err := cl.Pin(carg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cl.Pin(api.CidArgCid(c1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	alloc.reset()
+
+	cl.peerManager.rmPeer(p3, false)
+
+	cl.checkAllocations()
+	if cids := alloc.reset(); len(cids) != 0 {
+		t.Errorf("nothing should be re-allocated unless configured: %s", cids)
+	}
+	st, _ := cl.consensus.State()
+	for _, c := range []*cid.Cid{c1, c2} {
+		if !peerIn(st.Get(c).Allocations, p3) {
+			t.Errorf("the allocations of %s should not have changed", c)
+		}
+	}
+
+	cl.config.ReallocateUnknownAllocations = true
+	cl.checkAllocations()
+	cids := alloc.reset()
+	if len(cids) != 2 || cids[0] != c1.String() || cids[1] != c2.String() {
+		t.Errorf("expected the pin below its minimum to be re-allocated first: %s", cids)
+	}
+
+	st, _ = cl.consensus.State()
+	for _, c := range []*cid.Cid{c1, c2} {
+		carg := st.Get(c)
+		if len(carg.Allocations) != 2 || peerIn(carg.Allocations, p3) ||
+			!peerIn(carg.Allocations, p2) || !peerIn(carg.Allocations, self) {
+			t.Errorf("unexpected allocations for %s: %s", c, carg.Allocations)
+		}
+		if carg.UnderReplicated {
+			t.Errorf("%s should not be under-replicated", c)
+		}
+	}
+
+	// Nothing left to re-allocate
+	cl.checkAllocations()
+	if cids := alloc.reset(); len(cids) != 0 {
+		t.Errorf("expected no re-allocations: %s", cids)
+	}
+}
+
+func TestClusterRepin(t *testing.T) {
+	self := testingConfig().ID
+	p2 := test.TestPeerID2
+	p3 := test.TestPeerID3
+
+	cfg := testingConfig()
+	cfg.ReplicationFactor = 2
+	alloc := test.NewMockAllocator(p3, p2, self)
+	cl, _, _, _, _ := testingClusterWithAllocator(t, cfg, alloc)
+	defer cleanRaft()
+	defer cl.Shutdown()
+
+	for _, p := range []peer.ID{p2, p3} {
+		addr, _ := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/10000/ipfs/" + p.Pretty())
+		cl.peerManager.addPeer(addr)
+	}
+	for _, p := range []peer.ID{self, p2, p3} {
+		m := api.Metric{
+			Name:  numpin.MetricName,
+			Peer:  p,
+			Value: "0",
+			Valid: true,
+		}
+		m.SetTTL(60)
+		cl.monitor.LogMetric(m)
+	}
+
+	c, _ := cid.Decode(test.TestCid1)
+	carg := api.CidArgCid(c)
+	carg.Name = "first"
+	_, err := cl.Pin(carg) // allocated to p3, p2
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Prefer other peers now, so that a re-allocation would show.
+	alloc.Order = []peer.ID{self, p2, p3}
+	carg = api.CidArgCid(c)
+	carg.Name = "second"
+	carg.Metadata = map[string]string{"owner": "alice"}
+	_, err = cl.Pin(carg)
+	if err != nil {
+		t.Fatal("re-pinning should work:", err)
+	}
+
+	st, _ := cl.consensus.State()
+	carg = st.Get(c)
+	if len(carg.Allocations) != 2 || carg.Allocations[0] != p3 || carg.Allocations[1] != p2 {
+		t.Errorf("the current allocations should be kept: %s", carg.Allocations)
+	}
+	if carg.UnderReplicated {
+		t.Error("the pin should not be under-replicated")
+	}
+	if carg.Name != "second" || carg.Metadata["owner"] != "alice" {
+		t.Errorf("the new name and metadata should be set: %s %v", carg.Name, carg.Metadata)
+	}
+
+	// Raising the replication factor adds peers to the current ones
+	carg = api.CidArgCid(c)
+	carg.ReplicationFactorMin = 3
+	carg.ReplicationFactorMax = 3
+	_, err = cl.Pin(carg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, _ = cl.consensus.State()
+	carg = st.Get(c)
+	if len(carg.Allocations) != 3 || carg.Allocations[0] != p3 ||
+		carg.Allocations[1] != p2 || carg.Allocations[2] != self {
+		t.Errorf("expected a new peer after the current ones: %s", carg.Allocations)
+	}
+	if carg.UnderReplicated {
+		t.Error("the pin should not be under-replicated")
+	}
+}
+
 func TestClusterUnpin(t *testing.T) {
 	cl, _, _, _, _ := testingCluster(t)
 	defer cleanRaft()
 	defer cl.Shutdown()
 
 	c, _ := cid.Decode(test.TestCid1)
-	err := cl.Unpin(c)
+	err := cl.Unpin(api.CidArgCid(c))
 	if err != nil {
 		t.Fatal("pin should have worked:", err)
 	}
 
 	// test an error case
 	cl.consensus.Shutdown()
-	err = cl.Unpin(c)
+	err = cl.Unpin(api.CidArgCid(c))
 	if err == nil {
 		t.Error("expected an error but things worked")
 	}
 }
 
+func TestClusterProtect(t *testing.T) {
+	cl, _, _, _, _ := testingCluster(t)
+	defer cleanRaft()
+	defer cl.Shutdown()
+
+	c, _ := cid.Decode(test.TestCid1)
+	_, err := cl.Pin(api.CidArg{Cid: c, Protected: true})
+	if err != nil {
+		t.Fatal("pin should have worked:", err)
+	}
+	delay()
+
+	// Re-pinning keeps the protection
+	_, err = cl.Pin(api.CidArgCid(c))
+	if err != nil {
+		t.Fatal("pin should have worked:", err)
+	}
+	delay()
+
+	if err := cl.Unpin(api.CidArgCid(c)); err != errPinProtected {
+		t.Error("expected an error unpinning a protected pin:", err)
+	}
+	if err := cl.ForceUnpin(c); err != errPinProtected {
+		t.Error("expected an error force-unpinning a protected pin:", err)
+	}
+	if test.ErrProtected.Error() != errPinProtected.Error() {
+		t.Error("the mocked error should match errPinProtected")
+	}
+
+	err = cl.Protect(c, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delay()
+	err = cl.Unpin(api.CidArgCid(c))
+	if err != nil {
+		t.Error("unprotected pins should be unpinned:", err)
+	}
+}
+
+func TestClusterForceUnpin(t *testing.T) {
+	cl, _, _, _, _ := testingCluster(t)
+	defer cleanRaft()
+	defer cl.Shutdown()
+
+	c, _ := cid.Decode(test.TestCid1)
+	_, err := cl.Pin(api.CidArg{Cid: c, Namespace: "a"})
+	if err != nil {
+		t.Fatal("pin should have worked:", err)
+	}
+
+	// Force unpins work across namespaces
+	err = cl.ForceUnpin(c)
+	if err != nil {
+		t.Fatal("force unpin should have worked:", err)
+	}
+	delay()
+	for _, p := range cl.Pins() {
+		if p.Cid.Equals(c) {
+			t.Error("cid should have been removed from the state")
+		}
+	}
+
+	// Works on Cids which are not pinned
+	c2, _ := cid.Decode(test.TestCid2)
+	err = cl.ForceUnpin(c2)
+	if err != nil {
+		t.Fatal("force unpin should have worked:", err)
+	}
+
+	// All peers confirmed, nothing pending
+	cl.removalsMux.Lock()
+	pending := len(cl.pendingRemovals)
+	cl.removalsMux.Unlock()
+	if pending != 0 {
+		t.Error("expected no pending removals")
+	}
+}
+
+func TestClusterReplicationFactors(t *testing.T) {
+	cl := &Cluster{config: &Config{ReplicationFactor: 3}}
+	testcases := []struct {
+		min, max       int
+		rfMin, rfMax   int
+		everywhereConf bool
+	}{
+		{0, 0, 3, 3, false},
+		{2, 5, 2, 5, false},
+		{2, 0, 2, 3, false},
+		{4, 0, 4, 4, false},
+		{0, 5, 3, 5, false},
+		{0, 2, 2, 2, false},
+		{-1, 0, -1, -1, false},
+		{0, -1, 3, -1, false},
+		{2, 0, 2, -1, true},
+		{0, 4, 4, 4, true},
+	}
+	for _, tc := range testcases {
+		cl.config.ReplicationFactor = 3
+		if tc.everywhereConf {
+			cl.config.ReplicationFactor = -1
+		}
+		rplMin, rplMax := cl.replicationFactors(api.CidArg{
+			ReplicationFactorMin: tc.min,
+			ReplicationFactorMax: tc.max,
+		})
+		if rplMin != tc.rfMin || rplMax != tc.rfMax {
+			t.Errorf("%+v: got %d and %d", tc, rplMin, rplMax)
+		}
+	}
+}
+
+func TestClusterPendingRemovals(t *testing.T) {
+	cl, _, _, _, _ := testingCluster(t)
+	defer cleanRaft()
+	defer cl.Shutdown()
+
+	c, _ := cid.Decode(test.TestCid1)
+	c2, _ := cid.Decode(test.TestCid2)
+	_, err := cl.Pin(api.CidArgCid(c2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	delay()
+
+	cl.removalsMux.Lock()
+	cl.pendingRemovals[c.String()] = []peer.ID{test.TestPeerID2}
+	cl.pendingRemovals[c2.String()] = []peer.ID{test.TestPeerID2}
+	cl.removalsMux.Unlock()
+
+	// Pinned again after the removal
+	cl.retryRemovals()
+	cl.removalsMux.Lock()
+	_, ok := cl.pendingRemovals[c2.String()]
+	cl.removalsMux.Unlock()
+	if ok {
+		t.Error("removals of pinned Cids should be dropped")
+	}
+
+	_, err = cl.Pin(api.CidArgCid(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl.removalsMux.Lock()
+	_, ok = cl.pendingRemovals[c.String()]
+	cl.removalsMux.Unlock()
+	if ok {
+		t.Error("pinning should drop the pending removal")
+	}
+}
+
+func TestClusterSaveRemovals(t *testing.T) {
+	dir, err := ioutil.TempDir("", "removals")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cl := &Cluster{
+		config:          &Config{ConsensusDataFolder: dir},
+		pendingRemovals: map[string][]peer.ID{test.TestCid1: {test.TestPeerID1, test.TestPeerID2}},
+	}
+	cl.saveRemovals()
+
+	cl2 := &Cluster{
+		config:          cl.config,
+		pendingRemovals: make(map[string][]peer.ID),
+	}
+	if err := cl2.loadRemovals(); err != nil {
+		t.Fatal(err)
+	}
+	peers := cl2.pendingRemovals[test.TestCid1]
+	if len(peers) != 2 || peers[0] != test.TestPeerID1 || peers[1] != test.TestPeerID2 {
+		t.Error("unexpected pending removals:", cl2.pendingRemovals)
+	}
+
+	cl2.pendingRemovals = make(map[string][]peer.ID)
+	cl2.saveRemovals()
+	if _, err := os.Stat(filepath.Join(dir, removalsFile)); !os.IsNotExist(err) {
+		t.Error("the file should be removed when there are no pending removals")
+	}
+}
+
+func TestClusterNamespaces(t *testing.T) {
+	cl, _, _, _, _ := testingCluster(t)
+	defer cleanRaft()
+	defer cl.Shutdown()
+
+	c, _ := cid.Decode(test.TestCid1)
+	_, err := cl.Pin(api.CidArg{Cid: c, Namespace: "a"})
+	if err != nil {
+		t.Fatal("pin should have worked:", err)
+	}
+
+	// Pins in other namespaces are left alone, and look the same as
+	// Cids which are not pinned at all.
+	_, err = cl.Pin(api.CidArg{Cid: c, Namespace: "b"})
+	if err != errNotInNamespace {
+		t.Error("expected errNotInNamespace pinning:", err)
+	}
+	res := cl.PinMany([]api.CidArg{{Cid: c, Namespace: "b"}})
+	if res[0].Error != errNotInNamespace.Error() {
+		t.Error("expected an error pinning many:", res[0].Error)
+	}
+	err = cl.Unpin(api.CidArgCid(c))
+	if err != errNotInNamespace {
+		t.Error("expected errNotInNamespace unpinning:", err)
+	}
+	err = cl.UnpinIfHealthy(api.CidArg{Cid: c, Namespace: "b"})
+	if err != errNotInNamespace {
+		t.Error("expected errNotInNamespace unpinning if healthy:", err)
+	}
+	if len(cl.PinsByNamespace("a")) != 1 || cl.Pins()[0].Namespace != "a" {
+		t.Error("the pin should still be in its namespace")
+	}
+
+	c2, _ := cid.Decode(test.TestCid2)
+	err = cl.Unpin(api.CidArg{Cid: c2, Namespace: "b"})
+	if err != errNotInNamespace {
+		t.Error("expected errNotInNamespace unpinning a Cid which is not pinned:", err)
+	}
+	err = cl.UnpinIfHealthy(api.CidArg{Cid: c2, Namespace: "b"})
+	if err != errNotInNamespace {
+		t.Error("expected errNotInNamespace unpinning a Cid which is not pinned if healthy:", err)
+	}
+
+	err = cl.Unpin(api.CidArg{Cid: c, Namespace: "a"})
+	if err != nil {
+		t.Error("unpin should have worked:", err)
+	}
+}
+
 func TestClusterPeers(t *testing.T) {
 	cl, _, _, _, _ := testingCluster(t)
 	defer cleanRaft()
@@ -213,6 +1013,83 @@ func TestClusterPeers(t *testing.T) {
 	}
 }
 
+func TestClusterReallocationDone(t *testing.T) {
+	allocated := map[peer.ID]bool{test.TestPeerID1: true}
+	gpi := api.GlobalPinInfo{
+		PeerMap: map[peer.ID]api.PinInfo{
+			test.TestPeerID1: {Status: api.TrackerStatusPinning},
+			test.TestPeerID2: {Status: api.TrackerStatusRemote},
+		},
+	}
+
+	done, err := reallocationDone(gpi, allocated)
+	if done || err != nil {
+		t.Error("reallocation should be in progress")
+	}
+
+	gpi.PeerMap[test.TestPeerID1] = api.PinInfo{Status: api.TrackerStatusPinned}
+	done, err = reallocationDone(gpi, allocated)
+	if !done || err != nil {
+		t.Error("reallocation should be done")
+	}
+
+	gpi.PeerMap[test.TestPeerID2] = api.PinInfo{Status: api.TrackerStatusUnpinError}
+	_, err = reallocationDone(gpi, allocated)
+	if err == nil {
+		t.Error("expected an error when a peer failed to unpin")
+	}
+}
+
+func TestClusterReallocate(t *testing.T) {
+	cl, _, _, _, _ := testingCluster(t)
+	defer cleanRaft()
+	defer cl.Shutdown()
+
+	c, _ := cid.Decode(test.TestCid1)
+	_, err := cl.Reallocate(c, []peer.ID{cl.id})
+	if err == nil {
+		t.Error("expected an error reallocating a Cid which is not pinned")
+	}
+
+	_, err = cl.Pin(api.CidArgCid(c))
+	if err != nil {
+		t.Fatal("pin should have worked:", err)
+	}
+
+	_, err = cl.Reallocate(c, []peer.ID{test.TestPeerID2})
+	if err == nil {
+		t.Error("expected an error reallocating to a non-cluster peer")
+	}
+
+	_, err = cl.Reallocate(c, []peer.ID{cl.id})
+	if err != nil {
+		t.Fatal("reallocate should have worked:", err)
+	}
+	st, _ := cl.consensus.State()
+	carg := st.Get(c)
+	if carg.Everywhere || len(carg.Allocations) != 1 || carg.Allocations[0] != cl.id {
+		t.Error("the new allocation should be in the state")
+	}
+}
+
+func TestClusterCheckClockSkew(t *testing.T) {
+	start := time.Now()
+	id := api.ID{
+		ID:   test.TestPeerID2,
+		Time: start.Add(11 * time.Second),
+	}
+	checkClockSkew(&id, start, 2*time.Second)
+	if id.ClockSkew != 10*time.Second {
+		t.Error("expected a 10s clock skew, got ", id.ClockSkew)
+	}
+
+	id = api.ID{ID: test.TestPeerID2}
+	checkClockSkew(&id, start, time.Second)
+	if id.ClockSkew != 0 {
+		t.Error("should not estimate skew without a peer time")
+	}
+}
+
 func TestVersion(t *testing.T) {
 	cl, _, _, _, _ := testingCluster(t)
 	defer cleanRaft()
@@ -221,3 +1098,12 @@ func TestVersion(t *testing.T) {
 		t.Error("bad Version()")
 	}
 }
+
+func containsPeer(peers []peer.ID, p peer.ID) bool {
+	for _, q := range peers {
+		if q == p {
+			return true
+		}
+	}
+	return false
+}
@@ -3,9 +3,13 @@ package ipfscluster
 import (
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/url"
+	"strings"
 	"sync"
+	"time"
 
 	crypto "github.com/libp2p/go-libp2p-crypto"
 	peer "github.com/libp2p/go-libp2p-peer"
@@ -14,13 +18,70 @@ import (
 
 // Default parameters for the configuration
 const (
-	DefaultConfigCrypto     = crypto.RSA
-	DefaultConfigKeyLength  = 2048
-	DefaultAPIAddr          = "/ip4/127.0.0.1/tcp/9094"
-	DefaultIPFSProxyAddr    = "/ip4/127.0.0.1/tcp/9095"
-	DefaultIPFSNodeAddr     = "/ip4/127.0.0.1/tcp/5001"
-	DefaultClusterAddr      = "/ip4/0.0.0.0/tcp/9096"
-	DefaultStateSyncSeconds = 60
+	DefaultConfigCrypto              = crypto.RSA
+	DefaultConfigKeyLength           = 2048
+	DefaultAPIAddr                   = "/ip4/127.0.0.1/tcp/9094"
+	DefaultIPFSProxyAddr             = "/ip4/127.0.0.1/tcp/9095"
+	DefaultIPFSNodeAddr              = "/ip4/127.0.0.1/tcp/5001"
+	DefaultClusterAddr               = "/ip4/0.0.0.0/tcp/9096"
+	DefaultMetricsAddr               = "/ip4/127.0.0.1/tcp/9097"
+	DefaultStateSyncSeconds          = 60
+	DefaultIPFSCheckSeconds          = 10
+	DefaultIPFSRequestTimeoutSeconds = 60
+	DefaultIPFSPinTimeoutSeconds     = 24 * 60 * 60
+	DefaultPinQueueHighWater         = 0.9
+	DefaultSyncAllBatchRatio         = 0.1
+	DefaultEvictionPolicy            = EvictionPolicyNone
+	DefaultDiskSpaceThresholdMB      = 1024
+	DefaultPinRetryMaxBackoffSeconds = 600
+	DefaultReadStrategy              = ReadStrategyRandom
+	DefaultIPFSConnector             = IPFSConnectorHTTP
+	DefaultRecoverAllConcurrency     = 10
+
+	DefaultAllocationOnInsufficientPeers = InsufficientPeersReject
+)
+
+// Policies for pins whose replication factor is larger than the
+// number of available peers. See Config.AllocationOnInsufficientPeers.
+const (
+	// InsufficientPeersReject makes the pin fail.
+	InsufficientPeersReject = "reject"
+	// InsufficientPeersWarn allocates the pin to the available peers,
+	// logs a warning and flags the pin as under-replicated.
+	InsufficientPeersWarn = "warn"
+)
+
+// Eviction policies. See Config.EvictionPolicy.
+const (
+	// EvictionPolicyNone never evicts pins. The cluster acts as
+	// permanent storage.
+	EvictionPolicyNone = "none"
+	// EvictionPolicyLRU unpins the least recently accessed pins once
+	// the cache capacity is exceeded.
+	EvictionPolicyLRU = "lru"
+)
+
+// Strategies to choose the peer which serves the content of a pin.
+// See Config.ReadStrategy.
+const (
+	// ReadStrategyRandom picks any of the allocated peers.
+	ReadStrategyRandom = "random"
+	// ReadStrategyLowestLatency picks the allocated peer which
+	// answers faster.
+	ReadStrategyLowestLatency = "lowest-latency"
+	// ReadStrategyLeastLoaded picks the allocated peer preferred by
+	// the PinAllocator according to the metrics of the Informer (i.e.
+	// fewer pins or more free space).
+	ReadStrategyLeastLoaded = "least-loaded"
+)
+
+// IPFS connectors. See Config.IPFSConnector.
+const (
+	// IPFSConnectorHTTP talks to the IPFS daemon through its HTTP API.
+	IPFSConnectorHTTP = "http"
+	// IPFSConnectorNull does not talk to IPFS at all. Pins and unpins
+	// succeed right away and nothing is reported as pinned.
+	IPFSConnectorNull = "null"
 )
 
 // Config represents an ipfs-cluster configuration. It is used by
@@ -59,9 +120,35 @@ type Config struct {
 	// connector component.
 	IPFSProxyAddr ma.Multiaddr
 
+	// Serve the IPFS Proxy from the API listener (under /api/v0/)
+	// rather than from IPFSProxyAddr.
+	IPFSProxyOnAPI bool
+
 	// Host/Port for the IPFS daemon.
 	IPFSNodeAddr ma.Multiaddr
 
+	// EnableMetrics makes the peer serve operational metrics, in the
+	// Prometheus text format, on MetricsAddr under /metrics.
+	EnableMetrics bool
+
+	// Listen parameters for the metrics endpoint.
+	MetricsAddr ma.Multiaddr
+
+	// Number of seconds between checks of the IPFS daemon, used to
+	// detect restarts. Used by the IPFS connector component.
+	IPFSCheckSeconds int
+
+	// IPFSRequestTimeoutSeconds is the maximum duration of the
+	// requests made by the IPFS connector to the daemon, except for
+	// pins, unpins, adds and verifications, which are limited by
+	// IPFSPinTimeoutSeconds.
+	IPFSRequestTimeoutSeconds int
+	IPFSPinTimeoutSeconds     int
+
+	// MaxPinSize is the maximum cumulative size in bytes of the items
+	// which the IPFS connector pins recursively. 0 means no limit.
+	MaxPinSize uint64
+
 	// Storage folder for snapshots, log store etc. Used by
 	// the Consensus component.
 	ConsensusDataFolder string
@@ -69,13 +156,160 @@ type Config struct {
 	// Number of seconds between StateSync() operations
 	StateSyncSeconds int
 
+	// Raft heartbeat and election timeouts in milliseconds. Larger
+	// values make leadership stickier on unstable networks, at the
+	// cost of slower failover. 0 uses the Raft defaults.
+	RaftHeartbeatTimeoutMs int
+	RaftElectionTimeoutMs  int
+
+	// Compress the state with gzip when taking Raft snapshots.
+	RaftCompressSnapshots bool
+
+	// Number of seconds to wait for a consensus leader before failing
+	// an operation. 0 uses LeaderTimeout.
+	LeaderTimeoutSeconds int
+
+	// Number of times a failed commit to the consensus log is
+	// attempted, and milliseconds to wait between attempts. 0 uses
+	// CommitRetries and CommitRetryDelay.
+	CommitRetries      int
+	CommitRetryDelayMs int
+
 	// ReplicationFactor is the number of copies we keep for each pin
 	ReplicationFactor int
 
+	// ReallocateUnknownAllocations makes the leader re-allocate pins
+	// which are allocated to peers which are no longer part of the
+	// Cluster. Otherwise, such pins are only logged.
+	ReallocateUnknownAllocations bool
+
+	// AllocationOnInsufficientPeers decides what happens to pins when
+	// there are fewer available peers than the ReplicationFactor.
+	AllocationOnInsufficientPeers string
+
+	// PinQueueSize is the maximum number of pins, and of unpins,
+	// waiting to be processed by the PinTracker.
+	PinQueueSize int
+
+	// PinWorkers and UnpinWorkers are the number of pin and unpin
+	// operations which the PinTracker sends to IPFS at the same time.
+	PinWorkers   int
+	UnpinWorkers int
+
+	// PinQueueHighWater is the fill ratio of the pin queue above which
+	// the REST API rejects new pins with 429 (Too Many Requests).
+	PinQueueHighWater float64
+
+	// StrictRequestBodies makes the REST API reject request bodies
+	// with fields it does not know about.
+	StrictRequestBodies bool
+
+	// APIAuthToken is the bearer token required by the REST API.
+	// APIBasicAuthCredentials maps user names to the passwords
+	// accepted with HTTP basic authentication. When neither is set,
+	// the REST API is open.
+	APIAuthToken            string
+	APIBasicAuthCredentials map[string]string
+
+	// APIAuthExemptHealth allows unauthenticated requests to /health,
+	// i.e. for load balancer checks.
+	APIAuthExemptHealth bool
+
+	// APITLSCertFile and APITLSKeyFile are the paths to the
+	// certificate and key used to serve the REST API over HTTPS. When
+	// empty, the API is served over plain HTTP.
+	APITLSCertFile string
+	APITLSKeyFile  string
+
+	// APICORSAllowedOrigins lists the origins from which browsers may
+	// call the REST API ("*" allows any). When empty, no CORS headers
+	// are sent. APICORSAllowedMethods and APICORSAllowedHeaders
+	// default to DefaultCORSAllowedMethods and
+	// DefaultCORSAllowedHeaders when empty.
+	APICORSAllowedOrigins []string
+	APICORSAllowedMethods []string
+	APICORSAllowedHeaders []string
+
+	// APIAccessLog enables logging every request to the REST API,
+	// along with its status and duration, at debug level.
+	APIAccessLog bool
+
+	// APIWriteRateLimit and APIReadRateLimit are the number of
+	// requests per second which each client, by IP address, can make
+	// to the REST API. Write requests are those which are not GET,
+	// HEAD or OPTIONS. 0 means no limit.
+	APIWriteRateLimit float64
+	APIReadRateLimit  float64
+
+	// SyncAllBatchRatio is the ratio of tracked items to IPFS pins
+	// below which SyncAll checks the tracked items one by one, rather
+	// than listing all the pins in the IPFS daemon. Negative values
+	// disable it.
+	SyncAllBatchRatio float64
+
+	// CacheCapacity is the maximum number of pins the Cluster holds
+	// when running with an eviction policy. 0 means no limit.
+	CacheCapacity int
+
+	// EvictionPolicy decides which pins are unpinned by the leader
+	// when CacheCapacity is exceeded.
+	EvictionPolicy string
+
+	// ReadStrategy decides which of the peers allocated to a pin
+	// should serve its content. See Cluster.ServingPeer.
+	ReadStrategy string
+
+	// DiskSpaceThresholdMB is the free space, in megabytes, in the
+	// ConsensusDataFolder below which the peer reports low disk space.
+	// A negative value disables the check.
+	DiskSpaceThresholdMB int
+
+	// ReadOnlyOnLowDiskSpace makes the peer reject new pins while
+	// the free disk space is below DiskSpaceThresholdMB.
+	ReadOnlyOnLowDiskSpace bool
+
+	// IPFSConnector selects the IPFSConnector component: "http" or
+	// "null" (see NullConnector).
+	IPFSConnector string
+
+	// PinningServiceEndpoint is the URL of an IPFS Pinning Service API.
+	// When set, pins are delegated to this service rather than to the
+	// IPFS daemon. Used by the PinningServiceConnector component.
+	PinningServiceEndpoint string
+
+	// PinningServiceToken is the access token for the pinning service.
+	PinningServiceToken string
+
+	// PinErrorWebhook is a URL to which the PinTracker POSTs the
+	// PinInfo of items entering the PinError or UnpinError states.
+	PinErrorWebhook string
+
+	// PersistTrackerState makes the PinTracker save the status of
+	// the tracked items in the ConsensusDataFolder and load it on
+	// start, so that it is not lost on restarts.
+	PersistTrackerState bool
+
+	// PinRetryMaxAttempts enables retrying failed pins and unpins
+	// automatically, with an exponential backoff, until they have
+	// been attempted this many times. 0 disables retries.
+	PinRetryMaxAttempts int
+
+	// Maximum number of seconds between retries of failed pins
+	PinRetryMaxBackoffSeconds int
+
+	// RecoverAllConcurrency is the number of items which RecoverAll
+	// recovers at the same time on each peer.
+	RecoverAllConcurrency int
+
 	// if a config has been loaded from disk, track the path
 	// so it can be saved to the same place.
 	path string
 
+	// the options before the environment overrode them, and the
+	// names of the JSONConfig fields they overrode (see withoutEnv)
+	envBase   *JSONConfig
+	envFields []string
+
 	saveMux sync.Mutex
 }
 
@@ -117,9 +351,41 @@ type JSONConfig struct {
 	// an IPFS daemon.
 	IPFSProxyListenMultiaddress string `json:"ipfs_proxy_listen_multiaddress"`
 
+	// Mount the IPFS Proxy on the API listener. Requests to /api/v0/*
+	// are then forwarded to IPFS and ipfs_proxy_listen_multiaddress
+	// is ignored.
+	IPFSProxyOnAPI bool `json:"ipfs_proxy_on_api"`
+
 	// API address for the IPFS daemon.
 	IPFSNodeMultiaddress string `json:"ipfs_node_multiaddress"`
 
+	// Serve metrics for Prometheus (number of pins by status, queue
+	// lengths, consensus commits and leadership) under /metrics on
+	// metrics_listen_multiaddress.
+	EnableMetrics bool `json:"enable_metrics,omitempty"`
+
+	// Listen address for the metrics endpoint. Defaults to
+	// /ip4/127.0.0.1/tcp/9097.
+	MetricsListenMultiaddress string `json:"metrics_listen_multiaddress,omitempty"`
+
+	// Number of seconds between checks of the IPFS daemon. When the
+	// daemon is found to have restarted (it was unreachable or its ID
+	// changed), the local pinset is synced and lost pins are re-pinned.
+	IPFSCheckSeconds int `json:"ipfs_check_seconds"`
+
+	// Maximum number of seconds that requests to the IPFS daemon can
+	// take, so that a hung daemon does not block this peer. Defaults
+	// to 60. Pins, unpins, adds and verifications, which may take
+	// much longer, are limited by ipfs_pin_timeout_seconds instead,
+	// which defaults to 86400 (24 hours).
+	IPFSRequestTimeoutSeconds int `json:"ipfs_request_timeout_seconds,omitempty"`
+	IPFSPinTimeoutSeconds     int `json:"ipfs_pin_timeout_seconds,omitempty"`
+
+	// Maximum cumulative size, in bytes, of the items pinned
+	// recursively by this peer, as reported by IPFS. Larger pins fail
+	// without being fetched. 0 (the default) means no limit.
+	MaxPinSize uint64 `json:"max_pin_size,omitempty"`
+
 	// Storage folder for snapshots, log store etc. Used by
 	// the Consensus component.
 	ConsensusDataFolder string `json:"consensus_data_folder"`
@@ -129,11 +395,179 @@ type JSONConfig struct {
 	// when new nodes are joining the cluster
 	StateSyncSeconds int `json:"state_sync_seconds"`
 
+	// Time in milliseconds without contact from the leader after which
+	// a follower starts an election. Raising it avoids elections caused
+	// by short network blips, but makes failover slower. 0 uses the
+	// Raft default.
+	RaftHeartbeatTimeoutMs int `json:"raft_heartbeat_timeout_ms,omitempty"`
+
+	// Time in milliseconds a candidate waits for votes before starting
+	// a new election. It must not be lower than the heartbeat timeout.
+	// 0 uses the Raft default.
+	RaftElectionTimeoutMs int `json:"raft_election_timeout_ms,omitempty"`
+
+	// Compress the state with gzip when taking Raft snapshots, which
+	// makes them smaller on disk and when sent to new peers. Both
+	// compressed and uncompressed snapshots can always be restored, so
+	// this can be changed at any time.
+	RaftCompressSnapshots bool `json:"raft_compress_snapshots,omitempty"`
+
+	// Number of seconds to wait for a consensus leader before giving
+	// up on an operation. High-latency clusters may need to raise it.
+	LeaderTimeoutSeconds int `json:"leader_timeout_seconds,omitempty"`
+
+	// Number of times a commit to the consensus log is attempted
+	// before giving up.
+	CommitRetries int `json:"commit_retries,omitempty"`
+
+	// Milliseconds to wait between commit attempts.
+	CommitRetryDelayMs int `json:"commit_retry_delay_ms,omitempty"`
+
 	// ReplicationFactor indicates the number of nodes that must pin content.
 	// For exampe, a replication_factor of 2 will prompt cluster to choose
 	// two nodes for each pinned hash. A replication_factor -1 will
 	// use every available node for each pin.
 	ReplicationFactor int `json:"replication_factor"`
+
+	// When a pin is allocated to a peer which is no longer part of the
+	// Cluster, re-allocate it to a different peer. When false, a warning
+	// is logged instead.
+	ReallocateUnknownAllocations bool `json:"reallocate_unknown_allocations"`
+
+	// What to do when pinning with a replication_factor larger than
+	// the number of available peers: "reject" the pin with an error,
+	// or "warn" and pin on the available peers, flagging the pin as
+	// under-replicated.
+	AllocationOnInsufficientPeers string `json:"allocation_on_insufficient_peers"`
+
+	// Maximum number of pins, and of unpins, waiting to be processed
+	// by this peer. New pins allocated to the peer are rejected while
+	// the queue is full. Defaults to 1024.
+	PinQueueSize int `json:"pin_queue_size,omitempty"`
+
+	// Number of pins, and of unpins, sent to IPFS at the same time by
+	// this peer. Both default to 1, which processes the queues one
+	// item at a time.
+	PinWorkers   int `json:"pin_workers,omitempty"`
+	UnpinWorkers int `json:"unpin_workers,omitempty"`
+
+	// Fill ratio of the local pin queue (0 to 1) above which new pin
+	// requests are rejected by the REST API with a Retry-After header,
+	// so clients can slow down before the queue is full.
+	PinQueueHighWater float64 `json:"pin_queue_high_water"`
+
+	// Reject REST API request bodies containing unknown fields with a
+	// 400 error, rather than ignoring them. This catches typos in
+	// field names.
+	StrictRequestBodies bool `json:"strict_request_bodies,omitempty"`
+
+	// Require "Authorization: Bearer <token>" on every REST API
+	// request. Requests without it are rejected with 401.
+	APIAuthToken string `json:"api_auth_token,omitempty"`
+
+	// User names and passwords accepted with HTTP basic authentication
+	// on the REST API. Can be combined with api_auth_token.
+	APIBasicAuthCredentials map[string]string `json:"api_basic_auth_credentials,omitempty"`
+
+	// Let unauthenticated requests reach /health when authentication
+	// is enabled.
+	APIAuthExemptHealth bool `json:"api_auth_exempt_health,omitempty"`
+
+	// Paths to a PEM-encoded certificate and its key. When both are
+	// set, the REST API is served over HTTPS only.
+	APITLSCertFile string `json:"api_tls_cert_file,omitempty"`
+	APITLSKeyFile  string `json:"api_tls_key_file,omitempty"`
+
+	// Origins allowed to make cross-origin requests to the REST API,
+	// i.e. "https://admin.example.org", or "*" for any. Empty
+	// disables CORS. The methods and headers allowed in those
+	// requests default to GET, POST, DELETE and Content-Type,
+	// Authorization.
+	APICORSAllowedOrigins []string `json:"api_cors_allowed_origins,omitempty"`
+	APICORSAllowedMethods []string `json:"api_cors_allowed_methods,omitempty"`
+	APICORSAllowedHeaders []string `json:"api_cors_allowed_headers,omitempty"`
+
+	// Log the method, path, response status and size, and duration
+	// of every request to the REST API. Shown at debug level.
+	APIAccessLog bool `json:"api_access_log,omitempty"`
+
+	// Requests per second allowed to each client of the REST API, by
+	// IP address, for write requests (POST, DELETE...) and for read
+	// requests (GET, HEAD, OPTIONS). Bursts of up to one second of
+	// requests are allowed. Further requests get 429 Too Many
+	// Requests. 0 (the default) means no limit.
+	APIWriteRateLimit float64 `json:"api_write_rate_limit,omitempty"`
+	APIReadRateLimit  float64 `json:"api_read_rate_limit,omitempty"`
+
+	// When the items tracked by this peer are fewer than this fraction
+	// of the pins in the IPFS daemon, syncs query the status of the
+	// tracked items in batches instead of listing every IPFS pin.
+	// Defaults to 0.1. Negative values always list every pin.
+	SyncAllBatchRatio float64 `json:"sync_all_batch_ratio"`
+
+	// Maximum number of pins in the Cluster. When exceeded, the leader
+	// unpins items according to the eviction_policy, turning the
+	// Cluster into a managed cache. 0 disables the limit.
+	CacheCapacity int `json:"cache_capacity"`
+
+	// Eviction policy used when cache_capacity is exceeded: "none"
+	// (never evict) or "lru" (unpin least recently accessed first).
+	// Content accesses are recorded when served through the IPFS Proxy,
+	// and kept in the consensus data folder. Nothing is evicted while
+	// any peer is unreachable.
+	EvictionPolicy string `json:"eviction_policy"`
+
+	// How to choose the peer serving the content of a pin among those
+	// allocated to it: "random", "lowest-latency" or "least-loaded".
+	// If the chosen peer is unreachable, the next one is tried.
+	ReadStrategy string `json:"read_strategy"`
+
+	// Free space, in megabytes, in the consensus_data_folder below
+	// which a prominent warning is logged and low disk space is
+	// reported by /health. Defaults to 1024. Negative disables it.
+	DiskSpaceThresholdMB int `json:"disk_space_threshold_mb"`
+
+	// Reject new pins while the free disk space is below the
+	// disk_space_threshold_mb, rather than letting them fail later.
+	ReadOnlyOnLowDiskSpace bool `json:"read_only_on_low_disk_space"`
+
+	// How this peer talks to IPFS: "http" uses the daemon at
+	// ipfs_node_multiaddress, and "null" does not use IPFS at all
+	// (i.e. during maintenance of the daemon), so pins and unpins
+	// succeed without doing anything.
+	IPFSConnector string `json:"ipfs_connector,omitempty"`
+
+	// URL of a remote pinning service implementing the IPFS Pinning
+	// Service API (i.e. https://pinning-service.example.com/api/v1).
+	// When set, this peer pins on the remote service instead of on
+	// the IPFS daemon, and the IPFS Proxy is disabled.
+	PinningServiceEndpoint string `json:"pinning_service_endpoint,omitempty"`
+
+	// Access token sent as "Authorization: Bearer <token>" to the
+	// pinning service.
+	PinningServiceToken string `json:"pinning_service_token,omitempty"`
+
+	// URL which is notified with a POST request, carrying the status
+	// of the item as JSON, whenever a pin or unpin fails on this peer.
+	PinErrorWebhook string `json:"pin_error_webhook,omitempty"`
+
+	// Save the status of the tracked items in the consensus data
+	// folder, so that errors are still reported after a restart,
+	// before the items are synced again.
+	PersistTrackerState bool `json:"persist_tracker_state,omitempty"`
+
+	// Retry failed pins and unpins automatically, waiting longer
+	// after each failure, up to this number of attempts. Items which
+	// exhaust their attempts stay in error. 0 disables retries.
+	PinRetryMaxAttempts int `json:"pin_retry_max_attempts,omitempty"`
+
+	// Maximum number of seconds to wait between retries.
+	PinRetryMaxBackoffSeconds int `json:"pin_retry_max_backoff_seconds,omitempty"`
+
+	// Number of items in error which are recovered at the same time
+	// when recovering all of them, so that the IPFS daemon is not
+	// overwhelmed. Defaults to 10.
+	RecoverAllConcurrency int `json:"recover_all_concurrency,omitempty"`
 }
 
 // ToJSONConfig converts a Config object to its JSON representation which
@@ -162,25 +596,83 @@ func (cfg *Config) ToJSONConfig() (j *JSONConfig, err error) {
 	}
 
 	j = &JSONConfig{
-		ID:                          cfg.ID.Pretty(),
-		PrivateKey:                  pKey,
-		ClusterPeers:                clusterPeers,
-		Bootstrap:                   bootstrap,
-		LeaveOnShutdown:             cfg.LeaveOnShutdown,
-		ClusterListenMultiaddress:   cfg.ClusterAddr.String(),
-		APIListenMultiaddress:       cfg.APIAddr.String(),
-		IPFSProxyListenMultiaddress: cfg.IPFSProxyAddr.String(),
-		IPFSNodeMultiaddress:        cfg.IPFSNodeAddr.String(),
-		ConsensusDataFolder:         cfg.ConsensusDataFolder,
-		StateSyncSeconds:            cfg.StateSyncSeconds,
-		ReplicationFactor:           cfg.ReplicationFactor,
+		ID:                            cfg.ID.Pretty(),
+		PrivateKey:                    pKey,
+		ClusterPeers:                  clusterPeers,
+		Bootstrap:                     bootstrap,
+		LeaveOnShutdown:               cfg.LeaveOnShutdown,
+		ClusterListenMultiaddress:     cfg.ClusterAddr.String(),
+		APIListenMultiaddress:         cfg.APIAddr.String(),
+		IPFSProxyListenMultiaddress:   cfg.IPFSProxyAddr.String(),
+		IPFSProxyOnAPI:                cfg.IPFSProxyOnAPI,
+		IPFSNodeMultiaddress:          cfg.IPFSNodeAddr.String(),
+		EnableMetrics:                 cfg.EnableMetrics,
+		IPFSCheckSeconds:              cfg.IPFSCheckSeconds,
+		IPFSRequestTimeoutSeconds:     cfg.IPFSRequestTimeoutSeconds,
+		IPFSPinTimeoutSeconds:         cfg.IPFSPinTimeoutSeconds,
+		MaxPinSize:                    cfg.MaxPinSize,
+		ConsensusDataFolder:           cfg.ConsensusDataFolder,
+		StateSyncSeconds:              cfg.StateSyncSeconds,
+		RaftHeartbeatTimeoutMs:        cfg.RaftHeartbeatTimeoutMs,
+		RaftElectionTimeoutMs:         cfg.RaftElectionTimeoutMs,
+		RaftCompressSnapshots:         cfg.RaftCompressSnapshots,
+		LeaderTimeoutSeconds:          cfg.LeaderTimeoutSeconds,
+		CommitRetries:                 cfg.CommitRetries,
+		CommitRetryDelayMs:            cfg.CommitRetryDelayMs,
+		ReplicationFactor:             cfg.ReplicationFactor,
+		ReallocateUnknownAllocations:  cfg.ReallocateUnknownAllocations,
+		AllocationOnInsufficientPeers: cfg.AllocationOnInsufficientPeers,
+		PinQueueSize:                  cfg.PinQueueSize,
+		PinWorkers:                    cfg.PinWorkers,
+		UnpinWorkers:                  cfg.UnpinWorkers,
+		PinQueueHighWater:             cfg.PinQueueHighWater,
+		StrictRequestBodies:           cfg.StrictRequestBodies,
+		APIAuthToken:                  cfg.APIAuthToken,
+		APIBasicAuthCredentials:       cfg.APIBasicAuthCredentials,
+		APIAuthExemptHealth:           cfg.APIAuthExemptHealth,
+		APITLSCertFile:                cfg.APITLSCertFile,
+		APITLSKeyFile:                 cfg.APITLSKeyFile,
+		APICORSAllowedOrigins:         cfg.APICORSAllowedOrigins,
+		APICORSAllowedMethods:         cfg.APICORSAllowedMethods,
+		APICORSAllowedHeaders:         cfg.APICORSAllowedHeaders,
+		APIAccessLog:                  cfg.APIAccessLog,
+		APIWriteRateLimit:             cfg.APIWriteRateLimit,
+		APIReadRateLimit:              cfg.APIReadRateLimit,
+		SyncAllBatchRatio:             cfg.SyncAllBatchRatio,
+		CacheCapacity:                 cfg.CacheCapacity,
+		EvictionPolicy:                cfg.EvictionPolicy,
+		ReadStrategy:                  cfg.ReadStrategy,
+		DiskSpaceThresholdMB:          cfg.DiskSpaceThresholdMB,
+		ReadOnlyOnLowDiskSpace:        cfg.ReadOnlyOnLowDiskSpace,
+		IPFSConnector:                 cfg.IPFSConnector,
+		PinningServiceEndpoint:        cfg.PinningServiceEndpoint,
+		PinningServiceToken:           cfg.PinningServiceToken,
+		PinErrorWebhook:               cfg.PinErrorWebhook,
+		PersistTrackerState:           cfg.PersistTrackerState,
+		PinRetryMaxAttempts:           cfg.PinRetryMaxAttempts,
+		PinRetryMaxBackoffSeconds:     cfg.PinRetryMaxBackoffSeconds,
+		RecoverAllConcurrency:         cfg.RecoverAllConcurrency,
+	}
+	// Configurations built before the option existed may lack it
+	if cfg.MetricsAddr != nil {
+		j.MetricsListenMultiaddress = cfg.MetricsAddr.String()
 	}
 	return
 }
 
 // ToConfig converts a JSONConfig to its internal Config representation,
-// where options are parsed into their native types.
+// where options are parsed into their native types. The environment
+// variables in configEnvVars override the values in the JSONConfig,
+// which is not modified, and are not saved by Config.Save().
 func (jcfg *JSONConfig) ToConfig() (c *Config, err error) {
+	base := jcfg
+	envCfg := *jcfg
+	jcfg = &envCfg
+	envFields, err := jcfg.applyEnv()
+	if err != nil {
+		return
+	}
+
 	id, err := peer.IDB58Decode(jcfg.ID)
 	if err != nil {
 		err = fmt.Errorf("error decoding cluster ID: %s", err)
@@ -241,6 +733,14 @@ func (jcfg *JSONConfig) ToConfig() (c *Config, err error) {
 		err = fmt.Errorf("error parsing ipfs_node_multiaddress: %s", err)
 		return
 	}
+	if jcfg.MetricsListenMultiaddress == "" {
+		jcfg.MetricsListenMultiaddress = DefaultMetricsAddr
+	}
+	metricsAddr, err := ma.NewMultiaddr(jcfg.MetricsListenMultiaddress)
+	if err != nil {
+		err = fmt.Errorf("error parsing metrics_listen_multiaddress: %s", err)
+		return
+	}
 
 	if jcfg.ReplicationFactor == 0 {
 		logger.Warning("Replication factor set to -1 (pin everywhere)")
@@ -251,19 +751,233 @@ func (jcfg *JSONConfig) ToConfig() (c *Config, err error) {
 		jcfg.StateSyncSeconds = DefaultStateSyncSeconds
 	}
 
+	if jcfg.IPFSCheckSeconds <= 0 {
+		jcfg.IPFSCheckSeconds = DefaultIPFSCheckSeconds
+	}
+
+	if jcfg.IPFSRequestTimeoutSeconds < 0 || jcfg.IPFSPinTimeoutSeconds < 0 {
+		err = errors.New("ipfs_request_timeout_seconds and ipfs_pin_timeout_seconds cannot be negative")
+		return
+	}
+	if jcfg.IPFSRequestTimeoutSeconds == 0 {
+		jcfg.IPFSRequestTimeoutSeconds = DefaultIPFSRequestTimeoutSeconds
+	}
+	if jcfg.IPFSPinTimeoutSeconds == 0 {
+		jcfg.IPFSPinTimeoutSeconds = DefaultIPFSPinTimeoutSeconds
+	}
+
+	err = validateRaftTimeouts(jcfg.RaftHeartbeatTimeoutMs, jcfg.RaftElectionTimeoutMs)
+	if err != nil {
+		return
+	}
+
+	if jcfg.LeaderTimeoutSeconds < 0 || jcfg.CommitRetries < 0 || jcfg.CommitRetryDelayMs < 0 {
+		err = errors.New("leader_timeout_seconds, commit_retries and commit_retry_delay_ms cannot be negative")
+		return
+	}
+	if jcfg.LeaderTimeoutSeconds == 0 {
+		jcfg.LeaderTimeoutSeconds = int(LeaderTimeout / time.Second)
+	}
+	if jcfg.CommitRetries == 0 {
+		jcfg.CommitRetries = CommitRetries
+	}
+	if jcfg.CommitRetryDelayMs == 0 {
+		jcfg.CommitRetryDelayMs = int(CommitRetryDelay / time.Millisecond)
+	}
+
+	if jcfg.PinQueueSize < 0 {
+		err = errors.New("pin_queue_size cannot be negative")
+		return
+	}
+	if jcfg.PinQueueSize == 0 {
+		jcfg.PinQueueSize = PinQueueSize
+	}
+
+	if jcfg.PinWorkers < 0 || jcfg.UnpinWorkers < 0 {
+		err = errors.New("pin_workers and unpin_workers cannot be negative")
+		return
+	}
+	if jcfg.PinWorkers == 0 {
+		jcfg.PinWorkers = PinWorkers
+	}
+	if jcfg.UnpinWorkers == 0 {
+		jcfg.UnpinWorkers = UnpinWorkers
+	}
+
+	if jcfg.PinQueueHighWater <= 0 {
+		jcfg.PinQueueHighWater = DefaultPinQueueHighWater
+	}
+
+	if jcfg.SyncAllBatchRatio == 0 {
+		jcfg.SyncAllBatchRatio = DefaultSyncAllBatchRatio
+	}
+
+	if jcfg.DiskSpaceThresholdMB == 0 {
+		jcfg.DiskSpaceThresholdMB = DefaultDiskSpaceThresholdMB
+	}
+
+	switch jcfg.AllocationOnInsufficientPeers {
+	case "":
+		jcfg.AllocationOnInsufficientPeers = DefaultAllocationOnInsufficientPeers
+	case InsufficientPeersReject, InsufficientPeersWarn:
+	default:
+		err = fmt.Errorf("unknown allocation_on_insufficient_peers: %s",
+			jcfg.AllocationOnInsufficientPeers)
+		return
+	}
+
+	switch jcfg.EvictionPolicy {
+	case "":
+		jcfg.EvictionPolicy = DefaultEvictionPolicy
+	case EvictionPolicyNone, EvictionPolicyLRU:
+	default:
+		err = fmt.Errorf("unknown eviction_policy: %s", jcfg.EvictionPolicy)
+		return
+	}
+
+	switch jcfg.ReadStrategy {
+	case "":
+		jcfg.ReadStrategy = DefaultReadStrategy
+	case ReadStrategyRandom, ReadStrategyLowestLatency, ReadStrategyLeastLoaded:
+	default:
+		err = fmt.Errorf("unknown read_strategy: %s", jcfg.ReadStrategy)
+		return
+	}
+
+	if jcfg.PinRetryMaxAttempts < 0 {
+		err = errors.New("pin_retry_max_attempts cannot be negative")
+		return
+	}
+
+	if jcfg.PinRetryMaxBackoffSeconds <= 0 {
+		jcfg.PinRetryMaxBackoffSeconds = DefaultPinRetryMaxBackoffSeconds
+	}
+
+	switch {
+	case jcfg.RecoverAllConcurrency < 0:
+		err = errors.New("recover_all_concurrency cannot be negative")
+		return
+	case jcfg.RecoverAllConcurrency == 0:
+		jcfg.RecoverAllConcurrency = DefaultRecoverAllConcurrency
+	}
+
+	if jcfg.CacheCapacity < 0 {
+		err = errors.New("cache_capacity cannot be negative")
+		return
+	}
+
+	for user := range jcfg.APIBasicAuthCredentials {
+		if user == "" || strings.Contains(user, ":") {
+			err = fmt.Errorf("invalid user name in api_basic_auth_credentials: %q", user)
+			return
+		}
+	}
+
+	if (jcfg.APITLSCertFile == "") != (jcfg.APITLSKeyFile == "") {
+		err = errors.New("api_tls_cert_file and api_tls_key_file must be set together")
+		return
+	}
+
+	for _, origin := range jcfg.APICORSAllowedOrigins {
+		if origin == "" || strings.HasSuffix(origin, "/") {
+			err = fmt.Errorf("invalid origin in api_cors_allowed_origins: %q", origin)
+			return
+		}
+	}
+
+	if jcfg.APIWriteRateLimit < 0 || jcfg.APIReadRateLimit < 0 {
+		err = errors.New("api_write_rate_limit and api_read_rate_limit cannot be negative")
+		return
+	}
+
+	switch jcfg.IPFSConnector {
+	case "":
+		jcfg.IPFSConnector = DefaultIPFSConnector
+	case IPFSConnectorHTTP, IPFSConnectorNull:
+	default:
+		err = fmt.Errorf("unknown ipfs_connector: %s", jcfg.IPFSConnector)
+		return
+	}
+
+	if jcfg.PinningServiceEndpoint != "" {
+		_, err = url.ParseRequestURI(jcfg.PinningServiceEndpoint)
+		if err != nil {
+			err = fmt.Errorf("error parsing pinning_service_endpoint: %s", err)
+			return
+		}
+	}
+
+	if jcfg.PinErrorWebhook != "" {
+		_, err = url.ParseRequestURI(jcfg.PinErrorWebhook)
+		if err != nil {
+			err = fmt.Errorf("error parsing pin_error_webhook: %s", err)
+			return
+		}
+	}
+
 	c = &Config{
-		ID:                  id,
-		PrivateKey:          pKey,
-		ClusterPeers:        clusterPeers,
-		Bootstrap:           bootstrap,
-		LeaveOnShutdown:     jcfg.LeaveOnShutdown,
-		ClusterAddr:         clusterAddr,
-		APIAddr:             apiAddr,
-		IPFSProxyAddr:       ipfsProxyAddr,
-		IPFSNodeAddr:        ipfsNodeAddr,
-		ConsensusDataFolder: jcfg.ConsensusDataFolder,
-		StateSyncSeconds:    jcfg.StateSyncSeconds,
-		ReplicationFactor:   jcfg.ReplicationFactor,
+		ID:                            id,
+		PrivateKey:                    pKey,
+		ClusterPeers:                  clusterPeers,
+		Bootstrap:                     bootstrap,
+		LeaveOnShutdown:               jcfg.LeaveOnShutdown,
+		ClusterAddr:                   clusterAddr,
+		APIAddr:                       apiAddr,
+		IPFSProxyAddr:                 ipfsProxyAddr,
+		IPFSProxyOnAPI:                jcfg.IPFSProxyOnAPI,
+		IPFSNodeAddr:                  ipfsNodeAddr,
+		EnableMetrics:                 jcfg.EnableMetrics,
+		MetricsAddr:                   metricsAddr,
+		IPFSCheckSeconds:              jcfg.IPFSCheckSeconds,
+		IPFSRequestTimeoutSeconds:     jcfg.IPFSRequestTimeoutSeconds,
+		IPFSPinTimeoutSeconds:         jcfg.IPFSPinTimeoutSeconds,
+		MaxPinSize:                    jcfg.MaxPinSize,
+		ConsensusDataFolder:           jcfg.ConsensusDataFolder,
+		StateSyncSeconds:              jcfg.StateSyncSeconds,
+		RaftHeartbeatTimeoutMs:        jcfg.RaftHeartbeatTimeoutMs,
+		RaftElectionTimeoutMs:         jcfg.RaftElectionTimeoutMs,
+		RaftCompressSnapshots:         jcfg.RaftCompressSnapshots,
+		LeaderTimeoutSeconds:          jcfg.LeaderTimeoutSeconds,
+		CommitRetries:                 jcfg.CommitRetries,
+		CommitRetryDelayMs:            jcfg.CommitRetryDelayMs,
+		ReplicationFactor:             jcfg.ReplicationFactor,
+		ReallocateUnknownAllocations:  jcfg.ReallocateUnknownAllocations,
+		AllocationOnInsufficientPeers: jcfg.AllocationOnInsufficientPeers,
+		PinQueueSize:                  jcfg.PinQueueSize,
+		PinWorkers:                    jcfg.PinWorkers,
+		UnpinWorkers:                  jcfg.UnpinWorkers,
+		PinQueueHighWater:             jcfg.PinQueueHighWater,
+		StrictRequestBodies:           jcfg.StrictRequestBodies,
+		APIAuthToken:                  jcfg.APIAuthToken,
+		APIBasicAuthCredentials:       jcfg.APIBasicAuthCredentials,
+		APIAuthExemptHealth:           jcfg.APIAuthExemptHealth,
+		APITLSCertFile:                jcfg.APITLSCertFile,
+		APITLSKeyFile:                 jcfg.APITLSKeyFile,
+		APICORSAllowedOrigins:         jcfg.APICORSAllowedOrigins,
+		APICORSAllowedMethods:         jcfg.APICORSAllowedMethods,
+		APICORSAllowedHeaders:         jcfg.APICORSAllowedHeaders,
+		APIAccessLog:                  jcfg.APIAccessLog,
+		APIWriteRateLimit:             jcfg.APIWriteRateLimit,
+		APIReadRateLimit:              jcfg.APIReadRateLimit,
+		SyncAllBatchRatio:             jcfg.SyncAllBatchRatio,
+		CacheCapacity:                 jcfg.CacheCapacity,
+		EvictionPolicy:                jcfg.EvictionPolicy,
+		ReadStrategy:                  jcfg.ReadStrategy,
+		DiskSpaceThresholdMB:          jcfg.DiskSpaceThresholdMB,
+		ReadOnlyOnLowDiskSpace:        jcfg.ReadOnlyOnLowDiskSpace,
+		IPFSConnector:                 jcfg.IPFSConnector,
+		PinningServiceEndpoint:        jcfg.PinningServiceEndpoint,
+		PinningServiceToken:           jcfg.PinningServiceToken,
+		PinErrorWebhook:               jcfg.PinErrorWebhook,
+		PersistTrackerState:           jcfg.PersistTrackerState,
+		PinRetryMaxAttempts:           jcfg.PinRetryMaxAttempts,
+		PinRetryMaxBackoffSeconds:     jcfg.PinRetryMaxBackoffSeconds,
+		RecoverAllConcurrency:         jcfg.RecoverAllConcurrency,
+	}
+	if len(envFields) > 0 {
+		baseCopy := *base
+		c.envBase = &baseCopy
+		c.envFields = envFields
 	}
 	return
 }
@@ -293,7 +1007,8 @@ func LoadConfig(path string) (*Config, error) {
 
 // Save stores a configuration as a JSON file in the given path.
 // If no path is provided, it uses the path the configuration was
-// loaded from.
+// loaded from. The options overridden by environment variables are
+// saved with the values they had before.
 func (cfg *Config) Save(path string) error {
 	cfg.saveMux.Lock()
 	defer cfg.saveMux.Unlock()
@@ -308,6 +1023,7 @@ func (cfg *Config) Save(path string) error {
 		logger.Error("error generating JSON config")
 		return err
 	}
+	cfg.withoutEnv(jcfg)
 	json, err := json.MarshalIndent(jcfg, "", "    ")
 	if err != nil {
 		return err
@@ -317,7 +1033,8 @@ func (cfg *Config) Save(path string) error {
 }
 
 // NewDefaultConfig returns a default configuration object with a randomly
-// generated ID and private key.
+// generated ID and private key. The environment variables in
+// configEnvVars override the defaults.
 func NewDefaultConfig() (*Config, error) {
 	priv, pub, err := crypto.GenerateKeyPair(
 		DefaultConfigCrypto,
@@ -334,19 +1051,84 @@ func NewDefaultConfig() (*Config, error) {
 	apiAddr, _ := ma.NewMultiaddr(DefaultAPIAddr)
 	ipfsProxyAddr, _ := ma.NewMultiaddr(DefaultIPFSProxyAddr)
 	ipfsNodeAddr, _ := ma.NewMultiaddr(DefaultIPFSNodeAddr)
+	metricsAddr, _ := ma.NewMultiaddr(DefaultMetricsAddr)
+
+	cfg := &Config{
+		ID:                            pid,
+		PrivateKey:                    priv,
+		ClusterPeers:                  []ma.Multiaddr{},
+		Bootstrap:                     []ma.Multiaddr{},
+		LeaveOnShutdown:               false,
+		ClusterAddr:                   clusterAddr,
+		APIAddr:                       apiAddr,
+		IPFSProxyAddr:                 ipfsProxyAddr,
+		IPFSProxyOnAPI:                false,
+		IPFSNodeAddr:                  ipfsNodeAddr,
+		EnableMetrics:                 false,
+		MetricsAddr:                   metricsAddr,
+		IPFSCheckSeconds:              DefaultIPFSCheckSeconds,
+		IPFSRequestTimeoutSeconds:     DefaultIPFSRequestTimeoutSeconds,
+		IPFSPinTimeoutSeconds:         DefaultIPFSPinTimeoutSeconds,
+		MaxPinSize:                    0,
+		ConsensusDataFolder:           "ipfscluster-data",
+		StateSyncSeconds:              DefaultStateSyncSeconds,
+		RaftCompressSnapshots:         RaftCompressSnapshots,
+		LeaderTimeoutSeconds:          int(LeaderTimeout / time.Second),
+		CommitRetries:                 CommitRetries,
+		CommitRetryDelayMs:            int(CommitRetryDelay / time.Millisecond),
+		ReplicationFactor:             -1,
+		ReallocateUnknownAllocations:  false,
+		AllocationOnInsufficientPeers: DefaultAllocationOnInsufficientPeers,
+		PinQueueSize:                  PinQueueSize,
+		PinWorkers:                    PinWorkers,
+		UnpinWorkers:                  UnpinWorkers,
+		PinQueueHighWater:             DefaultPinQueueHighWater,
+		StrictRequestBodies:           false,
+		SyncAllBatchRatio:             DefaultSyncAllBatchRatio,
+		CacheCapacity:                 0,
+		EvictionPolicy:                DefaultEvictionPolicy,
+		ReadStrategy:                  DefaultReadStrategy,
+		IPFSConnector:                 DefaultIPFSConnector,
+		DiskSpaceThresholdMB:          DefaultDiskSpaceThresholdMB,
+		ReadOnlyOnLowDiskSpace:        false,
+		PersistTrackerState:           false,
+		PinRetryMaxAttempts:           0,
+		PinRetryMaxBackoffSeconds:     DefaultPinRetryMaxBackoffSeconds,
+		RecoverAllConcurrency:         DefaultRecoverAllConcurrency,
+	}
 
-	return &Config{
-		ID:                  pid,
-		PrivateKey:          priv,
-		ClusterPeers:        []ma.Multiaddr{},
-		Bootstrap:           []ma.Multiaddr{},
-		LeaveOnShutdown:     false,
-		ClusterAddr:         clusterAddr,
-		APIAddr:             apiAddr,
-		IPFSProxyAddr:       ipfsProxyAddr,
-		IPFSNodeAddr:        ipfsNodeAddr,
-		ConsensusDataFolder: "ipfscluster-data",
-		StateSyncSeconds:    DefaultStateSyncSeconds,
-		ReplicationFactor:   -1,
-	}, nil
+	if !configEnvSet() {
+		return cfg, nil
+	}
+	jcfg, err := cfg.ToJSONConfig()
+	if err != nil {
+		return nil, err
+	}
+	return jcfg.ToConfig()
+}
+
+// validateRaftTimeouts checks that the configured Raft timeouts are
+// valid. Raft requires the election timeout to be at least as long as
+// the heartbeat timeout. Unset (0) values take the Raft defaults.
+func validateRaftTimeouts(heartbeatMs, electionMs int) error {
+	if heartbeatMs < 0 {
+		return errors.New("raft_heartbeat_timeout_ms cannot be negative")
+	}
+	if electionMs < 0 {
+		return errors.New("raft_election_timeout_ms cannot be negative")
+	}
+
+	heartbeat := DefaultRaftConfig.HeartbeatTimeout
+	if heartbeatMs > 0 {
+		heartbeat = time.Duration(heartbeatMs) * time.Millisecond
+	}
+	election := DefaultRaftConfig.ElectionTimeout
+	if electionMs > 0 {
+		election = time.Duration(electionMs) * time.Millisecond
+	}
+	if election < heartbeat {
+		return fmt.Errorf("raft election timeout (%s) cannot be lower than the heartbeat timeout (%s)",
+			election, heartbeat)
+	}
+	return nil
 }
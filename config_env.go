@@ -0,0 +1,123 @@
+package ipfscluster
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// configEnvVar is an environment variable which overrides an option of
+// the configuration.
+type configEnvVar struct {
+	name string
+	// the JSONConfig field set by set
+	field string
+	set   func(jcfg *JSONConfig, value string) error
+}
+
+// configEnvVars lists the environment variables which override the
+// options of the configuration when it is loaded (see ToConfig) or
+// created (see NewDefaultConfig). Lists of multiaddresses are given
+// separated by commas. Unset or empty variables are ignored.
+var configEnvVars = []configEnvVar{
+	{"CLUSTER_PEERS", "ClusterPeers", func(j *JSONConfig, v string) error {
+		j.ClusterPeers = splitEnvList(v)
+		return nil
+	}},
+	{"CLUSTER_BOOTSTRAP", "Bootstrap", func(j *JSONConfig, v string) error {
+		j.Bootstrap = splitEnvList(v)
+		return nil
+	}},
+	{"CLUSTER_LEAVEONSHUTDOWN", "LeaveOnShutdown", func(j *JSONConfig, v string) (err error) {
+		j.LeaveOnShutdown, err = strconv.ParseBool(v)
+		return
+	}},
+	{"CLUSTER_CLUSTERADDR", "ClusterListenMultiaddress", func(j *JSONConfig, v string) error {
+		j.ClusterListenMultiaddress = v
+		return nil
+	}},
+	{"CLUSTER_APIADDR", "APIListenMultiaddress", func(j *JSONConfig, v string) error {
+		j.APIListenMultiaddress = v
+		return nil
+	}},
+	{"CLUSTER_IPFSPROXYADDR", "IPFSProxyListenMultiaddress", func(j *JSONConfig, v string) error {
+		j.IPFSProxyListenMultiaddress = v
+		return nil
+	}},
+	{"CLUSTER_IPFSNODEADDR", "IPFSNodeMultiaddress", func(j *JSONConfig, v string) error {
+		j.IPFSNodeMultiaddress = v
+		return nil
+	}},
+	{"CLUSTER_DATAFOLDER", "ConsensusDataFolder", func(j *JSONConfig, v string) error {
+		j.ConsensusDataFolder = v
+		return nil
+	}},
+	{"CLUSTER_REPLICATIONFACTOR", "ReplicationFactor", func(j *JSONConfig, v string) (err error) {
+		j.ReplicationFactor, err = strconv.Atoi(v)
+		return
+	}},
+	{"CLUSTER_IPFSCONNECTOR", "IPFSConnector", func(j *JSONConfig, v string) error {
+		j.IPFSConnector = v
+		return nil
+	}},
+	{"CLUSTER_APIAUTHTOKEN", "APIAuthToken", func(j *JSONConfig, v string) error {
+		j.APIAuthToken = v
+		return nil
+	}},
+}
+
+// applyEnv overrides the options of the configuration with the values
+// of the environment variables in configEnvVars. It returns the names
+// of the fields which were overridden.
+func (jcfg *JSONConfig) applyEnv() ([]string, error) {
+	var fields []string
+	for _, env := range configEnvVars {
+		v := os.Getenv(env.name)
+		if v == "" {
+			continue
+		}
+		logger.Debugf("%s overrides the configuration", env.name)
+		if err := env.set(jcfg, v); err != nil {
+			return nil, fmt.Errorf("error parsing %s: %s", env.name, err)
+		}
+		fields = append(fields, env.field)
+	}
+	return fields, nil
+}
+
+// withoutEnv sets the options of jcfg which were overridden by the
+// environment when cfg was created back to their previous values, so
+// that they are not saved.
+func (cfg *Config) withoutEnv(jcfg *JSONConfig) {
+	if cfg.envBase == nil {
+		return
+	}
+	dst := reflect.ValueOf(jcfg).Elem()
+	src := reflect.ValueOf(cfg.envBase).Elem()
+	for _, f := range cfg.envFields {
+		dst.FieldByName(f).Set(src.FieldByName(f))
+	}
+}
+
+// configEnvSet returns true when any of the variables in
+// configEnvVars is set.
+func configEnvSet() bool {
+	for _, env := range configEnvVars {
+		if os.Getenv(env.name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func splitEnvList(v string) []string {
+	var list []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			list = append(list, s)
+		}
+	}
+	return list
+}
@@ -1,6 +1,12 @@
 package ipfscluster
 
-import "testing"
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
 
 func testingConfig() *Config {
 	jcfg := &JSONConfig{
@@ -10,6 +16,7 @@ func testingConfig() *Config {
 		ClusterListenMultiaddress:   "/ip4/127.0.0.1/tcp/10000",
 		APIListenMultiaddress:       "/ip4/127.0.0.1/tcp/10002",
 		IPFSProxyListenMultiaddress: "/ip4/127.0.0.1/tcp/10001",
+		MetricsListenMultiaddress:   "/ip4/127.0.0.1/tcp/10003",
 		ConsensusDataFolder:         "./raftFolderFromTests",
 		LeaveOnShutdown:             true,
 	}
@@ -92,3 +99,325 @@ func TestConfigToConfig(t *testing.T) {
 		t.Error("expected error parsing Bootstrap")
 	}
 }
+
+func TestConfigEvictionPolicy(t *testing.T) {
+	cfg, _ := NewDefaultConfig()
+	j, _ := cfg.ToJSONConfig()
+
+	j.EvictionPolicy = ""
+	cfg2, err := j.ToConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg2.EvictionPolicy != EvictionPolicyNone {
+		t.Error("expected default eviction policy")
+	}
+
+	j.EvictionPolicy = "fifo"
+	_, err = j.ToConfig()
+	if err == nil {
+		t.Error("expected an error with an unknown eviction policy")
+	}
+
+	j.EvictionPolicy = EvictionPolicyLRU
+	j.CacheCapacity = -1
+	_, err = j.ToConfig()
+	if err == nil {
+		t.Error("expected an error with a negative cache capacity")
+	}
+}
+
+func TestConfigIPFSConnector(t *testing.T) {
+	cfg, _ := NewDefaultConfig()
+	j, _ := cfg.ToJSONConfig()
+
+	j.IPFSConnector = ""
+	cfg2, err := j.ToConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg2.IPFSConnector != IPFSConnectorHTTP {
+		t.Error("expected the http connector by default")
+	}
+
+	j.IPFSConnector = IPFSConnectorNull
+	cfg2, err = j.ToConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg2.IPFSConnector != IPFSConnectorNull {
+		t.Error("expected the null connector")
+	}
+
+	j.IPFSConnector = "grpc"
+	_, err = j.ToConfig()
+	if err == nil {
+		t.Error("expected an error with an unknown connector")
+	}
+}
+
+func TestConfigAllocationOnInsufficientPeers(t *testing.T) {
+	cfg, _ := NewDefaultConfig()
+	j, _ := cfg.ToJSONConfig()
+
+	j.AllocationOnInsufficientPeers = ""
+	cfg2, err := j.ToConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg2.AllocationOnInsufficientPeers != InsufficientPeersReject {
+		t.Error("expected to reject by default")
+	}
+
+	j.AllocationOnInsufficientPeers = "ignore"
+	_, err = j.ToConfig()
+	if err == nil {
+		t.Error("expected an error with an unknown policy")
+	}
+}
+
+func TestConfigConsensusTimeouts(t *testing.T) {
+	cfg, _ := NewDefaultConfig()
+	j, _ := cfg.ToJSONConfig()
+
+	j.LeaderTimeoutSeconds = 0
+	j.CommitRetries = 0
+	j.CommitRetryDelayMs = 0
+	cfg2, err := j.ToConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg2.LeaderTimeoutSeconds != 15 || cfg2.CommitRetries != CommitRetries ||
+		cfg2.CommitRetryDelayMs != 200 {
+		t.Errorf("expected the defaults: %d %d %d", cfg2.LeaderTimeoutSeconds,
+			cfg2.CommitRetries, cfg2.CommitRetryDelayMs)
+	}
+
+	j.CommitRetries = -1
+	_, err = j.ToConfig()
+	if err == nil {
+		t.Error("expected an error with negative commit retries")
+	}
+}
+
+func TestConfigPinQueueSize(t *testing.T) {
+	cfg, _ := NewDefaultConfig()
+	j, _ := cfg.ToJSONConfig()
+
+	j.PinQueueSize = 0
+	cfg2, err := j.ToConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg2.PinQueueSize != PinQueueSize {
+		t.Error("expected the default pin queue size, got ", cfg2.PinQueueSize)
+	}
+
+	j.PinQueueSize = 5000
+	cfg2, err = j.ToConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg2.PinQueueSize != 5000 {
+		t.Error("expected a pin queue size of 5000, got ", cfg2.PinQueueSize)
+	}
+
+	j.PinQueueSize = -1
+	_, err = j.ToConfig()
+	if err == nil {
+		t.Error("expected an error with a negative pin queue size")
+	}
+}
+
+func TestConfigPinWorkers(t *testing.T) {
+	cfg, _ := NewDefaultConfig()
+	j, _ := cfg.ToJSONConfig()
+
+	j.PinWorkers = 0
+	j.UnpinWorkers = 0
+	cfg2, err := j.ToConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg2.PinWorkers != PinWorkers || cfg2.UnpinWorkers != UnpinWorkers {
+		t.Error("expected the default number of workers, got ",
+			cfg2.PinWorkers, cfg2.UnpinWorkers)
+	}
+
+	j.PinWorkers = 4
+	j.UnpinWorkers = 2
+	cfg2, err = j.ToConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg2.PinWorkers != 4 || cfg2.UnpinWorkers != 2 {
+		t.Error("unexpected number of workers: ", cfg2.PinWorkers, cfg2.UnpinWorkers)
+	}
+
+	j.UnpinWorkers = -1
+	_, err = j.ToConfig()
+	if err == nil {
+		t.Error("expected an error with a negative number of workers")
+	}
+}
+
+func TestConfigAPIAuth(t *testing.T) {
+	cfg, _ := NewDefaultConfig()
+	cfg.APIAuthToken = "secret"
+	cfg.APIBasicAuthCredentials = map[string]string{"alice": "pass"}
+	cfg.APIAuthExemptHealth = true
+	j, err := cfg.ToJSONConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg2, err := j.ToConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg2.APIAuthToken != "secret" || cfg2.APIBasicAuthCredentials["alice"] != "pass" ||
+		!cfg2.APIAuthExemptHealth {
+		t.Error("the API credentials were not kept")
+	}
+
+	j.APIBasicAuthCredentials = map[string]string{"al:ice": "pass"}
+	_, err = j.ToConfig()
+	if err == nil {
+		t.Error("expected an error with a user name containing a colon")
+	}
+}
+
+func TestConfigRaftCompressSnapshots(t *testing.T) {
+	cfg, _ := NewDefaultConfig()
+	if cfg.RaftCompressSnapshots != RaftCompressSnapshots {
+		t.Error("expected RaftCompressSnapshots as default")
+	}
+
+	j, _ := cfg.ToJSONConfig()
+	j.RaftCompressSnapshots = true
+	cfg2, err := j.ToConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg2.RaftCompressSnapshots {
+		t.Error("raft_compress_snapshots should have been set")
+	}
+}
+
+func TestValidateRaftTimeouts(t *testing.T) {
+	testcases := []struct {
+		heartbeat int
+		election  int
+		valid     bool
+	}{
+		{0, 0, true},
+		{1000, 2000, true},
+		{1500, 1500, true},
+		{2000, 1000, false},
+		{2000, 0, false},
+		{-1, 0, false},
+		{0, -1, false},
+	}
+	for _, tc := range testcases {
+		err := validateRaftTimeouts(tc.heartbeat, tc.election)
+		if tc.valid && err != nil {
+			t.Errorf("%d/%d: unexpected error: %s", tc.heartbeat, tc.election, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("%d/%d: expected an error", tc.heartbeat, tc.election)
+		}
+	}
+}
+
+func TestConfigEnv(t *testing.T) {
+	cfg, _ := NewDefaultConfig()
+	j, _ := cfg.ToJSONConfig()
+
+	os.Setenv("CLUSTER_APIADDR", "/ip4/0.0.0.0/tcp/9094")
+	os.Setenv("CLUSTER_DATAFOLDER", "/data/ipfs-cluster")
+	os.Setenv("CLUSTER_REPLICATIONFACTOR", "2")
+	defer os.Unsetenv("CLUSTER_APIADDR")
+	defer os.Unsetenv("CLUSTER_DATAFOLDER")
+	defer os.Unsetenv("CLUSTER_REPLICATIONFACTOR")
+
+	cfg2, err := j.ToConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg2.APIAddr.String() != "/ip4/0.0.0.0/tcp/9094" {
+		t.Error("CLUSTER_APIADDR should override the API address: ", cfg2.APIAddr)
+	}
+	if cfg2.ConsensusDataFolder != "/data/ipfs-cluster" {
+		t.Error("CLUSTER_DATAFOLDER should override the data folder: ", cfg2.ConsensusDataFolder)
+	}
+	if cfg2.ReplicationFactor != 2 {
+		t.Error("CLUSTER_REPLICATIONFACTOR should override the replication factor")
+	}
+	if j.ConsensusDataFolder == "/data/ipfs-cluster" {
+		t.Error("the JSONConfig should not be modified")
+	}
+
+	// Saving keeps the overridden options as they were
+	dir, err := ioutil.TempDir("", "cluster-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "service.json")
+	cfg2.PinRetryMaxAttempts = 7
+	if err := cfg2.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadFile(path)
+	var saved JSONConfig
+	if err := json.Unmarshal(b, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.ConsensusDataFolder != j.ConsensusDataFolder ||
+		saved.APIListenMultiaddress != j.APIListenMultiaddress ||
+		saved.ReplicationFactor != j.ReplicationFactor {
+		t.Error("the options set by the environment should not be saved")
+	}
+	if saved.PinRetryMaxAttempts != 7 {
+		t.Error("the other options should be saved")
+	}
+	if cfg2.ConsensusDataFolder != "/data/ipfs-cluster" {
+		t.Error("saving should not change the configuration")
+	}
+
+	cfg3, err := NewDefaultConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg3.ConsensusDataFolder != "/data/ipfs-cluster" {
+		t.Error("CLUSTER_DATAFOLDER should override the default data folder")
+	}
+
+	os.Setenv("CLUSTER_REPLICATIONFACTOR", "two")
+	_, err = j.ToConfig()
+	if err == nil {
+		t.Error("expected an error parsing CLUSTER_REPLICATIONFACTOR")
+	}
+}
+
+func TestConfigIPFSTimeouts(t *testing.T) {
+	cfg, _ := NewDefaultConfig()
+	j, _ := cfg.ToJSONConfig()
+
+	j.IPFSRequestTimeoutSeconds = 0
+	cfg2, err := j.ToConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg2.IPFSRequestTimeoutSeconds != DefaultIPFSRequestTimeoutSeconds {
+		t.Error("expected the default request timeout")
+	}
+	if cfg2.IPFSPinTimeoutSeconds != DefaultIPFSPinTimeoutSeconds {
+		t.Error("expected the default pin timeout")
+	}
+
+	j.IPFSPinTimeoutSeconds = -1
+	_, err = j.ToConfig()
+	if err == nil {
+		t.Error("expected an error with a negative timeout")
+	}
+}
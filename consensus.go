@@ -2,12 +2,19 @@ package ipfscluster
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ipfs/ipfs-cluster/api"
 
+	hashiraft "github.com/hashicorp/raft"
 	rpc "github.com/hsanjuan/go-libp2p-gorpc"
 	consensus "github.com/libp2p/go-libp2p-consensus"
 	host "github.com/libp2p/go-libp2p-host"
@@ -24,6 +31,22 @@ var LeaderTimeout = 15 * time.Second
 // we give up
 var CommitRetries = 2
 
+// CommitRetryDelay specifies how long to wait between commit retries
+var CommitRetryDelay = 200 * time.Millisecond
+
+// MaxLogOpSize specifies the maximum size in bytes of a serialized
+// operation submitted to the consensus log. Larger operations are
+// rejected before being committed. A value <= 0 disables the check.
+var MaxLogOpSize = 512 * 1024
+
+// WaitForIndexTimeout specifies how long to wait for the local state
+// to catch up with a given log index before giving up.
+var WaitForIndexTimeout = 30 * time.Second
+
+// LeaderWatchInterval specifies how often the Consensus checks who the
+// leader is, in order to raise alerts when it changes or is lost.
+var LeaderWatchInterval = time.Second
+
 // Consensus handles the work of keeping a shared-state between
 // the peers of an IPFS Cluster, as well as modifying that state and
 // applying any updates in a thread-safe manner.
@@ -37,10 +60,22 @@ type Consensus struct {
 	baseOp    *LogOp
 	raft      *Raft
 
+	// the state given to NewConsensus, which the FSM holds until
+	// a state is agreed upon
+	initialState State
+
 	rpcClient *rpc.Client
 	rpcReady  chan struct{}
 	readyCh   chan struct{}
 
+	leaderTimeout    time.Duration
+	commitRetries    int
+	commitRetryDelay time.Duration
+
+	// commits attempted by this peer as leader and those which failed
+	commitAttempts uint64
+	commitFailures uint64
+
 	shutdownLock sync.Mutex
 	shutdown     bool
 	shutdownCh   chan struct{}
@@ -50,7 +85,7 @@ type Consensus struct {
 // NewConsensus builds a new ClusterConsensus component. The state
 // is used to initialize the Consensus system, so any information in it
 // is discarded.
-func NewConsensus(clusterPeers []peer.ID, host host.Host, dataFolder string, state State) (*Consensus, error) {
+func NewConsensus(clusterPeers []peer.ID, host host.Host, cfg *Config, state State) (*Consensus, error) {
 	ctx := context.Background()
 	op := &LogOp{
 		ctx: context.Background(),
@@ -58,7 +93,11 @@ func NewConsensus(clusterPeers []peer.ID, host host.Host, dataFolder string, sta
 
 	logger.Infof("starting Consensus and waiting for a leader...")
 	consensus := libp2praft.NewOpLog(state, op)
-	raft, err := NewRaft(clusterPeers, host, dataFolder, consensus.FSM())
+	fsm := &migratingFSM{
+		FSM:       consensus.FSM(),
+		consensus: consensus,
+	}
+	raft, err := NewRaft(clusterPeers, host, cfg, fsm)
 	if err != nil {
 		return nil, err
 	}
@@ -73,8 +112,23 @@ func NewConsensus(clusterPeers []peer.ID, host host.Host, dataFolder string, sta
 		baseOp:     op,
 		raft:       raft,
 		shutdownCh: make(chan struct{}, 1),
-		rpcReady:   make(chan struct{}, 1),
+		rpcReady:   make(chan struct{}),
 		readyCh:    make(chan struct{}, 1),
+
+		initialState: state,
+
+		leaderTimeout:    LeaderTimeout,
+		commitRetries:    CommitRetries,
+		commitRetryDelay: CommitRetryDelay,
+	}
+	if cfg.LeaderTimeoutSeconds > 0 {
+		cc.leaderTimeout = time.Duration(cfg.LeaderTimeoutSeconds) * time.Second
+	}
+	if cfg.CommitRetries > 0 {
+		cc.commitRetries = cfg.CommitRetries
+	}
+	if cfg.CommitRetryDelayMs > 0 {
+		cc.commitRetryDelay = time.Duration(cfg.CommitRetryDelayMs) * time.Millisecond
 	}
 
 	cc.run()
@@ -82,26 +136,86 @@ func NewConsensus(clusterPeers []peer.ID, host host.Host, dataFolder string, sta
 }
 
 func (cc *Consensus) run() {
-	cc.wg.Add(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	cc.ctx = ctx
+	cc.baseOp.ctx = ctx
+
+	cc.wg.Add(2)
 	go func() {
 		defer cc.wg.Done()
-		ctx, cancel := context.WithCancel(context.Background())
 		defer cancel()
-		cc.ctx = ctx
-		cc.baseOp.ctx = ctx
-
 		go cc.finishBootstrap()
 		<-cc.shutdownCh
 	}()
+	go cc.watchLeader(ctx)
+}
+
+// watchLeader checks the leader every LeaderWatchInterval, once RPC is
+// ready, and sends an alert to the Cluster when it changes or when
+// there is none.
+func (cc *Consensus) watchLeader(ctx context.Context) {
+	defer cc.wg.Done()
+	select {
+	case <-ctx.Done():
+		return
+	case <-cc.rpcReady:
+	}
+
+	ticker := time.NewTicker(LeaderWatchInterval)
+	defer ticker.Stop()
+
+	var last peer.ID
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		leader, err := cc.Leader()
+		if err != nil {
+			leader = ""
+		}
+		if leader == last {
+			continue
+		}
+
+		a := api.Alert{
+			Peer: leader,
+			Time: time.Now(),
+		}
+		if leader == "" {
+			a.Severity = api.AlertError
+			a.Code = api.AlertLeaderLost
+			a.Message = "there is no consensus leader"
+			logger.Warning(a.Message)
+		} else {
+			a.Severity = api.AlertInfo
+			a.Code = api.AlertLeaderChanged
+			a.Message = "new consensus leader: " + leader.Pretty()
+			logger.Info(a.Message)
+		}
+		last = leader
+
+		err = cc.rpcClient.Call(
+			"",
+			"Cluster",
+			"SendAlert",
+			a.ToSerial(),
+			&struct{}{})
+		if err != nil {
+			logger.Error(err)
+		}
+	}
 }
 
 // WaitForSync waits for a leader and for the state to be up to date, then returns.
 func (cc *Consensus) WaitForSync() error {
-	leaderCtx, cancel := context.WithTimeout(cc.ctx, LeaderTimeout)
+	leaderCtx, cancel := context.WithTimeout(cc.ctx, cc.leaderTimeout)
 	defer cancel()
 	err := cc.raft.WaitForLeader(leaderCtx)
 	if err != nil {
-		return errors.New("error waiting for leader: " + err.Error())
+		return api.NewError(503, "error waiting for leader: %s", err)
 	}
 	err = cc.raft.WaitForUpdates(cc.ctx)
 	if err != nil {
@@ -120,12 +234,10 @@ func (cc *Consensus) finishBootstrap() {
 	logger.Info("Consensus state is up to date")
 
 	// While rpc is not ready we cannot perform a sync
-	if cc.rpcClient == nil {
-		select {
-		case <-cc.ctx.Done():
-			return
-		case <-cc.rpcReady:
-		}
+	select {
+	case <-cc.ctx.Done():
+		return
+	case <-cc.rpcReady:
 	}
 
 	st, err := cc.State()
@@ -162,7 +274,6 @@ func (cc *Consensus) Shutdown() error {
 
 	logger.Info("stopping Consensus component")
 
-	close(cc.rpcReady)
 	cc.shutdownCh <- struct{}{}
 
 	// Raft shutdown
@@ -186,11 +297,13 @@ func (cc *Consensus) Shutdown() error {
 	return nil
 }
 
-// SetClient makes the component ready to perform RPC requets
+// SetClient makes the component ready to perform RPC requets. It must
+// be called only once.
 func (cc *Consensus) SetClient(c *rpc.Client) {
 	cc.rpcClient = c
 	cc.baseOp.rpcClient = c
-	cc.rpcReady <- struct{}{}
+	// Both finishBootstrap and watchLeader wait for it
+	close(cc.rpcReady)
 }
 
 // Ready returns a channel which is signaled when the Consensus
@@ -216,90 +329,183 @@ func (cc *Consensus) op(argi interface{}, t LogOpType) *LogOp {
 	}
 }
 
+// checkOpSize returns an error if the serialized operation is larger
+// than MaxLogOpSize. Such operations could exceed the limits of the
+// log entries and should not be attempted.
+func checkOpSize(op *LogOp) error {
+	if MaxLogOpSize <= 0 {
+		return nil
+	}
+	b, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	if len(b) > MaxLogOpSize {
+		return api.NewError(413, "operation too large: %d bytes (maximum is %d). Try splitting it in smaller operations",
+			len(b), MaxLogOpSize)
+	}
+	return nil
+}
+
 // returns true if the operation was redirected to the leader
-func (cc *Consensus) redirectToLeader(method string, arg interface{}) (bool, error) {
+func (cc *Consensus) redirectToLeader(method string, arg, reply interface{}) (bool, error) {
 	leader, err := cc.Leader()
 	if err != nil {
-		rctx, cancel := context.WithTimeout(cc.ctx, LeaderTimeout)
+		rctx, cancel := context.WithTimeout(cc.ctx, cc.leaderTimeout)
 		defer cancel()
 		err := cc.raft.WaitForLeader(rctx)
 		if err != nil {
-			return false, err
+			return false, api.NewError(503, "no consensus leader: %s", err)
 		}
 	}
 	if leader == cc.host.ID() {
 		return false, nil
 	}
+	if err := waitForRPC(cc.rpcReady, cc.ctx.Done()); err != nil {
+		return false, err
+	}
 
 	err = cc.rpcClient.Call(
 		leader,
 		"Cluster",
 		method,
 		arg,
-		&struct{}{})
+		reply)
 	return true, err
 }
 
-func (cc *Consensus) logOpCid(rpcOp string, opType LogOpType, carg api.CidArg) error {
+// logOpCid commits a pin or unpin operation and returns the log index
+// at which the local state (or the leader's, when redirected) includes it.
+func (cc *Consensus) logOpCid(rpcOp string, opType LogOpType, carg api.CidArg) (uint64, error) {
+	index, err := cc.logOp(rpcOp, cc.op(carg, opType), carg.ToSerial())
+	if err != nil {
+		return 0, err
+	}
+
+	switch opType {
+	case LogOpPin:
+		logger.Infof("pin committed to global state: %s", carg.Cid)
+	case LogOpUnpin:
+		logger.Infof("unpin committed to global state: %s", carg.Cid)
+	}
+	return index, nil
+}
+
+// logOp commits an operation, or redirects it to the leader calling
+// rpcOp with arg, and returns the log index at which the local state
+// (or the leader's, when redirected) includes it.
+func (cc *Consensus) logOp(rpcOp string, op *LogOp, arg interface{}) (uint64, error) {
+	if err := checkOpSize(op); err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	var index uint64
 	var finalErr error
-	for i := 0; i < CommitRetries; i++ {
+	for i := 0; i < cc.commitRetries; i++ {
 		logger.Debugf("Try %d", i)
 		redirected, err := cc.redirectToLeader(
-			rpcOp, carg.ToSerial())
+			rpcOp, arg, &index)
 		if err != nil {
 			finalErr = err
 			continue
 		}
 
 		if redirected {
-			return nil
+			return index, nil
 		}
 
 		// It seems WE are the leader.
-
-		op := cc.op(carg, opType)
-		_, err = cc.consensus.CommitOp(op)
+		err = cc.commitOp(op)
 		if err != nil {
 			// This means the op did not make it to the log
 			finalErr = err
-			time.Sleep(200 * time.Millisecond)
+			time.Sleep(cc.commitRetryDelay)
 			continue
 		}
+		// CommitOp returns once the operation has been applied
+		// locally, so the applied index includes it.
+		index = cc.raft.AppliedIndex()
 		finalErr = nil
 		break
 	}
 	if finalErr != nil {
-		return finalErr
+		return 0, finalErr
 	}
+	return index, nil
+}
 
-	switch opType {
-	case LogOpPin:
-		logger.Infof("pin committed to global state: %s", carg.Cid)
-	case LogOpUnpin:
-		logger.Infof("unpin committed to global state: %s", carg.Cid)
+// commitOp commits an operation to the log, keeping count of the
+// attempts and failures.
+func (cc *Consensus) commitOp(op *LogOp) error {
+	atomic.AddUint64(&cc.commitAttempts, 1)
+	_, err := cc.consensus.CommitOp(op)
+	if err != nil {
+		atomic.AddUint64(&cc.commitFailures, 1)
 	}
-	return nil
+	return err
+}
+
+// CommitStats returns how many operations this peer has tried to commit
+// to the log, as leader, and how many of those attempts failed.
+func (cc *Consensus) CommitStats() (attempts, failures uint64) {
+	return atomic.LoadUint64(&cc.commitAttempts), atomic.LoadUint64(&cc.commitFailures)
 }
 
 // LogPin submits a Cid to the shared state of the cluster. It will forward
-// the operation to the leader if this is not it.
-func (cc *Consensus) LogPin(c api.CidArg) error {
+// the operation to the leader if this is not it. It returns the log index
+// at which the pin was committed.
+func (cc *Consensus) LogPin(c api.CidArg) (uint64, error) {
 	return cc.logOpCid("ConsensusLogPin", LogOpPin, c)
 }
 
-// LogUnpin removes a Cid from the shared state of the cluster.
-func (cc *Consensus) LogUnpin(c api.CidArg) error {
+// LogPinMany submits several Cids to the shared state of the cluster
+// in a single operation. It returns the log index at which they were
+// committed.
+func (cc *Consensus) LogPinMany(cargs []api.CidArg) (uint64, error) {
+	serials := make([]api.CidArgSerial, len(cargs), len(cargs))
+	for i, carg := range cargs {
+		serials[i] = carg.ToSerial()
+	}
+	op := &LogOp{
+		Cids: serials,
+		Type: LogOpPinMany,
+	}
+	index, err := cc.logOp("ConsensusLogPinMany", op, serials)
+	if err != nil {
+		return 0, err
+	}
+	logger.Infof("%d pins committed to global state", len(cargs))
+	return index, nil
+}
+
+// LogUnpin removes a Cid from the shared state of the cluster. It returns
+// the log index at which the unpin was committed.
+func (cc *Consensus) LogUnpin(c api.CidArg) (uint64, error) {
 	return cc.logOpCid("ConsensusLogUnpin", LogOpUnpin, c)
 }
 
+// AppliedIndex returns the index of the last log entry applied
+// to the local state.
+func (cc *Consensus) AppliedIndex() uint64 {
+	return cc.raft.AppliedIndex()
+}
+
+// WaitForIndex blocks until the local state has applied the log
+// entry with the given index, or the context is cancelled. It can be
+// used to read-your-writes after LogPin or LogUnpin.
+func (cc *Consensus) WaitForIndex(ctx context.Context, index uint64) error {
+	return cc.raft.WaitForIndex(ctx, index)
+}
+
 // LogAddPeer submits a new peer to the shared state of the cluster. It will
 // forward the operation to the leader if this is not it.
 func (cc *Consensus) LogAddPeer(addr ma.Multiaddr) error {
 	var finalErr error
-	for i := 0; i < CommitRetries; i++ {
+	for i := 0; i < cc.commitRetries; i++ {
 		logger.Debugf("Try %d", i)
 		redirected, err := cc.redirectToLeader(
-			"ConsensusLogAddPeer", api.MultiaddrToSerial(addr))
+			"ConsensusLogAddPeer", api.MultiaddrToSerial(addr), &struct{}{})
 		if err != nil {
 			finalErr = err
 			continue
@@ -317,11 +523,11 @@ func (cc *Consensus) LogAddPeer(addr ma.Multiaddr) error {
 
 		// Create pin operation for the log
 		op := cc.op(addr, LogOpAddPeer)
-		_, err = cc.consensus.CommitOp(op)
+		err = cc.commitOp(op)
 		if err != nil {
 			// This means the op did not make it to the log
 			finalErr = err
-			time.Sleep(200 * time.Millisecond)
+			time.Sleep(cc.commitRetryDelay)
 			continue
 		}
 		err = cc.raft.AddPeer(peer.IDB58Encode(pid))
@@ -343,9 +549,9 @@ func (cc *Consensus) LogAddPeer(addr ma.Multiaddr) error {
 // forward the operation to the leader if this is not it.
 func (cc *Consensus) LogRmPeer(pid peer.ID) error {
 	var finalErr error
-	for i := 0; i < CommitRetries; i++ {
+	for i := 0; i < cc.commitRetries; i++ {
 		logger.Debugf("Try %d", i)
-		redirected, err := cc.redirectToLeader("ConsensusLogRmPeer", pid)
+		redirected, err := cc.redirectToLeader("ConsensusLogRmPeer", pid, &struct{}{})
 		if err != nil {
 			finalErr = err
 			continue
@@ -363,7 +569,7 @@ func (cc *Consensus) LogRmPeer(pid peer.ID) error {
 			return err
 		}
 		op := cc.op(addr, LogOpRmPeer)
-		_, err = cc.consensus.CommitOp(op)
+		err = cc.commitOp(op)
 		if err != nil {
 			// This means the op did not make it to the log
 			finalErr = err
@@ -372,7 +578,7 @@ func (cc *Consensus) LogRmPeer(pid peer.ID) error {
 		err = cc.raft.RemovePeer(peer.IDB58Encode(pid))
 		if err != nil {
 			finalErr = err
-			time.Sleep(200 * time.Millisecond)
+			time.Sleep(cc.commitRetryDelay)
 			continue
 		}
 		finalErr = nil
@@ -401,6 +607,21 @@ func (cc *Consensus) State() (State, error) {
 	return state, nil
 }
 
+// LocalState returns the copy of the shared State held by this peer,
+// without requiring a leader nor an agreed-upon State. When no State
+// has been agreed upon yet, the initial one is returned. The second
+// value is true when the copy may be stale, that is, when there is no
+// leader or no State has been agreed upon: updates may have been
+// committed which this peer has not seen.
+func (cc *Consensus) LocalState() (State, bool) {
+	st, err := cc.State()
+	if err != nil {
+		return cc.initialState, true
+	}
+	_, err = cc.Leader()
+	return st, err != nil
+}
+
 // Leader returns the peerID of the Leader of the
 // cluster. It returns an error when there is no leader.
 func (cc *Consensus) Leader() (peer.ID, error) {
@@ -408,6 +629,145 @@ func (cc *Consensus) Leader() (peer.ID, error) {
 	return raftactor.Leader()
 }
 
+// RaftPeers returns the IDs of the peers in the Raft configuration, as
+// seen by this peer, sorted. This membership is what counts for
+// elections and quorum, and may differ from the set of cluster peers,
+// i.e. while peers are being added or removed.
+func (cc *Consensus) RaftPeers() ([]peer.ID, error) {
+	peers, err := cc.raft.Peers()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(peers)
+	pids := make([]peer.ID, 0, len(peers))
+	for _, p := range peers {
+		pid, err := peer.IDB58Decode(p)
+		if err != nil {
+			return nil, fmt.Errorf("bad peer in Raft configuration %s: %s", p, err)
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
+// RaftConfiguration returns the servers taking part in Raft, as seen
+// by this peer. See RaftPeers(). The Raft version in use does not
+// support non-voting servers, so all of them are voters.
+func (cc *Consensus) RaftConfiguration() ([]api.RaftServer, error) {
+	pids, err := cc.RaftPeers()
+	if err != nil {
+		return nil, err
+	}
+	// There may be no leader at the moment
+	leader, _ := cc.Leader()
+
+	servers := make([]api.RaftServer, 0, len(pids))
+	for _, pid := range pids {
+		servers = append(servers, api.RaftServer{
+			ID:       pid,
+			Suffrage: api.RaftVoter,
+			Leader:   pid == leader,
+		})
+	}
+	return servers, nil
+}
+
+// Snapshot makes the consensus leader take a snapshot of the shared
+// state, so that its log is compacted, i.e. before taking backups.
+// Peers which are not the leader redirect the request to it.
+func (cc *Consensus) Snapshot() error {
+	redirected, err := cc.redirectToLeader("ConsensusSnapshot", struct{}{}, &struct{}{})
+	if err != nil || redirected {
+		return err
+	}
+	logger.Info("taking a snapshot of the consensus state")
+	return cc.raft.Snapshot()
+}
+
+// SnapshotLocal makes this peer take a snapshot of its copy of the
+// shared state, whether it is the leader or not.
+func (cc *Consensus) SnapshotLocal() error {
+	logger.Info("taking a snapshot of the local consensus state")
+	return cc.raft.Snapshot()
+}
+
+// ExportState returns the shared state, as known by this peer,
+// serialized by the state itself. It can be restored with
+// ImportState().
+func (cc *Consensus) ExportState() ([]byte, error) {
+	st, err := cc.State()
+	if err != nil {
+		return nil, err
+	}
+	return st.Marshal()
+}
+
+// ImportState replaces the shared state with one obtained with
+// ExportState(). Every pin is validated before anything is replaced.
+// Only the leader can replace the state, so peers which are not the
+// leader redirect the request to it.
+func (cc *Consensus) ImportState(data []byte) error {
+	st, err := cc.decodeState(data)
+	if err != nil {
+		return err
+	}
+	redirected, err := cc.redirectToLeader("ConsensusImportState", data, &struct{}{})
+	if err != nil || redirected {
+		return err
+	}
+	logger.Warningf("replacing the shared state with an imported one with %d pins",
+		st.Len())
+	return cc.Rollback(st)
+}
+
+// decodeState decodes a state exported with ExportState() into a new
+// state of the same type as the initial one. It fails with a 400 error
+// when the state cannot be decoded or any of the pins is not valid.
+func (cc *Consensus) decodeState(data []byte) (State, error) {
+	t := reflect.TypeOf(cc.initialState)
+	if t.Kind() != reflect.Ptr {
+		return nil, errors.New("the state cannot be imported")
+	}
+	st, ok := reflect.New(t.Elem()).Interface().(State)
+	if !ok {
+		return nil, errors.New("the state cannot be imported")
+	}
+	err := st.Unmarshal(data)
+	if err != nil {
+		return nil, api.NewError(400, "error decoding state: %s", err)
+	}
+	return st, nil
+}
+
+// migratingFSM wraps the Raft FSM so that the state is migrated to
+// the current version every time it is restored from a snapshot.
+type migratingFSM struct {
+	hashiraft.FSM
+	consensus *libp2praft.Consensus
+}
+
+// Restore restores the state from a snapshot and migrates it.
+func (fsm *migratingFSM) Restore(r io.ReadCloser) error {
+	err := fsm.FSM.Restore(r)
+	if err != nil {
+		return err
+	}
+	st, err := fsm.consensus.GetLogHead()
+	if err != nil {
+		return err
+	}
+	state, ok := st.(State)
+	if !ok {
+		return errors.New("wrong state type")
+	}
+	err = state.Migrate()
+	if err != nil {
+		logger.Error(err)
+	}
+	return err
+}
+
 // Rollback replaces the current agreed-upon
 // state with the state provided. Only the consensus leader
 // can perform this operation.
@@ -27,7 +27,7 @@ func testingConsensus(t *testing.T) *Consensus {
 		t.Fatal("cannot create host:", err)
 	}
 	st := mapstate.NewMapState()
-	cc, err := NewConsensus([]peer.ID{cfg.ID}, h, cfg.ConsensusDataFolder, st)
+	cc, err := NewConsensus([]peer.ID{cfg.ID}, h, cfg, st)
 	if err != nil {
 		t.Fatal("cannot create Consensus:", err)
 	}
@@ -59,7 +59,7 @@ func TestConsensusPin(t *testing.T) {
 	defer cc.Shutdown()
 
 	c, _ := cid.Decode(test.TestCid1)
-	err := cc.LogPin(api.CidArg{Cid: c, Everywhere: true})
+	_, err := cc.LogPin(api.CidArg{Cid: c, Everywhere: true})
 	if err != nil {
 		t.Error("the operation did not make it to the log:", err)
 	}
@@ -76,13 +76,61 @@ func TestConsensusPin(t *testing.T) {
 	}
 }
 
+func TestConsensusWaitForIndex(t *testing.T) {
+	cc := testingConsensus(t)
+	defer cleanRaft()
+	defer cc.Shutdown()
+
+	c, _ := cid.Decode(test.TestCid1)
+	index, err := cc.LogPin(api.CidArg{Cid: c, Everywhere: true})
+	if err != nil {
+		t.Fatal("the operation did not make it to the log:", err)
+	}
+	if index == 0 {
+		t.Error("expected a non-zero log index")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = cc.WaitForIndex(ctx, index)
+	if err != nil {
+		t.Error("the index should have been applied:", err)
+	}
+	if cc.AppliedIndex() < index {
+		t.Error("applied index should be at least the pin index")
+	}
+
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel2()
+	err = cc.WaitForIndex(ctx2, index+100)
+	if err == nil {
+		t.Error("expected a timeout waiting for a future index")
+	}
+}
+
+func TestConsensusPinTooLarge(t *testing.T) {
+	cc := testingConsensus(t)
+	defer cleanRaft()
+	defer cc.Shutdown()
+
+	oldMax := MaxLogOpSize
+	MaxLogOpSize = 10
+	defer func() { MaxLogOpSize = oldMax }()
+
+	c, _ := cid.Decode(test.TestCid1)
+	_, err := cc.LogPin(api.CidArg{Cid: c, Everywhere: true})
+	if err == nil {
+		t.Error("expected an error for an oversized operation")
+	}
+}
+
 func TestConsensusUnpin(t *testing.T) {
 	cc := testingConsensus(t)
 	defer cleanRaft()
 	defer cc.Shutdown()
 
 	c, _ := cid.Decode(test.TestCid2)
-	err := cc.LogUnpin(api.CidArgCid(c))
+	_, err := cc.LogUnpin(api.CidArgCid(c))
 	if err != nil {
 		t.Error("the operation did not make it to the log:", err)
 	}
@@ -103,3 +151,144 @@ func TestConsensusLeader(t *testing.T) {
 		t.Errorf("expected %s but the leader appears as %s", pID, l)
 	}
 }
+
+func TestConsensusLocalState(t *testing.T) {
+	cc := testingConsensus(t)
+	defer cleanRaft()
+	defer cc.Shutdown()
+
+	c, _ := cid.Decode(test.TestCid1)
+	_, err := cc.LogPin(api.CidArg{Cid: c, Everywhere: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(250 * time.Millisecond)
+
+	st, stale := cc.LocalState()
+	if stale {
+		t.Error("the state should not be stale with a leader")
+	}
+	if !st.Has(c) {
+		t.Error("the local state should have the pin")
+	}
+}
+
+func TestConsensusRaftConfiguration(t *testing.T) {
+	cc := testingConsensus(t)
+	cfg := testingConfig()
+	defer cleanRaft()
+	defer cc.Shutdown()
+
+	servers, err := cc.RaftConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(servers) != 1 {
+		t.Fatal("expected a single server:", servers)
+	}
+	s := servers[0]
+	if s.ID != cfg.ID || !s.Leader || s.Suffrage != api.RaftVoter {
+		t.Errorf("unexpected server: %+v", s)
+	}
+}
+
+func TestConsensusRaftPeers(t *testing.T) {
+	cc := testingConsensus(t)
+	cfg := testingConfig()
+	defer cleanRaft()
+	defer cc.Shutdown()
+
+	pids, err := cc.RaftPeers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pids) != 1 || pids[0] != cfg.ID {
+		t.Error("expected only this peer in the Raft configuration:", pids)
+	}
+}
+
+func TestConsensusSnapshot(t *testing.T) {
+	cc := testingConsensus(t)
+	defer cleanRaft()
+	defer cc.Shutdown()
+
+	c, _ := cid.Decode(test.TestCid1)
+	_, err := cc.LogPin(api.CidArg{Cid: c, Everywhere: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cc.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// nothing new to snapshot is not an error
+	err = cc.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConsensusExportImportState(t *testing.T) {
+	cc := testingConsensus(t)
+	defer cleanRaft()
+	defer cc.Shutdown()
+
+	c, _ := cid.Decode(test.TestCid1)
+	_, err := cc.LogPin(api.CidArg{Cid: c, Everywhere: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := cc.ExportState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cc.LogUnpin(api.CidArgCid(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = cc.ImportState(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(250 * time.Millisecond)
+	st, err := cc.State()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st.Has(c) {
+		t.Error("the imported state should have the pin")
+	}
+}
+
+func TestDecodeState(t *testing.T) {
+	testcases := []struct {
+		data  string
+		valid bool
+	}{
+		{`{"PinMap":{"` + test.TestCid1 + `":{"cid":"` + test.TestCid1 + `","allocations":["` +
+			test.TestPeerID1.Pretty() + `"]}},"Version":1}`, true},
+		{`{"PinMap":{}}`, true},
+		{`{"PinMap":{"abc":{"cid":"abc"}},"Version":1}`, false},
+		{`{"PinMap":{"` + test.TestCid1 + `":{"cid":"` + test.TestCid2 + `"}},"Version":1}`, false},
+		{`{"PinMap":{"` + test.TestCid1 + `":{"cid":"` + test.TestCid1 + `","allocations":["abc"]}}}`, false},
+		{`{"PinMap":{"` + test.TestCid1 + `":{"cid":"` + test.TestCid1 + `","type":"abc"}}}`, false},
+		{`{"PinMap":{},"Version":1000}`, false},
+		{`[]`, false},
+	}
+	cc := &Consensus{initialState: mapstate.NewMapState()}
+	for i, tc := range testcases {
+		_, err := cc.decodeState([]byte(tc.data))
+		if tc.valid && err != nil {
+			t.Errorf("%d: unexpected error: %s", i, err)
+		}
+		if tc.valid {
+			continue
+		}
+		if err == nil {
+			t.Errorf("%d: expected an error", i)
+		} else if code, _ := api.ErrorCode(err); code != 400 {
+			t.Errorf("%d: expected a 400 error: %s", i, err)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package ipfscluster
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ipfs/ipfs-cluster/api"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// ConsensusView returns what this peer sees of the consensus: the
+// cluster peers, the current leader and the number of pins and
+// checksum of the shared state.
+func (c *Cluster) ConsensusView() api.ConsensusView {
+	view := api.ConsensusView{
+		Peer:         c.id,
+		ClusterPeers: sortPeers(c.peerManager.peers()),
+	}
+
+	leader, err := c.consensus.Leader()
+	if err != nil {
+		view.Error = err.Error()
+		return view
+	}
+	view.Leader = leader
+
+	st, err := c.consensus.State()
+	if err != nil {
+		view.Error = err.Error()
+		return view
+	}
+	view.PinCount = len(st.List())
+	view.StateChecksum = st.Checksum()
+	return view
+}
+
+// ConsistencyCheck asks every cluster peer for its ConsensusView and
+// reports the peers which do not agree with the majority on the peer
+// set, the leader or the shared state, as well as those which cannot
+// be contacted. Disagreements point to serious problems, like network
+// partitions or corrupted states. Note that states may briefly differ
+// while the latest operations are being applied.
+func (c *Cluster) ConsistencyCheck() api.ConsistencyReport {
+	members := c.peerManager.peers()
+	replies := make([]api.ConsensusViewSerial, len(members), len(members))
+	ifaces := make([]interface{}, len(members), len(members))
+	for i := range replies {
+		ifaces[i] = &replies[i]
+	}
+	errs := c.multiRPC(c.ctx, members, "Cluster", "ConsensusView", struct{}{}, ifaces)
+
+	views := make([]api.ConsensusView, len(members), len(members))
+	for i, r := range replies {
+		if errs[i] != nil {
+			views[i] = api.ConsensusView{
+				Peer:  members[i],
+				Error: errs[i].Error(),
+			}
+			continue
+		}
+		views[i] = r.ToConsensusView()
+	}
+	return checkConsistency(views)
+}
+
+// checkConsistency compares the given views and builds a report
+// with the disagreements among them.
+func checkConsistency(views []api.ConsensusView) api.ConsistencyReport {
+	report := api.ConsistencyReport{
+		Views: views,
+	}
+
+	peerSets := make(map[peer.ID]string)
+	leaders := make(map[peer.ID]string)
+	states := make(map[peer.ID]string)
+	for _, v := range views {
+		if v.Error != "" {
+			report.Disagreements = append(report.Disagreements,
+				fmt.Sprintf("%s: error: %s", v.Peer.Pretty(), v.Error))
+			continue
+		}
+		var set []string
+		for _, p := range sortPeers(v.ClusterPeers) {
+			set = append(set, p.Pretty())
+		}
+		peerSets[v.Peer] = "[" + strings.Join(set, " ") + "]"
+		leaders[v.Peer] = v.Leader.Pretty()
+		states[v.Peer] = fmt.Sprintf("%d pins (checksum %s)",
+			v.PinCount, v.StateChecksum)
+	}
+
+	for _, d := range []struct {
+		what   string
+		values map[peer.ID]string
+	}{
+		{"peer set", peerSets},
+		{"leader", leaders},
+		{"state", states},
+	} {
+		major := majority(d.values)
+		for _, v := range views {
+			val, ok := d.values[v.Peer]
+			if !ok || val == major {
+				continue
+			}
+			report.Disagreements = append(report.Disagreements,
+				fmt.Sprintf("%s: %s differs from the majority: %s (majority: %s)",
+					v.Peer.Pretty(), d.what, val, major))
+		}
+	}
+
+	report.Consistent = len(report.Disagreements) == 0
+	return report
+}
+
+// majority returns the most common value in the map. Ties are
+// resolved by choosing the smallest value.
+func majority(values map[peer.ID]string) string {
+	counts := make(map[string]int)
+	for _, v := range values {
+		counts[v]++
+	}
+	var major string
+	max := 0
+	for v, n := range counts {
+		if n > max || (n == max && v < major) {
+			major = v
+			max = n
+		}
+	}
+	return major
+}
+
+// sortPeers returns a sorted copy of the given peers.
+func sortPeers(peers []peer.ID) []peer.ID {
+	sorted := make([]peer.ID, len(peers))
+	copy(sorted, peers)
+	sort.Sort(peerIDs(sorted))
+	return sorted
+}
+
+type peerIDs []peer.ID
+
+func (ps peerIDs) Len() int           { return len(ps) }
+func (ps peerIDs) Swap(i, j int)      { ps[i], ps[j] = ps[j], ps[i] }
+func (ps peerIDs) Less(i, j int) bool { return ps[i] < ps[j] }
@@ -0,0 +1,49 @@
+package ipfscluster
+
+import (
+	"testing"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/test"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+func TestCheckConsistency(t *testing.T) {
+	p1 := test.TestPeerID1
+	p2 := test.TestPeerID2
+	p3 := test.TestPeerID3
+	all := []peer.ID{p1, p2, p3}
+
+	view := func(p peer.ID, peers []peer.ID, checksum string) api.ConsensusView {
+		return api.ConsensusView{
+			Peer:          p,
+			ClusterPeers:  peers,
+			Leader:        p1,
+			PinCount:      2,
+			StateChecksum: checksum,
+		}
+	}
+
+	report := checkConsistency([]api.ConsensusView{
+		view(p1, all, "a"),
+		view(p2, []peer.ID{p3, p2, p1}, "a"),
+		view(p3, all, "a"),
+	})
+	if !report.Consistent || len(report.Disagreements) != 0 {
+		t.Error("expected a consistent report:", report.Disagreements)
+	}
+
+	report = checkConsistency([]api.ConsensusView{
+		view(p1, all, "a"),
+		view(p2, []peer.ID{p1, p2}, "b"),
+		{Peer: p3, Error: "unreachable"},
+	})
+	if report.Consistent {
+		t.Error("expected an inconsistent report")
+	}
+	// p3 is unreachable, p2 disagrees on peer set and state
+	if len(report.Disagreements) != 3 {
+		t.Error("expected 3 disagreements:", report.Disagreements)
+	}
+}
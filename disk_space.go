@@ -0,0 +1,114 @@
+package ipfscluster
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ipfs/ipfs-cluster/api"
+)
+
+// DiskSpaceCheckInterval is how often the free space in the consensus
+// data folder is checked.
+var DiskSpaceCheckInterval = 30 * time.Second
+
+var errLowDiskSpace = errors.New("free disk space is below the configured threshold: this peer is not accepting new pins")
+
+// diskSpace keeps track of the free space in the filesystem holding
+// the consensus data folder, so that running out of space can be
+// reported clearly instead of through failing commits and pins.
+type diskSpace struct {
+	path      string
+	threshold uint64 // bytes, 0 disables the low space warning
+
+	mux  sync.RWMutex
+	free uint64
+	low  bool
+	err  error
+}
+
+func newDiskSpace(path string, thresholdMB int) *diskSpace {
+	ds := &diskSpace{path: path}
+	if thresholdMB > 0 {
+		ds.threshold = uint64(thresholdMB) * 1024 * 1024
+	}
+	return ds
+}
+
+// check updates the free disk space, logging when it
+// drops below the threshold or recovers from it.
+func (ds *diskSpace) check() {
+	free, err := freeDiskSpace(ds.path)
+
+	ds.mux.Lock()
+	defer ds.mux.Unlock()
+	ds.err = err
+	if err != nil {
+		logger.Warningf("could not check free disk space in %s: %s", ds.path, err)
+		return
+	}
+	ds.free = free
+
+	low := ds.threshold > 0 && free < ds.threshold
+	switch {
+	case low && !ds.low:
+		logger.Error("**************************************************")
+		logger.Errorf("LOW DISK SPACE: %d MB free in %s (threshold: %d MB)",
+			free/(1024*1024), ds.path, ds.threshold/(1024*1024))
+		logger.Error("Commits and pins may start failing. Free up space.")
+		logger.Error("**************************************************")
+	case !low && ds.low:
+		logger.Infof("free disk space in %s is above the threshold again", ds.path)
+	}
+	ds.low = low
+}
+
+// isLow returns true when the last check found less free
+// space than the threshold.
+func (ds *diskSpace) isLow() bool {
+	ds.mux.RLock()
+	defer ds.mux.RUnlock()
+	return ds.low
+}
+
+// diskSpaceWatcher checks the free disk space every
+// DiskSpaceCheckInterval.
+func (c *Cluster) diskSpaceWatcher() {
+	c.diskSpace.check()
+	ticker := time.NewTicker(DiskSpaceCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.diskSpace.check()
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// readOnly returns true when new pins should be rejected
+// because this peer is running out of disk space.
+func (c *Cluster) readOnly() bool {
+	return c.config.ReadOnlyOnLowDiskSpace && c.diskSpace.isLow()
+}
+
+// Health returns information about conditions affecting
+// this peer, like low disk space in the consensus data folder.
+func (c *Cluster) Health() api.Health {
+	c.diskSpace.mux.RLock()
+	defer c.diskSpace.mux.RUnlock()
+	h := api.Health{
+		DiskFree:      c.diskSpace.free,
+		DiskThreshold: c.diskSpace.threshold,
+		LowDiskSpace:  c.diskSpace.low,
+		ReadOnly:      c.config.ReadOnlyOnLowDiskSpace && c.diskSpace.low,
+	}
+	load := c.tracker.Load()
+	h.PinQueueLength = load.QueueLength
+	h.PinQueueCapacity = load.QueueCapacity
+	if c.diskSpace.err != nil {
+		h.Error = c.diskSpace.err.Error()
+	}
+	return h
+}
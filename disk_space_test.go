@@ -0,0 +1,43 @@
+package ipfscluster
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestDiskSpaceCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskspace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// nobody has this much free space
+	ds := newDiskSpace(dir, 1<<40)
+	ds.check()
+	if ds.err != nil {
+		t.Fatal(ds.err)
+	}
+	if ds.free == 0 {
+		t.Error("expected some free space")
+	}
+	if !ds.isLow() {
+		t.Error("expected low disk space")
+	}
+
+	ds = newDiskSpace(dir, -1)
+	ds.check()
+	if ds.threshold != 0 || ds.isLow() {
+		t.Error("disabled check should never report low disk space")
+	}
+
+	ds = newDiskSpace(dir+"/doesnotexist", 1)
+	ds.check()
+	if ds.err == nil {
+		t.Error("expected an error for a missing folder")
+	}
+	if ds.isLow() {
+		t.Error("errors should not report low disk space")
+	}
+}
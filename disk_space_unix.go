@@ -0,0 +1,15 @@
+// +build !windows
+
+package ipfscluster
+
+import "syscall"
+
+// freeDiskSpace returns the space, in bytes, available to unprivileged
+// users in the filesystem holding the given path.
+func freeDiskSpace(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
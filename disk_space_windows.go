@@ -0,0 +1,10 @@
+// +build windows
+
+package ipfscluster
+
+import "errors"
+
+// freeDiskSpace is not supported on Windows.
+func freeDiskSpace(path string) (uint64, error) {
+	return 0, errors.New("checking free disk space is not supported on windows")
+}
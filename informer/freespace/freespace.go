@@ -0,0 +1,87 @@
+// Package freespace implements an ipfs-cluster informer which determines
+// how much space is left in the IPFS repository of this peer and returns
+// it as api.Metric
+package freespace
+
+import (
+	"fmt"
+
+	rpc "github.com/hsanjuan/go-libp2p-gorpc"
+	logging "github.com/ipfs/go-log"
+
+	"github.com/ipfs/ipfs-cluster/api"
+)
+
+var logger = logging.Logger("freespace")
+
+// MetricTTL specifies how long our reported metric is valid in seconds.
+var MetricTTL = 30
+
+// MetricName specifies the name of our metric
+var MetricName = "freespace"
+
+// Informer is a simple object to implement the ipfscluster.Informer
+// and Component interfaces
+type Informer struct {
+	rpcClient *rpc.Client
+}
+
+// NewInformer returns an initialized Informer.
+func NewInformer() *Informer {
+	return &Informer{}
+}
+
+// SetClient provides us with an rpc.Client which allows
+// contacting other components in the cluster.
+func (fsi *Informer) SetClient(c *rpc.Client) {
+	fsi.rpcClient = c
+}
+
+// Shutdown is called on cluster shutdown. We just invalidate
+// any metrics from this point.
+func (fsi *Informer) Shutdown() error {
+	fsi.rpcClient = nil
+	return nil
+}
+
+// Name returns the name of this informer
+func (fsi *Informer) Name() string {
+	return MetricName
+}
+
+// GetMetric contacts the IPFSConnector component and requests
+// the `repo stat` command. We return the number of bytes left
+// before the repository reaches its StorageMax.
+func (fsi *Informer) GetMetric() api.Metric {
+	rpcClient := fsi.rpcClient
+	if rpcClient == nil {
+		return api.Metric{
+			Valid: false,
+		}
+	}
+
+	var repoStat api.IPFSRepoStat
+
+	// make use of the RPC API to obtain information
+	// about the IPFS repository. See RPCAPI docs.
+	err := rpcClient.Call("", // Local call
+		"Cluster",      // Service name
+		"IPFSRepoStat", // Method name
+		struct{}{},     // in arg
+		&repoStat)      // out arg
+	if err != nil {
+		logger.Debugf("error obtaining the repository stats: %s", err)
+		return api.Metric{
+			Name:  MetricName,
+			Valid: false,
+		}
+	}
+
+	m := api.Metric{
+		Name:  MetricName,
+		Value: fmt.Sprintf("%d", repoStat.FreeSpace()),
+		Valid: true,
+	}
+	m.SetTTL(MetricTTL)
+	return m
+}
@@ -0,0 +1,60 @@
+package freespace
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ipfs/ipfs-cluster/api"
+
+	rpc "github.com/hsanjuan/go-libp2p-gorpc"
+)
+
+type mockService struct {
+	err bool
+}
+
+func mockRPCClient(t *testing.T, mock *mockService) *rpc.Client {
+	s := rpc.NewServer(nil, "mock")
+	c := rpc.NewClientWithServer(nil, "mock", s)
+	err := s.RegisterName("Cluster", mock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func (mock *mockService) IPFSRepoStat(in struct{}, out *api.IPFSRepoStat) error {
+	if mock.err {
+		return errors.New("repo/stat failed")
+	}
+	*out = api.IPFSRepoStat{
+		RepoSize:   1000,
+		StorageMax: 5000,
+	}
+	return nil
+}
+
+func Test(t *testing.T) {
+	inf := NewInformer()
+	m := inf.GetMetric()
+	if m.Valid {
+		t.Error("metric should be invalid")
+	}
+	inf.SetClient(mockRPCClient(t, &mockService{}))
+	m = inf.GetMetric()
+	if !m.Valid {
+		t.Error("metric should be valid")
+	}
+	if m.Value != "4000" {
+		t.Error("bad metric value:", m.Value)
+	}
+}
+
+func TestRPCError(t *testing.T) {
+	inf := NewInformer()
+	inf.SetClient(mockRPCClient(t, &mockService{err: true}))
+	m := inf.GetMetric()
+	if m.Valid || m.Name != MetricName {
+		t.Error("expected an invalid freespace metric")
+	}
+}
@@ -4,22 +4,46 @@ package numpin
 
 import (
 	"fmt"
+	"sync"
+	"time"
 
 	rpc "github.com/hsanjuan/go-libp2p-gorpc"
+	logging "github.com/ipfs/go-log"
 
 	"github.com/ipfs/ipfs-cluster/api"
 )
 
+var logger = logging.Logger("numpin")
+
 // MetricTTL specifies how long our reported metric is valid in seconds.
+// The number of pins is cached for this long, so that frequent calls
+// to GetMetric do not list all the pins in IPFS every time.
 var MetricTTL = 10
 
 // MetricName specifies the name of our metric
 var MetricName = "numpin"
 
+// CacheStats shows how GetMetric requests have been served.
+type CacheStats struct {
+	// Requests answered from the cache
+	Hits uint64
+	// Requests which had to wait for IPFS to list the pins
+	Misses uint64
+	// Background refreshes of the cache before expiring
+	Refreshes uint64
+	// Time since the cached value was obtained
+	Age time.Duration
+}
+
 // Informer is a simple object to implement the ipfscluster.Informer
 // and Component interfaces
 type Informer struct {
-	rpcClient *rpc.Client
+	mux        sync.Mutex
+	rpcClient  *rpc.Client
+	count      int
+	updated    time.Time // zero when there is no cached count
+	refreshing bool
+	stats      CacheStats
 }
 
 // NewInformer returns an initialized Informer.
@@ -30,13 +54,18 @@ func NewInformer() *Informer {
 // SetClient provides us with an rpc.Client which allows
 // contacting other components in the cluster.
 func (npi *Informer) SetClient(c *rpc.Client) {
+	npi.mux.Lock()
 	npi.rpcClient = c
+	npi.mux.Unlock()
 }
 
 // Shutdown is called on cluster shutdown. We just invalidate
 // any metrics from this point.
 func (npi *Informer) Shutdown() error {
+	npi.mux.Lock()
 	npi.rpcClient = nil
+	npi.updated = time.Time{}
+	npi.mux.Unlock()
 	return nil
 }
 
@@ -48,31 +77,110 @@ func (npi *Informer) Name() string {
 // GetMetric contacts the IPFSConnector component and
 // requests the `pin ls` command. We return the number
 // of pins in IPFS.
+//
+// The number of pins is cached for MetricTTL seconds. Close to
+// expiry, the cache is refreshed in the background while the cached
+// value keeps being returned. Metrics are pushed every half TTL, so
+// the cache is not refreshed earlier than that: otherwise every push
+// would list the pins.
 func (npi *Informer) GetMetric() api.Metric {
-	if npi.rpcClient == nil {
+	npi.mux.Lock()
+
+	rpcClient := npi.rpcClient
+	if rpcClient == nil {
+		npi.mux.Unlock()
 		return api.Metric{
 			Valid: false,
 		}
 	}
 
-	pinMap := make(map[string]api.IPFSPinStatus)
+	ttl := time.Duration(MetricTTL) * time.Second
 
-	// make use of the RPC API to obtain information
-	// about the number of pins in IPFS. See RPCAPI docs.
-	err := npi.rpcClient.Call("", // Local call
-		"Cluster",   // Service name
-		"IPFSPinLs", // Method name
-		"recursive", // in arg
-		&pinMap)     // out arg
+	age := time.Since(npi.updated)
+	if npi.updated.IsZero() || age >= ttl {
+		npi.stats.Misses++
+		npi.mux.Unlock()
+		return npi.fetch(rpcClient)
+	}
+	defer npi.mux.Unlock()
+
+	npi.stats.Hits++
+	logger.Debugf("numpin cache hit: %d pins (age: %s)", npi.count, age)
+	if age >= ttl*4/5 && !npi.refreshing {
+		npi.refreshing = true
+		go npi.refresh(rpcClient)
+	}
+	return npi.metric()
+}
+
+// Stats returns information about the use of the metric cache.
+func (npi *Informer) Stats() CacheStats {
+	npi.mux.Lock()
+	defer npi.mux.Unlock()
+	stats := npi.stats
+	if !npi.updated.IsZero() {
+		stats.Age = time.Since(npi.updated)
+	}
+	return stats
+}
+
+// fetch lists the pins without holding the lock, so that Stats and
+// other callers are not blocked by IPFS, and caches the result.
+func (npi *Informer) fetch(rpcClient *rpc.Client) api.Metric {
+	logger.Debug("numpin cache miss: listing pins")
+	count, err := pinCount(rpcClient)
 
-	valid := err == nil
+	npi.mux.Lock()
+	defer npi.mux.Unlock()
+	// Shutdown may have happened meanwhile
+	if err != nil || npi.rpcClient != rpcClient {
+		return api.Metric{
+			Name:  MetricName,
+			Valid: false,
+		}
+	}
+	npi.count = count
+	npi.updated = time.Now()
+	return npi.metric()
+}
+
+// refresh updates the cached count in the background.
+func (npi *Informer) refresh(rpcClient *rpc.Client) {
+	logger.Debug("refreshing numpin cache")
+	count, err := pinCount(rpcClient)
+
+	npi.mux.Lock()
+	defer npi.mux.Unlock()
+	npi.refreshing = false
+	if err != nil {
+		logger.Debugf("error refreshing numpin cache: %s", err)
+		return
+	}
+	npi.stats.Refreshes++
+	npi.count = count
+	npi.updated = time.Now()
+}
 
+func (npi *Informer) metric() api.Metric {
 	m := api.Metric{
 		Name:  MetricName,
-		Value: fmt.Sprintf("%d", len(pinMap)),
-		Valid: valid,
+		Value: fmt.Sprintf("%d", npi.count),
+		Valid: true,
 	}
-
 	m.SetTTL(MetricTTL)
 	return m
 }
+
+// pinCount returns the number of recursive and direct pins in IPFS.
+func pinCount(rpcClient *rpc.Client) (int, error) {
+	pinMap := make(map[string]api.IPFSPinStatus)
+
+	// make use of the RPC API to obtain information
+	// about the number of pins in IPFS. See RPCAPI docs.
+	err := rpcClient.Call("", // Local call
+		"Cluster",          // Service name
+		"IPFSPinLs",        // Method name
+		"recursive,direct", // in arg
+		&pinMap)            // out arg
+	return len(pinMap), err
+}
@@ -1,26 +1,32 @@
 package numpin
 
 import (
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/ipfs/ipfs-cluster/api"
 
 	rpc "github.com/hsanjuan/go-libp2p-gorpc"
 )
 
-type mockService struct{}
+type mockService struct {
+	calls int32
+}
 
-func mockRPCClient(t *testing.T) *rpc.Client {
+func mockRPCClient(t *testing.T) (*rpc.Client, *mockService) {
 	s := rpc.NewServer(nil, "mock")
 	c := rpc.NewClientWithServer(nil, "mock", s)
-	err := s.RegisterName("Cluster", &mockService{})
+	mock := &mockService{}
+	err := s.RegisterName("Cluster", mock)
 	if err != nil {
 		t.Fatal(err)
 	}
-	return c
+	return c, mock
 }
 
 func (mock *mockService) IPFSPinLs(in string, out *map[string]api.IPFSPinStatus) error {
+	atomic.AddInt32(&mock.calls, 1)
 	*out = map[string]api.IPFSPinStatus{
 		"QmPGDFvBkgWhvzEK9qaTWrWurSwqXNmhnK3hgELPdZZNPa": api.IPFSPinStatusRecursive,
 		"QmUZ13osndQ5uL4tPWHXe3iBgBgq9gfewcBMSCAuMBsDJ6": api.IPFSPinStatusRecursive,
@@ -34,7 +40,8 @@ func Test(t *testing.T) {
 	if m.Valid {
 		t.Error("metric should be invalid")
 	}
-	inf.SetClient(mockRPCClient(t))
+	c, _ := mockRPCClient(t)
+	inf.SetClient(c)
 	m = inf.GetMetric()
 	if !m.Valid {
 		t.Error("metric should be valid")
@@ -43,3 +50,67 @@ func Test(t *testing.T) {
 		t.Error("bad metric value")
 	}
 }
+
+func TestCache(t *testing.T) {
+	ttl := MetricTTL
+	MetricTTL = 1
+	defer func() { MetricTTL = ttl }()
+
+	inf := NewInformer()
+	c, mock := mockRPCClient(t)
+	inf.SetClient(c)
+
+	inf.GetMetric()
+	inf.GetMetric()
+	if n := atomic.LoadInt32(&mock.calls); n != 1 {
+		t.Fatal("expected a single pin ls but got", n)
+	}
+	stats := inf.Stats()
+	if stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	// close to the TTL, the cache is refreshed in the background
+	time.Sleep(850 * time.Millisecond)
+	m := inf.GetMetric()
+	if !m.Valid || m.Value != "2" {
+		t.Error("expected the cached metric")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&mock.calls); n != 2 {
+		t.Fatal("expected a background refresh but pin ls was called", n)
+	}
+	stats = inf.Stats()
+	if stats.Refreshes != 1 || stats.Age > 500*time.Millisecond {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestCachePushCadence(t *testing.T) {
+	ttl := MetricTTL
+	MetricTTL = 1
+	defer func() { MetricTTL = ttl }()
+
+	inf := NewInformer()
+	c, mock := mockRPCClient(t)
+	inf.SetClient(c)
+
+	// Metrics are pushed every half TTL (see Cluster.pushInformerMetrics)
+	pushes := 6
+	for i := 0; i < pushes; i++ {
+		m := inf.GetMetric()
+		if !m.Valid {
+			t.Fatal("metric should be valid")
+		}
+		time.Sleep(m.GetTTL() / 2)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// one pin ls every two pushes
+	if n := atomic.LoadInt32(&mock.calls); n > int32(pushes/2) {
+		t.Errorf("expected at most %d pin ls for %d pushes but got %d", pushes/2, pushes, n)
+	}
+	if stats := inf.Stats(); stats.Hits < uint64(pushes/2) {
+		t.Errorf("expected every other push to hit the cache: %+v", stats)
+	}
+}
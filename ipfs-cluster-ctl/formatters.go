@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/ipfs/ipfs-cluster/api"
@@ -15,6 +16,17 @@ const (
 	formatString
 	formatVersion
 	formatCidArg
+	formatHealth
+	formatVerify
+	formatConsistency
+	formatPeerReplacement
+	formatReconcilePlan
+	formatRaftServer
+	formatPinResult
+	formatQueueInfo
+	formatAllocationPreview
+	formatGPInfoMap
+	formatSummary
 )
 
 type format int
@@ -50,6 +62,58 @@ func textFormatObject(body []byte, format int) {
 		var obj api.CidArgSerial
 		textFormatDecodeOn(body, &obj)
 		textFormatPrintCidArg(&obj)
+	case formatVerify:
+		var obj api.VerifyResultSerial
+		textFormatDecodeOn(body, &obj)
+		textFormatPrintVerifyResult(&obj)
+	case formatConsistency:
+		var obj api.ConsistencyReportSerial
+		textFormatDecodeOn(body, &obj)
+		textFormatPrintConsistency(&obj)
+	case formatPeerReplacement:
+		var obj api.PeerReplacementSerial
+		textFormatDecodeOn(body, &obj)
+		textFormatPrintPeerReplacement(&obj)
+	case formatReconcilePlan:
+		var obj api.ReconcilePlanSerial
+		textFormatDecodeOn(body, &obj)
+		textFormatPrintReconcilePlan(&obj)
+	case formatRaftServer:
+		var obj api.RaftServerSerial
+		textFormatDecodeOn(body, &obj)
+		textFormatPrintRaftServer(&obj)
+	case formatPinResult:
+		var obj api.PinResultSerial
+		textFormatDecodeOn(body, &obj)
+		textFormatPrintPinResult(&obj)
+	case formatHealth:
+		var obj api.Health
+		textFormatDecodeOn(body, &obj)
+		textFormatPrintHealth(&obj)
+	case formatQueueInfo:
+		var obj api.QueueInfo
+		textFormatDecodeOn(body, &obj)
+		textFormatPrintQueueInfo(&obj)
+	case formatAllocationPreview:
+		var obj api.AllocationPreviewSerial
+		textFormatDecodeOn(body, &obj)
+		textFormatPrintAllocationPreview(&obj)
+	case formatSummary:
+		var obj api.Summary
+		textFormatDecodeOn(body, &obj)
+		textFormatPrintSummary(&obj)
+	case formatGPInfoMap:
+		var obj map[string]api.GlobalPinInfoSerial
+		textFormatDecodeOn(body, &obj)
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			gpi := obj[k]
+			textFormatPrintGPinfo(&gpi)
+		}
 	default:
 		var obj interface{}
 		textFormatDecodeOn(body, &obj)
@@ -75,7 +139,10 @@ func textFormatPrintIDSerial(obj *api.IDSerial) {
 		return
 	}
 
-	fmt.Printf("%s | %d peers\n", obj.ID, len(obj.ClusterPeers))
+	fmt.Printf("%s | %s | %d peers\n", obj.ID, obj.Version, len(obj.ClusterPeers))
+	if obj.ClockSkew != "" {
+		fmt.Printf("  > Clock skew: %s\n", obj.ClockSkew)
+	}
 	fmt.Println("  > Addresses:")
 	for _, a := range obj.Addresses {
 		fmt.Printf("    - %s\n", a)
@@ -91,16 +158,57 @@ func textFormatPrintIDSerial(obj *api.IDSerial) {
 }
 
 func textFormatPrintGPinfo(obj *api.GlobalPinInfoSerial) {
-	fmt.Printf("%s:\n", obj.Cid)
-	for k, v := range obj.PeerMap {
+	if obj.UnderReplicated {
+		fmt.Printf("%s (UNDER-REPLICATED):\n", obj.Cid)
+	} else {
+		fmt.Printf("%s:\n", obj.Cid)
+	}
+	if obj.Name != "" {
+		fmt.Printf("  > Name: %s\n", obj.Name)
+	}
+	if len(obj.Metadata) > 0 {
+		fmt.Printf("  > Metadata: %s\n", formatMetadata(obj.Metadata))
+	}
+	if obj.ReplicationFactorMin > 0 {
+		fmt.Printf("  > Replicas: %d (min: %d, max: %d)\n",
+			obj.Replicas, obj.ReplicationFactorMin, obj.ReplicationFactorMax)
+	}
+	peers := make([]string, 0, len(obj.PeerMap))
+	for k := range obj.PeerMap {
+		peers = append(peers, k)
+	}
+	sort.Strings(peers)
+	for _, k := range peers {
+		v := obj.PeerMap[k]
 		if v.Error != "" {
-			fmt.Printf("  - %s ERROR: %s\n", k, v.Error)
+			fmt.Printf("  - %s ERROR: %s | %s\n", k, v.Error, v.TS)
+			continue
+		}
+		if v.Progress > 0 {
+			fmt.Printf("    > Peer %s: %s (%d blocks fetched) | %s\n",
+				k, strings.ToUpper(v.Status), v.Progress, v.TS)
 			continue
 		}
 		fmt.Printf("    > Peer %s: %s | %s\n", k, strings.ToUpper(v.Status), v.TS)
 	}
 }
 
+func textFormatPrintPinAck(obj *api.PinAckSerial) {
+	if obj.Accepted {
+		fmt.Printf("%s: accepted\n", obj.Peer)
+		return
+	}
+	fmt.Printf("%s: rejected: %s\n", obj.Peer, obj.Error)
+}
+
+func textFormatPrintPinResult(obj *api.PinResultSerial) {
+	if obj.Error != "" {
+		fmt.Printf("%s: ERROR: %s\n", obj.Cid, obj.Error)
+		return
+	}
+	fmt.Printf("%s: pinned\n", obj.Cid)
+}
+
 func textFormatPrintVersion(obj *api.Version) {
 	fmt.Println(obj.Version)
 }
@@ -108,8 +216,157 @@ func textFormatPrintVersion(obj *api.Version) {
 func textFormatPrintCidArg(obj *api.CidArgSerial) {
 	fmt.Printf("%s | Allocations: ", obj.Cid)
 	if obj.Everywhere {
-		fmt.Printf("[everywhere]\n")
+		fmt.Printf("[everywhere]")
 	} else {
 		fmt.Printf("%s", obj.Allocations)
 	}
+	if obj.Protected {
+		fmt.Printf(" | PROTECTED")
+	}
+	if obj.Type == "direct" {
+		fmt.Printf(" | DIRECT")
+	}
+	if obj.Name != "" {
+		fmt.Printf(" | Name: %s", obj.Name)
+	}
+	if len(obj.Metadata) > 0 {
+		fmt.Printf(" | Metadata: %s", formatMetadata(obj.Metadata))
+	}
+	fmt.Println()
+}
+
+// formatMetadata returns the metadata of a pin as key=value pairs,
+// sorted by key.
+func formatMetadata(meta map[string]string) string {
+	pairs := make([]string, 0, len(meta))
+	for k, v := range meta {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ", ")
+}
+
+func textFormatPrintHealth(obj *api.Health) {
+	if obj.Error != "" {
+		fmt.Printf("Disk space: ERROR: %s\n", obj.Error)
+	} else if obj.LowDiskSpace {
+		fmt.Printf("Disk space: LOW: %d MB free (threshold: %d MB)\n",
+			obj.DiskFree/(1024*1024), obj.DiskThreshold/(1024*1024))
+	} else {
+		fmt.Printf("Disk space: OK: %d MB free\n", obj.DiskFree/(1024*1024))
+	}
+	if obj.ReadOnly {
+		fmt.Println("Read-only: new pins are being rejected")
+	}
+	fmt.Printf("Pin queue: %d/%d\n", obj.PinQueueLength, obj.PinQueueCapacity)
+}
+
+func textFormatPrintQueueInfo(obj *api.QueueInfo) {
+	fmt.Printf("Pin queue: %d/%d\n", obj.PinQueueLength, obj.PinQueueCapacity)
+	fmt.Printf("Unpin queue: %d/%d\n", obj.UnpinQueueLength, obj.UnpinQueueCapacity)
+}
+
+func textFormatPrintSummary(obj *api.Summary) {
+	leader := obj.Leader
+	if leader == "" {
+		leader = "none"
+	}
+	fmt.Printf("Version: %s\n", obj.Version)
+	fmt.Printf("Peers: %d | Leader: %s\n", obj.Peers, leader)
+	fmt.Printf("Pins: %d\n", obj.Pins)
+	statuses := make([]string, 0, len(obj.Status))
+	for st := range obj.Status {
+		statuses = append(statuses, st)
+	}
+	sort.Strings(statuses)
+	for _, st := range statuses {
+		if n := obj.Status[st]; n > 0 {
+			fmt.Printf("  > %s: %d\n", strings.ToUpper(st), n)
+		}
+	}
+}
+
+func textFormatPrintAllocationPreview(obj *api.AllocationPreviewSerial) {
+	fmt.Printf("%s | Replication: %d | Metric: %s\n",
+		obj.Cid, obj.ReplicationFactorMax, obj.MetricName)
+	fmt.Println("  > Current:")
+	for _, m := range obj.Current {
+		fmt.Printf("    - %s: %s\n", m.Peer, m.Value)
+	}
+	fmt.Println("  > Candidates:")
+	for _, m := range obj.Candidates {
+		fmt.Printf("    - %s: %s\n", m.Peer, m.Value)
+	}
+	if obj.Error != "" {
+		fmt.Printf("  > Error: %s\n", obj.Error)
+		return
+	}
+	fmt.Println("  > Allocations:")
+	for _, p := range obj.Allocations {
+		fmt.Printf("    - %s\n", p)
+	}
+}
+
+func textFormatPrintPeerReplacement(obj *api.PeerReplacementSerial) {
+	if obj.Phase == "" {
+		fmt.Println("No peer replacement has been started")
+		return
+	}
+	fmt.Printf("%s -> %s: %s | %d/%d pinned\n",
+		obj.OldPeer, obj.NewPeer, strings.ToUpper(obj.Phase), obj.Pinned, obj.Total)
+	if obj.Error != "" {
+		fmt.Printf("  > Error: %s\n", obj.Error)
+	}
+}
+
+func textFormatPrintReconcilePlan(obj *api.ReconcilePlanSerial) {
+	for _, e := range obj.Errors {
+		fmt.Printf("ERROR: %s\n", e)
+	}
+	if len(obj.Actions) == 0 {
+		fmt.Println("Nothing to reconcile")
+		return
+	}
+	for _, a := range obj.Actions {
+		if a.Peer == "" {
+			fmt.Printf("%-10s %s: %s\n", strings.ToUpper(a.Action), a.Cid, a.Reason)
+			continue
+		}
+		fmt.Printf("%-10s %s on %s: %s\n", strings.ToUpper(a.Action), a.Cid, a.Peer, a.Reason)
+	}
+}
+
+func textFormatPrintRaftServer(obj *api.RaftServerSerial) {
+	if obj.Leader {
+		fmt.Printf("%s | %s | leader\n", obj.ID, obj.Suffrage)
+		return
+	}
+	fmt.Printf("%s | %s\n", obj.ID, obj.Suffrage)
+}
+
+func textFormatPrintVerifyResult(obj *api.VerifyResultSerial) {
+	if obj.Valid {
+		fmt.Printf("%s: VALID\n", obj.Peer)
+		return
+	}
+	fmt.Printf("%s: INVALID: %s\n", obj.Peer, obj.Error)
+}
+
+func textFormatPrintConsistency(obj *api.ConsistencyReportSerial) {
+	for _, v := range obj.Views {
+		if v.Error != "" {
+			fmt.Printf("%s | ERROR: %s\n", v.Peer, v.Error)
+			continue
+		}
+		fmt.Printf("%s | %d peers | leader: %s | %d pins | state: %s\n",
+			v.Peer, len(v.ClusterPeers), v.Leader, v.PinCount, v.StateChecksum)
+	}
+	if obj.Consistent {
+		fmt.Println("All peers agree")
+		return
+	}
+	fmt.Println("INCONSISTENT:")
+	for _, d := range obj.Disagreements {
+		fmt.Printf("  - %s\n", d)
+	}
 }
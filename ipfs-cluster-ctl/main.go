@@ -1,17 +1,23 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/ipfs/ipfs-cluster/api"
+
 	cid "github.com/ipfs/go-cid"
 	logging "github.com/ipfs/go-log"
 	peer "github.com/libp2p/go-libp2p-peer"
@@ -29,6 +35,10 @@ var (
 	defaultHost     = fmt.Sprintf("127.0.0.1:%d", 9094)
 	defaultTimeout  = 60
 	defaultProtocol = "http"
+	namespace       = ""
+	socket          = ""
+	authToken       = ""
+	basicAuth       = ""
 )
 
 var logger = logging.Logger("cluster-ctl")
@@ -43,7 +53,8 @@ specific one.
 %s uses the IPFS Cluster API to perform requests and display
 responses in a user-readable format. The location of the IPFS
 Cluster server is assumed to be %s, but can be
-configured with the --host option.
+configured with the --host option. Use --socket instead when the API
+listens on a Unix domain socket.
 
 For feedback, bug reports or any additional information, visit
 https://github.com/ipfs/ipfs-cluster.
@@ -57,12 +68,27 @@ https://github.com/ipfs/ipfs-cluster.
 type errorResp struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
+	Field   string `json:"field,omitempty"`
+}
+
+type pinPathBody struct {
+	Path string `json:"path"`
+}
+
+type pinResp struct {
+	Cid  string             `json:"cid"`
+	Acks []api.PinAckSerial `json:"acks"`
 }
 
 type peerAddBody struct {
 	Addr string `json:"peer_multiaddress"`
 }
 
+type peerReplaceBody struct {
+	OldAddr string `json:"old_peer_multiaddress"`
+	NewAddr string `json:"new_peer_multiaddress"`
+}
+
 func out(m string, a ...interface{}) {
 	fmt.Fprintf(os.Stderr, m, a...)
 }
@@ -86,6 +112,10 @@ func main() {
 			Value: defaultHost,
 			Usage: "host:port of the IPFS Cluster service API",
 		},
+		cli.StringFlag{
+			Name:  "socket",
+			Usage: "path to the Unix domain socket of the IPFS Cluster service API",
+		},
 		cli.BoolFlag{
 			Name:  "https, s",
 			Usage: "use https to connect to the API",
@@ -100,6 +130,20 @@ func main() {
 			Value: defaultTimeout,
 			Usage: "number of seconds to wait before timing out a request",
 		},
+		cli.StringFlag{
+			Name:  "namespace, n",
+			Usage: "scope pin operations, listings and status to a namespace",
+		},
+		cli.StringFlag{
+			Name:   "token",
+			Usage:  "bearer token for APIs requiring authentication",
+			EnvVar: "CLUSTER_API_TOKEN",
+		},
+		cli.StringFlag{
+			Name:   "basic-auth",
+			Usage:  "user:password for APIs requiring basic authentication",
+			EnvVar: "CLUSTER_API_BASIC_AUTH",
+		},
 		cli.BoolFlag{
 			Name:  "debug, d",
 			Usage: "set debug log level",
@@ -109,6 +153,17 @@ func main() {
 	app.Before = func(c *cli.Context) error {
 		defaultHost = c.String("host")
 		defaultTimeout = c.Int("timeout")
+		namespace = c.String("namespace")
+		socket = c.String("socket")
+		authToken = c.String("token")
+		basicAuth = c.String("basic-auth")
+		if basicAuth != "" && !strings.Contains(basicAuth, ":") {
+			return cli.NewExitError("Error: --basic-auth must be given as user:password", 1)
+		}
+		if socket != "" {
+			// The host is only used in the request URL
+			defaultHost = "unix"
+		}
 		if c.Bool("https") {
 			defaultProtocol = "https"
 		}
@@ -144,10 +199,18 @@ This command can be used to list and manage IPFS Cluster peers.
 					Usage: "list the nodes participating in the IPFS Cluster",
 					UsageText: `
 This commands provides a list of the ID information of all the peers in the Cluster.
+When a peer ID is given, only the information for that peer is shown.
 `,
-					Flags: []cli.Flag{parseFlag(formatID)},
+					ArgsUsage: "[peer ID]",
+					Flags:     []cli.Flag{parseFlag(formatID)},
 					Action: func(c *cli.Context) error {
-						resp := request("GET", "/peers", nil)
+						path := "/peers"
+						if pid := c.Args().First(); pid != "" {
+							_, err := peer.IDB58Decode(pid)
+							checkErr("parsing peer ID", err)
+							path += "/" + pid
+						}
+						resp := request("GET", path, nil)
 						formatResponse(c, resp)
 						return nil
 					},
@@ -159,9 +222,18 @@ This commands provides a list of the ID information of all the peers in the Clus
 This command adds a new peer to the cluster. In order for the operation to
 succeed, the new peer needs to be reachable and any other member of the cluster
 should be online. The operation returns the ID information for the new peer.
+
+The new peer is dialed on the given address first. With --force, it is added
+even if it cannot be reached. It will then need to join the cluster on its own.
 `,
 					ArgsUsage: "<multiaddress>",
-					Flags:     []cli.Flag{parseFlag(formatID)},
+					Flags: []cli.Flag{
+						parseFlag(formatID),
+						cli.BoolFlag{
+							Name:  "force",
+							Usage: "add the peer even if it cannot be reached",
+						},
+					},
 					Action: func(c *cli.Context) error {
 						addr := c.Args().First()
 						if addr == "" {
@@ -173,7 +245,11 @@ should be online. The operation returns the ID information for the new peer.
 						var buf bytes.Buffer
 						enc := json.NewEncoder(&buf)
 						enc.Encode(addBody)
-						resp := request("POST", "/peers", &buf)
+						path := "/peers"
+						if c.Bool("force") {
+							path += "?force=true"
+						}
+						resp := request("POST", path, &buf)
 						formatResponse(c, resp)
 						return nil
 					},
@@ -186,6 +262,10 @@ This command removes a peer from the cluster. If the peer is online, it will
 automatically shut down. All other cluster peers should be online for the
 operation to succeed, otherwise some nodes may be left with an outdated list of
 cluster peers.
+
+The CIDs allocated to the peer are re-allocated to other peers before it is
+removed. CIDs for which not enough peers are available are marked as
+under-replicated.
 `,
 					ArgsUsage: "<peer ID>",
 					Flags:     []cli.Flag{parseFlag(formatNone)},
@@ -198,6 +278,43 @@ cluster peers.
 						return nil
 					},
 				},
+				{
+					Name:  "replace",
+					Usage: "replace a peer with a new one which takes over its pins",
+					UsageText: `
+This command replaces a cluster peer with a new one, for example, when the
+identity of the old peer has been compromised. The new peer is added to the
+cluster and allocated all the content of the old peer. Once it has pinned
+everything, the old peer is deallocated and removed from the cluster.
+
+The replacement runs in the background. Without arguments, this command shows
+the progress of the last replacement.
+`,
+					ArgsUsage: "[<old multiaddress> <new multiaddress>]",
+					Flags:     []cli.Flag{parseFlag(formatPeerReplacement)},
+					Action: func(c *cli.Context) error {
+						if c.NArg() == 0 {
+							resp := request("GET", "/peers/replace", nil)
+							formatResponse(c, resp)
+							return nil
+						}
+						if c.NArg() != 2 {
+							return cli.NewExitError("Error: the old and new peer multiaddresses are needed", 1)
+						}
+						oldAddr := c.Args().Get(0)
+						newAddr := c.Args().Get(1)
+						_, err := ma.NewMultiaddr(oldAddr)
+						checkErr("parsing old multiaddress", err)
+						_, err = ma.NewMultiaddr(newAddr)
+						checkErr("parsing new multiaddress", err)
+						var buf bytes.Buffer
+						enc := json.NewEncoder(&buf)
+						enc.Encode(peerReplaceBody{oldAddr, newAddr})
+						resp := request("POST", "/peers/replace", &buf)
+						formatResponse(c, resp)
+						return nil
+					},
+				},
 			},
 		},
 		{
@@ -218,21 +335,243 @@ become part of the Cluster's state and will tracked from this point.
 
 When the request has succeeded, the command returns the status of the CID
 in the cluster and should be part of the list offered by "pin ls".
+
+With --no-fetch, the IPFS daemons are not asked to fetch the content.
+All its blocks must already be in their repositories, i.e. after copying
+a datastore, otherwise the pin will be marked as errored.
+
+With --acks, the command reports which of the allocated peers accepted
+to pin the CID and which rejected it (i.e. because their pin queue is
+full), before pinning completes.
+
+--rmin and --rmax set the minimum and maximum number of peers which
+should pin the CID. The cluster allocates as many peers as possible
+within that range. --replication sets both to the same value, and
+-1 pins the CID everywhere. When not given, the configured replication
+factor is used, also for the bound left out when only one is given.
+
+With --protect, the CID cannot be unpinned until it is unprotected with
+"pin unprotect".
+
+--pin-timeout sets how long pinning may take before the CID is set to
+pin_error, instead of the default for the cluster. It is useful for large
+content which takes long to fetch.
+
+Instead of a CID, an IPFS path like /ipns/example.com or /ipfs/<cid>/dir
+can be given. It is resolved by the IPFS daemon and the resulting CID is
+pinned. The pin does not follow later changes of IPNS names or DNSLinks.
+
+--name and --metadata attach a name and key=value tags to the pin. They
+are shown by "pin ls" and "status", which can be filtered by name with
+--name.
+
+--type sets how the IPFS daemons pin the CID: "recursive" (the default)
+pins it along with all its children, while "direct" pins only its block.
 `,
-					ArgsUsage: "<cid>",
-					Flags:     []cli.Flag{parseFlag(formatGPInfo)},
+					ArgsUsage: "<cid|path>",
+					Flags: []cli.Flag{
+						parseFlag(formatGPInfo),
+						cli.BoolFlag{
+							Name:  "no-fetch",
+							Usage: "do not fetch content which is expected to be present already",
+						},
+						cli.BoolFlag{
+							Name:  "acks",
+							Usage: "report which peers accepted the pin",
+						},
+						cli.IntFlag{
+							Name:  "replication, r",
+							Usage: "replication factor for this pin",
+						},
+						cli.IntFlag{
+							Name:  "rmin",
+							Usage: "minimum replication factor for this pin",
+						},
+						cli.IntFlag{
+							Name:  "rmax",
+							Usage: "maximum replication factor for this pin",
+						},
+						cli.BoolFlag{
+							Name:  "protect",
+							Usage: "protect the pin from being unpinned",
+						},
+						cli.StringFlag{
+							Name:  "pin-timeout",
+							Usage: "how long pinning may take before it is considered failed, i.e. 2h",
+						},
+						cli.StringFlag{
+							Name:  "name, N",
+							Usage: "a name for the pin",
+						},
+						cli.StringSliceFlag{
+							Name:  "metadata",
+							Usage: "a key=value tag for the pin. Can be given several times",
+						},
+						cli.StringFlag{
+							Name:  "type",
+							Usage: "how IPFS pins the CID: recursive or direct",
+						},
+					},
 					Action: func(c *cli.Context) error {
 						cidStr := c.Args().First()
-						_, err := cid.Decode(cidStr)
-						checkErr("parsing cid", err)
-						resp := request("POST", "/pins/"+cidStr, nil)
-						formatResponse(c, resp)
+						isPath := strings.HasPrefix(cidStr, "/ipfs/") ||
+							strings.HasPrefix(cidStr, "/ipns/")
+						if !isPath {
+							_, err := cid.Decode(cidStr)
+							checkErr("parsing cid", err)
+						}
+						query := url.Values{}
+						if c.Bool("no-fetch") {
+							query.Set("no_fetch", "true")
+						}
+						if c.Bool("acks") {
+							query.Set("acks", "true")
+						}
+						if rf := c.Int("replication"); rf != 0 {
+							query.Set("replication", strconv.Itoa(rf))
+						}
+						if rmin := c.Int("rmin"); rmin != 0 {
+							query.Set("replication_min", strconv.Itoa(rmin))
+						}
+						if rmax := c.Int("rmax"); rmax != 0 {
+							query.Set("replication_max", strconv.Itoa(rmax))
+						}
+						if c.Bool("protect") {
+							query.Set("protected", "true")
+						}
+						if t := c.String("pin-timeout"); t != "" {
+							query.Set("pin_timeout", t)
+						}
+						if name := c.String("name"); name != "" {
+							query.Set("name", name)
+						}
+						for _, kv := range c.StringSlice("metadata") {
+							parts := strings.SplitN(kv, "=", 2)
+							if len(parts) != 2 || parts[0] == "" {
+								return cli.NewExitError("Error: metadata must be given as key=value", 1)
+							}
+							query.Set("meta-"+parts[0], parts[1])
+						}
+						if t := c.String("type"); t != "" {
+							query.Set("type", t)
+						}
+						path := "/pins/" + cidStr
+						var body io.Reader
+						if isPath {
+							path = "/pins"
+							var buf bytes.Buffer
+							json.NewEncoder(&buf).Encode(pinPathBody{cidStr})
+							body = &buf
+						}
+						if len(query) > 0 {
+							path += "?" + query.Encode()
+						}
+						resp := request("POST", path, body)
+						if resp.StatusCode != http.StatusAccepted {
+							formatResponse(c, resp)
+							return nil
+						}
+						pr := decodePinResp(resp)
+						if isPath {
+							out("%s resolved to %s\n", cidStr, pr.Cid)
+							cidStr = pr.Cid
+						}
+						if c.Bool("acks") {
+							for _, ack := range pr.Acks {
+								textFormatPrintPinAck(&ack)
+							}
+						} else {
+							out("%s", "Request accepted")
+						}
 						time.Sleep(500 * time.Millisecond)
 						resp = request("GET", "/pins/"+cidStr, nil)
 						formatResponse(c, resp)
 						return nil
 					},
 				},
+				{
+					Name:  "batch",
+					Usage: "Track several CIDs at once",
+					UsageText: `
+This command pins several CIDs with a single request, which is much faster
+than using "pin add" for each of them. The CIDs are given as arguments or,
+when there are none, read from the standard input, one per line.
+
+The command reports, for each CID, whether it was pinned or the error which
+prevented it. Errors with some CIDs do not prevent pinning the rest. The
+--rmin, --rmax, --replication, --protect and --pin-timeout flags work like
+in "pin add" and apply to all the CIDs.
+`,
+					ArgsUsage: "[<cid>...]",
+					Flags: []cli.Flag{
+						parseFlag(formatPinResult),
+						cli.BoolFlag{
+							Name:  "no-fetch",
+							Usage: "do not fetch content which is expected to be present already",
+						},
+						cli.IntFlag{
+							Name:  "replication, r",
+							Usage: "replication factor for the pins",
+						},
+						cli.IntFlag{
+							Name:  "rmin",
+							Usage: "minimum replication factor for the pins",
+						},
+						cli.IntFlag{
+							Name:  "rmax",
+							Usage: "maximum replication factor for the pins",
+						},
+						cli.BoolFlag{
+							Name:  "protect",
+							Usage: "protect the pins from being unpinned",
+						},
+						cli.StringFlag{
+							Name:  "pin-timeout",
+							Usage: "how long pinning may take before it is considered failed, i.e. 2h",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						cids := []string(c.Args())
+						if len(cids) == 0 {
+							scanner := bufio.NewScanner(os.Stdin)
+							for scanner.Scan() {
+								if l := strings.TrimSpace(scanner.Text()); l != "" {
+									cids = append(cids, l)
+								}
+							}
+							checkErr("reading CIDs", scanner.Err())
+						}
+						body, err := json.Marshal(cids)
+						checkErr("encoding CIDs", err)
+
+						query := url.Values{}
+						if c.Bool("no-fetch") {
+							query.Set("no_fetch", "true")
+						}
+						if rf := c.Int("replication"); rf != 0 {
+							query.Set("replication", strconv.Itoa(rf))
+						}
+						if rmin := c.Int("rmin"); rmin != 0 {
+							query.Set("replication_min", strconv.Itoa(rmin))
+						}
+						if rmax := c.Int("rmax"); rmax != 0 {
+							query.Set("replication_max", strconv.Itoa(rmax))
+						}
+						if c.Bool("protect") {
+							query.Set("protected", "true")
+						}
+						if t := c.String("pin-timeout"); t != "" {
+							query.Set("pin_timeout", t)
+						}
+						path := "/pins/batch"
+						if len(query) > 0 {
+							path += "?" + query.Encode()
+						}
+						resp := request("POST", path, bytes.NewReader(body))
+						formatResponse(c, resp)
+						return nil
+					},
+				},
 				{
 					Name:  "rm",
 					Usage: "Stop tracking a CID (unpin)",
@@ -243,16 +582,88 @@ trigger unpinning operations in all the IPFS nodes holding the content.
 When the request has succeeded, the command returns the status of the CID
 in the cluster. The CID should disappear from the list offered by "pin ls",
 although unpinning operations in the cluster may take longer or fail.
+
+With --force, the CID is removed regardless of its namespace, and the
+cluster keeps retrying to unpin it from peers which are down or failing
+until all of them have removed it.
+
+With --if-healthy, the CID is only removed if it is pinned on all the peers
+allocated to it. Otherwise, i.e. while it is being recovered, the command
+fails and lists the peers where it is not pinned.
+
+Protected CIDs cannot be removed, not even with --force. Run
+"pin unprotect" first.
 `,
 					ArgsUsage: "<cid>",
-					Flags:     []cli.Flag{parseFlag(formatGPInfo)},
+					Flags: []cli.Flag{
+						parseFlag(formatGPInfo),
+						cli.BoolFlag{
+							Name:  "force",
+							Usage: "remove from all peers, retrying on failures",
+						},
+						cli.BoolFlag{
+							Name:  "if-healthy",
+							Usage: "only remove if pinned on all its allocations",
+						},
+					},
 					Action: func(c *cli.Context) error {
 						cidStr := c.Args().First()
 						_, err := cid.Decode(cidStr)
 						checkErr("parsing cid", err)
-						request("DELETE", "/pins/"+cidStr, nil)
+						path := "/pins/" + cidStr
+						switch {
+						case c.Bool("force"):
+							path += "?force=true"
+						case c.Bool("if-healthy"):
+							path += "?if_healthy=true"
+						}
+						resp := request("DELETE", path, nil)
+						if resp.StatusCode == http.StatusForbidden ||
+							resp.StatusCode == http.StatusConflict {
+							formatResponse(c, resp)
+							return nil
+						}
+						resp.Body.Close()
 						time.Sleep(500 * time.Millisecond)
-						resp := request("GET", "/pins/"+cidStr, nil)
+						resp = request("GET", "/pins/"+cidStr, nil)
+						formatResponse(c, resp)
+						return nil
+					},
+				},
+				{
+					Name:  "protect",
+					Usage: "Protect a CID from being unpinned",
+					UsageText: `
+This command protects a pinned CID. Protected CIDs cannot be unpinned, not
+even with "pin rm --force", and are never evicted from the cluster cache.
+The protection is part of the shared state, so it applies to all peers and
+survives restarts. Use "pin unprotect" to remove it.
+`,
+					ArgsUsage: "<cid>",
+					Flags:     []cli.Flag{parseFlag(formatNone)},
+					Action: func(c *cli.Context) error {
+						cidStr := c.Args().First()
+						_, err := cid.Decode(cidStr)
+						checkErr("parsing cid", err)
+						resp := request("POST", "/pins/"+cidStr+"/protect", nil)
+						formatResponse(c, resp)
+						return nil
+					},
+				},
+				{
+					Name:  "unprotect",
+					Usage: "Allow a protected CID to be unpinned",
+					UsageText: `
+This command removes the protection from a CID, so that it can be unpinned
+with "pin rm".
+`,
+					ArgsUsage: "<cid>",
+					Flags:     []cli.Flag{parseFlag(formatNone)},
+					Action: func(c *cli.Context) error {
+						cidStr := c.Args().First()
+						_, err := cid.Decode(cidStr)
+						checkErr("parsing cid", err)
+						resp := request("DELETE", "/pins/"+cidStr+"/protect", nil)
 						formatResponse(c, resp)
 						return nil
 					},
@@ -266,10 +677,117 @@ which peers they are currently allocated. This list does not include
 any monitoring information about the 
 merely represents the list of pins which are part of the global state of
 the cluster. For specific information, use "status".
+
+With --peer, only the CIDs allocated to the given peer (including those
+pinned everywhere) are listed. With --name, only the CIDs pinned with
+that name are listed. With --local, only the CIDs which the peer serving
+the API is expected to pin are listed.
+`,
+					Flags: []cli.Flag{
+						parseFlag(formatCidArg),
+						cli.StringFlag{
+							Name:  "peer",
+							Usage: "only list CIDs allocated to this peer ID",
+						},
+						cli.StringFlag{
+							Name:  "name, N",
+							Usage: "only list CIDs pinned with this name",
+						},
+						cli.BoolFlag{
+							Name:  "local",
+							Usage: "only list CIDs allocated to the peer serving the API",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						if c.Bool("local") {
+							resp := request("GET", "/pins/local", nil)
+							formatResponse(c, resp)
+							return nil
+						}
+						query := url.Values{}
+						if p := c.String("peer"); p != "" {
+							query.Set("peer", p)
+						}
+						if name := c.String("name"); name != "" {
+							query.Set("name", name)
+						}
+						path := "/pinlist"
+						if len(query) > 0 {
+							path += "?" + query.Encode()
+						}
+						resp := request("GET", path, nil)
+						formatResponse(c, resp)
+						return nil
+					},
+				},
+				{
+					Name:  "allocations",
+					Usage: "Show which peers a CID is allocated to",
+					UsageText: `
+This command shows the peers which are expected to pin a CID according
+to the shared state, or whether it is pinned everywhere. Use "status" to
+see whether they have actually pinned it.
 `,
-					Flags: []cli.Flag{parseFlag(formatCidArg)},
+					ArgsUsage: "<cid>",
+					Flags:     []cli.Flag{parseFlag(formatCidArg)},
 					Action: func(c *cli.Context) error {
-						resp := request("GET", "/pinlist", nil)
+						cidStr := c.Args().First()
+						_, err := cid.Decode(cidStr)
+						checkErr("parsing cid", err)
+						resp := request("GET", "/pins/"+cidStr+"/allocations", nil)
+						formatResponse(c, resp)
+						return nil
+					},
+				},
+				{
+					Name:  "preview",
+					Usage: "Show where a CID would be allocated, without pinning it",
+					UsageText: `
+This command runs the allocator with the metrics currently known to the
+cluster and shows which peers a CID would be allocated to, along with the
+metric values of the peers already holding it and of the candidates, in
+the order preferred by the allocator. Nothing is pinned.
+
+--replication sets the replication factor to use. When not given, the
+configured replication factor is used.
+`,
+					ArgsUsage: "<cid>",
+					Flags: []cli.Flag{
+						parseFlag(formatAllocationPreview),
+						cli.IntFlag{
+							Name:  "replication, r",
+							Usage: "replication factor to use",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						cidStr := c.Args().First()
+						_, err := cid.Decode(cidStr)
+						checkErr("parsing cid", err)
+						query := ""
+						if rpl := c.Int("replication"); rpl != 0 {
+							query = fmt.Sprintf("?replication=%d", rpl)
+						}
+						resp := request("GET", "/allocations/preview/"+cidStr+query, nil)
+						formatResponse(c, resp)
+						return nil
+					},
+				},
+				{
+					Name:  "serving-peer",
+					Usage: "Show which peer should serve a CID",
+					UsageText: `
+This command returns the ID information, including the IPFS addresses, of
+the peer which should serve the content of a CID. It is chosen among the
+peers allocated to the CID following the "read_strategy" of the cluster
+configuration. Unreachable peers are skipped.
+`,
+					ArgsUsage: "<cid>",
+					Flags:     []cli.Flag{parseFlag(formatID)},
+					Action: func(c *cli.Context) error {
+						cidStr := c.Args().First()
+						_, err := cid.Decode(cidStr)
+						checkErr("parsing cid", err)
+						resp := request("GET", "/pins/"+cidStr+"/serving_peer", nil)
 						formatResponse(c, resp)
 						return nil
 					},
@@ -283,20 +801,45 @@ the cluster. For specific information, use "status".
 This command retrieves the status of the CIDs tracked by IPFS
 Cluster, including which member is pinning them and any errors.
 If a CID is provided, the status will be only fetched for a single
-item.
+item. When several CIDs are provided, their status is fetched with a
+single request.
 
 The status of a CID may not be accurate. A manual sync can be triggered
 with "sync".
+
+With --name, only the status of the CIDs pinned with that name is shown.
 `,
-			ArgsUsage: "[cid]",
-			Flags:     []cli.Flag{parseFlag(formatGPInfo)},
+			ArgsUsage: "[cid...]",
+			Flags: []cli.Flag{
+				parseFlag(formatGPInfo),
+				cli.StringFlag{
+					Name:  "name, N",
+					Usage: "only show CIDs pinned with this name",
+				},
+			},
 			Action: func(c *cli.Context) error {
+				if c.NArg() > 1 {
+					cids := []string(c.Args())
+					for _, cidStr := range cids {
+						_, err := cid.Decode(cidStr)
+						checkErr("parsing cid", err)
+					}
+					body, err := json.Marshal(cids)
+					checkErr("encoding CIDs", err)
+					resp := request("POST", "/pins/status", bytes.NewReader(body))
+					formatResponseAs(c, resp, formatGPInfoMap)
+					return nil
+				}
+
 				cidStr := c.Args().First()
+				path := "/pins/" + cidStr
 				if cidStr != "" {
 					_, err := cid.Decode(cidStr)
 					checkErr("parsing cid", err)
+				} else if name := c.String("name"); name != "" {
+					path += "?" + url.Values{"name": {name}}.Encode()
 				}
-				resp := request("GET", "/pins/"+cidStr, nil)
+				resp := request("GET", path, nil)
 				formatResponse(c, resp)
 				return nil
 			},
@@ -341,13 +884,25 @@ error state, usually because the IPFS pin or unpin operation has failed.
 
 The command will wait for any operations to succeed and will return the status
 of the item upon completion.
+
+With --all, every item in error state is recovered, i.e. after an IPFS outage,
+and the status of those items is returned.
 `,
-			ArgsUsage: "<cid>",
-			Flags:     []cli.Flag{parseFlag(formatGPInfo)},
+			ArgsUsage: "[cid]",
+			Flags: []cli.Flag{
+				parseFlag(formatGPInfo),
+				cli.BoolFlag{
+					Name:  "all",
+					Usage: "recover all the items in error state",
+				},
+			},
 			Action: func(c *cli.Context) error {
 				cidStr := c.Args().First()
 				var resp *http.Response
-				if cidStr != "" {
+				if c.Bool("all") {
+					resp = request("POST", "/pins/recover", nil)
+					formatResponse(c, resp)
+				} else if cidStr != "" {
 					_, err := cid.Decode(cidStr)
 					checkErr("parsing cid", err)
 					resp = request("POST", "/pins/"+cidStr+"/recover", nil)
@@ -359,6 +914,231 @@ of the item upon completion.
 				return nil
 			},
 		},
+		{
+			Name:  "verify",
+			Usage: "Verify that the peers hold all the blocks of an item",
+			UsageText: `
+This command asks the Cluster peers allocated to a CID to read all the
+blocks in its DAG from IPFS, and reports for each peer whether it
+succeeded. This detects missing or corrupted blocks which "status" cannot
+detect.
+
+Verification is expensive: peers only run a limited number of them at a
+time and report an error when busy.
+`,
+			ArgsUsage: "<cid>",
+			Flags:     []cli.Flag{parseFlag(formatVerify)},
+			Action: func(c *cli.Context) error {
+				cidStr := c.Args().First()
+				if cidStr == "" {
+					return cli.NewExitError("A CID is required", 1)
+				}
+				_, err := cid.Decode(cidStr)
+				checkErr("parsing cid", err)
+				resp := request("POST", "/pins/"+cidStr+"/verify", nil)
+				formatResponse(c, resp)
+				return nil
+			},
+		},
+		{
+			Name:  "consistency",
+			Usage: "Check that all peers agree on the cluster peers and state",
+			UsageText: `
+This command asks every cluster peer for its view of the consensus: the
+cluster peers, the leader, and the number of pins and checksum of the
+shared state. Peers which disagree with the majority, or which cannot be
+contacted, are reported. Disagreements usually indicate a network
+partition or a corrupted state, although states may briefly differ while
+operations are being applied.
+`,
+			Flags: []cli.Flag{parseFlag(formatConsistency)},
+			Action: func(c *cli.Context) error {
+				resp := request("GET", "/consensus/consistency", nil)
+				formatResponse(c, resp)
+				return nil
+			},
+		},
+		{
+			Name:  "state",
+			Usage: "Back up and restore the shared state",
+			UsageText: `
+This command groups operations to export the shared state of the cluster,
+with all the pins and their options, and to restore it.
+`,
+			Subcommands: []cli.Command{
+				{
+					Name:  "export",
+					Usage: "Write the shared state to stdout",
+					UsageText: `
+This command writes the shared state, as known by the peer, to stdout as
+JSON. It can be restored with "state import".
+`,
+					Action: func(c *cli.Context) error {
+						resp := request("GET", "/state/export", nil)
+						if resp.StatusCode != http.StatusOK {
+							formatResponse(c, resp)
+							return nil
+						}
+						defer resp.Body.Close()
+						_, err := io.Copy(os.Stdout, resp.Body)
+						checkErr("reading the state", err)
+						return nil
+					},
+				},
+				{
+					Name:  "import",
+					Usage: "Replace the shared state with an exported one",
+					UsageText: `
+This command replaces the shared state of the cluster with one written
+by "state export", read from the given file or from stdin. Every pin is
+validated before anything is replaced. Pins which are not in the
+imported state are unpinned.
+`,
+					ArgsUsage: "[file]",
+					Action: func(c *cli.Context) error {
+						var data []byte
+						var err error
+						if file := c.Args().First(); file != "" {
+							data, err = ioutil.ReadFile(file)
+						} else {
+							data, err = ioutil.ReadAll(os.Stdin)
+						}
+						checkErr("reading the state", err)
+						resp := request("POST", "/state/import", bytes.NewReader(data))
+						formatResponse(c, resp)
+						return nil
+					},
+				},
+			},
+		},
+		{
+			Name:  "consensus",
+			Usage: "Inspect and manage the consensus layer",
+			UsageText: `
+This command groups operations on the Raft consensus used to maintain
+the shared state.
+`,
+			Subcommands: []cli.Command{
+				{
+					Name:  "peers",
+					Usage: "List the servers in the Raft configuration",
+					UsageText: `
+This command lists the servers which take part in Raft, along with their
+suffrage and which one is the leader, as seen by the peer. This membership
+is what counts for elections and quorum, and may differ from the list of
+cluster peers, i.e. while peers are being added or removed.
+`,
+					Flags: []cli.Flag{parseFlag(formatRaftServer)},
+					Action: func(c *cli.Context) error {
+						resp := request("GET", "/consensus/peers", nil)
+						formatResponse(c, resp)
+						return nil
+					},
+				},
+				{
+					Name:  "snapshot",
+					Usage: "Make the leader take a snapshot of the shared state",
+					UsageText: `
+This command makes the consensus leader take a snapshot of the shared
+state and compact its log, i.e. before taking backups of the consensus
+data folder. Requests to other peers are redirected to the leader.
+`,
+					Action: func(c *cli.Context) error {
+						resp := request("POST", "/consensus/snapshot", nil)
+						formatResponse(c, resp)
+						return nil
+					},
+				},
+				{
+					Name:  "leave",
+					Usage: "Remove the peer from the Cluster and shut it down",
+					UsageText: `
+This command makes the peer leave the Cluster in an orderly fashion, i.e.
+when decommissioning it. The CIDs allocated to it are re-allocated and its
+removal is committed. Once the rest of the Cluster has applied it and has a
+leader, the peer takes a snapshot of the state and shuts down.
+`,
+					Action: func(c *cli.Context) error {
+						resp := request("POST", "/consensus/leave", nil)
+						formatResponse(c, resp)
+						return nil
+					},
+				},
+			},
+		},
+		{
+			Name:  "reconcile",
+			Usage: "Inspect how the cluster would be reconciled",
+			UsageText: `
+This command groups operations to reconcile the shared state of the cluster
+with the actual status of the peers.
+`,
+			Subcommands: []cli.Command{
+				{
+					Name:  "plan",
+					Usage: "List the actions reconciliation would take, without taking them",
+					UsageText: `
+This command compares the shared state with the status of the pins in every
+peer and its IPFS daemon, and lists what reconciliation would do: pin items
+where they are allocated, remove stray pins, stop tracking items which are no
+longer in the state, and re-allocate items allocated to peers which are gone
+or to too few peers. Nothing is changed.
+`,
+					Flags: []cli.Flag{parseFlag(formatReconcilePlan)},
+					Action: func(c *cli.Context) error {
+						resp := request("GET", "/reconcile/plan", nil)
+						formatResponse(c, resp)
+						return nil
+					},
+				},
+			},
+		},
+		{
+			Name:  "health",
+			Usage: "Retrieve the health of the peer",
+			UsageText: `
+This command reports conditions affecting the peer, like running low on
+disk space in the consensus data folder. When configured to do so, a peer
+low on disk space rejects new pins until space is freed.
+`,
+			Flags: []cli.Flag{parseFlag(formatHealth)},
+			Action: func(c *cli.Context) error {
+				resp := request("GET", "/health", nil)
+				formatResponse(c, resp)
+				return nil
+			},
+		},
+		{
+			Name:  "summary",
+			Usage: "Show an overview of the cluster",
+			UsageText: `
+This command shows the number of pins in the shared state, how many of
+the items tracked by the peer are in each status, the number of peers,
+the leader and the version. It is cheap, so it can be run regularly.
+`,
+			Flags: []cli.Flag{parseFlag(formatSummary)},
+			Action: func(c *cli.Context) error {
+				resp := request("GET", "/summary", nil)
+				formatResponse(c, resp)
+				return nil
+			},
+		},
+		{
+			Name:  "queue",
+			Usage: "Show how many operations wait in the peer's queues",
+			UsageText: `
+This command shows how many pins and unpins are waiting to be processed
+by the peer, and the size of its queues (pin_queue_size in the peer's
+configuration). New pins allocated to a peer whose pin queue is full
+are rejected.
+`,
+			Flags: []cli.Flag{parseFlag(formatQueueInfo)},
+			Action: func(c *cli.Context) error {
+				resp := request("GET", "/queue", nil)
+				formatResponse(c, resp)
+				return nil
+			},
+		},
 		{
 			Name:  "version",
 			Usage: "Retrieve cluster version",
@@ -402,10 +1182,12 @@ func walkCommands(cmds []cli.Command) {
 	}
 }
 
+// request performs a request against the Cluster API. The --timeout
+// applies until the body of the response has been read, so callers
+// must always close it.
 func request(method, path string, body io.Reader, args ...string) *http.Response {
 	ctx, cancel := context.WithTimeout(context.Background(),
 		time.Duration(defaultTimeout)*time.Second)
-	defer cancel()
 
 	u := defaultProtocol + "://" + defaultHost + path
 	// turn /a/{param0}/{param1} into /a/this/that
@@ -418,17 +1200,61 @@ func request(method, path string, body io.Reader, args ...string) *http.Response
 	logger.Debugf("%s: %s", method, u)
 
 	r, err := http.NewRequest(method, u, body)
+	if err != nil {
+		cancel()
+	}
 	checkErr("creating request", err)
-	r.WithContext(ctx)
+	if namespace != "" {
+		r.Header.Set("X-Cluster-Namespace", namespace)
+	}
+	switch {
+	case authToken != "":
+		r.Header.Set("Authorization", "Bearer "+authToken)
+	case basicAuth != "":
+		parts := strings.SplitN(basicAuth, ":", 2)
+		r.SetBasicAuth(parts[0], parts[1])
+	}
+	r = r.WithContext(ctx)
 
 	client := &http.Client{}
+	target := defaultHost
+	if socket != "" {
+		client.Transport = &http.Transport{
+			Dial: func(_, _ string) (net.Conn, error) {
+				return net.Dial("unix", socket)
+			},
+		}
+		target = socket
+	}
 	resp, err := client.Do(r)
-	checkErr(fmt.Sprintf("performing request to %s", defaultHost), err)
+	if err != nil {
+		cancel()
+	}
+	checkErr(fmt.Sprintf("performing request to %s", target), err)
 
+	resp.Body = &cancelOnClose{resp.Body, cancel}
 	return resp
 }
 
+// cancelOnClose releases the context of a request once its response
+// body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
 func formatResponse(c *cli.Context, r *http.Response) {
+	formatResponseAs(c, r, c.Int("parseAs"))
+}
+
+// formatResponseAs works like formatResponse, but prints text output
+// in the given format, for commands whose responses vary.
+func formatResponseAs(c *cli.Context, r *http.Response, format int) {
 	defer r.Body.Close()
 	body, err := ioutil.ReadAll(r.Body)
 	checkErr("reading body", err)
@@ -439,7 +1265,11 @@ func formatResponse(c *cli.Context, r *http.Response) {
 		var e errorResp
 		err = json.Unmarshal(body, &e)
 		checkErr("decoding error response", err)
-		out("Error %d: %s", e.Code, e.Message)
+		if e.Field != "" {
+			out("Error %d (%s): %s", e.Code, e.Field, e.Message)
+		} else {
+			out("Error %d: %s", e.Code, e.Message)
+		}
 	case r.StatusCode == http.StatusAccepted:
 		out("%s", "Request accepted")
 	case r.StatusCode == http.StatusNoContent:
@@ -449,7 +1279,7 @@ func formatResponse(c *cli.Context, r *http.Response) {
 
 		switch enc {
 		case "text":
-			textFormat(body, c.Int("parseAs"))
+			textFormat(body, format)
 		default:
 			var resp interface{}
 			err = json.Unmarshal(body, &resp)
@@ -459,6 +1289,15 @@ func formatResponse(c *cli.Context, r *http.Response) {
 	}
 }
 
+// decodePinResp decodes the response to a pin request.
+func decodePinResp(r *http.Response) pinResp {
+	defer r.Body.Close()
+	var pr pinResp
+	err := json.NewDecoder(r.Body).Decode(&pr)
+	checkErr("decoding response", err)
+	return pr
+}
+
 // JSON output is nice and allows users to build on top.
 func prettyPrint(buf []byte) {
 	var dst bytes.Buffer
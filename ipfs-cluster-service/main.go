@@ -13,7 +13,9 @@ import (
 	"github.com/urfave/cli"
 
 	ipfscluster "github.com/ipfs/ipfs-cluster"
+	"github.com/ipfs/ipfs-cluster/allocator/freespacealloc"
 	"github.com/ipfs/ipfs-cluster/allocator/numpinalloc"
+	"github.com/ipfs/ipfs-cluster/informer/freespace"
 	"github.com/ipfs/ipfs-cluster/informer/numpin"
 	"github.com/ipfs/ipfs-cluster/state/mapstate"
 )
@@ -158,6 +160,11 @@ func main() {
 			Usage:  "remove peer from cluster on exit. Overrides \"leave_on_shutdown\"",
 			Hidden: true,
 		},
+		cli.StringFlag{
+			Name:  "alloc, a",
+			Value: "numpin",
+			Usage: "allocation strategy to use [numpin, freespace]. All peers should use the same one",
+		},
 		cli.BoolFlag{
 			Name:  "debug, d",
 			Usage: "enable full debug logging (very verbose)",
@@ -234,19 +241,34 @@ func run(c *cli.Context) error {
 	api, err := ipfscluster.NewRESTAPI(cfg)
 	checkErr("creating REST API component", err)
 
-	proxy, err := ipfscluster.NewIPFSHTTPConnector(cfg)
-	checkErr("creating IPFS Connector component", err)
+	var connector ipfscluster.IPFSConnector
+	if cfg.IPFSConnector == ipfscluster.IPFSConnectorNull {
+		connector, err = ipfscluster.NewNullConnector(cfg)
+		checkErr("creating null IPFS Connector component", err)
+		logger.Warning("using the null IPFS connector: IPFS is not used and the IPFS Proxy is disabled")
+	} else if cfg.PinningServiceEndpoint != "" {
+		connector, err = ipfscluster.NewPinningServiceConnector(cfg)
+		checkErr("creating Pinning Service Connector component", err)
+		logger.Infof("pinning on %s. The IPFS Proxy is disabled", cfg.PinningServiceEndpoint)
+	} else {
+		proxy, err := ipfscluster.NewIPFSHTTPConnector(cfg)
+		checkErr("creating IPFS Connector component", err)
+		if cfg.IPFSProxyOnAPI {
+			api.MountProxy(proxy.ProxyHandler())
+		}
+		connector = proxy
+	}
 
 	state := mapstate.NewMapState()
 	tracker := ipfscluster.NewMapPinTracker(cfg)
+	api.SetEventSource(tracker)
 	mon := ipfscluster.NewStdPeerMonitor(5)
-	informer := numpin.NewInformer()
-	alloc := numpinalloc.NewAllocator()
+	informer, alloc := setupAllocation(c.String("alloc"))
 
 	cluster, err := ipfscluster.NewCluster(
 		cfg,
 		api,
-		proxy,
+		connector,
 		state,
 		tracker,
 		mon,
@@ -268,6 +290,20 @@ func run(c *cli.Context) error {
 	}
 }
 
+// setupAllocation returns the informer and the allocator for the
+// given allocation strategy.
+func setupAllocation(strategy string) (ipfscluster.Informer, ipfscluster.PinAllocator) {
+	switch strategy {
+	case "numpin":
+		return numpin.NewInformer(), numpinalloc.NewAllocator()
+	case "freespace":
+		return freespace.NewInformer(), freespacealloc.NewAllocator()
+	default:
+		checkErr("setting up allocation", fmt.Errorf("unknown allocation strategy: %s", strategy))
+		return nil, nil
+	}
+}
+
 func setupLogging(lvl string) {
 	ipfscluster.SetFacilityLogLevel("service", lvl)
 	ipfscluster.SetFacilityLogLevel("cluster", lvl)
@@ -7,11 +7,15 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime/multipart"
 	"net"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ipfs/ipfs-cluster/api"
@@ -24,15 +28,42 @@ import (
 
 // IPFS Proxy settings
 var (
-	// maximum duration before timing out read of the request
-	IPFSProxyServerReadTimeout = 5 * time.Second
-	// maximum duration before timing out write of the response
-	IPFSProxyServerWriteTimeout = 10 * time.Second
+	// maximum duration before timing out read of the request. 0, the
+	// default, means no limit, so that large uploads (i.e. add) are
+	// not cut.
+	IPFSProxyServerReadTimeout time.Duration
+	// maximum duration before timing out write of the response. 0,
+	// the default, means no limit, so that long streamed responses
+	// (i.e. cat) are not cut.
+	IPFSProxyServerWriteTimeout time.Duration
 	// server-side the amount of time a Keep-Alive connection will be
 	// kept idle before being reused
 	IPFSProxyServerIdleTimeout = 60 * time.Second
+	// how often the responses of forwarded requests are flushed to
+	// the client, so that large outputs (i.e. cat) are streamed
+	IPFSProxyFlushInterval = 100 * time.Millisecond
 )
 
+// Requests to the IPFS daemon which fail because it refuses connections,
+// i.e. while it restarts, are retried up to IPFSConnectRetries times.
+// The first retry waits IPFSConnectRetryDelay, which doubles with
+// every attempt, so a request gives up after about 3.5s by default.
+var (
+	IPFSConnectRetries    = 3
+	IPFSConnectRetryDelay = 500 * time.Millisecond
+)
+
+// ipfsDownMsg starts the message of the errors returned when the IPFS
+// daemon refuses connections. See isIPFSDown().
+const ipfsDownMsg = "the IPFS daemon is down"
+
+// errPinTooLarge is returned by Pin for items exceeding Config.MaxPinSize.
+var errPinTooLarge = errors.New("item too large to pin")
+
+// IPFSVerifyTimeout is the maximum duration of a Verify operation.
+// Verifying large DAGs may take a while.
+var IPFSVerifyTimeout = 30 * time.Minute
+
 // IPFSHTTPConnector implements the IPFSConnector interface
 // and provides a component which does two tasks:
 //
@@ -52,12 +83,32 @@ type IPFSHTTPConnector struct {
 	listenPort int
 
 	handlers map[string]func(http.ResponseWriter, *http.Request)
+	// forwards the requests without a custom handler to IPFS
+	reverseProxy *httputil.ReverseProxy
+
+	checkInterval time.Duration
+	watch         daemonWatch
+
+	// maximum cumulative size of recursive pins (0 for no limit)
+	maxPinSize uint64
+
+	// clients for requests to the daemon, with the configured
+	// timeouts: client for most requests and pinClient for pins
+	// and unpins
+	client    *http.Client
+	pinClient *http.Client
+
+	// 1 when the IPFS daemon accepted the last connection, 0 when it
+	// refused it. Accessed atomically.
+	online int32
 
 	rpcClient *rpc.Client
 	rpcReady  chan struct{}
+	doneCh    chan struct{}
 
 	listener net.Listener
 	server   *http.Server
+	handler  http.Handler
 
 	shutdownLock sync.Mutex
 	shutdown     bool
@@ -77,7 +128,13 @@ type ipfsPinLsResp struct {
 }
 
 type ipfsPinOpResp struct {
-	Pins []string
+	Pins     []string
+	Progress uint64
+}
+
+type ipfsRefsResp struct {
+	Ref string
+	Err string
 }
 
 type ipfsIDResp struct {
@@ -85,6 +142,31 @@ type ipfsIDResp struct {
 	Addresses []string
 }
 
+type ipfsAddResp struct {
+	Name string
+	Hash string
+}
+
+type ipfsObjectStatResp struct {
+	CumulativeSize uint64
+}
+
+type ipfsResolveResp struct {
+	Path string
+}
+
+type ipfsRepoStatResp struct {
+	RepoSize   uint64
+	StorageMax uint64
+}
+
+type ipfsBandwidthResp struct {
+	TotalIn  uint64
+	TotalOut uint64
+	RateIn   float64
+	RateOut  float64
+}
+
 // NewIPFSHTTPConnector creates the component and leaves it ready to be started
 func NewIPFSHTTPConnector(cfg *Config) (*IPFSHTTPConnector, error) {
 	ctx := context.Background()
@@ -101,23 +183,30 @@ func NewIPFSHTTPConnector(cfg *Config) (*IPFSHTTPConnector, error) {
 		return nil, err
 	}
 
-	listenAddr, err := cfg.IPFSProxyAddr.ValueForProtocol(ma.P_IP4)
-	if err != nil {
-		return nil, err
-	}
-	listenPortStr, err := cfg.IPFSProxyAddr.ValueForProtocol(ma.P_TCP)
-	if err != nil {
-		return nil, err
-	}
-	listenPort, err := strconv.Atoi(listenPortStr)
-	if err != nil {
-		return nil, err
-	}
+	var listenAddr string
+	var listenPort int
+	var l net.Listener
+	// When the proxy is mounted on the API listener we do not
+	// listen ourselves. See ProxyHandler().
+	if !cfg.IPFSProxyOnAPI {
+		listenAddr, err = cfg.IPFSProxyAddr.ValueForProtocol(ma.P_IP4)
+		if err != nil {
+			return nil, err
+		}
+		listenPortStr, err := cfg.IPFSProxyAddr.ValueForProtocol(ma.P_TCP)
+		if err != nil {
+			return nil, err
+		}
+		listenPort, err = strconv.Atoi(listenPortStr)
+		if err != nil {
+			return nil, err
+		}
 
-	l, err := net.Listen("tcp", fmt.Sprintf("%s:%d",
-		listenAddr, listenPort))
-	if err != nil {
-		return nil, err
+		l, err = net.Listen("tcp", fmt.Sprintf("%s:%d",
+			listenAddr, listenPort))
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	smux := http.NewServeMux()
@@ -129,6 +218,11 @@ func NewIPFSHTTPConnector(cfg *Config) (*IPFSHTTPConnector, error) {
 	}
 	s.SetKeepAlivesEnabled(true) // A reminder that this can be changed
 
+	checkSeconds := cfg.IPFSCheckSeconds
+	if checkSeconds <= 0 {
+		checkSeconds = DefaultIPFSCheckSeconds
+	}
+
 	ipfs := &IPFSHTTPConnector{
 		ctx:       ctx,
 		nodeAddr:  cfg.IPFSNodeAddr,
@@ -139,15 +233,37 @@ func NewIPFSHTTPConnector(cfg *Config) (*IPFSHTTPConnector, error) {
 		listenAddr: listenAddr,
 		listenPort: listenPort,
 		handlers:   make(map[string]func(http.ResponseWriter, *http.Request)),
-		rpcReady:   make(chan struct{}, 1),
-		listener:   l,
-		server:     s,
+
+		checkInterval: time.Duration(checkSeconds) * time.Second,
+		maxPinSize:    cfg.MaxPinSize,
+		online:        1,
+
+		client: &http.Client{
+			Timeout: time.Duration(cfg.IPFSRequestTimeoutSeconds) * time.Second,
+		},
+		pinClient: &http.Client{
+			Timeout: time.Duration(cfg.IPFSPinTimeoutSeconds) * time.Second,
+		},
+
+		rpcReady: make(chan struct{}),
+		doneCh:   make(chan struct{}),
+		listener: l,
+		server:   s,
+		handler:  smux,
 	}
 
+	ipfs.reverseProxy = httputil.NewSingleHostReverseProxy(&url.URL{
+		Scheme: "http",
+		Host:   fmt.Sprintf("%s:%d", destHost, destPort),
+	})
+	ipfs.reverseProxy.FlushInterval = IPFSProxyFlushInterval
+
 	smux.HandleFunc("/", ipfs.handle)
 	ipfs.handlers["/api/v0/pin/add"] = ipfs.pinHandler
 	ipfs.handlers["/api/v0/pin/rm"] = ipfs.unpinHandler
 	ipfs.handlers["/api/v0/pin/ls"] = ipfs.pinLsHandler
+	ipfs.handlers["/api/v0/cat"] = ipfs.accessHandler
+	ipfs.handlers["/api/v0/get"] = ipfs.accessHandler
 
 	ipfs.run()
 	return ipfs, nil
@@ -155,6 +271,14 @@ func NewIPFSHTTPConnector(cfg *Config) (*IPFSHTTPConnector, error) {
 
 // set cancellable context. launch proxy
 func (ipfs *IPFSHTTPConnector) run() {
+	ipfs.wg.Add(1)
+	go ipfs.daemonWatcher()
+
+	// The proxy is served by someone else
+	if ipfs.listener == nil {
+		return
+	}
+
 	// This launches the proxy
 	ipfs.wg.Add(1)
 	go func() {
@@ -163,7 +287,11 @@ func (ipfs *IPFSHTTPConnector) run() {
 		defer cancel()
 		ipfs.ctx = ctx
 
-		<-ipfs.rpcReady
+		select {
+		case <-ipfs.rpcReady:
+		case <-ipfs.doneCh:
+			return
+		}
 
 		logger.Infof("IPFS Proxy: %s -> %s",
 			ipfs.proxyAddr,
@@ -175,10 +303,24 @@ func (ipfs *IPFSHTTPConnector) run() {
 	}()
 }
 
+// ProxyHandler returns the http.Handler for the IPFS Proxy. It can be used
+// to mount the proxy on a different HTTP server, usually with
+// RESTAPI.MountProxy(), when the IPFSProxyOnAPI option is set. The
+// handler expects requests to /api/v0/*.
+func (ipfs *IPFSHTTPConnector) ProxyHandler() http.Handler {
+	return ipfs.handler
+}
+
 // This will run a custom handler if we have one for a URL.Path, or
 // otherwise just proxy the requests.
 func (ipfs *IPFSHTTPConnector) handle(w http.ResponseWriter, r *http.Request) {
 	if customHandler, ok := ipfs.handlers[r.URL.Path]; ok {
+		// custom handlers use RPC, which may not be set yet
+		// when the proxy is mounted on a different server.
+		if err := waitForRPC(ipfs.rpcReady, ipfs.doneCh); err != nil {
+			ipfsErrorResponder(w, err.Error())
+			return
+		}
 		customHandler(w, r)
 	} else {
 		ipfs.defaultHandler(w, r)
@@ -186,35 +328,13 @@ func (ipfs *IPFSHTTPConnector) handle(w http.ResponseWriter, r *http.Request) {
 
 }
 
-// defaultHandler just proxies the requests
+// defaultHandler forwards any request to the IPFS daemon as it is,
+// including its headers and body, and sends back the response with its
+// status, headers and trailers. Responses are streamed, and flushed
+// every IPFSProxyFlushInterval. Requests which cannot be forwarded get
+// 502 Bad Gateway.
 func (ipfs *IPFSHTTPConnector) defaultHandler(w http.ResponseWriter, r *http.Request) {
-	newURL := *r.URL
-	newURL.Host = fmt.Sprintf("%s:%d", ipfs.destHost, ipfs.destPort)
-	newURL.Scheme = "http"
-
-	proxyReq, err := http.NewRequest(r.Method, newURL.String(), r.Body)
-	if err != nil {
-		logger.Error("error creating proxy request: ", err)
-		http.Error(w, "error forwarding request", 500)
-		return
-	}
-
-	resp, err := http.DefaultClient.Do(proxyReq)
-	if err != nil {
-		logger.Error("error forwarding request: ", err)
-		http.Error(w, "error forwaring request", 500)
-		return
-	}
-
-	// Set response headers
-	for k, v := range resp.Header {
-		for _, s := range v {
-			w.Header().Add(k, s)
-		}
-	}
-
-	// And body
-	io.Copy(w, resp.Body)
+	ipfs.reverseProxy.ServeHTTP(w, r)
 }
 
 func ipfsErrorResponder(w http.ResponseWriter, errMsg string) {
@@ -225,34 +345,47 @@ func ipfsErrorResponder(w http.ResponseWriter, errMsg string) {
 	return
 }
 
-func (ipfs *IPFSHTTPConnector) pinOpHandler(op string, w http.ResponseWriter, r *http.Request) {
-	argA := r.URL.Query()["arg"]
-	if len(argA) == 0 {
+// pinOpHandler performs the given Cluster operation, i.e. Pin, for
+// every argument of the request, so that the items are tracked by the
+// whole Cluster. Like IPFS, it stops at the first error. The response
+// lists the Cids of the items, as IPFS does.
+func (ipfs *IPFSHTTPConnector) pinOpHandler(op string, reply interface{}, w http.ResponseWriter, r *http.Request) {
+	args := r.URL.Query()["arg"]
+	if len(args) == 0 {
 		ipfsErrorResponder(w, "Error: bad argument")
 		return
 	}
-	arg := argA[0]
-	_, err := cid.Decode(arg)
-	if err != nil {
-		ipfsErrorResponder(w, "Error parsing CID: "+err.Error())
-		return
-	}
 
-	err = ipfs.rpcClient.Call("",
-		"Cluster",
-		op,
-		api.CidArgSerial{
-			Cid: arg,
-		},
-		&struct{}{})
+	pins := make([]string, 0, len(args))
+	for _, arg := range args {
+		h, err := ipfs.proxyArgCid(arg)
+		if err != nil {
+			ipfsErrorResponder(w, err.Error())
+			return
+		}
 
-	if err != nil {
-		ipfsErrorResponder(w, err.Error())
-		return
+		carg := api.CidArgSerial{
+			Cid: h.String(),
+		}
+		// "ipfs pin add -r=false" pins directly
+		if r.URL.Query().Get("recursive") == "false" {
+			carg.Type = api.PinTypeDirect.String()
+		}
+
+		err = ipfs.rpcClient.Call("",
+			"Cluster",
+			op,
+			carg,
+			reply)
+		if err != nil {
+			ipfsErrorResponder(w, err.Error())
+			return
+		}
+		pins = append(pins, carg.Cid)
 	}
 
 	resp := ipfsPinOpResp{
-		Pins: []string{arg},
+		Pins: pins,
 	}
 	respBytes, _ := json.Marshal(resp)
 	w.WriteHeader(http.StatusOK)
@@ -260,12 +393,31 @@ func (ipfs *IPFSHTTPConnector) pinOpHandler(op string, w http.ResponseWriter, r
 	return
 }
 
+// proxyArgCid returns the Cid given in an argument of a proxied pin
+// request. Like IPFS, it accepts IPFS paths, which are resolved using
+// the IPFS daemon unless they are just /ipfs/<cid>.
+func (ipfs *IPFSHTTPConnector) proxyArgCid(arg string) (*cid.Cid, error) {
+	h, err := cid.Decode(strings.TrimPrefix(arg, "/ipfs/"))
+	if err == nil {
+		return h, nil
+	}
+	if !strings.HasPrefix(arg, "/") {
+		return nil, errors.New("Error parsing CID: " + err.Error())
+	}
+	h, err = ipfs.Resolve(arg)
+	if err != nil {
+		return nil, fmt.Errorf("Error resolving %s: %s", arg, err)
+	}
+	return h, nil
+}
+
 func (ipfs *IPFSHTTPConnector) pinHandler(w http.ResponseWriter, r *http.Request) {
-	ipfs.pinOpHandler("Pin", w, r)
+	var index uint64
+	ipfs.pinOpHandler("Pin", &index, w, r)
 }
 
 func (ipfs *IPFSHTTPConnector) unpinHandler(w http.ResponseWriter, r *http.Request) {
-	ipfs.pinOpHandler("Unpin", w, r)
+	ipfs.pinOpHandler("Unpin", &struct{}{}, w, r)
 }
 
 func (ipfs *IPFSHTTPConnector) pinLsHandler(w http.ResponseWriter, r *http.Request) {
@@ -285,8 +437,9 @@ func (ipfs *IPFSHTTPConnector) pinLsHandler(w http.ResponseWriter, r *http.Reque
 	}
 
 	for _, pin := range pins {
+		pinType, _ := api.PinTypeFromString(pin.Type)
 		pinLs.Keys[pin.Cid] = ipfsPinType{
-			Type: "recursive",
+			Type: pinType.String(),
 		}
 	}
 
@@ -315,11 +468,114 @@ func (ipfs *IPFSHTTPConnector) pinLsHandler(w http.ResponseWriter, r *http.Reque
 	w.Write(respBytes)
 }
 
+// accessHandler records that content is being accessed, so it can be
+// taken into account by the eviction policy, and proxies the request.
+func (ipfs *IPFSHTTPConnector) accessHandler(w http.ResponseWriter, r *http.Request) {
+	if arg := r.URL.Query().Get("arg"); arg != "" {
+		// arg can be a path like /ipfs/<cid>/file
+		root := strings.Split(strings.TrimPrefix(arg, "/ipfs/"), "/")[0]
+		if _, err := cid.Decode(root); err == nil {
+			ipfs.rpcClient.Go("",
+				"Cluster",
+				"Touch",
+				api.CidArgSerial{
+					Cid: root,
+				},
+				&struct{}{},
+				nil)
+		}
+	}
+	ipfs.defaultHandler(w, r)
+}
+
+// daemonWatch detects restarts of the IPFS daemon from the results of
+// successive ID requests. The daemon is considered to have restarted
+// when it becomes reachable after having failed, or when its ID changes.
+type daemonWatch struct {
+	lastID peer.ID
+	down   bool
+}
+
+// observe records the result of an ID request and returns
+// true if the daemon has restarted since the last one.
+func (dw *daemonWatch) observe(id peer.ID, err error) bool {
+	if err != nil {
+		if !dw.down {
+			logger.Warning("the IPFS daemon is unreachable: ", err)
+		}
+		dw.down = true
+		return false
+	}
+	restarted := dw.down || (dw.lastID != "" && dw.lastID != id)
+	dw.down = false
+	dw.lastID = id
+	return restarted
+}
+
+// daemonWatcher checks the IPFS daemon regularly and re-syncs
+// the pinset when it detects a restart.
+func (ipfs *IPFSHTTPConnector) daemonWatcher() {
+	defer ipfs.wg.Done()
+	ticker := time.NewTicker(ipfs.checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ipfs.doneCh:
+			return
+		case <-ticker.C:
+		}
+
+		id, err := ipfs.ID()
+		if ipfs.watch.observe(id.ID, err) {
+			logger.Info("IPFS daemon restart detected. Re-syncing pins")
+			ipfs.resync()
+		}
+	}
+}
+
+// resync syncs the local pinset with the IPFS daemon and recovers
+// the items which the daemon lost, which are in error after syncing.
+func (ipfs *IPFSHTTPConnector) resync() {
+	if err := waitForRPC(ipfs.rpcReady, ipfs.doneCh); err != nil {
+		logger.Warning(err)
+		return
+	}
+
+	var pinfos []api.PinInfoSerial
+	err := ipfs.rpcClient.Call("",
+		"Cluster",
+		"SyncAllLocal",
+		"",
+		&pinfos)
+	if err != nil {
+		logger.Error("error syncing after IPFS restart: ", err)
+		return
+	}
+
+	for _, p := range pinfos {
+		if api.TrackerStatusFromString(p.Status) != api.TrackerStatusPinError {
+			continue
+		}
+		logger.Infof("re-pinning %s, lost by the IPFS daemon", p.Cid)
+		err := ipfs.rpcClient.Call("",
+			"Cluster",
+			"TrackerRecover",
+			api.CidArgSerial{
+				Cid: p.Cid,
+			},
+			&api.PinInfoSerial{})
+		if err != nil {
+			logger.Errorf("error recovering %s: %s", p.Cid, err)
+		}
+	}
+}
+
 // SetClient makes the component ready to perform RPC
-// requests.
+// requests. It must be called only once.
 func (ipfs *IPFSHTTPConnector) SetClient(c *rpc.Client) {
 	ipfs.rpcClient = c
-	ipfs.rpcReady <- struct{}{}
+	// The proxy and the operations requested before wait for it
+	close(ipfs.rpcReady)
 }
 
 // Shutdown stops any listeners and stops the component from taking
@@ -335,9 +591,11 @@ func (ipfs *IPFSHTTPConnector) Shutdown() error {
 
 	logger.Info("stopping IPFS Proxy")
 
-	close(ipfs.rpcReady)
+	close(ipfs.doneCh)
 	ipfs.server.SetKeepAlivesEnabled(false)
-	ipfs.listener.Close()
+	if ipfs.listener != nil {
+		ipfs.listener.Close()
+	}
 
 	ipfs.wg.Wait()
 	ipfs.shutdown = true
@@ -386,15 +644,25 @@ func (ipfs *IPFSHTTPConnector) ID() (api.IPFSID, error) {
 }
 
 // Pin performs a pin request against the configured IPFS
-// daemon.
-func (ipfs *IPFSHTTPConnector) Pin(hash *cid.Cid) error {
+// daemon. Direct pins are requested with recursive=false, which is how
+// the IPFS API selects the pin type. The number of blocks fetched,
+// which IPFS reports while pinning, is sent to the PinTracker.
+//
+// When Config.MaxPinSize is set, recursive pins of items whose
+// cumulative size is larger fail before anything else is fetched.
+func (ipfs *IPFSHTTPConnector) Pin(hash *cid.Cid, pinType api.PinType) error {
 	pinStatus, err := ipfs.PinLsCid(hash)
 	if err != nil {
 		return err
 	}
-	if !pinStatus.IsPinned() {
-		path := fmt.Sprintf("pin/add?arg=%s", hash)
-		_, err = ipfs.get(path)
+	if !pinStatus.IsPinnedAs(pinType) {
+		if pinType == api.PinTypeRecursive && ipfs.maxPinSize > 0 {
+			err = ipfs.checkPinSize(hash)
+			if err != nil {
+				return err
+			}
+		}
+		err = ipfs.pinAdd(hash, pinType == api.PinTypeRecursive, false)
 		if err == nil {
 			logger.Info("IPFS Pin request succeeded: ", hash)
 		}
@@ -404,6 +672,157 @@ func (ipfs *IPFSHTTPConnector) Pin(hash *cid.Cid) error {
 	return nil
 }
 
+// PinPresent pins a Cid whose blocks are expected to be in the IPFS
+// repository already, i.e. after copying a datastore, without fetching
+// anything. The presence of the blocks is checked first with offline
+// requests: a "block/stat" of the root for direct pins, and a
+// "refs -r" of the whole DAG for recursive ones. When any block is
+// missing, a 404 error is returned and nothing is pinned. Otherwise,
+// the "pin/add" request is made offline as well.
+func (ipfs *IPFSHTTPConnector) PinPresent(hash *cid.Cid, pinType api.PinType) error {
+	pinStatus, err := ipfs.PinLsCid(hash)
+	if err != nil {
+		return err
+	}
+	if pinStatus.IsPinnedAs(pinType) {
+		logger.Debug("IPFS object is already pinned: ", hash)
+		return nil
+	}
+
+	recursive := pinType == api.PinTypeRecursive
+	if recursive {
+		_, err = ipfs.readDAG(ipfs.ctx, hash)
+	} else {
+		_, err = ipfs.get(fmt.Sprintf("block/stat?arg=%s&offline=true", hash))
+	}
+	if err != nil {
+		// i.e. 503 when the daemon is down
+		if code, _ := api.ErrorCode(err); code != 0 {
+			return err
+		}
+		return api.NewError(404, "%s is not present in IPFS: %s", hash, err)
+	}
+
+	err = ipfs.pinAdd(hash, recursive, true)
+	if err == nil {
+		logger.Info("IPFS Pin request succeeded without fetching: ", hash)
+	}
+	return err
+}
+
+// pinSize performs an "object/stat" request and returns the cumulative
+// size of the DAG of the given hash. Only the root block is needed to
+// obtain it, so the rest of the DAG is not fetched.
+func (ipfs *IPFSHTTPConnector) pinSize(hash *cid.Cid) (uint64, error) {
+	body, err := ipfs.get(fmt.Sprintf("object/stat?arg=%s", hash))
+	if err != nil {
+		return 0, err
+	}
+
+	var resp ipfsObjectStatResp
+	err = json.Unmarshal(body, &resp)
+	if err != nil {
+		logger.Error("parsing object/stat response")
+		logger.Error(string(body))
+		return 0, err
+	}
+	return resp.CumulativeSize, nil
+}
+
+// checkPinSize returns errPinTooLarge when the given hash is larger
+// than the configured maximum pin size.
+func (ipfs *IPFSHTTPConnector) checkPinSize(hash *cid.Cid) error {
+	size, err := ipfs.pinSize(hash)
+	if err != nil {
+		// Keep the code, i.e. 503 when the daemon is down.
+		if code, msg := api.ErrorCode(err); code != 0 {
+			return api.NewError(code, "error checking the size of %s: %s", hash, msg)
+		}
+		return fmt.Errorf("error checking the size of %s: %s", hash, err)
+	}
+	if size > ipfs.maxPinSize {
+		logger.Warningf("not pinning %s: %d bytes exceed max_pin_size", hash, size)
+		return fmt.Errorf("%s: %d bytes, max_pin_size is %d bytes",
+			errPinTooLarge, size, ipfs.maxPinSize)
+	}
+	return nil
+}
+
+// pinAdd performs a "pin/add" request with progress reporting. IPFS
+// then streams objects with the number of blocks fetched so far, and
+// one listing the pins at the end. Errors happening once the stream
+// has started are sent in the X-Stream-Error trailer. Offline requests
+// fail instead of fetching missing blocks.
+func (ipfs *IPFSHTTPConnector) pinAdd(hash *cid.Cid, recursive, offline bool) error {
+	path := fmt.Sprintf("pin/add?arg=%s&recursive=%t&progress=true&offline=%t",
+		hash, recursive, offline)
+	logger.Debugf("getting %s", path)
+	resp, err := ipfs.getWithRetries(ipfs.pinClient, fmt.Sprintf("%s/%s", ipfs.apiURL(), path))
+	if err != nil {
+		logger.Error("error getting:", err)
+		if isConnRefused(err) {
+			return api.NewError(503, "%s: %s", ipfsDownMsg, err)
+		}
+		if isTimeout(err) {
+			return api.NewError(504, "IPFS did not answer in time: %s", err)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var ipfsErr ipfsError
+		body, _ := ioutil.ReadAll(resp.Body)
+		json.Unmarshal(body, &ipfsErr)
+		msg := fmt.Sprintf("IPFS unsuccessful: %d: %s",
+			resp.StatusCode, ipfsErr.Message)
+		logger.Warning(msg)
+		return errors.New(msg)
+	}
+
+	pinned := false
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var pinResp ipfsPinOpResp
+		err := dec.Decode(&pinResp)
+		if err == io.EOF {
+			break
+		}
+		if isTimeout(err) {
+			return api.NewError(504, "IPFS did not finish pinning in time: %s", err)
+		}
+		if err != nil {
+			return err
+		}
+		if len(pinResp.Pins) > 0 {
+			pinned = true
+			continue
+		}
+		ipfs.reportPinProgress(hash, pinResp.Progress)
+	}
+	if msg := resp.Trailer.Get("X-Stream-Error"); msg != "" {
+		logger.Warning("IPFS unsuccessful: ", msg)
+		return errors.New("IPFS unsuccessful: " + msg)
+	}
+	if !pinned {
+		return fmt.Errorf("IPFS did not confirm the pin of %s", hash)
+	}
+	return nil
+}
+
+// reportPinProgress sends the number of blocks fetched for a Cid being
+// pinned to the PinTracker. Failures are not relevant for the pin.
+func (ipfs *IPFSHTTPConnector) reportPinProgress(hash *cid.Cid, blocks uint64) {
+	err := ipfs.rpcClient.Call("",
+		"Cluster",
+		"TrackerSetPinProgress",
+		api.PinInfo{Cid: hash, Progress: blocks}.ToSerial(),
+		&struct{}{})
+	if err != nil {
+		logger.Debugf("error reporting the pin progress of %s: %s", hash, err)
+	}
+}
+
 // Unpin performs an unpin request against the configured IPFS
 // daemon.
 func (ipfs *IPFSHTTPConnector) Unpin(hash *cid.Cid) error {
@@ -413,7 +832,7 @@ func (ipfs *IPFSHTTPConnector) Unpin(hash *cid.Cid) error {
 	}
 	if pinStatus.IsPinned() {
 		path := fmt.Sprintf("pin/rm?arg=%s", hash)
-		_, err := ipfs.get(path)
+		_, err := ipfs.getWith(ipfs.pinClient, path)
 		if err == nil {
 			logger.Info("IPFS Unpin request succeeded:", hash)
 		}
@@ -425,26 +844,30 @@ func (ipfs *IPFSHTTPConnector) Unpin(hash *cid.Cid) error {
 }
 
 // PinLs performs a "pin ls --type typeFilter" request against the configured
-// IPFS daemon and returns a map of cid strings and their status.
+// IPFS daemon and returns a map of cid strings and their status. IPFS
+// only accepts one type at a time, so a request is made for each of the
+// types in a comma-separated typeFilter (i.e. "recursive,direct").
 func (ipfs *IPFSHTTPConnector) PinLs(typeFilter string) (map[string]api.IPFSPinStatus, error) {
-	body, err := ipfs.get("pin/ls?type=" + typeFilter)
+	statusMap := make(map[string]api.IPFSPinStatus)
+	for _, t := range strings.Split(typeFilter, ",") {
+		body, err := ipfs.get("pin/ls?type=" + t)
 
-	// Some error talking to the daemon
-	if err != nil {
-		return nil, err
-	}
+		// Some error talking to the daemon
+		if err != nil {
+			return nil, err
+		}
 
-	var resp ipfsPinLsResp
-	err = json.Unmarshal(body, &resp)
-	if err != nil {
-		logger.Error("parsing pin/ls response")
-		logger.Error(string(body))
-		return nil, err
-	}
+		var resp ipfsPinLsResp
+		err = json.Unmarshal(body, &resp)
+		if err != nil {
+			logger.Error("parsing pin/ls response")
+			logger.Error(string(body))
+			return nil, err
+		}
 
-	statusMap := make(map[string]api.IPFSPinStatus)
-	for k, v := range resp.Keys {
-		statusMap[k] = api.IPFSPinStatusFromString(v.Type)
+		for k, v := range resp.Keys {
+			statusMap[k] = api.IPFSPinStatusFromString(v.Type)
+		}
 	}
 	return statusMap, nil
 }
@@ -480,23 +903,274 @@ func (ipfs *IPFSHTTPConnector) PinLsCid(hash *cid.Cid) (api.IPFSPinStatus, error
 	return api.IPFSPinStatusFromString(pinObj.Type), nil
 }
 
+// Verify reads every block in the DAG of the given hash from the
+// repository of the IPFS daemon (see readDAG). It returns an error if
+// any block is missing or cannot be read: missing blocks are not
+// fetched from the network, so that they are reported instead of
+// silently restored. The operation is bounded by IPFSVerifyTimeout.
+func (ipfs *IPFSHTTPConnector) Verify(hash *cid.Cid) error {
+	ctx, cancel := context.WithTimeout(ipfs.ctx, IPFSVerifyTimeout)
+	defer cancel()
+
+	blocks, err := ipfs.readDAG(ctx, hash)
+	if err != nil {
+		return err
+	}
+	logger.Infof("verified %s: %d blocks read", hash, blocks)
+	return nil
+}
+
+// readDAG performs an offline "refs -r <hash>" request, which reads
+// every block in the DAG of the given hash from the repository, and
+// returns the number of blocks. It fails when a block is missing or
+// cannot be read, as the daemon does not look for it in the network.
+func (ipfs *IPFSHTTPConnector) readDAG(ctx context.Context, hash *cid.Cid) (int, error) {
+	url := fmt.Sprintf("%s/refs?arg=%s&recursive=true&unique=true&offline=true",
+		ipfs.apiURL(), hash)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return 0, err
+	}
+	// Large DAGs take longer than the metadata requests.
+	resp, err := ipfs.pinClient.Do(req.WithContext(ctx))
+	if err != nil {
+		logger.Error("error getting:", err)
+		if isConnRefused(err) {
+			return 0, api.NewError(503, "%s: %s", ipfsDownMsg, err)
+		}
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var ipfsErr ipfsError
+		body, _ := ioutil.ReadAll(resp.Body)
+		json.Unmarshal(body, &ipfsErr)
+		msg := fmt.Sprintf("IPFS unsuccessful: %d: %s",
+			resp.StatusCode, ipfsErr.Message)
+		logger.Warning(msg)
+		return 0, errors.New(msg)
+	}
+
+	blocks := 0
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var ref ipfsRefsResp
+		err := dec.Decode(&ref)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return blocks, err
+		}
+		if ref.Err != "" {
+			return blocks, fmt.Errorf("error reading the DAG of %s: %s", hash, ref.Err)
+		}
+		blocks++
+	}
+	if msg := resp.Trailer.Get("X-Stream-Error"); msg != "" {
+		return blocks, fmt.Errorf("error reading the DAG of %s: %s", hash, msg)
+	}
+	return blocks, nil
+}
+
+// Add performs an "add" request, streaming the data to the IPFS daemon
+// as a multipart file while it is read, so that large contents are not
+// buffered. It returns the Cid of the root of the added DAG. The
+// content is not pinned by IPFS: that is left to the Cluster.
+func (ipfs *IPFSHTTPConnector) Add(data io.Reader) (*cid.Cid, error) {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		part, err := mw.CreateFormFile("file", "file")
+		if err == nil {
+			_, err = io.Copy(part, data)
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	url := fmt.Sprintf("%s/add?pin=false&progress=false", ipfs.apiURL())
+	req, err := http.NewRequest("POST", url, pr)
+	if err != nil {
+		pr.Close()
+		return nil, err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	// the request body is closed on return, which stops the writer
+	// Adding, like pinning, takes as long as the content is large.
+	resp, err := ipfs.pinClient.Do(req.WithContext(ipfs.ctx))
+	if err != nil {
+		logger.Error("error adding:", err)
+		if isConnRefused(err) {
+			return nil, api.NewError(503, "%s: %s", ipfsDownMsg, err)
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var ipfsErr ipfsError
+		body, _ := ioutil.ReadAll(resp.Body)
+		json.Unmarshal(body, &ipfsErr)
+		msg := fmt.Sprintf("IPFS unsuccessful: %d: %s",
+			resp.StatusCode, ipfsErr.Message)
+		logger.Warning(msg)
+		return nil, errors.New(msg)
+	}
+
+	// One object is sent for each added file or directory, the
+	// root coming last.
+	var root ipfsAddResp
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var added ipfsAddResp
+		err := dec.Decode(&added)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		root = added
+	}
+	if root.Hash == "" {
+		return nil, errors.New("IPFS did not return the Cid of the added content")
+	}
+	logger.Infof("added %s to IPFS", root.Hash)
+	return cid.Decode(root.Hash)
+}
+
+// Resolve returns the Cid which an IPFS path points to. Paths under
+// /ipns/, including DNSLink names, are resolved with a "name/resolve"
+// request first. The resulting /ipfs/ path is then resolved with a
+// "resolve" request. Paths not starting with "/" are taken as
+// /ipfs/ paths.
+func (ipfs *IPFSHTTPConnector) Resolve(path string) (*cid.Cid, error) {
+	if !strings.HasPrefix(path, "/") {
+		path = "/ipfs/" + path
+	}
+
+	var err error
+	if strings.HasPrefix(path, "/ipns/") {
+		path, err = ipfs.resolve("name/resolve", path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	path, err = ipfs.resolve("resolve", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(path, "/ipfs/") {
+		return nil, fmt.Errorf("unexpected resolved path: %s", path)
+	}
+	return cid.Decode(strings.TrimPrefix(path, "/ipfs/"))
+}
+
+// resolve performs a recursive resolution request against the given
+// endpoint and returns the resulting path.
+func (ipfs *IPFSHTTPConnector) resolve(endpoint, path string) (string, error) {
+	body, err := ipfs.get(fmt.Sprintf("%s?arg=%s&recursive=true",
+		endpoint, url.QueryEscape(path)))
+	if err != nil {
+		return "", err
+	}
+
+	var resp ipfsResolveResp
+	err = json.Unmarshal(body, &resp)
+	if err != nil {
+		logger.Errorf("parsing %s response", endpoint)
+		logger.Error(string(body))
+		return "", err
+	}
+	return resp.Path, nil
+}
+
+// RepoStat performs a "repo/stat" request against the configured IPFS
+// daemon and returns the size of the repository and the maximum size
+// it may grow to.
+func (ipfs *IPFSHTTPConnector) RepoStat() (api.IPFSRepoStat, error) {
+	body, err := ipfs.get("repo/stat")
+	if err != nil {
+		return api.IPFSRepoStat{}, err
+	}
+
+	var resp ipfsRepoStatResp
+	err = json.Unmarshal(body, &resp)
+	if err != nil {
+		logger.Error("parsing repo/stat response")
+		logger.Error(string(body))
+		return api.IPFSRepoStat{}, err
+	}
+	return api.IPFSRepoStat{
+		RepoSize:   resp.RepoSize,
+		StorageMax: resp.StorageMax,
+	}, nil
+}
+
+// BandwidthStats performs a "stats/bw" request against the configured
+// IPFS daemon and returns the total and current bandwidth used by it.
+// If the request fails, or the parsing fails, it returns an error and
+// an empty IPFSBandwidth which also contains the error message.
+func (ipfs *IPFSHTTPConnector) BandwidthStats() (api.IPFSBandwidth, error) {
+	bw := api.IPFSBandwidth{}
+	body, err := ipfs.get("stats/bw")
+	if err != nil {
+		bw.Error = err.Error()
+		return bw, err
+	}
+
+	var resp ipfsBandwidthResp
+	err = json.Unmarshal(body, &resp)
+	if err != nil {
+		logger.Error("parsing stats/bw response")
+		logger.Error(string(body))
+		bw.Error = err.Error()
+		return bw, err
+	}
+	bw.TotalIn = resp.TotalIn
+	bw.TotalOut = resp.TotalOut
+	bw.RateIn = resp.RateIn
+	bw.RateOut = resp.RateOut
+	return bw, nil
+}
+
 // get performs the heavy lifting of a get request against
 // the IPFS daemon.
 func (ipfs *IPFSHTTPConnector) get(path string) ([]byte, error) {
+	return ipfs.getWith(ipfs.client, path)
+}
+
+// getWith performs a get request against the IPFS daemon using the
+// given client.
+func (ipfs *IPFSHTTPConnector) getWith(client *http.Client, path string) ([]byte, error) {
 	logger.Debugf("getting %s", path)
 	url := fmt.Sprintf("%s/%s",
 		ipfs.apiURL(),
 		path)
 
-	resp, err := http.Get(url)
+	resp, err := ipfs.getWithRetries(client, url)
 	if err != nil {
 		logger.Error("error getting:", err)
+		if isConnRefused(err) {
+			return nil, api.NewError(503, "%s: %s", ipfsDownMsg, err)
+		}
+		if isTimeout(err) {
+			return nil, api.NewError(504, "IPFS did not answer in time: %s", err)
+		}
 		return nil, err
 	}
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		logger.Errorf("error reading response body: %s", err)
+		if isTimeout(err) {
+			return nil, api.NewError(504, "IPFS did not answer in time: %s", err)
+		}
 		return nil, err
 	}
 
@@ -518,6 +1192,62 @@ func (ipfs *IPFSHTTPConnector) get(path string) ([]byte, error) {
 	return body, nil
 }
 
+// getWithRetries performs a GET request, retrying with backoff while
+// the IPFS daemon refuses connections (see IPFSConnectRetries). It
+// updates the connectivity state reported by Online().
+func (ipfs *IPFSHTTPConnector) getWithRetries(client *http.Client, url string) (*http.Response, error) {
+	delay := IPFSConnectRetryDelay
+	for i := 0; ; i++ {
+		resp, err := client.Get(url)
+		if err == nil {
+			atomic.StoreInt32(&ipfs.online, 1)
+			return resp, nil
+		}
+		if !isConnRefused(err) {
+			return nil, err
+		}
+		atomic.StoreInt32(&ipfs.online, 0)
+		if i >= IPFSConnectRetries {
+			return nil, err
+		}
+		logger.Debugf("IPFS daemon refused connection. Retrying in %s", delay)
+		select {
+		case <-ipfs.doneCh:
+			return nil, err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// Online returns false when the IPFS daemon refused the last request
+// made to it, which usually means that it is not running.
+func (ipfs *IPFSHTTPConnector) Online() bool {
+	return atomic.LoadInt32(&ipfs.online) == 1
+}
+
+func isConnRefused(err error) bool {
+	return strings.Contains(err.Error(), "connection refused")
+}
+
+// isTimeout returns true if the error comes from a request which did not
+// complete in time.
+func isTimeout(err error) bool {
+	nerr, ok := err.(net.Error)
+	return ok && nerr.Timeout()
+}
+
+// isIPFSDown returns true if the error was returned by the IPFS
+// connector, possibly over RPC, because the IPFS daemon refuses
+// connections.
+func isIPFSDown(err error) bool {
+	if err == nil {
+		return false
+	}
+	code, msg := api.ErrorCode(err)
+	return code == 503 && strings.Contains(msg, ipfsDownMsg)
+}
+
 // apiURL is a short-hand for building the url of the IPFS
 // daemon API.
 func (ipfs *IPFSHTTPConnector) apiURL() string {
@@ -1,11 +1,17 @@
 package ipfscluster
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"mime/multipart"
 	"net/http"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/ipfs/ipfs-cluster/api"
 	"github.com/ipfs/ipfs-cluster/test"
@@ -55,14 +61,27 @@ func TestIPFSID(t *testing.T) {
 	if id.Error != "" {
 		t.Error("expected no error")
 	}
+	if !ipfs.Online() {
+		t.Error("IPFS should be online")
+	}
 	mock.Close()
 	id, err = ipfs.ID()
 	if err == nil {
-		t.Error("expected an error")
+		t.Fatal("expected an error")
 	}
 	if id.Error != err.Error() {
 		t.Error("error messages should match")
 	}
+	if !isIPFSDown(err) || ipfs.Online() {
+		t.Error("IPFS should be down")
+	}
+	// as received over RPC
+	if !isIPFSDown(errors.New(err.Error())) {
+		t.Error("IPFS should be down after RPC")
+	}
+	if isIPFSDown(errors.New("IPFS unsuccessful: 500: error")) {
+		t.Error("other errors do not mean that IPFS is down")
+	}
 }
 
 func TestIPFSPin(t *testing.T) {
@@ -70,7 +89,7 @@ func TestIPFSPin(t *testing.T) {
 	defer mock.Close()
 	defer ipfs.Shutdown()
 	c, _ := cid.Decode(test.TestCid1)
-	err := ipfs.Pin(c)
+	err := ipfs.Pin(c, api.PinTypeRecursive)
 	if err != nil {
 		t.Error("expected success pinning cid")
 	}
@@ -83,12 +102,159 @@ func TestIPFSPin(t *testing.T) {
 	}
 
 	c2, _ := cid.Decode(test.ErrorCid)
-	err = ipfs.Pin(c2)
+	err = ipfs.Pin(c2, api.PinTypeRecursive)
 	if err == nil {
 		t.Error("expected error pinning cid")
 	}
 }
 
+func TestIPFSPinPresent(t *testing.T) {
+	ipfs, mock := testIPFSConnector(t)
+	defer mock.Close()
+	defer ipfs.Shutdown()
+
+	for _, pt := range []api.PinType{api.PinTypeDirect, api.PinTypeRecursive} {
+		c, _ := cid.Decode(test.PresentCid)
+		err := ipfs.PinPresent(c, pt)
+		if err != nil {
+			t.Errorf("%s: expected success pinning present content: %s", pt, err)
+		}
+		pinSt, err := ipfs.PinLsCid(c)
+		if err != nil || !pinSt.IsPinnedAs(pt) {
+			t.Errorf("%s: cid should have been pinned", pt)
+		}
+		ipfs.Unpin(c)
+
+		c3, _ := cid.Decode(test.TestCid3)
+		err = ipfs.PinPresent(c3, pt)
+		if code, _ := api.ErrorCode(err); code != 404 {
+			t.Errorf("%s: expected a 404 error for missing blocks, got: %s", pt, err)
+		}
+		pinSt, _ = ipfs.PinLsCid(c3)
+		if pinSt.IsPinned() {
+			t.Errorf("%s: missing content should not be pinned", pt)
+		}
+	}
+}
+
+func TestIPFSPinMaxSize(t *testing.T) {
+	mock := test.NewIpfsMock()
+	defer mock.Close()
+	cfg := testIPFSConnectorConfig(mock)
+	cfg.MaxPinSize = test.TestPinSize - 1
+	ipfs, err := NewIPFSHTTPConnector(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ipfs.Shutdown()
+	ipfs.SetClient(test.NewMockRPCClient(t))
+
+	c, _ := cid.Decode(test.TestCid1)
+	err = ipfs.Pin(c, api.PinTypeRecursive)
+	if err == nil || !strings.HasPrefix(err.Error(), errPinTooLarge.Error()) {
+		t.Fatal("expected a max_pin_size error, got: ", err)
+	}
+	pinSt, err := ipfs.PinLsCid(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pinSt.IsPinned() {
+		t.Error("cid should not have been pinned")
+	}
+
+	// direct pins only fetch one block
+	err = ipfs.Pin(c, api.PinTypeDirect)
+	if err != nil {
+		t.Error("expected success pinning directly: ", err)
+	}
+
+	ipfs.maxPinSize = test.TestPinSize
+	err = ipfs.Pin(c, api.PinTypeRecursive)
+	if err != nil {
+		t.Error("expected success pinning cid: ", err)
+	}
+
+	mock.Close()
+	err = ipfs.checkPinSize(c)
+	if e, ok := err.(api.Error); !ok || e.Code != 503 || !isIPFSDown(err) {
+		t.Error("expected a 503 error with the daemon down, got: ", err)
+	}
+}
+
+func TestIPFSPinTimeout(t *testing.T) {
+	ipfs, mock := testIPFSConnector(t)
+	defer mock.Close()
+	defer ipfs.Shutdown()
+
+	if ipfs.client.Timeout != DefaultIPFSRequestTimeoutSeconds*time.Second {
+		t.Error("requests should time out by default")
+	}
+	if ipfs.pinClient.Timeout != DefaultIPFSPinTimeoutSeconds*time.Second {
+		t.Error("pins should time out by default")
+	}
+
+	// The mock sends the progress of slow pins before waiting, so
+	// this times out while reading it.
+	ipfs.pinClient.Timeout = test.SlowCidDelay / 10
+	c, _ := cid.Decode(test.SlowCid)
+	err := ipfs.Pin(c, api.PinTypeRecursive)
+	if err == nil {
+		t.Fatal("expected a timeout")
+	}
+	if code, _ := api.ErrorCode(err); code != 504 {
+		t.Error("expected a 504 error, got: ", err)
+	}
+
+	c2, _ := cid.Decode(test.TestCid1)
+	err = ipfs.Pin(c2, api.PinTypeRecursive)
+	if err != nil {
+		t.Error("fast pins should succeed: ", err)
+	}
+}
+
+func TestIPFSPinDirect(t *testing.T) {
+	ipfs, mock := testIPFSConnector(t)
+	defer mock.Close()
+	defer ipfs.Shutdown()
+	c, _ := cid.Decode(test.TestCid1)
+	err := ipfs.Pin(c, api.PinTypeDirect)
+	if err != nil {
+		t.Fatal("expected success pinning cid")
+	}
+	pinSt, err := ipfs.PinLsCid(c)
+	if err != nil {
+		t.Fatal("expected success doing ls")
+	}
+	if pinSt != api.IPFSPinStatusDirect {
+		t.Error("cid should have been pinned directly")
+	}
+
+	pins, err := ipfs.PinLs("recursive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pins) != 0 {
+		t.Error("direct pins should not be listed as recursive")
+	}
+	pins, err = ipfs.PinLs("recursive,direct")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pins[test.TestCid1] != api.IPFSPinStatusDirect {
+		t.Error("expected the direct pin to be listed")
+	}
+
+	// pinning recursively upgrades the pin
+	err = ipfs.Pin(c, api.PinTypeRecursive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinSt, _ = ipfs.PinLsCid(c)
+	if pinSt != api.IPFSPinStatusRecursive {
+		t.Error("cid should have been pinned recursively")
+	}
+}
+
 func TestIPFSUnpin(t *testing.T) {
 	ipfs, mock := testIPFSConnector(t)
 	defer mock.Close()
@@ -98,7 +264,7 @@ func TestIPFSUnpin(t *testing.T) {
 	if err != nil {
 		t.Error("expected success unpinning non-pinned cid")
 	}
-	ipfs.Pin(c)
+	ipfs.Pin(c, api.PinTypeRecursive)
 	err = ipfs.Unpin(c)
 	if err != nil {
 		t.Error("expected success unpinning pinned cid")
@@ -112,7 +278,7 @@ func TestIPFSPinLsCid(t *testing.T) {
 	c, _ := cid.Decode(test.TestCid1)
 	c2, _ := cid.Decode(test.TestCid2)
 
-	ipfs.Pin(c)
+	ipfs.Pin(c, api.PinTypeRecursive)
 	ips, err := ipfs.PinLsCid(c)
 	if err != nil || !ips.IsPinned() {
 		t.Error("c should appear pinned")
@@ -124,6 +290,26 @@ func TestIPFSPinLsCid(t *testing.T) {
 	}
 }
 
+func TestIPFSVerify(t *testing.T) {
+	ipfs, mock := testIPFSConnector(t)
+	defer mock.Close()
+	defer ipfs.Shutdown()
+	c, _ := cid.Decode(test.TestCid1)
+	c2, _ := cid.Decode(test.TestCid2)
+	errCid, _ := cid.Decode(test.ErrorCid)
+
+	ipfs.Pin(c, api.PinTypeRecursive)
+	if err := ipfs.Verify(c); err != nil {
+		t.Error("c should verify:", err)
+	}
+	if err := ipfs.Verify(c2); err == nil {
+		t.Error("expected an error verifying a cid whose blocks are not present")
+	}
+	if err := ipfs.Verify(errCid); err == nil {
+		t.Error("expected an error verifying a cid with missing blocks")
+	}
+}
+
 func TestIPFSPinLs(t *testing.T) {
 	ipfs, mock := testIPFSConnector(t)
 	defer mock.Close()
@@ -131,8 +317,8 @@ func TestIPFSPinLs(t *testing.T) {
 	c, _ := cid.Decode(test.TestCid1)
 	c2, _ := cid.Decode(test.TestCid2)
 
-	ipfs.Pin(c)
-	ipfs.Pin(c2)
+	ipfs.Pin(c, api.PinTypeRecursive)
+	ipfs.Pin(c2, api.PinTypeRecursive)
 	ipsMap, err := ipfs.PinLs("")
 	if err != nil {
 		t.Error("should not error")
@@ -147,6 +333,97 @@ func TestIPFSPinLs(t *testing.T) {
 	}
 }
 
+func TestIPFSResolve(t *testing.T) {
+	ipfs, mock := testIPFSConnector(t)
+	defer mock.Close()
+	defer ipfs.Shutdown()
+
+	testcases := []struct {
+		path string
+		cid  string
+	}{
+		{test.TestCid1, test.TestCid1},
+		{"/ipfs/" + test.TestCid1, test.TestCid1},
+		{"/ipfs/" + test.TestCid1 + "/dir", test.TestCid2},
+		{"/ipns/" + test.TestIPNSName, test.TestCid2},
+	}
+	for _, tc := range testcases {
+		c, err := ipfs.Resolve(tc.path)
+		if err != nil {
+			t.Errorf("%s: %s", tc.path, err)
+			continue
+		}
+		if c.String() != tc.cid {
+			t.Errorf("%s: expected %s but got %s", tc.path, tc.cid, c)
+		}
+	}
+
+	if _, err := ipfs.Resolve("/ipns/unknown.com"); err == nil {
+		t.Error("expected an error resolving an unknown name")
+	}
+}
+
+func TestIPFSAdd(t *testing.T) {
+	ipfs, mock := testIPFSConnector(t)
+	defer mock.Close()
+	defer ipfs.Shutdown()
+
+	c, err := ipfs.Add(strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.String() != test.TestCid1 {
+		t.Error("expected the Cid returned by IPFS, got ", c)
+	}
+
+	if _, err := ipfs.Add(strings.NewReader("")); err == nil {
+		t.Error("expected an error when IPFS fails to add")
+	}
+}
+
+func TestIPFSRepoStat(t *testing.T) {
+	ipfs, mock := testIPFSConnector(t)
+	defer mock.Close()
+	defer ipfs.Shutdown()
+
+	rs, err := ipfs.RepoStat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rs.RepoSize != test.TestRepoSize || rs.StorageMax != test.TestStorageMax {
+		t.Errorf("unexpected repo stats: %+v", rs)
+	}
+	if rs.FreeSpace() != test.TestStorageMax-test.TestRepoSize {
+		t.Error("bad free space")
+	}
+}
+
+func TestIPFSBandwidthStats(t *testing.T) {
+	ipfs, mock := testIPFSConnector(t)
+	defer ipfs.Shutdown()
+
+	bw, err := ipfs.BandwidthStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bw.TotalIn != test.TestBandwidthTotalIn || bw.TotalOut != test.TestBandwidthTotalOut ||
+		bw.RateIn != test.TestBandwidthRateIn || bw.RateOut != test.TestBandwidthRateOut {
+		t.Errorf("unexpected bandwidth stats: %+v", bw)
+	}
+	if bw.Error != "" {
+		t.Error("expected no error")
+	}
+
+	mock.Close()
+	bw, err = ipfs.BandwidthStats()
+	if err == nil {
+		t.Error("expected an error")
+	}
+	if bw.Error != err.Error() {
+		t.Error("error messages should match")
+	}
+}
+
 func TestIPFSProxyVersion(t *testing.T) {
 	// This makes sure default handler is used
 
@@ -183,6 +460,59 @@ func TestIPFSProxyVersion(t *testing.T) {
 	}
 }
 
+func TestIPFSProxyForward(t *testing.T) {
+	ipfs, mock := testIPFSConnector(t)
+	defer mock.Close()
+	defer ipfs.Shutdown()
+
+	cfg := testingConfig()
+	host, _ := cfg.IPFSProxyAddr.ValueForProtocol(ma.P_IP4)
+	port, _ := cfg.IPFSProxyAddr.ValueForProtocol(ma.P_TCP)
+	proxyURL := fmt.Sprintf("http://%s:%s/api/v0", host, port)
+
+	// The headers and body of the request should be forwarded, even
+	// when it is large and slow to send
+	pr, pw := io.Pipe()
+	mpw := multipart.NewWriter(pw)
+	go func() {
+		fw, _ := mpw.CreateFormFile("file", "file")
+		chunk := bytes.Repeat([]byte("hello"), 1024*1024)
+		fw.Write(chunk)
+		time.Sleep(time.Second)
+		fw.Write(chunk)
+		mpw.Close()
+		pw.Close()
+	}()
+	res, err := http.Post(proxyURL+"/add", mpw.FormDataContentType(), pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatal("the request should have succeeded: ", res.StatusCode)
+	}
+	var resp struct {
+		Hash string
+	}
+	err = json.NewDecoder(res.Body).Decode(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Hash != test.TestCid1 {
+		t.Error("unexpected response: ", resp)
+	}
+
+	// and so should the response status
+	res2, err := http.Get(proxyURL + "/dag/get?arg=" + test.TestCid1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res2.Body.Close()
+	if res2.StatusCode != http.StatusNotFound {
+		t.Error("expected the status from IPFS, got ", res2.StatusCode)
+	}
+}
+
 func TestIPFSProxyPin(t *testing.T) {
 	ipfs, mock := testIPFSConnector(t)
 	defer mock.Close()
@@ -239,6 +569,50 @@ func TestIPFSProxyPin(t *testing.T) {
 	res.Body.Close()
 }
 
+func TestIPFSProxyPinPaths(t *testing.T) {
+	ipfs, mock := testIPFSConnector(t)
+	defer mock.Close()
+	defer ipfs.Shutdown()
+
+	cfg := testingConfig()
+	host, _ := cfg.IPFSProxyAddr.ValueForProtocol(ma.P_IP4)
+	port, _ := cfg.IPFSProxyAddr.ValueForProtocol(ma.P_TCP)
+	res, err := http.Get(fmt.Sprintf("http://%s:%s/api/v0/pin/add?arg=/ipfs/%s&arg=/ipfs/%s/dir",
+		host,
+		port,
+		test.TestCid1,
+		test.TestCid1))
+	if err != nil {
+		t.Fatal("should have succeeded: ", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatal("the request should have succeeded")
+	}
+
+	var resp ipfsPinOpResp
+	err = json.NewDecoder(res.Body).Decode(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// paths under TestCid1 resolve to TestCid2
+	if len(resp.Pins) != 2 || resp.Pins[0] != test.TestCid1 || resp.Pins[1] != test.TestCid2 {
+		t.Error("wrong response: ", resp.Pins)
+	}
+
+	res2, err := http.Get(fmt.Sprintf("http://%s:%s/api/v0/pin/rm?arg=/ipfs/%s/missing",
+		host,
+		port,
+		test.TestCid2))
+	if err != nil {
+		t.Fatal("request should work: ", err)
+	}
+	res2.Body.Close()
+	if res2.StatusCode != http.StatusInternalServerError {
+		t.Error("paths which cannot be resolved should fail")
+	}
+}
+
 func TestIPFSProxyUnpin(t *testing.T) {
 	ipfs, mock := testIPFSConnector(t)
 	defer mock.Close()
@@ -361,3 +735,24 @@ func TestIPFSShutdown(t *testing.T) {
 		t.Error("expected a second clean shutdown")
 	}
 }
+
+func TestDaemonWatchObserve(t *testing.T) {
+	var dw daemonWatch
+	errDown := errors.New("connection refused")
+
+	if dw.observe(test.TestPeerID1, nil) {
+		t.Error("first observation should not be a restart")
+	}
+	if dw.observe(test.TestPeerID1, nil) {
+		t.Error("same ID should not be a restart")
+	}
+	if dw.observe("", errDown) || dw.observe("", errDown) {
+		t.Error("failures should not be restarts")
+	}
+	if !dw.observe(test.TestPeerID1, nil) {
+		t.Error("coming back after failing should be a restart")
+	}
+	if !dw.observe(test.TestPeerID2, nil) {
+		t.Error("a different ID should be a restart")
+	}
+}
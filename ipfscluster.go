@@ -6,13 +6,28 @@
 // communication between its different components, which perform different
 // tasks like managing the underlying IPFS daemons, or providing APIs for
 // external control.
+//
+// A Cluster peer can also be embedded in other Go programs and be driven
+// directly through the methods described by the ClusterAPI interface,
+// without going through the HTTP or RPC APIs. The lifecycle is:
+//
+//   - Build a Config (NewDefaultConfig() or LoadConfig()) and the
+//     components, then call NewCluster(). The API component is optional
+//     and can be nil when no external API is wanted.
+//   - Wait on Ready() before performing operations.
+//   - Use Pin(), Unpin(), Status(), Peers(), PeerAdd() etc. as needed.
+//   - Call Shutdown() and wait on Done() to stop the peer.
 package ipfscluster
 
 import (
+	"context"
+	"io"
+
 	rpc "github.com/hsanjuan/go-libp2p-gorpc"
 	cid "github.com/ipfs/go-cid"
 	peer "github.com/libp2p/go-libp2p-peer"
 	protocol "github.com/libp2p/go-libp2p-protocol"
+	ma "github.com/multiformats/go-multiaddr"
 
 	"github.com/ipfs/ipfs-cluster/api"
 )
@@ -29,6 +44,75 @@ type Component interface {
 	Shutdown() error
 }
 
+// ClusterAPI describes the Go API offered by a Cluster peer. It is
+// implemented by Cluster and allows embedding a peer in other programs
+// and driving it directly. The REST and RPC APIs are thin wrappers
+// around these methods.
+type ClusterAPI interface {
+	ID() api.ID
+	Version() string
+	Health() api.Health
+	QueueInfo() api.QueueInfo
+	Summary() api.Summary
+	Ready() <-chan struct{}
+	Done() <-chan struct{}
+	Shutdown() error
+
+	Peers() []api.ID
+	PeerInfo(pid peer.ID) (api.ID, error)
+	ConsistencyCheck() api.ConsistencyReport
+	RaftConfiguration() ([]api.RaftServer, error)
+	Snapshot() error
+	ExportState() ([]byte, error)
+	ImportState(data []byte) error
+	ReconcileDryRun() (api.ReconcilePlan, error)
+	PeerAdd(addr ma.Multiaddr) (api.ID, error)
+	PeerAddForce(addr ma.Multiaddr) (api.ID, error)
+	PeerRemove(pid peer.ID) error
+	Leave() error
+	Join(addr ma.Multiaddr) error
+	ReplacePeer(oldAddr, newAddr ma.Multiaddr) (api.PeerReplacement, error)
+	PeerReplacement() api.PeerReplacement
+	Alerts() <-chan api.Alert
+	SubscribeAlerts() (<-chan api.Alert, func())
+
+	Pin(carg api.CidArg) (uint64, error)
+	PinMany(cargs []api.CidArg) []api.PinResult
+	Unpin(carg api.CidArg) error
+	ForceUnpin(h *cid.Cid) error
+	UnpinIfHealthy(carg api.CidArg) error
+	Protect(h *cid.Cid, protected bool) error
+	PinPath(path string, carg api.CidArg) (*cid.Cid, uint64, error)
+	PinAcks(h *cid.Cid) ([]api.PinAck, error)
+	Pins() []api.CidArg
+	LocalAllocations() []api.CidArg
+	PinsByPeer(p peer.ID) []api.CidArg
+	PinsByNamespace(ns string) []api.CidArg
+	PinsByName(name string) []api.CidArg
+	PinsByMetadata(key, value string) []api.CidArg
+	StateStale() bool
+	Allocations(h *cid.Cid) (api.CidArg, error)
+	AllocationPreview(h *cid.Cid, replication int) (api.AllocationPreview, error)
+	WaitForIndex(index uint64) error
+	Touch(h *cid.Cid)
+
+	Status(h *cid.Cid) (api.GlobalPinInfo, error)
+	StatusCids(ctx context.Context, cids []*cid.Cid) ([]api.GlobalPinInfo, error)
+	StatusAll(ctx context.Context) ([]api.GlobalPinInfo, error)
+	StatusAllPage(ctx context.Context, after string, limit int) (api.StatusPage, error)
+	StreamStatusAll(ctx context.Context, f func(api.GlobalPinInfo) error) error
+	StatusChanges(req api.StatusChangesRequest) (api.StatusChanges, error)
+	Sync(h *cid.Cid) (api.GlobalPinInfo, error)
+	SyncAll(ctx context.Context) ([]api.GlobalPinInfo, error)
+	RecoverAll(ctx context.Context) ([]api.GlobalPinInfo, error)
+	Recover(h *cid.Cid) (api.GlobalPinInfo, error)
+	Verify(h *cid.Cid) ([]api.VerifyResult, error)
+	ServingPeer(h *cid.Cid) (api.ID, error)
+	Reallocate(h *cid.Cid, newPeers []peer.ID) (api.GlobalPinInfo, error)
+}
+
+var _ ClusterAPI = &Cluster{}
+
 // API is a component which offers an API for Cluster. This is
 // a base component.
 type API interface {
@@ -40,10 +124,31 @@ type API interface {
 type IPFSConnector interface {
 	Component
 	ID() (api.IPFSID, error)
-	Pin(*cid.Cid) error
+	// Pin pins a Cid. It may report its progress while it runs with
+	// the TrackerSetPinProgress RPC method.
+	Pin(*cid.Cid, api.PinType) error
+	// PinPresent pins a Cid only if its blocks are in the IPFS
+	// repository already, without fetching any. It returns a 404
+	// error otherwise.
+	PinPresent(*cid.Cid, api.PinType) error
 	Unpin(*cid.Cid) error
 	PinLsCid(*cid.Cid) (api.IPFSPinStatus, error)
+	// PinLs lists the pins of the given types, separated by commas
+	// (i.e. "recursive,direct").
 	PinLs(typeFilter string) (map[string]api.IPFSPinStatus, error)
+	// Verify checks that all the blocks of a Cid can be read.
+	Verify(*cid.Cid) error
+	// Resolve returns the Cid an IPFS path (/ipfs/ or /ipns/)
+	// points to.
+	Resolve(path string) (*cid.Cid, error)
+	// RepoStat returns the size of the IPFS repository and the
+	// maximum size it may grow to.
+	RepoStat() (api.IPFSRepoStat, error)
+	// BandwidthStats returns the bandwidth used by IPFS.
+	BandwidthStats() (api.IPFSBandwidth, error)
+	// Add adds the content read from data to IPFS and returns the
+	// Cid of its root. It is not pinned by IPFS.
+	Add(data io.Reader) (*cid.Cid, error)
 }
 
 // Peered represents a component which needs to be aware of the peers
@@ -64,10 +169,33 @@ type State interface {
 	Rm(*cid.Cid) error
 	// List lists all the pins in the state
 	List() []api.CidArg
+	// ListByPeer lists the pins allocated to a peer, including
+	// those pinned everywhere
+	ListByPeer(peer.ID) []api.CidArg
+	// ListByNamespace lists the pins in a namespace
+	ListByNamespace(string) []api.CidArg
+	// ListByName lists the pins with a name
+	ListByName(string) []api.CidArg
+	// ListByMetadata lists the pins with a metadata key set to a
+	// value
+	ListByMetadata(key, value string) []api.CidArg
+	// Len returns the number of pins in the state
+	Len() int
 	// Has returns true if the state is holding information for a Cid
 	Has(*cid.Cid) bool
 	// Get returns the information attacthed to this pin
 	Get(*cid.Cid) api.CidArg
+	// Checksum returns a hash of the Cids in the state, which
+	// is the same for states holding the same Cids
+	Checksum() string
+	// Migrate upgrades a state restored from an older snapshot
+	// to the current format
+	Migrate() error
+	// Marshal serializes the state
+	Marshal() ([]byte, error)
+	// Unmarshal replaces the pins with those of a state serialized
+	// with Marshal, after migrating and validating them
+	Unmarshal([]byte) error
 }
 
 // PinTracker represents a component which tracks the status of
@@ -78,21 +206,86 @@ type PinTracker interface {
 	// Track tells the tracker that a Cid is now under its supervision
 	// The tracker may decide to perform an IPFS pin.
 	Track(api.CidArg) error
+	// TrackContext works like Track but the given context bounds how
+	// long the operation may wait in queue before being abandoned.
+	TrackContext(context.Context, api.CidArg) error
 	// Untrack tells the tracker that a Cid is to be forgotten. The tracker
 	// may perform an IPFS unpin operation.
 	Untrack(*cid.Cid) error
 	// StatusAll returns the list of pins with their local status.
-	StatusAll() []api.PinInfo
+	// It returns nil if the context is cancelled.
+	StatusAll(context.Context) []api.PinInfo
+	// StatusPage returns, sorted by Cid, up to limit pins with their
+	// local status, starting after the given Cid.
+	StatusPage(after string, limit int) []api.PinInfo
 	// Status returns the local status of a given Cid.
 	Status(*cid.Cid) api.PinInfo
 	// SyncAll makes sure that all tracked Cids reflect the real IPFS status.
-	// It returns the list of pins which were updated by the call.
-	SyncAll() ([]api.PinInfo, error)
+	// It returns the list of pins which were updated by the call. It
+	// stops and returns the context's error if it is cancelled.
+	SyncAll(context.Context) ([]api.PinInfo, error)
 	// Sync makes sure that the Cid status reflect the real IPFS status.
 	// It returns the local status of the Cid.
 	Sync(*cid.Cid) (api.PinInfo, error)
 	// Recover retriggers a Pin/Unpin operation in Cids with error status.
 	Recover(*cid.Cid) (api.PinInfo, error)
+	// Load returns information about how busy the tracker is.
+	Load() api.TrackerLoad
+	// QueueInfo returns the occupancy of the pin and unpin queues.
+	QueueInfo() api.QueueInfo
+	// SetPinProgress records the number of blocks fetched so far for
+	// a Cid which is being pinned.
+	SetPinProgress(c *cid.Cid, blocks uint64)
+}
+
+// PinEventSource is implemented by components which can notify
+// changes in the status of tracked items, like the MapPinTracker.
+type PinEventSource interface {
+	// Subscribe returns a channel receiving the status of items every
+	// time it changes and a function to cancel the subscription.
+	Subscribe() (<-chan api.PinInfo, func())
+}
+
+// AlertSource is implemented by components which raise alerts for
+// operators, like the Cluster.
+type AlertSource interface {
+	// SubscribeAlerts returns a channel receiving the alerts and a
+	// function to cancel the subscription.
+	SubscribeAlerts() (<-chan api.Alert, func())
+}
+
+// alertStreamer is implemented by API components which stream the
+// alerts of the Cluster to their clients, like the RESTAPI.
+type alertStreamer interface {
+	SetAlertSource(AlertSource)
+}
+
+// StatusSource is implemented by components which can stream the
+// status of all the items, like the Cluster.
+type StatusSource interface {
+	// StreamStatusAll calls the given function with the status of
+	// every item, sorted by Cid, until it returns an error.
+	StreamStatusAll(context.Context, func(api.GlobalPinInfo) error) error
+}
+
+// statusStreamer is implemented by API components which stream the
+// status of all the items to their clients, like the RESTAPI.
+type statusStreamer interface {
+	SetStatusSource(StatusSource)
+}
+
+// ContentAdder is implemented by components which can add content to
+// the IPFS daemon of this peer, like the IPFSConnector.
+type ContentAdder interface {
+	// Add adds the content read from data and returns the Cid of
+	// its root.
+	Add(data io.Reader) (*cid.Cid, error)
+}
+
+// contentReceiver is implemented by API components which receive
+// content to add from their clients, like the RESTAPI.
+type contentReceiver interface {
+	SetContentAdder(ContentAdder)
 }
 
 // Informer provides Metric information from a peer. The metrics produced by
@@ -130,6 +323,9 @@ type PeerMonitor interface {
 	// LastMetrics returns a map with the latest metrics of matching name
 	// for the current cluster peers.
 	LastMetrics(name string) []api.Metric
+	// ExpiredPeers returns the peers whose last metric of the given
+	// name has expired, because they stopped sending them.
+	ExpiredPeers(name string) []peer.ID
 	// Alerts delivers alerts generated when this peer monitor detects
 	// a problem (i.e. metrics not arriving as expected). Alerts are used to
 	// trigger rebalancing operations.
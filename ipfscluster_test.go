@@ -1,9 +1,11 @@
 package ipfscluster
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"os"
+	"sort"
 	"strings"
 	"sync"
 	"testing"
@@ -37,6 +39,8 @@ var (
 
 func init() {
 	rand.Seed(time.Now().UnixNano())
+	// Some tests stop the IPFS mock on purpose
+	IPFSConnectRetryDelay = 10 * time.Millisecond
 }
 
 func checkErr(t *testing.T, err error) {
@@ -266,19 +270,19 @@ func TestClustersPin(t *testing.T) {
 		j := rand.Intn(nClusters)           // choose a random cluster peer
 		h, err := prefix.Sum(randomBytes()) // create random cid
 		checkErr(t, err)
-		err = clusters[j].Pin(h)
+		_, err = clusters[j].Pin(api.CidArgCid(h))
 		if err != nil {
 			t.Errorf("error pinning %s: %s", h, err)
 		}
 		// Test re-pin
-		err = clusters[j].Pin(h)
+		_, err = clusters[j].Pin(api.CidArgCid(h))
 		if err != nil {
 			t.Errorf("error repinning %s: %s", h, err)
 		}
 	}
 	delay()
 	fpinned := func(t *testing.T, c *Cluster) {
-		status := c.tracker.StatusAll()
+		status := c.tracker.StatusAll(c.ctx)
 		for _, v := range status {
 			if v.Status != api.TrackerStatusPinned {
 				t.Errorf("%s should have been pinned but it is %s",
@@ -297,12 +301,12 @@ func TestClustersPin(t *testing.T) {
 
 	for i := 0; i < nPins; i++ {
 		j := rand.Intn(nClusters) // choose a random cluster peer
-		err := clusters[j].Unpin(pinList[i].Cid)
+		err := clusters[j].Unpin(pinList[i])
 		if err != nil {
 			t.Errorf("error unpinning %s: %s", pinList[i].Cid, err)
 		}
 		// test re-unpin
-		err = clusters[j].Unpin(pinList[i].Cid)
+		err = clusters[j].Unpin(pinList[i])
 		if err != nil {
 			t.Errorf("error re-unpinning %s: %s", pinList[i].Cid, err)
 		}
@@ -311,7 +315,7 @@ func TestClustersPin(t *testing.T) {
 	delay()
 
 	funpinned := func(t *testing.T, c *Cluster) {
-		status := c.tracker.StatusAll()
+		status := c.tracker.StatusAll(c.ctx)
 		if l := len(status); l != 0 {
 			t.Errorf("Nothing should be pinned")
 			//t.Errorf("%+v", status)
@@ -324,11 +328,11 @@ func TestClustersStatusAll(t *testing.T) {
 	clusters, mock := createClusters(t)
 	defer shutdownClusters(t, clusters, mock)
 	h, _ := cid.Decode(test.TestCid1)
-	clusters[0].Pin(h)
+	clusters[0].Pin(api.CidArgCid(h))
 	delay()
 	// Global status
 	f := func(t *testing.T, c *Cluster) {
-		statuses, err := c.StatusAll()
+		statuses, err := c.StatusAll(context.Background())
 		if err != nil {
 			t.Error(err)
 		}
@@ -364,17 +368,95 @@ func TestClustersStatusAll(t *testing.T) {
 	runF(t, clusters, f)
 }
 
+func TestClustersStatusAllPage(t *testing.T) {
+	clusters, mock := createClusters(t)
+	defer shutdownClusters(t, clusters, mock)
+	cids := []string{test.TestCid1, test.TestCid2, test.TestCid3}
+	for _, s := range cids {
+		h, _ := cid.Decode(s)
+		clusters[0].Pin(api.CidArgCid(h))
+	}
+	sort.Strings(cids)
+	delay()
+
+	f := func(t *testing.T, c *Cluster) {
+		ctx := context.Background()
+		page, err := c.StatusAllPage(ctx, "", 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Items) != 2 || page.Items[1].Cid.String() != cids[1] {
+			t.Fatal("expected the first two items")
+		}
+		if page.Next != cids[1] {
+			t.Error("expected a next page after ", cids[1])
+		}
+
+		// the last page has no next page, even when it is full
+		page, err = c.StatusAllPage(ctx, page.Next, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Items) != 1 || page.Items[0].Cid.String() != cids[2] {
+			t.Fatal("expected the last item")
+		}
+		if page.Next != "" {
+			t.Error("the last page should not have a next page")
+		}
+
+		page, err = c.StatusAllPage(ctx, "", 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Items) != 3 || page.Next != "" {
+			t.Error("expected a single page with all the items")
+		}
+	}
+	runF(t, clusters, f)
+}
+
+func TestClustersStatusCids(t *testing.T) {
+	clusters, mock := createClusters(t)
+	defer shutdownClusters(t, clusters, mock)
+	h1, _ := cid.Decode(test.TestCid1)
+	h2, _ := cid.Decode(test.TestCid2)
+	clusters[0].Pin(api.CidArgCid(h1))
+	delay()
+	f := func(t *testing.T, c *Cluster) {
+		statuses, err := c.StatusCids(context.Background(), []*cid.Cid{h2, h1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(statuses) != 2 {
+			t.Fatal("expected two items")
+		}
+		if !statuses[0].Cid.Equals(h2) || !statuses[1].Cid.Equals(h1) {
+			t.Error("the items should be in the same order as the Cids")
+		}
+		if len(statuses[0].PeerMap) != nClusters || len(statuses[1].PeerMap) != nClusters {
+			t.Error("expected the status in every peer")
+		}
+		if statuses[0].PeerMap[c.host.ID()].Status != api.TrackerStatusUnpinned {
+			t.Error("an unknown Cid should be unpinned")
+		}
+		if statuses[1].PeerMap[c.host.ID()].Status != api.TrackerStatusPinned {
+			t.Error("the hash should have been pinned")
+		}
+	}
+	runF(t, clusters, f)
+}
+
 func TestClustersSyncAllLocal(t *testing.T) {
 	clusters, mock := createClusters(t)
 	defer shutdownClusters(t, clusters, mock)
 	h, _ := cid.Decode(test.ErrorCid) // This cid always fails
 	h2, _ := cid.Decode(test.TestCid2)
-	clusters[0].Pin(h)
-	clusters[0].Pin(h2)
+	clusters[0].Pin(api.CidArgCid(h))
+	clusters[0].Pin(api.CidArgCid(h2))
 	delay()
 	f := func(t *testing.T, c *Cluster) {
 		// Sync bad ID
-		infos, err := c.SyncAllLocal()
+		infos, err := c.SyncAllLocal(context.Background())
 		if err != nil {
 			// LocalSync() is asynchronous and should not show an
 			// error even if Recover() fails.
@@ -397,8 +479,8 @@ func TestClustersSyncLocal(t *testing.T) {
 	defer shutdownClusters(t, clusters, mock)
 	h, _ := cid.Decode(test.ErrorCid) // This cid always fails
 	h2, _ := cid.Decode(test.TestCid2)
-	clusters[0].Pin(h)
-	clusters[0].Pin(h2)
+	clusters[0].Pin(api.CidArgCid(h))
+	clusters[0].Pin(api.CidArgCid(h2))
 	delay()
 
 	f := func(t *testing.T, c *Cluster) {
@@ -428,12 +510,12 @@ func TestClustersSyncAll(t *testing.T) {
 	defer shutdownClusters(t, clusters, mock)
 	h, _ := cid.Decode(test.ErrorCid) // This cid always fails
 	h2, _ := cid.Decode(test.TestCid2)
-	clusters[0].Pin(h)
-	clusters[0].Pin(h2)
+	clusters[0].Pin(api.CidArgCid(h))
+	clusters[0].Pin(api.CidArgCid(h2))
 	delay()
 
 	j := rand.Intn(nClusters) // choose a random cluster peer
-	ginfos, err := clusters[j].SyncAll()
+	ginfos, err := clusters[j].SyncAll(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -459,8 +541,8 @@ func TestClustersSync(t *testing.T) {
 	defer shutdownClusters(t, clusters, mock)
 	h, _ := cid.Decode(test.ErrorCid) // This cid always fails
 	h2, _ := cid.Decode(test.TestCid2)
-	clusters[0].Pin(h)
-	clusters[0].Pin(h2)
+	clusters[0].Pin(api.CidArgCid(h))
+	clusters[0].Pin(api.CidArgCid(h2))
 	delay()
 
 	j := rand.Intn(nClusters)
@@ -520,8 +602,8 @@ func TestClustersRecoverLocal(t *testing.T) {
 	defer shutdownClusters(t, clusters, mock)
 	h, _ := cid.Decode(test.ErrorCid) // This cid always fails
 	h2, _ := cid.Decode(test.TestCid2)
-	clusters[0].Pin(h)
-	clusters[0].Pin(h2)
+	clusters[0].Pin(api.CidArgCid(h))
+	clusters[0].Pin(api.CidArgCid(h2))
 
 	delay()
 
@@ -547,13 +629,43 @@ func TestClustersRecoverLocal(t *testing.T) {
 	runF(t, clusters, f)
 }
 
+func TestClustersRecoverAll(t *testing.T) {
+	clusters, mock := createClusters(t)
+	defer shutdownClusters(t, clusters, mock)
+	h, _ := cid.Decode(test.ErrorCid) // This cid always fails
+	h2, _ := cid.Decode(test.TestCid2)
+	clusters[0].Pin(api.CidArgCid(h))
+	clusters[0].Pin(api.CidArgCid(h2))
+
+	delay()
+
+	j := rand.Intn(nClusters)
+	ginfos, err := clusters[j].RecoverAll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the item in error is recovered
+	if len(ginfos) != 1 || ginfos[0].Cid.String() != test.ErrorCid {
+		t.Fatal("expected only the errored item to be recovered")
+	}
+	for _, c := range clusters {
+		inf, ok := ginfos[0].PeerMap[c.host.ID()]
+		if !ok {
+			t.Fatal("GlobalPinInfo should have this cluster")
+		}
+		if inf.Status != api.TrackerStatusPinError {
+			t.Error("should be PinError in all peers")
+		}
+	}
+}
+
 func TestClustersRecover(t *testing.T) {
 	clusters, mock := createClusters(t)
 	defer shutdownClusters(t, clusters, mock)
 	h, _ := cid.Decode(test.ErrorCid) // This cid always fails
 	h2, _ := cid.Decode(test.TestCid2)
-	clusters[0].Pin(h)
-	clusters[0].Pin(h2)
+	clusters[0].Pin(api.CidArgCid(h))
+	clusters[0].Pin(api.CidArgCid(h2))
 
 	delay()
 
@@ -646,7 +758,7 @@ func TestClustersReplication(t *testing.T) {
 		j := rand.Intn(nClusters)           // choose a random cluster peer
 		h, err := prefix.Sum(randomBytes()) // create random cid
 		checkErr(t, err)
-		err = clusters[j].Pin(h)
+		_, err = clusters[j].Pin(api.CidArgCid(h))
 		if err != nil {
 			t.Error(err)
 		}
@@ -679,7 +791,7 @@ func TestClustersReplication(t *testing.T) {
 	}
 
 	f := func(t *testing.T, c *Cluster) {
-		pinfos := c.tracker.StatusAll()
+		pinfos := c.tracker.StatusAll(c.ctx)
 		if len(pinfos) != nClusters {
 			t.Error("Pinfos does not have the expected pins")
 		}
@@ -736,7 +848,7 @@ func TestClustersReplicationRealloc(t *testing.T) {
 
 	j := rand.Intn(nClusters)
 	h, _ := cid.Decode(test.TestCid1)
-	err := clusters[j].Pin(h)
+	_, err := clusters[j].Pin(api.CidArgCid(h))
 	if err != nil {
 		t.Error(err)
 	}
@@ -744,12 +856,22 @@ func TestClustersReplicationRealloc(t *testing.T) {
 	// Let the pin arrive
 	time.Sleep(time.Second / 2)
 
-	// Re-pin should fail as it is allocated already
-	err = clusters[j].Pin(h)
-	if err == nil {
-		t.Fatal("expected an error")
+	// Re-pin should keep the current allocations
+	allocs := clusters[j].Pins()[0].Allocations
+	_, err = clusters[j].Pin(api.CidArgCid(h))
+	if err != nil {
+		t.Fatal("re-pinning should keep the current allocations:", err)
+	}
+	time.Sleep(time.Second / 2)
+	newAllocs := clusters[j].Pins()[0].Allocations
+	if len(newAllocs) != len(allocs) {
+		t.Errorf("allocations changed on re-pin: %s -> %s", allocs, newAllocs)
+	}
+	for _, p := range allocs {
+		if !containsPeer(newAllocs, p) {
+			t.Errorf("allocations changed on re-pin: %s -> %s", allocs, newAllocs)
+		}
 	}
-	t.Log(err)
 
 	var killedClusterIndex int
 	// find someone that pinned it and kill that cluster
@@ -766,7 +888,7 @@ func TestClustersReplicationRealloc(t *testing.T) {
 	time.Sleep(2 * time.Second)
 
 	// now pin should succeed
-	err = clusters[j].Pin(h)
+	_, err = clusters[j].Pin(api.CidArgCid(h))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -805,7 +927,7 @@ func TestClustersReplicationNotEnoughPeers(t *testing.T) {
 
 	j := rand.Intn(nClusters)
 	h, _ := cid.Decode(test.TestCid1)
-	err := clusters[j].Pin(h)
+	_, err := clusters[j].Pin(api.CidArgCid(h))
 	if err != nil {
 		t.Error(err)
 	}
@@ -820,7 +942,7 @@ func TestClustersReplicationNotEnoughPeers(t *testing.T) {
 	delay()
 	delay()
 
-	err = clusters[j].Pin(h)
+	_, err = clusters[j].Pin(api.CidArgCid(h))
 	if err == nil {
 		t.Fatal("expected an error")
 	}
@@ -18,6 +18,7 @@ const (
 	LogOpUnpin
 	LogOpAddPeer
 	LogOpRmPeer
+	LogOpPinMany
 )
 
 // LogOpType expresses the type of a consensus Operation
@@ -28,6 +29,7 @@ type LogOpType int
 // Consensus component.
 type LogOp struct {
 	Cid       api.CidArgSerial
+	Cids      []api.CidArgSerial
 	Peer      api.MultiaddrSerial
 	Type      LogOpType
 	ctx       context.Context
@@ -50,6 +52,11 @@ func (op *LogOp) ApplyTo(cstate consensus.State) (consensus.State, error) {
 		if err != nil {
 			goto ROLLBACK
 		}
+		if op.rpcClient == nil {
+			// Replayed before RPC is ready. The tracker will
+			// catch up on the StateSync run once consensus is ready.
+			break
+		}
 		// Async, we let the PinTracker take care of any problems
 		op.rpcClient.Go("",
 			"Cluster",
@@ -57,12 +64,33 @@ func (op *LogOp) ApplyTo(cstate consensus.State) (consensus.State, error) {
 			arg.ToSerial(),
 			&struct{}{},
 			nil)
+	case LogOpPinMany:
+		for _, carg := range op.Cids {
+			err = state.Add(carg.ToCidArg())
+			if err != nil {
+				goto ROLLBACK
+			}
+		}
+		if op.rpcClient == nil {
+			break
+		}
+		for _, carg := range op.Cids {
+			op.rpcClient.Go("",
+				"Cluster",
+				"Track",
+				carg,
+				&struct{}{},
+				nil)
+		}
 	case LogOpUnpin:
 		arg := op.Cid.ToCidArg()
 		err = state.Rm(arg.Cid)
 		if err != nil {
 			goto ROLLBACK
 		}
+		if op.rpcClient == nil {
+			break
+		}
 		// Async, we let the PinTracker take care of any problems
 		op.rpcClient.Go("",
 			"Cluster",
@@ -71,6 +99,10 @@ func (op *LogOp) ApplyTo(cstate consensus.State) (consensus.State, error) {
 			&struct{}{},
 			nil)
 	case LogOpAddPeer:
+		if op.rpcClient == nil {
+			logger.Warning("cannot add peer: RPC client not set")
+			break
+		}
 		addr := op.Peer.ToMultiaddr()
 		op.rpcClient.Call("",
 			"Cluster",
@@ -79,6 +111,10 @@ func (op *LogOp) ApplyTo(cstate consensus.State) (consensus.State, error) {
 			&struct{}{})
 		// TODO rebalance ops
 	case LogOpRmPeer:
+		if op.rpcClient == nil {
+			logger.Warning("cannot remove peer: RPC client not set")
+			break
+		}
 		addr := op.Peer.ToMultiaddr()
 		pidstr, err := addr.ValueForProtocol(ma.P_IPFS)
 		if err != nil {
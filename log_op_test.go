@@ -27,6 +27,26 @@ func TestApplyToPin(t *testing.T) {
 	}
 }
 
+func TestApplyToPinMany(t *testing.T) {
+	op := &LogOp{
+		Cids: []api.CidArgSerial{
+			{Cid: test.TestCid1},
+			{Cid: test.TestCid2},
+		},
+		Type:      LogOpPinMany,
+		ctx:       context.Background(),
+		rpcClient: test.NewMockRPCClient(t),
+	}
+
+	st := mapstate.NewMapState()
+	op.ApplyTo(st)
+	c1, _ := cid.Decode(test.TestCid1)
+	c2, _ := cid.Decode(test.TestCid2)
+	if st.Len() != 2 || !st.Has(c1) || !st.Has(c2) {
+		t.Error("the state was not modified correctly")
+	}
+}
+
 func TestApplyToUnpin(t *testing.T) {
 	op := &LogOp{
 		Cid:       api.CidArgSerial{Cid: test.TestCid1},
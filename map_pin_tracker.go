@@ -3,6 +3,9 @@ package ipfscluster
 import (
 	"context"
 	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 
@@ -22,22 +25,52 @@ var (
 )
 
 // PinQueueSize specifies the maximum amount of pin operations waiting
-// to be performed. If the queue is full, pins/unpins will be set to
-// pinError/unpinError.
+// to be performed, unless Config.PinQueueSize is set. If the queue is
+// full, pins/unpins will be set to pinError/unpinError.
 var PinQueueSize = 1024
 
+// PinWorkers and UnpinWorkers specify how many pin and unpin operations
+// are sent to IPFS at the same time, unless Config.PinWorkers and
+// Config.UnpinWorkers are set.
+var (
+	PinWorkers   = 1
+	UnpinWorkers = 1
+)
+
+// SyncAllBatchSize is the number of IPFS pin ls requests made at the
+// same time when SyncAll checks tracked items one by one. See
+// Config.SyncAllBatchRatio.
+var SyncAllBatchSize = 50
+
+// ErrPinQueueFull is returned when there is no room for more pins in
+// the pin queue.
+var ErrPinQueueFull = api.NewError(503, "pin queue is full")
+
 var (
 	errUnpinningTimeout = errors.New("unpinning operation is taking too long")
 	errPinningTimeout   = errors.New("pinning operation is taking too long")
 	errPinned           = errors.New("the item is unexpectedly pinned on IPFS")
 	errUnpinned         = errors.New("the item is unexpectedly not pinned on IPFS")
+	errPinQueueTimeout  = errors.New("timed out waiting in the pin queue")
+	errNotPresent       = errors.New("the item is not present in IPFS and fetching is disabled for it")
 )
 
+// trackOp is an item in the pin queue. The context is checked by the
+// worker before performing the operation so that callers can bound the
+// time an operation may wait in the queue.
+type trackOp struct {
+	ctx  context.Context
+	carg api.CidArg
+}
+
 // MapPinTracker is a PinTracker implementation which uses a Go map
 // to store the status of the tracked Cids. This component is thread-safe.
 type MapPinTracker struct {
 	mux    sync.RWMutex
 	status map[string]api.PinInfo
+	// the CidArgs of the items being pinned or unpinned, for their
+	// options
+	tracked map[string]api.CidArg
 
 	ctx    context.Context
 	cancel func()
@@ -46,9 +79,35 @@ type MapPinTracker struct {
 	rpcReady  chan struct{}
 
 	peerID  peer.ID
-	pinCh   chan api.CidArg
+	pinCh   chan trackOp
 	unpinCh chan api.CidArg
 
+	// number of goroutines reading pinCh and unpinCh
+	pinWorkers   int
+	unpinWorkers int
+
+	// moving average of the time taken by successful IPFS pins
+	avgPinDuration time.Duration
+
+	// notified when items enter an error state (may be nil)
+	webhook *webhook
+
+	// notified when the status of items changes (see Subscribe)
+	subsMux sync.Mutex
+	subs    map[chan api.PinInfo]struct{}
+
+	// file where the status is saved, if Config.PersistTrackerState
+	statePath string
+
+	// retries of failed operations (disabled when retryMax is 0)
+	retryMax        int
+	retryMaxBackoff time.Duration
+
+	// SyncAll strategy: number of pins found in IPFS by the last
+	// full listing (-1 if none yet) and the batching ratio
+	ipfsPinCount   int
+	syncBatchRatio float64
+
 	shutdownLock sync.Mutex
 	shutdown     bool
 	wg           sync.WaitGroup
@@ -59,38 +118,127 @@ type MapPinTracker struct {
 func NewMapPinTracker(cfg *Config) *MapPinTracker {
 	ctx, cancel := context.WithCancel(context.Background())
 
+	queueSize := PinQueueSize
+	if cfg.PinQueueSize > 0 {
+		queueSize = cfg.PinQueueSize
+	}
+	pinWorkers := PinWorkers
+	if cfg.PinWorkers > 0 {
+		pinWorkers = cfg.PinWorkers
+	}
+	unpinWorkers := UnpinWorkers
+	if cfg.UnpinWorkers > 0 {
+		unpinWorkers = cfg.UnpinWorkers
+	}
+
 	mpt := &MapPinTracker{
 		ctx:      ctx,
 		cancel:   cancel,
 		status:   make(map[string]api.PinInfo),
-		rpcReady: make(chan struct{}, 1),
+		tracked:  make(map[string]api.CidArg),
+		rpcReady: make(chan struct{}),
+		subs:     make(map[chan api.PinInfo]struct{}),
 		peerID:   cfg.ID,
-		pinCh:    make(chan api.CidArg, PinQueueSize),
-		unpinCh:  make(chan api.CidArg, PinQueueSize),
+		pinCh:    make(chan trackOp, queueSize),
+		unpinCh:  make(chan api.CidArg, queueSize),
+
+		pinWorkers:   pinWorkers,
+		unpinWorkers: unpinWorkers,
+
+		ipfsPinCount:   -1,
+		syncBatchRatio: cfg.SyncAllBatchRatio,
+
+		retryMax:        cfg.PinRetryMaxAttempts,
+		retryMaxBackoff: time.Duration(cfg.PinRetryMaxBackoffSeconds) * time.Second,
+	}
+	if cfg.PinErrorWebhook != "" {
+		mpt.webhook = newWebhook(cfg.PinErrorWebhook)
+		mpt.wg.Add(1)
+		go func() {
+			defer mpt.wg.Done()
+			mpt.webhook.run(ctx)
+		}()
+	}
+	if cfg.PersistTrackerState {
+		mpt.statePath = filepath.Join(cfg.ConsensusDataFolder, trackerStateFile)
+		if err := mpt.loadStatus(mpt.statePath); err != nil {
+			logger.Errorf("error loading the pin tracker status: %s", err)
+		}
+		mpt.wg.Add(1)
+		go func() {
+			defer mpt.wg.Done()
+			mpt.flushStatus(ctx, mpt.statePath)
+		}()
 	}
-	go mpt.pinWorker()
-	go mpt.unpinWorker()
+	if mpt.retryMax > 0 {
+		mpt.wg.Add(1)
+		go func() {
+			defer mpt.wg.Done()
+			mpt.retryWorker()
+		}()
+	}
+	mpt.wg.Add(1)
+	go func() {
+		defer mpt.wg.Done()
+		mpt.startWorkers()
+	}()
 	return mpt
 }
 
-// reads the queue and makes pins to the IPFS daemon one by one
+// startWorkers launches the pin and unpin workers once the RPC client
+// has been set. Until then, operations just wait in the queues.
+// The workers are accounted in the WaitGroup before startWorkers
+// returns, so that Shutdown waits for all of them.
+func (mpt *MapPinTracker) startWorkers() {
+	select {
+	case <-mpt.rpcReady:
+	case <-mpt.ctx.Done():
+		return
+	}
+	if mpt.ctx.Err() != nil {
+		return
+	}
+	for i := 0; i < mpt.pinWorkers; i++ {
+		mpt.wg.Add(1)
+		go func() {
+			defer mpt.wg.Done()
+			mpt.pinWorker()
+		}()
+	}
+	for i := 0; i < mpt.unpinWorkers; i++ {
+		mpt.wg.Add(1)
+		go func() {
+			defer mpt.wg.Done()
+			mpt.unpinWorker()
+		}()
+	}
+}
+
+// reads the queue and makes pins to the IPFS daemon. Several workers
+// may run at the same time (see Config.PinWorkers).
 func (mpt *MapPinTracker) pinWorker() {
 	for {
 		select {
-		case p := <-mpt.pinCh:
-			mpt.pin(p)
+		case op := <-mpt.pinCh:
+			if err := op.ctx.Err(); err != nil {
+				logger.Warningf("abandoning queued pin for %s: %s", op.carg.Cid, err)
+				mpt.setError(op.carg.Cid, errPinQueueTimeout)
+				continue
+			}
+			mpt.pin(mpt.ctx, op.carg)
 		case <-mpt.ctx.Done():
 			return
 		}
 	}
 }
 
-// reads the queue and makes unpin requests to the IPFS daemon
+// reads the queue and makes unpin requests to the IPFS daemon. Several
+// workers may run at the same time (see Config.UnpinWorkers).
 func (mpt *MapPinTracker) unpinWorker() {
 	for {
 		select {
 		case p := <-mpt.unpinCh:
-			mpt.unpin(p)
+			mpt.unpin(mpt.ctx, p)
 		case <-mpt.ctx.Done():
 			return
 		}
@@ -110,8 +258,13 @@ func (mpt *MapPinTracker) Shutdown() error {
 
 	logger.Info("stopping MapPinTracker")
 	mpt.cancel()
-	close(mpt.rpcReady)
 	mpt.wg.Wait()
+	if mpt.statePath != "" {
+		if err := mpt.saveStatus(mpt.statePath); err != nil {
+			logger.Errorf("error saving the pin tracker status: %s", err)
+		}
+	}
+	mpt.closeSubscriptions()
 	mpt.shutdown = true
 	return nil
 }
@@ -123,18 +276,70 @@ func (mpt *MapPinTracker) set(c *cid.Cid, s api.TrackerStatus) {
 }
 
 func (mpt *MapPinTracker) unsafeSet(c *cid.Cid, s api.TrackerStatus) {
+	k := c.String()
+	p, ok := mpt.status[k]
 	if s == api.TrackerStatusUnpinned {
-		delete(mpt.status, c.String())
+		delete(mpt.tracked, k)
+		delete(mpt.status, k)
+		if ok {
+			mpt.notify(api.PinInfo{
+				Cid:    c,
+				Peer:   mpt.peerID,
+				Status: s,
+				TS:     time.Now(),
+			})
+		}
 		return
 	}
 
-	mpt.status[c.String()] = api.PinInfo{
-		Cid:    c,
-		Peer:   mpt.peerID,
-		Status: s,
-		TS:     time.Now(),
-		Error:  "",
+	// Operations being retried keep counting their attempts
+	var attempts int
+	if ok && p.Status == s {
+		attempts = p.Attempts
+	}
+
+	mpt.status[k] = api.PinInfo{
+		Cid:      c,
+		Peer:     mpt.peerID,
+		Status:   s,
+		TS:       time.Now(),
+		Error:    "",
+		Attempts: attempts,
+	}
+	if !ok || p.Status != s {
+		mpt.notify(mpt.status[k])
+	}
+}
+
+// setPinning sets a Cid to Pinning and keeps its CidArg, which
+// carries its pinning timeout and is used to retry it.
+func (mpt *MapPinTracker) setPinning(c api.CidArg) {
+	mpt.mux.Lock()
+	defer mpt.mux.Unlock()
+	mpt.unsafeSet(c.Cid, api.TrackerStatusPinning)
+	mpt.tracked[c.Cid.String()] = c
+}
+
+// pinTimeout returns how long the given Cid may stay in Pinning
+// state: its own timeout if it set one or the global PinningTimeout.
+func (mpt *MapPinTracker) pinTimeout(c *cid.Cid) time.Duration {
+	if carg, ok := mpt.trackedArg(c); ok && carg.PinTimeout > 0 {
+		return carg.PinTimeout
+	}
+	return PinningTimeout
+}
+
+// trackedArg returns the CidArg with which a Cid is being pinned or
+// unpinned, or has been pinned. Otherwise, it returns one with the
+// default options and false.
+func (mpt *MapPinTracker) trackedArg(c *cid.Cid) (api.CidArg, bool) {
+	mpt.mux.RLock()
+	defer mpt.mux.RUnlock()
+	carg, ok := mpt.tracked[c.String()]
+	if !ok {
+		return api.CidArg{Cid: c}, false
 	}
+	return carg, true
 }
 
 func (mpt *MapPinTracker) get(c *cid.Cid) api.PinInfo {
@@ -164,65 +369,175 @@ func (mpt *MapPinTracker) setError(c *cid.Cid, err error) {
 	mpt.unsafeSetError(c, err)
 }
 
+// unsafeSetError sets the Cid in PinError or UnpinError, depending on
+// its current status, and notifies the webhook when the Cid was not
+// already in that state.
 func (mpt *MapPinTracker) unsafeSetError(c *cid.Cid, err error) {
 	p := mpt.unsafeGet(c)
+	gaveUp := false
+	defer func() {
+		newp := mpt.unsafeGet(c)
+		if newp.Status == p.Status {
+			return
+		}
+		if mpt.webhook != nil {
+			mpt.webhook.notify(newp)
+		}
+		mpt.notify(newp)
+		if gaveUp {
+			mpt.alertStuck(newp)
+		}
+	}()
+
+	// Each failure counts as an attempt. Retries stop when they
+	// reach the maximum.
+	attempts := p.Attempts
+	msg := err.Error()
+	if p.Status != api.TrackerStatusPinError && p.Status != api.TrackerStatusUnpinError {
+		attempts++
+		if mpt.retryMax > 0 && attempts >= mpt.retryMax {
+			msg = fmt.Sprintf("%s (gave up after %d attempts)", msg, attempts)
+			gaveUp = true
+		}
+	}
+
 	switch p.Status {
 	case api.TrackerStatusPinned, api.TrackerStatusPinning, api.TrackerStatusPinError:
 		mpt.status[c.String()] = api.PinInfo{
-			Cid:    c,
-			Peer:   mpt.peerID,
-			Status: api.TrackerStatusPinError,
-			TS:     time.Now(),
-			Error:  err.Error(),
+			Cid:      c,
+			Peer:     mpt.peerID,
+			Status:   api.TrackerStatusPinError,
+			TS:       time.Now(),
+			Error:    msg,
+			Attempts: attempts,
 		}
 	case api.TrackerStatusUnpinned, api.TrackerStatusUnpinning, api.TrackerStatusUnpinError:
 		mpt.status[c.String()] = api.PinInfo{
-			Cid:    c,
-			Peer:   mpt.peerID,
-			Status: api.TrackerStatusUnpinError,
-			TS:     time.Now(),
-			Error:  err.Error(),
+			Cid:      c,
+			Peer:     mpt.peerID,
+			Status:   api.TrackerStatusUnpinError,
+			TS:       time.Now(),
+			Error:    msg,
+			Attempts: attempts,
 		}
 	}
 }
 
 func (mpt *MapPinTracker) isRemote(c api.CidArg) bool {
-	if c.Everywhere {
-		return false
-	}
-
-	for _, p := range c.Allocations {
-		if p == mpt.peerID {
-			return false
-		}
-	}
-	return true
+	return !c.AllocatedTo(mpt.peerID)
 }
 
-func (mpt *MapPinTracker) pin(c api.CidArg) error {
+// pin asks the IPFS daemon to pin an item. The context bounds how long
+// to wait for it: the workers use the tracker's own context so that
+// shutting down does not wait for pins in progress. The item's pinning
+// timeout (see pinTimeout) is applied as well, so that a stuck daemon
+// does not block the worker: the pin is then abandoned and the item
+// set to PinError.
+func (mpt *MapPinTracker) pin(ctx context.Context, c api.CidArg) error {
 	mpt.set(c.Cid, api.TrackerStatusPinning)
-	err := mpt.rpcClient.Call("",
+	ctx, cancel := context.WithTimeout(ctx, mpt.pinTimeout(c.Cid))
+	defer cancel()
+	if c.NoFetch {
+		return mpt.adopt(ctx, c)
+	}
+
+	start := time.Now()
+	err := mpt.rpcClient.CallContext(ctx, "",
 		"Cluster",
 		"IPFSPin",
 		c.ToSerial(),
 		&struct{}{})
 
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		logger.Errorf("abandoning pin of %s after %s: IPFS did not answer in time",
+			c.Cid, time.Since(start))
+		err = errPinningTimeout
+	}
 	if err != nil {
 		mpt.setError(c.Cid, err)
 		return err
 	}
 
+	mpt.recordPinDuration(time.Since(start))
 	mpt.set(c.Cid, api.TrackerStatusPinned)
 	return nil
 }
 
-func (mpt *MapPinTracker) unpin(c api.CidArg) error {
-	err := mpt.rpcClient.Call("",
+// recordPinDuration updates the moving average of pin durations, giving
+// more weight to recent pins so the average follows the trend.
+func (mpt *MapPinTracker) recordPinDuration(d time.Duration) {
+	mpt.mux.Lock()
+	defer mpt.mux.Unlock()
+	if mpt.avgPinDuration == 0 {
+		mpt.avgPinDuration = d
+		return
+	}
+	mpt.avgPinDuration = (mpt.avgPinDuration*4 + d) / 5
+}
+
+// Load returns the current state of the queues, as given by
+// QueueInfo, along with the average time pins are taking.
+func (mpt *MapPinTracker) Load() api.TrackerLoad {
+	q := mpt.QueueInfo()
+	mpt.mux.RLock()
+	defer mpt.mux.RUnlock()
+	return api.TrackerLoad{
+		QueueLength:      q.PinQueueLength,
+		QueueCapacity:    q.PinQueueCapacity,
+		UnpinQueueLength: q.UnpinQueueLength,
+		AvgPinDuration:   mpt.avgPinDuration,
+	}
+}
+
+// QueueInfo returns the number of operations waiting in the pin and
+// unpin queues, along with their capacity.
+func (mpt *MapPinTracker) QueueInfo() api.QueueInfo {
+	return api.QueueInfo{
+		PinQueueLength:     len(mpt.pinCh),
+		PinQueueCapacity:   cap(mpt.pinCh),
+		UnpinQueueLength:   len(mpt.unpinCh),
+		UnpinQueueCapacity: cap(mpt.unpinCh),
+	}
+}
+
+// adopt pins an item without letting IPFS fetch any of it, as its
+// blocks are expected to be in the IPFS repository already. When any
+// of them is missing, the item is marked with errNotPresent and nothing
+// is pinned in the daemon.
+func (mpt *MapPinTracker) adopt(ctx context.Context, c api.CidArg) error {
+	err := mpt.rpcClient.CallContext(ctx, "",
+		"Cluster",
+		"IPFSPinPresent",
+		c.ToSerial(),
+		&struct{}{})
+	if code, _ := api.ErrorCode(err); code == 404 {
+		err = errNotPresent
+	}
+	if err != nil {
+		mpt.setError(c.Cid, err)
+		return err
+	}
+
+	mpt.set(c.Cid, api.TrackerStatusPinned)
+	return nil
+}
+
+// unpin asks the IPFS daemon to unpin an item, like pin does. It gives
+// up after UnpinningTimeout and sets the item to UnpinError.
+func (mpt *MapPinTracker) unpin(ctx context.Context, c api.CidArg) error {
+	ctx, cancel := context.WithTimeout(ctx, UnpinningTimeout)
+	defer cancel()
+	err := mpt.rpcClient.CallContext(ctx, "",
 		"Cluster",
 		"IPFSUnpin",
 		c.ToSerial(),
 		&struct{}{})
 
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		logger.Errorf("abandoning unpin of %s after %s: IPFS did not answer in time",
+			c.Cid, UnpinningTimeout)
+		err = errUnpinningTimeout
+	}
 	if err != nil {
 		mpt.setError(c.Cid, err)
 		return err
@@ -235,29 +550,59 @@ func (mpt *MapPinTracker) unpin(c api.CidArg) error {
 // possibly trigerring Pin operations on the IPFS daemon.
 func (mpt *MapPinTracker) Track(c api.CidArg) error {
 	if mpt.isRemote(c) {
-		if mpt.get(c.Cid).Status == api.TrackerStatusPinned {
-			mpt.unpin(c)
-		}
-		mpt.set(c.Cid, api.TrackerStatusRemote)
+		mpt.trackRemote(c)
 		return nil
 	}
 
-	mpt.set(c.Cid, api.TrackerStatusPinning)
+	mpt.setPinning(c)
 	select {
-	case mpt.pinCh <- c:
+	case mpt.pinCh <- trackOp{mpt.ctx, c}:
 	default:
-		mpt.setError(c.Cid, errors.New("pin queue is full"))
-		return logError("map_pin_tracker pin queue is full")
+		mpt.setError(c.Cid, errors.New(ErrPinQueueFull.Message))
+		logger.Errorf("could not queue pin for %s: %s", c.Cid, ErrPinQueueFull)
+		return ErrPinQueueFull
+	}
+	return nil
+}
+
+// TrackContext works like Track, but it waits for room in the pin
+// queue until the given context is cancelled or its deadline expires.
+// The context also bounds how long the operation may stay queued: if
+// it is done by the time a worker picks the operation up, the pin is
+// abandoned and set to PinError. TrackContext returns the context's
+// error when the operation could not be queued in time.
+func (mpt *MapPinTracker) TrackContext(ctx context.Context, c api.CidArg) error {
+	if mpt.isRemote(c) {
+		mpt.trackRemote(c)
+		return nil
+	}
+
+	mpt.setPinning(c)
+	select {
+	case mpt.pinCh <- trackOp{ctx, c}:
+	case <-ctx.Done():
+		mpt.setError(c.Cid, errPinQueueTimeout)
+		logger.Errorf("could not queue pin for %s: %s", c.Cid, ctx.Err())
+		return ctx.Err()
 	}
 	return nil
 }
 
+func (mpt *MapPinTracker) trackRemote(c api.CidArg) {
+	if mpt.get(c.Cid).Status == api.TrackerStatusPinned {
+		mpt.unpin(mpt.ctx, c)
+	}
+	mpt.set(c.Cid, api.TrackerStatusRemote)
+}
+
 // Untrack tells the MapPinTracker to stop managing a Cid.
 // If the Cid is pinned locally, it will be unpinned.
 func (mpt *MapPinTracker) Untrack(c *cid.Cid) error {
+	// Unpinned with the options it was pinned with, i.e. its type
+	carg, _ := mpt.trackedArg(c)
 	mpt.set(c, api.TrackerStatusUnpinning)
 	select {
-	case mpt.unpinCh <- api.CidArgCid(c):
+	case mpt.unpinCh <- carg:
 	default:
 		mpt.setError(c, errors.New("unpin queue is full"))
 		return logError("map_pin_tracker unpin queue is full")
@@ -265,24 +610,71 @@ func (mpt *MapPinTracker) Untrack(c *cid.Cid) error {
 	return nil
 }
 
+// SetPinProgress records the number of blocks fetched so far for an
+// item which is being pinned. It is ignored for items in any other
+// status. The progress is reset on the next status change.
+func (mpt *MapPinTracker) SetPinProgress(c *cid.Cid, blocks uint64) {
+	mpt.mux.Lock()
+	defer mpt.mux.Unlock()
+	k := c.String()
+	p, ok := mpt.status[k]
+	if !ok || p.Status != api.TrackerStatusPinning {
+		return
+	}
+	p.Progress = blocks
+	mpt.status[k] = p
+}
+
 // Status returns information for a Cid tracked by this
 // MapPinTracker.
 func (mpt *MapPinTracker) Status(c *cid.Cid) api.PinInfo {
 	return mpt.get(c)
 }
 
+// statusAllCheckInterval is how many items StatusAll copies between
+// checks of its context.
+const statusAllCheckInterval = 1000
+
 // StatusAll returns information for all Cids tracked by this
-// MapPinTracker.
-func (mpt *MapPinTracker) StatusAll() []api.PinInfo {
+// MapPinTracker, or nil if the context is cancelled before it is done.
+func (mpt *MapPinTracker) StatusAll(ctx context.Context) []api.PinInfo {
+	if ctx.Err() != nil {
+		return nil
+	}
 	mpt.mux.Lock()
 	defer mpt.mux.Unlock()
 	pins := make([]api.PinInfo, 0, len(mpt.status))
 	for _, v := range mpt.status {
+		if len(pins)%statusAllCheckInterval == 0 && ctx.Err() != nil {
+			return nil
+		}
 		pins = append(pins, v)
 	}
 	return pins
 }
 
+// StatusPage returns, sorted by Cid, information for up to limit Cids
+// tracked by this MapPinTracker which sort after the given one.
+func (mpt *MapPinTracker) StatusPage(after string, limit int) []api.PinInfo {
+	mpt.mux.RLock()
+	defer mpt.mux.RUnlock()
+	keys := make([]string, 0, len(mpt.status))
+	for k := range mpt.status {
+		if k > after {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	if limit >= 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+	pins := make([]api.PinInfo, len(keys), len(keys))
+	for i, k := range keys {
+		pins[i] = mpt.status[k]
+	}
+	return pins
+}
+
 // Sync verifies that the status of a Cid matches that of
 // the IPFS daemon. If not, it will be transitioned
 // to PinError or UnpinError.
@@ -290,14 +682,24 @@ func (mpt *MapPinTracker) StatusAll() []api.PinInfo {
 // Sync returns the updated local status for the given Cid.
 // Pins in error states can be recovered with Recover().
 // An error is returned if we are unable to contact
-// the IPFS daemon.
+// the IPFS daemon. The status is not changed when the
+// daemon refuses connections, i.e. while it restarts.
 func (mpt *MapPinTracker) Sync(c *cid.Cid) (api.PinInfo, error) {
+	if err := waitForRPC(mpt.rpcReady, mpt.ctx.Done()); err != nil {
+		return mpt.get(c), err
+	}
+
 	var ips api.IPFSPinStatus
 	err := mpt.rpcClient.Call("",
 		"Cluster",
 		"IPFSPinLsCid",
 		api.CidArgCid(c).ToSerial(),
 		&ips)
+	if isIPFSDown(err) {
+		// The daemon is probably restarting. Its pins are checked
+		// again once it is back.
+		return mpt.get(c), err
+	}
 	if err != nil {
 		mpt.setError(c, err)
 		return mpt.get(c), err
@@ -309,18 +711,60 @@ func (mpt *MapPinTracker) Sync(c *cid.Cid) (api.PinInfo, error) {
 // one reported by the IPFS daemon. If not, they will be transitioned
 // to PinError or UnpinError.
 //
+// SyncAll usually lists all the pins in the IPFS daemon. When the
+// items tracked by this peer are a small fraction of them (see
+// Config.SyncAllBatchRatio), their status is requested one by one
+// instead, in batches of SyncAllBatchSize.
+//
 // SyncAll returns the list of local status for all tracked Cids which
 // were updated or have errors. Cids in error states can be recovered
-// with Recover().
+// with Recover(). Statuses are left as they are while the IPFS daemon
+// refuses connections.
 // An error is returned if we are unable to contact the IPFS daemon.
-func (mpt *MapPinTracker) SyncAll() ([]api.PinInfo, error) {
+//
+// SyncAll stops when the given context is cancelled, i.e. because the
+// client which requested it went away, and returns the context's error
+// along with the items updated until then.
+func (mpt *MapPinTracker) SyncAll(ctx context.Context) ([]api.PinInfo, error) {
+	if err := waitForRPC(mpt.rpcReady, mpt.ctx.Done()); err != nil {
+		return nil, err
+	}
+
 	var ipsMap map[string]api.IPFSPinStatus
 	var pInfos []api.PinInfo
-	err := mpt.rpcClient.Call("",
-		"Cluster",
-		"IPFSPinLs",
-		"recursive",
-		&ipsMap)
+	var err error
+
+	status := mpt.StatusAll(ctx)
+	var local []*cid.Cid
+	for _, pinfo := range status {
+		if pinfo.Status != api.TrackerStatusRemote {
+			local = append(local, pinfo.Cid)
+		}
+	}
+
+	if mpt.useBatchSync(len(local)) {
+		logger.Debugf("syncing %d items in batches", len(local))
+		ipsMap, err = mpt.pinLsBatches(ctx, local)
+	} else {
+		err = mpt.rpcClient.CallContext(ctx, "",
+			"Cluster",
+			"IPFSPinLs",
+			"recursive,direct",
+			&ipsMap)
+		if err == nil {
+			mpt.mux.Lock()
+			mpt.ipfsPinCount = len(ipsMap)
+			mpt.mux.Unlock()
+		}
+	}
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if isIPFSDown(err) {
+		logger.Warning("not syncing while the IPFS daemon is down")
+		return nil, err
+	}
 	if err != nil {
 		mpt.mux.Lock()
 		for k := range mpt.status {
@@ -332,8 +776,10 @@ func (mpt *MapPinTracker) SyncAll() ([]api.PinInfo, error) {
 		return pInfos, err
 	}
 
-	status := mpt.StatusAll()
 	for _, pInfoOrig := range status {
+		if ctx.Err() != nil {
+			return pInfos, ctx.Err()
+		}
 		var pInfoNew api.PinInfo
 		c := pInfoOrig.Cid
 		ips, ok := ipsMap[c.String()]
@@ -352,9 +798,83 @@ func (mpt *MapPinTracker) SyncAll() ([]api.PinInfo, error) {
 	return pInfos, nil
 }
 
+// useBatchSync decides whether SyncAll should request the status of
+// the given number of items one by one. This needs to know how many
+// pins the IPFS daemon has, so the first SyncAll always lists them all.
+func (mpt *MapPinTracker) useBatchSync(items int) bool {
+	mpt.mux.RLock()
+	defer mpt.mux.RUnlock()
+	if mpt.syncBatchRatio <= 0 || mpt.ipfsPinCount <= 0 {
+		return false
+	}
+	return float64(items) < mpt.syncBatchRatio*float64(mpt.ipfsPinCount)
+}
+
+// pinLsBatches requests the IPFS status of the given Cids, making up to
+// SyncAllBatchSize requests at the same time. It fails if any of the
+// requests does, or when the context is cancelled.
+func (mpt *MapPinTracker) pinLsBatches(ctx context.Context, cids []*cid.Cid) (map[string]api.IPFSPinStatus, error) {
+	ipsMap := make(map[string]api.IPFSPinStatus)
+	batchSize := SyncAllBatchSize
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+
+	for start := 0; start < len(cids); start += batchSize {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		end := start + batchSize
+		if end > len(cids) {
+			end = len(cids)
+		}
+		batch := cids[start:end]
+
+		statuses := make([]api.IPFSPinStatus, len(batch), len(batch))
+		errs := make([]error, len(batch), len(batch))
+		var wg sync.WaitGroup
+		for i, c := range batch {
+			wg.Add(1)
+			go func(i int, c *cid.Cid) {
+				defer wg.Done()
+				errs[i] = mpt.rpcClient.CallContext(ctx, "",
+					"Cluster",
+					"IPFSPinLsCid",
+					api.CidArgCid(c).ToSerial(),
+					&statuses[i])
+			}(i, c)
+		}
+		wg.Wait()
+
+		for i, c := range batch {
+			if errs[i] != nil {
+				return nil, errs[i]
+			}
+			ipsMap[c.String()] = statuses[i]
+		}
+	}
+	return ipsMap, nil
+}
+
+// isPinStatus returns true for the statuses of items which should be
+// pinned.
+func isPinStatus(s api.TrackerStatus) bool {
+	switch s {
+	case api.TrackerStatusPinned, api.TrackerStatusPinning, api.TrackerStatusPinError:
+		return true
+	}
+	return false
+}
+
 func (mpt *MapPinTracker) syncStatus(c *cid.Cid, ips api.IPFSPinStatus) api.PinInfo {
 	p := mpt.get(c)
-	if ips.IsPinned() {
+	// Items being pinned must be pinned with their type. Any pin
+	// prevents the rest from being unpinned.
+	pinned := ips.IsPinned()
+	if carg, ok := mpt.trackedArg(c); ok && isPinStatus(p.Status) {
+		pinned = ips.IsPinnedAs(carg.Type)
+	}
+	if pinned {
 		switch p.Status {
 		case api.TrackerStatusPinned: // nothing
 		case api.TrackerStatusPinning, api.TrackerStatusPinError:
@@ -375,7 +895,7 @@ func (mpt *MapPinTracker) syncStatus(c *cid.Cid, ips api.IPFSPinStatus) api.PinI
 			mpt.setError(c, errUnpinned)
 		case api.TrackerStatusPinError: // nothing, keep error as it was
 		case api.TrackerStatusPinning:
-			if time.Since(p.TS) > PinningTimeout {
+			if time.Since(p.TS) > mpt.pinTimeout(c) {
 				mpt.setError(c, errPinningTimeout)
 			}
 		case api.TrackerStatusUnpinning, api.TrackerStatusUnpinError:
@@ -397,13 +917,17 @@ func (mpt *MapPinTracker) Recover(c *cid.Cid) (api.PinInfo, error) {
 		p.Status != api.TrackerStatusUnpinError {
 		return p, nil
 	}
+	if err := waitForRPC(mpt.rpcReady, mpt.ctx.Done()); err != nil {
+		return p, err
+	}
 	logger.Infof("Recovering %s", c)
 	var err error
+	carg, _ := mpt.trackedArg(c)
 	switch p.Status {
 	case api.TrackerStatusPinError:
-		err = mpt.pin(api.CidArg{Cid: c})
+		err = mpt.pin(mpt.ctx, carg)
 	case api.TrackerStatusUnpinError:
-		err = mpt.unpin(api.CidArg{Cid: c})
+		err = mpt.unpin(mpt.ctx, carg)
 	}
 	if err != nil {
 		logger.Errorf("error recovering %s: %s", c, err)
@@ -412,8 +936,9 @@ func (mpt *MapPinTracker) Recover(c *cid.Cid) (api.PinInfo, error) {
 }
 
 // SetClient makes the MapPinTracker ready to perform RPC requests to
-// other components.
+// other components. It must be called only once.
 func (mpt *MapPinTracker) SetClient(c *rpc.Client) {
 	mpt.rpcClient = c
-	mpt.rpcReady <- struct{}{}
+	// The workers and the operations requested before wait for it
+	close(mpt.rpcReady)
 }
@@ -0,0 +1,63 @@
+package ipfscluster
+
+import (
+	"github.com/ipfs/ipfs-cluster/api"
+)
+
+// PinEventBufferSize is the number of status changes kept for each
+// subscriber of the MapPinTracker. Further changes are dropped for
+// subscribers which fall this far behind, so that slow subscribers
+// cannot block the tracker.
+var PinEventBufferSize = 100
+
+// Subscribe returns a channel on which the status of a tracked item is
+// sent every time it changes, and a function which cancels the
+// subscription and must be called when done. Items which are no longer
+// tracked are sent with TrackerStatusUnpinned. The channel is closed
+// when the subscription is cancelled or the tracker shuts down.
+func (mpt *MapPinTracker) Subscribe() (<-chan api.PinInfo, func()) {
+	ch := make(chan api.PinInfo, PinEventBufferSize)
+
+	mpt.subsMux.Lock()
+	defer mpt.subsMux.Unlock()
+	if mpt.subs == nil {
+		// shut down already
+		close(ch)
+		return ch, func() {}
+	}
+	mpt.subs[ch] = struct{}{}
+
+	cancel := func() {
+		mpt.subsMux.Lock()
+		defer mpt.subsMux.Unlock()
+		if _, ok := mpt.subs[ch]; ok {
+			delete(mpt.subs, ch)
+			close(ch)
+		}
+	}
+	return ch, cancel
+}
+
+// notify sends a status change to the subscribers which have room
+// for it.
+func (mpt *MapPinTracker) notify(pinfo api.PinInfo) {
+	mpt.subsMux.Lock()
+	defer mpt.subsMux.Unlock()
+	for ch := range mpt.subs {
+		select {
+		case ch <- pinfo:
+		default:
+			logger.Warningf("dropping status change of %s for a slow subscriber", pinfo.Cid)
+		}
+	}
+}
+
+// closeSubscriptions closes the channels of all the subscribers.
+func (mpt *MapPinTracker) closeSubscriptions() {
+	mpt.subsMux.Lock()
+	defer mpt.subsMux.Unlock()
+	for ch := range mpt.subs {
+		close(ch)
+	}
+	mpt.subs = nil
+}
@@ -0,0 +1,100 @@
+package ipfscluster
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ipfs/ipfs-cluster/api"
+)
+
+// TrackerStateFlushInterval specifies how often the MapPinTracker
+// saves the status of the tracked items when Config.PersistTrackerState
+// is set. The status is saved on shutdown too.
+var TrackerStateFlushInterval = 1 * time.Minute
+
+// trackerStateFile is the name of the file, in the consensus data
+// folder, where the MapPinTracker saves the status of the items.
+const trackerStateFile = "pintracker.json"
+
+// loadStatus reads the status saved in the given file. Items which
+// were being pinned or unpinned are left out, as those operations did
+// not survive the restart. A missing file is not an error.
+func (mpt *MapPinTracker) loadStatus(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var pinfos []api.PinInfoSerial
+	err = json.Unmarshal(b, &pinfos)
+	if err != nil {
+		return err
+	}
+
+	mpt.mux.Lock()
+	defer mpt.mux.Unlock()
+	for _, pis := range pinfos {
+		pinfo := pis.ToPinInfo()
+		if pinfo.Cid == nil {
+			continue
+		}
+		switch pinfo.Status {
+		case api.TrackerStatusPinned, api.TrackerStatusPinError,
+			api.TrackerStatusUnpinError, api.TrackerStatusRemote:
+			pinfo.Peer = mpt.peerID
+			mpt.status[pinfo.Cid.String()] = pinfo
+		}
+	}
+	logger.Infof("loaded the status of %d items from %s", len(mpt.status), path)
+	return nil
+}
+
+// saveStatus writes the status of all the items to the given file.
+// The file is replaced atomically so that a crash while writing does
+// not leave a corrupted file behind.
+func (mpt *MapPinTracker) saveStatus(path string) error {
+	pinfos := mpt.StatusAll(context.Background())
+	serial := make([]api.PinInfoSerial, len(pinfos), len(pinfos))
+	for i, pinfo := range pinfos {
+		serial[i] = pinfo.ToSerial()
+	}
+	b, err := json.Marshal(serial)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, b, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// flushStatus saves the status every TrackerStateFlushInterval until
+// the context is cancelled.
+func (mpt *MapPinTracker) flushStatus(ctx context.Context, path string) {
+	ticker := time.NewTicker(TrackerStateFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := mpt.saveStatus(path); err != nil {
+				logger.Errorf("error saving the pin tracker status: %s", err)
+			}
+		}
+	}
+}
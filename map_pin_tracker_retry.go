@@ -0,0 +1,124 @@
+package ipfscluster
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ipfs/ipfs-cluster/api"
+)
+
+// PinRetryBaseDelay is how long the MapPinTracker waits before
+// retrying an operation which failed once, when retries are enabled
+// with Config.PinRetryMaxAttempts. The delay doubles with every
+// further failure, up to Config.PinRetryMaxBackoffSeconds.
+var PinRetryBaseDelay = 10 * time.Second
+
+// PinRetryCheckInterval specifies how often the MapPinTracker looks
+// for failed operations which are due for a retry.
+var PinRetryCheckInterval = 5 * time.Second
+
+// retryWorker retries the failed operations until the tracker is
+// shut down.
+func (mpt *MapPinTracker) retryWorker() {
+	ticker := time.NewTicker(PinRetryCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-mpt.ctx.Done():
+			return
+		case <-ticker.C:
+			mpt.retryFailed()
+		}
+	}
+}
+
+// retryDelay returns how long to wait before retrying an operation
+// which has failed the given number of times.
+func (mpt *MapPinTracker) retryDelay(attempts int) time.Duration {
+	d := PinRetryBaseDelay
+	for i := 1; i < attempts && d < mpt.retryMaxBackoff; i++ {
+		d *= 2
+	}
+	if d > mpt.retryMaxBackoff {
+		d = mpt.retryMaxBackoff
+	}
+	return d
+}
+
+// retryFailed queues again the items in PinError or UnpinError which
+// have attempts left and have waited long enough since they failed.
+// The new status is set here rather than with unsafeSet, which would
+// reset the attempts, but it is notified all the same.
+func (mpt *MapPinTracker) retryFailed() {
+	var pins, unpins []api.CidArg
+
+	mpt.mux.Lock()
+	for k, p := range mpt.status {
+		if p.Attempts >= mpt.retryMax || time.Since(p.TS) < mpt.retryDelay(p.Attempts) {
+			continue
+		}
+		carg, ok := mpt.tracked[k]
+		if !ok {
+			carg = api.CidArgCid(p.Cid)
+		}
+		switch p.Status {
+		case api.TrackerStatusPinError:
+			pins = append(pins, carg)
+			p.Status = api.TrackerStatusPinning
+		case api.TrackerStatusUnpinError:
+			unpins = append(unpins, carg)
+			p.Status = api.TrackerStatusUnpinning
+		default:
+			continue
+		}
+		p.TS = time.Now()
+		p.Error = ""
+		mpt.status[k] = p
+		mpt.notify(p)
+	}
+	mpt.mux.Unlock()
+
+	for _, carg := range pins {
+		logger.Infof("retrying pin of %s", carg.Cid)
+		select {
+		case mpt.pinCh <- trackOp{mpt.ctx, carg}:
+		default:
+			mpt.setError(carg.Cid, errors.New("pin queue is full"))
+		}
+	}
+	for _, carg := range unpins {
+		logger.Infof("retrying unpin of %s", carg.Cid)
+		select {
+		case mpt.unpinCh <- carg:
+		default:
+			mpt.setError(carg.Cid, errors.New("unpin queue is full"))
+		}
+	}
+}
+
+// alertStuck sends an alert to the Cluster about an item which is left
+// in error because it failed as many times as retries are allowed. The
+// alert is sent in the background, as the caller holds the lock.
+func (mpt *MapPinTracker) alertStuck(pinfo api.PinInfo) {
+	a := api.Alert{
+		Peer:     mpt.peerID,
+		Severity: api.AlertError,
+		Code:     api.AlertPinStuck,
+		Message: fmt.Sprintf("%s is stuck in %s past the retry limit: %s",
+			pinfo.Cid, pinfo.Status, pinfo.Error),
+		Time: time.Now(),
+	}
+	logger.Error(a.Message)
+	go func() {
+		if err := waitForRPC(mpt.rpcReady, mpt.ctx.Done()); err != nil {
+			logger.Warningf("cannot send alert: %s", err)
+			return
+		}
+		mpt.rpcClient.Call("",
+			"Cluster",
+			"SendAlert",
+			a.ToSerial(),
+			&struct{}{})
+	}()
+}
@@ -0,0 +1,603 @@
+package ipfscluster
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/test"
+
+	rpc "github.com/hsanjuan/go-libp2p-gorpc"
+	cid "github.com/ipfs/go-cid"
+)
+
+func testMapPinTracker(t *testing.T) *MapPinTracker {
+	cfg := testingConfig()
+	mpt := NewMapPinTracker(cfg)
+	mpt.SetClient(test.NewMockRPCClient(t))
+	return mpt
+}
+
+func TestMapPinTrackerTrackContext(t *testing.T) {
+	mpt := testMapPinTracker(t)
+	defer mpt.Shutdown()
+
+	c, _ := cid.Decode(test.TestCid1)
+	err := mpt.TrackContext(context.Background(), api.CidArg{Cid: c, Everywhere: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if st := mpt.Status(c).Status; st != api.TrackerStatusPinned {
+		t.Fatal("expected pinned status, got ", st)
+	}
+
+	c, _ = cid.Decode(test.TestCid2)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Whether the operation is rejected when queueing or
+	// abandoned by the worker, it should end up in error.
+	mpt.TrackContext(ctx, api.CidArg{Cid: c, Everywhere: true})
+	time.Sleep(100 * time.Millisecond)
+	if st := mpt.Status(c).Status; st != api.TrackerStatusPinError {
+		t.Error("expected pin_error for an expired context, got ", st)
+	}
+}
+
+func TestMapPinTrackerTrackNoFetch(t *testing.T) {
+	mpt := testMapPinTracker(t)
+	defer mpt.Shutdown()
+
+	// TestCid1 is present in the mock IPFS daemon
+	c, _ := cid.Decode(test.TestCid1)
+	err := mpt.Track(api.CidArg{Cid: c, Everywhere: true, NoFetch: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// TestCid3 is missing blocks
+	c3, _ := cid.Decode(test.TestCid3)
+	err = mpt.Track(api.CidArg{Cid: c3, Everywhere: true, NoFetch: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if st := mpt.Status(c).Status; st != api.TrackerStatusPinned {
+		t.Error("expected pinned status, got ", st)
+	}
+	pinfo := mpt.Status(c3)
+	if pinfo.Status != api.TrackerStatusPinError || pinfo.Error != errNotPresent.Error() {
+		t.Error("expected pin_error for content which is not present, got ", pinfo.Status)
+	}
+}
+
+func TestMapPinTrackerNoClient(t *testing.T) {
+	defer func(d time.Duration) { RPCReadyTimeout = d }(RPCReadyTimeout)
+	RPCReadyTimeout = 100 * time.Millisecond
+
+	mpt := NewMapPinTracker(testingConfig())
+	defer mpt.Shutdown()
+
+	c, _ := cid.Decode(test.TestCid1)
+	err := mpt.Track(api.CidArg{Cid: c, Everywhere: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is pinned until the RPC client is set
+	time.Sleep(100 * time.Millisecond)
+	if st := mpt.Status(c).Status; st != api.TrackerStatusPinning {
+		t.Error("expected pinning status, got ", st)
+	}
+
+	_, err = mpt.Sync(c)
+	if err != errRPCNotReady {
+		t.Error("expected errRPCNotReady, got ", err)
+	}
+	_, err = mpt.SyncAll(context.Background())
+	if err != errRPCNotReady {
+		t.Error("expected errRPCNotReady, got ", err)
+	}
+
+	// Operations requested shortly before the client is set wait
+	// for it.
+	RPCReadyTimeout = 5 * time.Second
+	syncErr := make(chan error, 1)
+	go func() {
+		_, err := mpt.Sync(c)
+		syncErr <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	mpt.SetClient(test.NewMockRPCClient(t))
+	if err := <-syncErr; err != nil {
+		t.Error("sync should have waited for the client: ", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if st := mpt.Status(c).Status; st != api.TrackerStatusPinned {
+		t.Error("expected pinned status once the client is set, got ", st)
+	}
+}
+
+func TestMapPinTrackerSyncAllCancelled(t *testing.T) {
+	mpt := testMapPinTracker(t)
+	defer mpt.Shutdown()
+
+	c, _ := cid.Decode(test.TestCid1)
+	mpt.Track(api.CidArg{Cid: c, Everywhere: true})
+	time.Sleep(100 * time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if pinfos := mpt.StatusAll(ctx); pinfos != nil {
+		t.Error("expected no status with a cancelled context")
+	}
+	_, err := mpt.SyncAll(ctx)
+	if err != context.Canceled {
+		t.Error("expected context.Canceled, got ", err)
+	}
+	if st := mpt.Status(c).Status; st != api.TrackerStatusPinned {
+		t.Error("a cancelled SyncAll should not change the status, got ", st)
+	}
+}
+
+func TestMapPinTrackerSyncStatusPinType(t *testing.T) {
+	cfg := testingConfig()
+	mpt := NewMapPinTracker(cfg)
+	defer mpt.Shutdown()
+
+	c1, _ := cid.Decode(test.TestCid1)
+	c2, _ := cid.Decode(test.TestCid2)
+	mpt.setPinning(api.CidArg{Cid: c1})
+	mpt.set(c1, api.TrackerStatusPinned)
+	mpt.setPinning(api.CidArg{Cid: c2, Type: api.PinTypeDirect})
+	mpt.set(c2, api.TrackerStatusPinned)
+
+	if st := mpt.syncStatus(c1, api.IPFSPinStatusDirect).Status; st != api.TrackerStatusPinError {
+		t.Error("a direct pin should not satisfy a recursive one, got ", st)
+	}
+	if st := mpt.syncStatus(c2, api.IPFSPinStatusRecursive).Status; st != api.TrackerStatusPinned {
+		t.Error("a recursive pin should satisfy a direct one, got ", st)
+	}
+}
+
+func TestMapPinTrackerPinQueueFull(t *testing.T) {
+	cfg := testingConfig()
+	cfg.PinQueueSize = 1
+	mpt := NewMapPinTracker(cfg)
+	defer mpt.Shutdown()
+
+	// Without a client, the queue is not processed
+	c1, _ := cid.Decode(test.TestCid1)
+	c2, _ := cid.Decode(test.TestCid2)
+	if err := mpt.Track(api.CidArg{Cid: c1, Everywhere: true}); err != nil {
+		t.Fatal(err)
+	}
+	if load := mpt.Load(); !pinQueueFull(load) {
+		t.Errorf("expected a full queue, got %d/%d", load.QueueLength, load.QueueCapacity)
+	}
+	if err := mpt.Track(api.CidArg{Cid: c2, Everywhere: true}); err != ErrPinQueueFull {
+		t.Fatal("expected ErrPinQueueFull, got ", err)
+	}
+	if code, _ := api.ErrorCode(ErrPinQueueFull); code != 503 {
+		t.Error("expected a 503 code, got ", code)
+	}
+	pinfo := mpt.Status(c2)
+	if pinfo.Status != api.TrackerStatusPinError || pinfo.Error != ErrPinQueueFull.Message {
+		t.Errorf("unexpected status: %s %s", pinfo.Status, pinfo.Error)
+	}
+
+	q := mpt.QueueInfo()
+	if q.PinQueueLength != 1 || q.PinQueueCapacity != 1 ||
+		q.UnpinQueueLength != 0 || q.UnpinQueueCapacity != 1 {
+		t.Errorf("unexpected queue info: %+v", q)
+	}
+}
+
+func TestMapPinTrackerPinTimeout(t *testing.T) {
+	mpt := NewMapPinTracker(testingConfig())
+	defer mpt.Shutdown()
+
+	// Without a client, the items stay in Pinning
+	c1, _ := cid.Decode(test.TestCid1)
+	c2, _ := cid.Decode(test.TestCid2)
+	mpt.Track(api.CidArg{Cid: c1, Everywhere: true, PinTimeout: time.Millisecond})
+	mpt.Track(api.CidArg{Cid: c2, Everywhere: true})
+	time.Sleep(10 * time.Millisecond)
+
+	pinfo := mpt.syncStatus(c1, api.IPFSPinStatusUnpinned)
+	if pinfo.Status != api.TrackerStatusPinError || pinfo.Error != errPinningTimeout.Error() {
+		t.Error("expected a pinning timeout error, got ", pinfo.Status)
+	}
+	pinfo = mpt.syncStatus(c2, api.IPFSPinStatusUnpinned)
+	if pinfo.Status != api.TrackerStatusPinning {
+		t.Error("the global timeout should apply, got ", pinfo.Status)
+	}
+
+	// Untracking forgets the timeout
+	mpt.Untrack(c1)
+	if mpt.pinTimeout(c1) != PinningTimeout {
+		t.Error("the pin timeout should have been removed")
+	}
+}
+
+func TestMapPinTrackerStuckOperations(t *testing.T) {
+	defer func(p, u time.Duration) {
+		PinningTimeout = p
+		UnpinningTimeout = u
+	}(PinningTimeout, UnpinningTimeout)
+	PinningTimeout = 100 * time.Millisecond
+	UnpinningTimeout = 100 * time.Millisecond
+
+	mpt := testMapPinTracker(t)
+	defer mpt.Shutdown()
+
+	// The mock IPFS daemon takes longer than the timeouts for SlowCid
+	c, _ := cid.Decode(test.SlowCid)
+	mpt.Track(api.CidArg{Cid: c, Everywhere: true})
+	time.Sleep(300 * time.Millisecond)
+	pinfo := mpt.Status(c)
+	if pinfo.Status != api.TrackerStatusPinError || pinfo.Error != errPinningTimeout.Error() {
+		t.Error("expected a pinning timeout error, got ", pinfo.Status)
+	}
+
+	mpt.Untrack(c)
+	time.Sleep(300 * time.Millisecond)
+	pinfo = mpt.Status(c)
+	if pinfo.Status != api.TrackerStatusUnpinError || pinfo.Error != errUnpinningTimeout.Error() {
+		t.Error("expected an unpinning timeout error, got ", pinfo.Status)
+	}
+}
+
+func TestMapPinTrackerPinWorkers(t *testing.T) {
+	cfg := testingConfig()
+	cfg.PinWorkers = 2
+	mpt := NewMapPinTracker(cfg)
+	mpt.SetClient(test.NewMockRPCClient(t))
+	defer mpt.Shutdown()
+
+	// A slow pin should not hold the next one
+	slow, _ := cid.Decode(test.SlowCid)
+	c, _ := cid.Decode(test.TestCid1)
+	mpt.Track(api.CidArg{Cid: slow, Everywhere: true})
+	mpt.Track(api.CidArg{Cid: c, Everywhere: true})
+	time.Sleep(200 * time.Millisecond)
+	if st := mpt.Status(slow).Status; st != api.TrackerStatusPinning {
+		t.Error("expected the slow item to be pinning, got ", st)
+	}
+	if st := mpt.Status(c).Status; st != api.TrackerStatusPinned {
+		t.Error("expected the item to be pinned, got ", st)
+	}
+}
+
+func TestMapPinTrackerSetPinProgress(t *testing.T) {
+	mpt := NewMapPinTracker(testingConfig())
+	defer mpt.Shutdown()
+
+	// Without a client, the item stays in Pinning
+	c, _ := cid.Decode(test.TestCid1)
+	mpt.Track(api.CidArg{Cid: c, Everywhere: true})
+	mpt.SetPinProgress(c, 1234)
+	if p := mpt.Status(c).Progress; p != 1234 {
+		t.Error("expected the progress to be recorded, got ", p)
+	}
+
+	mpt.set(c, api.TrackerStatusPinned)
+	if p := mpt.Status(c).Progress; p != 0 {
+		t.Error("expected the progress to be reset, got ", p)
+	}
+	mpt.SetPinProgress(c, 5)
+	if p := mpt.Status(c).Progress; p != 0 {
+		t.Error("progress should only be recorded while pinning, got ", p)
+	}
+}
+
+func TestMapPinTrackerStatusPage(t *testing.T) {
+	mpt := NewMapPinTracker(testingConfig())
+	defer mpt.Shutdown()
+
+	cids := []string{test.TestCid1, test.TestCid2, test.TestCid3}
+	for _, k := range cids {
+		c, _ := cid.Decode(k)
+		mpt.set(c, api.TrackerStatusPinned)
+	}
+	sort.Strings(cids)
+
+	page := mpt.StatusPage("", 2)
+	if len(page) != 2 || page[0].Cid.String() != cids[0] || page[1].Cid.String() != cids[1] {
+		t.Fatalf("unexpected first page: %+v", page)
+	}
+	page = mpt.StatusPage(cids[1], 2)
+	if len(page) != 1 || page[0].Cid.String() != cids[2] {
+		t.Fatalf("unexpected second page: %+v", page)
+	}
+	if page = mpt.StatusPage(cids[2], 2); len(page) != 0 {
+		t.Error("expected an empty page")
+	}
+}
+
+func TestMapPinTrackerSubscribe(t *testing.T) {
+	mpt := NewMapPinTracker(testingConfig())
+	defer mpt.Shutdown()
+	c, _ := cid.Decode(test.TestCid1)
+
+	events, cancel := mpt.Subscribe()
+	mpt.set(c, api.TrackerStatusPinning)
+	mpt.set(c, api.TrackerStatusPinning) // no change
+	mpt.setError(c, errPinningTimeout)
+	mpt.set(c, api.TrackerStatusUnpinned)
+
+	expected := []api.TrackerStatus{
+		api.TrackerStatusPinning,
+		api.TrackerStatusPinError,
+		api.TrackerStatusUnpinned,
+	}
+	for _, st := range expected {
+		pinfo := <-events
+		if !pinfo.Cid.Equals(c) || pinfo.Status != st {
+			t.Errorf("expected %s for %s, got %s", st, c, pinfo.Status)
+		}
+	}
+	if len(events) != 0 {
+		t.Error("expected no more events")
+	}
+
+	cancel()
+	if _, ok := <-events; ok {
+		t.Error("the channel should be closed once cancelled")
+	}
+	cancel() // does nothing
+}
+
+func TestMapPinTrackerSubscribeSlow(t *testing.T) {
+	mpt := NewMapPinTracker(testingConfig())
+	c, _ := cid.Decode(test.TestCid1)
+
+	events, _ := mpt.Subscribe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < PinEventBufferSize+10; i++ {
+			mpt.set(c, api.TrackerStatusPinning)
+			mpt.set(c, api.TrackerStatusPinned)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a subscriber which does not read blocked the tracker")
+	}
+	if len(events) != PinEventBufferSize {
+		t.Errorf("expected a full buffer, got %d events", len(events))
+	}
+
+	mpt.Shutdown()
+	for range events {
+	}
+	if _, cancel := mpt.Subscribe(); cancel == nil {
+		t.Error("expected a cancel function after shutdown")
+	}
+}
+
+func TestMapPinTrackerPersistStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pintracker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := testingConfig()
+	cfg.ConsensusDataFolder = dir
+	cfg.PersistTrackerState = true
+
+	c1, _ := cid.Decode(test.TestCid1)
+	c2, _ := cid.Decode(test.TestCid2)
+	c3, _ := cid.Decode(test.TestCid3)
+
+	// Without a client, the items stay as they are set
+	mpt := NewMapPinTracker(cfg)
+	mpt.Track(api.CidArg{Cid: c1, Everywhere: true})
+	mpt.setError(c1, errPinningTimeout)
+	mpt.Track(api.CidArg{Cid: c2, Everywhere: true})
+	mpt.set(c3, api.TrackerStatusPinned)
+	mpt.Shutdown()
+
+	mpt = NewMapPinTracker(cfg)
+	defer mpt.Shutdown()
+	pinfo := mpt.Status(c1)
+	if pinfo.Status != api.TrackerStatusPinError || pinfo.Error != errPinningTimeout.Error() {
+		t.Error("the error should have been loaded, got ", pinfo.Status)
+	}
+	if st := mpt.Status(c2).Status; st != api.TrackerStatusUnpinned {
+		t.Error("items being pinned should not be loaded, got ", st)
+	}
+	if st := mpt.Status(c3).Status; st != api.TrackerStatusPinned {
+		t.Error("expected pinned status, got ", st)
+	}
+}
+
+func TestMapPinTrackerRetry(t *testing.T) {
+	cfg := testingConfig()
+	cfg.PinRetryMaxAttempts = 2
+	cfg.PinRetryMaxBackoffSeconds = 40
+
+	// Without a client, the retried items wait in the queue
+	mpt := NewMapPinTracker(cfg)
+	defer mpt.Shutdown()
+
+	for attempts, d := range []time.Duration{10, 10, 20, 40, 40} {
+		if got := mpt.retryDelay(attempts); got != d*time.Second {
+			t.Errorf("retry delay after %d attempts: expected %s, got %s",
+				attempts, d*time.Second, got)
+		}
+	}
+
+	baseDelay := PinRetryBaseDelay
+	PinRetryBaseDelay = 0
+	defer func() { PinRetryBaseDelay = baseDelay }()
+
+	c, _ := cid.Decode(test.TestCid1)
+	mpt.Track(api.CidArg{Cid: c, Everywhere: true, NoFetch: true})
+	mpt.setError(c, errPinningTimeout)
+	if p := mpt.Status(c); p.Attempts != 1 {
+		t.Fatal("expected 1 attempt, got ", p.Attempts)
+	}
+
+	events, cancel := mpt.Subscribe()
+	defer cancel()
+	mpt.retryFailed()
+	p := mpt.Status(c)
+	if p.Status != api.TrackerStatusPinning || p.Attempts != 1 {
+		t.Errorf("expected the pin to be retried, got %s after %d attempts", p.Status, p.Attempts)
+	}
+	select {
+	case ev := <-events:
+		if ev.Status != api.TrackerStatusPinning {
+			t.Error("expected an event for the retry, got ", ev.Status)
+		}
+	default:
+		t.Error("the retry should be notified")
+	}
+	if len(mpt.pinCh) != 2 {
+		t.Fatal("expected the pin to be queued again")
+	}
+	<-mpt.pinCh
+	if op := <-mpt.pinCh; !op.carg.NoFetch {
+		t.Error("the retried pin should keep its options")
+	}
+
+	mpt.setError(c, errPinningTimeout)
+	mpt.retryFailed()
+	p = mpt.Status(c)
+	if p.Status != api.TrackerStatusPinError || p.Attempts != 2 {
+		t.Errorf("expected the retries to stop, got %s after %d attempts", p.Status, p.Attempts)
+	}
+	if !strings.Contains(p.Error, "gave up after 2 attempts") {
+		t.Error("the error should say that retries stopped: ", p.Error)
+	}
+
+	// Tracking again starts over
+	mpt.Track(api.CidArg{Cid: c, Everywhere: true, Type: api.PinTypeDirect, Namespace: "ns"})
+	if p := mpt.Status(c); p.Attempts != 0 {
+		t.Error("expected no attempts, got ", p.Attempts)
+	}
+
+	// Unpins, retried or not, keep the options of the pin
+	for len(mpt.pinCh) > 0 {
+		<-mpt.pinCh
+	}
+	mpt.Untrack(c)
+	if op := <-mpt.unpinCh; op.Type != api.PinTypeDirect || op.Namespace != "ns" {
+		t.Error("the unpin should keep the options of the pin")
+	}
+	mpt.setError(c, errUnpinningTimeout)
+	for len(events) > 0 {
+		<-events
+	}
+	mpt.retryFailed()
+	if p := mpt.Status(c); p.Status != api.TrackerStatusUnpinning {
+		t.Error("expected the unpin to be retried, got ", p.Status)
+	}
+	select {
+	case op := <-mpt.unpinCh:
+		if op.Type != api.PinTypeDirect || op.Namespace != "ns" {
+			t.Error("the retried unpin should keep the options of the pin")
+		}
+	default:
+		t.Fatal("expected the unpin to be queued again")
+	}
+	select {
+	case ev := <-events:
+		if ev.Status != api.TrackerStatusUnpinning {
+			t.Error("expected an event for the retry, got ", ev.Status)
+		}
+	default:
+		t.Error("the retry should be notified")
+	}
+}
+
+// alertCatcher receives the alerts sent by the tracker and fails to
+// pin anything.
+type alertCatcher struct {
+	alerts chan api.AlertSerial
+}
+
+func (ac *alertCatcher) IPFSPin(in api.CidArgSerial, out *struct{}) error {
+	return errors.New("cannot pin")
+}
+
+func (ac *alertCatcher) SendAlert(in api.AlertSerial, out *struct{}) error {
+	ac.alerts <- in
+	return nil
+}
+
+func TestMapPinTrackerAlertStuck(t *testing.T) {
+	cfg := testingConfig()
+	cfg.PinRetryMaxAttempts = 2
+	mpt := NewMapPinTracker(cfg)
+	defer mpt.Shutdown()
+
+	ac := &alertCatcher{alerts: make(chan api.AlertSerial, 1)}
+	s := rpc.NewServer(nil, "mock")
+	if err := s.RegisterName("Cluster", ac); err != nil {
+		t.Fatal(err)
+	}
+	mpt.SetClient(rpc.NewClientWithServer(nil, "mock", s))
+
+	c, _ := cid.Decode(test.TestCid1)
+	mpt.set(c, api.TrackerStatusPinning)
+	mpt.setError(c, errPinningTimeout)
+	select {
+	case a := <-ac.alerts:
+		t.Fatalf("unexpected alert with attempts left: %+v", a)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// The retried pin fails again
+	baseDelay := PinRetryBaseDelay
+	PinRetryBaseDelay = 0
+	defer func() { PinRetryBaseDelay = baseDelay }()
+	mpt.retryFailed()
+	select {
+	case a := <-ac.alerts:
+		if a.Code != api.AlertPinStuck || a.Severity != api.AlertError ||
+			!strings.Contains(a.Message, test.TestCid1) {
+			t.Errorf("unexpected alert: %+v", a)
+		}
+	case <-time.After(time.Second):
+		t.Error("expected an alert when retries stop")
+	}
+}
+
+func TestMapPinTrackerUseBatchSync(t *testing.T) {
+	cfg := testingConfig()
+	cfg.SyncAllBatchRatio = 0.1
+	mpt := NewMapPinTracker(cfg)
+	defer mpt.Shutdown()
+
+	// No full listing yet
+	if mpt.useBatchSync(1) {
+		t.Error("the first sync should list all pins")
+	}
+
+	mpt.ipfsPinCount = 1000
+	if !mpt.useBatchSync(50) {
+		t.Error("should use batches when tracking few of the IPFS pins")
+	}
+	if mpt.useBatchSync(500) {
+		t.Error("should list all pins when tracking many of the IPFS pins")
+	}
+
+	mpt.syncBatchRatio = -1
+	if mpt.useBatchSync(1) {
+		t.Error("batches should be disabled")
+	}
+}
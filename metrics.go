@@ -0,0 +1,137 @@
+package ipfscluster
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/ipfs-cluster/api"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// MetricsContentType is the media type of the Prometheus text format.
+const MetricsContentType = "text/plain; version=0.0.4"
+
+// Metrics server settings
+var (
+	MetricsServerReadTimeout  = 5 * time.Second
+	MetricsServerWriteTimeout = 10 * time.Second
+)
+
+// metricsServer exposes operational metrics of a Cluster peer in the
+// Prometheus text format, so that they can be scraped from /metrics.
+type metricsServer struct {
+	cluster  *Cluster
+	listener net.Listener
+	server   *http.Server
+	wg       sync.WaitGroup
+}
+
+// newMetricsServer starts serving the metrics of the given peer on
+// the given address.
+func newMetricsServer(c *Cluster, addr ma.Multiaddr) (*metricsServer, error) {
+	host, err := addr.ValueForProtocol(ma.P_IP4)
+	if err != nil {
+		return nil, err
+	}
+	port, err := addr.ValueForProtocol(ma.P_TCP)
+	if err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return nil, err
+	}
+
+	ms := &metricsServer{
+		cluster:  c,
+		listener: l,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", ms.metricsHandler)
+	ms.server = &http.Server{
+		ReadTimeout:  MetricsServerReadTimeout,
+		WriteTimeout: MetricsServerWriteTimeout,
+		Handler:      mux,
+	}
+
+	ms.wg.Add(1)
+	go func() {
+		defer ms.wg.Done()
+		logger.Infof("metrics: %s", addr)
+		err := ms.server.Serve(l)
+		if err != nil && !strings.Contains(err.Error(), "closed network connection") {
+			logger.Error(err)
+		}
+	}()
+	return ms, nil
+}
+
+// shutdown stops the metrics listener.
+func (ms *metricsServer) shutdown() {
+	ms.server.SetKeepAlivesEnabled(false)
+	ms.listener.Close()
+	ms.wg.Wait()
+}
+
+func (ms *metricsServer) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", MetricsContentType)
+	w.Write(ms.cluster.metrics())
+}
+
+// metrics returns the current metrics of the peer in the Prometheus
+// text format.
+func (c *Cluster) metrics() []byte {
+	var buf bytes.Buffer
+
+	counts := make(map[api.TrackerStatus]int)
+	for _, pinfo := range c.tracker.StatusAll(c.ctx) {
+		counts[pinfo.Status]++
+	}
+	writeMetricHeader(&buf, "ipfscluster_pins", "gauge",
+		"Number of items tracked by this peer, by status.")
+	for st := api.TrackerStatusClusterError; st <= api.TrackerStatusRemote; st++ {
+		fmt.Fprintf(&buf, "ipfscluster_pins{status=%q} %d\n",
+			api.TrackerStatus(st).String(), counts[api.TrackerStatus(st)])
+	}
+
+	load := c.tracker.Load()
+	writeMetricHeader(&buf, "ipfscluster_pin_queue_length", "gauge",
+		"Number of pins waiting to be processed.")
+	fmt.Fprintf(&buf, "ipfscluster_pin_queue_length %d\n", load.QueueLength)
+	writeMetricHeader(&buf, "ipfscluster_pin_queue_capacity", "gauge",
+		"Maximum number of pins waiting to be processed.")
+	fmt.Fprintf(&buf, "ipfscluster_pin_queue_capacity %d\n", load.QueueCapacity)
+	writeMetricHeader(&buf, "ipfscluster_unpin_queue_length", "gauge",
+		"Number of unpins waiting to be processed.")
+	fmt.Fprintf(&buf, "ipfscluster_unpin_queue_length %d\n", load.UnpinQueueLength)
+
+	var attempts, failures uint64
+	var leader int
+	if c.consensus != nil {
+		attempts, failures = c.consensus.CommitStats()
+		if l, err := c.consensus.Leader(); err == nil && l == c.id {
+			leader = 1
+		}
+	}
+	writeMetricHeader(&buf, "ipfscluster_consensus_commit_attempts_total", "counter",
+		"Number of operations this peer has tried to commit as leader.")
+	fmt.Fprintf(&buf, "ipfscluster_consensus_commit_attempts_total %d\n", attempts)
+	writeMetricHeader(&buf, "ipfscluster_consensus_commit_failures_total", "counter",
+		"Number of operations this peer has failed to commit as leader.")
+	fmt.Fprintf(&buf, "ipfscluster_consensus_commit_failures_total %d\n", failures)
+	writeMetricHeader(&buf, "ipfscluster_consensus_leader", "gauge",
+		"Whether this peer is the consensus leader (1) or not (0).")
+	fmt.Fprintf(&buf, "ipfscluster_consensus_leader %d\n", leader)
+
+	return buf.Bytes()
+}
+
+func writeMetricHeader(buf *bytes.Buffer, name, typ, help string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
@@ -0,0 +1,71 @@
+package ipfscluster
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/ipfs-cluster/allocator/numpinalloc"
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/test"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+func TestClusterMetrics(t *testing.T) {
+	cfg := testingConfig()
+	cfg.EnableMetrics = true
+	cl, _, _, _, _ := testingClusterWithAllocator(t, cfg, numpinalloc.NewAllocator())
+	defer cleanRaft()
+	defer cl.Shutdown()
+
+	c, _ := cid.Decode(test.TestCid1)
+	_, err := cl.Pin(api.CidArgCid(c))
+	if err != nil {
+		t.Fatal("pin should have worked:", err)
+	}
+	delay()
+
+	resp, err := http.Get("http://127.0.0.1:10003/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != MetricsContentType {
+		t.Error("unexpected content type:", ct)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"# TYPE ipfscluster_pins gauge\n",
+		"ipfscluster_pins{status=\"pinned\"} 1\n",
+		"ipfscluster_pins{status=\"pin_error\"} 0\n",
+		"ipfscluster_pin_queue_length 0\n",
+		"ipfscluster_unpin_queue_length 0\n",
+		"ipfscluster_consensus_commit_attempts_total 1\n",
+		"ipfscluster_consensus_commit_failures_total 0\n",
+		"ipfscluster_consensus_leader 1\n",
+	}
+	for _, e := range expected {
+		if !strings.Contains(string(body), e) {
+			t.Errorf("expected %q in the metrics:\n%s", e, body)
+		}
+	}
+}
+
+func TestClusterMetricsDisabled(t *testing.T) {
+	cl, _, _, _, _ := testingCluster(t)
+	defer cleanRaft()
+	defer cl.Shutdown()
+
+	if cl.metricsServer != nil {
+		t.Error("the metrics server should not run unless enabled")
+	}
+	if _, err := http.Get("http://127.0.0.1:10003/metrics"); err == nil {
+		t.Error("expected an error when the metrics are disabled")
+	}
+}
@@ -0,0 +1,160 @@
+package ipfscluster
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/ipfs/ipfs-cluster/api"
+
+	rpc "github.com/hsanjuan/go-libp2p-gorpc"
+	cid "github.com/ipfs/go-cid"
+)
+
+var errNullConnector = errors.New("IPFS is not used by this peer (null connector)")
+
+// NullConnector implements the IPFSConnector interface without talking
+// to IPFS at all. Pin() and Unpin() succeed right away, so the Cluster
+// keeps accepting changes to the shared state while the IPFS daemon is
+// offline, i.e. during maintenance, and in tests. The pins are only
+// recorded in memory, so that PinLs() and PinLsCid() agree with them
+// and syncing does not put the items in error. They are lost when the
+// peer stops.
+//
+// The operations which need information from IPFS return an error.
+// It does not provide an IPFS Proxy.
+type NullConnector struct {
+	rpcClient *rpc.Client
+
+	pinsMux sync.RWMutex
+	pins    map[string]api.PinType
+
+	shutdownLock sync.Mutex
+	shutdown     bool
+}
+
+// NewNullConnector creates the component.
+func NewNullConnector(cfg *Config) (*NullConnector, error) {
+	return &NullConnector{
+		pins: make(map[string]api.PinType),
+	}, nil
+}
+
+// SetClient makes the component ready to perform RPC
+// requests.
+func (nc *NullConnector) SetClient(c *rpc.Client) {
+	nc.rpcClient = c
+}
+
+// Shutdown stops the component.
+func (nc *NullConnector) Shutdown() error {
+	nc.shutdownLock.Lock()
+	defer nc.shutdownLock.Unlock()
+
+	if nc.shutdown {
+		logger.Debug("already shutdown")
+		return nil
+	}
+
+	logger.Info("stopping null IPFS connector")
+	nc.shutdown = true
+	return nil
+}
+
+// ID returns an IPFSID containing an error, as there is no IPFS daemon.
+func (nc *NullConnector) ID() (api.IPFSID, error) {
+	return api.IPFSID{
+		Error: errNullConnector.Error(),
+	}, errNullConnector
+}
+
+// Pin records the item as pinned with the given type.
+func (nc *NullConnector) Pin(hash *cid.Cid, pinType api.PinType) error {
+	logger.Debugf("null connector: pinning %s in memory", hash)
+	nc.pinsMux.Lock()
+	defer nc.pinsMux.Unlock()
+	// Like IPFS, a recursive pin is not made direct.
+	if pt, ok := nc.pins[hash.String()]; !ok || pt != api.PinTypeRecursive {
+		nc.pins[hash.String()] = pinType
+	}
+	return nil
+}
+
+// PinPresent works like Pin, as nothing is ever fetched.
+func (nc *NullConnector) PinPresent(hash *cid.Cid, pinType api.PinType) error {
+	return nc.Pin(hash, pinType)
+}
+
+// Unpin forgets the item.
+func (nc *NullConnector) Unpin(hash *cid.Cid) error {
+	logger.Debugf("null connector: unpinning %s in memory", hash)
+	nc.pinsMux.Lock()
+	defer nc.pinsMux.Unlock()
+	delete(nc.pins, hash.String())
+	return nil
+}
+
+// pinStatus returns the IPFSPinStatus for an item pinned with the given
+// type.
+func pinStatus(pt api.PinType) api.IPFSPinStatus {
+	if pt == api.PinTypeDirect {
+		return api.IPFSPinStatusDirect
+	}
+	return api.IPFSPinStatusRecursive
+}
+
+// PinLsCid reports the status of an item as recorded by Pin().
+func (nc *NullConnector) PinLsCid(hash *cid.Cid) (api.IPFSPinStatus, error) {
+	nc.pinsMux.RLock()
+	defer nc.pinsMux.RUnlock()
+	pt, ok := nc.pins[hash.String()]
+	if !ok {
+		return api.IPFSPinStatusUnpinned, nil
+	}
+	return pinStatus(pt), nil
+}
+
+// PinLs returns the items recorded by Pin() whose type is among those
+// in the comma-separated typeFilter (i.e. "recursive,direct").
+func (nc *NullConnector) PinLs(typeFilter string) (map[string]api.IPFSPinStatus, error) {
+	types := make(map[string]bool)
+	for _, t := range strings.Split(typeFilter, ",") {
+		types[t] = true
+	}
+
+	nc.pinsMux.RLock()
+	defer nc.pinsMux.RUnlock()
+	statusMap := make(map[string]api.IPFSPinStatus)
+	for k, pt := range nc.pins {
+		if types[pt.String()] {
+			statusMap[k] = pinStatus(pt)
+		}
+	}
+	return statusMap, nil
+}
+
+// Verify is not supported, as there are no blocks to read.
+func (nc *NullConnector) Verify(hash *cid.Cid) error {
+	return errNullConnector
+}
+
+// Add is not supported, as there is nowhere to store the content.
+func (nc *NullConnector) Add(data io.Reader) (*cid.Cid, error) {
+	return nil, errNullConnector
+}
+
+// Resolve is not supported.
+func (nc *NullConnector) Resolve(path string) (*cid.Cid, error) {
+	return nil, errNullConnector
+}
+
+// RepoStat is not supported.
+func (nc *NullConnector) RepoStat() (api.IPFSRepoStat, error) {
+	return api.IPFSRepoStat{}, errNullConnector
+}
+
+// BandwidthStats is not supported.
+func (nc *NullConnector) BandwidthStats() (api.IPFSBandwidth, error) {
+	return api.IPFSBandwidth{Error: errNullConnector.Error()}, errNullConnector
+}
@@ -0,0 +1,61 @@
+package ipfscluster
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/test"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+func TestNullConnector(t *testing.T) {
+	nc, err := NewNullConnector(&Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc.SetClient(test.NewMockRPCClient(t))
+	defer nc.Shutdown()
+
+	c, _ := cid.Decode(test.TestCid1)
+	c2, _ := cid.Decode(test.TestCid2)
+	if err := nc.Pin(c, api.PinTypeRecursive); err != nil {
+		t.Error("pinning should succeed: ", err)
+	}
+	if err := nc.Pin(c2, api.PinTypeDirect); err != nil {
+		t.Error("pinning should succeed: ", err)
+	}
+
+	st, err := nc.PinLsCid(c)
+	if err != nil || st != api.IPFSPinStatusRecursive {
+		t.Error("expected a recursive status without error")
+	}
+	pins, err := nc.PinLs("recursive,direct")
+	if err != nil || len(pins) != 2 || pins[test.TestCid2] != api.IPFSPinStatusDirect {
+		t.Error("expected both pins without error: ", pins)
+	}
+	pins, err = nc.PinLs("recursive")
+	if err != nil || len(pins) != 1 || pins[test.TestCid1] != api.IPFSPinStatusRecursive {
+		t.Error("expected the recursive pin without error: ", pins)
+	}
+
+	if err := nc.Unpin(c); err != nil {
+		t.Error("unpinning should succeed: ", err)
+	}
+	st, err = nc.PinLsCid(c)
+	if err != nil || st != api.IPFSPinStatusUnpinned {
+		t.Error("expected an unpinned status without error")
+	}
+
+	id, err := nc.ID()
+	if err == nil || id.Error == "" {
+		t.Error("expected an error getting the IPFS ID")
+	}
+	if err := nc.Verify(c); err == nil {
+		t.Error("expected an error verifying")
+	}
+	if _, err := nc.Add(strings.NewReader("hello")); err == nil {
+		t.Error("expected an error adding")
+	}
+}
@@ -0,0 +1,86 @@
+package ipfscluster
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// RPC methods do not receive the context of their callers. Long
+// operations started over RPC (StatusAll, SyncAll, RecoverAll and their
+// local versions) take an operation ID instead, and are cancelled by
+// calling CancelOp with it on the peers running them.
+
+// cancelledOpTTL is how long the ID of an operation which was cancelled
+// before it started is remembered.
+var cancelledOpTTL = time.Minute
+
+// opContexts keeps the cancel functions of the running operations.
+type opContexts struct {
+	mu      sync.Mutex
+	cancels map[string]context.CancelFunc
+	// operations cancelled before they started, with the time
+	cancelled map[string]time.Time
+}
+
+func newOpContexts() *opContexts {
+	return &opContexts{
+		cancels:   make(map[string]context.CancelFunc),
+		cancelled: make(map[string]time.Time),
+	}
+}
+
+// newOpID returns a random operation ID.
+func newOpID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// start returns the context for the operation with the given ID, which
+// is a child of parent, and a function which must be called when the
+// operation is done. An empty ID means that the operation cannot be
+// cancelled.
+func (oc *opContexts) start(parent context.Context, id string) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(parent)
+	if id == "" {
+		return ctx, cancel
+	}
+
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+	if _, ok := oc.cancelled[id]; ok {
+		delete(oc.cancelled, id)
+		cancel()
+		return ctx, cancel
+	}
+	oc.cancels[id] = cancel
+	return ctx, func() {
+		oc.mu.Lock()
+		delete(oc.cancels, id)
+		oc.mu.Unlock()
+		cancel()
+	}
+}
+
+// cancel cancels the operation with the given ID. When it has not
+// started yet, it is cancelled as soon as it does.
+func (oc *opContexts) cancel(id string) {
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+	if cancel, ok := oc.cancels[id]; ok {
+		delete(oc.cancels, id)
+		cancel()
+		return
+	}
+
+	now := time.Now()
+	for k, t := range oc.cancelled {
+		if now.Sub(t) > cancelledOpTTL {
+			delete(oc.cancelled, k)
+		}
+	}
+	oc.cancelled[id] = now
+}
@@ -0,0 +1,86 @@
+package ipfscluster
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/test"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+func TestOpContexts(t *testing.T) {
+	oc := newOpContexts()
+
+	ctx, done := oc.start(context.Background(), "op1")
+	oc.cancel("op1")
+	if ctx.Err() != context.Canceled {
+		t.Error("the operation should have been cancelled")
+	}
+	done()
+	if len(oc.cancels) != 0 {
+		t.Error("finished operations should be forgotten")
+	}
+
+	// Cancelled before it starts
+	oc.cancel("op2")
+	ctx, done = oc.start(context.Background(), "op2")
+	defer done()
+	if ctx.Err() != context.Canceled {
+		t.Error("the operation should start cancelled")
+	}
+	if len(oc.cancelled) != 0 {
+		t.Error("the cancellation should be forgotten once used")
+	}
+
+	ctx, done = oc.start(context.Background(), "")
+	oc.cancel("")
+	if ctx.Err() != nil {
+		t.Error("operations without ID cannot be cancelled")
+	}
+	done()
+}
+
+// cancellingTracker cancels the context of RecoverAllLocal on the first
+// Recover.
+type cancellingTracker struct {
+	*MapPinTracker
+	cancel    func()
+	recovered int
+}
+
+func (ct *cancellingTracker) Recover(c *cid.Cid) (api.PinInfo, error) {
+	ct.recovered++
+	ct.cancel()
+	return ct.MapPinTracker.Recover(c)
+}
+
+func TestRecoverAllLocalCancelled(t *testing.T) {
+	cfg := testingConfig()
+	cfg.RecoverAllConcurrency = 1
+	mpt := NewMapPinTracker(cfg)
+	defer mpt.Shutdown()
+	mpt.SetClient(test.NewMockRPCClient(t))
+
+	for _, h := range []string{test.TestCid1, test.TestCid2, test.TestCid3} {
+		c, _ := cid.Decode(h)
+		mpt.set(c, api.TrackerStatusPinned)
+		mpt.setError(c, errors.New("an error"))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ct := &cancellingTracker{MapPinTracker: mpt, cancel: cancel}
+	c := &Cluster{config: cfg, tracker: ct}
+
+	pinfos, err := c.RecoverAllLocal(ctx)
+	if err != context.Canceled {
+		t.Error("expected context.Canceled, got ", err)
+	}
+	if ct.recovered != 1 || len(pinfos) != 1 {
+		t.Errorf("only the first item should be recovered: %d recovered, %d returned",
+			ct.recovered, len(pinfos))
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/ipfs/ipfs-cluster/api"
+
 	peer "github.com/libp2p/go-libp2p-peer"
 	peerstore "github.com/libp2p/go-libp2p-peerstore"
 	ma "github.com/multiformats/go-multiaddr"
@@ -37,7 +39,8 @@ func (pm *peerManager) addPeer(addr ma.Multiaddr) error {
 	}
 	pm.ps.AddAddr(pid, decapAddr, peerstore.PermanentAddrTTL)
 
-	if !pm.isPeer(pid) {
+	isNew := !pm.isPeer(pid)
+	if isNew {
 		logger.Infof("new Cluster peer %s", addr.String())
 	}
 
@@ -45,13 +48,19 @@ func (pm *peerManager) addPeer(addr ma.Multiaddr) error {
 	pm.peermap[pid] = addr
 	pm.m.Unlock()
 
+	if isNew {
+		pm.cluster.alert(pid, api.AlertInfo, api.AlertPeerAdded,
+			"new Cluster peer %s", addr)
+	}
+
 	return nil
 }
 
 func (pm *peerManager) rmPeer(pid peer.ID, selfShutdown bool) error {
 	logger.Debugf("removing peer %s", pid.Pretty())
 
-	if pm.isPeer(pid) {
+	wasPeer := pm.isPeer(pid)
+	if wasPeer {
 		logger.Infof("removing Cluster peer %s", pid.Pretty())
 	}
 
@@ -59,6 +68,11 @@ func (pm *peerManager) rmPeer(pid peer.ID, selfShutdown bool) error {
 	delete(pm.peermap, pid)
 	pm.m.Unlock()
 
+	if wasPeer {
+		pm.cluster.alert(pid, api.AlertWarning, api.AlertPeerRemoved,
+			"removed Cluster peer %s", pid.Pretty())
+	}
+
 	// It's ourselves. This is not very graceful
 	if pid == pm.self && selfShutdown {
 		logger.Warning("this peer has been removed from the Cluster and will shutdown itself in 5 seconds")
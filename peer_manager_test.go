@@ -6,9 +6,11 @@ import (
 	"testing"
 	"time"
 
+	"github.com/ipfs/ipfs-cluster/api"
 	"github.com/ipfs/ipfs-cluster/test"
 
 	cid "github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-peer"
 	ma "github.com/multiformats/go-multiaddr"
 )
 
@@ -56,7 +58,7 @@ func TestClustersPeerAdd(t *testing.T) {
 	}
 
 	h, _ := cid.Decode(test.TestCid1)
-	err := clusters[1].Pin(h)
+	_, err := clusters[1].Pin(api.CidArgCid(h))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -188,6 +190,45 @@ func TestClustersPeerRemove(t *testing.T) {
 	runF(t, clusters, f)
 }
 
+func TestClustersPeerAlerts(t *testing.T) {
+	clusters, mocks := peerManagerClusters(t)
+	defer shutdownClusters(t, clusters, mocks)
+
+	if len(clusters) < 2 {
+		t.Skip("need at least 2 nodes for this test")
+	}
+
+	alerts, cancel := clusters[0].SubscribeAlerts()
+	defer cancel()
+
+	// waitAlert skips other alerts, like leader changes
+	waitAlert := func(code string, p peer.ID) {
+		timeout := time.After(10 * time.Second)
+		for {
+			select {
+			case a := <-alerts:
+				if a.Code == code && a.Peer == p {
+					return
+				}
+			case <-timeout:
+				t.Fatal("no alert received:", code)
+			}
+		}
+	}
+
+	id, err := clusters[0].PeerAdd(clusterAddr(clusters[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitAlert(api.AlertPeerAdded, id.ID)
+
+	err = clusters[0].PeerRemove(id.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitAlert(api.AlertPeerRemoved, id.ID)
+}
+
 func TestClusterPeerRemoveSelf(t *testing.T) {
 	clusters, mocks := createClusters(t)
 	defer shutdownClusters(t, clusters, mocks)
@@ -205,6 +246,49 @@ func TestClusterPeerRemoveSelf(t *testing.T) {
 	}
 }
 
+func TestClustersLeave(t *testing.T) {
+	clusters, mocks := createClusters(t)
+	defer shutdownClusters(t, clusters, mocks)
+
+	if len(clusters) < 2 {
+		t.Skip("test needs at least 2 clusters")
+	}
+
+	// The leader leaving is the hardest case
+	var leaving *Cluster
+	leader, err := clusters[0].consensus.Leader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range clusters {
+		if c.id == leader {
+			leaving = c
+		}
+	}
+
+	err = leaving.Leave()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, more := <-leaving.Done()
+	if more {
+		t.Error("should be done")
+	}
+
+	for _, c := range clusters {
+		if c == leaving {
+			continue
+		}
+		if len(c.Peers()) != nClusters-1 {
+			t.Error("should have removed 1 peer")
+		}
+		l, err := c.consensus.Leader()
+		if err != nil || l == leader {
+			t.Error("expected a new leader")
+		}
+	}
+}
+
 func TestClustersPeerJoin(t *testing.T) {
 	clusters, mocks := peerManagerClusters(t)
 	defer shutdownClusters(t, clusters, mocks)
@@ -220,7 +304,7 @@ func TestClustersPeerJoin(t *testing.T) {
 		}
 	}
 	hash, _ := cid.Decode(test.TestCid1)
-	clusters[0].Pin(hash)
+	clusters[0].Pin(api.CidArgCid(hash))
 	delay()
 
 	f := func(t *testing.T, c *Cluster) {
@@ -253,7 +337,7 @@ func TestClustersPeerJoinAllAtOnce(t *testing.T) {
 	runF(t, clusters[1:], f)
 
 	hash, _ := cid.Decode(test.TestCid1)
-	clusters[0].Pin(hash)
+	clusters[0].Pin(api.CidArgCid(hash))
 	delay()
 
 	f2 := func(t *testing.T, c *Cluster) {
@@ -295,7 +379,7 @@ func TestClustersPeerJoinAllAtOnceWithRandomBootstrap(t *testing.T) {
 	runF(t, clusters[2:], f)
 
 	hash, _ := cid.Decode(test.TestCid1)
-	clusters[0].Pin(hash)
+	clusters[0].Pin(api.CidArgCid(hash))
 	delay()
 
 	f2 := func(t *testing.T, c *Cluster) {
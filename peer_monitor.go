@@ -213,6 +213,24 @@ func (mon *StdPeerMonitor) LastMetrics(name string) []api.Metric {
 	return metrics
 }
 
+// ExpiredPeers returns the peers whose last metric of the given type
+// has expired, which means that they have stopped reporting and are
+// likely down. Peers which have not reported any metric are not
+// included.
+func (mon *StdPeerMonitor) ExpiredPeers(name string) []peer.ID {
+	mon.metricsMux.RLock()
+	defer mon.metricsMux.RUnlock()
+
+	var expired []peer.ID
+	for p, peerMetrics := range mon.metrics[name] {
+		last, err := peerMetrics.latest()
+		if err == nil && last.Expired() {
+			expired = append(expired, p)
+		}
+	}
+	return expired
+}
+
 // Alerts returns a channel on which alerts are sent when the
 // monitor detects a failure.
 func (mon *StdPeerMonitor) Alerts() <-chan api.Alert {
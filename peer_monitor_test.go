@@ -98,3 +98,30 @@ func TestPeerMonitorLogMetric(t *testing.T) {
 		t.Error("metric is not last")
 	}
 }
+
+func TestPeerMonitorExpiredPeers(t *testing.T) {
+	pm := testPeerMonitor(t)
+	defer pm.Shutdown()
+
+	pm.LogMetric(newMetric("test", test.TestPeerID1))
+	expired := newMetric("test", test.TestPeerID2)
+	expired.SetTTL(-5)
+	pm.LogMetric(expired)
+	invalid := newMetric("test", test.TestPeerID3)
+	invalid.Valid = false
+	pm.LogMetric(invalid)
+
+	peers := pm.ExpiredPeers("test")
+	if len(peers) != 1 || peers[0] != test.TestPeerID2 {
+		t.Errorf("expected only %s to have expired: %s", test.TestPeerID2, peers)
+	}
+	if len(pm.ExpiredPeers("testbad")) != 0 {
+		t.Error("no peers should have expired metrics of another type")
+	}
+
+	// the peer is back
+	pm.LogMetric(newMetric("test", test.TestPeerID2))
+	if len(pm.ExpiredPeers("test")) != 0 {
+		t.Error("no metrics should have expired")
+	}
+}
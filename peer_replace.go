@@ -0,0 +1,315 @@
+package ipfscluster
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/ipfs/ipfs-cluster/api"
+
+	cid "github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// PeerReplaceTimeout is the maximum time that a peer replacement waits
+// for the new peer to pin the content allocated to the old one.
+var PeerReplaceTimeout = 1 * time.Hour
+
+// PeerReplaceCheckInterval is how often the progress of the new peer
+// is checked during a peer replacement.
+var PeerReplaceCheckInterval = 5 * time.Second
+
+var errReplaceInProgress = errors.New("a peer replacement is already in progress")
+
+// peerReplacement holds the progress of the last peer replacement
+// started by this peer.
+type peerReplacement struct {
+	mux      sync.Mutex
+	progress api.PeerReplacement
+}
+
+func (pr *peerReplacement) get() api.PeerReplacement {
+	pr.mux.Lock()
+	defer pr.mux.Unlock()
+	return pr.progress
+}
+
+func (pr *peerReplacement) update(f func(*api.PeerReplacement)) {
+	pr.mux.Lock()
+	defer pr.mux.Unlock()
+	f(&pr.progress)
+}
+
+func (pr *peerReplacement) fail(err error) {
+	logger.Errorf("peer replacement failed: %s", err)
+	pr.update(func(p *api.PeerReplacement) {
+		p.Phase = api.PeerReplacementFailed
+		p.Error = err.Error()
+		p.Finished = time.Now()
+	})
+}
+
+// ReplacePeer replaces a cluster peer with a new one, which takes over
+// all its pins. This is useful when the identity of a peer must change,
+// for example, because its key has been compromised.
+//
+// The new peer is added to the cluster and allocated all the pins of
+// the old peer, which keeps its allocations until the new peer has
+// pinned them. Items unpinned in the meantime are not waited for. Once
+// every item is pinned or has failed to pin on the new peer, the old
+// peer is deallocated from the pinned ones which have not changed
+// since, and removed from the cluster, which re-allocates the rest.
+// If the new peer does not finish in PeerReplaceTimeout, the
+// replacement stops and the old peer is left in place.
+//
+// The replacement runs in the background. ReplacePeer returns once it
+// has started and its progress can be followed with PeerReplacement().
+func (c *Cluster) ReplacePeer(oldAddr, newAddr ma.Multiaddr) (api.PeerReplacement, error) {
+	oldPid, _, err := multiaddrSplit(oldAddr)
+	if err != nil {
+		return api.PeerReplacement{}, err
+	}
+	newPid, _, err := multiaddrSplit(newAddr)
+	if err != nil {
+		return api.PeerReplacement{}, err
+	}
+
+	switch {
+	case oldPid == newPid:
+		return api.PeerReplacement{}, errors.New("the old and new peers are the same")
+	case oldPid == c.id:
+		return api.PeerReplacement{}, errors.New("a peer cannot replace itself: run the replacement from a different peer")
+	case !c.peerManager.isPeer(oldPid):
+		return api.PeerReplacement{}, fmt.Errorf("%s is not a peer", oldPid.Pretty())
+	}
+
+	var progress api.PeerReplacement
+	c.replacement.mux.Lock()
+	switch c.replacement.progress.Phase {
+	case "", api.PeerReplacementDone, api.PeerReplacementFailed:
+	default:
+		c.replacement.mux.Unlock()
+		return api.PeerReplacement{}, errReplaceInProgress
+	}
+	c.replacement.progress = api.PeerReplacement{
+		OldPeer: oldPid,
+		NewPeer: newPid,
+		Phase:   api.PeerReplacementAdding,
+		Started: time.Now(),
+	}
+	progress = c.replacement.progress
+	c.replacement.mux.Unlock()
+
+	logger.Infof("replacing peer %s with %s", oldPid.Pretty(), newPid.Pretty())
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		c.replacePeer(oldPid, newPid, newAddr)
+	}()
+	return progress, nil
+}
+
+// PeerReplacement returns the progress of the last peer replacement
+// started on this peer.
+func (c *Cluster) PeerReplacement() api.PeerReplacement {
+	return c.replacement.get()
+}
+
+func (c *Cluster) replacePeer(oldPid, newPid peer.ID, newAddr ma.Multiaddr) {
+	if !c.peerManager.isPeer(newPid) {
+		_, err := c.PeerAdd(newAddr)
+		if err != nil {
+			c.replacement.fail(fmt.Errorf("adding %s: %s", newPid.Pretty(), err))
+			return
+		}
+	}
+
+	cState, err := c.consensus.State()
+	if err != nil {
+		c.replacement.fail(err)
+		return
+	}
+
+	// Allocate the new peer alongside the old one, so that the
+	// content is not released before the new peer holds it.
+	migrated := make(map[string]api.CidArg)
+	pending := make(map[string]bool)
+	for _, carg := range cState.ListByPeer(oldPid) {
+		k := carg.Cid.String()
+		pending[k] = true
+		if carg.Everywhere {
+			continue
+		}
+		if !peerIn(carg.Allocations, newPid) {
+			carg.Allocations = append(carg.Allocations, newPid)
+			_, err := c.consensus.LogPin(carg)
+			if err != nil {
+				c.replacement.fail(fmt.Errorf("allocating %s to %s: %s",
+					carg.Cid, newPid.Pretty(), err))
+				return
+			}
+		}
+		migrated[k] = carg
+	}
+
+	c.replacement.update(func(p *api.PeerReplacement) {
+		p.Phase = api.PeerReplacementPinning
+		p.Total = len(pending)
+	})
+
+	done, failed, err := c.waitPinnedOn(newPid, pending)
+	if err != nil {
+		c.replacement.fail(err)
+		return
+	}
+	for k, e := range failed {
+		logger.Warningf("%s could not pin %s and it will be re-allocated: %s",
+			newPid.Pretty(), k, e)
+	}
+
+	c.replacement.update(func(p *api.PeerReplacement) {
+		p.Phase = api.PeerReplacementRemoving
+	})
+
+	// The state is read again, as it may have changed while waiting.
+	// Items which have changed are left to the re-allocation
+	// when removing the old peer.
+	for k, carg := range migrated {
+		if !done[k] {
+			continue
+		}
+		cState, err = c.consensus.State()
+		if err != nil {
+			c.replacement.fail(err)
+			return
+		}
+		if !cState.Has(carg.Cid) {
+			continue
+		}
+		current := cState.Get(carg.Cid)
+		if !reflect.DeepEqual(current.ToSerial(), carg.ToSerial()) {
+			logger.Infof("%s has changed during the replacement. Not deallocating it from %s",
+				k, oldPid.Pretty())
+			continue
+		}
+		current.Allocations = withoutPeer(current.Allocations, oldPid)
+		_, err := c.consensus.LogPin(current)
+		if err != nil {
+			c.replacement.fail(fmt.Errorf("deallocating %s from %s: %s",
+				carg.Cid, oldPid.Pretty(), err))
+			return
+		}
+	}
+
+	if c.peerManager.isPeer(oldPid) {
+		err = c.PeerRemove(oldPid)
+		if err != nil {
+			c.replacement.fail(fmt.Errorf("removing %s: %s", oldPid.Pretty(), err))
+			return
+		}
+	}
+
+	logger.Infof("peer %s replaced by %s", oldPid.Pretty(), newPid.Pretty())
+	c.replacement.update(func(p *api.PeerReplacement) {
+		p.Phase = api.PeerReplacementDone
+		p.Finished = time.Now()
+	})
+}
+
+// waitPinnedOn waits until the given peer has pinned or failed to pin
+// each of the pending Cids, updating the progress of the replacement as
+// it goes. Cids which are no longer in the shared state are done. It
+// returns the Cids which are done and the errors of those which failed.
+func (c *Cluster) waitPinnedOn(pid peer.ID, pending map[string]bool) (map[string]bool, map[string]string, error) {
+	timeout := time.NewTimer(PeerReplaceTimeout)
+	defer timeout.Stop()
+	ticker := time.NewTicker(PeerReplaceCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		var pinfos []api.PinInfoSerial
+		err := c.rpcClient.Call(pid,
+			"Cluster",
+			"TrackerStatusAll",
+			struct{}{},
+			&pinfos)
+		if err != nil {
+			logger.Warningf("checking replacement progress on %s: %s", pid.Pretty(), err)
+		}
+
+		removed := make(map[string]bool)
+		if cState, err := c.consensus.State(); err == nil {
+			for k := range pending {
+				h, _ := cid.Decode(k)
+				if !cState.Has(h) {
+					removed[k] = true
+				}
+			}
+		}
+
+		done, failed := replaceProgress(pinfos, pending, removed)
+		c.replacement.update(func(p *api.PeerReplacement) {
+			p.Pinned = len(done)
+			p.Failed = len(failed)
+		})
+		if len(done)+len(failed) == len(pending) {
+			return done, failed, nil
+		}
+
+		select {
+		case <-c.ctx.Done():
+			return nil, nil, errors.New("cluster is shutting down")
+		case <-timeout.C:
+			return nil, nil, fmt.Errorf("%s did not pin all the content in %s", pid.Pretty(), PeerReplaceTimeout)
+		case <-ticker.C:
+		}
+	}
+}
+
+// replaceProgress sorts the wanted Cids according to the given status:
+// those which are done, because they are pinned or have been removed
+// from the shared state, and those which failed to pin, along with
+// their errors. The rest are still in progress.
+func replaceProgress(pinfos []api.PinInfoSerial, wanted, removed map[string]bool) (map[string]bool, map[string]string) {
+	done := make(map[string]bool)
+	failed := make(map[string]string)
+	for k := range removed {
+		if wanted[k] {
+			done[k] = true
+		}
+	}
+	for _, pinfo := range pinfos {
+		if !wanted[pinfo.Cid] || done[pinfo.Cid] {
+			continue
+		}
+		switch api.TrackerStatusFromString(pinfo.Status) {
+		case api.TrackerStatusPinned:
+			done[pinfo.Cid] = true
+		case api.TrackerStatusPinError:
+			failed[pinfo.Cid] = pinfo.Error
+		}
+	}
+	return done, failed
+}
+
+func peerIn(peers []peer.ID, pid peer.ID) bool {
+	for _, p := range peers {
+		if p == pid {
+			return true
+		}
+	}
+	return false
+}
+
+func withoutPeer(peers []peer.ID, pid peer.ID) []peer.ID {
+	res := make([]peer.ID, 0, len(peers))
+	for _, p := range peers {
+		if p != pid {
+			res = append(res, p)
+		}
+	}
+	return res
+}
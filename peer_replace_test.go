@@ -0,0 +1,56 @@
+package ipfscluster
+
+import (
+	"testing"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/test"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+func TestReplaceProgress(t *testing.T) {
+	wanted := map[string]bool{
+		test.TestCid1: true,
+		test.TestCid2: true,
+		test.TestCid3: true,
+	}
+	removed := map[string]bool{}
+	pinfos := []api.PinInfoSerial{
+		{Cid: test.TestCid1, Status: api.TrackerStatus(api.TrackerStatusPinned).String()},
+		{Cid: test.TestCid2, Status: api.TrackerStatus(api.TrackerStatusPinning).String()},
+		{Cid: test.ErrorCid, Status: api.TrackerStatus(api.TrackerStatusPinned).String()},
+	}
+
+	done, failed := replaceProgress(pinfos, wanted, removed)
+	if len(done) != 1 || !done[test.TestCid1] || len(failed) != 0 {
+		t.Errorf("unexpected progress: %v %v", done, failed)
+	}
+
+	// An error does not stop the rest
+	pinfos[1].Status = api.TrackerStatus(api.TrackerStatusPinError).String()
+	pinfos[1].Error = "pin error"
+	done, failed = replaceProgress(pinfos, wanted, removed)
+	if len(done) != 1 || failed[test.TestCid2] != "pin error" {
+		t.Errorf("unexpected progress: %v %v", done, failed)
+	}
+
+	// Unpinned items are done, whatever their status
+	removed[test.TestCid2] = true
+	removed[test.TestCid3] = true
+	done, failed = replaceProgress(pinfos, wanted, removed)
+	if len(done) != 3 || len(failed) != 0 {
+		t.Errorf("unexpected progress: %v %v", done, failed)
+	}
+}
+
+func TestWithoutPeer(t *testing.T) {
+	peers := []peer.ID{test.TestPeerID1, test.TestPeerID2, test.TestPeerID3}
+	res := withoutPeer(peers, test.TestPeerID2)
+	if len(res) != 2 || peerIn(res, test.TestPeerID2) {
+		t.Error("expected the peer to be removed:", res)
+	}
+	if len(peers) != 3 {
+		t.Error("the original allocations should not be modified")
+	}
+}
@@ -0,0 +1,399 @@
+package ipfscluster
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/ipfs-cluster/api"
+
+	rpc "github.com/hsanjuan/go-libp2p-gorpc"
+	cid "github.com/ipfs/go-cid"
+)
+
+// Pinning service connector settings
+var (
+	// maximum duration of a single request to the pinning service
+	PinningServiceRequestTimeout = 30 * time.Second
+	// how often Pin() checks the status of a pin request
+	PinningServicePollInterval = 2 * time.Second
+	// how long Pin() waits for the pinning service to pin an item
+	PinningServicePinTimeout = 1 * time.Hour
+)
+
+// Pin states defined by the IPFS Pinning Service API.
+const (
+	pinningServiceQueued  = "queued"
+	pinningServicePinning = "pinning"
+	pinningServicePinned  = "pinned"
+	pinningServiceFailed  = "failed"
+)
+
+// maximum number of results per page allowed by the Pinning Service API
+const pinningServicePageLimit = 1000
+
+var errNoPinningServiceID = errors.New("ID is not available when using a pinning service")
+
+// PinningServiceConnector implements the IPFSConnector interface by
+// delegating pins to a remote service implementing the IPFS Pinning
+// Service API, rather than to a local IPFS daemon. It does not provide
+// an IPFS Proxy.
+//
+// The pin states used by the service are mapped as follows:
+//
+//   - "pinned" items are reported as IPFSPinStatusRecursive.
+//   - "queued", "pinning" and "failed" items, as well as items not
+//     known to the service, are reported as IPFSPinStatusUnpinned.
+//
+// Pin() only returns once the service reports the item as "pinned",
+// and returns an error when it reports it as "failed". Unpin() removes
+// all the pin requests for the item.
+type PinningServiceConnector struct {
+	endpoint string
+	token    string
+	client   *http.Client
+
+	rpcClient *rpc.Client
+
+	shutdownLock sync.Mutex
+	shutdown     bool
+	shutdownCh   chan struct{}
+}
+
+type pinningServicePin struct {
+	Cid  string `json:"cid"`
+	Name string `json:"name,omitempty"`
+}
+
+type pinningServicePinStatus struct {
+	RequestID string            `json:"requestid"`
+	Status    string            `json:"status"`
+	Created   time.Time         `json:"created"`
+	Pin       pinningServicePin `json:"pin"`
+}
+
+type pinningServicePinResults struct {
+	Count   int                       `json:"count"`
+	Results []pinningServicePinStatus `json:"results"`
+}
+
+type pinningServiceError struct {
+	Error struct {
+		Reason  string `json:"reason"`
+		Details string `json:"details"`
+	} `json:"error"`
+}
+
+// NewPinningServiceConnector creates the component using the
+// PinningServiceEndpoint and PinningServiceToken from the configuration.
+func NewPinningServiceConnector(cfg *Config) (*PinningServiceConnector, error) {
+	if cfg.PinningServiceEndpoint == "" {
+		return nil, errors.New("no pinning service endpoint configured")
+	}
+
+	return &PinningServiceConnector{
+		endpoint: strings.TrimSuffix(cfg.PinningServiceEndpoint, "/"),
+		token:    cfg.PinningServiceToken,
+		client: &http.Client{
+			Timeout: PinningServiceRequestTimeout,
+		},
+		shutdownCh: make(chan struct{}),
+	}, nil
+}
+
+// SetClient makes the component ready to perform RPC
+// requests.
+func (psc *PinningServiceConnector) SetClient(c *rpc.Client) {
+	psc.rpcClient = c
+}
+
+// Shutdown stops the component. Ongoing Pin() calls return
+// with an error.
+func (psc *PinningServiceConnector) Shutdown() error {
+	psc.shutdownLock.Lock()
+	defer psc.shutdownLock.Unlock()
+
+	if psc.shutdown {
+		logger.Debug("already shutdown")
+		return nil
+	}
+
+	logger.Info("stopping Pinning Service connector")
+	close(psc.shutdownCh)
+	psc.shutdown = true
+	return nil
+}
+
+// ID returns an IPFSID containing an error, as pinning services do
+// not expose the identity of the IPFS nodes holding the content.
+func (psc *PinningServiceConnector) ID() (api.IPFSID, error) {
+	return api.IPFSID{
+		Error: errNoPinningServiceID.Error(),
+	}, errNoPinningServiceID
+}
+
+// Pin requests the pinning service to pin an item and waits until it
+// is pinned, the service reports a failure, or PinningServicePinTimeout
+// expires. Existing requests for the item are re-used. Pinning services
+// only pin recursively, so direct pins are rejected.
+func (psc *PinningServiceConnector) Pin(hash *cid.Cid, pinType api.PinType) error {
+	if pinType != api.PinTypeRecursive {
+		return fmt.Errorf("%s pins are not supported when using a pinning service", pinType)
+	}
+	pins, err := psc.pinRequests(hash)
+	if err != nil {
+		return err
+	}
+
+	var req *pinningServicePinStatus
+	for i, p := range pins {
+		switch p.Status {
+		case pinningServicePinned:
+			logger.Debug("object is already pinned in the pinning service: ", hash)
+			return nil
+		case pinningServiceQueued, pinningServicePinning:
+			req = &pins[i]
+		}
+	}
+
+	if req == nil {
+		var st pinningServicePinStatus
+		err = psc.do("POST", "/pins", nil,
+			pinningServicePin{Cid: hash.String()}, &st)
+		if err != nil {
+			return err
+		}
+		req = &st
+	}
+
+	timeout := time.NewTimer(PinningServicePinTimeout)
+	defer timeout.Stop()
+	ticker := time.NewTicker(PinningServicePollInterval)
+	defer ticker.Stop()
+
+	for {
+		switch req.Status {
+		case pinningServicePinned:
+			logger.Info("Pinning Service pin request succeeded: ", hash)
+			return nil
+		case pinningServiceFailed:
+			return fmt.Errorf("pinning service failed to pin %s", hash)
+		}
+
+		select {
+		case <-psc.shutdownCh:
+			return errors.New("pinning service connector is shutting down")
+		case <-timeout.C:
+			return errPinningTimeout
+		case <-ticker.C:
+		}
+
+		var st pinningServicePinStatus
+		err = psc.do("GET", "/pins/"+req.RequestID, nil, nil, &st)
+		if err != nil {
+			return err
+		}
+		req = &st
+	}
+}
+
+// Unpin removes all the pin requests for the given item
+// from the pinning service.
+func (psc *PinningServiceConnector) Unpin(hash *cid.Cid) error {
+	pins, err := psc.pinRequests(hash)
+	if err != nil {
+		return err
+	}
+
+	if len(pins) == 0 {
+		logger.Debug("object is already unpinned in the pinning service: ", hash)
+		return nil
+	}
+
+	for _, p := range pins {
+		err = psc.do("DELETE", "/pins/"+p.RequestID, nil, nil, nil)
+		if err != nil {
+			return err
+		}
+	}
+	logger.Info("Pinning Service unpin request succeeded: ", hash)
+	return nil
+}
+
+// PinLsCid returns the status of the given item in the pinning service.
+func (psc *PinningServiceConnector) PinLsCid(hash *cid.Cid) (api.IPFSPinStatus, error) {
+	pins, err := psc.pinRequests(hash)
+	if err != nil {
+		return api.IPFSPinStatusError, err
+	}
+	for _, p := range pins {
+		if p.Status == pinningServicePinned {
+			return api.IPFSPinStatusRecursive, nil
+		}
+	}
+	return api.IPFSPinStatusUnpinned, nil
+}
+
+// PinLs returns all the items pinned in the pinning service. All pins
+// in a pinning service are recursive, so no items are returned when
+// asking for other types.
+func (psc *PinningServiceConnector) PinLs(typeFilter string) (map[string]api.IPFSPinStatus, error) {
+	statusMap := make(map[string]api.IPFSPinStatus)
+	listed := false
+	for _, t := range strings.Split(typeFilter, ",") {
+		switch t {
+		case "", "all", "recursive":
+			listed = true
+		}
+	}
+	if !listed {
+		return statusMap, nil
+	}
+
+	// Results are sorted by creation date, most recent first,
+	// so we page using the creation date of the last one.
+	query := url.Values{}
+	query.Set("status", pinningServicePinned)
+	query.Set("limit", fmt.Sprintf("%d", pinningServicePageLimit))
+	for {
+		var res pinningServicePinResults
+		err := psc.do("GET", "/pins", query, nil, &res)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range res.Results {
+			statusMap[p.Pin.Cid] = api.IPFSPinStatusRecursive
+		}
+		if len(res.Results) < pinningServicePageLimit {
+			return statusMap, nil
+		}
+		last := res.Results[len(res.Results)-1]
+		query.Set("before", last.Created.Format(time.RFC3339Nano))
+	}
+}
+
+// PinPresent is not supported, as pinning services do not tell whether
+// they hold the content without fetching it.
+func (psc *PinningServiceConnector) PinPresent(hash *cid.Cid, pinType api.PinType) error {
+	return errors.New("pinning without fetching is not supported when using a pinning service")
+}
+
+// Verify is not supported, as pinning services do not offer
+// access to the blocks they hold.
+func (psc *PinningServiceConnector) Verify(hash *cid.Cid) error {
+	return errors.New("verifying content is not supported when using a pinning service")
+}
+
+// Add is not supported, as pinning services only fetch content which
+// is already available in the IPFS network.
+func (psc *PinningServiceConnector) Add(data io.Reader) (*cid.Cid, error) {
+	return nil, errors.New("adding content is not supported when using a pinning service")
+}
+
+// Resolve is not supported, as pinning services do not offer a way to
+// resolve IPFS paths.
+func (psc *PinningServiceConnector) Resolve(path string) (*cid.Cid, error) {
+	return nil, errors.New("resolving paths is not supported when using a pinning service")
+}
+
+// RepoStat is not supported, as pinning services do not report the
+// space available to them.
+func (psc *PinningServiceConnector) RepoStat() (api.IPFSRepoStat, error) {
+	return api.IPFSRepoStat{}, errors.New("repository stats are not available when using a pinning service")
+}
+
+// BandwidthStats is not supported, as pinning services do not report
+// their bandwidth usage.
+func (psc *PinningServiceConnector) BandwidthStats() (api.IPFSBandwidth, error) {
+	err := errors.New("bandwidth stats are not available when using a pinning service")
+	return api.IPFSBandwidth{Error: err.Error()}, err
+}
+
+// pinRequests returns the pin requests for the given item in any state.
+func (psc *PinningServiceConnector) pinRequests(hash *cid.Cid) ([]pinningServicePinStatus, error) {
+	query := url.Values{}
+	query.Set("cid", hash.String())
+	query.Set("status", strings.Join([]string{
+		pinningServiceQueued,
+		pinningServicePinning,
+		pinningServicePinned,
+		pinningServiceFailed,
+	}, ","))
+
+	var res pinningServicePinResults
+	err := psc.do("GET", "/pins", query, nil, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Results, nil
+}
+
+// do performs an authenticated request against the pinning service.
+// When not nil, body is sent as JSON and the response is decoded
+// into out.
+func (psc *PinningServiceConnector) do(method, path string, query url.Values, body, out interface{}) error {
+	u := psc.endpoint + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	logger.Debugf("pinning service: %s %s", method, u)
+
+	reqBody := bytes.NewReader(nil)
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, u, reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if psc.token != "" {
+		req.Header.Set("Authorization", "Bearer "+psc.token)
+	}
+
+	resp, err := psc.client.Do(req)
+	if err != nil {
+		logger.Error("error contacting the pinning service:", err)
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		logger.Errorf("error reading response body: %s", err)
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var psErr pinningServiceError
+		var msg string
+		if json.Unmarshal(respBody, &psErr) == nil && psErr.Error.Reason != "" {
+			msg = fmt.Sprintf("pinning service unsuccessful: %d: %s: %s",
+				resp.StatusCode, psErr.Error.Reason, psErr.Error.Details)
+		} else {
+			msg = fmt.Sprintf("pinning service %s %s unsuccessful: %d: %s",
+				method, path, resp.StatusCode, respBody)
+		}
+		logger.Warning(msg)
+		return errors.New(msg)
+	}
+
+	if out == nil || len(respBody) == 0 {
+		return nil
+	}
+	return json.Unmarshal(respBody, out)
+}
@@ -0,0 +1,131 @@
+package ipfscluster
+
+import (
+	"testing"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/test"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+func testPinningServiceConnector(t *testing.T, token string) (*PinningServiceConnector, *test.PinningServiceMock) {
+	mock := test.NewPinningServiceMock()
+	cfg := &Config{
+		PinningServiceEndpoint: mock.URL + "/",
+		PinningServiceToken:    token,
+	}
+	psc, err := NewPinningServiceConnector(cfg)
+	if err != nil {
+		t.Fatal("creating a PinningServiceConnector should work: ", err)
+	}
+	psc.SetClient(test.NewMockRPCClient(t))
+	return psc, mock
+}
+
+func TestNewPinningServiceConnector(t *testing.T) {
+	_, err := NewPinningServiceConnector(&Config{})
+	if err == nil {
+		t.Error("expected an error without endpoint")
+	}
+
+	psc, mock := testPinningServiceConnector(t, test.PinningServiceToken)
+	defer mock.Close()
+	defer psc.Shutdown()
+}
+
+func TestPinningServiceConnectorPin(t *testing.T) {
+	psc, mock := testPinningServiceConnector(t, test.PinningServiceToken)
+	defer mock.Close()
+	defer psc.Shutdown()
+
+	c, _ := cid.Decode(test.TestCid1)
+	err := psc.Pin(c, api.PinTypeRecursive)
+	if err != nil {
+		t.Fatal("expected success pinning cid")
+	}
+	// Pinning again re-uses the existing request
+	err = psc.Pin(c, api.PinTypeRecursive)
+	if err != nil {
+		t.Fatal("expected success pinning a pinned cid")
+	}
+
+	st, err := psc.PinLsCid(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st != api.IPFSPinStatusRecursive {
+		t.Error("cid should have been pinned")
+	}
+
+	c2, _ := cid.Decode(test.ErrorCid)
+	err = psc.Pin(c2, api.PinTypeRecursive)
+	if err == nil {
+		t.Error("expected an error when the service fails to pin")
+	}
+	st, _ = psc.PinLsCid(c2)
+	if st != api.IPFSPinStatusUnpinned {
+		t.Error("failed pins should be reported as unpinned")
+	}
+}
+
+func TestPinningServiceConnectorUnpin(t *testing.T) {
+	psc, mock := testPinningServiceConnector(t, test.PinningServiceToken)
+	defer mock.Close()
+	defer psc.Shutdown()
+
+	c, _ := cid.Decode(test.TestCid1)
+	err := psc.Unpin(c)
+	if err != nil {
+		t.Fatal("unpinning an unpinned cid should succeed")
+	}
+
+	psc.Pin(c, api.PinTypeRecursive)
+	err = psc.Unpin(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, _ := psc.PinLsCid(c)
+	if st != api.IPFSPinStatusUnpinned {
+		t.Error("cid should have been unpinned")
+	}
+}
+
+func TestPinningServiceConnectorPinLs(t *testing.T) {
+	psc, mock := testPinningServiceConnector(t, test.PinningServiceToken)
+	defer mock.Close()
+	defer psc.Shutdown()
+
+	c, _ := cid.Decode(test.TestCid1)
+	c2, _ := cid.Decode(test.TestCid2)
+	psc.Pin(c, api.PinTypeRecursive)
+	psc.Pin(c2, api.PinTypeRecursive)
+
+	ips, err := psc.PinLs("recursive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ips) != 2 || !ips[test.TestCid1].IsPinned() {
+		t.Error("expected 2 pinned items")
+	}
+
+	ips, err = psc.PinLs("direct")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ips) != 0 {
+		t.Error("pinning services do not hold direct pins")
+	}
+}
+
+func TestPinningServiceConnectorBadToken(t *testing.T) {
+	psc, mock := testPinningServiceConnector(t, "wrong")
+	defer mock.Close()
+	defer psc.Shutdown()
+
+	c, _ := cid.Decode(test.TestCid1)
+	st, err := psc.PinLsCid(c)
+	if err == nil || st != api.IPFSPinStatusError {
+		t.Error("expected an error with a bad token")
+	}
+}
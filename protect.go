@@ -0,0 +1,48 @@
+package ipfscluster
+
+import (
+	"github.com/ipfs/ipfs-cluster/api"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+var errPinProtected = api.NewError(403, "pin is protected: unprotect it before unpinning")
+
+// isProtected returns true when the given Cid is pinned and protected
+// in the shared state.
+func (c *Cluster) isProtected(h *cid.Cid) bool {
+	st, err := c.consensus.State()
+	if err != nil || !st.Has(h) {
+		return false
+	}
+	return st.Get(h).Protected
+}
+
+// Protect sets or clears the protection of a pinned Cid. Protected pins
+// cannot be unpinned (not even with ForceUnpin) nor evicted from the
+// cache. Since the flag is part of the shared state, it survives
+// restarts and applies to every peer. Anyone with access to the API can
+// protect and unprotect pins: protection prevents accidents, not
+// malicious removals.
+func (c *Cluster) Protect(h *cid.Cid, protected bool) error {
+	st, err := c.consensus.State()
+	if err != nil {
+		return err
+	}
+	if !st.Has(h) {
+		return errNotPinned(h)
+	}
+
+	carg := st.Get(h)
+	if carg.Protected == protected {
+		return nil
+	}
+	carg.Protected = protected
+	if protected {
+		logger.Info("protecting:", h)
+	} else {
+		logger.Info("unprotecting:", h)
+	}
+	_, err = c.consensus.LogPin(carg)
+	return err
+}
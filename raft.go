@@ -1,8 +1,11 @@
 package ipfscluster
 
 import (
+	"bufio"
+	"compress/gzip"
 	"context"
 	"errors"
+	"io"
 	"io/ioutil"
 	"path/filepath"
 	"strings"
@@ -23,6 +26,12 @@ var DefaultRaftConfig = hashiraft.DefaultConfig()
 // folder.
 var RaftMaxSnapshots = 5
 
+// RaftCompressSnapshots is the default of Config.RaftCompressSnapshots,
+// which enables gzip compression of the state when taking Raft
+// snapshots. Compressed and uncompressed snapshots can always be
+// restored, regardless of this setting.
+var RaftCompressSnapshots = false
+
 // is this running 64 bits arch? https://groups.google.com/forum/#!topic/golang-nuts/vAckmhUMAdQ
 const sixtyfour = uint64(^uint(0)) == ^uint64(0)
 
@@ -58,8 +67,27 @@ func defaultRaftConfig() *hashiraft.Config {
 	return DefaultRaftConfig
 }
 
+// raftConfig returns a copy of the default Raft configuration with
+// the timeouts set in the Cluster configuration.
+func raftConfig(cfg *Config) *hashiraft.Config {
+	rcfg := *defaultRaftConfig()
+	if cfg.RaftHeartbeatTimeoutMs > 0 {
+		rcfg.HeartbeatTimeout = time.Duration(cfg.RaftHeartbeatTimeoutMs) * time.Millisecond
+	}
+	if cfg.RaftElectionTimeoutMs > 0 {
+		rcfg.ElectionTimeout = time.Duration(cfg.RaftElectionTimeoutMs) * time.Millisecond
+	}
+	// Raft refuses a leader lease longer than the heartbeat timeout
+	if rcfg.LeaderLeaseTimeout > rcfg.HeartbeatTimeout {
+		rcfg.LeaderLeaseTimeout = rcfg.HeartbeatTimeout
+	}
+	return &rcfg
+}
+
 // NewRaft launches a go-libp2p-raft consensus peer.
-func NewRaft(peers []peer.ID, host host.Host, dataFolder string, fsm hashiraft.FSM) (*Raft, error) {
+func NewRaft(peers []peer.ID, host host.Host, cfg *Config, fsm hashiraft.FSM) (*Raft, error) {
+	dataFolder := cfg.ConsensusDataFolder
+
 	logger.Debug("creating libp2p Raft transport")
 	transport, err := libp2praft.NewLibp2pTransportWithHost(host)
 	if err != nil {
@@ -88,9 +116,9 @@ func NewRaft(peers []peer.ID, host host.Host, dataFolder string, fsm hashiraft.F
 		return nil, err
 	}
 
-	cfg := defaultRaftConfig()
+	rcfg := raftConfig(cfg)
 	logger.Debug("creating Raft")
-	r, err := hashiraft.NewRaft(cfg, fsm, logStore, logStore, snapshots, pstore, transport)
+	r, err := hashiraft.NewRaft(rcfg, &gzipFSM{FSM: fsm, compress: cfg.RaftCompressSnapshots}, logStore, logStore, snapshots, pstore, transport)
 	if err != nil {
 		logger.Error("initializing raft: ", err)
 		return nil, err
@@ -195,6 +223,25 @@ func (r *Raft) WaitForUpdates(ctx context.Context) error {
 	}
 }
 
+// AppliedIndex returns the index of the last log entry applied to the FSM.
+func (r *Raft) AppliedIndex() uint64 {
+	return r.raft.AppliedIndex()
+}
+
+// WaitForIndex holds until the FSM has applied the given log index.
+func (r *Raft) WaitForIndex(ctx context.Context, index uint64) error {
+	for {
+		if r.raft.AppliedIndex() >= index {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
 // Snapshot tells Raft to take a snapshot.
 func (r *Raft) Snapshot() error {
 	future := r.raft.Snapshot()
@@ -278,6 +325,11 @@ func (r *Raft) RemovePeer(peer string) error {
 // 	return err
 // }
 
+// Peers returns the peers in the Raft configuration.
+func (r *Raft) Peers() ([]string, error) {
+	return r.peerstore.Peers()
+}
+
 // Leader returns Raft's leader. It may be an empty string if
 // there is no leader or it is unknown.
 func (r *Raft) Leader() string {
@@ -296,3 +348,75 @@ func (r *Raft) hasPeer(peer string) bool {
 
 	return found
 }
+
+// gzipFSM wraps a Raft FSM so that snapshots are compressed when
+// compress is set. Restore detects the format from the gzip header, so
+// both kinds of snapshots can be read. Snapshots are stored and sent to
+// other peers in their compressed form.
+type gzipFSM struct {
+	hashiraft.FSM
+	compress bool
+}
+
+func (fsm *gzipFSM) Snapshot() (hashiraft.FSMSnapshot, error) {
+	snap, err := fsm.FSM.Snapshot()
+	if err != nil || !fsm.compress {
+		return snap, err
+	}
+	return &gzipFSMSnapshot{snap}, nil
+}
+
+func (fsm *gzipFSM) Restore(rc io.ReadCloser) error {
+	br := bufio.NewReader(rc)
+	magic, err := br.Peek(2)
+	if err != nil || magic[0] != 0x1f || magic[1] != 0x8b {
+		// Not compressed (or too short to be).
+		return fsm.FSM.Restore(&readCloser{br, rc})
+	}
+
+	logger.Debug("restoring compressed snapshot")
+	gz, err := gzip.NewReader(br)
+	if err != nil {
+		rc.Close()
+		return err
+	}
+	return fsm.FSM.Restore(&readCloser{gz, rc})
+}
+
+type gzipFSMSnapshot struct {
+	hashiraft.FSMSnapshot
+}
+
+func (snap *gzipFSMSnapshot) Persist(sink hashiraft.SnapshotSink) error {
+	return snap.FSMSnapshot.Persist(&gzipSnapshotSink{
+		SnapshotSink: sink,
+		gz:           gzip.NewWriter(sink),
+	})
+}
+
+type gzipSnapshotSink struct {
+	hashiraft.SnapshotSink
+	gz *gzip.Writer
+}
+
+func (sink *gzipSnapshotSink) Write(p []byte) (int, error) {
+	return sink.gz.Write(p)
+}
+
+func (sink *gzipSnapshotSink) Close() error {
+	if err := sink.gz.Close(); err != nil {
+		sink.SnapshotSink.Cancel()
+		return err
+	}
+	return sink.SnapshotSink.Close()
+}
+
+// readCloser reads from a reader and closes a different closer.
+type readCloser struct {
+	io.Reader
+	closer io.Closer
+}
+
+func (rc *readCloser) Close() error {
+	return rc.closer.Close()
+}
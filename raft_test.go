@@ -0,0 +1,79 @@
+package ipfscluster
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	hashiraft "github.com/hashicorp/raft"
+)
+
+var testSnapshotData = []byte("this is a snapshot of the state")
+
+type testFSM struct {
+	restored []byte
+}
+
+func (fsm *testFSM) Apply(l *hashiraft.Log) interface{} { return nil }
+
+func (fsm *testFSM) Snapshot() (hashiraft.FSMSnapshot, error) {
+	return &testFSMSnapshot{}, nil
+}
+
+func (fsm *testFSM) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+	b, err := ioutil.ReadAll(rc)
+	fsm.restored = b
+	return err
+}
+
+type testFSMSnapshot struct{}
+
+func (snap *testFSMSnapshot) Persist(sink hashiraft.SnapshotSink) error {
+	sink.Write(testSnapshotData)
+	return sink.Close()
+}
+
+func (snap *testFSMSnapshot) Release() {}
+
+type testSnapshotSink struct {
+	bytes.Buffer
+}
+
+func (sink *testSnapshotSink) ID() string    { return "test" }
+func (sink *testSnapshotSink) Cancel() error { return nil }
+func (sink *testSnapshotSink) Close() error  { return nil }
+
+func testSnapshotRoundtrip(t *testing.T, compress bool) {
+	fsm := &gzipFSM{FSM: &testFSM{}, compress: compress}
+	snap, err := fsm.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &testSnapshotSink{}
+	err = snap.Persist(sink)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored := sink.Bytes()
+	if compress == bytes.Equal(stored, testSnapshotData) {
+		t.Error("snapshot not stored in the expected format")
+	}
+
+	// Restore regardless of the current setting
+	fsm.compress = !compress
+	err = fsm.Restore(ioutil.NopCloser(bytes.NewReader(stored)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(fsm.FSM.(*testFSM).restored, testSnapshotData) {
+		t.Error("restored data does not match")
+	}
+}
+
+func TestRaftSnapshotCompression(t *testing.T) {
+	testSnapshotRoundtrip(t, true)
+	testSnapshotRoundtrip(t, false)
+}
@@ -0,0 +1,174 @@
+package ipfscluster
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/ipfs/ipfs-cluster/api"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// ReconcileDryRun compares the shared state with the status reported
+// by the PinTracker and the IPFS daemon of every peer and returns the
+// actions needed to bring them in line: items to pin where they are
+// allocated, stray pins to remove, items to stop tracking and pins to
+// re-allocate. Nothing is changed. Peers which cannot be contacted are
+// listed in the plan errors.
+func (c *Cluster) ReconcileDryRun() (api.ReconcilePlan, error) {
+	cState, err := c.consensus.State()
+	if err != nil {
+		return api.ReconcilePlan{}, err
+	}
+	pins := cState.List()
+
+	plan := api.ReconcilePlan{
+		Actions: c.allocationActions(pins),
+	}
+
+	peers := c.peerManager.peers()
+	statuses := make([][]api.PinInfoSerial, len(peers), len(peers))
+	ifaces := make([]interface{}, len(peers), len(peers))
+	for i := range statuses {
+		ifaces[i] = &statuses[i]
+	}
+	errs := c.multiRPC(c.ctx, peers, "Cluster", "TrackerStatusAll", struct{}{}, ifaces)
+
+	ipfsPins := make([]map[string]api.IPFSPinStatus, len(peers), len(peers))
+	for i := range ipfsPins {
+		ifaces[i] = &ipfsPins[i]
+	}
+	ipfsErrs := c.multiRPC(c.ctx, peers, "Cluster", "IPFSPinLs", "recursive,direct", ifaces)
+
+	for i, p := range peers {
+		if errs[i] != nil {
+			plan.Errors = append(plan.Errors,
+				fmt.Sprintf("%s: %s", p.Pretty(), errs[i]))
+			continue
+		}
+		pinMap := ipfsPins[i]
+		if ipfsErrs[i] != nil {
+			plan.Errors = append(plan.Errors,
+				fmt.Sprintf("%s: listing IPFS pins: %s", p.Pretty(), ipfsErrs[i]))
+			pinMap = nil
+		}
+		plan.Actions = append(plan.Actions,
+			peerActions(p, pins, statuses[i], pinMap)...)
+	}
+
+	sort.Sort(reconcileActions(plan.Actions))
+	return plan, nil
+}
+
+// allocationActions lists the pins which checkAllocations would
+// re-allocate when ReallocateUnknownAllocations is set.
+func (c *Cluster) allocationActions(pins []api.CidArg) []api.ReconcileAction {
+	var actions []api.ReconcileAction
+	for _, carg := range pins {
+		if carg.Everywhere {
+			continue
+		}
+		known, unknown := c.splitAllocations(carg.Allocations)
+		rplMin, _ := c.replicationFactors(carg)
+		var reason string
+		switch {
+		case len(known) < rplMin:
+			reason = fmt.Sprintf("allocated to %d peers, below the minimum replication factor (%d)",
+				len(known), rplMin)
+		case len(unknown) > 0:
+			reason = fmt.Sprintf("allocated to %d peers which are not part of the cluster",
+				len(unknown))
+		default:
+			continue
+		}
+		actions = append(actions, api.ReconcileAction{
+			Action: api.ReconcileReallocate,
+			Cid:    carg.Cid,
+			Reason: reason,
+		})
+	}
+	return actions
+}
+
+// peerActions compares what a peer should hold according to the given
+// pins with the status reported by its PinTracker and the pins in its
+// IPFS daemon. ipfsPins is nil when they could not be listed.
+func peerActions(p peer.ID, pins []api.CidArg, tracked []api.PinInfoSerial, ipfsPins map[string]api.IPFSPinStatus) []api.ReconcileAction {
+	var actions []api.ReconcileAction
+	add := func(action string, carg api.CidArg, reason string) {
+		actions = append(actions, api.ReconcileAction{
+			Action: action,
+			Cid:    carg.Cid,
+			Peer:   p,
+			Reason: reason,
+		})
+	}
+
+	status := make(map[string]api.PinInfoSerial, len(tracked))
+	for _, pinfo := range tracked {
+		status[pinfo.Cid] = pinfo
+	}
+
+	inState := make(map[string]bool, len(pins))
+	for _, carg := range pins {
+		k := carg.Cid.String()
+		inState[k] = true
+		local := carg.Everywhere || carg.AllocatedTo(p)
+		pinfo, ok := status[k]
+		st := api.TrackerStatus(api.TrackerStatusUnpinned)
+		if ok {
+			st = api.TrackerStatusFromString(pinfo.Status)
+		}
+		ipfsPinned := ipfsPins != nil && ipfsPins[k].IsPinned()
+
+		switch {
+		case local && st == api.TrackerStatusPinned && ipfsPins != nil && !ipfsPinned:
+			add(api.ReconcilePin, carg, "tracked as pinned but not pinned in IPFS")
+		case local && st != api.TrackerStatusPinned && st != api.TrackerStatusPinning:
+			reason := "tracker status is " + st.String()
+			if pinfo.Error != "" {
+				reason += ": " + pinfo.Error
+			}
+			add(api.ReconcilePin, carg, reason)
+		case !local && ipfsPinned && st != api.TrackerStatusUnpinning:
+			add(api.ReconcileUnpin, carg, "pinned in IPFS but allocated to other peers")
+		}
+	}
+
+	for _, pinfo := range tracked {
+		if inState[pinfo.Cid] {
+			continue
+		}
+		switch api.TrackerStatusFromString(pinfo.Status) {
+		case api.TrackerStatusUnpinned, api.TrackerStatusUnpinning:
+			continue
+		}
+		carg := api.CidArgSerial{Cid: pinfo.Cid}.ToCidArg()
+		add(api.ReconcileUntrack, carg, "tracked but not in the shared state")
+	}
+
+	for k, st := range ipfsPins {
+		if inState[k] || !st.IsPinned() {
+			continue
+		}
+		carg := api.CidArgSerial{Cid: k}.ToCidArg()
+		add(api.ReconcileUnpin, carg, "pinned in IPFS but not in the shared state")
+	}
+	return actions
+}
+
+// reconcileActions sorts actions by Cid, peer and action.
+type reconcileActions []api.ReconcileAction
+
+func (ra reconcileActions) Len() int      { return len(ra) }
+func (ra reconcileActions) Swap(i, j int) { ra[i], ra[j] = ra[j], ra[i] }
+func (ra reconcileActions) Less(i, j int) bool {
+	ci, cj := ra[i].Cid.String(), ra[j].Cid.String()
+	if ci != cj {
+		return ci < cj
+	}
+	if ra[i].Peer != ra[j].Peer {
+		return ra[i].Peer < ra[j].Peer
+	}
+	return ra[i].Action < ra[j].Action
+}
@@ -0,0 +1,63 @@
+package ipfscluster
+
+import (
+	"testing"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/test"
+
+	cid "github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+func TestPeerActions(t *testing.T) {
+	c1, _ := cid.Decode(test.TestCid1)
+	c2, _ := cid.Decode(test.TestCid2)
+	c3, _ := cid.Decode(test.TestCid3)
+	c4, _ := cid.Decode(test.ErrorCid)
+	p := test.TestPeerID1
+
+	pins := []api.CidArg{
+		// allocated here, but failed to pin
+		{Cid: c1, Allocations: []peer.ID{p}},
+		// allocated elsewhere but pinned here
+		{Cid: c2, Allocations: []peer.ID{test.TestPeerID2}},
+		// pinned and fine
+		{Cid: c3, Everywhere: true},
+	}
+	tracked := []api.PinInfoSerial{
+		{Cid: test.TestCid1, Status: "pin_error", Error: "timeout"},
+		{Cid: test.TestCid2, Status: "remote"},
+		{Cid: test.TestCid3, Status: "pinned"},
+	}
+	ipfsPins := map[string]api.IPFSPinStatus{
+		test.TestCid2: api.IPFSPinStatusRecursive,
+		test.TestCid3: api.IPFSPinStatusRecursive,
+		// not in the shared state
+		test.ErrorCid: api.IPFSPinStatusRecursive,
+	}
+
+	actions := peerActions(p, pins, tracked, ipfsPins)
+	expected := map[string]string{
+		c1.String(): api.ReconcilePin,
+		c2.String(): api.ReconcileUnpin,
+		c4.String(): api.ReconcileUnpin,
+	}
+	if len(actions) != len(expected) {
+		t.Fatalf("expected %d actions: %+v", len(expected), actions)
+	}
+	for _, a := range actions {
+		if expected[a.Cid.String()] != a.Action {
+			t.Errorf("unexpected action %s for %s", a.Action, a.Cid)
+		}
+		if a.Peer != p {
+			t.Error("actions should refer to the peer")
+		}
+	}
+
+	// Without IPFS pins, only the tracker status is compared
+	actions = peerActions(p, pins, tracked, nil)
+	if len(actions) != 1 || actions[0].Cid.String() != c1.String() {
+		t.Errorf("expected a single pin action: %+v", actions)
+	}
+}
@@ -0,0 +1,101 @@
+package ipfscluster
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// removalsFile is the name of the file, in the consensus data folder,
+// where the Cluster keeps the removals of ForceUnpin which some peers
+// have not confirmed yet, so that they are retried after a restart.
+const removalsFile = "removals.json"
+
+func (c *Cluster) removalsPath() string {
+	if c.config.ConsensusDataFolder == "" {
+		return ""
+	}
+	return filepath.Join(c.config.ConsensusDataFolder, removalsFile)
+}
+
+// loadRemovals reads the pending removals saved by saveRemovals. A
+// missing file is not an error.
+func (c *Cluster) loadRemovals() error {
+	path := c.removalsPath()
+	if path == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var serial map[string][]string
+	err = json.Unmarshal(b, &serial)
+	if err != nil {
+		return err
+	}
+
+	c.removalsMux.Lock()
+	defer c.removalsMux.Unlock()
+	for k, pids := range serial {
+		peers := make([]peer.ID, 0, len(pids))
+		for _, p := range pids {
+			pid, err := peer.IDB58Decode(p)
+			if err != nil {
+				continue
+			}
+			peers = append(peers, pid)
+		}
+		c.pendingRemovals[k] = peers
+	}
+	if len(serial) > 0 {
+		logger.Infof("loaded %d pending removals from %s", len(serial), path)
+	}
+	return nil
+}
+
+// saveRemovals writes the pending removals to the consensus data
+// folder, replacing the file atomically. The file is removed when there
+// are none. It must be called with removalsMux held.
+func (c *Cluster) saveRemovals() {
+	path := c.removalsPath()
+	if path == "" {
+		return
+	}
+	if len(c.pendingRemovals) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			logger.Errorf("error removing %s: %s", path, err)
+		}
+		return
+	}
+
+	serial := make(map[string][]string, len(c.pendingRemovals))
+	for k, peers := range c.pendingRemovals {
+		pids := make([]string, len(peers), len(peers))
+		for i, p := range peers {
+			pids[i] = peer.IDB58Encode(p)
+		}
+		serial[k] = pids
+	}
+	b, err := json.Marshal(serial)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(path), 0700)
+	}
+	if err == nil {
+		tmp := path + ".tmp"
+		err = ioutil.WriteFile(tmp, b, 0600)
+		if err == nil {
+			err = os.Rename(tmp, path)
+		}
+	}
+	if err != nil {
+		logger.Errorf("error saving pending removals: %s", err)
+	}
+}
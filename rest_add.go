@@ -0,0 +1,106 @@
+package ipfscluster
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"time"
+
+	"github.com/ipfs/ipfs-cluster/api"
+)
+
+// RESTAPIAddTimeout is the time allowed to receive the content sent to
+// POST /add and to answer, instead of the server's read and write
+// timeouts, so that large uploads are not cut.
+var RESTAPIAddTimeout = 30 * time.Minute
+
+// SetContentAdder sets where the content sent to POST /add is added,
+// usually the IPFSConnector of this peer. NewCluster sets it before
+// calling SetClient(). Without one, POST /add is not supported.
+func (rest *RESTAPI) SetContentAdder(a ContentAdder) {
+	rest.contentAdder = a
+}
+
+// extendDeadlines gives the request RESTAPIAddTimeout to be read and
+// answered, instead of the server's timeouts. The rest of the requests
+// keep those.
+func extendDeadlines(w http.ResponseWriter) {
+	deadline := time.Now().Add(RESTAPIAddTimeout)
+	rc := http.NewResponseController(w)
+	if err := rc.SetReadDeadline(deadline); err != nil {
+		logger.Warningf("cannot extend the read timeout of /add: %s", err)
+	}
+	if err := rc.SetWriteDeadline(deadline); err != nil {
+		logger.Warningf("cannot extend the write timeout of /add: %s", err)
+	}
+}
+
+// addHandler adds the first file in the multipart request body to the
+// IPFS daemon of this peer and pins the resulting Cid in the Cluster,
+// with the options in the query like pinHandler. The file is streamed
+// to the ContentAdder while it is received, so it is never held in
+// memory or on disk. Only this request has RESTAPIAddTimeout to
+// complete.
+func (rest *RESTAPI) addHandler(w http.ResponseWriter, r *http.Request) {
+	if rest.contentAdder == nil {
+		sendErrorResponse(w, http.StatusNotImplemented, "adding content is not supported")
+		return
+	}
+	var c api.CidArgSerial
+	if !parsePinOptions(w, r, &c) {
+		return
+	}
+	if !rest.checkLoad(w) {
+		return
+	}
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+		sendErrorResponse(w, 400, "a multipart/form-data body is required")
+		return
+	}
+
+	extendDeadlines(w)
+
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	var part *multipart.Part
+	for {
+		part, err = mr.NextPart()
+		if err == io.EOF {
+			sendErrorResponse(w, 400, "a file is required")
+			return
+		}
+		if err != nil {
+			sendErrorResponse(w, 400, "error reading multipart body: "+err.Error())
+			return
+		}
+		if part.FileName() != "" {
+			break
+		}
+		part.Close()
+	}
+
+	h, err := rest.contentAdder.Add(part)
+	part.Close()
+	if !checkRPCErr(w, err) {
+		return
+	}
+
+	c.Cid = h.String()
+	var index uint64
+	err = rest.rpcClient.Call("",
+		"Cluster",
+		"Pin",
+		c,
+		&index)
+	if err != nil {
+		// The content is in IPFS but not pinned, so it may be
+		// garbage-collected. Tell the client what to pin.
+		code, msg := api.ErrorCode(err)
+		err = api.NewError(code, "added as %s but not pinned: %s", c.Cid, msg)
+	}
+	if !checkRPCErr(w, err) {
+		return
+	}
+	rest.sendPinResponse(w, r, c, pinResp{Cid: c.Cid, Index: index})
+}
@@ -2,10 +2,15 @@ package ipfscluster
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
 	"net"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
 	"sync"
@@ -31,6 +36,22 @@ var (
 	RESTAPIServerIdleTimeout = 60 * time.Second
 )
 
+// RESTAPIMaxStateSize is the maximum size, in bytes, of the body of
+// POST /state/import.
+var RESTAPIMaxStateSize int64 = 256 * 1024 * 1024
+
+// RESTAPIMaxPinBatch is the maximum number of Cids accepted in a
+// single request to POST /pins/batch or POST /pins/status.
+var RESTAPIMaxPinBatch = 10000
+
+// DefaultPageLimit is the number of items in a page of a paginated
+// listing when the request gives a cursor but no limit.
+var DefaultPageLimit = 1000
+
+// StreamPageSize is the number of items requested from every peer at
+// a time when streaming the status of all items.
+var StreamPageSize = 1000
+
 // RESTAPI implements an API and aims to provides
 // a RESTful HTTP API for Cluster.
 type RESTAPI struct {
@@ -38,9 +59,41 @@ type RESTAPI struct {
 	apiAddr    ma.Multiaddr
 	listenAddr string
 	listenPort int
-	rpcClient  *rpc.Client
-	rpcReady   chan struct{}
-	router     *mux.Router
+	// set when listening on a Unix domain socket
+	socketPath string
+	// set when serving HTTPS
+	tls       bool
+	rpcClient *rpc.Client
+	rpcReady  chan struct{}
+	router    *mux.Router
+	// notifies the status changes sent to /events (may be nil)
+	eventSource PinEventSource
+	// raises the alerts sent to /events/alerts (may be nil)
+	alertSource AlertSource
+	// streams the status sent to GET /pins as NDJSON (may be nil)
+	statusSource StatusSource
+	// adds the content sent to POST /add (may be nil)
+	contentAdder ContentAdder
+
+	pinQueueHighWater   float64
+	strictRequestBodies bool
+
+	// credentials required by the API (see authenticate)
+	authToken        string
+	basicAuth        map[string]string
+	authExemptHealth bool
+
+	// cross-origin requests allowed by the API (see cors)
+	corsOrigins []string
+	corsMethods []string
+	corsHeaders []string
+
+	// log every request (see logRequests)
+	accessLog bool
+
+	// per-client limits of read and write requests (see rateLimit)
+	readLimiter  *rateLimiter
+	writeLimiter *rateLimiter
 
 	listener net.Listener
 	server   *http.Server
@@ -61,9 +114,55 @@ type peerAddBody struct {
 	PeerMultiaddr string `json:"peer_multiaddress"`
 }
 
+// NamespaceHeader is the request header used to scope pin, unpin,
+// listing and status operations to a namespace. Pins and unpins without
+// it use the default namespace, while listings return all pins.
+const NamespaceHeader = "X-Cluster-Namespace"
+
+// PinMetadataPrefix prefixes the query parameters which set metadata
+// when pinning, i.e. "meta-owner=alice" sets the "owner" key.
+const PinMetadataPrefix = "meta-"
+
+// NextPageHeader is the response header carrying the Cid to pass in
+// the "after" parameter to obtain the next page of a paginated listing.
+// It is not set on the last page.
+const NextPageHeader = "X-Cluster-Next-Page"
+
+// StaleStateHeader is set to "true" on responses built from the shared
+// state when it may be out of date, i.e. during a leader election.
+const StaleStateHeader = "X-Cluster-Stale-State"
+
+// NDJSONContentType is the media type, accepted by GET /pins, of
+// responses which are streamed as one JSON object per line.
+const NDJSONContentType = "application/x-ndjson"
+
+type peerReplaceBody struct {
+	OldPeerMultiaddr string `json:"old_peer_multiaddress"`
+	NewPeerMultiaddr string `json:"new_peer_multiaddress"`
+}
+
+type reallocateBody struct {
+	Allocations []string `json:"allocations"`
+}
+
+type pinPathBody struct {
+	Path        string   `json:"path"`
+	Allocations []string `json:"allocations,omitempty"`
+}
+
+type pinResp struct {
+	// Cid is set when pinning a path, to tell what it resolved to,
+	// and when adding content, to tell the Cid it was added as.
+	Cid   string             `json:"cid,omitempty"`
+	Index uint64             `json:"index"`
+	Acks  []api.PinAckSerial `json:"acks,omitempty"`
+}
+
 type errorResp struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
+	// Field is the request body field which caused the error, if any.
+	Field string `json:"field,omitempty"`
 }
 
 func (e errorResp) Error() string {
@@ -75,23 +174,46 @@ func (e errorResp) Error() string {
 func NewRESTAPI(cfg *Config) (*RESTAPI, error) {
 	ctx := context.Background()
 
-	listenAddr, err := cfg.APIAddr.ValueForProtocol(ma.P_IP4)
-	if err != nil {
-		return nil, err
-	}
-	listenPortStr, err := cfg.APIAddr.ValueForProtocol(ma.P_TCP)
-	if err != nil {
-		return nil, err
-	}
-	listenPort, err := strconv.Atoi(listenPortStr)
-	if err != nil {
-		return nil, err
+	var l net.Listener
+	var listenAddr, socketPath string
+	var listenPort int
+	if path, err := cfg.APIAddr.ValueForProtocol(ma.P_UNIX); err == nil {
+		socketPath = path
+		l, err = listenUnix(socketPath)
+		if err != nil {
+			return nil, err
+		}
+		listenAddr = socketPath
+	} else {
+		listenAddr, err = cfg.APIAddr.ValueForProtocol(ma.P_IP4)
+		if err != nil {
+			return nil, err
+		}
+		listenPortStr, err := cfg.APIAddr.ValueForProtocol(ma.P_TCP)
+		if err != nil {
+			return nil, err
+		}
+		listenPort, err = strconv.Atoi(listenPortStr)
+		if err != nil {
+			return nil, err
+		}
+
+		l, err = net.Listen("tcp", fmt.Sprintf("%s:%d",
+			listenAddr, listenPort))
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	l, err := net.Listen("tcp", fmt.Sprintf("%s:%d",
-		listenAddr, listenPort))
-	if err != nil {
-		return nil, err
+	tlsEnabled := cfg.APITLSCertFile != ""
+	if tlsEnabled {
+		// Wrapping the listener keeps run() and Shutdown() the same
+		// for both HTTP and HTTPS.
+		var err error
+		l, err = tlsListener(l, cfg.APITLSCertFile, cfg.APITLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	router := mux.NewRouter().StrictSlash(true)
@@ -108,10 +230,29 @@ func NewRESTAPI(cfg *Config) (*RESTAPI, error) {
 		apiAddr:    cfg.APIAddr,
 		listenAddr: listenAddr,
 		listenPort: listenPort,
+		socketPath: socketPath,
+		tls:        tlsEnabled,
 		listener:   l,
 		server:     s,
 		rpcReady:   make(chan struct{}, 1),
+
+		pinQueueHighWater:   cfg.PinQueueHighWater,
+		strictRequestBodies: cfg.StrictRequestBodies,
+
+		authToken:        cfg.APIAuthToken,
+		basicAuth:        cfg.APIBasicAuthCredentials,
+		authExemptHealth: cfg.APIAuthExemptHealth,
+
+		corsOrigins: cfg.APICORSAllowedOrigins,
+		corsMethods: cfg.APICORSAllowedMethods,
+		corsHeaders: cfg.APICORSAllowedHeaders,
+
+		accessLog: cfg.APIAccessLog,
+
+		readLimiter:  newRateLimiter(cfg.APIReadRateLimit),
+		writeLimiter: newRateLimiter(cfg.APIWriteRateLimit),
 	}
+	s.Handler = api.logRequests(api.cors(api.rateLimit(api.authenticate(router))))
 
 	for _, route := range api.routes() {
 		router.
@@ -126,6 +267,26 @@ func NewRESTAPI(cfg *Config) (*RESTAPI, error) {
 	return api, nil
 }
 
+// listenUnix listens on a Unix domain socket at the given path. A
+// socket left behind by a previous run is removed first, but not one
+// which is still in use or any other kind of file.
+func listenUnix(path string) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use", path)
+		}
+		err = os.Remove(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", path)
+}
+
 func (rest *RESTAPI) routes() []route {
 	return []route{
 		{
@@ -142,6 +303,78 @@ func (rest *RESTAPI) routes() []route {
 			rest.versionHandler,
 		},
 
+		{
+			"Health",
+			"GET",
+			"/health",
+			rest.healthHandler,
+		},
+
+		{
+			"IPFSBandwidth",
+			"GET",
+			"/ipfs/bandwidth",
+			rest.ipfsBandwidthHandler,
+		},
+
+		{
+			"QueueInfo",
+			"GET",
+			"/queue",
+			rest.queueInfoHandler,
+		},
+
+		{
+			"Summary",
+			"GET",
+			"/summary",
+			rest.summaryHandler,
+		},
+
+		{
+			"ConsensusConsistency",
+			"GET",
+			"/consensus/consistency",
+			rest.consistencyHandler,
+		},
+		{
+			"ConsensusPeers",
+			"GET",
+			"/consensus/peers",
+			rest.raftConfigurationHandler,
+		},
+		{
+			"StateExport",
+			"GET",
+			"/state/export",
+			rest.stateExportHandler,
+		},
+		{
+			"StateImport",
+			"POST",
+			"/state/import",
+			rest.stateImportHandler,
+		},
+		{
+			"ConsensusSnapshot",
+			"POST",
+			"/consensus/snapshot",
+			rest.snapshotHandler,
+		},
+		{
+			"ConsensusLeave",
+			"POST",
+			"/consensus/leave",
+			rest.leaveHandler,
+		},
+
+		{
+			"ReconcilePlan",
+			"GET",
+			"/reconcile/plan",
+			rest.reconcilePlanHandler,
+		},
+
 		{
 			"Peers",
 			"GET",
@@ -154,6 +387,24 @@ func (rest *RESTAPI) routes() []route {
 			"/peers",
 			rest.peerAddHandler,
 		},
+		{
+			"PeerReplace",
+			"POST",
+			"/peers/replace",
+			rest.peerReplaceHandler,
+		},
+		{
+			"PeerReplacement",
+			"GET",
+			"/peers/replace",
+			rest.peerReplacementHandler,
+		},
+		{
+			"PeerInfo",
+			"GET",
+			"/peers/{peer}",
+			rest.peerInfoHandler,
+		},
 		{
 			"PeerRemove",
 			"DELETE",
@@ -180,12 +431,48 @@ func (rest *RESTAPI) routes() []route {
 			"/pins/sync",
 			rest.syncAllHandler,
 		},
+		{
+			"RecoverAll",
+			"POST",
+			"/pins/recover",
+			rest.recoverAllHandler,
+		},
+		{
+			"PinBatch",
+			"POST",
+			"/pins/batch",
+			rest.pinBatchHandler,
+		},
+		{
+			"StatusCids",
+			"POST",
+			"/pins/status",
+			rest.statusCidsHandler,
+		},
+		{
+			"LocalAllocations",
+			"GET",
+			"/pins/local",
+			rest.localAllocationsHandler,
+		},
 		{
 			"Status",
 			"GET",
 			"/pins/{hash}",
 			rest.statusHandler,
 		},
+		{
+			"PinPath",
+			"POST",
+			"/pins",
+			rest.pinPathHandler,
+		},
+		{
+			"Add",
+			"POST",
+			"/add",
+			rest.addHandler,
+		},
 		{
 			"Pin",
 			"POST",
@@ -210,7 +497,74 @@ func (rest *RESTAPI) routes() []route {
 			"/pins/{hash}/recover",
 			rest.recoverHandler,
 		},
+		{
+			"Protect",
+			"POST",
+			"/pins/{hash}/protect",
+			rest.protectHandler,
+		},
+		{
+			"Unprotect",
+			"DELETE",
+			"/pins/{hash}/protect",
+			rest.protectHandler,
+		},
+		{
+			"Verify",
+			"POST",
+			"/pins/{hash}/verify",
+			rest.verifyHandler,
+		},
+		{
+			"Allocations",
+			"GET",
+			"/pins/{hash}/allocations",
+			rest.allocationsHandler,
+		},
+		{
+			"AllocationPreview",
+			"GET",
+			"/allocations/preview/{hash}",
+			rest.allocationPreviewHandler,
+		},
+		{
+			"Events",
+			"GET",
+			"/events",
+			rest.eventsHandler,
+		},
+		{
+			"Alerts",
+			"GET",
+			"/events/alerts",
+			rest.alertsHandler,
+		},
+		{
+			"ServingPeer",
+			"GET",
+			"/pins/{hash}/serving_peer",
+			rest.servingPeerHandler,
+		},
+		{
+			"Reallocate",
+			"POST",
+			"/pins/{hash}/reallocate",
+			rest.reallocateHandler,
+		},
+	}
+}
+
+// tlsListener wraps a listener so that it serves TLS connections using
+// the given PEM-encoded certificate and key.
+func tlsListener(l net.Listener, certFile, keyFile string) (net.Listener, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		l.Close()
+		return nil, fmt.Errorf("error loading the REST API certificate: %s", err)
 	}
+	return tls.NewListener(l, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}), nil
 }
 
 func (rest *RESTAPI) run() {
@@ -223,7 +577,11 @@ func (rest *RESTAPI) run() {
 
 		<-rest.rpcReady
 
-		logger.Infof("REST API: %s", rest.apiAddr)
+		if rest.tls {
+			logger.Infof("REST API (HTTPS): %s", rest.apiAddr)
+		} else {
+			logger.Infof("REST API: %s", rest.apiAddr)
+		}
 		err := rest.server.Serve(rest.listener)
 		if err != nil && !strings.Contains(err.Error(), "closed network connection") {
 			logger.Error(err)
@@ -231,6 +589,61 @@ func (rest *RESTAPI) run() {
 	}()
 }
 
+// MountProxy makes the API server forward any requests to /api/v0/* to
+// the given handler, usually obtained from
+// IPFSHTTPConnector.ProxyHandler(). This allows serving the Cluster API
+// and the IPFS Proxy from a single listener.
+//
+// It must be called before SetClient(), since the server starts
+// serving requests right after that. The server's timeouts are raised
+// to those of the IPFS Proxy if they are larger, or removed if the
+// proxy has none, so that proxied requests behave the same as when the
+// proxy runs on its own listener.
+func (rest *RESTAPI) MountProxy(h http.Handler) {
+	rest.server.ReadTimeout = longerTimeout(rest.server.ReadTimeout,
+		IPFSProxyServerReadTimeout)
+	rest.server.WriteTimeout = longerTimeout(rest.server.WriteTimeout,
+		IPFSProxyServerWriteTimeout)
+
+	rest.router.
+		PathPrefix("/api/v0/").
+		Name("IPFSProxy").
+		Handler(h)
+}
+
+// longerTimeout returns the longest of two server timeouts, where 0
+// means no timeout.
+func longerTimeout(a, b time.Duration) time.Duration {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// SetEventSource sets where the status changes streamed by the /events
+// endpoint come from, usually the PinTracker. Like MountProxy(), it
+// must be called before SetClient().
+func (rest *RESTAPI) SetEventSource(src PinEventSource) {
+	rest.eventSource = src
+}
+
+// SetAlertSource sets where the alerts streamed by the /events/alerts
+// endpoint come from. NewCluster sets it to the Cluster peer before
+// calling SetClient().
+func (rest *RESTAPI) SetAlertSource(src AlertSource) {
+	rest.alertSource = src
+}
+
+// SetStatusSource sets where the status of all the items streamed as
+// NDJSON by GET /pins comes from. NewCluster sets it to the Cluster
+// peer before calling SetClient().
+func (rest *RESTAPI) SetStatusSource(src StatusSource) {
+	rest.statusSource = src
+}
+
 // Shutdown stops any API listeners.
 func (rest *RESTAPI) Shutdown() error {
 	rest.shutdownLock.Lock()
@@ -247,6 +660,12 @@ func (rest *RESTAPI) Shutdown() error {
 	// Cancel any outstanding ops
 	rest.server.SetKeepAlivesEnabled(false)
 	rest.listener.Close()
+	if rest.socketPath != "" {
+		err := os.Remove(rest.socketPath)
+		if err != nil && !os.IsNotExist(err) {
+			logger.Warningf("error removing %s: %s", rest.socketPath, err)
+		}
+	}
 
 	rest.wg.Wait()
 	rest.shutdown = true
@@ -282,120 +701,908 @@ func (rest *RESTAPI) versionHandler(w http.ResponseWriter, r *http.Request) {
 	sendResponse(w, err, v)
 }
 
-func (rest *RESTAPI) peerListHandler(w http.ResponseWriter, r *http.Request) {
-	var peersSerial []api.IDSerial
+func (rest *RESTAPI) healthHandler(w http.ResponseWriter, r *http.Request) {
+	var h api.Health
 	err := rest.rpcClient.Call("",
 		"Cluster",
-		"Peers",
+		"Health",
 		struct{}{},
-		&peersSerial)
+		&h)
 
-	sendResponse(w, err, peersSerial)
+	sendResponse(w, err, h)
 }
 
-func (rest *RESTAPI) peerAddHandler(w http.ResponseWriter, r *http.Request) {
-	dec := json.NewDecoder(r.Body)
-	defer r.Body.Close()
+func (rest *RESTAPI) ipfsBandwidthHandler(w http.ResponseWriter, r *http.Request) {
+	var bw api.IPFSBandwidth
+	err := rest.rpcClient.Call("",
+		"Cluster",
+		"IPFSBandwidthStats",
+		struct{}{},
+		&bw)
 
-	var addInfo peerAddBody
-	err := dec.Decode(&addInfo)
-	if err != nil {
-		sendErrorResponse(w, 400, "error decoding request body")
-		return
-	}
+	sendResponse(w, err, bw)
+}
 
-	mAddr, err := ma.NewMultiaddr(addInfo.PeerMultiaddr)
-	if err != nil {
-		sendErrorResponse(w, 400, "error decoding peer_multiaddress")
-		return
-	}
+func (rest *RESTAPI) queueInfoHandler(w http.ResponseWriter, r *http.Request) {
+	var q api.QueueInfo
+	err := rest.rpcClient.Call("",
+		"Cluster",
+		"QueueInfo",
+		struct{}{},
+		&q)
 
-	var ids api.IDSerial
-	err = rest.rpcClient.Call("",
+	sendResponse(w, err, q)
+}
+
+func (rest *RESTAPI) summaryHandler(w http.ResponseWriter, r *http.Request) {
+	var s api.Summary
+	err := rest.rpcClient.Call("",
 		"Cluster",
-		"PeerAdd",
-		api.MultiaddrToSerial(mAddr),
-		&ids)
-	sendResponse(w, err, ids)
+		"Summary",
+		struct{}{},
+		&s)
+
+	sendResponse(w, err, s)
 }
 
-func (rest *RESTAPI) peerRemoveHandler(w http.ResponseWriter, r *http.Request) {
-	if p := parsePidOrError(w, r); p != "" {
-		err := rest.rpcClient.Call("",
-			"Cluster",
-			"PeerRemove",
-			p,
-			&struct{}{})
-		sendEmptyResponse(w, err)
-	}
+func (rest *RESTAPI) consistencyHandler(w http.ResponseWriter, r *http.Request) {
+	var report api.ConsistencyReportSerial
+	err := rest.rpcClient.Call("",
+		"Cluster",
+		"ConsistencyCheck",
+		struct{}{},
+		&report)
+
+	sendResponse(w, err, report)
 }
 
-func (rest *RESTAPI) pinHandler(w http.ResponseWriter, r *http.Request) {
-	if c := parseCidOrError(w, r); c.Cid != "" {
-		err := rest.rpcClient.Call("",
-			"Cluster",
-			"Pin",
-			c,
-			&struct{}{})
-		sendAcceptedResponse(w, err)
+func (rest *RESTAPI) stateExportHandler(w http.ResponseWriter, r *http.Request) {
+	var data []byte
+	err := rest.rpcClient.Call("",
+		"Cluster",
+		"ExportState",
+		struct{}{},
+		&data)
+	if !checkRPCErr(w, err) {
+		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
 }
 
-func (rest *RESTAPI) unpinHandler(w http.ResponseWriter, r *http.Request) {
-	if c := parseCidOrError(w, r); c.Cid != "" {
-		err := rest.rpcClient.Call("",
-			"Cluster",
-			"Unpin",
-			c,
-			&struct{}{})
-		sendAcceptedResponse(w, err)
+func (rest *RESTAPI) stateImportHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, RESTAPIMaxStateSize+1))
+	r.Body.Close()
+	if err != nil {
+		sendErrorResponse(w, 400, "error reading request body: "+err.Error())
+		return
+	}
+	if int64(len(data)) > RESTAPIMaxStateSize {
+		sendErrorResponse(w, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("the state is larger than %d bytes", RESTAPIMaxStateSize))
+		return
 	}
+	err = rest.rpcClient.Call("",
+		"Cluster",
+		"ImportState",
+		data,
+		&struct{}{})
+	sendEmptyResponse(w, err)
 }
 
-func (rest *RESTAPI) pinListHandler(w http.ResponseWriter, r *http.Request) {
-	var pins []api.CidArgSerial
+func (rest *RESTAPI) snapshotHandler(w http.ResponseWriter, r *http.Request) {
 	err := rest.rpcClient.Call("",
 		"Cluster",
-		"PinList",
+		"Snapshot",
 		struct{}{},
-		&pins)
-	sendResponse(w, err, pins)
+		&struct{}{})
+	sendEmptyResponse(w, err)
 }
 
-func (rest *RESTAPI) statusAllHandler(w http.ResponseWriter, r *http.Request) {
-	var pinInfos []api.GlobalPinInfoSerial
+func (rest *RESTAPI) leaveHandler(w http.ResponseWriter, r *http.Request) {
 	err := rest.rpcClient.Call("",
 		"Cluster",
-		"StatusAll",
+		"Leave",
 		struct{}{},
-		&pinInfos)
-	sendResponse(w, err, pinInfos)
+		&struct{}{})
+	sendEmptyResponse(w, err)
 }
 
-func (rest *RESTAPI) statusHandler(w http.ResponseWriter, r *http.Request) {
-	if c := parseCidOrError(w, r); c.Cid != "" {
-		var pinInfo api.GlobalPinInfoSerial
-		err := rest.rpcClient.Call("",
-			"Cluster",
-			"Status",
-			c,
-			&pinInfo)
-		sendResponse(w, err, pinInfo)
-	}
+func (rest *RESTAPI) raftConfigurationHandler(w http.ResponseWriter, r *http.Request) {
+	var servers []api.RaftServerSerial
+	err := rest.rpcClient.Call("",
+		"Cluster",
+		"RaftConfiguration",
+		struct{}{},
+		&servers)
+
+	sendResponse(w, err, servers)
 }
 
-func (rest *RESTAPI) syncAllHandler(w http.ResponseWriter, r *http.Request) {
-	var pinInfos []api.GlobalPinInfoSerial
+func (rest *RESTAPI) reconcilePlanHandler(w http.ResponseWriter, r *http.Request) {
+	var plan api.ReconcilePlanSerial
 	err := rest.rpcClient.Call("",
 		"Cluster",
-		"SyncAll",
+		"ReconcileDryRun",
 		struct{}{},
-		&pinInfos)
-	sendResponse(w, err, pinInfos)
+		&plan)
+
+	sendResponse(w, err, plan)
 }
 
-func (rest *RESTAPI) syncHandler(w http.ResponseWriter, r *http.Request) {
-	if c := parseCidOrError(w, r); c.Cid != "" {
+func (rest *RESTAPI) peerListHandler(w http.ResponseWriter, r *http.Request) {
+	var peersSerial []api.IDSerial
+	err := rest.rpcClient.Call("",
+		"Cluster",
+		"Peers",
+		struct{}{},
+		&peersSerial)
+
+	sendResponse(w, err, peersSerial)
+}
+
+func (rest *RESTAPI) peerAddHandler(w http.ResponseWriter, r *http.Request) {
+	var addInfo peerAddBody
+	if !rest.decodeBodyOrError(w, r, &addInfo) {
+		return
+	}
+
+	mAddr, ok := parseMultiaddrOrError(w, "peer_multiaddress", addInfo.PeerMultiaddr)
+	if !ok {
+		return
+	}
+
+	method := "PeerAdd"
+	if r.URL.Query().Get("force") == "true" {
+		method = "PeerAddForce"
+	}
+	var ids api.IDSerial
+	err := rest.rpcClient.Call("",
+		"Cluster",
+		method,
+		api.MultiaddrToSerial(mAddr),
+		&ids)
+	sendResponse(w, err, ids)
+}
+
+func (rest *RESTAPI) peerReplaceHandler(w http.ResponseWriter, r *http.Request) {
+	var body peerReplaceBody
+	if !rest.decodeBodyOrError(w, r, &body) {
+		return
+	}
+
+	oldAddr, ok := parseMultiaddrOrError(w, "old_peer_multiaddress", body.OldPeerMultiaddr)
+	if !ok {
+		return
+	}
+	newAddr, ok := parseMultiaddrOrError(w, "new_peer_multiaddress", body.NewPeerMultiaddr)
+	if !ok {
+		return
+	}
+
+	var pr api.PeerReplacementSerial
+	err := rest.rpcClient.Call("",
+		"Cluster",
+		"ReplacePeer",
+		api.MultiaddrsToSerial([]ma.Multiaddr{oldAddr, newAddr}),
+		&pr)
+	if checkRPCErr(w, err) {
+		sendJSONResponse(w, http.StatusAccepted, pr)
+	}
+}
+
+func (rest *RESTAPI) peerReplacementHandler(w http.ResponseWriter, r *http.Request) {
+	var pr api.PeerReplacementSerial
+	err := rest.rpcClient.Call("",
+		"Cluster",
+		"PeerReplacement",
+		struct{}{},
+		&pr)
+	sendResponse(w, err, pr)
+}
+
+func (rest *RESTAPI) peerInfoHandler(w http.ResponseWriter, r *http.Request) {
+	if p := parsePidOrError(w, r); p != "" {
+		var idSerial api.IDSerial
+		err := rest.rpcClient.Call("",
+			"Cluster",
+			"PeerInfo",
+			p,
+			&idSerial)
+		sendResponse(w, err, idSerial)
+	}
+}
+
+func (rest *RESTAPI) peerRemoveHandler(w http.ResponseWriter, r *http.Request) {
+	if p := parsePidOrError(w, r); p != "" {
+		err := rest.rpcClient.Call("",
+			"Cluster",
+			"PeerRemove",
+			p,
+			&struct{}{})
+		sendEmptyResponse(w, err)
+	}
+}
+
+func (rest *RESTAPI) pinHandler(w http.ResponseWriter, r *http.Request) {
+	if c := parseCidOrError(w, r); c.Cid != "" {
+		if !parsePinOptions(w, r, &c) {
+			return
+		}
+		if !rest.checkLoad(w) {
+			return
+		}
+		var index uint64
+		err := rest.rpcClient.Call("",
+			"Cluster",
+			"Pin",
+			c,
+			&index)
+		if !rest.checkPinErr(w, err) {
+			return
+		}
+		rest.sendPinResponse(w, r, c, pinResp{Index: index})
+	}
+}
+
+// pinPathHandler pins the Cid which the IPFS path in the request body
+// resolves to. The options are read from the query like in pinHandler.
+// The body may list the peers to allocate the Cid to, in which case the
+// allocator is not used.
+func (rest *RESTAPI) pinPathHandler(w http.ResponseWriter, r *http.Request) {
+	var body pinPathBody
+	if !rest.decodeBodyOrError(w, r, &body) {
+		return
+	}
+	if body.Path == "" {
+		sendError(w, errorResp{
+			Code:    400,
+			Message: "error decoding request body: a path is required",
+			Field:   "path",
+		})
+		return
+	}
+
+	if !checkPeersOrError(w, body.Allocations) {
+		return
+	}
+
+	var c api.CidArgSerial
+	if !parsePinOptions(w, r, &c) {
+		return
+	}
+	c.Allocations = body.Allocations
+	if !rest.checkLoad(w) {
+		return
+	}
+	var pinned api.PinResultSerial
+	err := rest.rpcClient.Call("",
+		"Cluster",
+		"PinPath",
+		api.PinPathSerial{Path: body.Path, CidArg: c},
+		&pinned)
+	if !rest.checkPinErr(w, err) {
+		return
+	}
+	c.Cid = pinned.Cid
+	rest.sendPinResponse(w, r, c, pinResp{Cid: pinned.Cid, Index: pinned.Index})
+}
+
+// sendPinResponse sends the response for a successful pin of c. When
+// the "acks" query parameter is set, it waits for the pin to be in the
+// local state and includes the acknowledgements of the allocated peers.
+func (rest *RESTAPI) sendPinResponse(w http.ResponseWriter, r *http.Request, c api.CidArgSerial, resp pinResp) {
+	if r.URL.Query().Get("acks") == "true" {
+		// make sure the pin is in our state first
+		err := rest.rpcClient.Call("",
+			"Cluster",
+			"WaitForIndex",
+			resp.Index,
+			&struct{}{})
+		if !checkRPCErr(w, err) {
+			return
+		}
+		err = rest.rpcClient.Call("",
+			"Cluster",
+			"PinAcks",
+			c,
+			&resp.Acks)
+		if !checkRPCErr(w, err) {
+			return
+		}
+	}
+	sendJSONResponse(w, http.StatusAccepted, resp)
+}
+
+// pinBatchHandler pins the Cids in a JSON array in the request body.
+// The options from the query and the namespace header apply to all of
+// them. The response lists the outcome for each Cid, so that invalid
+// or failed Cids do not prevent pinning the rest.
+func (rest *RESTAPI) pinBatchHandler(w http.ResponseWriter, r *http.Request) {
+	var hashes []string
+	err := json.NewDecoder(r.Body).Decode(&hashes)
+	r.Body.Close()
+	if err != nil {
+		sendErrorResponse(w, 400, "error decoding request body: expected a JSON array of Cids: "+err.Error())
+		return
+	}
+	if len(hashes) > RESTAPIMaxPinBatch {
+		sendErrorResponse(w, 400, fmt.Sprintf("too many Cids: %d (maximum is %d)",
+			len(hashes), RESTAPIMaxPinBatch))
+		return
+	}
+
+	var opts api.CidArgSerial
+	if !parsePinOptions(w, r, &opts) {
+		return
+	}
+	if !rest.checkLoad(w) {
+		return
+	}
+
+	results := make([]api.PinResultSerial, len(hashes), len(hashes))
+	var cargs []api.CidArgSerial
+	var pos []int
+	for i, h := range hashes {
+		results[i].Cid = h
+		if _, err := cid.Decode(h); err != nil {
+			results[i].Error = "error decoding Cid: " + err.Error()
+			continue
+		}
+		c := opts
+		c.Cid = h
+		cargs = append(cargs, c)
+		pos = append(pos, i)
+	}
+
+	if len(cargs) > 0 {
+		var pinned []api.PinResultSerial
+		err = rest.rpcClient.Call("",
+			"Cluster",
+			"PinMany",
+			cargs,
+			&pinned)
+		if !checkRPCErr(w, err) {
+			return
+		}
+		for i, pr := range pinned {
+			results[pos[i]] = pr
+		}
+	}
+	sendJSONResponse(w, http.StatusAccepted, results)
+}
+
+// statusCidsHandler returns the status of the Cids in the JSON array
+// sent in the body, as a map indexed by the given Cids. Cids which are
+// not pinned, or not in the namespace of the request, are reported
+// without any peers.
+func (rest *RESTAPI) statusCidsHandler(w http.ResponseWriter, r *http.Request) {
+	var hashes []string
+	err := json.NewDecoder(r.Body).Decode(&hashes)
+	r.Body.Close()
+	if err != nil {
+		sendErrorResponse(w, 400, "error decoding request body: expected a JSON array of Cids: "+err.Error())
+		return
+	}
+	if len(hashes) > RESTAPIMaxPinBatch {
+		sendErrorResponse(w, 400, fmt.Sprintf("too many Cids: %d (maximum is %d)",
+			len(hashes), RESTAPIMaxPinBatch))
+		return
+	}
+	cids, err := decodeCids(hashes)
+	if !checkRPCErr(w, err) {
+		return
+	}
+	if !rest.waitForMinIndex(w, r) {
+		return
+	}
+	nsPins, ok := rest.namespacePins(w, r)
+	if !ok {
+		return
+	}
+
+	var query []string
+	for _, c := range cids {
+		if nsPins == nil || nsPins[c.String()] {
+			query = append(query, c.String())
+		}
+	}
+	var infos []api.GlobalPinInfoSerial
+	if len(query) > 0 {
+		err = rest.rpcClient.CallContext(r.Context(), "",
+			"Cluster",
+			"StatusCids",
+			query,
+			&infos)
+		if !checkRPCErr(w, err) {
+			return
+		}
+	}
+
+	byCid := make(map[string]api.GlobalPinInfoSerial, len(infos))
+	for _, info := range infos {
+		byCid[info.Cid] = info
+	}
+	result := make(map[string]api.GlobalPinInfoSerial, len(hashes))
+	for i, h := range hashes {
+		info, ok := byCid[cids[i].String()]
+		if !ok {
+			info = api.GlobalPinInfoSerial{
+				Cid:     cids[i].String(),
+				PeerMap: make(map[string]api.PinInfoSerial),
+			}
+		}
+		result[h] = info
+	}
+	sendJSONResponse(w, http.StatusOK, result)
+}
+
+// parsePinOptions sets the options of a pin request, given in the
+// query and the namespace header, on the given CidArgSerial. It sends
+// an error response and returns false when they cannot be parsed.
+func parsePinOptions(w http.ResponseWriter, r *http.Request, c *api.CidArgSerial) bool {
+	q := r.URL.Query()
+	c.NoFetch = q.Get("no_fetch") == "true"
+	c.Protected = q.Get("protected") == "true"
+	c.Namespace = r.Header.Get(NamespaceHeader)
+	c.Name = q.Get("name")
+	for k, v := range q {
+		if !strings.HasPrefix(k, PinMetadataPrefix) {
+			continue
+		}
+		key := strings.TrimPrefix(k, PinMetadataPrefix)
+		if key == "" {
+			sendErrorResponse(w, 400, "error decoding metadata: empty key in "+k)
+			return false
+		}
+		if c.Metadata == nil {
+			c.Metadata = make(map[string]string)
+		}
+		c.Metadata[key] = v[0]
+	}
+	if t := q.Get("type"); t != "" {
+		pinType, err := api.PinTypeFromString(t)
+		if err != nil {
+			sendErrorResponse(w, 400, "error decoding type: "+err.Error())
+			return false
+		}
+		c.Type = pinType.String()
+	}
+	if t := q.Get("pin_timeout"); t != "" {
+		d, err := time.ParseDuration(t)
+		if err != nil || d <= 0 {
+			sendErrorResponse(w, 400, "error decoding pin_timeout: expected a positive duration like \"1h30m\"")
+			return false
+		}
+		c.PinTimeout = d.String()
+	}
+	return parseReplicationFactors(w, r, c)
+}
+
+// parseReplicationFactors reads the replication_min and replication_max
+// query parameters into the given CidArgSerial. The replication
+// parameter sets both at once, and is overridden by them. It sends an
+// error response and returns false when they cannot be parsed.
+func parseReplicationFactors(w http.ResponseWriter, r *http.Request, c *api.CidArgSerial) bool {
+	q := r.URL.Query()
+	for _, f := range []struct {
+		param string
+		dest  *int
+	}{
+		{"replication", &c.ReplicationFactorMin},
+		{"replication", &c.ReplicationFactorMax},
+		{"replication_min", &c.ReplicationFactorMin},
+		{"replication_max", &c.ReplicationFactorMax},
+	} {
+		v := q.Get(f.param)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			sendErrorResponse(w, 400, "error decoding "+f.param+": "+err.Error())
+			return false
+		}
+		*f.dest = n
+	}
+	return true
+}
+
+func (rest *RESTAPI) unpinHandler(w http.ResponseWriter, r *http.Request) {
+	if c := parseCidOrError(w, r); c.Cid != "" {
+		c.Namespace = r.Header.Get(NamespaceHeader)
+		method := "Unpin"
+		switch {
+		case r.URL.Query().Get("force") == "true":
+			method = "ForceUnpin"
+		case r.URL.Query().Get("if_healthy") == "true":
+			method = "UnpinIfHealthy"
+		}
+		err := rest.rpcClient.Call("",
+			"Cluster",
+			method,
+			c,
+			&struct{}{})
+		sendAcceptedResponse(w, err)
+	}
+}
+
+func (rest *RESTAPI) protectHandler(w http.ResponseWriter, r *http.Request) {
+	if c := parseCidOrError(w, r); c.Cid != "" {
+		c.Protected = r.Method == "POST"
+		err := rest.rpcClient.Call("",
+			"Cluster",
+			"Protect",
+			c,
+			&struct{}{})
+		sendAcceptedResponse(w, err)
+	}
+}
+
+func (rest *RESTAPI) pinListHandler(w http.ResponseWriter, r *http.Request) {
+	fields, ok := parseFieldsOrError(w, r, cidArgFields)
+	if !ok {
+		return
+	}
+
+	var allocatedTo peer.ID
+	if pidStr := r.URL.Query().Get("peer"); pidStr != "" {
+		var err error
+		allocatedTo, err = peer.IDB58Decode(pidStr)
+		if err != nil {
+			sendErrorResponse(w, 400, "error decoding Peer ID: "+err.Error())
+			return
+		}
+	}
+	ns, scoped := requestNamespace(r)
+	name := r.URL.Query().Get("name")
+	meta := metadataFilters(r)
+
+	// Use the state indexes for the most selective filter and
+	// apply the others here.
+	var pins []api.CidArgSerial
+	var err error
+	switch {
+	case allocatedTo != "":
+		err = rest.rpcClient.Call("",
+			"Cluster",
+			"PinListByPeer",
+			allocatedTo,
+			&pins)
+	case name != "":
+		err = rest.rpcClient.Call("",
+			"Cluster",
+			"PinListByName",
+			name,
+			&pins)
+	case len(meta) > 0:
+		err = rest.rpcClient.Call("",
+			"Cluster",
+			"PinListByMetadata",
+			meta[0],
+			&pins)
+	case scoped:
+		err = rest.rpcClient.Call("",
+			"Cluster",
+			"PinListByNamespace",
+			ns,
+			&pins)
+	default:
+		err = rest.rpcClient.Call("",
+			"Cluster",
+			"PinList",
+			struct{}{},
+			&pins)
+	}
+	if err != nil {
+		sendResponse(w, err, pins)
+		return
+	}
+	rest.setStaleStateHeader(w)
+
+	filtered := make([]api.CidArgSerial, 0, len(pins))
+	for _, p := range pins {
+		if scoped && p.Namespace != ns {
+			continue
+		}
+		if name != "" && p.Name != name {
+			continue
+		}
+		if !matchMetadata(p, meta) {
+			continue
+		}
+		filtered = append(filtered, p)
+	}
+	if fields != nil {
+		selected, err := filterCidArgs(filtered, fields)
+		sendResponse(w, err, selected)
+		return
+	}
+	sendResponse(w, nil, filtered)
+}
+
+// localAllocationsHandler lists the pins which this peer is expected to
+// pin, within the namespace of the request.
+func (rest *RESTAPI) localAllocationsHandler(w http.ResponseWriter, r *http.Request) {
+	var pins []api.CidArgSerial
+	err := rest.rpcClient.Call("",
+		"Cluster",
+		"LocalAllocations",
+		struct{}{},
+		&pins)
+	if err != nil {
+		sendResponse(w, err, pins)
+		return
+	}
+	rest.setStaleStateHeader(w)
+	ns, scoped := requestNamespace(r)
+	filtered := make([]api.CidArgSerial, 0, len(pins))
+	for _, p := range pins {
+		if scoped && p.Namespace != ns {
+			continue
+		}
+		filtered = append(filtered, p)
+	}
+	sendResponse(w, nil, filtered)
+}
+
+func (rest *RESTAPI) statusAllHandler(w http.ResponseWriter, r *http.Request) {
+	pinSet, ok := rest.filterPins(w, r)
+	if !ok {
+		return
+	}
+	if r.URL.Query().Get("wait_for_changes") == "true" {
+		rest.statusChanges(w, r, pinSet)
+		return
+	}
+	fields, ok := parseFieldsOrError(w, r, globalPinInfoFields, pinInfoFields)
+	if !ok {
+		return
+	}
+	page, ok := parsePageOrError(w, r)
+	if !ok {
+		return
+	}
+	if strings.Contains(r.Header.Get("Accept"), NDJSONContentType) {
+		rest.streamStatusAll(w, r, page, pinSet, fields)
+		return
+	}
+
+	var pinInfos []api.GlobalPinInfoSerial
+	var err error
+	if page != nil {
+		// Namespace and name filtering happen on the page, so
+		// pages may have fewer items than the limit.
+		var sp api.StatusPageSerial
+		err = rest.rpcClient.CallContext(r.Context(), "",
+			"Cluster",
+			"StatusAllPage",
+			*page,
+			&sp)
+		pinInfos = sp.Items
+		if sp.Next != "" {
+			w.Header().Set(NextPageHeader, sp.Next)
+		}
+	} else {
+		err = rest.callOp(r.Context(), "StatusAll", &pinInfos)
+	}
+	if pinSet != nil && err == nil {
+		filtered := make([]api.GlobalPinInfoSerial, 0, len(pinSet))
+		for _, pinfo := range pinInfos {
+			if pinSet[pinfo.Cid] {
+				filtered = append(filtered, pinfo)
+			}
+		}
+		pinInfos = filtered
+	}
+	if fields != nil && err == nil {
+		selected, err := filterGlobalPinInfos(pinInfos, fields)
+		sendResponse(w, err, selected)
+		return
+	}
+	sendResponse(w, err, pinInfos)
+}
+
+// streamStatusAll sends the status of the items as NDJSON, flushing
+// them as they come. When the request is paginated, only the requested
+// page is sent. Otherwise the items come from the statusSource, so that
+// the whole list is never held in memory, or from a single StatusAll
+// call when there is none. Errors happening once the response has
+// started are sent as a last errorResp object.
+func (rest *RESTAPI) streamStatusAll(w http.ResponseWriter, r *http.Request, page *api.PageRequest, nsPins, fields map[string]bool) {
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	started := false
+	sent := 0
+
+	fail := func(err error) {
+		if !started {
+			checkRPCErr(w, err)
+			return
+		}
+		e := errorRespFor(err)
+		logger.Errorf("error streaming the status: %d: %s", e.Code, e.Message)
+		enc.Encode(e)
+	}
+	start := func(next string) {
+		if started {
+			return
+		}
+		w.Header().Set("Content-Type", NDJSONContentType)
+		if next != "" {
+			w.Header().Set(NextPageHeader, next)
+		}
+		w.WriteHeader(200)
+		started = true
+	}
+	// send returns an error only when the client is gone
+	send := func(pinfo api.GlobalPinInfoSerial) error {
+		start("")
+		if nsPins != nil && !nsPins[pinfo.Cid] {
+			return nil
+		}
+		var obj interface{} = pinfo
+		if fields != nil {
+			var err error
+			obj, err = filterGlobalPinInfo(pinfo, fields)
+			if err != nil {
+				fail(err)
+				return err
+			}
+		}
+		if err := enc.Encode(obj); err != nil {
+			logger.Errorf("error streaming the status: %s", err)
+			return err
+		}
+		sent++
+		if flusher != nil && sent%StreamPageSize == 0 {
+			flusher.Flush()
+		}
+		return nil
+	}
+
+	var pinInfos []api.GlobalPinInfoSerial
+	switch {
+	case page != nil:
+		var sp api.StatusPageSerial
+		err := rest.rpcClient.Call("",
+			"Cluster",
+			"StatusAllPage",
+			*page,
+			&sp)
+		if err != nil {
+			fail(err)
+			return
+		}
+		start(sp.Next)
+		pinInfos = sp.Items
+	case rest.statusSource != nil:
+		var sendErr error
+		err := rest.statusSource.StreamStatusAll(r.Context(), func(gpi api.GlobalPinInfo) error {
+			sendErr = send(gpi.ToSerial())
+			return sendErr
+		})
+		if err != nil && sendErr == nil {
+			fail(err)
+			return
+		}
+		start("")
+	default:
+		err := rest.callOp(r.Context(), "StatusAll", &pinInfos)
+		if err != nil {
+			fail(err)
+			return
+		}
+	}
+
+	for _, pinfo := range pinInfos {
+		if send(pinfo) != nil {
+			return
+		}
+	}
+	start("")
+}
+
+// parsePageOrError reads the "limit" and "after" query parameters. It
+// returns nil when neither is set, meaning that all items should be
+// sent. A 400 response is sent, and false returned, when they cannot
+// be parsed.
+func parsePageOrError(w http.ResponseWriter, r *http.Request) (*api.PageRequest, bool) {
+	q := r.URL.Query()
+	limitStr := q.Get("limit")
+	page := &api.PageRequest{
+		After: q.Get("after"),
+		Limit: DefaultPageLimit,
+	}
+	if limitStr == "" && page.After == "" {
+		return nil, true
+	}
+
+	if limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n <= 0 {
+			sendErrorResponse(w, 400, "error decoding limit: expected a positive integer")
+			return nil, false
+		}
+		page.Limit = n
+	}
+	if page.After != "" {
+		if _, err := cid.Decode(page.After); err != nil {
+			sendErrorResponse(w, 400, "error decoding after: "+err.Error())
+			return nil, false
+		}
+	}
+	return page, true
+}
+
+// statusChanges long-polls for changes in the global status since the
+// token given in the "since" parameter. The Cluster only reports the
+// changes and removals in the requested namespace, and the changes are
+// further restricted to the given set of Cids (see filterPins).
+// Removed Cids cannot be filtered by name.
+func (rest *RESTAPI) statusChanges(w http.ResponseWriter, r *http.Request, nsPins map[string]bool) {
+	ns, scoped := requestNamespace(r)
+	var changes api.StatusChangesSerial
+	err := rest.rpcClient.Call("",
+		"Cluster",
+		"StatusChanges",
+		api.StatusChangesRequest{
+			Token:     r.URL.Query().Get("since"),
+			Namespace: ns,
+			Scoped:    scoped,
+		},
+		&changes)
+	if nsPins != nil && err == nil {
+		filtered := make([]api.GlobalPinInfoSerial, 0, len(changes.Changed))
+		for _, pinfo := range changes.Changed {
+			if nsPins[pinfo.Cid] {
+				filtered = append(filtered, pinfo)
+			}
+		}
+		changes.Changed = filtered
+	}
+	sendResponse(w, err, changes)
+}
+
+func (rest *RESTAPI) statusHandler(w http.ResponseWriter, r *http.Request) {
+	if c := parseCidOrError(w, r); c.Cid != "" {
+		if !rest.waitForMinIndex(w, r) {
+			return
+		}
+		if !rest.inRequestNamespace(w, r, c.Cid) {
+			return
+		}
+		fields, ok := parseFieldsOrError(w, r, globalPinInfoFields, pinInfoFields)
+		if !ok {
+			return
+		}
+		var pinInfo api.GlobalPinInfoSerial
+		err := rest.rpcClient.Call("",
+			"Cluster",
+			"Status",
+			c,
+			&pinInfo)
+		if fields != nil && err == nil {
+			selected, err := filterGlobalPinInfo(pinInfo, fields)
+			sendResponse(w, err, selected)
+			return
+		}
+		sendResponse(w, err, pinInfo)
+	}
+}
+
+func (rest *RESTAPI) syncAllHandler(w http.ResponseWriter, r *http.Request) {
+	var pinInfos []api.GlobalPinInfoSerial
+	err := rest.callOp(r.Context(), "SyncAll", &pinInfos)
+	sendResponse(w, err, pinInfos)
+}
+
+func (rest *RESTAPI) syncHandler(w http.ResponseWriter, r *http.Request) {
+	if c := parseCidOrError(w, r); c.Cid != "" {
+		if !rest.inRequestNamespace(w, r, c.Cid) {
+			return
+		}
 		var pinInfo api.GlobalPinInfoSerial
 		err := rest.rpcClient.Call("",
 			"Cluster",
@@ -406,8 +1613,28 @@ func (rest *RESTAPI) syncHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (rest *RESTAPI) recoverAllHandler(w http.ResponseWriter, r *http.Request) {
+	var pinInfos []api.GlobalPinInfoSerial
+	err := rest.callOp(r.Context(), "RecoverAll", &pinInfos)
+	sendResponse(w, err, pinInfos)
+}
+
+// callOp runs a cancellable operation (see RPCAPI.CancelOp) in this
+// peer. It is cancelled when ctx is, i.e. when the client goes away.
+func (rest *RESTAPI) callOp(ctx context.Context, method string, reply interface{}) error {
+	id := newOpID()
+	err := rest.rpcClient.CallContext(ctx, "", "Cluster", method, id, reply)
+	if ctx.Err() != nil {
+		go rest.rpcClient.Call("", "Cluster", "CancelOp", id, &struct{}{})
+	}
+	return err
+}
+
 func (rest *RESTAPI) recoverHandler(w http.ResponseWriter, r *http.Request) {
 	if c := parseCidOrError(w, r); c.Cid != "" {
+		if !rest.inRequestNamespace(w, r, c.Cid) {
+			return
+		}
 		var pinInfo api.GlobalPinInfoSerial
 		err := rest.rpcClient.Call("",
 			"Cluster",
@@ -418,6 +1645,147 @@ func (rest *RESTAPI) recoverHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (rest *RESTAPI) verifyHandler(w http.ResponseWriter, r *http.Request) {
+	if c := parseCidOrError(w, r); c.Cid != "" {
+		var results []api.VerifyResultSerial
+		err := rest.rpcClient.Call("",
+			"Cluster",
+			"Verify",
+			c,
+			&results)
+		sendResponse(w, err, results)
+	}
+}
+
+func (rest *RESTAPI) servingPeerHandler(w http.ResponseWriter, r *http.Request) {
+	if c := parseCidOrError(w, r); c.Cid != "" {
+		var id api.IDSerial
+		err := rest.rpcClient.Call("",
+			"Cluster",
+			"ServingPeer",
+			c,
+			&id)
+		sendResponse(w, err, id)
+	}
+}
+
+func (rest *RESTAPI) allocationsHandler(w http.ResponseWriter, r *http.Request) {
+	if c := parseCidOrError(w, r); c.Cid != "" {
+		var carg api.CidArgSerial
+		err := rest.rpcClient.Call("",
+			"Cluster",
+			"Allocations",
+			c,
+			&carg)
+		if err == nil {
+			rest.setStaleStateHeader(w)
+		}
+		sendResponse(w, err, carg)
+	}
+}
+
+// setStaleStateHeader sets the StaleStateHeader when the shared state
+// known by this peer may be out of date.
+func (rest *RESTAPI) setStaleStateHeader(w http.ResponseWriter) {
+	var stale bool
+	err := rest.rpcClient.Call("",
+		"Cluster",
+		"StateStale",
+		struct{}{},
+		&stale)
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+	if stale {
+		w.Header().Set(StaleStateHeader, "true")
+	}
+}
+
+// allocationPreviewHandler shows where the given Cid would be allocated
+// with the replication factor in the "replication" query parameter,
+// or the default one when it is not set.
+func (rest *RESTAPI) allocationPreviewHandler(w http.ResponseWriter, r *http.Request) {
+	if c := parseCidOrError(w, r); c.Cid != "" {
+		if v := r.URL.Query().Get("replication"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				sendErrorResponse(w, 400, "error decoding replication: "+err.Error())
+				return
+			}
+			c.ReplicationFactorMin = n
+		}
+		var preview api.AllocationPreviewSerial
+		err := rest.rpcClient.Call("",
+			"Cluster",
+			"AllocationPreview",
+			c,
+			&preview)
+		sendResponse(w, err, preview)
+	}
+}
+
+func (rest *RESTAPI) reallocateHandler(w http.ResponseWriter, r *http.Request) {
+	if c := parseCidOrError(w, r); c.Cid != "" {
+		var body reallocateBody
+		if !rest.decodeBodyOrError(w, r, &body) {
+			return
+		}
+		if !checkPeersOrError(w, body.Allocations) {
+			return
+		}
+		c.Allocations = body.Allocations
+
+		var pinInfo api.GlobalPinInfoSerial
+		err := rest.rpcClient.Call("",
+			"Cluster",
+			"Reallocate",
+			c,
+			&pinInfo)
+		sendResponse(w, err, pinInfo)
+	}
+}
+
+// checkPeersOrError checks that the given allocations are valid peer
+// IDs. It sends a 400 response and returns false otherwise.
+func checkPeersOrError(w http.ResponseWriter, allocs []string) bool {
+	for _, p := range allocs {
+		if _, err := peer.IDB58Decode(p); err != nil {
+			sendError(w, errorResp{
+				Code:    400,
+				Message: "error decoding Peer ID: " + err.Error(),
+				Field:   "allocations",
+			})
+			return false
+		}
+	}
+	return true
+}
+
+// parseMultiaddrOrError parses a multiaddress given in the named field
+// of a request body. It sends a 400 response and returns false when it
+// is not valid.
+func parseMultiaddrOrError(w http.ResponseWriter, field, addr string) (ma.Multiaddr, bool) {
+	if addr == "" {
+		sendError(w, errorResp{
+			Code:    400,
+			Message: "missing " + field,
+			Field:   field,
+		})
+		return nil, false
+	}
+	mAddr, err := ma.NewMultiaddr(addr)
+	if err != nil {
+		sendError(w, errorResp{
+			Code:    400,
+			Message: "error decoding " + field + ": " + err.Error(),
+			Field:   field,
+		})
+		return nil, false
+	}
+	return mAddr, true
+}
+
 func parseCidOrError(w http.ResponseWriter, r *http.Request) api.CidArgSerial {
 	vars := mux.Vars(r)
 	hash := vars["hash"]
@@ -429,6 +1797,193 @@ func parseCidOrError(w http.ResponseWriter, r *http.Request) api.CidArgSerial {
 	return api.CidArgSerial{Cid: hash}
 }
 
+// requestNamespace returns the namespace requested with the
+// NamespaceHeader and whether the header was set at all.
+func requestNamespace(r *http.Request) (string, bool) {
+	values, ok := r.Header[http.CanonicalHeaderKey(NamespaceHeader)]
+	if !ok || len(values) == 0 {
+		return "", false
+	}
+	return values[0], true
+}
+
+// inRequestNamespace returns true if the Cid is pinned under the
+// namespace requested with the NamespaceHeader, or if the request is
+// not scoped to a namespace. Otherwise it sends a 404 error, the same
+// whether the Cid is pinned under another namespace or not at all.
+func (rest *RESTAPI) inRequestNamespace(w http.ResponseWriter, r *http.Request, c string) bool {
+	nsPins, ok := rest.namespacePins(w, r)
+	if !ok {
+		return false
+	}
+	if nsPins != nil && !nsPins[c] {
+		checkRPCErr(w, errNotInNamespace)
+		return false
+	}
+	return true
+}
+
+// namespacePins returns the set of Cids pinned under the namespace
+// requested with the NamespaceHeader, or nil when the request is not
+// scoped to a namespace. It returns false if an error response has
+// been sent.
+func (rest *RESTAPI) namespacePins(w http.ResponseWriter, r *http.Request) (map[string]bool, bool) {
+	ns, ok := requestNamespace(r)
+	if !ok {
+		return nil, true
+	}
+
+	var pins []api.CidArgSerial
+	err := rest.rpcClient.Call("",
+		"Cluster",
+		"PinListByNamespace",
+		ns,
+		&pins)
+	if !checkRPCErr(w, err) {
+		return nil, false
+	}
+
+	nsPins := make(map[string]bool, len(pins))
+	for _, p := range pins {
+		nsPins[p.Cid] = true
+	}
+	return nsPins, true
+}
+
+// metadataFilters returns the metadata filters given in the query of a
+// pin listing, with the same PinMetadataPrefix used when pinning, i.e.
+// "meta-owner=alice" lists the pins whose "owner" key is "alice".
+func metadataFilters(r *http.Request) []api.MetadataFilter {
+	var filters []api.MetadataFilter
+	for k, v := range r.URL.Query() {
+		key := strings.TrimPrefix(k, PinMetadataPrefix)
+		if key == k || key == "" {
+			continue
+		}
+		filters = append(filters, api.MetadataFilter{Key: key, Value: v[0]})
+	}
+	return filters
+}
+
+// matchMetadata returns true if the pin matches all the filters.
+func matchMetadata(p api.CidArgSerial, filters []api.MetadataFilter) bool {
+	for _, f := range filters {
+		if v, ok := p.Metadata[f.Key]; !ok || v != f.Value {
+			return false
+		}
+	}
+	return true
+}
+
+// filterPins returns the set of Cids which a status request is
+// restricted to, by namespace (see namespacePins) and by the "name"
+// query parameter, or nil when it is not restricted. It returns false
+// if an error response has been sent.
+func (rest *RESTAPI) filterPins(w http.ResponseWriter, r *http.Request) (map[string]bool, bool) {
+	nsPins, ok := rest.namespacePins(w, r)
+	if !ok {
+		return nil, false
+	}
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		return nsPins, true
+	}
+
+	var pins []api.CidArgSerial
+	err := rest.rpcClient.Call("",
+		"Cluster",
+		"PinListByName",
+		name,
+		&pins)
+	if !checkRPCErr(w, err) {
+		return nil, false
+	}
+
+	named := make(map[string]bool)
+	for _, p := range pins {
+		if nsPins == nil || nsPins[p.Cid] {
+			named[p.Cid] = true
+		}
+	}
+	return named, true
+}
+
+// checkLoad rejects the request with 429 and a Retry-After header when
+// the local pin queue is above the configured high-water mark. It returns
+// false if such response has been sent.
+func (rest *RESTAPI) checkLoad(w http.ResponseWriter) bool {
+	load, err := rest.trackerLoad()
+	if err != nil {
+		logger.Warningf("could not obtain tracker load: %s", err)
+		return true
+	}
+
+	if load.FillRatio() < rest.pinQueueHighWater {
+		return true
+	}
+
+	setRetryAfter(w, load)
+	sendErrorResponse(w, http.StatusTooManyRequests,
+		fmt.Sprintf("the pin queue is %d/%d full. Try again later",
+			load.QueueLength, load.QueueCapacity))
+	return false
+}
+
+// checkPinErr works like checkRPCErr, but pins rejected because the
+// pin queue is full (503) get a Retry-After header as well.
+func (rest *RESTAPI) checkPinErr(w http.ResponseWriter, err error) bool {
+	if err == nil {
+		return true
+	}
+	if _, msg := api.ErrorCode(err); msg == ErrPinQueueFull.Message {
+		if load, lerr := rest.trackerLoad(); lerr == nil {
+			setRetryAfter(w, load)
+		}
+	}
+	return checkRPCErr(w, err)
+}
+
+func (rest *RESTAPI) trackerLoad() (api.TrackerLoad, error) {
+	var load api.TrackerLoad
+	err := rest.rpcClient.Call("",
+		"Cluster",
+		"TrackerLoad",
+		struct{}{},
+		&load)
+	return load, err
+}
+
+// setRetryAfter sets the Retry-After header to the time it would take
+// to process the pin queue.
+func setRetryAfter(w http.ResponseWriter, load api.TrackerLoad) {
+	retry := int(math.Ceil(load.EstimatedWait().Seconds()))
+	if retry < 1 {
+		retry = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retry))
+}
+
+// waitForMinIndex honors the "min_index" query parameter by waiting
+// until the local state has applied that log index. It returns false
+// if an error response has been sent.
+func (rest *RESTAPI) waitForMinIndex(w http.ResponseWriter, r *http.Request) bool {
+	minIndex := r.URL.Query().Get("min_index")
+	if minIndex == "" {
+		return true
+	}
+	index, err := strconv.ParseUint(minIndex, 10, 64)
+	if err != nil {
+		sendErrorResponse(w, 400, "error parsing min_index: "+err.Error())
+		return false
+	}
+	err = rest.rpcClient.Call("",
+		"Cluster",
+		"WaitForIndex",
+		index,
+		&struct{}{})
+	return checkRPCErr(w, err)
+}
+
 func parsePidOrError(w http.ResponseWriter, r *http.Request) peer.ID {
 	vars := mux.Vars(r)
 	idStr := vars["peer"]
@@ -448,15 +2003,25 @@ func sendResponse(w http.ResponseWriter, rpcErr error, resp interface{}) {
 
 // checkRPCErr takes care of returning standard error responses if we
 // pass an error to it. It returns true when everythings OK (no error
-// was handled), or false otherwise.
+// was handled), or false otherwise. The code carried by api.Error
+// errors is used as the response status, and 500 otherwise.
 func checkRPCErr(w http.ResponseWriter, err error) bool {
 	if err != nil {
-		sendErrorResponse(w, 500, err.Error())
+		sendError(w, errorRespFor(err))
 		return false
 	}
 	return true
 }
 
+// errorRespFor returns the errorResp describing an RPC error.
+func errorRespFor(err error) errorResp {
+	code, msg := api.ErrorCode(err)
+	if code < 400 || code > 599 {
+		code = 500
+	}
+	return errorResp{Code: code, Message: msg}
+}
+
 func sendEmptyResponse(w http.ResponseWriter, rpcErr error) {
 	if checkRPCErr(w, rpcErr) {
 		w.WriteHeader(http.StatusNoContent)
@@ -477,7 +2042,10 @@ func sendJSONResponse(w http.ResponseWriter, code int, resp interface{}) {
 }
 
 func sendErrorResponse(w http.ResponseWriter, code int, msg string) {
-	errorResp := errorResp{code, msg}
-	logger.Errorf("sending error response: %d: %s", code, msg)
-	sendJSONResponse(w, code, errorResp)
+	sendError(w, errorResp{Code: code, Message: msg})
+}
+
+func sendError(w http.ResponseWriter, e errorResp) {
+	logger.Errorf("sending error response: %d: %s", e.Code, e.Message)
+	sendJSONResponse(w, e.Code, e)
 }
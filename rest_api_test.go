@@ -1,15 +1,35 @@
 package ipfscluster
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math/big"
+	"mime/multipart"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/ipfs/ipfs-cluster/api"
 	"github.com/ipfs/ipfs-cluster/test"
+
+	cid "github.com/ipfs/go-cid"
 )
 
 var (
@@ -60,6 +80,22 @@ func makePost(t *testing.T, path string, body []byte, resp interface{}) {
 	processResp(t, httpResp, err, resp)
 }
 
+func makeGetNamespace(t *testing.T, path, ns string, resp interface{}) {
+	req, _ := http.NewRequest("GET", apiHost+path, nil)
+	req.Header.Set(NamespaceHeader, ns)
+	c := &http.Client{}
+	httpResp, err := c.Do(req)
+	processResp(t, httpResp, err, resp)
+}
+
+func makePostNamespace(t *testing.T, path, ns string, resp interface{}) {
+	req, _ := http.NewRequest("POST", apiHost+path, bytes.NewReader([]byte{}))
+	req.Header.Set(NamespaceHeader, ns)
+	c := &http.Client{}
+	httpResp, err := c.Do(req)
+	processResp(t, httpResp, err, resp)
+}
+
 func makeDelete(t *testing.T, path string, resp interface{}) {
 	req, _ := http.NewRequest("DELETE", apiHost+path, bytes.NewReader([]byte{}))
 	c := &http.Client{}
@@ -95,6 +131,140 @@ func TestRESTAPIVersionEndpoint(t *testing.T) {
 	if ver.Version != "0.0.mock" {
 		t.Error("expected correct version")
 	}
+	if ver.Commit != "mock" || ver.RPCProtocolVersion != "/ipfscluster/0.0.mock/rpc" || ver.GoVersion != "go0.mock" {
+		t.Errorf("expected the commit, protocol and Go versions: %+v", ver)
+	}
+}
+
+func TestRESTAPIHealthEndpoint(t *testing.T) {
+	rest := testRESTAPI(t)
+	defer rest.Shutdown()
+	var h api.Health
+	makeGet(t, "/health", &h)
+	if h.DiskFree == 0 || h.LowDiskSpace || h.ReadOnly {
+		t.Error("unexpected health:", h)
+	}
+}
+
+func TestRESTAPISummaryEndpoint(t *testing.T) {
+	rest := testRESTAPI(t)
+	defer rest.Shutdown()
+	var s api.Summary
+	makeGet(t, "/summary", &s)
+	if s.Pins != 3 || s.Status["pinned"] != 2 || s.Leader != test.TestPeerID1.Pretty() {
+		t.Error("unexpected summary:", s)
+	}
+}
+
+func TestRESTAPIQueueInfoEndpoint(t *testing.T) {
+	rest := testRESTAPI(t)
+	defer rest.Shutdown()
+	var q api.QueueInfo
+	makeGet(t, "/queue", &q)
+	if q.PinQueueLength != 10 || q.PinQueueCapacity != 1024 || q.UnpinQueueCapacity != 1024 {
+		t.Error("unexpected queue info:", q)
+	}
+}
+
+func TestRESTAPIConsistencyEndpoint(t *testing.T) {
+	rest := testRESTAPI(t)
+	defer rest.Shutdown()
+	var report api.ConsistencyReportSerial
+	makeGet(t, "/consensus/consistency", &report)
+	if !report.Consistent || len(report.Views) != 1 {
+		t.Error("unexpected report:", report)
+	}
+	if report.Views[0].Peer != test.TestPeerID1.Pretty() {
+		t.Error("unexpected peer in report")
+	}
+}
+
+func TestRESTAPIConsensusPeersEndpoint(t *testing.T) {
+	rest := testRESTAPI(t)
+	defer rest.Shutdown()
+	var servers []api.RaftServerSerial
+	makeGet(t, "/consensus/peers", &servers)
+	if len(servers) != 2 {
+		t.Fatal("expected 2 servers:", servers)
+	}
+	if !servers[0].Leader || servers[0].Suffrage != api.RaftVoter {
+		t.Error("unexpected server:", servers[0])
+	}
+}
+
+func TestRESTAPIConsensusSnapshotEndpoint(t *testing.T) {
+	rest := testRESTAPI(t)
+	defer rest.Shutdown()
+	resp, err := http.Post(apiHost+"/consensus/snapshot", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Error("expected 204 but got", resp.StatusCode)
+	}
+}
+
+func TestRESTAPIConsensusLeaveEndpoint(t *testing.T) {
+	rest := testRESTAPI(t)
+	defer rest.Shutdown()
+	resp, err := http.Post(apiHost+"/consensus/leave", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Error("expected 204 but got", resp.StatusCode)
+	}
+}
+
+func TestRESTAPIStateExportImportEndpoints(t *testing.T) {
+	rest := testRESTAPI(t)
+	defer rest.Shutdown()
+
+	var st map[string]interface{}
+	makeGet(t, "/state/export", &st)
+	if _, ok := st["PinMap"]; !ok {
+		t.Fatal("expected a PinMap in the exported state")
+	}
+
+	data, _ := json.Marshal(st)
+	resp, err := http.Post(apiHost+"/state/import", "application/json", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Error("expected 204 but got", resp.StatusCode)
+	}
+
+	errResp := errorResp{}
+	makePost(t, "/state/import", []byte("{abc"), &errResp)
+	if errResp.Code != 400 {
+		t.Error("expected an error importing a bad state")
+	}
+
+	maxSize := RESTAPIMaxStateSize
+	RESTAPIMaxStateSize = int64(len(data) - 1)
+	defer func() { RESTAPIMaxStateSize = maxSize }()
+	errResp = errorResp{}
+	makePost(t, "/state/import", data, &errResp)
+	if errResp.Code != http.StatusRequestEntityTooLarge {
+		t.Error("expected 413 importing a state which is too large")
+	}
+}
+
+func TestRESTAPIReconcilePlanEndpoint(t *testing.T) {
+	rest := testRESTAPI(t)
+	defer rest.Shutdown()
+	var plan api.ReconcilePlanSerial
+	makeGet(t, "/reconcile/plan", &plan)
+	if len(plan.Actions) != 2 {
+		t.Fatal("expected 2 actions:", plan)
+	}
+	if plan.Actions[0].Action != api.ReconcilePin || plan.Actions[0].Cid != test.TestCid1 {
+		t.Error("unexpected action:", plan.Actions[0])
+	}
 }
 
 func TestRESTAPIPeerstEndpoint(t *testing.T) {
@@ -111,6 +281,27 @@ func TestRESTAPIPeerstEndpoint(t *testing.T) {
 	}
 }
 
+func TestRESTAPIPeerInfoEndpoint(t *testing.T) {
+	rest := testRESTAPI(t)
+	defer rest.Shutdown()
+
+	id := api.IDSerial{}
+	makeGet(t, "/peers/"+test.TestPeerID1.Pretty(), &id)
+	if id.ID != test.TestPeerID1.Pretty() {
+		t.Error("expected the peer's ID, got ", id.ID)
+	}
+
+	errResp := errorResp{}
+	makeGet(t, "/peers/"+test.TestPeerID2.Pretty(), &errResp)
+	if errResp.Code != 404 {
+		t.Error("expected a 404 for a peer which is not in the cluster")
+	}
+	makeGet(t, "/peers/abc", &errResp)
+	if errResp.Code != 400 {
+		t.Error("expected a 400 for an invalid peer ID")
+	}
+}
+
 func TestRESTAPIPeerAddEndpoint(t *testing.T) {
 	rest := testRESTAPI(t)
 	defer rest.Shutdown()
@@ -128,14 +319,52 @@ func TestRESTAPIPeerAddEndpoint(t *testing.T) {
 		t.Error("did not expect an error")
 	}
 
-	// Send invalid body
+	// unreachable peers are only added when forced
 	errResp := errorResp{}
+	body = fmt.Sprintf("{\"peer_multiaddress\":\"/ip4/1.2.3.5/tcp/1234/ipfs/%s\"}", test.TestPeerID2.Pretty())
+	makePost(t, "/peers", []byte(body), &errResp)
+	if errResp.Code != 400 {
+		t.Error("expected an error with an unreachable peer")
+	}
+	id = api.IDSerial{}
+	makePost(t, "/peers?force=true", []byte(body), &id)
+	if id.Error != "" {
+		t.Error("did not expect an error when forcing")
+	}
+
+	// Send invalid body
+	errResp = errorResp{}
 	makePost(t, "/peers", []byte("oeoeoeoe"), &errResp)
 	if errResp.Code != 400 {
 		t.Error("expected error with bad body")
 	}
 	// Send invalid multiaddr
 	makePost(t, "/peers", []byte("{\"peer_multiaddress\": \"ab\"}"), &errResp)
+	if errResp.Code != 400 || errResp.Field != "peer_multiaddress" {
+		t.Error("expected error with bad multiaddress")
+	}
+}
+
+func TestRESTAPIPeerReplaceEndpoint(t *testing.T) {
+	rest := testRESTAPI(t)
+	defer rest.Shutdown()
+
+	pr := api.PeerReplacementSerial{}
+	body := fmt.Sprintf("{\"old_peer_multiaddress\":\"/ip4/1.2.3.4/tcp/1234/ipfs/%s\",\"new_peer_multiaddress\":\"/ip4/1.2.3.5/tcp/1234/ipfs/%s\"}",
+		test.TestPeerID1.Pretty(), test.TestPeerID2.Pretty())
+	makePost(t, "/peers/replace", []byte(body), &pr)
+	if pr.OldPeer != test.TestPeerID1.Pretty() || pr.NewPeer != test.TestPeerID2.Pretty() {
+		t.Error("expected the old and new peers in the response")
+	}
+
+	pr = api.PeerReplacementSerial{}
+	makeGet(t, "/peers/replace", &pr)
+	if pr.Phase != api.PeerReplacementPinning || pr.Total != 3 || pr.Pinned != 1 {
+		t.Error("unexpected replacement progress:", pr)
+	}
+
+	errResp := errorResp{}
+	makePost(t, "/peers/replace", []byte("{\"old_peer_multiaddress\": \"ab\"}"), &errResp)
 	if errResp.Code != 400 {
 		t.Error("expected error with bad multiaddress")
 	}
@@ -153,7 +382,11 @@ func TestRESTAPIPinEndpoint(t *testing.T) {
 	defer rest.Shutdown()
 
 	// test regular post
-	makePost(t, "/pins/"+test.TestCid1, []byte{}, &struct{}{})
+	var resp pinResp
+	makePost(t, "/pins/"+test.TestCid1, []byte{}, &resp)
+	if resp.Index != test.TestLogIndex {
+		t.Error("expected the committed log index in the response")
+	}
 
 	errResp := errorResp{}
 	makePost(t, "/pins/"+test.ErrorCid, []byte{}, &errResp)
@@ -167,118 +400,1276 @@ func TestRESTAPIPinEndpoint(t *testing.T) {
 	}
 }
 
-func TestRESTAPIUnpinEndpoint(t *testing.T) {
+func TestRESTAPIPinPathEndpoint(t *testing.T) {
 	rest := testRESTAPI(t)
 	defer rest.Shutdown()
 
-	// test regular delete
-	makeDelete(t, "/pins/"+test.TestCid1, &struct{}{})
+	var resp pinResp
+	body := []byte(`{"path": "/ipns/` + test.TestIPNSName + `"}`)
+	makePost(t, "/pins", body, &resp)
+	if resp.Cid != test.TestCid1 || resp.Index != test.TestLogIndex {
+		t.Errorf("unexpected response: %+v", resp)
+	}
 
 	errResp := errorResp{}
-	makeDelete(t, "/pins/"+test.ErrorCid, &errResp)
+	makePost(t, "/pins", []byte(`{}`), &errResp)
+	if errResp.Code != 400 || errResp.Field != "path" {
+		t.Error("expected an error without a path")
+	}
+
+	errResp = errorResp{}
+	makePost(t, "/pins", []byte(`{"path": "/ipns/`+test.ErrorCid+`"}`), &errResp)
 	if errResp.Message != test.ErrBadCid.Error() {
 		t.Error("expected different error: ", errResp.Message)
 	}
 
-	makeDelete(t, "/pins/abcd", &errResp)
+	resp = pinResp{}
+	body, _ = json.Marshal(pinPathBody{
+		Path:        "/ipns/" + test.TestIPNSName,
+		Allocations: []string{test.TestPeerID1.Pretty(), test.TestPeerID2.Pretty()},
+	})
+	makePost(t, "/pins", body, &resp)
+	if resp.Cid != test.TestCid1 || resp.Index != test.TestLogIndex {
+		t.Errorf("unexpected response pinning with allocations: %+v", resp)
+	}
+
+	errResp = errorResp{}
+	body, _ = json.Marshal(pinPathBody{
+		Path:        "/ipns/" + test.TestIPNSName,
+		Allocations: []string{"QmUQ6Nsejt1SuZAu8yL8WgqQZHHAYreLVYYa4VPsLUCed7"},
+	})
+	makePost(t, "/pins", body, &errResp)
 	if errResp.Code != 400 {
-		t.Error("should fail with bad Cid")
+		t.Error("expected a 400 error when allocating to an unknown peer: ", errResp)
 	}
 }
 
-func TestRESTAPIPinListEndpoint(t *testing.T) {
+func TestRESTAPIPinBatchEndpoint(t *testing.T) {
 	rest := testRESTAPI(t)
 	defer rest.Shutdown()
 
-	var resp []api.CidArgSerial
-	makeGet(t, "/pinlist", &resp)
-	if len(resp) != 3 ||
-		resp[0].Cid != test.TestCid1 || resp[1].Cid != test.TestCid2 ||
-		resp[2].Cid != test.TestCid3 {
-		t.Error("unexpected pin list: ", resp)
+	body, _ := json.Marshal([]string{test.TestCid1, "abcd", test.ErrorCid, test.TestCid2})
+	var resp []api.PinResultSerial
+	makePost(t, "/pins/batch", body, &resp)
+	if len(resp) != 4 {
+		t.Fatalf("expected 4 results, got %d", len(resp))
+	}
+	for _, i := range []int{0, 3} {
+		if resp[i].Error != "" || resp[i].Index != test.TestLogIndex {
+			t.Errorf("%s should have been pinned: %+v", resp[i].Cid, resp[i])
+		}
+	}
+	if resp[1].Cid != "abcd" || resp[1].Error == "" {
+		t.Error("expected an error decoding the second Cid")
+	}
+	if resp[2].Error != test.ErrBadCid.Error() {
+		t.Error("expected an error pinning the third Cid: ", resp[2].Error)
+	}
+
+	errResp := errorResp{}
+	makePost(t, "/pins/batch", []byte(`{"cid": "abcd"}`), &errResp)
+	if errResp.Code != 400 {
+		t.Error("should fail when the body is not an array")
 	}
 }
 
-func TestRESTAPIStatusAllEndpoint(t *testing.T) {
+func TestListenUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "restapi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "file")
+	ioutil.WriteFile(file, []byte("abc"), 0600)
+	if _, err := listenUnix(file); err == nil {
+		t.Error("should not replace files which are not sockets")
+	}
+
+	path := filepath.Join(dir, "api.sock")
+	l, err := listenUnix(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	if _, err := listenUnix(path); err == nil {
+		t.Error("should not replace a socket in use")
+	}
+}
+
+func TestParseReplicationFactors(t *testing.T) {
+	testcases := []struct {
+		query    string
+		min, max int
+		ok       bool
+	}{
+		{"", 0, 0, true},
+		{"replication=3", 3, 3, true},
+		{"replication=-1", -1, -1, true},
+		{"replication=3&replication_max=5", 3, 5, true},
+		{"replication_min=2&replication_max=4", 2, 4, true},
+		{"replication=abc", 0, 0, false},
+	}
+
+	for _, tc := range testcases {
+		r, _ := http.NewRequest("POST", "/pins/"+test.TestCid1+"?"+tc.query, nil)
+		w := httptest.NewRecorder()
+		var c api.CidArgSerial
+		ok := parseReplicationFactors(w, r, &c)
+		if ok != tc.ok {
+			t.Errorf("%q: expected ok=%t", tc.query, tc.ok)
+			continue
+		}
+		if !ok {
+			if w.Code != 400 {
+				t.Errorf("%q: expected a 400 response", tc.query)
+			}
+			continue
+		}
+		if c.ReplicationFactorMin != tc.min || c.ReplicationFactorMax != tc.max {
+			t.Errorf("%q: got min %d and max %d", tc.query,
+				c.ReplicationFactorMin, c.ReplicationFactorMax)
+		}
+	}
+}
+
+func TestRESTAPIAuthenticate(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	open := (&RESTAPI{}).authenticate(ok)
+	w := httptest.NewRecorder()
+	open.ServeHTTP(w, httptest.NewRequest("GET", "/id", nil))
+	if w.Code != http.StatusOK {
+		t.Error("the API should be open without credentials")
+	}
+
+	rest := &RESTAPI{
+		authToken:        "secret",
+		basicAuth:        map[string]string{"alice": "pass"},
+		authExemptHealth: true,
+	}
+	h := rest.authenticate(ok)
+
+	testcases := []struct {
+		name     string
+		path     string
+		auth     func(r *http.Request)
+		expected int
+	}{
+		{"no credentials", "/id", func(r *http.Request) {}, 401},
+		{"exempt health", "/health", func(r *http.Request) {}, 200},
+		{"good token", "/id", func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer secret")
+		}, 200},
+		{"bad token", "/id", func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer secreT")
+		}, 401},
+		{"good password", "/id", func(r *http.Request) {
+			r.SetBasicAuth("alice", "pass")
+		}, 200},
+		{"bad password", "/id", func(r *http.Request) {
+			r.SetBasicAuth("alice", "secret")
+		}, 401},
+		{"unknown user", "/id", func(r *http.Request) {
+			r.SetBasicAuth("bob", "pass")
+		}, 401},
+	}
+	for _, tc := range testcases {
+		r := httptest.NewRequest("GET", tc.path, nil)
+		tc.auth(r)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tc.expected {
+			t.Errorf("%s: expected %d but got %d", tc.name, tc.expected, w.Code)
+		}
+		if w.Code == 401 && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: expected a WWW-Authenticate header", tc.name)
+		}
+	}
+}
+
+func TestParsePinOptions(t *testing.T) {
+	r, _ := http.NewRequest("POST", "/pins/"+test.TestCid1+"?pin_timeout=90m&no_fetch=true&name=backup&meta-owner=alice&meta-env=prod&type=direct", nil)
+	r.Header.Set(NamespaceHeader, test.TestNamespace)
+	w := httptest.NewRecorder()
+	var c api.CidArgSerial
+	if !parsePinOptions(w, r, &c) {
+		t.Fatal("options should parse")
+	}
+	if c.PinTimeout != "1h30m0s" || !c.NoFetch || c.Namespace != test.TestNamespace ||
+		c.Type != "direct" {
+		t.Errorf("unexpected options: %+v", c)
+	}
+	if c.Name != "backup" || len(c.Metadata) != 2 ||
+		c.Metadata["owner"] != "alice" || c.Metadata["env"] != "prod" {
+		t.Errorf("unexpected name or metadata: %s %v", c.Name, c.Metadata)
+	}
+
+	for _, q := range []string{"pin_timeout=abc", "pin_timeout=-1m", "replication_min=x", "meta-=x", "type=indirect"} {
+		r, _ = http.NewRequest("POST", "/pins/"+test.TestCid1+"?"+q, nil)
+		w = httptest.NewRecorder()
+		if parsePinOptions(w, r, &c) || w.Code != 400 {
+			t.Errorf("%q: expected a 400 response", q)
+		}
+	}
+}
+
+func TestParsePageOrError(t *testing.T) {
+	testcases := []struct {
+		query string
+		page  *api.PageRequest
+		ok    bool
+	}{
+		{"", nil, true},
+		{"limit=10", &api.PageRequest{Limit: 10}, true},
+		{"after=" + test.TestCid1, &api.PageRequest{After: test.TestCid1, Limit: DefaultPageLimit}, true},
+		{"limit=0", nil, false},
+		{"limit=abc", nil, false},
+	}
+
+	for _, tc := range testcases {
+		r, _ := http.NewRequest("GET", "/pins?"+tc.query, nil)
+		w := httptest.NewRecorder()
+		page, ok := parsePageOrError(w, r)
+		if ok != tc.ok {
+			t.Errorf("%q: expected ok=%t", tc.query, tc.ok)
+			continue
+		}
+		if !ok {
+			if w.Code != 400 {
+				t.Errorf("%q: expected a 400 response", tc.query)
+			}
+			continue
+		}
+		if (page == nil) != (tc.page == nil) || page != nil && *page != *tc.page {
+			t.Errorf("%q: unexpected page %+v", tc.query, page)
+		}
+	}
+}
+
+func TestCheckRPCErr(t *testing.T) {
+	testcases := []struct {
+		err  error
+		code int
+	}{
+		{api.NewError(404, "not found"), 404},
+		{errors.New("no leader (code 503)"), 503},
+		{api.NewError(200, "bad code"), 500},
+		{errors.New("plain"), 500},
+	}
+	for _, tc := range testcases {
+		w := httptest.NewRecorder()
+		if checkRPCErr(w, tc.err) {
+			t.Fatal("expected false")
+		}
+		var errResp errorResp
+		json.Unmarshal(w.Body.Bytes(), &errResp)
+		if w.Code != tc.code || errResp.Code != tc.code {
+			t.Errorf("%q: expected %d but got %d", tc.err, tc.code, w.Code)
+		}
+		if strings.Contains(errResp.Message, "(code") {
+			t.Errorf("%q: the code should be removed from the message", tc.err)
+		}
+	}
+}
+
+func TestRESTAPIPinEndpointAcks(t *testing.T) {
 	rest := testRESTAPI(t)
 	defer rest.Shutdown()
 
-	var resp []api.GlobalPinInfoSerial
-	makeGet(t, "/pins", &resp)
-	if len(resp) != 3 ||
-		resp[0].Cid != test.TestCid1 ||
-		resp[1].PeerMap[test.TestPeerID1.Pretty()].Status != "pinning" {
-		t.Errorf("unexpected statusResp:\n %+v", resp)
+	var resp pinResp
+	makePost(t, "/pins/"+test.TestCid1+"?acks=true", []byte{}, &resp)
+	if len(resp.Acks) != 2 {
+		t.Fatal("expected 2 acknowledgements")
+	}
+	if !resp.Acks[0].Accepted || resp.Acks[0].Peer != test.TestPeerID1.Pretty() {
+		t.Error("expected first peer to accept the pin")
+	}
+	if resp.Acks[1].Accepted || resp.Acks[1].Error == "" {
+		t.Error("expected second peer to reject the pin")
+	}
+
+	// No acks unless requested
+	resp = pinResp{}
+	makePost(t, "/pins/"+test.TestCid1, []byte{}, &resp)
+	if len(resp.Acks) != 0 {
+		t.Error("acks should only be collected when requested")
 	}
 }
 
-func TestRESTAPIStatusEndpoint(t *testing.T) {
+func TestRESTAPIVerifyEndpoint(t *testing.T) {
 	rest := testRESTAPI(t)
 	defer rest.Shutdown()
 
-	var resp api.GlobalPinInfoSerial
-	makeGet(t, "/pins/"+test.TestCid1, &resp)
-
-	if resp.Cid != test.TestCid1 {
-		t.Error("expected the same cid")
+	var results []api.VerifyResultSerial
+	makePost(t, "/pins/"+test.TestCid1+"/verify", []byte{}, &results)
+	if len(results) != 2 {
+		t.Fatal("expected 2 results")
 	}
-	info, ok := resp.PeerMap[test.TestPeerID1.Pretty()]
-	if !ok {
-		t.Fatal("expected info for test.TestPeerID1")
+	if !results[0].Valid || results[0].Peer != test.TestPeerID1.Pretty() {
+		t.Error("expected first peer to be valid")
 	}
-	if info.Status != "pinned" {
-		t.Error("expected different status")
+	if results[1].Valid || results[1].Error == "" {
+		t.Error("expected second peer to fail verification")
+	}
+
+	errResp := errorResp{}
+	makePost(t, "/pins/"+test.ErrorCid+"/verify", []byte{}, &errResp)
+	if errResp.Message != test.ErrBadCid.Error() {
+		t.Error("expected different error: ", errResp.Message)
 	}
 }
 
-func TestRESTAPISyncAllEndpoint(t *testing.T) {
+func TestRESTAPIAllocationsEndpoint(t *testing.T) {
 	rest := testRESTAPI(t)
 	defer rest.Shutdown()
 
-	var resp []api.GlobalPinInfoSerial
-	makePost(t, "/pins/sync", []byte{}, &resp)
+	var carg api.CidArgSerial
+	makeGet(t, "/pins/"+test.TestCid1+"/allocations", &carg)
+	if carg.Cid != test.TestCid1 || len(carg.Allocations) != 2 || carg.Everywhere {
+		t.Errorf("unexpected allocations: %+v", carg)
+	}
 
-	if len(resp) != 3 ||
-		resp[0].Cid != test.TestCid1 ||
-		resp[1].PeerMap[test.TestPeerID1.Pretty()].Status != "pinning" {
-		t.Errorf("unexpected statusResp:\n %+v", resp)
+	errResp := errorResp{}
+	makeGet(t, "/pins/"+test.ErrorCid+"/allocations", &errResp)
+	if errResp.Code != 404 {
+		t.Error("expected 404 for a cid which is not pinned")
 	}
 }
 
-func TestRESTAPISyncEndpoint(t *testing.T) {
+func TestRESTAPIIPFSBandwidthEndpoint(t *testing.T) {
 	rest := testRESTAPI(t)
 	defer rest.Shutdown()
 
-	var resp api.GlobalPinInfoSerial
-	makePost(t, "/pins/"+test.TestCid1+"/sync", []byte{}, &resp)
+	var bw api.IPFSBandwidth
+	makeGet(t, "/ipfs/bandwidth", &bw)
+	if bw.TotalIn != test.TestBandwidthTotalIn || bw.RateOut != test.TestBandwidthRateOut {
+		t.Errorf("unexpected bandwidth stats: %+v", bw)
+	}
+}
 
-	if resp.Cid != test.TestCid1 {
-		t.Error("expected the same cid")
+func TestRESTAPIAllocationPreviewEndpoint(t *testing.T) {
+	rest := testRESTAPI(t)
+	defer rest.Shutdown()
+
+	var preview api.AllocationPreviewSerial
+	makeGet(t, "/allocations/preview/"+test.TestCid1, &preview)
+	if preview.Cid != test.TestCid1 || len(preview.Candidates) != 3 ||
+		len(preview.Allocations) != 2 || preview.Error != "" {
+		t.Errorf("unexpected preview: %+v", preview)
 	}
-	info, ok := resp.PeerMap[test.TestPeerID1.Pretty()]
-	if !ok {
-		t.Fatal("expected info for test.TestPeerID1")
+	if preview.Candidates[0].Value != "2000" {
+		t.Error("expected the metric values of the candidates")
 	}
-	if info.Status != "pinned" {
-		t.Error("expected different status")
+
+	preview = api.AllocationPreviewSerial{}
+	makeGet(t, "/allocations/preview/"+test.TestCid1+"?replication=1", &preview)
+	if len(preview.Allocations) != 1 {
+		t.Errorf("expected 1 allocation: %+v", preview)
+	}
+
+	errResp := errorResp{}
+	makeGet(t, "/allocations/preview/"+test.TestCid1+"?replication=abc", &errResp)
+	if errResp.Code != 400 {
+		t.Error("expected 400 for a bad replication factor")
 	}
 }
 
-func TestRESTAPIRecoverEndpoint(t *testing.T) {
+func TestRESTAPIServingPeerEndpoint(t *testing.T) {
 	rest := testRESTAPI(t)
 	defer rest.Shutdown()
 
-	var resp api.GlobalPinInfoSerial
-	makePost(t, "/pins/"+test.TestCid1+"/recover", []byte{}, &resp)
+	var id api.IDSerial
+	makeGet(t, "/pins/"+test.TestCid1+"/serving_peer", &id)
+	if id.ID != test.TestPeerID1.Pretty() {
+		t.Error("expected a different serving peer:", id.ID)
+	}
 
-	if resp.Cid != test.TestCid1 {
-		t.Error("expected the same cid")
+	errResp := errorResp{}
+	makeGet(t, "/pins/"+test.ErrorCid+"/serving_peer", &errResp)
+	if errResp.Message != test.ErrBadCid.Error() {
+		t.Error("expected different error: ", errResp.Message)
 	}
-	info, ok := resp.PeerMap[test.TestPeerID1.Pretty()]
-	if !ok {
-		t.Fatal("expected info for test.TestPeerID1")
+}
+
+func TestRESTAPIPinEndpointBackpressure(t *testing.T) {
+	rest := testRESTAPI(t)
+	defer rest.Shutdown()
+
+	// The mock tracker queue is half full
+	rest.pinQueueHighWater = 0.5
+
+	httpResp, err := http.Post(apiHost+"/pins/"+test.TestCid1, "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusTooManyRequests {
+		t.Error("expected 429 when the pin queue is above the high-water mark")
+	}
+	if httpResp.Header.Get("Retry-After") != "5" {
+		t.Error("expected a Retry-After header with the estimated wait")
+	}
+}
+
+func TestRESTAPIPinEndpointQueueFull(t *testing.T) {
+	rest := testRESTAPI(t)
+	defer rest.Shutdown()
+
+	httpResp, err := http.Post(apiHost+"/pins/"+test.QueueFullCid, "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusServiceUnavailable {
+		t.Error("expected 503 when the pin queue is full, got", httpResp.StatusCode)
+	}
+	if httpResp.Header.Get("Retry-After") != "5" {
+		t.Error("expected a Retry-After header with the estimated wait")
+	}
+}
+
+func TestRESTAPIUnpinEndpoint(t *testing.T) {
+	rest := testRESTAPI(t)
+	defer rest.Shutdown()
+
+	// test regular delete
+	makeDelete(t, "/pins/"+test.TestCid1, &struct{}{})
+
+	errResp := errorResp{}
+	makeDelete(t, "/pins/"+test.ErrorCid, &errResp)
+	if errResp.Message != test.ErrBadCid.Error() {
+		t.Error("expected different error: ", errResp.Message)
+	}
+
+	makeDelete(t, "/pins/abcd", &errResp)
+	if errResp.Code != 400 {
+		t.Error("should fail with bad Cid")
+	}
+
+	// test forced delete
+	makeDelete(t, "/pins/"+test.TestCid1+"?force=true", &struct{}{})
+
+	errResp = errorResp{}
+	makeDelete(t, "/pins/"+test.ErrorCid+"?force=true", &errResp)
+	if errResp.Message != test.ErrBadCid.Error() {
+		t.Error("expected different error: ", errResp.Message)
+	}
+
+	// test protected pin
+	errResp = errorResp{}
+	makeDelete(t, "/pins/"+test.TestCid2, &errResp)
+	if errResp.Code != 403 {
+		t.Error("expected 403 when unpinning a protected pin")
+	}
+
+	// test conditional delete
+	makeDelete(t, "/pins/"+test.TestCid1+"?if_healthy=true", &struct{}{})
+
+	errResp = errorResp{}
+	makeDelete(t, "/pins/"+test.TestCid3+"?if_healthy=true", &errResp)
+	if errResp.Code != 409 {
+		t.Error("expected 409 when the pin is not healthy")
+	}
+}
+
+func TestRESTAPIProtectEndpoint(t *testing.T) {
+	rest := testRESTAPI(t)
+	defer rest.Shutdown()
+
+	makePost(t, "/pins/"+test.TestCid1+"/protect", []byte{}, &struct{}{})
+	makeDelete(t, "/pins/"+test.TestCid1+"/protect", &struct{}{})
+
+	errResp := errorResp{}
+	makePost(t, "/pins/"+test.ErrorCid+"/protect", []byte{}, &errResp)
+	if errResp.Message != test.ErrBadCid.Error() {
+		t.Error("expected different error: ", errResp.Message)
+	}
+}
+
+func TestRESTAPIPinListEndpoint(t *testing.T) {
+	rest := testRESTAPI(t)
+	defer rest.Shutdown()
+
+	var resp []api.CidArgSerial
+	makeGet(t, "/pinlist", &resp)
+	if len(resp) != 3 ||
+		resp[0].Cid != test.TestCid1 || resp[1].Cid != test.TestCid2 ||
+		resp[2].Cid != test.TestCid3 {
+		t.Error("unexpected pin list: ", resp)
+	}
+}
+
+func TestRESTAPIStaleStateHeader(t *testing.T) {
+	rest := testRESTAPI(t)
+	defer rest.Shutdown()
+
+	// The mocked state is always stale
+	for _, path := range []string{"/pinlist", "/pins/local", "/pins/" + test.TestCid1 + "/allocations"} {
+		resp, err := http.Get(apiHost + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.Header.Get(StaleStateHeader) != "true" {
+			t.Errorf("%s: expected the %s header", path, StaleStateHeader)
+		}
+	}
+
+	resp, err := http.Get(apiHost + "/pins/" + test.ErrorCid + "/allocations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get(StaleStateHeader) != "" {
+		t.Error("errors should not carry the stale state header")
+	}
+}
+
+func TestRESTAPINamespace(t *testing.T) {
+	rest := testRESTAPI(t)
+	defer rest.Shutdown()
+
+	var pins []api.CidArgSerial
+	makeGetNamespace(t, "/pinlist", test.TestNamespace, &pins)
+	if len(pins) != 1 || pins[0].Cid != test.TestCid3 {
+		t.Error("expected only the pins in the namespace: ", pins)
+	}
+
+	var statuses []api.GlobalPinInfoSerial
+	makeGetNamespace(t, "/pins", test.TestNamespace, &statuses)
+	if len(statuses) != 1 || statuses[0].Cid != test.TestCid3 {
+		t.Error("expected only the status of pins in the namespace: ", statuses)
+	}
+
+	errResp := errorResp{}
+	makeGetNamespace(t, "/pins/"+test.TestCid1, test.TestNamespace, &errResp)
+	if errResp.Code != 404 {
+		t.Error("expected 404 for a Cid in a different namespace")
+	}
+
+	for _, path := range []string{"/sync", "/recover"} {
+		errResp = errorResp{}
+		makePostNamespace(t, "/pins/"+test.TestCid1+path, test.TestNamespace, &errResp)
+		if errResp.Code != 404 {
+			t.Errorf("%s: expected 404 for a Cid in a different namespace", path)
+		}
+	}
+}
+
+func TestRESTAPIPinName(t *testing.T) {
+	rest := testRESTAPI(t)
+	defer rest.Shutdown()
+
+	var pins []api.CidArgSerial
+	makeGet(t, "/pinlist?name="+test.TestPinName, &pins)
+	if len(pins) != 1 || pins[0].Cid != test.TestCid2 || pins[0].Name != test.TestPinName {
+		t.Error("expected only the pins with the name: ", pins)
+	}
+
+	var statuses []api.GlobalPinInfoSerial
+	makeGet(t, "/pins?name="+test.TestPinName, &statuses)
+	if len(statuses) != 1 || statuses[0].Cid != test.TestCid2 || statuses[0].Name != test.TestPinName {
+		t.Error("expected only the status of the pins with the name: ", statuses)
+	}
+
+	makeGet(t, "/pins?name=nonexistent", &statuses)
+	if len(statuses) != 0 {
+		t.Error("expected no statuses: ", statuses)
+	}
+}
+
+func TestRESTAPIPinListMetadata(t *testing.T) {
+	rest := testRESTAPI(t)
+	defer rest.Shutdown()
+
+	var pins []api.CidArgSerial
+	makeGet(t, "/pinlist?meta-owner="+test.TestPinOwner, &pins)
+	if len(pins) != 1 || pins[0].Cid != test.TestCid2 {
+		t.Error("expected only the pins with the metadata: ", pins)
+	}
+
+	makeGet(t, "/pinlist?meta-owner="+test.TestPinOwner+"&meta-env=prod", &pins)
+	if len(pins) != 0 {
+		t.Error("all the metadata filters should match: ", pins)
+	}
+
+	makeGet(t, "/pinlist?name="+test.TestPinName+"&meta-owner=nobody", &pins)
+	if len(pins) != 0 {
+		t.Error("the metadata should be filtered with the name: ", pins)
+	}
+}
+
+func TestRESTAPIPinListEndpointByPeer(t *testing.T) {
+	rest := testRESTAPI(t)
+	defer rest.Shutdown()
+
+	var resp []api.CidArgSerial
+	makeGet(t, "/pinlist?peer="+test.TestPeerID1.Pretty(), &resp)
+	if len(resp) != 2 ||
+		resp[0].Cid != test.TestCid1 || resp[1].Cid != test.TestCid3 {
+		t.Error("expected only pins allocated to the peer: ", resp)
+	}
+
+	errResp := errorResp{}
+	makeGet(t, "/pinlist?peer=abc", &errResp)
+	if errResp.Code != 400 {
+		t.Error("expected error with a bad peer ID")
+	}
+}
+
+func TestRESTAPIStatusAllEndpoint(t *testing.T) {
+	rest := testRESTAPI(t)
+	defer rest.Shutdown()
+
+	var resp []api.GlobalPinInfoSerial
+	makeGet(t, "/pins", &resp)
+	if len(resp) != 3 ||
+		resp[0].Cid != test.TestCid1 ||
+		resp[1].PeerMap[test.TestPeerID1.Pretty()].Status != "pinning" {
+		t.Errorf("unexpected statusResp:\n %+v", resp)
+	}
+}
+
+func TestRESTAPIStatusAllEndpointNDJSON(t *testing.T) {
+	rest := testRESTAPI(t)
+	defer rest.Shutdown()
+
+	pageSize := StreamPageSize
+	StreamPageSize = 2
+	defer func() { StreamPageSize = pageSize }()
+
+	req, _ := http.NewRequest("GET", apiHost+"/pins", nil)
+	req.Header.Set("Accept", NDJSONContentType)
+	httpResp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer httpResp.Body.Close()
+	if ct := httpResp.Header.Get("Content-Type"); ct != NDJSONContentType {
+		t.Error("unexpected content type:", ct)
+	}
+
+	var cids []string
+	dec := json.NewDecoder(httpResp.Body)
+	for dec.More() {
+		var gpi api.GlobalPinInfoSerial
+		if err := dec.Decode(&gpi); err != nil {
+			t.Fatal(err)
+		}
+		cids = append(cids, gpi.Cid)
+	}
+	if len(cids) != 3 || !sort.StringsAreSorted(cids) {
+		t.Errorf("expected the 3 items sorted: %s", cids)
+	}
+}
+
+func TestRESTAPIStatusAllEndpointFields(t *testing.T) {
+	rest := testRESTAPI(t)
+	defer rest.Shutdown()
+
+	var resp []map[string]interface{}
+	makeGet(t, "/pins?fields=cid,status", &resp)
+	if len(resp) != 3 {
+		t.Fatal("expected 3 items")
+	}
+	if len(resp[0]) != 2 || resp[0]["cid"] != test.TestCid1 {
+		t.Errorf("expected only cid and peer_map: %+v", resp[0])
+	}
+	peerMap := resp[1]["peer_map"].(map[string]interface{})
+	pinfo := peerMap[test.TestPeerID1.Pretty()].(map[string]interface{})
+	if len(pinfo) != 1 || pinfo["status"] != "pinning" {
+		t.Errorf("expected only the status in the peer map: %+v", pinfo)
+	}
+
+	errResp := errorResp{}
+	makeGet(t, "/pins?fields=cid,foo", &errResp)
+	if errResp.Code != 400 {
+		t.Error("expected error with unknown field")
+	}
+}
+
+func TestRESTAPIStatusAllEndpointWaitForChanges(t *testing.T) {
+	rest := testRESTAPI(t)
+	defer rest.Shutdown()
+
+	var resp api.StatusChangesSerial
+	makeGet(t, "/pins?wait_for_changes=true", &resp)
+	if resp.Token == "" || len(resp.Changed) != 3 {
+		t.Error("expected the full status and a token")
+	}
+
+	var resp2 api.StatusChangesSerial
+	makeGet(t, "/pins?wait_for_changes=true&since="+resp.Token, &resp2)
+	if resp2.Token == resp.Token || len(resp2.Changed) != 1 {
+		t.Error("expected the changes since the token")
+	}
+	if len(resp2.Removed) != 1 || resp2.Removed[0] != test.TestCid3 {
+		t.Error("expected a removed cid")
+	}
+
+	// TestCid3 was in TestNamespace
+	makeGetNamespace(t, "/pins?wait_for_changes=true&since="+resp.Token, test.TestNamespace, &resp2)
+	if len(resp2.Removed) != 1 {
+		t.Error("expected the removed cid in its namespace")
+	}
+	makeGetNamespace(t, "/pins?wait_for_changes=true&since="+resp.Token, "other", &resp2)
+	if len(resp2.Removed) != 0 {
+		t.Error("removed cids of other namespaces should not be listed:", resp2.Removed)
+	}
+}
+
+func TestRESTAPIStatusEndpoint(t *testing.T) {
+	rest := testRESTAPI(t)
+	defer rest.Shutdown()
+
+	var resp api.GlobalPinInfoSerial
+	makeGet(t, "/pins/"+test.TestCid1, &resp)
+
+	if resp.Cid != test.TestCid1 {
+		t.Error("expected the same cid")
+	}
+	info, ok := resp.PeerMap[test.TestPeerID1.Pretty()]
+	if !ok {
+		t.Fatal("expected info for test.TestPeerID1")
+	}
+	if info.Status != "pinned" {
+		t.Error("expected different status")
+	}
+}
+
+func TestRESTAPIStatusCidsEndpoint(t *testing.T) {
+	rest := testRESTAPI(t)
+	defer rest.Shutdown()
+
+	body, _ := json.Marshal([]string{test.TestCid1, test.TestCid2})
+	var resp map[string]api.GlobalPinInfoSerial
+	makePost(t, "/pins/status", body, &resp)
+	if len(resp) != 2 {
+		t.Fatalf("expected 2 items, got %d", len(resp))
+	}
+	st1 := resp[test.TestCid1].PeerMap[test.TestPeerID1.Pretty()].Status
+	st2 := resp[test.TestCid2].PeerMap[test.TestPeerID1.Pretty()].Status
+	if st1 != "pinned" || st2 != "unpinned" {
+		t.Errorf("unexpected status: %s, %s", st1, st2)
+	}
+
+	errResp := errorResp{}
+	body, _ = json.Marshal([]string{test.TestCid1, "abcd"})
+	makePost(t, "/pins/status", body, &errResp)
+	if errResp.Code != 400 {
+		t.Error("should fail with an invalid Cid")
+	}
+}
+
+func TestRESTAPIStatusEndpointMinIndex(t *testing.T) {
+	rest := testRESTAPI(t)
+	defer rest.Shutdown()
+
+	var resp api.GlobalPinInfoSerial
+	makeGet(t, fmt.Sprintf("/pins/%s?min_index=%d", test.TestCid1, test.TestLogIndex), &resp)
+	if resp.Cid != test.TestCid1 {
+		t.Error("expected the same cid")
+	}
+
+	errResp := errorResp{}
+	makeGet(t, fmt.Sprintf("/pins/%s?min_index=%d", test.TestCid1, test.TestLogIndex+1), &errResp)
+	if errResp.Code != 500 {
+		t.Error("expected an error waiting for a future index")
+	}
+
+	makeGet(t, "/pins/"+test.TestCid1+"?min_index=abc", &errResp)
+	if errResp.Code != 400 {
+		t.Error("expected an error with a bad min_index")
+	}
+}
+
+func TestRESTAPISyncAllEndpoint(t *testing.T) {
+	rest := testRESTAPI(t)
+	defer rest.Shutdown()
+
+	var resp []api.GlobalPinInfoSerial
+	makePost(t, "/pins/sync", []byte{}, &resp)
+
+	if len(resp) != 3 ||
+		resp[0].Cid != test.TestCid1 ||
+		resp[1].PeerMap[test.TestPeerID1.Pretty()].Status != "pinning" {
+		t.Errorf("unexpected statusResp:\n %+v", resp)
+	}
+}
+
+func TestRESTAPISyncEndpoint(t *testing.T) {
+	rest := testRESTAPI(t)
+	defer rest.Shutdown()
+
+	var resp api.GlobalPinInfoSerial
+	makePost(t, "/pins/"+test.TestCid1+"/sync", []byte{}, &resp)
+
+	if resp.Cid != test.TestCid1 {
+		t.Error("expected the same cid")
+	}
+	info, ok := resp.PeerMap[test.TestPeerID1.Pretty()]
+	if !ok {
+		t.Fatal("expected info for test.TestPeerID1")
+	}
+	if info.Status != "pinned" {
+		t.Error("expected different status")
+	}
+}
+
+func TestRESTAPIRecoverEndpoint(t *testing.T) {
+	rest := testRESTAPI(t)
+	defer rest.Shutdown()
+
+	var resp api.GlobalPinInfoSerial
+	makePost(t, "/pins/"+test.TestCid1+"/recover", []byte{}, &resp)
+
+	if resp.Cid != test.TestCid1 {
+		t.Error("expected the same cid")
+	}
+	info, ok := resp.PeerMap[test.TestPeerID1.Pretty()]
+	if !ok {
+		t.Fatal("expected info for test.TestPeerID1")
 	}
 	if info.Status != "pinned" {
 		t.Error("expected different status")
 	}
 }
+
+func TestRESTAPILocalAllocationsEndpoint(t *testing.T) {
+	rest := testRESTAPI(t)
+	defer rest.Shutdown()
+
+	var resp []api.CidArgSerial
+	makeGet(t, "/pins/local", &resp)
+	if len(resp) != 2 || resp[0].Cid != test.TestCid1 || resp[1].Cid != test.TestCid3 {
+		t.Errorf("unexpected local allocations: %+v", resp)
+	}
+}
+
+func TestRESTAPIRecoverAllEndpoint(t *testing.T) {
+	rest := testRESTAPI(t)
+	defer rest.Shutdown()
+
+	var resp []api.GlobalPinInfoSerial
+	makePost(t, "/pins/recover", []byte{}, &resp)
+	if len(resp) != 3 {
+		t.Fatal("wrong number of items")
+	}
+}
+
+func TestRESTAPIReallocateEndpoint(t *testing.T) {
+	rest := testRESTAPI(t)
+	defer rest.Shutdown()
+
+	var resp api.GlobalPinInfoSerial
+	body := fmt.Sprintf("{\"allocations\":[\"%s\"]}", test.TestPeerID1.Pretty())
+	makePost(t, "/pins/"+test.TestCid1+"/reallocate", []byte(body), &resp)
+	if resp.Cid != test.TestCid1 {
+		t.Error("expected the same cid")
+	}
+
+	errResp := errorResp{}
+	makePost(t, "/pins/"+test.TestCid1+"/reallocate", []byte("{\"allocations\":[\"abc\"]}"), &errResp)
+	if errResp.Code != 400 {
+		t.Error("expected error with a bad peer ID")
+	}
+}
+
+func TestRESTAPIMountProxy(t *testing.T) {
+	cfg := testingConfig()
+	rest, err := NewRESTAPI(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rest.Shutdown()
+	rest.server.SetKeepAlivesEnabled(false)
+
+	rest.MountProxy(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{\"Version\":\"proxied\"}"))
+	}))
+	rest.SetClient(test.NewMockRPCClient(t))
+
+	var ver api.Version
+	makeGet(t, "/api/v0/version", &ver)
+	if ver.Version != "proxied" {
+		t.Error("expected request to be handled by the proxy")
+	}
+
+	makeGet(t, "/version", &ver)
+	if ver.Version != "0.0.mock" {
+		t.Error("expected cluster routes to keep working")
+	}
+}
+
+// testAdder adds any content as TestCid1, or as ErrorCid when it is
+// "error".
+type testAdder struct{}
+
+func (ta testAdder) Add(data io.Reader) (*cid.Cid, error) {
+	b, err := ioutil.ReadAll(data)
+	if err != nil {
+		return nil, err
+	}
+	if string(b) == "error" {
+		return cid.Decode(test.ErrorCid)
+	}
+	return cid.Decode(test.TestCid1)
+}
+
+func TestRESTAPIAddEndpoint(t *testing.T) {
+	// Only /add is allowed to take longer than these.
+	RESTAPIServerReadTimeout = 200 * time.Millisecond
+	RESTAPIServerWriteTimeout = 200 * time.Millisecond
+	defer func() {
+		RESTAPIServerReadTimeout = 5 * time.Second
+		RESTAPIServerWriteTimeout = 10 * time.Second
+	}()
+	cfg := testingConfig()
+	rest, err := NewRESTAPI(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rest.Shutdown()
+	rest.server.SetKeepAlivesEnabled(false)
+	rest.SetContentAdder(testAdder{})
+	rest.SetClient(test.NewMockRPCClient(t))
+
+	post := func(content string) (*http.Response, error) {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		part, _ := mw.CreateFormFile("file", "hello.txt")
+		part.Write([]byte(content))
+		mw.Close()
+		return http.Post(apiHost+"/add?name=hello", mw.FormDataContentType(), &body)
+	}
+
+	httpResp, err := post("hello")
+	var resp pinResp
+	processResp(t, httpResp, err, &resp)
+	if httpResp.StatusCode != http.StatusAccepted || resp.Cid != test.TestCid1 {
+		t.Error("expected the added Cid to be pinned, got ", resp.Cid)
+	}
+
+	// added but not pinned: the Cid is reported
+	httpResp, err = post("error")
+	errResp := errorResp{}
+	processResp(t, httpResp, err, &errResp)
+	if errResp.Code != 500 || !strings.Contains(errResp.Message, test.ErrorCid) {
+		t.Error("expected the error to tell the added Cid: ", errResp.Message)
+	}
+
+	// a slow upload
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		part, _ := mw.CreateFormFile("file", "hello.txt")
+		part.Write([]byte("hel"))
+		time.Sleep(2 * RESTAPIServerReadTimeout)
+		part.Write([]byte("lo"))
+		mw.Close()
+		pw.Close()
+	}()
+	httpResp, err = http.Post(apiHost+"/add", mw.FormDataContentType(), pr)
+	resp = pinResp{}
+	processResp(t, httpResp, err, &resp)
+	if httpResp.StatusCode != http.StatusAccepted || resp.Cid != test.TestCid1 {
+		t.Error("expected slow uploads to be accepted, got ", resp.Cid)
+	}
+
+	// no file
+	var body bytes.Buffer
+	mw = multipart.NewWriter(&body)
+	mw.WriteField("name", "hello")
+	mw.Close()
+	httpResp, err = http.Post(apiHost+"/add", mw.FormDataContentType(), &body)
+	errResp = errorResp{}
+	processResp(t, httpResp, err, &errResp)
+	if errResp.Code != 400 {
+		t.Error("expected a 400 without a file")
+	}
+}
+
+func TestRESTAPIEventsEndpoint(t *testing.T) {
+	cfg := testingConfig()
+	rest, err := NewRESTAPI(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rest.Shutdown()
+	mpt := NewMapPinTracker(cfg)
+	defer mpt.Shutdown()
+	rest.SetEventSource(mpt)
+	rest.SetClient(test.NewMockRPCClient(t))
+
+	httpResp, err := http.Get(apiHost + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer httpResp.Body.Close()
+	if ct := httpResp.Header.Get("Content-Type"); ct != EventsContentType {
+		t.Fatal("unexpected content type:", ct)
+	}
+
+	// Wait for the handler to subscribe
+	for i := 0; ; i++ {
+		mpt.subsMux.Lock()
+		n := len(mpt.subs)
+		mpt.subsMux.Unlock()
+		if n > 0 {
+			break
+		}
+		if i == 50 {
+			t.Fatal("the handler did not subscribe")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	c, _ := cid.Decode(test.TestCid1)
+	mpt.set(c, api.TrackerStatusPinned)
+
+	rd := bufio.NewReader(httpResp.Body)
+	var lines []string
+	for len(lines) < 2 {
+		l, err := rd.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, strings.TrimSpace(l))
+	}
+	if lines[0] != "event: status" || !strings.HasPrefix(lines[1], "data: ") {
+		t.Fatalf("unexpected event: %q", lines)
+	}
+	var pinfo api.PinInfoSerial
+	err = json.Unmarshal([]byte(strings.TrimPrefix(lines[1], "data: ")), &pinfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pinfo.Cid != test.TestCid1 || pinfo.Status != "pinned" {
+		t.Errorf("unexpected event data: %+v", pinfo)
+	}
+}
+
+func TestRESTAPIAlertsEndpoint(t *testing.T) {
+	rest := testRESTAPI(t)
+	defer rest.Shutdown()
+
+	httpResp, err := http.Get(apiHost + "/events/alerts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusServiceUnavailable {
+		t.Error("expected 503 without an alert source but got", httpResp.StatusCode)
+	}
+
+	c := &Cluster{alerts: newAlertBroker()}
+	rest.SetAlertSource(c)
+
+	httpResp, err = http.Get(apiHost + "/events/alerts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer httpResp.Body.Close()
+	if ct := httpResp.Header.Get("Content-Type"); ct != EventsContentType {
+		t.Fatal("unexpected content type:", ct)
+	}
+
+	// Wait for the handler to subscribe
+	for i := 0; ; i++ {
+		c.alerts.mu.Lock()
+		n := len(c.alerts.subs)
+		c.alerts.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		if i == 50 {
+			t.Fatal("the handler did not subscribe")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	c.alert(test.TestPeerID2, api.AlertWarning, api.AlertPeerRemoved, "removed")
+
+	rd := bufio.NewReader(httpResp.Body)
+	var lines []string
+	for len(lines) < 2 {
+		l, err := rd.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, strings.TrimSpace(l))
+	}
+	if lines[0] != "event: alert" || !strings.HasPrefix(lines[1], "data: ") {
+		t.Fatalf("unexpected event: %q", lines)
+	}
+	var a api.AlertSerial
+	err = json.Unmarshal([]byte(strings.TrimPrefix(lines[1], "data: ")), &a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Code != api.AlertPeerRemoved || a.Severity != api.AlertWarning ||
+		a.Peer != test.TestPeerID2.Pretty() || a.Message != "removed" {
+		t.Errorf("unexpected event data: %+v", a)
+	}
+}
+
+// writeTestCertificate writes a self-signed certificate for 127.0.0.1
+// and its key to dir.
+func writeTestCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	err = ioutil.WriteFile(certFile,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(keyFile,
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestRESTAPITLSListener(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cluster-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeTestCertificate(t, dir)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tlsListener(l, filepath.Join(dir, "missing.pem"), keyFile)
+	if err == nil {
+		t.Fatal("expected an error with a missing certificate")
+	}
+
+	l, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tl, err := tlsListener(l, certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}),
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Serve(tl)
+	}()
+	defer func() {
+		tl.Close()
+		<-done
+	}()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			DisableKeepAlives: true,
+		},
+	}
+	resp, err := client.Get("https://" + l.Addr().String() + "/id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("unexpected status %d over HTTPS", resp.StatusCode)
+	}
+
+	plain := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	resp, err = plain.Get("http://" + l.Addr().String() + "/id")
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNoContent {
+			t.Error("plain HTTP requests should not be served")
+		}
+	}
+}
+
+func TestRESTAPICORS(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/id", nil)
+	req.Header.Set("Origin", "https://admin.example.org")
+	(&RESTAPI{}).cors(ok).ServeHTTP(w, req)
+	if w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Error("no CORS headers should be sent when no origins are allowed")
+	}
+
+	rest := &RESTAPI{
+		corsOrigins: []string{"https://admin.example.org"},
+		authToken:   "secret",
+	}
+	h := rest.cors(rest.authenticate(ok))
+
+	testcases := []struct {
+		name      string
+		method    string
+		origin    string
+		preflight string
+		expected  int
+		allowed   bool
+	}{
+		{"preflight", "OPTIONS", "https://admin.example.org", "POST", 204, true},
+		{"preflight bad method", "OPTIONS", "https://admin.example.org", "PUT", 403, false},
+		{"preflight bad origin", "OPTIONS", "https://evil.example.org", "POST", 401, false},
+		{"request", "GET", "https://admin.example.org", "", 401, true},
+		{"request bad origin", "GET", "https://evil.example.org", "", 401, false},
+	}
+	for _, tc := range testcases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(tc.method, "/pins", nil)
+		req.Header.Set("Origin", tc.origin)
+		if tc.preflight != "" {
+			req.Header.Set("Access-Control-Request-Method", tc.preflight)
+		}
+		h.ServeHTTP(w, req)
+		if w.Code != tc.expected {
+			t.Errorf("%s: expected %d but got %d", tc.name, tc.expected, w.Code)
+		}
+		allowOrigin := w.Header().Get("Access-Control-Allow-Origin")
+		if tc.allowed && allowOrigin != tc.origin {
+			t.Errorf("%s: expected Access-Control-Allow-Origin %s but got %q",
+				tc.name, tc.origin, allowOrigin)
+		}
+		if !tc.allowed && allowOrigin != "" {
+			t.Errorf("%s: unexpected Access-Control-Allow-Origin", tc.name)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package ipfscluster
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// authenticate wraps the given handler so that it only serves requests
+// carrying the credentials configured for the REST API: either the
+// bearer token or any of the basic authentication users. Other requests
+// are rejected with 401. When no credentials are configured, the
+// handler is returned as it is.
+func (rest *RESTAPI) authenticate(next http.Handler) http.Handler {
+	if rest.authToken == "" && len(rest.basicAuth) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rest.authExemptHealth && r.URL.Path == "/health" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !rest.authorized(r) {
+			if len(rest.basicAuth) > 0 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="ipfs-cluster"`)
+			} else {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+			}
+			sendErrorResponse(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authorized checks the Authorization header of a request. Secrets are
+// compared in constant time.
+func (rest *RESTAPI) authorized(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	if rest.authToken != "" && strings.HasPrefix(auth, "Bearer ") {
+		token := strings.TrimPrefix(auth, "Bearer ")
+		return secureEqual(token, rest.authToken)
+	}
+	if len(rest.basicAuth) > 0 {
+		user, pass, ok := r.BasicAuth()
+		if !ok {
+			return false
+		}
+		expected, ok := rest.basicAuth[user]
+		return ok && secureEqual(pass, expected)
+	}
+	return false
+}
+
+func secureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
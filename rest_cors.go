@@ -0,0 +1,85 @@
+package ipfscluster
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORS settings used when the configuration does not list the allowed
+// methods or headers.
+var (
+	DefaultCORSAllowedMethods = []string{"GET", "POST", "DELETE"}
+	DefaultCORSAllowedHeaders = []string{"Content-Type", "Authorization"}
+)
+
+// CORSMaxAge is how long browsers may cache the answer to a preflight
+// request.
+var CORSMaxAge = 10 * time.Minute
+
+// cors wraps the given handler so that requests coming from any of the
+// allowed origins carry the Cross-Origin Resource Sharing headers in
+// their responses. Preflight OPTIONS requests from those origins are
+// answered directly, without authentication, since browsers do not send
+// credentials with them. When no origins are configured, the handler is
+// returned as it is.
+func (rest *RESTAPI) cors(next http.Handler) http.Handler {
+	if len(rest.corsOrigins) == 0 {
+		return next
+	}
+	methods := rest.corsMethods
+	if len(methods) == 0 {
+		methods = DefaultCORSAllowedMethods
+	}
+	headers := rest.corsHeaders
+	if len(headers) == 0 {
+		headers = DefaultCORSAllowedHeaders
+	}
+	allowMethods := strings.Join(methods, ", ")
+	allowHeaders := strings.Join(headers, ", ")
+	maxAge := strconv.Itoa(int(CORSMaxAge.Seconds()))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		origin := r.Header.Get("Origin")
+		if origin == "" || !rest.corsOriginAllowed(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		reqMethod := r.Header.Get("Access-Control-Request-Method")
+		if r.Method == "OPTIONS" && reqMethod != "" {
+			if !stringIn(methods, reqMethod) {
+				sendErrorResponse(w, http.StatusForbidden,
+					"method not allowed by the CORS policy: "+reqMethod)
+				return
+			}
+			h.Set("Access-Control-Allow-Origin", origin)
+			h.Set("Access-Control-Allow-Methods", allowMethods)
+			h.Set("Access-Control-Allow-Headers", allowHeaders)
+			h.Set("Access-Control-Max-Age", maxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		h.Set("Access-Control-Allow-Origin", origin)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// corsOriginAllowed returns true when the origin is in the allowed
+// list, or when the list contains "*".
+func (rest *RESTAPI) corsOriginAllowed(origin string) bool {
+	return stringIn(rest.corsOrigins, "*") || stringIn(rest.corsOrigins, origin)
+}
+
+func stringIn(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
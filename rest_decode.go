@@ -0,0 +1,101 @@
+package ipfscluster
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sort"
+)
+
+// decodeBodyOrError decodes the JSON body of a request into obj, which
+// must be a pointer to a struct. When the body cannot be decoded, it
+// sends a 400 response explaining why, including the offending field
+// when there is one, and returns false. When strictRequestBodies is
+// set, fields which obj does not have are rejected too.
+func (rest *RESTAPI) decodeBodyOrError(w http.ResponseWriter, r *http.Request, obj interface{}) bool {
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		sendErrorResponse(w, 400, "error reading request body: "+err.Error())
+		return false
+	}
+
+	if e := decodeBody(body, obj, rest.strictRequestBodies); e != nil {
+		sendError(w, *e)
+		return false
+	}
+	return true
+}
+
+// decodeBody decodes a JSON object into obj and describes any problem
+// in an errorResp.
+func decodeBody(body []byte, obj interface{}, strict bool) *errorResp {
+	badRequest := func(field, format string, a ...interface{}) *errorResp {
+		return &errorResp{
+			Code:    400,
+			Message: "error decoding request body: " + fmt.Sprintf(format, a...),
+			Field:   field,
+		}
+	}
+
+	// A first pass obtains the fields in the body, catching malformed
+	// JSON and values which are not objects.
+	var raw map[string]json.RawMessage
+	err := json.NewDecoder(bytes.NewReader(body)).Decode(&raw)
+	switch e := err.(type) {
+	case nil:
+	case *json.SyntaxError:
+		return badRequest("", "malformed JSON at offset %d: %s", e.Offset, e)
+	case *json.UnmarshalTypeError:
+		return badRequest("", "expected a JSON object")
+	default:
+		if err == io.EOF {
+			return badRequest("", "the body is empty")
+		}
+		return badRequest("", "%s", err)
+	}
+
+	if strict {
+		known := jsonFields(reflect.ValueOf(obj).Elem().Interface())
+		var unknown []string
+		for k := range raw {
+			if !known[k] {
+				unknown = append(unknown, k)
+			}
+		}
+		if len(unknown) > 0 {
+			sort.Strings(unknown)
+			return badRequest(unknown[0], "unknown field %q", unknown[0])
+		}
+	}
+
+	err = json.Unmarshal(body, obj)
+	if err == nil {
+		return nil
+	}
+
+	// Find out which field could not be decoded by decoding them
+	// one by one.
+	fields := make([]string, 0, len(raw))
+	for k := range raw {
+		fields = append(fields, k)
+	}
+	sort.Strings(fields)
+	t := reflect.TypeOf(obj).Elem()
+	for _, k := range fields {
+		single := map[string]json.RawMessage{k: raw[k]}
+		b, _ := json.Marshal(single)
+		if ferr := json.Unmarshal(b, reflect.New(t).Interface()); ferr != nil {
+			if te, ok := ferr.(*json.UnmarshalTypeError); ok {
+				return badRequest(k, "invalid value for %q: cannot use %s as %s",
+					k, te.Value, te.Type)
+			}
+			return badRequest(k, "invalid value for %q: %s", k, ferr)
+		}
+	}
+	return badRequest("", "%s", err)
+}
@@ -0,0 +1,44 @@
+package ipfscluster
+
+import "testing"
+
+func TestDecodeBody(t *testing.T) {
+	testcases := []struct {
+		body   string
+		strict bool
+		ok     bool
+		field  string
+	}{
+		{`{"allocations": ["a", "b"]}`, false, true, ""},
+		{`{"allocations": ["a"], "alocations": []}`, false, true, ""},
+		{`{"allocations": ["a"], "alocations": []}`, true, false, "alocations"},
+		{`{"allocations": "a"}`, false, false, "allocations"},
+		{`{"allocations": [}`, false, false, ""},
+		{`["a"]`, false, false, ""},
+		{``, false, false, ""},
+	}
+
+	for _, tc := range testcases {
+		var body reallocateBody
+		e := decodeBody([]byte(tc.body), &body, tc.strict)
+		if tc.ok {
+			if e != nil {
+				t.Errorf("%s: unexpected error: %s", tc.body, e.Message)
+			}
+			continue
+		}
+		if e == nil {
+			t.Errorf("%s: expected an error", tc.body)
+			continue
+		}
+		if e.Code != 400 || e.Field != tc.field {
+			t.Errorf("%s: unexpected error response: %+v", tc.body, e)
+		}
+	}
+
+	var body reallocateBody
+	decodeBody([]byte(`{"allocations": ["a", "b"]}`), &body, true)
+	if len(body.Allocations) != 2 {
+		t.Error("expected the body to be decoded")
+	}
+}
@@ -0,0 +1,192 @@
+package ipfscluster
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// EventsKeepAliveInterval specifies how often a comment is sent to the
+// clients of the /events endpoints when there are no events, so that
+// idle connections are kept open and disconnections are noticed.
+var EventsKeepAliveInterval = 15 * time.Second
+
+// EventsContentType is the media type of the /events streams.
+const EventsContentType = "text/event-stream"
+
+// eventStream sends Server-Sent Events on a hijacked connection, so
+// that the stream is not cut by the server's write timeout, which is
+// instead applied to every write.
+type eventStream struct {
+	conn  net.Conn
+	bufrw *bufio.ReadWriter
+	// closed when the client disconnects
+	gone      chan struct{}
+	keepAlive *time.Ticker
+}
+
+// openEventStream hijacks the connection of the request and sends the
+// response headers. It returns false, after answering the request if
+// possible, when the stream cannot be opened.
+func openEventStream(w http.ResponseWriter) (*eventStream, bool) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		sendErrorResponse(w, http.StatusInternalServerError, "streaming is not supported")
+		return nil, false
+	}
+	conn, bufrw, err := hj.Hijack()
+	if err != nil {
+		logger.Error(err)
+		return nil, false
+	}
+
+	es := &eventStream{
+		conn:  conn,
+		bufrw: bufrw,
+		gone:  make(chan struct{}),
+	}
+
+	// Clients do not send anything else. Reading only fails once they
+	// disconnect.
+	go func() {
+		defer close(es.gone)
+		buf := make([]byte, 512)
+		for {
+			if _, err := conn.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	ok = es.send(func(bw *bufio.Writer) error {
+		_, err := fmt.Fprintf(bw,
+			"HTTP/1.1 200 OK\r\nContent-Type: %s\r\nCache-Control: no-cache\r\nConnection: close\r\n\r\n",
+			EventsContentType)
+		return err
+	})
+	if !ok {
+		conn.Close()
+		return nil, false
+	}
+	es.keepAlive = time.NewTicker(EventsKeepAliveInterval)
+	return es, true
+}
+
+// close stops the stream and closes the connection.
+func (es *eventStream) close() {
+	es.keepAlive.Stop()
+	es.conn.Close()
+}
+
+// send flushes whatever write writes to the client. It returns false
+// when the stream should be closed.
+func (es *eventStream) send(write func(*bufio.Writer) error) bool {
+	es.conn.SetWriteDeadline(time.Now().Add(RESTAPIServerWriteTimeout))
+	if err := write(es.bufrw.Writer); err != nil {
+		logger.Debugf("closing event stream: %s", err)
+		return false
+	}
+	if err := es.bufrw.Flush(); err != nil {
+		logger.Debugf("closing event stream: %s", err)
+		return false
+	}
+	return true
+}
+
+// sendEvent sends an event of the given type whose data is v,
+// serialized as JSON. It returns false when the stream should be
+// closed.
+func (es *eventStream) sendEvent(event string, v interface{}) bool {
+	data, err := json.Marshal(v)
+	if err != nil {
+		// skip the event
+		logger.Error(err)
+		return true
+	}
+	return es.send(func(bw *bufio.Writer) error {
+		_, err := fmt.Fprintf(bw, "event: %s\ndata: %s\n\n", event, data)
+		return err
+	})
+}
+
+// sendKeepAlive sends a comment. It returns false when the stream
+// should be closed.
+func (es *eventStream) sendKeepAlive() bool {
+	return es.send(func(bw *bufio.Writer) error {
+		_, err := bw.WriteString(": keepalive\n\n")
+		return err
+	})
+}
+
+// eventsHandler streams the status changes notified by the eventSource
+// as Server-Sent Events. Every change is sent as a "status" event whose
+// data is the PinInfo of the item, serialized as JSON.
+func (rest *RESTAPI) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	if rest.eventSource == nil {
+		sendErrorResponse(w, http.StatusServiceUnavailable, "events are not available")
+		return
+	}
+	es, ok := openEventStream(w)
+	if !ok {
+		return
+	}
+	defer es.close()
+
+	events, cancel := rest.eventSource.Subscribe()
+	defer cancel()
+
+	for {
+		select {
+		case <-rest.ctx.Done():
+			return
+		case <-es.gone:
+			return
+		case pinfo, ok := <-events:
+			if !ok || !es.sendEvent("status", pinfo.ToSerial()) {
+				return
+			}
+		case <-es.keepAlive.C:
+			if !es.sendKeepAlive() {
+				return
+			}
+		}
+	}
+}
+
+// alertsHandler streams the alerts raised by the Cluster peer as
+// Server-Sent Events. Every alert is sent as an "alert" event whose
+// data is the Alert, serialized as JSON.
+func (rest *RESTAPI) alertsHandler(w http.ResponseWriter, r *http.Request) {
+	if rest.alertSource == nil {
+		sendErrorResponse(w, http.StatusServiceUnavailable, "alerts are not available")
+		return
+	}
+	es, ok := openEventStream(w)
+	if !ok {
+		return
+	}
+	defer es.close()
+
+	alerts, cancel := rest.alertSource.SubscribeAlerts()
+	defer cancel()
+
+	for {
+		select {
+		case <-rest.ctx.Done():
+			return
+		case <-es.gone:
+			return
+		case a, ok := <-alerts:
+			if !ok || !es.sendEvent("alert", a.ToSerial()) {
+				return
+			}
+		case <-es.keepAlive.C:
+			if !es.sendKeepAlive() {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package ipfscluster
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/ipfs/ipfs-cluster/api"
+)
+
+// The fields which can be selected with the "fields" query parameter
+// for each type of object, as they are named in their JSON form.
+var (
+	cidArgFields        = jsonFields(api.CidArgSerial{})
+	globalPinInfoFields = jsonFields(api.GlobalPinInfoSerial{})
+	pinInfoFields       = jsonFields(api.PinInfoSerial{})
+)
+
+// jsonFields returns the set of JSON keys used by the fields of
+// the given struct.
+func jsonFields(v interface{}) map[string]bool {
+	fields := make(map[string]bool)
+	t := reflect.TypeOf(v)
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			fields[name] = true
+		}
+	}
+	return fields
+}
+
+// parseFieldsOrError parses the comma-separated list of fields in the
+// "fields" query parameter. It returns a nil set when the parameter is
+// not set, meaning that all fields should be sent. A 400 response is
+// sent, and false returned, when a field is not in any of the given
+// valid sets.
+func parseFieldsOrError(w http.ResponseWriter, r *http.Request, valid ...map[string]bool) (map[string]bool, bool) {
+	param := r.URL.Query().Get("fields")
+	if param == "" {
+		return nil, true
+	}
+
+	fields := make(map[string]bool)
+	for _, f := range strings.Split(param, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		known := false
+		for _, v := range valid {
+			known = known || v[f]
+		}
+		if !known {
+			sendErrorResponse(w, 400, "unknown field: "+f)
+			return nil, false
+		}
+		fields[f] = true
+	}
+	if len(fields) == 0 {
+		return nil, true
+	}
+	return fields, true
+}
+
+// filterFields returns the JSON object for the given value with only
+// the given fields.
+func filterFields(v interface{}, fields map[string]bool) (map[string]interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var obj map[string]interface{}
+	err = json.Unmarshal(b, &obj)
+	if err != nil {
+		return nil, err
+	}
+	for k := range obj {
+		if !fields[k] {
+			delete(obj, k)
+		}
+	}
+	return obj, nil
+}
+
+// filterCidArgs applies filterFields to a list of pins.
+func filterCidArgs(pins []api.CidArgSerial, fields map[string]bool) ([]map[string]interface{}, error) {
+	filtered := make([]map[string]interface{}, len(pins), len(pins))
+	for i, p := range pins {
+		obj, err := filterFields(p, fields)
+		if err != nil {
+			return nil, err
+		}
+		filtered[i] = obj
+	}
+	return filtered, nil
+}
+
+// filterGlobalPinInfo keeps the requested fields of a GlobalPinInfo.
+// Fields which only belong to PinInfo (like "status") select the
+// fields of the entries in the peer map, which is then included even
+// if not requested. Requesting "peer_map" includes the full entries.
+func filterGlobalPinInfo(gpi api.GlobalPinInfoSerial, fields map[string]bool) (map[string]interface{}, error) {
+	obj, err := filterFields(gpi, fields)
+	if err != nil || fields["peer_map"] {
+		return obj, err
+	}
+
+	peerFields := make(map[string]bool)
+	for f := range fields {
+		if pinInfoFields[f] && !globalPinInfoFields[f] {
+			peerFields[f] = true
+		}
+	}
+	if len(peerFields) == 0 {
+		return obj, nil
+	}
+
+	peerMap := make(map[string]interface{}, len(gpi.PeerMap))
+	for p, pinfo := range gpi.PeerMap {
+		peerMap[p], err = filterFields(pinfo, peerFields)
+		if err != nil {
+			return nil, err
+		}
+	}
+	obj["peer_map"] = peerMap
+	return obj, nil
+}
+
+// filterGlobalPinInfos applies filterGlobalPinInfo to a list.
+func filterGlobalPinInfos(gpis []api.GlobalPinInfoSerial, fields map[string]bool) ([]map[string]interface{}, error) {
+	filtered := make([]map[string]interface{}, len(gpis), len(gpis))
+	for i, gpi := range gpis {
+		obj, err := filterGlobalPinInfo(gpi, fields)
+		if err != nil {
+			return nil, err
+		}
+		filtered[i] = obj
+	}
+	return filtered, nil
+}
@@ -0,0 +1,47 @@
+package ipfscluster
+
+import (
+	"testing"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/test"
+)
+
+func TestFilterGlobalPinInfo(t *testing.T) {
+	gpi := api.GlobalPinInfoSerial{
+		Cid: test.TestCid1,
+		PeerMap: map[string]api.PinInfoSerial{
+			test.TestPeerID1.Pretty(): {
+				Cid:    test.TestCid1,
+				Peer:   test.TestPeerID1.Pretty(),
+				Status: "pinned",
+			},
+		},
+		Replicas: 1,
+	}
+
+	obj, err := filterGlobalPinInfo(gpi, map[string]bool{"cid": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(obj) != 1 || obj["cid"] != test.TestCid1 {
+		t.Error("expected only the cid:", obj)
+	}
+
+	obj, _ = filterGlobalPinInfo(gpi, map[string]bool{"status": true})
+	peerMap, ok := obj["peer_map"].(map[string]interface{})
+	if len(obj) != 1 || !ok {
+		t.Fatal("expected only the peer map:", obj)
+	}
+	pinfo := peerMap[test.TestPeerID1.Pretty()].(map[string]interface{})
+	if len(pinfo) != 1 || pinfo["status"] != "pinned" {
+		t.Error("expected only the status in the peer map:", pinfo)
+	}
+
+	obj, _ = filterGlobalPinInfo(gpi, map[string]bool{"peer_map": true, "status": true})
+	peerMap = obj["peer_map"].(map[string]interface{})
+	pinfo = peerMap[test.TestPeerID1.Pretty()].(map[string]interface{})
+	if len(pinfo) != 3 {
+		t.Error("expected full peer map entries when requesting peer_map:", pinfo)
+	}
+}
@@ -0,0 +1,90 @@
+package ipfscluster
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+// logRequests wraps the given handler so that every request is logged
+// at debug level, with its method, path, response status and size, and
+// the time taken to serve it. When access logging is disabled, the
+// handler is returned as it is.
+func (rest *RESTAPI) logRequests(next http.Handler) http.Handler {
+	if !rest.accessLog {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		lw := &loggingResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(lw, r)
+		if lw.hijacked {
+			logger.Debugf("%s %s %s hijacked %s",
+				r.RemoteAddr, r.Method, r.URL.RequestURI(), time.Since(start))
+			return
+		}
+		logger.Debugf("%s %s %s %d %dB %s",
+			r.RemoteAddr, r.Method, r.URL.RequestURI(), lw.status(), lw.size, time.Since(start))
+	})
+}
+
+// loggingResponseWriter records the status code and the size of a
+// response. It keeps supporting flushing and hijacking when the
+// wrapped ResponseWriter does, as streaming endpoints rely on them.
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	code     int
+	size     int
+	hijacked bool
+}
+
+func (lw *loggingResponseWriter) WriteHeader(code int) {
+	if lw.code == 0 {
+		lw.code = code
+	}
+	lw.ResponseWriter.WriteHeader(code)
+}
+
+func (lw *loggingResponseWriter) Write(b []byte) (int, error) {
+	if lw.code == 0 {
+		lw.code = http.StatusOK
+	}
+	n, err := lw.ResponseWriter.Write(b)
+	lw.size += n
+	return n, err
+}
+
+// status returns the status code of the response. Handlers which write
+// nothing at all respond with 200.
+func (lw *loggingResponseWriter) status() int {
+	if lw.code == 0 {
+		return http.StatusOK
+	}
+	return lw.code
+}
+
+func (lw *loggingResponseWriter) Flush() {
+	if f, ok := lw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the wrapped ResponseWriter,
+// i.e. to extend the deadlines of POST /add.
+func (lw *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return lw.ResponseWriter
+}
+
+func (lw *loggingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := lw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the ResponseWriter does not support hijacking")
+	}
+	conn, bufrw, err := hj.Hijack()
+	if err == nil {
+		lw.hijacked = true
+	}
+	return conn, bufrw, err
+}
@@ -0,0 +1,45 @@
+package ipfscluster
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLoggingResponseWriter(t *testing.T) {
+	var lw *loggingResponseWriter
+	rest := &RESTAPI{accessLog: true}
+	h := rest.logRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lw = w.(*loggingResponseWriter)
+		if _, ok := w.(http.Flusher); !ok {
+			t.Error("the wrapper should support flushing")
+		}
+		if _, ok := w.(http.Hijacker); !ok {
+			t.Error("the wrapper should support hijacking")
+		}
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("hello"))
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/pins", nil))
+	if rec.Code != http.StatusAccepted || rec.Body.String() != "hello" {
+		t.Error("the response should be passed through")
+	}
+	if lw.status() != http.StatusAccepted || lw.size != 5 {
+		t.Errorf("unexpected status or size: %d %d", lw.status(), lw.size)
+	}
+
+	// A recorder cannot be hijacked
+	if _, _, err := lw.Hijack(); err == nil || lw.hijacked {
+		t.Error("expected an error hijacking")
+	}
+
+	rest.accessLog = false
+	h = rest.logRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(*loggingResponseWriter); ok {
+			t.Error("requests should not be wrapped when access logging is disabled")
+		}
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/pins", nil))
+}
@@ -0,0 +1,119 @@
+package ipfscluster
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimitPurgeInterval is how often the rate limiters of the REST API
+// forget the clients which have not made requests lately.
+var RateLimitPurgeInterval = time.Minute
+
+// tokenBucket holds the tokens of a client. A request takes a token.
+// Tokens are added at a constant rate, up to the size of the bucket.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps a token bucket for each client. The buckets start
+// full and hold one second of requests, so short bursts are allowed.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastPurge time.Time
+}
+
+// newRateLimiter returns a limiter allowing the given number of
+// requests per second to each client, or nil when rate is 0.
+func newRateLimiter(rate float64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{
+		rate:      rate,
+		burst:     math.Max(1, math.Ceil(rate)),
+		buckets:   make(map[string]*tokenBucket),
+		lastPurge: time.Now(),
+	}
+}
+
+// allow takes a token from the bucket of the given client. When there
+// are none, it returns false along with the time until the next one.
+func (rl *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if now.Sub(rl.lastPurge) > RateLimitPurgeInterval {
+		rl.purge(now)
+	}
+
+	b, ok := rl.buckets[client]
+	if !ok {
+		b = &tokenBucket{tokens: rl.burst, last: now}
+		rl.buckets[client] = b
+	}
+	b.tokens = math.Min(rl.burst, b.tokens+now.Sub(b.last).Seconds()*rl.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / rl.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// purge removes the buckets which would be full by now, as they are
+// no different from new ones.
+func (rl *rateLimiter) purge(now time.Time) {
+	for client, b := range rl.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rl.rate >= rl.burst {
+			delete(rl.buckets, client)
+		}
+	}
+	rl.lastPurge = now
+}
+
+// rateLimit wraps the given handler so that clients making more
+// requests than allowed by the read or write limits are rejected with
+// 429 and a Retry-After header. Clients are told apart by their IP
+// address. When there are no limits, the handler is returned as it is.
+func (rest *RESTAPI) rateLimit(next http.Handler) http.Handler {
+	if rest.readLimiter == nil && rest.writeLimiter == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limiter := rest.writeLimiter
+		switch r.Method {
+		case "GET", "HEAD", "OPTIONS":
+			limiter = rest.readLimiter
+		}
+		if limiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			// i.e. Unix domain sockets
+			client = r.RemoteAddr
+		}
+		ok, wait := limiter.allow(client, time.Now())
+		if !ok {
+			logger.Debugf("rate limiting %s: %s %s", client, r.Method, r.URL.Path)
+			secs := int(math.Ceil(wait.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(secs))
+			sendErrorResponse(w, http.StatusTooManyRequests, "too many requests")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,83 @@
+package ipfscluster
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ipfs/ipfs-cluster/test"
+)
+
+func TestRateLimiter(t *testing.T) {
+	if newRateLimiter(0) != nil {
+		t.Fatal("a rate of 0 should disable the limiter")
+	}
+
+	rl := newRateLimiter(2)
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if ok, _ := rl.allow("a", now); !ok {
+			t.Fatal("bursts of one second of requests should be allowed")
+		}
+	}
+	ok, wait := rl.allow("a", now)
+	if ok {
+		t.Fatal("expected the request to be limited")
+	}
+	if wait != 500*time.Millisecond {
+		t.Error("unexpected wait: ", wait)
+	}
+	if ok, _ := rl.allow("b", now); !ok {
+		t.Error("other clients should not be limited")
+	}
+	if ok, _ := rl.allow("a", now.Add(wait)); !ok {
+		t.Error("a token should have been added")
+	}
+
+	rl.purge(now.Add(time.Hour))
+	if len(rl.buckets) != 0 {
+		t.Error("full buckets should have been purged")
+	}
+}
+
+func TestRESTAPIRateLimit(t *testing.T) {
+	rest := &RESTAPI{writeLimiter: newRateLimiter(1)}
+	h := rest.rateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	post := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/pins/"+test.TestCid1, nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := post("10.0.0.1:1234"); rec.Code != http.StatusNoContent {
+		t.Fatal("expected 204 but got", rec.Code)
+	}
+	// Same client from another port
+	rec := post("10.0.0.1:1235")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatal("expected 429 but got", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") != "1" {
+		t.Error("expected a Retry-After header")
+	}
+	if rec := post("10.0.0.2:1234"); rec.Code != http.StatusNoContent {
+		t.Error("other clients should not be limited")
+	}
+
+	// Reads are not limited
+	for i := 0; i < 5; i++ {
+		req := httptest.NewRequest("GET", "/pins", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusNoContent {
+			t.Fatal("reads should not be limited")
+		}
+	}
+}
@@ -2,6 +2,8 @@ package ipfscluster
 
 import (
 	"errors"
+	"runtime"
+	"time"
 
 	peer "github.com/libp2p/go-libp2p-peer"
 
@@ -31,36 +33,239 @@ func (rpcapi *RPCAPI) ID(in struct{}, out *api.IDSerial) error {
 }
 
 // Pin runs Cluster.Pin().
-func (rpcapi *RPCAPI) Pin(in api.CidArgSerial, out *struct{}) error {
-	c := in.ToCidArg().Cid
-	return rpcapi.c.Pin(c)
+func (rpcapi *RPCAPI) Pin(in api.CidArgSerial, out *uint64) error {
+	c := in.ToCidArg()
+	index, err := rpcapi.c.Pin(c)
+	*out = index
+	return err
+}
+
+// PinPath runs Cluster.PinPath().
+func (rpcapi *RPCAPI) PinPath(in api.PinPathSerial, out *api.PinResultSerial) error {
+	h, index, err := rpcapi.c.PinPath(in.Path, in.CidArg.ToCidArg())
+	*out = api.PinResult{Cid: h, Index: index}.ToSerial()
+	return err
+}
+
+// PinMany runs Cluster.PinMany().
+func (rpcapi *RPCAPI) PinMany(in []api.CidArgSerial, out *[]api.PinResultSerial) error {
+	cargs := make([]api.CidArg, len(in), len(in))
+	for i, c := range in {
+		cargs[i] = c.ToCidArg()
+	}
+	results := rpcapi.c.PinMany(cargs)
+	*out = make([]api.PinResultSerial, len(results), len(results))
+	for i, r := range results {
+		(*out)[i] = r.ToSerial()
+	}
+	return nil
 }
 
 // Unpin runs Cluster.Unpin().
 func (rpcapi *RPCAPI) Unpin(in api.CidArgSerial, out *struct{}) error {
-	c := in.ToCidArg().Cid
+	c := in.ToCidArg()
 	return rpcapi.c.Unpin(c)
 }
 
+// PinAcks runs Cluster.PinAcks().
+func (rpcapi *RPCAPI) PinAcks(in api.CidArgSerial, out *[]api.PinAckSerial) error {
+	c := in.ToCidArg().Cid
+	acks, err := rpcapi.c.PinAcks(c)
+	acksSerial := make([]api.PinAckSerial, 0, len(acks))
+	for _, ack := range acks {
+		acksSerial = append(acksSerial, ack.ToSerial())
+	}
+	*out = acksSerial
+	return err
+}
+
+// Verify runs Cluster.Verify().
+func (rpcapi *RPCAPI) Verify(in api.CidArgSerial, out *[]api.VerifyResultSerial) error {
+	c := in.ToCidArg().Cid
+	results, err := rpcapi.c.Verify(c)
+	resultsSerial := make([]api.VerifyResultSerial, 0, len(results))
+	for _, r := range results {
+		resultsSerial = append(resultsSerial, r.ToSerial())
+	}
+	*out = resultsSerial
+	return err
+}
+
+// ServingPeer runs Cluster.ServingPeer().
+func (rpcapi *RPCAPI) ServingPeer(in api.CidArgSerial, out *api.IDSerial) error {
+	c := in.ToCidArg().Cid
+	id, err := rpcapi.c.ServingPeer(c)
+	*out = id.ToSerial()
+	return err
+}
+
+// VerifyLocal runs Cluster.VerifyLocal().
+func (rpcapi *RPCAPI) VerifyLocal(in api.CidArgSerial, out *api.VerifyResultSerial) error {
+	c := in.ToCidArg().Cid
+	*out = rpcapi.c.VerifyLocal(c).ToSerial()
+	return nil
+}
+
+// Protect runs Cluster.Protect(). The Protected field of the
+// argument is the protection to set.
+func (rpcapi *RPCAPI) Protect(in api.CidArgSerial, out *struct{}) error {
+	c := in.ToCidArg()
+	return rpcapi.c.Protect(c.Cid, c.Protected)
+}
+
+// UnpinIfHealthy runs Cluster.UnpinIfHealthy().
+func (rpcapi *RPCAPI) UnpinIfHealthy(in api.CidArgSerial, out *struct{}) error {
+	c := in.ToCidArg()
+	return rpcapi.c.UnpinIfHealthy(c)
+}
+
+// ForceUnpin runs Cluster.ForceUnpin().
+func (rpcapi *RPCAPI) ForceUnpin(in api.CidArgSerial, out *struct{}) error {
+	c := in.ToCidArg().Cid
+	return rpcapi.c.ForceUnpin(c)
+}
+
+// WaitForIndex runs Cluster.WaitForIndex().
+func (rpcapi *RPCAPI) WaitForIndex(in uint64, out *struct{}) error {
+	return rpcapi.c.WaitForIndex(in)
+}
+
 // PinList runs Cluster.Pins().
 func (rpcapi *RPCAPI) PinList(in struct{}, out *[]api.CidArgSerial) error {
-	cidList := rpcapi.c.Pins()
-	cidSerialList := make([]api.CidArgSerial, 0, len(cidList))
-	for _, c := range cidList {
-		cidSerialList = append(cidSerialList, c.ToSerial())
-	}
-	*out = cidSerialList
+	*out = cidArgsToSerial(rpcapi.c.Pins())
+	return nil
+}
+
+// LocalAllocations runs Cluster.LocalAllocations().
+func (rpcapi *RPCAPI) LocalAllocations(in struct{}, out *[]api.CidArgSerial) error {
+	*out = cidArgsToSerial(rpcapi.c.LocalAllocations())
+	return nil
+}
+
+// PinListByPeer runs Cluster.PinsByPeer().
+func (rpcapi *RPCAPI) PinListByPeer(in peer.ID, out *[]api.CidArgSerial) error {
+	*out = cidArgsToSerial(rpcapi.c.PinsByPeer(in))
+	return nil
+}
+
+// StateStale runs Cluster.StateStale().
+func (rpcapi *RPCAPI) StateStale(in struct{}, out *bool) error {
+	*out = rpcapi.c.StateStale()
+	return nil
+}
+
+// PinListByNamespace runs Cluster.PinsByNamespace().
+func (rpcapi *RPCAPI) PinListByNamespace(in string, out *[]api.CidArgSerial) error {
+	*out = cidArgsToSerial(rpcapi.c.PinsByNamespace(in))
+	return nil
+}
+
+// PinListByName runs Cluster.PinsByName().
+func (rpcapi *RPCAPI) PinListByName(in string, out *[]api.CidArgSerial) error {
+	*out = cidArgsToSerial(rpcapi.c.PinsByName(in))
+	return nil
+}
+
+// PinListByMetadata runs Cluster.PinsByMetadata().
+func (rpcapi *RPCAPI) PinListByMetadata(in api.MetadataFilter, out *[]api.CidArgSerial) error {
+	*out = cidArgsToSerial(rpcapi.c.PinsByMetadata(in.Key, in.Value))
 	return nil
 }
 
+// Allocations runs Cluster.Allocations().
+func (rpcapi *RPCAPI) Allocations(in api.CidArgSerial, out *api.CidArgSerial) error {
+	c := in.ToCidArg().Cid
+	carg, err := rpcapi.c.Allocations(c)
+	*out = carg.ToSerial()
+	return err
+}
+
+// AllocationPreview runs Cluster.AllocationPreview(). The
+// ReplicationFactorMin field of the argument is the replication
+// factor to use.
+func (rpcapi *RPCAPI) AllocationPreview(in api.CidArgSerial, out *api.AllocationPreviewSerial) error {
+	c := in.ToCidArg()
+	preview, err := rpcapi.c.AllocationPreview(c.Cid, c.ReplicationFactorMin)
+	*out = preview.ToSerial()
+	return err
+}
+
 // Version runs Cluster.Version().
 func (rpcapi *RPCAPI) Version(in struct{}, out *api.Version) error {
 	*out = api.Version{
-		Version: rpcapi.c.Version(),
+		Version:            rpcapi.c.Version(),
+		Commit:             Commit,
+		RPCProtocolVersion: string(RPCProtocol),
+		GoVersion:          runtime.Version(),
 	}
 	return nil
 }
 
+// Health runs Cluster.Health().
+func (rpcapi *RPCAPI) Health(in struct{}, out *api.Health) error {
+	*out = rpcapi.c.Health()
+	return nil
+}
+
+// Summary runs Cluster.Summary().
+func (rpcapi *RPCAPI) Summary(in struct{}, out *api.Summary) error {
+	*out = rpcapi.c.Summary()
+	return nil
+}
+
+// QueueInfo runs Cluster.QueueInfo().
+func (rpcapi *RPCAPI) QueueInfo(in struct{}, out *api.QueueInfo) error {
+	*out = rpcapi.c.QueueInfo()
+	return nil
+}
+
+// ConsistencyCheck runs Cluster.ConsistencyCheck().
+func (rpcapi *RPCAPI) ConsistencyCheck(in struct{}, out *api.ConsistencyReportSerial) error {
+	*out = rpcapi.c.ConsistencyCheck().ToSerial()
+	return nil
+}
+
+// ConsensusView runs Cluster.ConsensusView().
+func (rpcapi *RPCAPI) ConsensusView(in struct{}, out *api.ConsensusViewSerial) error {
+	*out = rpcapi.c.ConsensusView().ToSerial()
+	return nil
+}
+
+// RaftConfiguration runs Cluster.RaftConfiguration().
+func (rpcapi *RPCAPI) RaftConfiguration(in struct{}, out *[]api.RaftServerSerial) error {
+	servers, err := rpcapi.c.RaftConfiguration()
+	serials := make([]api.RaftServerSerial, len(servers), len(servers))
+	for i, s := range servers {
+		serials[i] = s.ToSerial()
+	}
+	*out = serials
+	return err
+}
+
+// ExportState runs Cluster.ExportState().
+func (rpcapi *RPCAPI) ExportState(in struct{}, out *[]byte) error {
+	data, err := rpcapi.c.ExportState()
+	*out = data
+	return err
+}
+
+// ImportState runs Cluster.ImportState().
+func (rpcapi *RPCAPI) ImportState(in []byte, out *struct{}) error {
+	return rpcapi.c.ImportState(in)
+}
+
+// Snapshot runs Cluster.Snapshot().
+func (rpcapi *RPCAPI) Snapshot(in struct{}, out *struct{}) error {
+	return rpcapi.c.Snapshot()
+}
+
+// ReconcileDryRun runs Cluster.ReconcileDryRun().
+func (rpcapi *RPCAPI) ReconcileDryRun(in struct{}, out *api.ReconcilePlanSerial) error {
+	plan, err := rpcapi.c.ReconcileDryRun()
+	*out = plan.ToSerial()
+	return err
+}
+
 // Peers runs Cluster.Peers().
 func (rpcapi *RPCAPI) Peers(in struct{}, out *[]api.IDSerial) error {
 	peers := rpcapi.c.Peers()
@@ -72,6 +277,13 @@ func (rpcapi *RPCAPI) Peers(in struct{}, out *[]api.IDSerial) error {
 	return nil
 }
 
+// PeerInfo runs Cluster.PeerInfo().
+func (rpcapi *RPCAPI) PeerInfo(in peer.ID, out *api.IDSerial) error {
+	id, err := rpcapi.c.PeerInfo(in)
+	*out = id.ToSerial()
+	return err
+}
+
 // PeerAdd runs Cluster.PeerAdd().
 func (rpcapi *RPCAPI) PeerAdd(in api.MultiaddrSerial, out *api.IDSerial) error {
 	addr := in.ToMultiaddr()
@@ -80,11 +292,49 @@ func (rpcapi *RPCAPI) PeerAdd(in api.MultiaddrSerial, out *api.IDSerial) error {
 	return err
 }
 
+// PeerAddForce runs Cluster.PeerAddForce().
+func (rpcapi *RPCAPI) PeerAddForce(in api.MultiaddrSerial, out *api.IDSerial) error {
+	addr := in.ToMultiaddr()
+	id, err := rpcapi.c.PeerAddForce(addr)
+	*out = id.ToSerial()
+	return err
+}
+
 // PeerRemove runs Cluster.PeerRm().
 func (rpcapi *RPCAPI) PeerRemove(in peer.ID, out *struct{}) error {
 	return rpcapi.c.PeerRemove(in)
 }
 
+// Leave runs Cluster.Leave().
+func (rpcapi *RPCAPI) Leave(in struct{}, out *struct{}) error {
+	return rpcapi.c.Leave()
+}
+
+// SendAlert sends an alert raised by a component of this peer to the
+// subscribers of Cluster.SubscribeAlerts().
+func (rpcapi *RPCAPI) SendAlert(in api.AlertSerial, out *struct{}) error {
+	rpcapi.c.alerts.publish(in.ToAlert())
+	return nil
+}
+
+// ReplacePeer runs Cluster.ReplacePeer(). The input holds the
+// multiaddresses of the old and the new peer, in that order.
+func (rpcapi *RPCAPI) ReplacePeer(in api.MultiaddrsSerial, out *api.PeerReplacementSerial) error {
+	if len(in) != 2 {
+		return errors.New("expected the old and new peer multiaddresses")
+	}
+	addrs := in.ToMultiaddrs()
+	pr, err := rpcapi.c.ReplacePeer(addrs[0], addrs[1])
+	*out = pr.ToSerial()
+	return err
+}
+
+// PeerReplacement runs Cluster.PeerReplacement().
+func (rpcapi *RPCAPI) PeerReplacement(in struct{}, out *api.PeerReplacementSerial) error {
+	*out = rpcapi.c.PeerReplacement().ToSerial()
+	return nil
+}
+
 // Join runs Cluster.Join().
 func (rpcapi *RPCAPI) Join(in api.MultiaddrSerial, out *struct{}) error {
 	addr := in.ToMultiaddr()
@@ -92,13 +342,23 @@ func (rpcapi *RPCAPI) Join(in api.MultiaddrSerial, out *struct{}) error {
 	return err
 }
 
-// StatusAll runs Cluster.StatusAll().
-func (rpcapi *RPCAPI) StatusAll(in struct{}, out *[]api.GlobalPinInfoSerial) error {
-	pinfos, err := rpcapi.c.StatusAll()
+// StatusAll runs Cluster.StatusAll() as the operation with the given
+// ID (see CancelOp).
+func (rpcapi *RPCAPI) StatusAll(in string, out *[]api.GlobalPinInfoSerial) error {
+	ctx, done := rpcapi.c.ops.start(rpcapi.c.ctx, in)
+	defer done()
+	pinfos, err := rpcapi.c.StatusAll(ctx)
 	*out = globalPinInfoSliceToSerial(pinfos)
 	return err
 }
 
+// StatusAllPage runs Cluster.StatusAllPage().
+func (rpcapi *RPCAPI) StatusAllPage(in api.PageRequest, out *api.StatusPageSerial) error {
+	page, err := rpcapi.c.StatusAllPage(rpcapi.c.ctx, in.After, in.Limit)
+	*out = page.ToSerial()
+	return err
+}
+
 // Status runs Cluster.Status().
 func (rpcapi *RPCAPI) Status(in api.CidArgSerial, out *api.GlobalPinInfoSerial) error {
 	c := in.ToCidArg().Cid
@@ -107,9 +367,30 @@ func (rpcapi *RPCAPI) Status(in api.CidArgSerial, out *api.GlobalPinInfoSerial)
 	return err
 }
 
-// SyncAllLocal runs Cluster.SyncAllLocal().
-func (rpcapi *RPCAPI) SyncAllLocal(in struct{}, out *[]api.PinInfoSerial) error {
-	pinfos, err := rpcapi.c.SyncAllLocal()
+// StatusCids runs Cluster.StatusCids().
+func (rpcapi *RPCAPI) StatusCids(in []string, out *[]api.GlobalPinInfoSerial) error {
+	cids, err := decodeCids(in)
+	if err != nil {
+		return err
+	}
+	pinfos, err := rpcapi.c.StatusCids(rpcapi.c.ctx, cids)
+	*out = globalPinInfoSliceToSerial(pinfos)
+	return err
+}
+
+// StatusChanges runs Cluster.StatusChanges().
+func (rpcapi *RPCAPI) StatusChanges(in api.StatusChangesRequest, out *api.StatusChangesSerial) error {
+	changes, err := rpcapi.c.StatusChanges(in)
+	*out = changes.ToSerial()
+	return err
+}
+
+// SyncAllLocal runs Cluster.SyncAllLocal() as the operation with the
+// given ID (see CancelOp).
+func (rpcapi *RPCAPI) SyncAllLocal(in string, out *[]api.PinInfoSerial) error {
+	ctx, done := rpcapi.c.ops.start(rpcapi.c.ctx, in)
+	defer done()
+	pinfos, err := rpcapi.c.SyncAllLocal(ctx)
 	*out = pinInfoSliceToSerial(pinfos)
 	return err
 }
@@ -122,9 +403,12 @@ func (rpcapi *RPCAPI) SyncLocal(in api.CidArgSerial, out *api.PinInfoSerial) err
 	return err
 }
 
-// SyncAll runs Cluster.SyncAll().
-func (rpcapi *RPCAPI) SyncAll(in struct{}, out *[]api.GlobalPinInfoSerial) error {
-	pinfos, err := rpcapi.c.SyncAll()
+// SyncAll runs Cluster.SyncAll() as the operation with the given ID
+// (see CancelOp).
+func (rpcapi *RPCAPI) SyncAll(in string, out *[]api.GlobalPinInfoSerial) error {
+	ctx, done := rpcapi.c.ops.start(rpcapi.c.ctx, in)
+	defer done()
+	pinfos, err := rpcapi.c.SyncAll(ctx)
 	*out = globalPinInfoSliceToSerial(pinfos)
 	return err
 }
@@ -152,6 +436,55 @@ func (rpcapi *RPCAPI) Recover(in api.CidArgSerial, out *api.GlobalPinInfoSerial)
 	return err
 }
 
+// RecoverAllLocal runs Cluster.RecoverAllLocal() as the operation with
+// the given ID (see CancelOp).
+func (rpcapi *RPCAPI) RecoverAllLocal(in string, out *[]api.PinInfoSerial) error {
+	ctx, done := rpcapi.c.ops.start(rpcapi.c.ctx, in)
+	defer done()
+	pinfos, err := rpcapi.c.RecoverAllLocal(ctx)
+	*out = pinInfoSliceToSerial(pinfos)
+	return err
+}
+
+// RecoverAll runs Cluster.RecoverAll() as the operation with the given
+// ID (see CancelOp).
+func (rpcapi *RPCAPI) RecoverAll(in string, out *[]api.GlobalPinInfoSerial) error {
+	ctx, done := rpcapi.c.ops.start(rpcapi.c.ctx, in)
+	defer done()
+	pinfos, err := rpcapi.c.RecoverAll(ctx)
+	*out = globalPinInfoSliceToSerial(pinfos)
+	return err
+}
+
+// CancelOp cancels the StatusAll, SyncAll, RecoverAll, SyncAllLocal or
+// RecoverAllLocal operation with the given ID, which may not have
+// started yet.
+func (rpcapi *RPCAPI) CancelOp(in string, out *struct{}) error {
+	rpcapi.c.ops.cancel(in)
+	return nil
+}
+
+// Reallocate runs Cluster.Reallocate().
+func (rpcapi *RPCAPI) Reallocate(in api.CidArgSerial, out *api.GlobalPinInfoSerial) error {
+	carg := in.ToCidArg()
+	pinfo, err := rpcapi.c.Reallocate(carg.Cid, carg.Allocations)
+	*out = pinfo.ToSerial()
+	return err
+}
+
+// Touch runs Cluster.Touch().
+func (rpcapi *RPCAPI) Touch(in api.CidArgSerial, out *struct{}) error {
+	c := in.ToCidArg().Cid
+	rpcapi.c.Touch(c)
+	return nil
+}
+
+// AccessTimes runs Cluster.AccessTimes().
+func (rpcapi *RPCAPI) AccessTimes(in struct{}, out *map[string]time.Time) error {
+	*out = rpcapi.c.AccessTimes()
+	return nil
+}
+
 /*
    Tracker component methods
 */
@@ -167,9 +500,28 @@ func (rpcapi *RPCAPI) Untrack(in api.CidArgSerial, out *struct{}) error {
 	return rpcapi.c.tracker.Untrack(c)
 }
 
+// TrackerLoad runs PinTracker.Load().
+func (rpcapi *RPCAPI) TrackerLoad(in struct{}, out *api.TrackerLoad) error {
+	*out = rpcapi.c.tracker.Load()
+	return nil
+}
+
+// TrackerAck runs Cluster.trackerAck().
+func (rpcapi *RPCAPI) TrackerAck(in api.CidArgSerial, out *api.PinAckSerial) error {
+	c := in.ToCidArg().Cid
+	*out = rpcapi.c.trackerAck(c).ToSerial()
+	return nil
+}
+
 // TrackerStatusAll runs PinTracker.StatusAll().
 func (rpcapi *RPCAPI) TrackerStatusAll(in struct{}, out *[]api.PinInfoSerial) error {
-	*out = pinInfoSliceToSerial(rpcapi.c.tracker.StatusAll())
+	*out = pinInfoSliceToSerial(rpcapi.c.tracker.StatusAll(rpcapi.c.ctx))
+	return nil
+}
+
+// TrackerStatusPage runs PinTracker.StatusPage().
+func (rpcapi *RPCAPI) TrackerStatusPage(in api.PageRequest, out *[]api.PinInfoSerial) error {
+	*out = pinInfoSliceToSerial(rpcapi.c.tracker.StatusPage(in.After, in.Limit))
 	return nil
 }
 
@@ -181,6 +533,32 @@ func (rpcapi *RPCAPI) TrackerStatus(in api.CidArgSerial, out *api.PinInfoSerial)
 	return nil
 }
 
+// TrackerStatusCids runs PinTracker.Status() for each of the given
+// Cids.
+func (rpcapi *RPCAPI) TrackerStatusCids(in []string, out *[]api.PinInfoSerial) error {
+	cids, err := decodeCids(in)
+	if err != nil {
+		return err
+	}
+	pinfos := make([]api.PinInfo, len(cids), len(cids))
+	for i, c := range cids {
+		pinfos[i] = rpcapi.c.tracker.Status(c)
+	}
+	*out = pinInfoSliceToSerial(pinfos)
+	return nil
+}
+
+// TrackerSetPinProgress runs PinTracker.SetPinProgress() with the Cid
+// and Progress of the given PinInfo.
+func (rpcapi *RPCAPI) TrackerSetPinProgress(in api.PinInfoSerial, out *struct{}) error {
+	pinfo := in.ToPinInfo()
+	if pinfo.Cid == nil {
+		return errors.New("a valid Cid is required")
+	}
+	rpcapi.c.tracker.SetPinProgress(pinfo.Cid, pinfo.Progress)
+	return nil
+}
+
 // TrackerRecover runs PinTracker.Recover().
 func (rpcapi *RPCAPI) TrackerRecover(in api.CidArgSerial, out *api.PinInfoSerial) error {
 	c := in.ToCidArg().Cid
@@ -195,8 +573,14 @@ func (rpcapi *RPCAPI) TrackerRecover(in api.CidArgSerial, out *api.PinInfoSerial
 
 // IPFSPin runs IPFSConnector.Pin().
 func (rpcapi *RPCAPI) IPFSPin(in api.CidArgSerial, out *struct{}) error {
-	c := in.ToCidArg().Cid
-	return rpcapi.c.ipfs.Pin(c)
+	c := in.ToCidArg()
+	return rpcapi.c.ipfs.Pin(c.Cid, c.Type)
+}
+
+// IPFSPinPresent runs IPFSConnector.PinPresent().
+func (rpcapi *RPCAPI) IPFSPinPresent(in api.CidArgSerial, out *struct{}) error {
+	c := in.ToCidArg()
+	return rpcapi.c.ipfs.PinPresent(c.Cid, c.Type)
 }
 
 // IPFSUnpin runs IPFSConnector.Unpin().
@@ -220,20 +604,59 @@ func (rpcapi *RPCAPI) IPFSPinLs(in string, out *map[string]api.IPFSPinStatus) er
 	return err
 }
 
+// IPFSRepoStat runs IPFSConnector.RepoStat().
+func (rpcapi *RPCAPI) IPFSRepoStat(in struct{}, out *api.IPFSRepoStat) error {
+	rs, err := rpcapi.c.ipfs.RepoStat()
+	*out = rs
+	return err
+}
+
+// IPFSBandwidthStats runs IPFSConnector.BandwidthStats().
+func (rpcapi *RPCAPI) IPFSBandwidthStats(in struct{}, out *api.IPFSBandwidth) error {
+	bw, err := rpcapi.c.ipfs.BandwidthStats()
+	*out = bw
+	return err
+}
+
 /*
    Consensus component methods
 */
 
 // ConsensusLogPin runs Consensus.LogPin().
-func (rpcapi *RPCAPI) ConsensusLogPin(in api.CidArgSerial, out *struct{}) error {
+func (rpcapi *RPCAPI) ConsensusLogPin(in api.CidArgSerial, out *uint64) error {
 	c := in.ToCidArg()
-	return rpcapi.c.consensus.LogPin(c)
+	index, err := rpcapi.c.consensus.LogPin(c)
+	*out = index
+	return err
+}
+
+// ConsensusLogPinMany runs Consensus.LogPinMany().
+func (rpcapi *RPCAPI) ConsensusLogPinMany(in []api.CidArgSerial, out *uint64) error {
+	cargs := make([]api.CidArg, len(in), len(in))
+	for i, c := range in {
+		cargs[i] = c.ToCidArg()
+	}
+	index, err := rpcapi.c.consensus.LogPinMany(cargs)
+	*out = index
+	return err
 }
 
 // ConsensusLogUnpin runs Consensus.LogUnpin().
-func (rpcapi *RPCAPI) ConsensusLogUnpin(in api.CidArgSerial, out *struct{}) error {
+func (rpcapi *RPCAPI) ConsensusLogUnpin(in api.CidArgSerial, out *uint64) error {
 	c := in.ToCidArg()
-	return rpcapi.c.consensus.LogUnpin(c)
+	index, err := rpcapi.c.consensus.LogUnpin(c)
+	*out = index
+	return err
+}
+
+// ConsensusSnapshot runs Consensus.Snapshot().
+func (rpcapi *RPCAPI) ConsensusSnapshot(in struct{}, out *struct{}) error {
+	return rpcapi.c.consensus.Snapshot()
+}
+
+// ConsensusImportState runs Consensus.ImportState().
+func (rpcapi *RPCAPI) ConsensusImportState(in []byte, out *struct{}) error {
+	return rpcapi.c.consensus.ImportState(in)
 }
 
 // ConsensusLogAddPeer runs Consensus.LogAddPeer().
@@ -291,6 +714,12 @@ func (rpcapi *RPCAPI) PeerMonitorLogMetric(in api.Metric, out *struct{}) error {
 	return nil
 }
 
+// PeerMonitorExpiredPeers runs PeerMonitor.ExpiredPeers().
+func (rpcapi *RPCAPI) PeerMonitorExpiredPeers(in string, out *[]peer.ID) error {
+	*out = rpcapi.c.monitor.ExpiredPeers(in)
+	return nil
+}
+
 // PeerMonitorLastMetrics runs PeerMonitor.LastMetrics().
 func (rpcapi *RPCAPI) PeerMonitorLastMetrics(in string, out *[]api.Metric) error {
 	*out = rpcapi.c.monitor.LastMetrics(in)
@@ -313,3 +742,11 @@ func (rpcapi *RPCAPI) RemoteMultiaddrForPeer(in peer.ID, out *api.MultiaddrSeria
 	*out = api.MultiaddrToSerial(multiaddrJoin(conns[0].RemoteMultiaddr(), in))
 	return nil
 }
+
+func cidArgsToSerial(cidList []api.CidArg) []api.CidArgSerial {
+	cidSerialList := make([]api.CidArgSerial, 0, len(cidList))
+	for _, c := range cidList {
+		cidSerialList = append(cidSerialList, c.ToSerial())
+	}
+	return cidSerialList
+}
@@ -0,0 +1,163 @@
+package ipfscluster
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+
+	"github.com/ipfs/ipfs-cluster/api"
+
+	cid "github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+var errNoServingPeer = errors.New("none of the peers allocated to this cid can serve it")
+
+// ServingPeer returns the ID of the peer which should serve the content
+// of the given Cid, chosen among the peers allocated to it according to
+// the ReadStrategy. The ID includes the addresses of the peer's IPFS
+// daemon. Peers which cannot be contacted, or whose IPFS daemon is not
+// available, are skipped in favour of the next one.
+func (c *Cluster) ServingPeer(h *cid.Cid) (api.ID, error) {
+	cState, err := c.consensus.State()
+	if err != nil {
+		return api.ID{}, err
+	}
+	if !cState.Has(h) {
+		return api.ID{}, errNotPinned(h)
+	}
+
+	carg := cState.Get(h)
+	candidates := carg.Allocations
+	if carg.Everywhere {
+		candidates = c.peerManager.peers()
+	}
+
+	switch c.config.ReadStrategy {
+	case ReadStrategyLowestLatency:
+		return c.fastestPeer(candidates)
+	case ReadStrategyLeastLoaded:
+		candidates = orderByLoad(h, candidates, c.lastMetrics(), c.allocator)
+	default:
+		candidates = shufflePeers(candidates)
+	}
+
+	for _, p := range candidates {
+		id, err := c.probePeer(c.ctx, p)
+		if err != nil {
+			logger.Warningf("%s cannot serve %s: %s", p.Pretty(), h, err)
+			continue
+		}
+		return id, nil
+	}
+	return api.ID{}, errNoServingPeer
+}
+
+// probePeer returns the ID of a peer, or an error if it or its
+// IPFS daemon cannot be contacted.
+func (c *Cluster) probePeer(ctx context.Context, p peer.ID) (api.ID, error) {
+	var idSerial api.IDSerial
+	err := c.rpcClient.CallContext(ctx, p, "Cluster", "ID", struct{}{}, &idSerial)
+	if err != nil {
+		return api.ID{}, err
+	}
+	id := idSerial.ToID()
+	if id.IPFS.Error != "" {
+		return id, errors.New(id.IPFS.Error)
+	}
+	return id, nil
+}
+
+// fastestPeer probes all the given peers at the same time and returns
+// the ID of the first one which answers successfully. The other probes
+// are cancelled.
+func (c *Cluster) fastestPeer(peers []peer.ID) (api.ID, error) {
+	ctx, cancel := context.WithCancel(c.ctx)
+	defer cancel()
+
+	type probe struct {
+		id  api.ID
+		err error
+	}
+	// buffered so that the probes which lose do not block
+	probes := make(chan probe, len(peers))
+	for _, p := range peers {
+		go func(p peer.ID) {
+			id, err := c.probePeer(ctx, p)
+			probes <- probe{id, err}
+		}(p)
+	}
+
+	for range peers {
+		pr := <-probes
+		if pr.err == nil {
+			return pr.id, nil
+		}
+		logger.Debugf("probe failed: %s", pr.err)
+	}
+	return api.ID{}, errNoServingPeer
+}
+
+// lastMetrics returns the latest metrics of the Informer for all
+// peers, as known by the leader's PeerMonitor.
+func (c *Cluster) lastMetrics() []api.Metric {
+	var metrics []api.Metric
+	l, err := c.consensus.Leader()
+	if err != nil {
+		return metrics
+	}
+	err = c.rpcClient.Call(l,
+		"Cluster", "PeerMonitorLastMetrics",
+		c.informer.Name(),
+		&metrics)
+	if err != nil {
+		logger.Warningf("could not obtain metrics: %s", err)
+	}
+	return metrics
+}
+
+// orderByLoad sorts the peers in the order of preference of the
+// allocator, which knows how to compare the metrics of its Informer
+// (i.e. fewer pins or more free space first). Peers without a valid
+// metric are placed last.
+func orderByLoad(h *cid.Cid, peers []peer.ID, metrics []api.Metric, alloc PinAllocator) []peer.ID {
+	isCandidate := make(map[peer.ID]bool, len(peers))
+	for _, p := range peers {
+		isCandidate[p] = true
+	}
+	candidates := make(map[peer.ID]api.Metric)
+	for _, m := range metrics {
+		if isCandidate[m.Peer] && !m.Discard() {
+			candidates[m.Peer] = m
+		}
+	}
+
+	preferred, err := alloc.Allocate(h, nil, candidates)
+	if err != nil {
+		logger.Warningf("could not order peers by load: %s", err)
+	}
+
+	ordered := make([]peer.ID, 0, len(peers))
+	added := make(map[peer.ID]bool, len(peers))
+	for _, p := range preferred {
+		if _, ok := candidates[p]; ok && !added[p] {
+			ordered = append(ordered, p)
+			added[p] = true
+		}
+	}
+	for _, p := range peers {
+		if !added[p] {
+			ordered = append(ordered, p)
+			added[p] = true
+		}
+	}
+	return ordered
+}
+
+func shufflePeers(peers []peer.ID) []peer.ID {
+	shuffled := make([]peer.ID, len(peers))
+	for i, j := range rand.Perm(len(peers)) {
+		shuffled[i] = peers[j]
+	}
+	return shuffled
+}
@@ -0,0 +1,53 @@
+package ipfscluster
+
+import (
+	"testing"
+
+	"github.com/ipfs/ipfs-cluster/allocator/freespacealloc"
+	"github.com/ipfs/ipfs-cluster/allocator/numpinalloc"
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/informer/freespace"
+	"github.com/ipfs/ipfs-cluster/informer/numpin"
+	"github.com/ipfs/ipfs-cluster/test"
+
+	cid "github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+func TestOrderByLoad(t *testing.T) {
+	p1 := test.TestPeerID1
+	p2 := test.TestPeerID2
+	p3 := test.TestPeerID3
+	h, _ := cid.Decode(test.TestCid1)
+
+	metric := func(name string, p peer.ID, v string, valid bool) api.Metric {
+		m := api.Metric{Name: name, Peer: p, Value: v, Valid: valid}
+		m.SetTTL(60)
+		return m
+	}
+	check := func(ordered, expected []peer.ID) {
+		for i, p := range expected {
+			if ordered[i] != p {
+				t.Fatalf("expected %s but got %s", expected, ordered)
+			}
+		}
+	}
+
+	// Fewer pins first
+	metrics := []api.Metric{
+		metric(numpin.MetricName, p1, "10", true),
+		metric(numpin.MetricName, p2, "5", true),
+		metric(numpin.MetricName, p3, "1", false),
+	}
+	ordered := orderByLoad(h, []peer.ID{p1, p2, p3}, metrics, numpinalloc.NewAllocator())
+	check(ordered, []peer.ID{p2, p1, p3})
+
+	// More free space first
+	metrics = []api.Metric{
+		metric(freespace.MetricName, p1, "10", true),
+		metric(freespace.MetricName, p2, "5", true),
+		metric(freespace.MetricName, p3, "1", false),
+	}
+	ordered = orderByLoad(h, []peer.ID{p3, p2, p1}, metrics, freespacealloc.NewAllocator())
+	check(ordered, []peer.ID{p1, p2, p3})
+}
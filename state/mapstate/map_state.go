@@ -1,37 +1,186 @@
 package mapstate
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/ipfs/ipfs-cluster/api"
 
 	cid "github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-peer"
 )
 
 // Version is the map state Version. States with old versions should
 // perform an upgrade before.
 const Version = 1
 
+// migrations holds the steps which upgrade a state to the next version.
+// migrations[v] upgrades a state with version v to version v+1, so
+// there must be one per version. New steps are appended whenever the
+// serialized format changes and Version is bumped.
+var migrations = []func(st *MapState) error{
+	// 0 -> 1: states written before versions were set. The format
+	// is the same.
+	func(st *MapState) error { return nil },
+}
+
 // MapState is a very simple database to store the state of the system
 // using a Go map. It is thread safe. It implements the State interface.
+//
+// MapState keeps secondary indexes of the pins by allocation, by
+// namespace, by name and by metadata, so that they can be listed
+// without going through every pin. The indexes are not serialized:
+// they are rebuilt from the PinMap the first time they are needed
+// after the state is decoded or migrated.
 type MapState struct {
 	pinMux  sync.RWMutex
 	PinMap  map[string]api.CidArgSerial
 	Version int
+
+	// indexValid is unset whenever the PinMap is replaced, so
+	// that the indexes are rebuilt.
+	indexValid  bool
+	byPeer      map[string]map[string]struct{}
+	byNamespace map[string]map[string]struct{}
+	byName      map[string]map[string]struct{}
+	// keyed by metadataKey
+	byMetadata map[string]map[string]struct{}
+	everywhere map[string]struct{}
 }
 
 // NewMapState initializes the internal map and returns a new MapState object.
 func NewMapState() *MapState {
 	return &MapState{
-		PinMap: make(map[string]api.CidArgSerial),
+		PinMap:  make(map[string]api.CidArgSerial),
+		Version: Version,
 	}
 }
 
+// Migrate upgrades the state to the current Version by applying the
+// necessary steps in order. It should be called whenever a state has
+// been decoded from a snapshot, which may have been taken by an older
+// version. States newer than Version cannot be loaded and return an
+// error. The indexes are rebuilt afterwards, as the pins may have been
+// decoded into this state.
+func (st *MapState) Migrate() error {
+	st.pinMux.Lock()
+	defer st.pinMux.Unlock()
+	st.indexValid = false
+	if st.Version > Version {
+		return fmt.Errorf("state version %d is newer than the supported version %d",
+			st.Version, Version)
+	}
+	if st.Version < 0 {
+		return fmt.Errorf("invalid state version %d", st.Version)
+	}
+	if st.PinMap == nil {
+		st.PinMap = make(map[string]api.CidArgSerial)
+	}
+	for st.Version < Version {
+		err := migrations[st.Version](st)
+		if err != nil {
+			return fmt.Errorf("error migrating state from version %d: %s",
+				st.Version, err)
+		}
+		st.Version++
+	}
+	return nil
+}
+
+// UnmarshalJSON decodes a serialized state, replacing the pins held by
+// this one rather than merging them.
+func (st *MapState) UnmarshalJSON(b []byte) error {
+	var serial struct {
+		PinMap  map[string]api.CidArgSerial
+		Version int
+	}
+	err := json.Unmarshal(b, &serial)
+	if err != nil {
+		return err
+	}
+	if serial.PinMap == nil {
+		serial.PinMap = make(map[string]api.CidArgSerial)
+	}
+
+	st.pinMux.Lock()
+	defer st.pinMux.Unlock()
+	st.PinMap = serial.PinMap
+	st.Version = serial.Version
+	st.indexValid = false
+	return nil
+}
+
+// Marshal serializes the state as JSON. It can be restored with
+// Unmarshal.
+func (st *MapState) Marshal() ([]byte, error) {
+	st.pinMux.RLock()
+	defer st.pinMux.RUnlock()
+	return json.Marshal(struct {
+		PinMap  map[string]api.CidArgSerial
+		Version int
+	}{st.PinMap, st.Version})
+}
+
+// Unmarshal replaces the pins of this state with those serialized by
+// Marshal, migrating them to the current Version. It fails, without
+// modifying the state, when any of the pins is not valid.
+func (st *MapState) Unmarshal(data []byte) error {
+	decoded := &MapState{}
+	err := json.Unmarshal(data, decoded)
+	if err != nil {
+		return err
+	}
+	err = decoded.Migrate()
+	if err != nil {
+		return err
+	}
+	for k, carg := range decoded.PinMap {
+		c, err := cid.Decode(carg.Cid)
+		if err != nil {
+			return fmt.Errorf("bad Cid %q: %s", carg.Cid, err)
+		}
+		if c.String() != k {
+			return fmt.Errorf("pin for %s stored as %s", c, k)
+		}
+		for _, p := range carg.Allocations {
+			_, err := peer.IDB58Decode(p)
+			if err != nil {
+				return fmt.Errorf("bad allocation %q for %s: %s", p, k, err)
+			}
+		}
+		_, err = api.PinTypeFromString(carg.Type)
+		if err != nil {
+			return fmt.Errorf("bad pin type for %s: %s", k, err)
+		}
+	}
+
+	st.pinMux.Lock()
+	defer st.pinMux.Unlock()
+	st.PinMap = decoded.PinMap
+	st.Version = decoded.Version
+	st.indexValid = false
+	return nil
+}
+
 // Add adds a CidArg to the internal map.
 func (st *MapState) Add(c api.CidArg) error {
 	st.pinMux.Lock()
 	defer st.pinMux.Unlock()
-	st.PinMap[c.Cid.String()] = c.ToSerial()
+	k := c.Cid.String()
+	carg := c.ToSerial()
+	indexed := st.indexed()
+	old, ok := st.PinMap[k]
+	if indexed && ok {
+		st.unindex(k, old)
+	}
+	st.PinMap[k] = carg
+	if indexed {
+		st.index(k, carg)
+	}
 	return nil
 }
 
@@ -39,7 +188,15 @@ func (st *MapState) Add(c api.CidArg) error {
 func (st *MapState) Rm(c *cid.Cid) error {
 	st.pinMux.Lock()
 	defer st.pinMux.Unlock()
-	delete(st.PinMap, c.String())
+	k := c.String()
+	old, ok := st.PinMap[k]
+	if !ok {
+		return nil
+	}
+	delete(st.PinMap, k)
+	if st.indexed() {
+		st.unindex(k, old)
+	}
 	return nil
 }
 
@@ -54,6 +211,13 @@ func (st *MapState) Get(c *cid.Cid) api.CidArg {
 	return cargs.ToCidArg()
 }
 
+// Len returns the number of pins in the State.
+func (st *MapState) Len() int {
+	st.pinMux.RLock()
+	defer st.pinMux.RUnlock()
+	return len(st.PinMap)
+}
+
 // Has returns true if the Cid belongs to the State.
 func (st *MapState) Has(c *cid.Cid) bool {
 	st.pinMux.RLock()
@@ -72,3 +236,176 @@ func (st *MapState) List() []api.CidArg {
 	}
 	return cids
 }
+
+// ListByPeer provides the list of CidArgs which are allocated to the
+// given peer, including those pinned everywhere.
+func (st *MapState) ListByPeer(p peer.ID) []api.CidArg {
+	var cids []api.CidArg
+	st.withIndex(func() {
+		keys := st.byPeer[peer.IDB58Encode(p)]
+		cids = make([]api.CidArg, 0, len(keys)+len(st.everywhere))
+		for k := range keys {
+			cids = append(cids, st.PinMap[k].ToCidArg())
+		}
+		for k := range st.everywhere {
+			cids = append(cids, st.PinMap[k].ToCidArg())
+		}
+	})
+	return cids
+}
+
+// ListByNamespace provides the list of CidArgs in the given namespace.
+// The empty namespace holds the pins which do not have one.
+func (st *MapState) ListByNamespace(ns string) []api.CidArg {
+	var cids []api.CidArg
+	st.withIndex(func() {
+		cids = st.listKeys(st.byNamespace[ns])
+	})
+	return cids
+}
+
+// ListByName provides the list of CidArgs with the given name. Pins
+// without name are not indexed, so an empty name lists nothing.
+func (st *MapState) ListByName(name string) []api.CidArg {
+	if name == "" {
+		return nil
+	}
+	var cids []api.CidArg
+	st.withIndex(func() {
+		cids = st.listKeys(st.byName[name])
+	})
+	return cids
+}
+
+// ListByMetadata provides the list of CidArgs whose metadata has the
+// given value for the given key.
+func (st *MapState) ListByMetadata(key, value string) []api.CidArg {
+	var cids []api.CidArg
+	st.withIndex(func() {
+		cids = st.listKeys(st.byMetadata[metadataKey(key, value)])
+	})
+	return cids
+}
+
+// listKeys returns the pins with the given keys. It must be called
+// with the lock held.
+func (st *MapState) listKeys(keys map[string]struct{}) []api.CidArg {
+	cids := make([]api.CidArg, 0, len(keys))
+	for k := range keys {
+		cids = append(cids, st.PinMap[k].ToCidArg())
+	}
+	return cids
+}
+
+// metadataKey is the key of the metadata index for a key and a value.
+// The length of the key is included so that any pair is unambiguous.
+func metadataKey(key, value string) string {
+	return fmt.Sprintf("%d:%s%s", len(key), key, value)
+}
+
+// withIndex runs f holding at least a read lock and with the indexes
+// built.
+func (st *MapState) withIndex(f func()) {
+	st.pinMux.RLock()
+	if st.indexed() {
+		defer st.pinMux.RUnlock()
+		f()
+		return
+	}
+	st.pinMux.RUnlock()
+
+	st.pinMux.Lock()
+	defer st.pinMux.Unlock()
+	if !st.indexed() {
+		st.reindex()
+	}
+	f()
+}
+
+// indexed returns true if the indexes match the current PinMap.
+func (st *MapState) indexed() bool {
+	return st.indexValid
+}
+
+// reindex builds the indexes from the PinMap. It must be called
+// with the write lock held.
+func (st *MapState) reindex() {
+	st.byPeer = make(map[string]map[string]struct{})
+	st.byNamespace = make(map[string]map[string]struct{})
+	st.byName = make(map[string]map[string]struct{})
+	st.byMetadata = make(map[string]map[string]struct{})
+	st.everywhere = make(map[string]struct{})
+	for k, carg := range st.PinMap {
+		st.index(k, carg)
+	}
+	st.indexValid = true
+}
+
+func (st *MapState) index(k string, carg api.CidArgSerial) {
+	if carg.Everywhere {
+		st.everywhere[k] = struct{}{}
+	} else {
+		for _, p := range carg.Allocations {
+			addKey(st.byPeer, p, k)
+		}
+	}
+	addKey(st.byNamespace, carg.Namespace, k)
+	if carg.Name != "" {
+		addKey(st.byName, carg.Name, k)
+	}
+	for mk, mv := range carg.Metadata {
+		addKey(st.byMetadata, metadataKey(mk, mv), k)
+	}
+}
+
+func (st *MapState) unindex(k string, carg api.CidArgSerial) {
+	delete(st.everywhere, k)
+	for _, p := range carg.Allocations {
+		rmKey(st.byPeer, p, k)
+	}
+	rmKey(st.byNamespace, carg.Namespace, k)
+	rmKey(st.byName, carg.Name, k)
+	for mk, mv := range carg.Metadata {
+		rmKey(st.byMetadata, metadataKey(mk, mv), k)
+	}
+}
+
+func addKey(idx map[string]map[string]struct{}, v, k string) {
+	keys, ok := idx[v]
+	if !ok {
+		keys = make(map[string]struct{})
+		idx[v] = keys
+	}
+	keys[k] = struct{}{}
+}
+
+func rmKey(idx map[string]map[string]struct{}, v, k string) {
+	keys, ok := idx[v]
+	if !ok {
+		return
+	}
+	delete(keys, k)
+	if len(keys) == 0 {
+		delete(idx, v)
+	}
+}
+
+// Checksum returns a hash of the sorted list of Cids in the state.
+// Peers with the same pins produce the same checksum, so it can be
+// used to cheaply compare states.
+func (st *MapState) Checksum() string {
+	st.pinMux.RLock()
+	defer st.pinMux.RUnlock()
+	keys := make([]string, 0, len(st.PinMap))
+	for k := range st.PinMap {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, k := range keys {
+		h.Write([]byte(k))
+		h.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
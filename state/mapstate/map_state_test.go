@@ -1,6 +1,7 @@
 package mapstate
 
 import (
+	"encoding/json"
 	"testing"
 
 	cid "github.com/ipfs/go-cid"
@@ -51,6 +52,30 @@ func TestGet(t *testing.T) {
 	}
 }
 
+func TestGetNameAndMetadata(t *testing.T) {
+	ms := NewMapState()
+	named := c
+	named.Name = "backup"
+	named.Metadata = map[string]string{"owner": "alice"}
+	ms.Add(named)
+
+	// and through a snapshot
+	b, err := json.Marshal(ms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewMapState()
+	if err := json.Unmarshal(b, restored); err != nil {
+		t.Fatal(err)
+	}
+	for _, st := range []*MapState{ms, restored} {
+		get := st.Get(c.Cid)
+		if get.Name != "backup" || get.Metadata["owner"] != "alice" {
+			t.Errorf("name or metadata lost: %s %v", get.Name, get.Metadata)
+		}
+	}
+}
+
 func TestList(t *testing.T) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -66,3 +91,261 @@ func TestList(t *testing.T) {
 		t.Error("returned something different")
 	}
 }
+
+func TestChecksum(t *testing.T) {
+	ms := NewMapState()
+	empty := ms.Checksum()
+	ms.Add(c)
+	sum := ms.Checksum()
+	if sum == empty {
+		t.Error("checksum should change when adding pins")
+	}
+
+	ms2 := NewMapState()
+	ms2.Add(api.CidArg{Cid: testCid1})
+	if ms2.Checksum() != sum {
+		t.Error("states with the same cids should have the same checksum")
+	}
+
+	ms.Rm(c.Cid)
+	if ms.Checksum() != empty {
+		t.Error("checksum should go back when removing pins")
+	}
+}
+
+func TestListByPeer(t *testing.T) {
+	testCid2, _ := cid.Decode("QmP63DkAFEnDYNjDYBpyNDfttu1fvUw99x1brscPzpqmma")
+	testCid3, _ := cid.Decode("QmP63DkAFEnDYNjDYBpyNDfttu1fvUw99x1brscPzpqmmb")
+	testPeerID2, _ := peer.IDB58Decode("QmUZ13osndQ5uL4tPWHXe3iBgBgq9gfewcBMSCAuMBsDJ6")
+
+	ms := NewMapState()
+	ms.Add(c)
+	ms.Add(api.CidArg{Cid: testCid2, Everywhere: true})
+	ms.Add(api.CidArg{Cid: testCid3, Allocations: []peer.ID{testPeerID2}})
+
+	if l := ms.ListByPeer(testPeerID1); len(l) != 2 {
+		t.Errorf("expected 2 pins for peer 1, got %d", len(l))
+	}
+	if l := ms.ListByPeer(testPeerID2); len(l) != 2 {
+		t.Errorf("expected 2 pins for peer 2, got %d", len(l))
+	}
+
+	// Re-allocating updates the index
+	ms.Add(api.CidArg{Cid: testCid3, Allocations: []peer.ID{testPeerID1}})
+	if l := ms.ListByPeer(testPeerID2); len(l) != 1 || !l[0].Everywhere {
+		t.Error("peer 2 should only have the pin everywhere")
+	}
+	if l := ms.ListByPeer(testPeerID1); len(l) != 3 {
+		t.Errorf("expected 3 pins for peer 1, got %d", len(l))
+	}
+
+	ms.Rm(testCid2)
+	ms.Rm(c.Cid)
+	l := ms.ListByPeer(testPeerID1)
+	if len(l) != 1 || l[0].Cid.String() != testCid3.String() {
+		t.Error("removed pins should not be listed")
+	}
+}
+
+func TestListByNamespace(t *testing.T) {
+	testCid2, _ := cid.Decode("QmP63DkAFEnDYNjDYBpyNDfttu1fvUw99x1brscPzpqmma")
+
+	ms := NewMapState()
+	ms.Add(c)
+	ms.Add(api.CidArg{Cid: testCid2, Namespace: "ns"})
+
+	if l := ms.ListByNamespace("ns"); len(l) != 1 || l[0].Cid.String() != testCid2.String() {
+		t.Error("expected the pin in the namespace")
+	}
+	if l := ms.ListByNamespace(""); len(l) != 1 || l[0].Cid.String() != testCid1.String() {
+		t.Error("expected the pin without namespace")
+	}
+
+	ms.Add(api.CidArg{Cid: testCid2, Namespace: "other"})
+	if l := ms.ListByNamespace("ns"); len(l) != 0 {
+		t.Error("the pin should have moved to the other namespace")
+	}
+}
+
+func TestListByNameAndMetadata(t *testing.T) {
+	testCid2, _ := cid.Decode("QmP63DkAFEnDYNjDYBpyNDfttu1fvUw99x1brscPzpqmma")
+
+	ms := NewMapState()
+	ms.Add(c)
+	ms.Add(api.CidArg{
+		Cid:      testCid2,
+		Name:     "backup",
+		Metadata: map[string]string{"owner": "alice", "env": "prod"},
+	})
+
+	if l := ms.ListByName("backup"); len(l) != 1 || l[0].Cid.String() != testCid2.String() {
+		t.Error("expected the pin with the name")
+	}
+	if l := ms.ListByName(""); len(l) != 0 {
+		t.Error("pins without name should not be listed")
+	}
+	if l := ms.ListByMetadata("owner", "alice"); len(l) != 1 || l[0].Cid.String() != testCid2.String() {
+		t.Error("expected the pin with the metadata")
+	}
+	if l := ms.ListByMetadata("owner", "bob"); len(l) != 0 {
+		t.Error("expected no pins with another value")
+	}
+
+	ms.Add(api.CidArg{
+		Cid:      testCid2,
+		Name:     "other",
+		Metadata: map[string]string{"owner": "bob"},
+	})
+	if l := ms.ListByName("backup"); len(l) != 0 {
+		t.Error("the pin should have been renamed")
+	}
+	if l := ms.ListByMetadata("owner", "alice"); len(l) != 0 {
+		t.Error("the old metadata should not be indexed")
+	}
+	if l := ms.ListByMetadata("env", "prod"); len(l) != 0 {
+		t.Error("the removed metadata should not be indexed")
+	}
+	if l := ms.ListByMetadata("owner", "bob"); len(l) != 1 {
+		t.Error("expected the pin with the new metadata")
+	}
+
+	ms.Rm(testCid2)
+	if l := ms.ListByName("other"); len(l) != 0 {
+		t.Error("removed pins should not be listed")
+	}
+}
+
+func TestIndexRestore(t *testing.T) {
+	testCid2, _ := cid.Decode("QmP63DkAFEnDYNjDYBpyNDfttu1fvUw99x1brscPzpqmma")
+	ms := NewMapState()
+	ms.Add(c)
+	if l := ms.ListByPeer(testPeerID1); len(l) != 1 {
+		t.Fatal("expected 1 pin")
+	}
+
+	// A snapshot with as many pins, but different ones
+	snap := NewMapState()
+	snap.Add(api.CidArg{
+		Cid:       testCid2,
+		Namespace: "ns",
+		Name:      "backup",
+		Metadata:  map[string]string{"owner": "alice"},
+	})
+	b, err := json.Marshal(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Decoding a snapshot into a used state replaces the pins
+	// without going through Add.
+	err = json.Unmarshal(b, ms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ms.Len() != 1 || ms.Has(testCid1) {
+		t.Fatal("the pins should have been replaced")
+	}
+	if l := ms.ListByPeer(testPeerID1); len(l) != 0 {
+		t.Error("the index should have been rebuilt")
+	}
+	if l := ms.ListByNamespace("ns"); len(l) != 1 {
+		t.Error("the index should have been rebuilt")
+	}
+
+	// Pins decoded otherwise are indexed once migrated
+	ms.PinMap[testCid1.String()] = c.ToSerial()
+	delete(ms.PinMap, testCid2.String())
+	err = ms.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := ms.ListByPeer(testPeerID1); len(l) != 1 {
+		t.Error("the index should have been rebuilt after migrating")
+	}
+	if l := ms.ListByNamespace("ns"); len(l) != 0 {
+		t.Error("the index should have been rebuilt after migrating")
+	}
+
+	ms2 := &MapState{}
+	err = json.Unmarshal(b, ms2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := ms2.ListByNamespace("ns"); len(l) != 1 {
+		t.Error("a restored state should be indexed")
+	}
+	if l := ms2.ListByName("backup"); len(l) != 1 {
+		t.Error("a restored state should be indexed by name")
+	}
+	if l := ms2.ListByMetadata("owner", "alice"); len(l) != 1 {
+		t.Error("a restored state should be indexed by metadata")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	ms := NewMapState()
+	ms.Add(c)
+	v0 := NewMapState()
+	v0.Add(c)
+	v0.Version = 0
+
+	// a snapshot taken before versions were set
+	b, err := json.Marshal(v0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewMapState()
+	err = json.Unmarshal(b, restored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Version != 0 {
+		t.Fatal("expected the version of the snapshot")
+	}
+	err = restored.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Version != Version {
+		t.Errorf("expected version %d but got %d", Version, restored.Version)
+	}
+	if restored.Checksum() != ms.Checksum() || len(restored.ListByPeer(testPeerID1)) != 1 {
+		t.Error("the pins should be kept")
+	}
+
+	restored.Version = Version + 1
+	err = restored.Migrate()
+	if err == nil {
+		t.Error("expected an error migrating a newer state")
+	}
+}
+
+func TestMarshalUnmarshal(t *testing.T) {
+	ms := NewMapState()
+	ms.Add(c)
+	b, err := ms.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ms2 := &MapState{}
+	err = ms2.Unmarshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ms2.Version != Version || !ms2.Has(testCid1) {
+		t.Fatal("the state should have been restored")
+	}
+	if l := ms2.ListByPeer(testPeerID1); len(l) != 1 {
+		t.Error("a restored state should be indexed")
+	}
+
+	bad := []byte(`{"PinMap":{"` + testCid1.String() + `":{"cid":"` + testCid1.String() + `","type":"abc"}},"Version":1}`)
+	ms3 := NewMapState()
+	if err := ms3.Unmarshal(bad); err == nil {
+		t.Error("expected an error with a bad pin type")
+	}
+	if ms3.Len() != 0 {
+		t.Error("the state should not be modified when failing")
+	}
+}
@@ -0,0 +1,275 @@
+package ipfscluster
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/ipfs-cluster/api"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// StatusChangesTimeout is the maximum time StatusChanges waits for the
+// global status to change. It should be lower than
+// RESTAPIServerWriteTimeout so that REST long-polls are not cut.
+var StatusChangesTimeout = 5 * time.Second
+
+// StatusChangesInterval is how often the global status is checked for
+// changes while waiting in StatusChanges. All the waiting callers share
+// the same check.
+var StatusChangesInterval = 1 * time.Second
+
+// StatusChangesRemovedTTL is how long Cids which have disappeared from
+// the global status are remembered. Tokens issued before a forgotten
+// removal are no longer valid, and return the full status.
+var StatusChangesRemovedTTL = 10 * time.Minute
+
+// statusVersions numbers the changes in the global status of the pins.
+// Every time the status of a Cid changes, it is tagged with a new
+// sequence number, so that the changes after a given one can be
+// obtained. Tokens are formed by an epoch, which identifies this
+// statusVersions, and a sequence number.
+type statusVersions struct {
+	mux     sync.Mutex
+	epoch   string
+	seq     uint64
+	prints  map[string]string
+	last    map[string]api.GlobalPinInfo
+	changed map[string]uint64
+	removed map[string]removal
+	// tokens with a lower sequence number may have missed
+	// forgotten removals
+	minSeq uint64
+
+	// when the status was last fetched by refresh, the error it got
+	// and a channel which is closed once the fetch in progress, if
+	// any, finishes
+	fetched  time.Time
+	fetchErr error
+	fetching chan struct{}
+}
+
+// removal records when a Cid disappeared from the global status, and
+// the namespace it was pinned under.
+type removal struct {
+	seq       uint64
+	at        time.Time
+	namespace string
+}
+
+func newStatusVersions() *statusVersions {
+	return &statusVersions{
+		epoch:   strconv.FormatInt(time.Now().UnixNano(), 36),
+		prints:  make(map[string]string),
+		last:    make(map[string]api.GlobalPinInfo),
+		changed: make(map[string]uint64),
+		removed: make(map[string]removal),
+	}
+}
+
+// fingerprint summarizes the parts of a GlobalPinInfo whose
+// changes are reported: the status and error for every peer.
+func fingerprint(gpi api.GlobalPinInfo) string {
+	var parts []string
+	for p, pinfo := range gpi.PeerMap {
+		parts = append(parts, fmt.Sprintf("%s:%s:%s",
+			p.Pretty(), pinfo.Status, pinfo.Error))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+// refresh updates the global status with fetch, unless it was fetched
+// less than StatusChangesInterval ago. Concurrent callers wait for the
+// same fetch, so that waiting clients do not each ask all the peers
+// for their status.
+func (sv *statusVersions) refresh(fetch func() ([]api.GlobalPinInfo, error)) error {
+	sv.mux.Lock()
+	if time.Since(sv.fetched) < StatusChangesInterval {
+		err := sv.fetchErr
+		sv.mux.Unlock()
+		return err
+	}
+	if ch := sv.fetching; ch != nil {
+		sv.mux.Unlock()
+		<-ch
+		sv.mux.Lock()
+		defer sv.mux.Unlock()
+		return sv.fetchErr
+	}
+	ch := make(chan struct{})
+	sv.fetching = ch
+	sv.mux.Unlock()
+
+	gpis, err := fetch()
+
+	sv.mux.Lock()
+	if err == nil {
+		sv.unsafeUpdate(gpis)
+	}
+	sv.fetched = time.Now()
+	sv.fetchErr = err
+	sv.fetching = nil
+	sv.mux.Unlock()
+	close(ch)
+	return err
+}
+
+// update records the given global status, tagging the items
+// which have changed or disappeared.
+func (sv *statusVersions) update(gpis []api.GlobalPinInfo) {
+	sv.mux.Lock()
+	defer sv.mux.Unlock()
+	sv.unsafeUpdate(gpis)
+}
+
+func (sv *statusVersions) unsafeUpdate(gpis []api.GlobalPinInfo) {
+	now := time.Now()
+
+	current := make(map[string]struct{}, len(gpis))
+	for _, gpi := range gpis {
+		k := gpi.Cid.String()
+		current[k] = struct{}{}
+		sv.last[k] = gpi
+		fp := fingerprint(gpi)
+		if prev, ok := sv.prints[k]; ok && prev == fp {
+			continue
+		}
+		sv.seq++
+		sv.prints[k] = fp
+		sv.changed[k] = sv.seq
+		delete(sv.removed, k)
+	}
+
+	for k := range sv.prints {
+		if _, ok := current[k]; ok {
+			continue
+		}
+		sv.seq++
+		sv.removed[k] = removal{
+			seq:       sv.seq,
+			at:        now,
+			namespace: sv.last[k].Namespace,
+		}
+		delete(sv.prints, k)
+		delete(sv.last, k)
+		delete(sv.changed, k)
+	}
+
+	for k, r := range sv.removed {
+		if now.Sub(r.at) > StatusChangesRemovedTTL {
+			delete(sv.removed, k)
+			if r.seq > sv.minSeq {
+				sv.minSeq = r.seq
+			}
+		}
+	}
+}
+
+// since returns the changes which happened after the given token was
+// issued. When the token is empty or was not issued by us, the full
+// status is returned and full is true.
+func (sv *statusVersions) since(token string) (api.StatusChanges, bool) {
+	return sv.sinceIn(api.StatusChangesRequest{Token: token})
+}
+
+// sinceIn works like since, but only returns the changes in the
+// requested namespace when the request is scoped.
+func (sv *statusVersions) sinceIn(req api.StatusChangesRequest) (changes api.StatusChanges, full bool) {
+	sv.mux.Lock()
+	defer sv.mux.Unlock()
+
+	changes.Token = fmt.Sprintf("%s-%d", sv.epoch, sv.seq)
+
+	seq, ok := sv.parseToken(req.Token)
+	full = !ok
+
+	inScope := func(ns string) bool {
+		return !req.Scoped || ns == req.Namespace
+	}
+
+	var keys []string
+	for k, s := range sv.changed {
+		if s > seq && inScope(sv.last[k].Namespace) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		changes.Changed = append(changes.Changed, sv.last[k])
+	}
+
+	// No removals when sending the full status
+	if full {
+		return
+	}
+
+	keys = nil
+	for k, r := range sv.removed {
+		if r.seq > seq && inScope(r.namespace) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		c, _ := cid.Decode(k)
+		changes.Removed = append(changes.Removed, c)
+	}
+	return
+}
+
+// parseToken returns the sequence number in a token and
+// whether it is a valid token issued by us. Tokens issued before
+// the removals which have been forgotten are not valid.
+func (sv *statusVersions) parseToken(token string) (uint64, bool) {
+	parts := strings.SplitN(token, "-", 2)
+	if len(parts) != 2 || parts[0] != sv.epoch {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil || seq > sv.seq || seq < sv.minSeq {
+		return 0, false
+	}
+	return seq, true
+}
+
+// StatusChanges returns the changes in the global status of the pins
+// since the token in the request was obtained, along with a new token.
+// Removed Cids are listed separately. Scoped requests only get the
+// changes in their namespace. When there are no changes, it waits for
+// them up to StatusChangesTimeout, so it can be used for long-polling.
+// An empty or unknown token returns the full status.
+func (c *Cluster) StatusChanges(req api.StatusChangesRequest) (api.StatusChanges, error) {
+	timeout := time.NewTimer(StatusChangesTimeout)
+	defer timeout.Stop()
+	ticker := time.NewTicker(StatusChangesInterval)
+	defer ticker.Stop()
+
+	// The status is fetched once for all the waiting clients, so it
+	// is not tied to any of them.
+	fetch := func() ([]api.GlobalPinInfo, error) {
+		return c.StatusAll(c.ctx)
+	}
+	for {
+		err := c.statusVersions.refresh(fetch)
+		if err != nil {
+			return api.StatusChanges{}, err
+		}
+		changes, full := c.statusVersions.sinceIn(req)
+		if full || len(changes.Changed) > 0 || len(changes.Removed) > 0 {
+			return changes, nil
+		}
+
+		select {
+		case <-timeout.C:
+			return changes, nil
+		case <-c.ctx.Done():
+			return changes, nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package ipfscluster
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/test"
+
+	cid "github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+func testGlobalPinInfo(c *cid.Cid, st api.TrackerStatus) api.GlobalPinInfo {
+	return api.GlobalPinInfo{
+		Cid: c,
+		PeerMap: map[peer.ID]api.PinInfo{
+			test.TestPeerID1: {
+				Cid:    c,
+				Peer:   test.TestPeerID1,
+				Status: st,
+			},
+		},
+	}
+}
+
+func TestStatusVersions(t *testing.T) {
+	sv := newStatusVersions()
+	c1, _ := cid.Decode(test.TestCid1)
+	c2, _ := cid.Decode(test.TestCid2)
+
+	sv.update([]api.GlobalPinInfo{
+		testGlobalPinInfo(c1, api.TrackerStatusPinning),
+		testGlobalPinInfo(c2, api.TrackerStatusPinning),
+	})
+
+	changes, full := sv.since("")
+	if !full || len(changes.Changed) != 2 {
+		t.Fatal("expected the full status without token")
+	}
+	token := changes.Token
+
+	// Same status, no changes
+	sv.update([]api.GlobalPinInfo{
+		testGlobalPinInfo(c1, api.TrackerStatusPinning),
+		testGlobalPinInfo(c2, api.TrackerStatusPinning),
+	})
+	changes, full = sv.since(token)
+	if full || len(changes.Changed) != 0 || len(changes.Removed) != 0 {
+		t.Fatal("expected no changes")
+	}
+	if changes.Token != token {
+		t.Error("token should not change without changes")
+	}
+
+	// c1 pinned, c2 removed
+	sv.update([]api.GlobalPinInfo{
+		testGlobalPinInfo(c1, api.TrackerStatusPinned),
+	})
+	changes, _ = sv.since(token)
+	if len(changes.Changed) != 1 || !changes.Changed[0].Cid.Equals(c1) {
+		t.Error("expected c1 to have changed")
+	}
+	if len(changes.Removed) != 1 || !changes.Removed[0].Equals(c2) {
+		t.Error("expected c2 to have been removed")
+	}
+
+	changes, _ = sv.since(changes.Token)
+	if len(changes.Changed) != 0 || len(changes.Removed) != 0 {
+		t.Error("expected no changes with the latest token")
+	}
+
+	// Unknown tokens get the full status
+	for _, tk := range []string{"abc", "abc-1", sv.epoch + "-999"} {
+		changes, full = sv.since(tk)
+		if !full || len(changes.Changed) != 1 || len(changes.Removed) != 0 {
+			t.Errorf("expected the full status with token %s", tk)
+		}
+	}
+
+	// Old removals are forgotten, along with the tokens before them
+	r := sv.removed[c2.String()]
+	r.at = r.at.Add(-StatusChangesRemovedTTL - time.Second)
+	sv.removed[c2.String()] = r
+	sv.update([]api.GlobalPinInfo{
+		testGlobalPinInfo(c1, api.TrackerStatusPinned),
+	})
+	if len(sv.removed) != 0 {
+		t.Error("the removal should have been forgotten")
+	}
+	changes, full = sv.since(token)
+	if !full || len(changes.Changed) != 1 {
+		t.Error("tokens before forgotten removals should get the full status")
+	}
+}
+
+func TestStatusVersionsNamespace(t *testing.T) {
+	sv := newStatusVersions()
+	c1, _ := cid.Decode(test.TestCid1)
+	c2, _ := cid.Decode(test.TestCid2)
+
+	gpi1 := testGlobalPinInfo(c1, api.TrackerStatusPinning)
+	gpi1.Namespace = "a"
+	gpi2 := testGlobalPinInfo(c2, api.TrackerStatusPinning)
+	gpi2.Namespace = "b"
+	sv.update([]api.GlobalPinInfo{gpi1, gpi2})
+
+	changes, _ := sv.sinceIn(api.StatusChangesRequest{Namespace: "a", Scoped: true})
+	if len(changes.Changed) != 1 || !changes.Changed[0].Cid.Equals(c1) {
+		t.Error("expected only the status in the namespace")
+	}
+	token := changes.Token
+
+	// Both removed
+	sv.update(nil)
+	changes, _ = sv.sinceIn(api.StatusChangesRequest{Token: token, Namespace: "a", Scoped: true})
+	if len(changes.Removed) != 1 || !changes.Removed[0].Equals(c1) {
+		t.Error("expected only the removals in the namespace:", changes.Removed)
+	}
+	changes, _ = sv.since(token)
+	if len(changes.Removed) != 2 {
+		t.Error("expected all the removals without namespace")
+	}
+}
+
+func TestStatusVersionsRefresh(t *testing.T) {
+	sv := newStatusVersions()
+	c1, _ := cid.Decode(test.TestCid1)
+
+	var mux sync.Mutex
+	fetches := 0
+	fetch := func() ([]api.GlobalPinInfo, error) {
+		mux.Lock()
+		fetches++
+		mux.Unlock()
+		time.Sleep(100 * time.Millisecond)
+		return []api.GlobalPinInfo{testGlobalPinInfo(c1, api.TrackerStatusPinned)}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := sv.refresh(fetch); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	sv.refresh(fetch)
+
+	if fetches != 1 {
+		t.Error("waiting callers should share a single fetch:", fetches)
+	}
+	if changes, _ := sv.since(""); len(changes.Changed) != 1 {
+		t.Error("the fetched status should have been recorded")
+	}
+}
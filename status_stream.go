@@ -0,0 +1,157 @@
+package ipfscluster
+
+import (
+	"context"
+	"time"
+
+	"github.com/ipfs/ipfs-cluster/api"
+
+	cid "github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// peerStatusItem is an item of the status of a peer, sent by
+// streamPeerStatus, or the error which stopped it.
+type peerStatusItem struct {
+	pinfo api.PinInfoSerial
+	err   error
+}
+
+// StreamStatusAll calls f with the GlobalPinInfo of every tracked Cid,
+// sorted by Cid. Unlike StatusAll, the whole status is never held in
+// memory: every peer is asked once and sends its items StreamPageSize
+// at a time, which are merged as they arrive. It stops when f returns
+// an error or ctx is cancelled, and returns that error.
+func (c *Cluster) StreamStatusAll(ctx context.Context, f func(api.GlobalPinInfo) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	members := c.peerManager.peers()
+	failed := make(map[peer.ID]string)
+	streams := make([]<-chan peerStatusItem, len(members), len(members))
+	for i, p := range members {
+		streams[i] = c.streamPeerStatus(ctx, p)
+	}
+
+	st, stErr := c.consensus.State()
+	err := mergePeerStatus(members, streams, c.expiredPeers(), failed, func(gpi api.GlobalPinInfo) error {
+		if stErr == nil && st.Has(gpi.Cid) {
+			c.setPinDetails(&gpi, st.Get(gpi.Cid))
+		}
+		return f(gpi)
+	})
+	if err == nil {
+		// the streams are closed early when cancelled
+		err = ctx.Err()
+	}
+	return err
+}
+
+// streamPeerStatus sends the status of the items tracked by a peer,
+// sorted by Cid, fetching StreamPageSize of them at a time. The
+// channel is closed when done, after an error or when ctx is
+// cancelled.
+func (c *Cluster) streamPeerStatus(ctx context.Context, p peer.ID) <-chan peerStatusItem {
+	ch := make(chan peerStatusItem, StreamPageSize)
+	send := func(item peerStatusItem) bool {
+		select {
+		case ch <- item:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	go func() {
+		defer close(ch)
+		after := ""
+		for {
+			var pinfos []api.PinInfoSerial
+			err := c.rpcClient.CallContext(ctx, p,
+				"Cluster",
+				"TrackerStatusPage",
+				api.PageRequest{After: after, Limit: StreamPageSize},
+				&pinfos)
+			if err != nil {
+				send(peerStatusItem{err: err})
+				return
+			}
+			for _, pinfo := range pinfos {
+				if !send(peerStatusItem{pinfo: pinfo}) {
+					return
+				}
+			}
+			if len(pinfos) < StreamPageSize {
+				return
+			}
+			after = pinfos[len(pinfos)-1].Cid
+		}
+	}()
+	return ch
+}
+
+// mergePeerStatus merges the status streams of the given peers, which
+// are sorted by Cid, calling f with the GlobalPinInfo of every Cid in
+// order. The peers in failed, and those whose stream fails, are
+// reported with a ClusterError for every item which follows. Errors of
+// expired peers are labelled as such.
+func mergePeerStatus(peers []peer.ID, streams []<-chan peerStatusItem, expired map[peer.ID]bool, failed map[peer.ID]string, f func(api.GlobalPinInfo) error) error {
+	heads := make([]*api.PinInfoSerial, len(streams), len(streams))
+	advance := func(i int) {
+		heads[i] = nil
+		item, ok := <-streams[i]
+		if !ok {
+			return
+		}
+		if item.err != nil {
+			logger.Errorf("error streaming the status of %s: %s", peers[i].Pretty(), item.err)
+			failed[peers[i]] = peerStatusError(expired, peers[i], item.err)
+			return
+		}
+		heads[i] = &item.pinfo
+	}
+	for i := range streams {
+		advance(i)
+	}
+
+	for {
+		next := ""
+		for _, h := range heads {
+			if h != nil && (next == "" || h.Cid < next) {
+				next = h.Cid
+			}
+		}
+		if next == "" {
+			return nil
+		}
+
+		h, _ := cid.Decode(next)
+		gpi := api.GlobalPinInfo{
+			Cid:     h,
+			PeerMap: make(map[peer.ID]api.PinInfo),
+		}
+		for i, head := range heads {
+			if head != nil && head.Cid == next {
+				pinfo := head.ToPinInfo()
+				gpi.PeerMap[pinfo.Peer] = pinfo
+				advance(i)
+			}
+		}
+		for p, msg := range failed {
+			if _, ok := gpi.PeerMap[p]; ok {
+				// sent before failing
+				continue
+			}
+			gpi.PeerMap[p] = api.PinInfo{
+				Cid:    h,
+				Peer:   p,
+				Status: api.TrackerStatusClusterError,
+				TS:     time.Now(),
+				Error:  msg,
+			}
+		}
+		if err := f(gpi); err != nil {
+			return err
+		}
+	}
+}
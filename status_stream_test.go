@@ -0,0 +1,79 @@
+package ipfscluster
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/test"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+func peerStatusStream(p peer.ID, err error, cids ...string) <-chan peerStatusItem {
+	ch := make(chan peerStatusItem, len(cids)+1)
+	for _, c := range cids {
+		ch <- peerStatusItem{pinfo: api.PinInfoSerial{
+			Cid:    c,
+			Peer:   peer.IDB58Encode(p),
+			Status: api.TrackerStatus(api.TrackerStatusPinned).String(),
+		}}
+	}
+	if err != nil {
+		ch <- peerStatusItem{err: err}
+	}
+	close(ch)
+	return ch
+}
+
+func TestMergePeerStatus(t *testing.T) {
+	peers := []peer.ID{test.TestPeerID1, test.TestPeerID2}
+	streams := []<-chan peerStatusItem{
+		peerStatusStream(peers[0], nil, test.TestCid2, test.TestCid3, test.TestCid1),
+		peerStatusStream(peers[1], errors.New("gone"), test.TestCid3),
+	}
+	failed := map[peer.ID]string{test.TestPeerID3: "failed"}
+	expired := map[peer.ID]bool{test.TestPeerID2: true}
+
+	var gpis []api.GlobalPinInfo
+	err := mergePeerStatus(peers, streams, expired, failed, func(gpi api.GlobalPinInfo) error {
+		gpis = append(gpis, gpi)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gpis) != 3 {
+		t.Fatal("expected 3 items but got", len(gpis))
+	}
+	for i, c := range []string{test.TestCid2, test.TestCid3, test.TestCid1} {
+		if gpis[i].Cid.String() != c {
+			t.Errorf("%d: expected %s but got %s", i, c, gpis[i].Cid)
+		}
+		if gpis[i].PeerMap[test.TestPeerID3].Status != api.TrackerStatusClusterError {
+			t.Errorf("%d: the failed peer should be reported", i)
+		}
+	}
+	if gpis[1].PeerMap[test.TestPeerID2].Status != api.TrackerStatusPinned {
+		t.Error("both peers should be merged")
+	}
+	pinfo := gpis[2].PeerMap[test.TestPeerID2]
+	if pinfo.Status != api.TrackerStatusClusterError ||
+		pinfo.Error != errPeerOffline.Error()+": gone" {
+		t.Error("the peer should be reported as offline after its stream failed:", pinfo)
+	}
+
+	// Stopping early
+	streams = []<-chan peerStatusItem{
+		peerStatusStream(peers[0], nil, test.TestCid2, test.TestCid1),
+	}
+	stop := errors.New("stop")
+	n := 0
+	err = mergePeerStatus(peers, streams, nil, map[peer.ID]string{}, func(gpi api.GlobalPinInfo) error {
+		n++
+		return stop
+	})
+	if err != stop || n != 1 {
+		t.Error("merging should stop when f fails")
+	}
+}
@@ -0,0 +1,57 @@
+package test
+
+import (
+	"sort"
+
+	"github.com/ipfs/ipfs-cluster/api"
+
+	rpc "github.com/hsanjuan/go-libp2p-gorpc"
+	cid "github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// MockAllocator is a deterministic PinAllocator which can be used to
+// test allocation outcomes. It ignores metric values: candidates listed
+// in Order are returned first, in that order, followed by any other
+// candidates sorted by peer ID.
+type MockAllocator struct {
+	Order []peer.ID
+}
+
+// NewMockAllocator returns a MockAllocator which prefers the given
+// peers in the given order.
+func NewMockAllocator(order ...peer.ID) *MockAllocator {
+	return &MockAllocator{
+		Order: order,
+	}
+}
+
+// SetClient does nothing in this allocator.
+func (alloc *MockAllocator) SetClient(c *rpc.Client) {}
+
+// Shutdown does nothing in this allocator.
+func (alloc *MockAllocator) Shutdown() error { return nil }
+
+// Allocate returns the candidates in a fixed order.
+func (alloc *MockAllocator) Allocate(c *cid.Cid, current, candidates map[peer.ID]api.Metric) ([]peer.ID, error) {
+	peers := make([]peer.ID, 0, len(candidates))
+	seen := make(map[peer.ID]bool)
+	for _, p := range alloc.Order {
+		if _, ok := candidates[p]; ok && !seen[p] {
+			peers = append(peers, p)
+			seen[p] = true
+		}
+	}
+
+	var rest []string
+	for p := range candidates {
+		if !seen[p] {
+			rest = append(rest, string(p))
+		}
+	}
+	sort.Strings(rest)
+	for _, p := range rest {
+		peers = append(peers, peer.ID(p))
+	}
+	return peers, nil
+}
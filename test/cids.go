@@ -1,6 +1,10 @@
 package test
 
-import peer "github.com/libp2p/go-libp2p-peer"
+import (
+	"time"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+)
 
 // Common variables used all arround tests.
 var (
@@ -9,8 +13,42 @@ var (
 	TestCid3 = "QmP63DkAFEnDYNjDYBpyNDfttu1fvUw99x1brscPzpqmmb"
 	// ErrorCid is meant to be used as a Cid which causes errors. i.e. the
 	// ipfs mock fails when pinning this CID.
-	ErrorCid       = "QmP63DkAFEnDYNjDYBpyNDfttu1fvUw99x1brscPzpqmmc"
+	ErrorCid = "QmP63DkAFEnDYNjDYBpyNDfttu1fvUw99x1brscPzpqmmc"
+	// SlowCid makes the mocked IPFS pin and unpin operations take
+	// SlowCidDelay.
+	SlowCid      = "QmP63DkAFEnDYNjDYBpyNDfttu1fvUw99x1brscPzpqmmd"
+	SlowCidDelay = time.Second
+	// PresentCid has its blocks in the ipfs mock without being pinned.
+	PresentCid = "QmP63DkAFEnDYNjDYBpyNDfttu1fvUw99x1brscPzpqmmf"
+	// QueueFullCid is rejected by the mocked Pin operation as if the
+	// pin queue was full.
+	QueueFullCid   = "QmP63DkAFEnDYNjDYBpyNDfttu1fvUw99x1brscPzpqmme"
 	TestPeerID1, _ = peer.IDB58Decode("QmXZrtE5jQwXNqCJMfHUTQkvhQ4ZAnqMnmzFMJfLewuabc")
 	TestPeerID2, _ = peer.IDB58Decode("QmUZ13osndQ5uL4tPWHXe3iBgBgq9gfewcBMSCAuMBsDJ6")
 	TestPeerID3, _ = peer.IDB58Decode("QmPGDFvBkgWhvzEK9qaTWrWurSwqXNmhnK3hgELPdZZNPa")
+	// TestNamespace is the namespace of TestCid3 in the mocked state.
+	TestNamespace = "testns"
+	// TestPinName is the name of TestCid2 in the mocked state.
+	TestPinName = "testname"
+	// TestPinOwner is the "owner" metadata of TestCid2 in the mocked
+	// state.
+	TestPinOwner = "testowner"
+	// TestLogIndex is the log index returned by the mocked Pin operation.
+	TestLogIndex uint64 = 5
+	// TestIPNSName is resolved by the ipfs mock.
+	TestIPNSName = "example.com"
+	// TestRepoSize and TestStorageMax are reported by the mocked
+	// repo/stat endpoint.
+	TestRepoSize   uint64 = 1000
+	TestStorageMax uint64 = 5000
+	// TestBandwidthTotalIn, TestBandwidthTotalOut, TestBandwidthRateIn
+	// and TestBandwidthRateOut are reported by the mocked stats/bw
+	// endpoint.
+	TestBandwidthTotalIn  uint64  = 3000
+	TestBandwidthTotalOut uint64  = 4000
+	TestBandwidthRateIn   float64 = 10.5
+	TestBandwidthRateOut  float64 = 20.5
+	// TestPinSize is the cumulative size of every item, as reported
+	// by the mocked object/stat endpoint.
+	TestPinSize uint64 = 2000
 )
@@ -3,11 +3,13 @@ package test
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/ipfs/ipfs-cluster/api"
 	"github.com/ipfs/ipfs-cluster/state/mapstate"
@@ -24,7 +26,8 @@ type IpfsMock struct {
 }
 
 type mockPinResp struct {
-	Pins []string
+	Pins     []string
+	Progress uint64 `json:",omitempty"`
 }
 
 type mockPinType struct {
@@ -35,6 +38,11 @@ type mockPinLsResp struct {
 	Keys map[string]mockPinType
 }
 
+type mockRefsResp struct {
+	Ref string
+	Err string
+}
+
 type ipfsErr struct {
 	Code    int
 	Message string
@@ -45,6 +53,36 @@ type idResp struct {
 	Addresses []string
 }
 
+type addResp struct {
+	Name string
+	Hash string
+}
+
+type resolveResp struct {
+	Path string
+}
+
+type repoStatResp struct {
+	RepoSize   uint64
+	StorageMax uint64
+}
+
+type blockStatResp struct {
+	Key  string
+	Size int
+}
+
+type objectStatResp struct {
+	CumulativeSize uint64
+}
+
+type bandwidthResp struct {
+	TotalIn  uint64
+	TotalOut uint64
+	RateIn   float64
+	RateOut  float64
+}
+
 // NewIpfsMock returns a new mock.
 func NewIpfsMock() *IpfsMock {
 	st := mapstate.NewMapState()
@@ -65,6 +103,16 @@ func NewIpfsMock() *IpfsMock {
 }
 
 // FIXME: what if IPFS API changes?
+// hasBlocks returns true if the blocks of the given Cid are in the
+// mock, i.e. when it is pinned or it is PresentCid.
+func (m *IpfsMock) hasBlocks(cidStr string) bool {
+	if cidStr == PresentCid {
+		return true
+	}
+	c, err := cid.Decode(cidStr)
+	return err == nil && m.pinMap.Has(c)
+}
+
 func (m *IpfsMock) handler(w http.ResponseWriter, r *http.Request) {
 	p := r.URL.Path
 	endp := strings.TrimPrefix(p, "/api/v0/")
@@ -93,7 +141,27 @@ func (m *IpfsMock) handler(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			goto ERROR
 		}
-		m.pinMap.Add(api.CidArgCid(c))
+		// Offline pins cannot fetch missing blocks
+		if query.Get("offline") == "true" && !m.hasBlocks(cidStr) {
+			goto ERROR
+		}
+		carg := api.CidArgCid(c)
+		if query.Get("recursive") == "false" {
+			carg.Type = api.PinTypeDirect
+		}
+		m.pinMap.Add(carg)
+		if query.Get("progress") == "true" {
+			j, _ := json.Marshal(mockPinResp{Progress: 1})
+			w.Write(j)
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		}
+		// Slow pins are slow once the response has started, like
+		// when fetching the content.
+		if cidStr == SlowCid {
+			time.Sleep(SlowCidDelay)
+		}
 		resp := mockPinResp{
 			Pins: []string{cidStr},
 		}
@@ -122,8 +190,12 @@ func (m *IpfsMock) handler(w http.ResponseWriter, r *http.Request) {
 		if !ok {
 			rMap := make(map[string]mockPinType)
 			pins := m.pinMap.List()
+			t := query.Get("type")
 			for _, p := range pins {
-				rMap[p.Cid.String()] = mockPinType{"recursive"}
+				if t != "" && t != "all" && t != p.Type.String() {
+					continue
+				}
+				rMap[p.Cid.String()] = mockPinType{p.Type.String()}
 			}
 			j, _ := json.Marshal(mockPinLsResp{rMap})
 			w.Write(j)
@@ -141,7 +213,7 @@ func (m *IpfsMock) handler(w http.ResponseWriter, r *http.Request) {
 		ok = m.pinMap.Has(c)
 		if ok {
 			rMap := make(map[string]mockPinType)
-			rMap[cidStr] = mockPinType{"recursive"}
+			rMap[cidStr] = mockPinType{m.pinMap.Get(c).Type.String()}
 			j, _ := json.Marshal(mockPinLsResp{rMap})
 			w.Write(j)
 		} else {
@@ -150,6 +222,81 @@ func (m *IpfsMock) handler(w http.ResponseWriter, r *http.Request) {
 			j, _ := json.Marshal(resp)
 			w.Write(j)
 		}
+	case "refs":
+		query := r.URL.Query()
+		arg, ok := query["arg"]
+		if !ok || len(arg) != 1 {
+			goto ERROR
+		}
+		cidStr = arg[0]
+		if cidStr == ErrorCid {
+			j, _ := json.Marshal(mockRefsResp{Err: "merkledag: not found"})
+			w.Write(j)
+			break
+		}
+		// Online, missing blocks would be fetched from the
+		// network.
+		if !m.hasBlocks(cidStr) && query.Get("offline") == "true" {
+			goto ERROR
+		}
+		j, _ := json.Marshal(mockRefsResp{Ref: cidStr})
+		w.Write(j)
+	case "block/stat":
+		cidStr = r.URL.Query().Get("arg")
+		if !m.hasBlocks(cidStr) {
+			goto ERROR
+		}
+		j, _ := json.Marshal(blockStatResp{Key: cidStr, Size: 10})
+		w.Write(j)
+	case "add":
+		// Any non-empty file is added as TestCid1
+		f, _, err := r.FormFile("file")
+		if err != nil {
+			goto ERROR
+		}
+		data, _ := ioutil.ReadAll(f)
+		f.Close()
+		if len(data) == 0 {
+			goto ERROR
+		}
+		j, _ := json.Marshal(addResp{Name: "file", Hash: TestCid1})
+		w.Write(j)
+	case "name/resolve":
+		// TestIPNSName resolves to /ipfs/TestCid1/dir
+		if r.URL.Query().Get("arg") != "/ipns/"+TestIPNSName {
+			goto ERROR
+		}
+		j, _ := json.Marshal(resolveResp{"/ipfs/" + TestCid1 + "/dir"})
+		w.Write(j)
+	case "resolve":
+		// Paths under TestCid1 resolve to TestCid2
+		arg := r.URL.Query().Get("arg")
+		switch {
+		case arg == "/ipfs/"+TestCid1:
+		case strings.HasPrefix(arg, "/ipfs/"+TestCid1+"/"):
+			arg = "/ipfs/" + TestCid2
+		default:
+			goto ERROR
+		}
+		j, _ := json.Marshal(resolveResp{arg})
+		w.Write(j)
+	case "object/stat":
+		j, _ := json.Marshal(objectStatResp{TestPinSize})
+		w.Write(j)
+	case "repo/stat":
+		j, _ := json.Marshal(repoStatResp{
+			RepoSize:   TestRepoSize,
+			StorageMax: TestStorageMax,
+		})
+		w.Write(j)
+	case "stats/bw":
+		j, _ := json.Marshal(bandwidthResp{
+			TotalIn:  TestBandwidthTotalIn,
+			TotalOut: TestBandwidthTotalOut,
+			RateIn:   TestBandwidthRateIn,
+			RateOut:  TestBandwidthRateOut,
+		})
+		w.Write(j)
 	case "version":
 		w.Write([]byte("{\"Version\":\"m.o.c.k\"}"))
 	default:
@@ -0,0 +1,124 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PinningServiceToken is the access token expected by PinningServiceMock.
+const PinningServiceToken = "testtoken"
+
+// PinningServiceMock is a minimal implementation of the IPFS Pinning
+// Service API. Pin requests are "pinned" right away, except for
+// ErrorCid, which "failed".
+type PinningServiceMock struct {
+	server *httptest.Server
+	URL    string
+
+	mux    sync.Mutex
+	nextID int
+	pins   map[string]mockPinStatus
+}
+
+type mockPin struct {
+	Cid string `json:"cid"`
+}
+
+type mockPinStatus struct {
+	RequestID string    `json:"requestid"`
+	Status    string    `json:"status"`
+	Created   time.Time `json:"created"`
+	Pin       mockPin   `json:"pin"`
+}
+
+type mockPinResults struct {
+	Count   int             `json:"count"`
+	Results []mockPinStatus `json:"results"`
+}
+
+// NewPinningServiceMock returns a new mock.
+func NewPinningServiceMock() *PinningServiceMock {
+	m := &PinningServiceMock{
+		pins: make(map[string]mockPinStatus),
+	}
+	m.server = httptest.NewServer(http.HandlerFunc(m.handler))
+	m.URL = m.server.URL
+	return m
+}
+
+func (m *PinningServiceMock) handler(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+PinningServiceToken {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":{"reason":"UNAUTHORIZED","details":"bad token"}}`))
+		return
+	}
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	reqID := strings.TrimPrefix(r.URL.Path, "/pins/")
+	switch {
+	case r.URL.Path == "/pins" && r.Method == "GET":
+		q := r.URL.Query()
+		statuses := strings.Split(q.Get("status"), ",")
+		res := mockPinResults{Results: []mockPinStatus{}}
+		for _, p := range m.pins {
+			if c := q.Get("cid"); c != "" && c != p.Pin.Cid {
+				continue
+			}
+			for _, st := range statuses {
+				if st == p.Status {
+					res.Results = append(res.Results, p)
+				}
+			}
+		}
+		res.Count = len(res.Results)
+		j, _ := json.Marshal(res)
+		w.Write(j)
+	case r.URL.Path == "/pins" && r.Method == "POST":
+		var pin mockPin
+		json.NewDecoder(r.Body).Decode(&pin)
+		m.nextID++
+		st := mockPinStatus{
+			RequestID: fmt.Sprintf("%d", m.nextID),
+			Status:    "pinned",
+			Created:   time.Now(),
+			Pin:       pin,
+		}
+		if pin.Cid == ErrorCid {
+			st.Status = "failed"
+		}
+		m.pins[st.RequestID] = st
+		j, _ := json.Marshal(st)
+		w.WriteHeader(http.StatusAccepted)
+		w.Write(j)
+	case r.Method == "GET":
+		st, ok := m.pins[reqID]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		j, _ := json.Marshal(st)
+		w.Write(j)
+	case r.Method == "DELETE":
+		if _, ok := m.pins[reqID]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(m.pins, reqID)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// Close closes the mock server. It's important to call after each test or
+// the listeners are left hanging around.
+func (m *PinningServiceMock) Close() {
+	m.server.Close()
+}
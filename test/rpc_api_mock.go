@@ -1,7 +1,10 @@
 package test
 
 import (
+	"encoding/json"
 	"errors"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
@@ -16,6 +19,10 @@ import (
 // fail.
 var ErrBadCid = errors.New("this is an expected error when using ErrorCid")
 
+// ErrProtected is returned when unpinning TestCid2, which the mock
+// considers protected. It matches the error returned by the Cluster.
+var ErrProtected = api.NewError(403, "pin is protected: unprotect it before unpinning")
+
 type mockService struct{}
 
 // NewMockRPCClient creates a mock ipfs-cluster RPC server and returns
@@ -30,35 +37,254 @@ func NewMockRPCClient(t *testing.T) *rpc.Client {
 	return c
 }
 
-func (mock *mockService) Pin(in api.CidArgSerial, out *struct{}) error {
-	if in.Cid == ErrorCid {
+func (mock *mockService) Pin(in api.CidArgSerial, out *uint64) error {
+	switch in.Cid {
+	case ErrorCid:
+		return ErrBadCid
+	case QueueFullCid:
+		return api.NewError(503, "pin queue is full")
+	}
+	for _, p := range in.Allocations {
+		switch p {
+		case peer.IDB58Encode(TestPeerID1), peer.IDB58Encode(TestPeerID2), peer.IDB58Encode(TestPeerID3):
+		default:
+			return api.NewError(400, "%s is not a cluster peer", p)
+		}
+	}
+	*out = TestLogIndex
+	return nil
+}
+
+func (mock *mockService) PinPath(in api.PinPathSerial, out *api.PinResultSerial) error {
+	if in.Path == "/ipns/"+ErrorCid {
 		return ErrBadCid
 	}
+	c := in.CidArg
+	c.Cid = TestCid1
+	out.Cid = c.Cid
+	return mock.Pin(c, &out.Index)
+}
+
+func (mock *mockService) PinMany(in []api.CidArgSerial, out *[]api.PinResultSerial) error {
+	*out = make([]api.PinResultSerial, len(in), len(in))
+	for i, c := range in {
+		(*out)[i].Cid = c.Cid
+		var index uint64
+		if err := mock.Pin(c, &index); err != nil {
+			(*out)[i].Error = err.Error()
+			continue
+		}
+		(*out)[i].Index = index
+	}
+	return nil
+}
+
+func (mock *mockService) WaitForIndex(in uint64, out *struct{}) error {
+	if in > TestLogIndex {
+		return errors.New("timed out waiting for index")
+	}
 	return nil
 }
 
 func (mock *mockService) Unpin(in api.CidArgSerial, out *struct{}) error {
+	switch in.Cid {
+	case ErrorCid:
+		return ErrBadCid
+	case TestCid2:
+		return ErrProtected
+	}
+	return nil
+}
+
+func (mock *mockService) PinAcks(in api.CidArgSerial, out *[]api.PinAckSerial) error {
+	if in.Cid == ErrorCid {
+		return ErrBadCid
+	}
+	*out = []api.PinAckSerial{
+		{
+			Peer:     TestPeerID1.Pretty(),
+			Accepted: true,
+		},
+		{
+			Peer:  TestPeerID2.Pretty(),
+			Error: "pin queue is full",
+		},
+	}
+	return nil
+}
+
+func (mock *mockService) Verify(in api.CidArgSerial, out *[]api.VerifyResultSerial) error {
+	if in.Cid == ErrorCid {
+		return ErrBadCid
+	}
+	*out = []api.VerifyResultSerial{
+		{
+			Peer:  TestPeerID1.Pretty(),
+			Valid: true,
+		},
+		{
+			Peer:  TestPeerID2.Pretty(),
+			Error: "merkledag: not found",
+		},
+	}
+	return nil
+}
+
+func (mock *mockService) Allocations(in api.CidArgSerial, out *api.CidArgSerial) error {
+	if in.Cid == ErrorCid {
+		return api.NewError(404, "%s is not pinned", in.Cid)
+	}
+	*out = api.CidArgSerial{
+		Cid:         in.Cid,
+		Allocations: []string{TestPeerID1.Pretty(), TestPeerID2.Pretty()},
+	}
+	return nil
+}
+
+func (mock *mockService) AllocationPreview(in api.CidArgSerial, out *api.AllocationPreviewSerial) error {
 	if in.Cid == ErrorCid {
 		return ErrBadCid
 	}
+	rpl := in.ReplicationFactorMin
+	if rpl == 0 {
+		rpl = 2
+	}
+	candidates := []api.MetricSerial{
+		{Name: "freespace", Peer: TestPeerID1.Pretty(), Value: "2000", Valid: true},
+		{Name: "freespace", Peer: TestPeerID2.Pretty(), Value: "1000", Valid: true},
+		{Name: "freespace", Peer: TestPeerID3.Pretty(), Value: "500", Valid: true},
+	}
+	*out = api.AllocationPreviewSerial{
+		Cid:                  in.Cid,
+		ReplicationFactorMin: rpl,
+		ReplicationFactorMax: rpl,
+		MetricName:           "freespace",
+		Current:              []api.MetricSerial{},
+		Candidates:           candidates,
+	}
+	if rpl > len(candidates) {
+		out.Error = "not enough candidates"
+		out.Allocations = []string{}
+		return nil
+	}
+	for _, m := range candidates[:rpl] {
+		out.Allocations = append(out.Allocations, m.Peer)
+	}
 	return nil
 }
 
+func (mock *mockService) ServingPeer(in api.CidArgSerial, out *api.IDSerial) error {
+	if in.Cid == ErrorCid {
+		return ErrBadCid
+	}
+	return mock.ID(struct{}{}, out)
+}
+
+func (mock *mockService) Protect(in api.CidArgSerial, out *struct{}) error {
+	if in.Cid == ErrorCid {
+		return ErrBadCid
+	}
+	return nil
+}
+
+func (mock *mockService) UnpinIfHealthy(in api.CidArgSerial, out *struct{}) error {
+	switch in.Cid {
+	case ErrorCid:
+		return ErrBadCid
+	case TestCid3:
+		return api.NewError(409, "%s is not pinned on all its allocations", in.Cid)
+	}
+	return mock.Unpin(in, out)
+}
+
+func (mock *mockService) ForceUnpin(in api.CidArgSerial, out *struct{}) error {
+	if in.Cid == ErrorCid {
+		return ErrBadCid
+	}
+	return nil
+}
+
+func (mock *mockService) LocalAllocations(in struct{}, out *[]api.CidArgSerial) error {
+	// TestPeerID1 is the local peer
+	return mock.PinListByPeer(TestPeerID1, out)
+}
+
 func (mock *mockService) PinList(in struct{}, out *[]api.CidArgSerial) error {
 	*out = []api.CidArgSerial{
 		{
-			Cid: TestCid1,
+			Cid:        TestCid1,
+			Everywhere: true,
 		},
 		{
-			Cid: TestCid2,
+			Cid:         TestCid2,
+			Allocations: []string{TestPeerID2.Pretty()},
+			Name:        TestPinName,
+			Metadata:    map[string]string{"owner": TestPinOwner},
 		},
 		{
-			Cid: TestCid3,
+			Cid:         TestCid3,
+			Allocations: []string{TestPeerID1.Pretty()},
+			Namespace:   TestNamespace,
 		},
 	}
 	return nil
 }
 
+func (mock *mockService) PinListByPeer(in peer.ID, out *[]api.CidArgSerial) error {
+	var pins []api.CidArgSerial
+	mock.PinList(struct{}{}, &pins)
+	*out = make([]api.CidArgSerial, 0, len(pins))
+	for _, p := range pins {
+		if p.ToCidArg().AllocatedTo(in) {
+			*out = append(*out, p)
+		}
+	}
+	return nil
+}
+
+// StateStale reports the mocked state as stale, so that the
+// corresponding REST API header can be tested.
+func (mock *mockService) StateStale(in struct{}, out *bool) error {
+	*out = true
+	return nil
+}
+
+func (mock *mockService) PinListByNamespace(in string, out *[]api.CidArgSerial) error {
+	var pins []api.CidArgSerial
+	mock.PinList(struct{}{}, &pins)
+	*out = make([]api.CidArgSerial, 0, len(pins))
+	for _, p := range pins {
+		if p.Namespace == in {
+			*out = append(*out, p)
+		}
+	}
+	return nil
+}
+
+func (mock *mockService) PinListByName(in string, out *[]api.CidArgSerial) error {
+	var pins []api.CidArgSerial
+	mock.PinList(struct{}{}, &pins)
+	*out = make([]api.CidArgSerial, 0, len(pins))
+	for _, p := range pins {
+		if in != "" && p.Name == in {
+			*out = append(*out, p)
+		}
+	}
+	return nil
+}
+
+func (mock *mockService) PinListByMetadata(in api.MetadataFilter, out *[]api.CidArgSerial) error {
+	var pins []api.CidArgSerial
+	mock.PinList(struct{}{}, &pins)
+	*out = make([]api.CidArgSerial, 0, len(pins))
+	for _, p := range pins {
+		if v, ok := p.Metadata[in.Key]; ok && v == in.Value {
+			*out = append(*out, p)
+		}
+	}
+	return nil
+}
+
 func (mock *mockService) ID(in struct{}, out *api.IDSerial) error {
 	//_, pubkey, _ := crypto.GenerateKeyPair(
 	//	DefaultConfigCrypto,
@@ -76,11 +302,120 @@ func (mock *mockService) ID(in struct{}, out *api.IDSerial) error {
 
 func (mock *mockService) Version(in struct{}, out *api.Version) error {
 	*out = api.Version{
+		Version:            "0.0.mock",
+		Commit:             "mock",
+		RPCProtocolVersion: "/ipfscluster/0.0.mock/rpc",
+		GoVersion:          "go0.mock",
+	}
+	return nil
+}
+
+func (mock *mockService) Health(in struct{}, out *api.Health) error {
+	*out = api.Health{
+		DiskFree:      2048 * 1024 * 1024,
+		DiskThreshold: 1024 * 1024 * 1024,
+	}
+	return nil
+}
+
+func (mock *mockService) QueueInfo(in struct{}, out *api.QueueInfo) error {
+	*out = api.QueueInfo{
+		PinQueueLength:     10,
+		PinQueueCapacity:   1024,
+		UnpinQueueLength:   0,
+		UnpinQueueCapacity: 1024,
+	}
+	return nil
+}
+
+func (mock *mockService) Summary(in struct{}, out *api.Summary) error {
+	*out = api.Summary{
+		Pins: 3,
+		Status: map[string]int{
+			"pinned":  2,
+			"pinning": 1,
+		},
+		Peers:   2,
+		Leader:  TestPeerID1.Pretty(),
 		Version: "0.0.mock",
 	}
 	return nil
 }
 
+func (mock *mockService) ConsistencyCheck(in struct{}, out *api.ConsistencyReportSerial) error {
+	view := api.ConsensusViewSerial{
+		Peer:          TestPeerID1.Pretty(),
+		ClusterPeers:  []string{TestPeerID1.Pretty()},
+		Leader:        TestPeerID1.Pretty(),
+		PinCount:      3,
+		StateChecksum: "abc",
+	}
+	*out = api.ConsistencyReportSerial{
+		Consistent: true,
+		Views:      []api.ConsensusViewSerial{view},
+	}
+	return nil
+}
+
+func (mock *mockService) ExportState(in struct{}, out *[]byte) error {
+	*out = []byte(`{"PinMap":{"` + TestCid1 + `":{"cid":"` + TestCid1 + `","everywhere":true}},"Version":1}`)
+	return nil
+}
+
+func (mock *mockService) ImportState(in []byte, out *struct{}) error {
+	var st interface{}
+	if err := json.Unmarshal(in, &st); err != nil {
+		return api.NewError(400, "error decoding state: %s", err)
+	}
+	return nil
+}
+
+func (mock *mockService) Snapshot(in struct{}, out *struct{}) error {
+	return nil
+}
+
+func (mock *mockService) SendAlert(in api.AlertSerial, out *struct{}) error {
+	return nil
+}
+
+func (mock *mockService) Leave(in struct{}, out *struct{}) error {
+	return nil
+}
+
+func (mock *mockService) RaftConfiguration(in struct{}, out *[]api.RaftServerSerial) error {
+	*out = []api.RaftServerSerial{
+		{
+			ID:       TestPeerID1.Pretty(),
+			Suffrage: api.RaftVoter,
+			Leader:   true,
+		},
+		{
+			ID:       TestPeerID2.Pretty(),
+			Suffrage: api.RaftVoter,
+		},
+	}
+	return nil
+}
+
+func (mock *mockService) ReconcileDryRun(in struct{}, out *api.ReconcilePlanSerial) error {
+	*out = api.ReconcilePlanSerial{
+		Actions: []api.ReconcileActionSerial{
+			{
+				Action: api.ReconcilePin,
+				Cid:    TestCid1,
+				Peer:   TestPeerID1.Pretty(),
+				Reason: "tracker status is pin_error",
+			},
+			{
+				Action: api.ReconcileReallocate,
+				Cid:    TestCid2,
+				Reason: "allocated to 1 peers which are not part of the cluster",
+			},
+		},
+	}
+	return nil
+}
+
 func (mock *mockService) Peers(in struct{}, out *[]api.IDSerial) error {
 	id := api.IDSerial{}
 	mock.ID(in, &id)
@@ -89,7 +424,25 @@ func (mock *mockService) Peers(in struct{}, out *[]api.IDSerial) error {
 	return nil
 }
 
+func (mock *mockService) PeerInfo(in peer.ID, out *api.IDSerial) error {
+	if in != TestPeerID1 {
+		return api.NewError(404, "%s is not a peer", in.Pretty())
+	}
+	return mock.ID(struct{}{}, out)
+}
+
+// PeerAdd fails to connect to TestPeerID2.
 func (mock *mockService) PeerAdd(in api.MultiaddrSerial, out *api.IDSerial) error {
+	if strings.HasSuffix(string(in), TestPeerID2.Pretty()) {
+		return api.NewError(400, "cannot connect to %s", TestPeerID2.Pretty())
+	}
+	id := api.IDSerial{}
+	mock.ID(struct{}{}, &id)
+	*out = id
+	return nil
+}
+
+func (mock *mockService) PeerAddForce(in api.MultiaddrSerial, out *api.IDSerial) error {
 	id := api.IDSerial{}
 	mock.ID(struct{}{}, &id)
 	*out = id
@@ -100,6 +453,24 @@ func (mock *mockService) PeerRemove(in peer.ID, out *struct{}) error {
 	return nil
 }
 
+func (mock *mockService) ReplacePeer(in api.MultiaddrsSerial, out *api.PeerReplacementSerial) error {
+	if len(in) != 2 {
+		return errors.New("expected the old and new peer multiaddresses")
+	}
+	return mock.PeerReplacement(struct{}{}, out)
+}
+
+func (mock *mockService) PeerReplacement(in struct{}, out *api.PeerReplacementSerial) error {
+	*out = api.PeerReplacementSerial{
+		OldPeer: TestPeerID1.Pretty(),
+		NewPeer: TestPeerID2.Pretty(),
+		Phase:   api.PeerReplacementPinning,
+		Total:   3,
+		Pinned:  1,
+	}
+	return nil
+}
+
 // FIXME: dup from util.go
 func globalPinInfoSliceToSerial(gpi []api.GlobalPinInfo) []api.GlobalPinInfoSerial {
 	gpis := make([]api.GlobalPinInfoSerial, len(gpi), len(gpi))
@@ -109,7 +480,7 @@ func globalPinInfoSliceToSerial(gpi []api.GlobalPinInfo) []api.GlobalPinInfoSeri
 	return gpis
 }
 
-func (mock *mockService) StatusAll(in struct{}, out *[]api.GlobalPinInfoSerial) error {
+func (mock *mockService) StatusAll(in string, out *[]api.GlobalPinInfoSerial) error {
 	c1, _ := cid.Decode(TestCid1)
 	c2, _ := cid.Decode(TestCid2)
 	c3, _ := cid.Decode(TestCid3)
@@ -135,6 +506,7 @@ func (mock *mockService) StatusAll(in struct{}, out *[]api.GlobalPinInfoSerial)
 					TS:     time.Now(),
 				},
 			},
+			Name: TestPinName,
 		},
 		{
 			Cid: c3,
@@ -151,6 +523,56 @@ func (mock *mockService) StatusAll(in struct{}, out *[]api.GlobalPinInfoSerial)
 	return nil
 }
 
+func (mock *mockService) StatusAllPage(in api.PageRequest, out *api.StatusPageSerial) error {
+	var gpis []api.GlobalPinInfoSerial
+	mock.StatusAll("", &gpis)
+	byCid := make(map[string]api.GlobalPinInfoSerial)
+	var cids []string
+	for _, gpi := range gpis {
+		if gpi.Cid > in.After {
+			byCid[gpi.Cid] = gpi
+			cids = append(cids, gpi.Cid)
+		}
+	}
+	sort.Strings(cids)
+	var page api.StatusPageSerial
+	for _, c := range cids {
+		if len(page.Items) == in.Limit {
+			break
+		}
+		page.Items = append(page.Items, byCid[c])
+	}
+	if len(cids) > in.Limit {
+		page.Next = page.Items[in.Limit-1].Cid
+	}
+	*out = page
+	return nil
+}
+
+func (mock *mockService) StatusChanges(in api.StatusChangesRequest, out *api.StatusChangesSerial) error {
+	var gpis []api.GlobalPinInfoSerial
+	mock.StatusAll("", &gpis)
+	if in.Token == "" {
+		*out = api.StatusChangesSerial{
+			Token:   "mock-1",
+			Changed: gpis,
+			Removed: []string{},
+		}
+		return nil
+	}
+	// TestCid3 was under TestNamespace
+	removed := []string{TestCid3}
+	if in.Scoped && in.Namespace != TestNamespace {
+		removed = []string{}
+	}
+	*out = api.StatusChangesSerial{
+		Token:   "mock-2",
+		Changed: gpis[:1],
+		Removed: removed,
+	}
+	return nil
+}
+
 func (mock *mockService) Status(in api.CidArgSerial, out *api.GlobalPinInfoSerial) error {
 	if in.Cid == ErrorCid {
 		return ErrBadCid
@@ -170,10 +592,45 @@ func (mock *mockService) Status(in api.CidArgSerial, out *api.GlobalPinInfoSeria
 	return nil
 }
 
-func (mock *mockService) SyncAll(in struct{}, out *[]api.GlobalPinInfoSerial) error {
+func (mock *mockService) StatusCids(in []string, out *[]api.GlobalPinInfoSerial) error {
+	gpis := make([]api.GlobalPinInfoSerial, 0, len(in))
+	for _, h := range in {
+		if h == ErrorCid {
+			return ErrBadCid
+		}
+		c, _ := cid.Decode(h)
+		st := api.TrackerStatus(api.TrackerStatusUnpinned)
+		if h == TestCid1 {
+			st = api.TrackerStatusPinned
+		}
+		gpis = append(gpis, api.GlobalPinInfo{
+			Cid: c,
+			PeerMap: map[peer.ID]api.PinInfo{
+				TestPeerID1: {
+					Cid:    c,
+					Peer:   TestPeerID1,
+					Status: st,
+					TS:     time.Now(),
+				},
+			},
+		}.ToSerial())
+	}
+	*out = gpis
+	return nil
+}
+
+func (mock *mockService) SyncAll(in string, out *[]api.GlobalPinInfoSerial) error {
 	return mock.StatusAll(in, out)
 }
 
+func (mock *mockService) RecoverAll(in string, out *[]api.GlobalPinInfoSerial) error {
+	return mock.StatusAll(in, out)
+}
+
+func (mock *mockService) CancelOp(in string, out *struct{}) error {
+	return nil
+}
+
 func (mock *mockService) Sync(in api.CidArgSerial, out *api.GlobalPinInfoSerial) error {
 	return mock.Status(in, out)
 }
@@ -187,6 +644,27 @@ func (mock *mockService) Recover(in api.CidArgSerial, out *api.GlobalPinInfoSeri
 	return mock.Status(in, out)
 }
 
+func (mock *mockService) Reallocate(in api.CidArgSerial, out *api.GlobalPinInfoSerial) error {
+	if len(in.Allocations) == 0 {
+		return errors.New("no peers to allocate to")
+	}
+	return mock.Status(in, out)
+}
+
+func (mock *mockService) Touch(in api.CidArgSerial, out *struct{}) error {
+	if in.Cid == ErrorCid {
+		return ErrBadCid
+	}
+	return nil
+}
+
+func (mock *mockService) AccessTimes(in struct{}, out *map[string]time.Time) error {
+	*out = map[string]time.Time{
+		TestCid1: time.Now(),
+	}
+	return nil
+}
+
 func (mock *mockService) Track(in api.CidArgSerial, out *struct{}) error {
 	return nil
 }
@@ -195,7 +673,82 @@ func (mock *mockService) Untrack(in api.CidArgSerial, out *struct{}) error {
 	return nil
 }
 
+func (mock *mockService) TrackerSetPinProgress(in api.PinInfoSerial, out *struct{}) error {
+	return nil
+}
+
+func (mock *mockService) TrackerLoad(in struct{}, out *api.TrackerLoad) error {
+	*out = api.TrackerLoad{
+		QueueLength:    5,
+		QueueCapacity:  10,
+		AvgPinDuration: time.Second,
+	}
+	return nil
+}
+
 func (mock *mockService) PeerManagerPeers(in struct{}, out *[]peer.ID) error {
 	*out = []peer.ID{TestPeerID1, TestPeerID2, TestPeerID3}
 	return nil
 }
+
+func (mock *mockService) IPFSPin(in api.CidArgSerial, out *struct{}) error {
+	switch in.Cid {
+	case ErrorCid:
+		return ErrBadCid
+	case SlowCid:
+		time.Sleep(SlowCidDelay)
+	}
+	return nil
+}
+
+// IPFSPinPresent fails with a 404 error for TestCid3, as if its blocks
+// were missing.
+func (mock *mockService) IPFSPinPresent(in api.CidArgSerial, out *struct{}) error {
+	switch in.Cid {
+	case ErrorCid:
+		return ErrBadCid
+	case TestCid3:
+		return api.NewError(404, "%s is not present in IPFS", in.Cid)
+	}
+	return nil
+}
+
+func (mock *mockService) IPFSUnpin(in api.CidArgSerial, out *struct{}) error {
+	switch in.Cid {
+	case ErrorCid:
+		return ErrBadCid
+	case SlowCid:
+		time.Sleep(SlowCidDelay)
+	}
+	return nil
+}
+
+func (mock *mockService) IPFSRepoStat(in struct{}, out *api.IPFSRepoStat) error {
+	*out = api.IPFSRepoStat{
+		RepoSize:   TestRepoSize,
+		StorageMax: TestStorageMax,
+	}
+	return nil
+}
+
+func (mock *mockService) IPFSBandwidthStats(in struct{}, out *api.IPFSBandwidth) error {
+	*out = api.IPFSBandwidth{
+		TotalIn:  TestBandwidthTotalIn,
+		TotalOut: TestBandwidthTotalOut,
+		RateIn:   TestBandwidthRateIn,
+		RateOut:  TestBandwidthRateOut,
+	}
+	return nil
+}
+
+func (mock *mockService) IPFSPinLsCid(in api.CidArgSerial, out *api.IPFSPinStatus) error {
+	switch in.Cid {
+	case ErrorCid:
+		return ErrBadCid
+	case TestCid3:
+		*out = api.IPFSPinStatusUnpinned
+	default:
+		*out = api.IPFSPinStatusRecursive
+	}
+	return nil
+}
@@ -5,6 +5,9 @@ import (
 	"testing"
 
 	ipfscluster "github.com/ipfs/ipfs-cluster"
+	"github.com/ipfs/ipfs-cluster/api"
+
+	peer "github.com/libp2p/go-libp2p-peer"
 )
 
 func TestIpfsMock(t *testing.T) {
@@ -60,3 +63,22 @@ func TestRPCMockValid(t *testing.T) {
 		}
 	}
 }
+
+func TestMockAllocator(t *testing.T) {
+	var alloc ipfscluster.PinAllocator = NewMockAllocator(TestPeerID3, TestPeerID1)
+	candidates := map[peer.ID]api.Metric{
+		TestPeerID1: {},
+		TestPeerID2: {},
+		TestPeerID3: {},
+	}
+	peers, err := alloc.Allocate(nil, nil, candidates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 3 ||
+		peers[0] != TestPeerID3 ||
+		peers[1] != TestPeerID1 ||
+		peers[2] != TestPeerID2 {
+		t.Error("unexpected allocation order: ", peers)
+	}
+}
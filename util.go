@@ -3,14 +3,54 @@ package ipfscluster
 import (
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/ipfs/ipfs-cluster/api"
 
+	cid "github.com/ipfs/go-cid"
 	host "github.com/libp2p/go-libp2p-host"
 	peer "github.com/libp2p/go-libp2p-peer"
 	ma "github.com/multiformats/go-multiaddr"
 )
 
+// errRPCNotReady is returned by components which are asked to perform
+// an operation requiring RPC before their SetClient() has been called.
+var errRPCNotReady = errors.New("component not ready: RPC client not set")
+
+// RPCReadyTimeout is how long the operations which need RPC wait for
+// the RPC client of a component to be set, when they are requested
+// before it, until they fail with errRPCNotReady.
+var RPCReadyTimeout = 5 * time.Second
+
+// waitForRPC waits until the given rpcReady channel is closed, which
+// components do in SetClient() once the RPC client is set. It returns
+// errRPCNotReady if that does not happen within RPCReadyTimeout or
+// when done is closed first.
+func waitForRPC(rpcReady, done <-chan struct{}) error {
+	select {
+	case <-rpcReady:
+		return nil
+	default:
+	}
+
+	timer := time.NewTimer(RPCReadyTimeout)
+	defer timer.Stop()
+	select {
+	case <-rpcReady:
+		return nil
+	case <-done:
+		return errRPCNotReady
+	case <-timer.C:
+		return errRPCNotReady
+	}
+}
+
+// errNotPinned returns the error for operations which need a Cid to
+// be in the shared state when it is not.
+func errNotPinned(h *cid.Cid) error {
+	return api.NewError(404, "%s is not pinned", h)
+}
+
 // The copy functions below are used in calls to Cluste.multiRPC()
 // func copyPIDsToIfaces(in []peer.ID) []interface{} {
 // 	ifaces := make([]interface{}, len(in), len(in))
@@ -139,6 +179,20 @@ func globalPinInfoSliceToSerial(gpi []api.GlobalPinInfo) []api.GlobalPinInfoSeri
 	return gpis
 }
 
+// decodeCids decodes a list of Cids, failing with a 400 error on the
+// first one which is not valid.
+func decodeCids(hashes []string) ([]*cid.Cid, error) {
+	cids := make([]*cid.Cid, len(hashes), len(hashes))
+	for i, h := range hashes {
+		c, err := cid.Decode(h)
+		if err != nil {
+			return nil, api.NewError(400, "error decoding Cid %s: %s", h, err)
+		}
+		cids[i] = c
+	}
+	return cids, nil
+}
+
 func logError(fmtstr string, args ...interface{}) error {
 	msg := fmt.Sprintf(fmtstr, args...)
 	logger.Error(msg)
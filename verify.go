@@ -0,0 +1,85 @@
+package ipfscluster
+
+import (
+	"errors"
+
+	"github.com/ipfs/ipfs-cluster/api"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// VerifyConcurrency is the maximum number of Verify operations that a
+// peer runs at the same time. Verifying reads the whole DAG from the
+// IPFS datastore, so additional requests are rejected rather than
+// queued.
+var VerifyConcurrency = 1
+
+var errVerifyBusy = errors.New("too many verifications in progress on this peer, try again later")
+
+// Verify asks every peer allocated to the given Cid to read all the
+// blocks in its DAG, and returns the result for each of them. This
+// detects missing or corrupted blocks which a plain "pin ls" does not.
+// Verification is expensive: each peer only runs VerifyConcurrency
+// operations at a time and reports an error for the rest.
+func (c *Cluster) Verify(h *cid.Cid) ([]api.VerifyResult, error) {
+	cState, err := c.consensus.State()
+	if err != nil {
+		return nil, err
+	}
+	if !cState.Has(h) {
+		return nil, errNotPinned(h)
+	}
+
+	carg := cState.Get(h)
+	dests := carg.Allocations
+	if carg.Everywhere {
+		dests = c.peerManager.peers()
+	}
+
+	replies := make([]api.VerifyResultSerial, len(dests), len(dests))
+	ifaces := make([]interface{}, len(dests), len(dests))
+	for i := range replies {
+		ifaces[i] = &replies[i]
+	}
+	errs := c.multiRPC(c.ctx, dests, "Cluster", "VerifyLocal",
+		api.CidArgCid(h).ToSerial(), ifaces)
+
+	results := make([]api.VerifyResult, len(dests), len(dests))
+	for i, r := range replies {
+		if errs[i] != nil {
+			results[i] = api.VerifyResult{
+				Peer:  dests[i],
+				Error: errs[i].Error(),
+			}
+			continue
+		}
+		results[i] = r.ToVerifyResult()
+	}
+	return results, nil
+}
+
+// VerifyLocal checks that all the blocks of the given Cid can be read
+// from the IPFS daemon of this peer.
+func (c *Cluster) VerifyLocal(h *cid.Cid) api.VerifyResult {
+	res := api.VerifyResult{
+		Peer: c.id,
+	}
+
+	select {
+	case c.verifySem <- struct{}{}:
+		defer func() { <-c.verifySem }()
+	default:
+		res.Error = errVerifyBusy.Error()
+		return res
+	}
+
+	logger.Info("verifying:", h)
+	err := c.ipfs.Verify(h)
+	if err != nil {
+		logger.Errorf("verification of %s failed: %s", h, err)
+		res.Error = err.Error()
+		return res
+	}
+	res.Valid = true
+	return res
+}
@@ -0,0 +1,38 @@
+package ipfscluster
+
+import (
+	"testing"
+
+	"github.com/ipfs/ipfs-cluster/test"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+func TestClusterVerifyLocal(t *testing.T) {
+	ipfs := &mockConnector{}
+	cl := &Cluster{
+		id:        test.TestPeerID1,
+		ipfs:      ipfs,
+		verifySem: make(chan struct{}, 1),
+	}
+	c, _ := cid.Decode(test.TestCid1)
+
+	res := cl.VerifyLocal(c)
+	if !res.Valid || res.Peer != test.TestPeerID1 {
+		t.Error("expected a valid result:", res)
+	}
+
+	ipfs.returnError = true
+	res = cl.VerifyLocal(c)
+	if res.Valid {
+		t.Error("expected an invalid result")
+	}
+	ipfs.returnError = false
+
+	// a verification is already running
+	cl.verifySem <- struct{}{}
+	res = cl.VerifyLocal(c)
+	if res.Valid || res.Error != errVerifyBusy.Error() {
+		t.Error("expected a busy error:", res)
+	}
+}
@@ -0,0 +1,105 @@
+package ipfscluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ipfs/ipfs-cluster/api"
+)
+
+// Pin error webhook settings
+var (
+	// maximum number of notifications waiting to be delivered
+	WebhookQueueSize = 256
+	// how many times a notification is retried before dropping it
+	WebhookRetries = 3
+	// how long to wait between retries
+	WebhookRetryDelay = 5 * time.Second
+	// maximum duration of a single webhook request
+	WebhookTimeout = 10 * time.Second
+)
+
+// webhook delivers notifications about failed pins and unpins by
+// POSTing the PinInfo (in its serial form) to a URL. Notifications are
+// queued and delivered in the background, with retries, so that the
+// PinTracker is never blocked. When the queue is full, or all the
+// retries fail, the notification is dropped and logged.
+type webhook struct {
+	url    string
+	client *http.Client
+	queue  chan api.PinInfoSerial
+}
+
+func newWebhook(url string) *webhook {
+	return &webhook{
+		url: url,
+		client: &http.Client{
+			Timeout: WebhookTimeout,
+		},
+		queue: make(chan api.PinInfoSerial, WebhookQueueSize),
+	}
+}
+
+// notify queues a notification for the given PinInfo.
+func (wh *webhook) notify(pinfo api.PinInfo) {
+	select {
+	case wh.queue <- pinfo.ToSerial():
+	default:
+		logger.Errorf("webhook queue is full: dropping notification for %s", pinfo.Cid)
+	}
+}
+
+// run delivers the queued notifications until the context is cancelled.
+func (wh *webhook) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case pinfo := <-wh.queue:
+			wh.deliver(ctx, pinfo)
+		}
+	}
+}
+
+func (wh *webhook) deliver(ctx context.Context, pinfo api.PinInfoSerial) {
+	body, err := json.Marshal(pinfo)
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+
+	for i := 0; i <= WebhookRetries; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(WebhookRetryDelay):
+			}
+		}
+
+		err = wh.post(body)
+		if err == nil {
+			logger.Debugf("webhook notified for %s", pinfo.Cid)
+			return
+		}
+		logger.Warningf("error notifying webhook for %s (attempt %d): %s",
+			pinfo.Cid, i+1, err)
+	}
+	logger.Errorf("giving up notifying webhook for %s", pinfo.Cid)
+}
+
+func (wh *webhook) post(body []byte) error {
+	resp, err := wh.client.Post(wh.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package ipfscluster
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/test"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// webhookReceiver records the notifications it receives, failing
+// the first fail requests.
+type webhookReceiver struct {
+	mux      sync.Mutex
+	fail     int
+	attempts int
+	received []api.PinInfoSerial
+}
+
+func (wr *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	wr.mux.Lock()
+	defer wr.mux.Unlock()
+	wr.attempts++
+	if wr.attempts <= wr.fail {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var pinfo api.PinInfoSerial
+	json.NewDecoder(r.Body).Decode(&pinfo)
+	wr.received = append(wr.received, pinfo)
+}
+
+func (wr *webhookReceiver) get() (int, []api.PinInfoSerial) {
+	wr.mux.Lock()
+	defer wr.mux.Unlock()
+	return wr.attempts, wr.received
+}
+
+func TestMapPinTrackerWebhook(t *testing.T) {
+	delay := WebhookRetryDelay
+	WebhookRetryDelay = 10 * time.Millisecond
+	defer func() { WebhookRetryDelay = delay }()
+
+	wr := &webhookReceiver{fail: 2}
+	srv := httptest.NewServer(wr)
+	defer srv.Close()
+
+	cfg := testingConfig()
+	cfg.PinErrorWebhook = srv.URL
+	mpt := NewMapPinTracker(cfg)
+	defer mpt.Shutdown()
+
+	c, _ := cid.Decode(test.TestCid1)
+	mpt.set(c, api.TrackerStatusPinning)
+	mpt.setError(c, errors.New("pin failed"))
+	// already in error: no new notification
+	mpt.setError(c, errors.New("pin failed again"))
+
+	time.Sleep(200 * time.Millisecond)
+	attempts, received := wr.get()
+	if attempts != 3 {
+		t.Error("expected 2 failed attempts and a successful one, got", attempts)
+	}
+	if len(received) != 1 {
+		t.Fatal("expected a single notification, got", len(received))
+	}
+	n := received[0]
+	if n.Cid != test.TestCid1 || n.Status != "pin_error" ||
+		n.Error != "pin failed" || n.Peer != cfg.ID.Pretty() || n.TS == "" {
+		t.Errorf("unexpected notification: %+v", n)
+	}
+}